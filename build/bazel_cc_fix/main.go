@@ -36,11 +36,16 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/mattn/go-shellwords"
+	"github.com/pmezard/go-difflib/difflib"
 	"google.golang.org/protobuf/encoding/prototext"
 
 	"source.monogon.dev/build/bazel_cc_fix/ccfixspec"
@@ -77,30 +82,210 @@ func (r rewrites) replacer() *strings.Replacer {
 	return strings.NewReplacer(replacerArgs...)
 }
 
-// addWorkspace adds a rewrite from a given directive to a workspace-relative path.
-func (r rewrites) addWorkspace(oldDirective, workspaceRelativePath string) {
-	normalizedDirective := strings.TrimSpace(oldDirective)
-	replacementDirective := fmt.Sprintf("#include \"%s\"", workspaceRelativePath)
-	oldRewrite, ok := r[normalizedDirective]
+// Type rewriteMetadata maps file paths to rewrite metadata for that file. It
+// is safe for concurrent use.
+type rewriteMetadata struct {
+	mu    sync.Mutex
+	files map[string]*rewriteMetadataFile
+}
+
+func newRewriteMetadata() *rewriteMetadata {
+	return &rewriteMetadata{
+		files: make(map[string]*rewriteMetadataFile),
+	}
+}
+
+// file returns the rewrite metadata of the file at the given path, reading the
+// file on first use. It returns nil if the file could not be read.
+func (m *rewriteMetadata) file(filePath string) *rewriteMetadataFile {
+	m.mu.Lock()
+	meta, ok := m.files[filePath]
 	if !ok {
-		r[normalizedDirective] = replacementDirective
-	} else if oldRewrite != replacementDirective {
-		log.Printf("WARNING: inconsistent rewrite detected: %s => %s | %s", normalizedDirective, oldRewrite, replacementDirective)
+		meta = &rewriteMetadataFile{}
+		m.files[filePath] = meta
+	}
+	m.mu.Unlock()
+
+	// Read the file outside of the global lock, concurrent users of the same
+	// file wait for the first one to finish reading it.
+	meta.load.Do(func() {
+		cSourceRaw, err := os.ReadFile(filePath)
+		if err != nil {
+			log.Printf("failed to open source file: %v", err)
+			meta.failed = true
+			return
+		}
+		meta.source = string(cSourceRaw)
+		meta.directives = findIncludeDirectives(meta.source)
+		meta.rewrites = make(rewrites)
+		meta.rewriteEntries = make(map[string]int)
+	})
+	if meta.failed {
+		return nil
 	}
+	return meta
 }
 
-// Type rewriteMetadata is a map of a file path to rewrite metadata for that file
-type rewriteMetadata map[string]rewriteMetadataFile
+// sortedFiles returns the paths of all successfully read files in sorted
+// order.
+func (m *rewriteMetadata) sortedFiles() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := make([]string, 0, len(m.files))
+	for file, meta := range m.files {
+		if meta.failed {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
 
 type rewriteMetadataFile struct {
+	// load guards reading the file, all fields other than mu and the ones
+	// guarded by it are immutable afterwards.
+	load sync.Once
+	// failed is set if reading the file failed.
+	failed bool
+	source string
+	// directives contains the start and end offsets in source of all include
+	// directives which are not part of a comment or string literal.
+	directives [][]int
+
+	// mu guards rewrites and rewriteEntries.
+	mu       sync.Mutex
 	rewrites rewrites
-	source   string
+	// rewriteEntries contains the index of the compilation database entry
+	// which recorded each rewrite.
+	rewriteEntries map[string]int
+}
+
+// addWorkspace adds a rewrite from a given directive to a workspace-relative
+// path, recorded while processing the given compilation database entry. The
+// replacement directive uses angle brackets if angle is set and quotes
+// otherwise. If different rewrites are recorded for the same directive, the one
+// recorded by the first entry in the compilation database wins, independently
+// of the order in which entries are processed.
+func (f *rewriteMetadataFile) addWorkspace(entry int, oldDirective, workspaceRelativePath string, angle bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	normalizedDirective := strings.TrimSpace(oldDirective)
+	replacementDirective := fmt.Sprintf("#include \"%s\"", workspaceRelativePath)
+	if angle {
+		replacementDirective = fmt.Sprintf("#include <%s>", workspaceRelativePath)
+	}
+	oldRewrite, ok := f.rewrites[normalizedDirective]
+	if ok && oldRewrite != replacementDirective {
+		log.Printf("WARNING: inconsistent rewrite detected: %s => %s | %s", normalizedDirective, oldRewrite, replacementDirective)
+	}
+	if !ok || entry < f.rewriteEntries[normalizedDirective] {
+		f.rewrites[normalizedDirective] = replacementDirective
+		f.rewriteEntries[normalizedDirective] = entry
+	}
+}
+
+// rewritten returns the source of the file with all rewrites applied to its
+// include directives.
+func (f *rewriteMetadataFile) rewritten() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	replacer := f.rewrites.replacer()
+	var out strings.Builder
+	last := 0
+	for _, d := range f.directives {
+		out.WriteString(f.source[last:d[0]])
+		out.WriteString(replacer.Replace(f.source[d[0]:d[1]]))
+		last = d[1]
+	}
+	out.WriteString(f.source[last:])
+	return out.String()
+}
+
+// workspaceRelative returns the given path relative to the workspace if it is
+// inside the workspace, otherwise it returns the path unmodified.
+func workspaceRelative(path string) string {
+	if rel, err := filepath.Rel(*workspacePath, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// diff returns a unified diff between the original and the rewritten source
+// of the file at the given path, or an empty string if the file is unchanged.
+// Paths in the diff are relative to the workspace if possible.
+func (f *rewriteMetadataFile) diff(path string) (string, error) {
+	rewritten := f.rewritten()
+	if rewritten == f.source {
+		return "", nil
+	}
+	name := workspaceRelative(path)
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(f.source),
+		B:        difflib.SplitLines(rewritten),
+		FromFile: "a/" + name,
+		ToFile:   "b/" + name,
+		Context:  3,
+	})
+}
+
+// reportRewrite is a single include directive rewrite in a report.
+type reportRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// reportFile contains all rewrites recorded for a single file in a report.
+type reportFile struct {
+	Rewrites []reportRewrite `json:"rewrites"`
+	// Modified is true if applying the rewrites changes the file.
+	Modified bool `json:"modified"`
+}
+
+// writeReport writes a JSON report of all rewrites in m to the given path.
+// The report maps workspace-relative file paths to their rewrites. Both the
+// files and their rewrites are sorted to make the report deterministic.
+func writeReport(path string, m *rewriteMetadata) error {
+	report := make(map[string]reportFile)
+	for _, file := range m.sortedFiles() {
+		meta := m.file(file)
+		rf := reportFile{
+			Rewrites: []reportRewrite{},
+			Modified: meta.rewritten() != meta.source,
+		}
+		for from, to := range meta.rewrites {
+			rf.Rewrites = append(rf.Rewrites, reportRewrite{From: from, To: to})
+		}
+		sort.Slice(rf.Rewrites, func(i, j int) bool {
+			return rf.Rewrites[i].From < rf.Rewrites[j].From
+		})
+		report[workspaceRelative(file)] = rf
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// encoding/json sorts map keys. Don't escape the angle brackets of system
+	// includes to keep the report readable.
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 var (
-	compilationDBPath = flag.String("compilation_db", "", "Path the the compilation_database.json file for the project")
-	workspacePath     = flag.String("workspace", "", "Path to the workspace root")
-	specPath          = flag.String("spec", "", "Path to the spec (ccfixspec.CCFixSpec)")
+	compilationDBPath    = flag.String("compilation_db", "", "Path the the compilation_database.json file for the project")
+	workspacePath        = flag.String("workspace", "", "Path to the workspace root")
+	specPath             = flag.String("spec", "", "Path to the spec (ccfixspec.CCFixSpec)")
+	dryRun               = flag.Bool("dry_run", false, "Print a unified diff of all changes to stdout instead of rewriting files. Exits with a non-zero status if any file would change.")
+	reportPath           = flag.String("report", "", "If set, write a JSON report of all rewrites to this path")
+	preserveBracketStyle = flag.Bool("preserve_bracket_style", false, "Rewrite angle includes to workspace-relative angle includes instead of quote includes")
+	backupSuffix         = flag.String("backup_suffix", "", "If set, back up every modified file to its path with this suffix appended (eg. .orig) before rewriting it")
+	restore              = flag.Bool("restore", false, "Restore all files from the backups written by the last run with -backup_suffix instead of rewriting them")
 )
 
 var (
@@ -113,14 +298,130 @@ var (
 	reIncludeDirective = regexp.MustCompile(`(?m:^\s*#\s*include\s*([<"])(.*)([>"]))`)
 )
 
+// isIdentifierChar returns true if c can be part of a C identifier.
+func isIdentifierChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// isLiteralPrefix contains all valid encoding prefixes of string and
+// character literals.
+var isLiteralPrefix = map[string]bool{
+	"":   true,
+	"u8": true,
+	"u":  true,
+	"U":  true,
+	"L":  true,
+}
+
+// isRawStringPrefix returns true if ident is a raw string literal prefix.
+func isRawStringPrefix(ident string) bool {
+	return strings.HasSuffix(ident, "R") && isLiteralPrefix[strings.TrimSuffix(ident, "R")]
+}
+
+// trailingIdentifier returns the identifier (or number) at the end of source.
+func trailingIdentifier(source string) string {
+	start := len(source)
+	for start > 0 && isIdentifierChar(source[start-1]) {
+		start--
+	}
+	return source[start:]
+}
+
+// findDirectives returns the offsets of all '#' characters in source which
+// start a preprocessor directive. It performs a minimal tokenization of the
+// source to skip over comments as well as string, character and raw string
+// literals.
+func findDirectives(source string) map[int]bool {
+	directives := make(map[int]bool)
+	// lineStart is true if only whitespace or comments were encountered since
+	// the start of the current line.
+	lineStart := true
+	for i := 0; i < len(source); i++ {
+		c := source[i]
+		switch {
+		case c == '\\' && i+1 < len(source) && source[i+1] == '\n':
+			// Line continuation
+			i++
+		case c == '\n':
+			lineStart = true
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+		case strings.HasPrefix(source[i:], "//"):
+			// Line comments end at the next newline which is not escaped.
+			for i+1 < len(source) && source[i+1] != '\n' {
+				if source[i+1] == '\\' {
+					i++
+				}
+				i++
+			}
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end == -1 {
+				return directives
+			}
+			i += 2 + end + 1
+		case c == '"' && isRawStringPrefix(trailingIdentifier(source[:i])):
+			// Raw string literal R"delim(...)delim"
+			lineStart = false
+			open := strings.IndexByte(source[i:], '(')
+			if open == -1 {
+				return directives
+			}
+			delim := ")" + source[i+1:i+open] + "\""
+			end := strings.Index(source[i+open:], delim)
+			if end == -1 {
+				return directives
+			}
+			i += open + end + len(delim) - 1
+		case c == '"' || (c == '\'' && isLiteralPrefix[trailingIdentifier(source[:i])]):
+			// String and character literals end at the next unescaped
+			// quote of the same kind or, if unterminated, at the end of the
+			// line. The latter keeps apostrophes in directives like #error
+			// from swallowing the rest of the file. Apostrophes following
+			// identifiers or numbers other than encoding prefixes are digit
+			// separators (1'000) and are ignored.
+			lineStart = false
+			for i+1 < len(source) && source[i+1] != '\n' {
+				i++
+				if source[i] == '\\' {
+					i++
+				} else if source[i] == c {
+					break
+				}
+			}
+		case c == '#' && lineStart:
+			directives[i] = true
+			lineStart = false
+		default:
+			lineStart = false
+		}
+	}
+	return directives
+}
+
+// findIncludeDirectives returns the start and end offsets of all include
+// directives in source which are not part of a comment or string literal.
+func findIncludeDirectives(source string) [][]int {
+	directives := findDirectives(source)
+	var out [][]int
+	for _, m := range reIncludeDirective.FindAllStringSubmatchIndex(source, -1) {
+		// The match can start with whitespace, check the position of the
+		// actual '#'.
+		if directives[m[0]+strings.IndexByte(source[m[0]:m[1]], '#')] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
 // applyReplaceDirectives applies all directives of the given replaceType in
 // directives to originalPath and returns the resulting string. If
 // returnUnmodified is unset, it returns an empty string when no replacements
 // were performed, otherwise it returns the unmodified originalPath.
-// The first rewrite wins, it does not do any recursive processing.
+// The first rewrite wins, it does not do any recursive processing. Exact and
+// prefix matches take priority over glob matches.
 func applyReplaceDirectives(directives []*ccfixspec.Replace, replaceType ccfixspec.Replace_Type, originalPath string, returnUnmodified bool) string {
 	for _, d := range directives {
-		if d.Type != replaceType {
+		if d.Type != replaceType || isGlob(d.From) {
 			continue
 		}
 		if d.From == originalPath {
@@ -129,12 +430,56 @@ func applyReplaceDirectives(directives []*ccfixspec.Replace, replaceType ccfixsp
 			return d.To + strings.TrimPrefix(originalPath, d.From)
 		}
 	}
+	for _, d := range directives {
+		if d.Type != replaceType || !isGlob(d.From) {
+			continue
+		}
+		if tail, ok := matchGlob(d.From, originalPath); ok {
+			return d.To + tail
+		}
+	}
 	if returnUnmodified {
 		return originalPath
 	}
 	return ""
 }
 
+// isGlob returns true if the From path of a replace directive is a glob
+// pattern.
+func isGlob(from string) bool {
+	return strings.Contains(from, "*")
+}
+
+// matchGlob matches a path against a glob pattern with path.Match semantics.
+// Like normal replace directives, patterns ending in a slash match all paths
+// in matching directories, in which case the unmatched tail of the path is
+// returned. Otherwise the pattern needs to match the whole path, and the file
+// name is returned as the tail if it is matched by a wildcard in the last
+// element of the pattern.
+func matchGlob(pattern, originalPath string) (tail string, ok bool) {
+	if !strings.HasSuffix(pattern, "/") {
+		if matched, _ := path.Match(pattern, originalPath); !matched {
+			return "", false
+		}
+		if isGlob(path.Base(pattern)) {
+			return path.Base(originalPath), true
+		}
+		return "", true
+	}
+	// As wildcards never match a slash, the pattern can only match the
+	// leading path components.
+	n := strings.Count(pattern, "/")
+	parts := strings.SplitAfterN(originalPath, "/", n+1)
+	if len(parts) <= n {
+		return "", false
+	}
+	prefix := strings.Join(parts[:n], "")
+	if matched, _ := path.Match(pattern, prefix); !matched {
+		return "", false
+	}
+	return parts[n], true
+}
+
 // findFileInWorkspace takes a path from a C include directive and uses the
 // given search path to find its absolute path. If that absolute path is
 // outside the workspace, it returns an empty string, otherwise it returns the
@@ -174,29 +519,18 @@ func findFileInWorkspace(searchPath []string, inclFile string, isGeneratedFile m
 
 // fixIncludesAndGetRefs opens a file, looks at all its includes, records
 // rewriting data into rewriteMetadata and returns all files included by the
-// file for further analysis.
-func (m rewriteMetadata) fixIncludesAndGetRefs(filePath string, quoteIncludes, systemIncludes []string, spec *ccfixspec.CCFixSpec, isGeneratedFile map[string]bool) []string {
-	meta, ok := m[filePath]
-	if !ok {
-		cSourceRaw, err := os.ReadFile(filePath)
-		if err != nil {
-			log.Printf("failed to open source file: %v", err)
-			return nil
-		}
-		cSource := string(cSourceRaw)
-		m[filePath] = rewriteMetadataFile{
-			rewrites: make(rewrites),
-			source:   cSource,
-		}
-		meta = m[filePath]
+// file for further analysis. entry is the index of the compilation database
+// entry being processed.
+func (m *rewriteMetadata) fixIncludesAndGetRefs(entry int, filePath string, quoteIncludes, systemIncludes []string, spec *ccfixspec.CCFixSpec, isGeneratedFile map[string]bool) []string {
+	meta := m.file(filePath)
+	if meta == nil {
+		return nil
 	}
 	var includeFiles []string
-	// Find all include directives
-	out := reIncludeDirective.FindAllStringSubmatch(meta.source, -1)
-	for _, incl := range out {
-		inclDirective := incl[0]
-		inclType := incl[1]
-		inclFile := incl[2]
+	for _, d := range meta.directives {
+		inclDirective := meta.source[d[0]:d[1]]
+		inclType := meta.source[d[2]:d[3]]
+		inclFile := meta.source[d[4]:d[5]]
 		var workspaceRelativeFilePath string
 		var searchPath []string
 		if inclType == "\"" {
@@ -226,23 +560,66 @@ func (m rewriteMetadata) fixIncludesAndGetRefs(filePath string, quoteIncludes, s
 			foundGenerated = true
 		}
 
+		// Keep angle includes as such if requested.
+		angle := inclType == "<" && *preserveBracketStyle
+
 		// Shorten include paths when both files are in the same directory
 		// except when a generated file is involved as these end up in
 		// physically different locations and need to be referenced using a
-		// full workspace- relative path
-		if !foundGenerated && filepath.Dir(filePath) == filepath.Dir(filepath.Join(*workspacePath, workspaceRelativeFilePath)) {
+		// full workspace- relative path. Angle includes are not looked up
+		// relative to the including file, so they are never shortened.
+		if !foundGenerated && !angle && filepath.Dir(filePath) == filepath.Dir(filepath.Join(*workspacePath, workspaceRelativeFilePath)) {
 			workspaceRelativeFilePath = filepath.Base(workspaceRelativeFilePath)
 		}
 		// Don't perform rewrites when both include directives are semantically
 		// equivalent
-		if workspaceRelativeFilePath == inclFile && inclType == "\"" {
+		if workspaceRelativeFilePath == inclFile && (inclType == "\"" || angle) {
 			continue
 		}
-		meta.rewrites.addWorkspace(inclDirective, workspaceRelativeFilePath)
+		meta.addWorkspace(entry, inclDirective, workspaceRelativeFilePath, angle)
 	}
 	return includeFiles
 }
 
+// maxResponseFileDepth is the maximum nesting depth of response files
+// referencing other response files.
+const maxResponseFileDepth = 10
+
+// expandResponseFiles replaces all arguments referencing a response file
+// (@file) with the arguments contained in that file. Relative paths are
+// resolved against dir. Response files referenced from within response files
+// are expanded recursively up to maxResponseFileDepth.
+func expandResponseFiles(args []string, dir string, depth int) ([]string, error) {
+	var out []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") || len(arg) == 1 {
+			out = append(out, arg)
+			continue
+		}
+		if depth >= maxResponseFileDepth {
+			return nil, fmt.Errorf("response files nested deeper than %d levels", maxResponseFileDepth)
+		}
+		rspPath := arg[1:]
+		if !filepath.IsAbs(rspPath) {
+			rspPath = filepath.Join(dir, rspPath)
+		}
+		rspRaw, err := os.ReadFile(rspPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response file: %w", err)
+		}
+		rspArgs, err := shellwords.Parse(string(rspRaw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse response file %q: %w", rspPath, err)
+		}
+		rspArgs, err = expandResponseFiles(rspArgs, dir, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rspArgs...)
+	}
+	return out, nil
+}
+
 // getIncludeDirs takes a compilation database entry and returns the search
 // paths for both system and quote includes
 func getIncludeDirs(entry compilationDBEntry) (quoteIncludes []string, systemIncludes []string, err error) {
@@ -254,6 +631,11 @@ func getIncludeDirs(entry compilationDBEntry) (quoteIncludes []string, systemInc
 		}
 		entry.Arguments = commandArgs
 	}
+	args, err := expandResponseFiles(entry.Arguments, entry.Directory, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to expand response files: %w", err)
+	}
+	entry.Arguments = args
 
 	// Parse out and generate include search paths
 	var preSystemIncludes []string
@@ -317,8 +699,227 @@ func getIncludeDirs(entry compilationDBEntry) (quoteIncludes []string, systemInc
 	return
 }
 
+// processEntry analyzes a single source file from the compilation database
+// and all files it transitively includes. i is the index of the entry in the
+// compilation database.
+func (m *rewriteMetadata) processEntry(i int, entry compilationDBEntry, spec *ccfixspec.CCFixSpec, isGeneratedFile map[string]bool) {
+	quoteIncludes, systemIncludes, err := getIncludeDirs(entry)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	filePath := entry.File
+	if !filepath.IsAbs(entry.File) {
+		filePath = filepath.Join(entry.Directory, entry.File)
+	}
+	includedFiles := m.fixIncludesAndGetRefs(i, filePath, quoteIncludes, systemIncludes, spec, isGeneratedFile)
+
+	// seen stores the path of already-visited files, similar to #pragma once
+	seen := make(map[string]bool)
+	// rec recursively resolves includes and records rewrites
+	var rec func([]string)
+	rec = func(files []string) {
+		for _, f := range files {
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			icf2 := m.fixIncludesAndGetRefs(i, f, quoteIncludes, systemIncludes, spec, isGeneratedFile)
+			rec(icf2)
+		}
+	}
+	rec(includedFiles)
+}
+
+// processCompilationDB analyzes all source files in the compilation database
+// using the given number of concurrent workers. The recorded rewrites do not
+// depend on the number of workers.
+func (m *rewriteMetadata) processCompilationDB(db compilationDB, spec *ccfixspec.CCFixSpec, isGeneratedFile map[string]bool, workers int) {
+	entries := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range entries {
+				m.processEntry(i, db[i], spec, isGeneratedFile)
+			}
+		}()
+	}
+	for i := range db {
+		entries <- i
+	}
+	close(entries)
+	wg.Wait()
+}
+
+// backupManifestName is the name of the file in the workspace root which
+// lists all backups written by the last run.
+const backupManifestName = ".bazel_cc_fix_backups.json"
+
+// backupManifestEntry is a single backup in the backup manifest.
+type backupManifestEntry struct {
+	Original string `json:"original"`
+	Backup   string `json:"backup"`
+}
+
+// backupManifestPath returns the path of the backup manifest of the workspace.
+func backupManifestPath() string {
+	return filepath.Join(*workspacePath, backupManifestName)
+}
+
+// writeBackupManifest writes entries to the backup manifest at path, or
+// removes the manifest if there are no entries.
+func writeBackupManifest(path string, entries []backupManifestEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// writeBackups writes a copy of the original source of every given file which
+// is modified by its rewrites to the path of the file with suffix appended,
+// and records all of them in the backup manifest. Existing backups are never
+// overwritten, as on a repeated run they hold the only copy of the original
+// source. If any backup or the manifest already exists, no backup is written
+// and an error is returned.
+func writeBackups(m *rewriteMetadata, files []string, suffix string) error {
+	manifestPath := backupManifestPath()
+	if _, err := os.Lstat(manifestPath); err == nil {
+		return fmt.Errorf("backup manifest %v already exists, restore backups first", manifestPath)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat backup manifest %v: %w", manifestPath, err)
+	}
+	var modified []string
+	for _, file := range files {
+		rew := m.file(file)
+		if rew.rewritten() == rew.source {
+			continue
+		}
+		if _, err := os.Lstat(file + suffix); err == nil {
+			return fmt.Errorf("backup %v already exists, restore or remove it first", file+suffix)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to stat backup %v: %w", file+suffix, err)
+		}
+		modified = append(modified, file)
+	}
+	var written []backupManifestEntry
+	// removeWritten removes all backups written so far. As no file has been
+	// rewritten yet, they are not needed anymore.
+	removeWritten := func() {
+		for _, e := range written {
+			os.Remove(e.Backup)
+		}
+	}
+	for _, file := range modified {
+		rew := m.file(file)
+		info, err := os.Stat(file)
+		if err != nil {
+			removeWritten()
+			return fmt.Errorf("failed to stat file %v: %w", file, err)
+		}
+		// O_EXCL guards against a backup which appeared since the check above.
+		f, err := os.OpenFile(file+suffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			removeWritten()
+			return fmt.Errorf("failed to create backup of file %v: %w", file, err)
+		}
+		written = append(written, backupManifestEntry{Original: file, Backup: file + suffix})
+		if _, err := f.WriteString(rew.source); err != nil {
+			f.Close()
+			removeWritten()
+			return fmt.Errorf("failed to write backup of file %v: %w", file, err)
+		}
+		if err := f.Close(); err != nil {
+			removeWritten()
+			return fmt.Errorf("failed to close backup of file %v: %w", file, err)
+		}
+	}
+	if err := writeBackupManifest(manifestPath, written); err != nil {
+		removeWritten()
+		return fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+	return nil
+}
+
+// writeRewrites overwrites the given files with their rewritten source. If
+// backupSuffix is set, backups of all modified files are written first and no
+// file is rewritten if any of them fails.
+func writeRewrites(m *rewriteMetadata, files []string, backupSuffix string) error {
+	if backupSuffix != "" {
+		if err := writeBackups(m, files, backupSuffix); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		rew := m.file(file)
+		outFile, err := os.Create(file)
+		if err != nil {
+			return fmt.Errorf("failed to open file for writing output: %w", err)
+		}
+		if _, err := outFile.WriteString(rew.rewritten()); err != nil {
+			outFile.Close()
+			return fmt.Errorf("failed to write file %v: %w", file, err)
+		}
+		if err := outFile.Close(); err != nil {
+			return fmt.Errorf("failed to close file %v: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// restoreBackups moves all backups listed in the backup manifest back to the
+// path of the file they were made of and removes the manifest. Files which
+// could not be restored are kept in the manifest. It returns the paths of all
+// restored files.
+func restoreBackups() ([]string, error) {
+	manifestPath := backupManifestPath()
+	b, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+	var entries []backupManifestEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+	}
+	var restored []string
+	for i, e := range entries {
+		if err := os.Rename(e.Backup, e.Original); err != nil {
+			if mErr := writeBackupManifest(manifestPath, entries[i:]); mErr != nil {
+				return restored, fmt.Errorf("failed to update backup manifest: %w", mErr)
+			}
+			return restored, fmt.Errorf("failed to restore file %v: %w", e.Original, err)
+		}
+		restored = append(restored, e.Original)
+	}
+	if err := writeBackupManifest(manifestPath, nil); err != nil {
+		return restored, fmt.Errorf("failed to remove backup manifest: %w", err)
+	}
+	return restored, nil
+}
+
 func main() {
 	flag.Parse()
+	if *restore {
+		restored, err := restoreBackups()
+		for _, file := range restored {
+			log.Printf("restored %v", file)
+		}
+		if err != nil {
+			log.Fatalf("failed to restore backups: %v", err)
+		}
+		return
+	}
 	compilationDBFile, err := os.Open(*compilationDBPath)
 	if err != nil {
 		log.Fatalf("failed to open compilation db: %v", err)
@@ -335,54 +936,50 @@ func main() {
 	if err := prototext.Unmarshal(specRaw, &spec); err != nil {
 		log.Fatalf("failed to load spec: %v", err)
 	}
+	for _, r := range spec.Replace {
+		if _, err := path.Match(r.From, ""); isGlob(r.From) && err != nil {
+			log.Fatalf("invalid replace pattern %q: %v", r.From, err)
+		}
+	}
 
 	isGeneratedFile := make(map[string]bool)
 	for _, entry := range spec.GeneratedFile {
 		isGeneratedFile[filepath.Join(*workspacePath, entry.Path)] = true
 	}
 
-	rewriteMetadata := make(rewriteMetadata)
+	rewriteMetadata := newRewriteMetadata()
+	rewriteMetadata.processCompilationDB(compilationDB, &spec, isGeneratedFile, runtime.GOMAXPROCS(0))
 
-	// Iterate over all source files in the compilation database and analyze
-	// them one-by-one
-	for _, entry := range compilationDB {
-		quoteIncludes, systemIncludes, err := getIncludeDirs(entry)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		filePath := entry.File
-		if !filepath.IsAbs(entry.File) {
-			filePath = filepath.Join(entry.Directory, entry.File)
+	// Process files sorted by path to keep the output stable.
+	files := rewriteMetadata.sortedFiles()
+
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, rewriteMetadata); err != nil {
+			log.Fatalf("failed to write report: %v", err)
 		}
-		includedFiles := rewriteMetadata.fixIncludesAndGetRefs(filePath, quoteIncludes, systemIncludes, &spec, isGeneratedFile)
+	}
 
-		// seen stores the path of already-visited files, similar to #pragma once
-		seen := make(map[string]bool)
-		// rec recursively resolves includes and records rewrites
-		var rec func([]string)
-		rec = func(files []string) {
-			for _, f := range files {
-				if seen[f] {
-					continue
-				}
-				seen[f] = true
-				icf2 := rewriteMetadata.fixIncludesAndGetRefs(f, quoteIncludes, systemIncludes, &spec, isGeneratedFile)
-				rec(icf2)
+	if *dryRun {
+		changed := false
+		for _, file := range files {
+			rew := rewriteMetadata.file(file)
+			diff, err := rew.diff(file)
+			if err != nil {
+				log.Fatalf("failed to diff file %v: %v", file, err)
+			}
+			if diff != "" {
+				fmt.Print(diff)
+				changed = true
 			}
 		}
-		rec(includedFiles)
+		if changed {
+			os.Exit(1)
+		}
+		return
 	}
 
 	// Perform all recorded rewrites on the actual files
-	for file, rew := range rewriteMetadata {
-		outFile, err := os.Create(file)
-		if err != nil {
-			log.Fatalf("failed to open file for writing output: %v", err)
-		}
-		defer outFile.Close()
-		if _, err := rew.rewrites.replacer().WriteString(outFile, rew.source); err != nil {
-			log.Fatalf("failed to write file %v: %v", file, err)
-		}
+	if err := writeRewrites(rewriteMetadata, files, *backupSuffix); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,523 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"source.monogon.dev/build/bazel_cc_fix/ccfixspec"
+)
+
+func TestFindIncludeDirectives(t *testing.T) {
+	for i, te := range []struct {
+		source string
+		want   []string
+	}{
+		{"#include <a.h>\n  #  include \"b.h\"\n", []string{"#include <a.h>", "  #  include \"b.h\""}},
+		{"// #include \"a.h\"\n#include \"b.h\"", []string{"#include \"b.h\""}},
+		{"/*\n#include \"a.h\"\n*/\n#include \"b.h\"", []string{"#include \"b.h\""}},
+		{"/* x */ #include \"a.h\"", nil},
+		{"// comment \\\n#include \"a.h\"\n", nil},
+		{"const char *s = \"\\\n#include \\\"a.h\\\"\";\n", nil},
+		{"auto s = R\"x(\n#include \"a.h\"\n)x\";\n#include \"b.h\"", []string{"#include \"b.h\""}},
+		{"#error don't\n#include \"a.h\"", []string{"#include \"a.h\""}},
+		{"int a = 1'000; /*\n#include \"a.h\"\n*/", nil},
+	} {
+		var got []string
+		for _, d := range findIncludeDirectives(te.source) {
+			got = append(got, te.source[d[0]:d[1]])
+		}
+		if len(got) != len(te.want) {
+			t.Errorf("case %d: wanted %q, got %q", i, te.want, got)
+			continue
+		}
+		for j := range got {
+			if got[j] != te.want[j] {
+				t.Errorf("case %d: wanted %q, got %q", i, te.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestApplyReplaceDirectives(t *testing.T) {
+	directives := []*ccfixspec.Replace{
+		{Type: ccfixspec.Replace_WORKSPACE, From: "*/internal/", To: "external/lib/internal/"},
+		{Type: ccfixspec.Replace_WORKSPACE, From: "src/*/gen_*.h", To: "generated/"},
+		{Type: ccfixspec.Replace_WORKSPACE, From: "*/config.h", To: "external/lib/config.h"},
+		{Type: ccfixspec.Replace_WORKSPACE, From: "foo/internal/", To: "external/foo/"},
+		{Type: ccfixspec.Replace_WORKSPACE, From: "bar/internal/a.h", To: "external/bar/a.h"},
+		{Type: ccfixspec.Replace_SYSTEM, From: "*/sys/", To: "external/sys/"},
+		{Type: ccfixspec.Replace_SYSTEM, From: "*/internal/*.h", To: "external/lib/internal/"},
+	}
+	for _, te := range []struct {
+		replaceType ccfixspec.Replace_Type
+		from        string
+		want        string
+	}{
+		// Exact and prefix matches take priority over globs.
+		{ccfixspec.Replace_WORKSPACE, "foo/internal/x/a.h", "external/foo/x/a.h"},
+		{ccfixspec.Replace_WORKSPACE, "bar/internal/a.h", "external/bar/a.h"},
+		{ccfixspec.Replace_WORKSPACE, "baz/internal/x/a.h", "external/lib/internal/x/a.h"},
+		{ccfixspec.Replace_WORKSPACE, "baz/qux/internal/a.h", ""},
+		{ccfixspec.Replace_WORKSPACE, "baz/internal", ""},
+		{ccfixspec.Replace_WORKSPACE, "src/foo/gen_bar.h", "generated/gen_bar.h"},
+		{ccfixspec.Replace_WORKSPACE, "src/foo/gen_baz.h", "generated/gen_baz.h"},
+		// Without a wildcard in the last element, the file name is not kept.
+		{ccfixspec.Replace_WORKSPACE, "foo/config.h", "external/lib/config.h"},
+		{ccfixspec.Replace_WORKSPACE, "src/foo/gen_bar.h.in", ""},
+		{ccfixspec.Replace_WORKSPACE, "src/foo/bar/gen_bar.h", ""},
+		{ccfixspec.Replace_SYSTEM, "linux/sys/types.h", "external/sys/types.h"},
+		{ccfixspec.Replace_SYSTEM, "baz/internal/a.h", "external/lib/internal/a.h"},
+		{ccfixspec.Replace_SYSTEM, "qux/internal/b.h", "external/lib/internal/b.h"},
+		{ccfixspec.Replace_SYSTEM, "baz/internal/x/a.h", ""},
+	} {
+		if got := applyReplaceDirectives(directives, te.replaceType, te.from, false); got != te.want {
+			t.Errorf("%v %q: wanted %q, got %q", te.replaceType, te.from, te.want, got)
+		}
+	}
+}
+
+// TestFixIncludesSkipsComments ensures include directives in comments are
+// neither followed nor rewritten.
+func TestFixIncludesSkipsComments(t *testing.T) {
+	ws := t.TempDir()
+	oldWorkspacePath := *workspacePath
+	*workspacePath = ws
+	defer func() { *workspacePath = oldWorkspacePath }()
+
+	if err := os.MkdirAll(filepath.Join(ws, "inc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(ws, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"inc/foo.h", "inc/bar.h"} {
+		if err := os.WriteFile(filepath.Join(ws, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := `/*
+ * Usage:
+ *   #include "bar.h"
+ *   #include "foo.h"
+ */
+#include "foo.h"
+// #include "foo.h"
+`
+	want := `/*
+ * Usage:
+ *   #include "bar.h"
+ *   #include "foo.h"
+ */
+#include "inc/foo.h"
+// #include "foo.h"
+`
+	srcPath := filepath.Join(ws, "src/main.c")
+	if err := os.WriteFile(srcPath, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newRewriteMetadata()
+	includes := []string{filepath.Join(ws, "inc")}
+	refs := m.fixIncludesAndGetRefs(0, srcPath, includes, includes, &ccfixspec.CCFixSpec{}, nil)
+	if len(refs) != 1 || refs[0] != filepath.Join(ws, "inc/foo.h") {
+		t.Errorf("wanted only inc/foo.h to be referenced, got %v", refs)
+	}
+	if got := m.file(srcPath).rewritten(); got != want {
+		t.Errorf("wanted rewritten source %q, got %q", want, got)
+	}
+}
+
+// TestPreserveBracketStyle ensures angle includes stay angle includes when
+// -preserve_bracket_style is set, while quote includes stay quoted.
+func TestPreserveBracketStyle(t *testing.T) {
+	ws := t.TempDir()
+	oldWorkspacePath := *workspacePath
+	*workspacePath = ws
+	defer func() { *workspacePath = oldWorkspacePath }()
+	oldPreserveBracketStyle := *preserveBracketStyle
+	*preserveBracketStyle = true
+	defer func() { *preserveBracketStyle = oldPreserveBracketStyle }()
+
+	for _, name := range []string{"inc/foo.h", "inc/bar.h", "src/local.h"} {
+		p := filepath.Join(ws, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := "#include <foo.h>\n#include \"bar.h\"\n#include <local.h>\n#include <inc/foo.h>\n"
+	want := "#include <inc/foo.h>\n#include \"inc/bar.h\"\n#include <src/local.h>\n#include <inc/foo.h>\n"
+	srcPath := filepath.Join(ws, "src/main.c")
+	if err := os.WriteFile(srcPath, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newRewriteMetadata()
+	includes := []string{ws, filepath.Join(ws, "inc"), filepath.Join(ws, "src")}
+	m.fixIncludesAndGetRefs(0, srcPath, includes, includes, &ccfixspec.CCFixSpec{}, nil)
+	if got := m.file(srcPath).rewritten(); got != want {
+		t.Errorf("wanted rewritten source %q, got %q", want, got)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	ws := t.TempDir()
+	oldWorkspacePath := *workspacePath
+	*workspacePath = ws
+	defer func() { *workspacePath = oldWorkspacePath }()
+
+	for name, content := range map[string]string{
+		"src/main.c": "#include <b.h>\n#include <a.h>\n",
+		"inc/a.h":    "",
+	} {
+		p := filepath.Join(ws, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := newRewriteMetadata()
+	m.file(filepath.Join(ws, "inc/a.h"))
+	mainC := m.file(filepath.Join(ws, "src/main.c"))
+	mainC.addWorkspace(0, "#include <b.h>", "inc/b.h", false)
+	mainC.addWorkspace(0, "#include <a.h>", "inc/a.h", false)
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := writeReport(path, m); err != nil {
+		t.Fatalf("writeReport: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "inc/a.h": {
+    "rewrites": [],
+    "modified": false
+  },
+  "src/main.c": {
+    "rewrites": [
+      {
+        "from": "#include <a.h>",
+        "to": "#include \"inc/a.h\""
+      },
+      {
+        "from": "#include <b.h>",
+        "to": "#include \"inc/b.h\""
+      }
+    ],
+    "modified": true
+  }
+}
+`
+	if string(got) != want {
+		t.Errorf("wanted report %s, got %s", want, got)
+	}
+}
+
+// TestBackupAndRestore ensures modified files are backed up before being
+// rewritten and can be restored from their backups.
+func TestBackupAndRestore(t *testing.T) {
+	ws := t.TempDir()
+	oldWorkspacePath := *workspacePath
+	*workspacePath = ws
+	defer func() { *workspacePath = oldWorkspacePath }()
+
+	source := "#include <a.h>\n"
+	for name, content := range map[string]string{
+		"src/main.c": source,
+		"inc/a.h":    "",
+		// A backup-like file which was not written by bazel_cc_fix.
+		"src/other.c":      "other\n",
+		"src/other.c.orig": "other original\n",
+	} {
+		p := filepath.Join(ws, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mainC := filepath.Join(ws, "src/main.c")
+	// A file outside of the workspace, eg. from an external library.
+	externalC := filepath.Join(t.TempDir(), "external.c")
+	if err := os.WriteFile(externalC, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := newRewriteMetadata()
+	m.file(filepath.Join(ws, "inc/a.h"))
+	m.file(mainC).addWorkspace(0, "#include <a.h>", "inc/a.h", false)
+	m.file(externalC).addWorkspace(0, "#include <a.h>", "inc/a.h", false)
+	files := m.sortedFiles()
+
+	// A backup which cannot be written must prevent any file from being
+	// rewritten.
+	if err := os.Mkdir(mainC+".orig", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRewrites(m, files, ".orig"); err == nil {
+		t.Fatal("wanted writeRewrites to fail")
+	}
+	if got, _ := os.ReadFile(mainC); string(got) != source {
+		t.Errorf("wanted src/main.c to be unmodified, got %q", got)
+	}
+	if _, err := os.Stat(externalC + ".orig"); !os.IsNotExist(err) {
+		t.Errorf("wanted no backup of external.c to be left behind, got %v", err)
+	}
+	if _, err := os.Stat(backupManifestPath()); !os.IsNotExist(err) {
+		t.Errorf("wanted no backup manifest, got %v", err)
+	}
+	if err := os.Remove(mainC + ".orig"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeRewrites(m, files, ".orig"); err != nil {
+		t.Fatalf("writeRewrites: %v", err)
+	}
+	if got, _ := os.ReadFile(mainC); string(got) != "#include \"inc/a.h\"\n" {
+		t.Errorf("wanted src/main.c to be rewritten, got %q", got)
+	}
+	if got, _ := os.ReadFile(mainC + ".orig"); string(got) != source {
+		t.Errorf("wanted backup of src/main.c to contain original source, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "inc/a.h.orig")); !os.IsNotExist(err) {
+		t.Errorf("wanted no backup of unmodified inc/a.h, got %v", err)
+	}
+
+	// A second run on the already rewritten tree must not replace the backup
+	// of the original source.
+	m2 := newRewriteMetadata()
+	m2.file(filepath.Join(ws, "inc/a.h"))
+	m2.file(mainC).addWorkspace(0, "#include \"inc/a.h\"", "inc/a.h", true)
+	if err := writeRewrites(m2, m2.sortedFiles(), ".orig"); err == nil {
+		t.Fatal("wanted second writeRewrites to fail")
+	}
+	if got, _ := os.ReadFile(mainC + ".orig"); string(got) != source {
+		t.Errorf("wanted backup of src/main.c to still contain original source, got %q", got)
+	}
+	if got, _ := os.ReadFile(mainC); string(got) != "#include \"inc/a.h\"\n" {
+		t.Errorf("wanted src/main.c to be unmodified by second run, got %q", got)
+	}
+
+	restored, err := restoreBackups()
+	if err != nil {
+		t.Fatalf("restoreBackups: %v", err)
+	}
+	if want := []string{mainC, externalC}; !slices.Equal(restored, want) {
+		t.Errorf("wanted %v to be restored, got %v", want, restored)
+	}
+	for _, file := range []string{mainC, externalC} {
+		if got, _ := os.ReadFile(file); string(got) != source {
+			t.Errorf("wanted %v to be restored, got %q", file, got)
+		}
+		if _, err := os.Stat(file + ".orig"); !os.IsNotExist(err) {
+			t.Errorf("wanted backup of %v to be removed, got %v", file, err)
+		}
+	}
+	// Files not backed up by bazel_cc_fix must be left alone.
+	if got, _ := os.ReadFile(filepath.Join(ws, "src/other.c")); string(got) != "other\n" {
+		t.Errorf("wanted src/other.c to be untouched, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "src/other.c.orig")); err != nil {
+		t.Errorf("wanted src/other.c.orig to be kept, got %v", err)
+	}
+	if _, err := os.Stat(backupManifestPath()); !os.IsNotExist(err) {
+		t.Errorf("wanted backup manifest to be removed, got %v", err)
+	}
+}
+
+// makeSyntheticWorkspace creates a workspace containing the given number of
+// source files including a set of shared headers and returns a compilation
+// database for it.
+func makeSyntheticWorkspace(t testing.TB, ws string, sources int) compilationDB {
+	t.Helper()
+	const headers = 100
+	write := func(name, content string) {
+		p := filepath.Join(ws, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("inc/common.h", "#pragma once\n")
+	// Headers form include chains of length 10.
+	for i := 0; i < headers; i++ {
+		content := "#include \"common.h\"\n"
+		if (i+1)%10 != 0 {
+			content += fmt.Sprintf("#include \"hdr%d.h\"\n", i+1)
+		}
+		write(fmt.Sprintf("inc/lib/hdr%d.h", i), content)
+	}
+	var db compilationDB
+	for i := 0; i < sources; i++ {
+		name := fmt.Sprintf("src/file%d.c", i)
+		write(name, fmt.Sprintf("#include <hdr%d.h>\n#include \"common.h\"\n", i%headers))
+		db = append(db, compilationDBEntry{
+			Directory: ws,
+			Arguments: []string{"cc", "-Iinc/lib", "-Iinc", "-c", name},
+			File:      name,
+		})
+	}
+	return db
+}
+
+// TestProcessCompilationDBDeterministic ensures that the recorded rewrites do
+// not depend on the number of workers, even if entries disagree about the
+// rewrite of a directive.
+func TestExpandResponseFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"flags.rsp":      "-Iinc \"-DMSG=hello world\" 'sp ace.c'\n@nested.rsp\n",
+		"nested.rsp":     "-DNESTED @rsp/inner.rsp",
+		"rsp/inner.rsp":  "-DINNER",
+		"missing.rsp":    "-DA @does-not-exist.rsp",
+		"recursive.rsp":  "@recursive.rsp",
+		"unbalanced.rsp": "\"-DA",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, te := range []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "NoResponseFiles",
+			args: []string{"cc", "-c", "@", "a.c"},
+			want: []string{"cc", "-c", "@", "a.c"},
+		},
+		{
+			// Nested response files are resolved against the working directory,
+			// not against the directory of the referencing response file.
+			name: "Nested",
+			args: []string{"cc", "@flags.rsp", "-c"},
+			want: []string{"cc", "-Iinc", "-DMSG=hello world", "sp ace.c", "-DNESTED", "-DINNER", "-c"},
+		},
+		{
+			name: "Absolute",
+			args: []string{"@" + filepath.Join(dir, "rsp/inner.rsp")},
+			want: []string{"-DINNER"},
+		},
+		{
+			name:    "Missing",
+			args:    []string{"cc", "@missing.rsp"},
+			wantErr: "failed to read response file",
+		},
+		{
+			name:    "Recursive",
+			args:    []string{"@recursive.rsp"},
+			wantErr: "nested deeper than",
+		},
+		{
+			name:    "Unbalanced",
+			args:    []string{"@unbalanced.rsp"},
+			wantErr: "failed to parse response file",
+		},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			got, err := expandResponseFiles(te.args, dir, 0)
+			if te.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), te.wantErr) {
+					t.Fatalf("wanted error containing %q, got %v", te.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandResponseFiles: %v", err)
+			}
+			if !slices.Equal(got, te.want) {
+				t.Errorf("wanted %q, got %q", te.want, got)
+			}
+		})
+	}
+}
+
+func TestProcessCompilationDBDeterministic(t *testing.T) {
+	ws := t.TempDir()
+	oldWorkspacePath := *workspacePath
+	*workspacePath = ws
+	defer func() { *workspacePath = oldWorkspacePath }()
+
+	db := makeSyntheticWorkspace(t, ws, 50)
+	// Make common.h resolve to a different header for all but the first
+	// entry.
+	if err := os.WriteFile(filepath.Join(ws, "inc/lib/common.h"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(db); i++ {
+		db[i].Arguments = []string{"cc", "-iquote", "inc/lib", "-Iinc", "-c", db[i].File}
+	}
+	db[0].Arguments = []string{"cc", "-Iinc", "-Iinc/lib", "-c", db[0].File}
+
+	want := newRewriteMetadata()
+	want.processCompilationDB(db, &ccfixspec.CCFixSpec{}, nil, 1)
+	got := newRewriteMetadata()
+	got.processCompilationDB(db, &ccfixspec.CCFixSpec{}, nil, 8)
+
+	wantFiles, gotFiles := want.sortedFiles(), got.sortedFiles()
+	if fmt.Sprint(wantFiles) != fmt.Sprint(gotFiles) {
+		t.Fatalf("wanted files %v, got %v", wantFiles, gotFiles)
+	}
+	for _, f := range wantFiles {
+		if w, g := want.file(f).rewritten(), got.file(f).rewritten(); w != g {
+			t.Errorf("%s: wanted %q, got %q", f, w, g)
+		}
+	}
+	hdr := filepath.Join(ws, "inc/lib/hdr0.h")
+	if w, g := "#include \"inc/common.h\"", want.file(hdr).rewrites["#include \"common.h\""]; w != g {
+		t.Errorf("wanted rewrite of first entry %q, got %q", w, g)
+	}
+}
+
+func BenchmarkProcessCompilationDB(b *testing.B) {
+	ws := b.TempDir()
+	oldWorkspacePath := *workspacePath
+	*workspacePath = ws
+	defer func() { *workspacePath = oldWorkspacePath }()
+
+	db := makeSyntheticWorkspace(b, ws, 5000)
+	for _, workers := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m := newRewriteMetadata()
+				m.processCompilationDB(db, &ccfixspec.CCFixSpec{}, nil, workers)
+			}
+		})
+	}
+}
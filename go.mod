@@ -116,6 +116,7 @@ require (
 	github.com/packethost/packngo v0.29.0
 	github.com/pkg/errors v0.9.1
 	github.com/pkg/sftp v1.13.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/prometheus/client_golang v1.17.0
 	github.com/prometheus/node_exporter v1.7.0
 	github.com/pseudomuto/protoc-gen-doc v1.5.0
@@ -161,6 +162,7 @@ require (
 	k8s.io/kubelet v0.30.2
 	k8s.io/kubernetes v1.20.1
 	k8s.io/pod-security-admission v0.0.0
+	sigs.k8s.io/yaml v1.3.0
 )
 
 require (
@@ -402,7 +404,6 @@ require (
 	github.com/pingcap/log v1.1.0 // indirect
 	github.com/pingcap/tidb/parser v0.0.0-20231010133155-38cb4f3312be // indirect
 	github.com/pkg/xattr v0.4.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/pquerna/cachecontrol v0.1.0 // indirect
 	github.com/prometheus-community/go-runit v0.1.0 // indirect
 	github.com/prometheus/client_model v0.5.0 // indirect
@@ -508,7 +509,6 @@ require (
 	sigs.k8s.io/kustomize/kustomize/v5 v5.0.4-0.20230601165947-6ce0bf390ce3 // indirect
 	sigs.k8s.io/kustomize/kyaml v0.14.3-0.20230601165947-6ce0bf390ce3 // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.4.1 // indirect
-	sigs.k8s.io/yaml v1.3.0 // indirect
 	tags.cncf.io/container-device-interface v0.6.2 // indirect
 	tags.cncf.io/container-device-interface/specs-go v0.6.0 // indirect
 )
@@ -1,19 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"log"
 	"os"
+	"os/signal"
 
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
 
 	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	"source.monogon.dev/metropolis/node/core/rpc/resolver"
+	apb "source.monogon.dev/metropolis/proto/api"
 )
 
 func init() {
 	certCmd.AddCommand(certExportCmd)
+	certCmd.AddCommand(certIssueReadOnlyCmd)
 
 	rootCmd.AddCommand(certCmd)
 }
@@ -50,3 +59,67 @@ var certExportCmd = &cobra.Command{
 	},
 	Args: cobra.NoArgs,
 }
+
+var certIssueReadOnlyCmd = &cobra.Command{
+	Short: "Issues read-only credentials for use in other programs",
+	Long: `This issues a new certificate and key which only permit read-only access
+to the cluster, eg. retrieving the cluster's nodes or their logs. These are
+intended for monitoring systems and similar tooling which should not be able to
+mutate the cluster. The files are written into the current directory.`,
+	Use:     "issue-read-only",
+	Example: "metroctl cert issue-read-only",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+
+		// Read-only certificates are issued via AAA.Escrow, which requires
+		// authenticating with the owner's key.
+		opk, err := core.GetOwnerKey(flags.configPath)
+		if errors.Is(err, core.ErrNoCredentials) {
+			log.Fatalf("You have to take ownership of the cluster first: %v", err)
+		}
+		if err != nil {
+			log.Fatalf("Couldn't get owner's key: %v", err)
+		}
+		ca, err := core.GetClusterCAWithTOFU(ctx, connectOptions())
+		if err != nil {
+			log.Fatalf("Could not retrieve cluster CA: %v", err)
+		}
+		opts, err := core.DialOpts(ctx, connectOptions())
+		if err != nil {
+			log.Fatalf("While configuring cluster dial opts: %v", err)
+		}
+		creds, err := rpc.NewEphemeralCredentials(opk, rpc.WantRemoteCluster(ca))
+		if err != nil {
+			log.Fatalf("While generating ephemeral credentials: %v", err)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+		cc, err := grpc.Dial(resolver.MetropolisControlAddress, opts...)
+		if err != nil {
+			log.Fatalf("While dialing the cluster: %v", err)
+		}
+		defer cc.Close()
+
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatalf("Could not generate key: %v", err)
+		}
+		cert, err := rpc.RetrieveReadOnlyCertificate(ctx, apb.NewAAAClient(cc), pub)
+		if err != nil {
+			log.Fatalf("Failed to retrieve read-only certificate from cluster: %v", err)
+		}
+
+		pkcs8Key, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			// We explicitly pass an Ed25519 private key in, so this can't happen
+			panic(err)
+		}
+		if err := os.WriteFile("read-only.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile("read-only.key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Key}), 0600); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Wrote files to current dir: read-only.crt, read-only.key")
+	},
+	Args: cobra.NoArgs,
+}
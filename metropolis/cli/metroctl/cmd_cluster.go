@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/node/core/identity"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+var clusterCmd = &cobra.Command{
+	Short: "Queries cluster information.",
+	Use:   "cluster",
+}
+
+var clusterStatusCmd = &cobra.Command{
+	Short: "Summarizes the health of the cluster.",
+	Long: `Summarizes the health of the cluster.
+
+The fingerprint of the cluster CA certificate, the number of nodes per state
+and health, and the consensus members are shown. The output format can be
+selected with --format, which can be one of:
+
+  - plaintext: a human-readable summary, the default
+  - json: a JSON object
+  - yaml: a YAML object
+`,
+	Use:  "status [--format]",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
+		cc, err := dialAuthenticated(ctx)
+		if err != nil {
+			log.Fatalf("Could not connect to cluster: %v", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
+
+		info, err := mgmt.GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+		if err != nil {
+			log.Fatalf("While calling Management.GetClusterInfo: %v", err)
+		}
+		ca, err := x509.ParseCertificate(info.CaCertificate)
+		if err != nil {
+			log.Fatalf("Could not parse cluster CA certificate: %v", err)
+		}
+		nodes, err := core.GetNodes(ctx, mgmt, "")
+		if err != nil {
+			log.Fatalf("While calling Management.GetNodes: %v", err)
+		}
+		st := newClusterStatus(ca, nodes)
+
+		o := outputWriter()
+		defer o.Close()
+		switch flags.format {
+		case "plaintext", "table":
+			st.print(o)
+		case "json", "yaml":
+			out, err := json.MarshalIndent(st, "", "  ")
+			if err != nil {
+				log.Fatalf("While marshaling cluster status: %v", err)
+			}
+			if flags.format == "yaml" {
+				out, err = yaml.JSONToYAML(out)
+				if err != nil {
+					log.Fatalf("While converting cluster status to YAML: %v", err)
+				}
+			} else {
+				out = append(out, '\n')
+			}
+			if _, err := o.Write(out); err != nil {
+				log.Fatalf("While writing output: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown output format %q, must be one of: plaintext, json, yaml", flags.format)
+		}
+	},
+}
+
+// clusterStatus is the summary of the cluster shown by 'cluster status'.
+type clusterStatus struct {
+	CAFingerprint string `json:"ca_fingerprint"`
+	NodeCount     int    `json:"node_count"`
+	// States and Health are the number of nodes per state and health.
+	States map[string]int `json:"states"`
+	Health map[string]int `json:"health"`
+	// ConsensusMembers are the consensus members of the cluster, sorted by
+	// node ID.
+	ConsensusMembers []clusterStatusMember `json:"consensus_members"`
+	// HealthyConsensus is true if at least one consensus member is healthy.
+	HealthyConsensus bool `json:"healthy_consensus"`
+}
+
+type clusterStatusMember struct {
+	ID     string `json:"id"`
+	Health string `json:"health"`
+}
+
+func newClusterStatus(ca *x509.Certificate, nodes []*apb.Node) *clusterStatus {
+	st := &clusterStatus{
+		CAFingerprint:    core.CertificateFingerprint(ca),
+		NodeCount:        len(nodes),
+		States:           make(map[string]int),
+		Health:           make(map[string]int),
+		ConsensusMembers: []clusterStatusMember{},
+	}
+	for _, n := range nodes {
+		st.States[strings.ReplaceAll(n.State.String(), "NODE_STATE_", "")]++
+		st.Health[n.Health.String()]++
+		if n.Roles.GetConsensusMember() == nil {
+			continue
+		}
+		st.ConsensusMembers = append(st.ConsensusMembers, clusterStatusMember{
+			ID:     identity.NodeID(n.Pubkey),
+			Health: n.Health.String(),
+		})
+		if n.Health == apb.Node_HEALTHY {
+			st.HealthyConsensus = true
+		}
+	}
+	sort.Slice(st.ConsensusMembers, func(i, j int) bool {
+		return st.ConsensusMembers[i].ID < st.ConsensusMembers[j].ID
+	})
+	return st
+}
+
+// print writes the cluster status in a human-readable form to w.
+func (s *clusterStatus) print(w io.Writer) {
+	fmt.Fprintf(w, "Cluster CA: %s\n", s.CAFingerprint)
+	fmt.Fprintf(w, "Nodes: %d\n", s.NodeCount)
+	fmt.Fprintf(w, "  by state:  %s\n", formatTally(s.States))
+	fmt.Fprintf(w, "  by health: %s\n", formatTally(s.Health))
+	fmt.Fprintf(w, "Consensus members: %d\n", len(s.ConsensusMembers))
+	for _, m := range s.ConsensusMembers {
+		fmt.Fprintf(w, "  %s (%s)\n", m.ID, m.Health)
+	}
+	if !s.HealthyConsensus {
+		fmt.Fprintf(w, "WARNING: no consensus member is currently healthy\n")
+	}
+}
+
+// formatTally formats a map of counts as a sorted, comma-separated list.
+func formatTally(tally map[string]int) string {
+	if len(tally) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(tally))
+	for k := range tally {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, tally[k]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func init() {
+	clusterCmd.AddCommand(clusterStatusCmd)
+	rootCmd.AddCommand(clusterCmd)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/proto/api"
+)
+
+var completionCmd = &cobra.Command{
+	Short: "Generate shell completion scripts",
+	Long: `Generate shell completion scripts.
+
+The generated script completes metroctl commands and flags, as well as node IDs
+(by querying the cluster) and metrics exporter names. To load completions in
+the current shell session:
+
+  bash: source <(metroctl completion bash)
+  zsh:  source <(metroctl completion zsh)
+  fish: metroctl completion fish | source
+`,
+	Use:       "completion [bash|zsh|fish]",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		}
+		return fmt.Errorf("unsupported shell %q", args[0])
+	},
+}
+
+// metricsExporters are the names of the metrics exporters available on nodes,
+// as accepted by 'node metrics'.
+var metricsExporters = []string{
+	"node",
+	"etcd",
+	"kubernetes-scheduler",
+	"kubernetes-controller-manager",
+	"kubernetes-apiserver",
+	"containerd",
+}
+
+// completeNodeIDs returns the IDs of all nodes in the cluster which start with
+// toComplete and have not yet been given in args. If the cluster cannot be
+// queried (eg. because no ownership has been taken yet), no completions are
+// returned.
+func completeNodeIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// Bail out early in any case in which dialAuthenticated would prompt the
+	// user or fail.
+	if _, _, err := core.GetOwnerCredentials(flags.configPath); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if len(flags.clusterEndpoints) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	ctx, ctxC := context.WithTimeout(cmd.Context(), 5*time.Second)
+	defer ctxC()
+	cc, err := dialAuthenticated(ctx)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	defer cc.Close()
+	nodes, err := core.GetNodes(ctx, api.NewManagementClient(cc), "")
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	given := make(map[string]bool)
+	for _, a := range args {
+		given[a] = true
+	}
+	var res []string
+	for _, n := range nodes {
+		id := identity.NodeID(n.Pubkey)
+		if given[id] || !strings.HasPrefix(id, toComplete) {
+			continue
+		}
+		res = append(res, id)
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeSingleNodeID works like completeNodeIDs, but only completes the first
+// argument.
+func completeSingleNodeID(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeNodeIDs(cmd, args, toComplete)
+}
+
+// completeMetricsArgs completes the arguments of 'node metrics', ie. a node ID
+// followed by any number of exporters.
+func completeMetricsArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return completeNodeIDs(cmd, args, toComplete)
+	}
+	given := make(map[string]bool)
+	for _, a := range args[1:] {
+		given[a] = true
+	}
+	var res []string
+	for _, e := range metricsExporters {
+		if given[e] || !strings.HasPrefix(e, toComplete) {
+			continue
+		}
+		res = append(res, e)
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	nodeDescribeCmd.ValidArgsFunction = completeNodeIDs
+	nodeListCmd.ValidArgsFunction = completeNodeIDs
+	nodeUpdateCmd.ValidArgsFunction = completeNodeIDs
+	nodeDeleteCmd.ValidArgsFunction = completeSingleNodeID
+	nodeLogsCmd.ValidArgsFunction = completeSingleNodeID
+	nodeMetricsCmd.ValidArgsFunction = completeMetricsArgs
+	rootCmd.AddCommand(completionCmd)
+}
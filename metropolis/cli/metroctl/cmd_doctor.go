@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"source.monogon.dev/metropolis/cli/metroctl/core"
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+var doctorCmd = &cobra.Command{
+	Short: "Runs a set of health checks against the cluster",
+	Long: `Runs a set of health checks against the cluster.
+
+This checks whether the cluster's control plane is reachable, whether the
+consensus members and all other nodes are healthy, whether certificates are
+close to expiring, whether the storage of consensus members is close to being
+full and whether node clocks appear to be in sync. Each check results in either
+PASS, WARN, FAIL or SKIP, with hints on how to remediate any problems found.
+
+The command exits with a non-zero exit code if any check failed.
+`,
+	Use:  "doctor",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		d := &doctor{
+			dial:     dialAuthenticated,
+			dialNode: dialAuthenticatedNode,
+		}
+		d.run(ctx)
+
+		failed := 0
+		for _, r := range d.results {
+			fmt.Printf("[%s] %s: %s\n", r.status, r.name, r.detail)
+			if r.hint != "" && r.status != checkPass {
+				fmt.Printf("       hint: %s\n", r.hint)
+			}
+			if r.status == checkFail {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d check(s) failed", failed)
+		}
+		return nil
+	},
+}
+
+// checkStatus is the outcome of a single doctor check.
+type checkStatus string
+
+const (
+	checkPass checkStatus = "PASS"
+	checkWarn checkStatus = "WARN"
+	checkFail checkStatus = "FAIL"
+	// checkSkip is used for checks which could not be performed, eg. because
+	// the cluster is unreachable.
+	checkSkip checkStatus = "SKIP"
+)
+
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+	// hint is a remediation hint shown for non-passing checks.
+	hint string
+}
+
+const (
+	// doctorRPCTimeout is the timeout of every RPC performed by the doctor.
+	doctorRPCTimeout = 10 * time.Second
+	// doctorCertWarnThreshold is the remaining validity of a certificate below
+	// which a warning is emitted.
+	doctorCertWarnThreshold = 30 * 24 * time.Hour
+	// doctorStorageWarnPercent and doctorStorageFailPercent are the fill
+	// levels of consensus members' data partitions at which a warning or
+	// failure are emitted.
+	doctorStorageWarnPercent = 80
+	doctorStorageFailPercent = 95
+	// doctorClockSkewThreshold is the amount of time by which a node's last
+	// status update may lie in the future before its clock is considered to
+	// be out of sync.
+	doctorClockSkewThreshold = 30 * time.Second
+)
+
+// doctor runs health checks against a cluster and collects their results.
+type doctor struct {
+	results []checkResult
+
+	// dial connects to the cluster, and dialNode to a single node. These are
+	// dialAuthenticated and dialAuthenticatedNode outside of tests.
+	dial     func(ctx context.Context) (*grpc.ClientConn, error)
+	dialNode func(ctx context.Context, id, address string, cacert *x509.Certificate) (*grpc.ClientConn, error)
+}
+
+func (d *doctor) report(name string, status checkStatus, hint, format string, args ...any) {
+	d.results = append(d.results, checkResult{
+		name:   name,
+		status: status,
+		detail: fmt.Sprintf(format, args...),
+		hint:   hint,
+	})
+}
+
+// controlPlaneChecks are the names of the checks which depend on the control
+// plane, and are skipped if it is unreachable.
+var controlPlaneChecks = []string{"cluster CA certificate", "consensus", "node heartbeats", "consensus storage", "time sync"}
+
+// run performs all checks. Checks which depend on the control plane are
+// skipped if it is unreachable.
+func (d *doctor) run(ctx context.Context) {
+	ocert, _, err := core.GetOwnerCredentials(flags.configPath)
+	if err != nil {
+		d.report("owner certificate", checkFail, "Ensure the metroctl configuration directory is intact, or take ownership of the cluster first.", "could not load owner credentials: %v", err)
+	} else {
+		d.checkCertificate("owner certificate", ocert, "Issue a new owner certificate.")
+	}
+
+	cc, err := d.dial(ctx)
+	if err != nil {
+		d.report("control plane", checkFail, "Check the owner credentials, the cluster CA and the given endpoints.", "could not connect: %v", err)
+		for _, name := range controlPlaneChecks {
+			d.report(name, checkSkip, "", "control plane unreachable")
+		}
+		return
+	}
+	defer cc.Close()
+	mgmt := apb.NewManagementClient(cc)
+
+	rctx, rctxC := context.WithTimeout(ctx, doctorRPCTimeout)
+	info, err := mgmt.GetClusterInfo(rctx, &apb.GetClusterInfoRequest{})
+	rctxC()
+	if err != nil {
+		d.report("control plane", checkFail, "Check that the given endpoints are correct and reachable from this machine, and that at least one consensus member is running.", "GetClusterInfo failed: %v", err)
+		for _, name := range controlPlaneChecks {
+			d.report(name, checkSkip, "", "control plane unreachable")
+		}
+		return
+	}
+	d.report("control plane", checkPass, "", "reachable, %d nodes in cluster directory", len(info.ClusterDirectory.GetNodes()))
+
+	ca, err := x509.ParseCertificate(info.CaCertificate)
+	if err != nil {
+		d.report("cluster CA certificate", checkFail, "", "could not parse: %v", err)
+	} else {
+		d.checkCertificate("cluster CA certificate", ca, "The cluster CA cannot currently be rotated, plan a cluster migration.")
+	}
+
+	rctx, rctxC = context.WithTimeout(ctx, doctorRPCTimeout)
+	nodes, err := core.GetNodes(rctx, mgmt, "")
+	rctxC()
+	if err != nil {
+		for _, name := range controlPlaneChecks[1:] {
+			d.report(name, checkSkip, "", "GetNodes failed: %v", err)
+		}
+		return
+	}
+	d.checkConsensus(nodes)
+	d.checkHeartbeats(nodes)
+	if ca != nil {
+		d.checkConsensusStorage(ctx, nodes, ca)
+	} else {
+		d.report("consensus storage", checkSkip, "", "no valid cluster CA certificate")
+	}
+	d.checkTimeSync(nodes)
+}
+
+// checkCertificate checks the validity period of a certificate.
+func (d *doctor) checkCertificate(name string, cert *x509.Certificate, hint string) {
+	now := time.Now()
+	switch left := cert.NotAfter.Sub(now); {
+	case now.Before(cert.NotBefore):
+		d.report(name, checkFail, "Check the clock of this machine.", "not valid before %s", cert.NotBefore.Format(time.RFC3339))
+	case left <= 0:
+		d.report(name, checkFail, hint, "expired at %s", cert.NotAfter.Format(time.RFC3339))
+	case left < doctorCertWarnThreshold:
+		d.report(name, checkWarn, hint, "expires in %s (at %s)", left.Round(time.Hour), cert.NotAfter.Format(time.RFC3339))
+	default:
+		d.report(name, checkPass, "", "valid until %s", cert.NotAfter.Format(time.RFC3339))
+	}
+}
+
+// checkConsensus checks that a majority of consensus members is healthy.
+func (d *doctor) checkConsensus(nodes []*apb.Node) {
+	const name = "consensus"
+	var members, unhealthy []string
+	for _, n := range nodes {
+		if n.Roles.GetConsensusMember() == nil {
+			continue
+		}
+		members = append(members, n.Id)
+		if n.Health != apb.Node_HEALTHY {
+			unhealthy = append(unhealthy, n.Id)
+		}
+	}
+	healthy := len(members) - len(unhealthy)
+	switch {
+	case len(members) == 0:
+		d.report(name, checkFail, "", "no consensus members found")
+	case healthy*2 <= len(members):
+		d.report(name, checkFail, "Bring the unhealthy members back up, the cluster cannot make progress without a majority of consensus members.", "only %d of %d members healthy, quorum lost (unhealthy: %s)", healthy, len(members), strings.Join(unhealthy, ", "))
+	case len(unhealthy) > 0:
+		d.report(name, checkWarn, "Investigate the unhealthy members using 'metroctl node logs'.", "%d of %d members healthy (unhealthy: %s)", healthy, len(members), strings.Join(unhealthy, ", "))
+	case len(members) == 1:
+		d.report(name, checkWarn, "Add the ConsensusMember role to two more nodes to tolerate node failures.", "single member, no redundancy")
+	default:
+		d.report(name, checkPass, "", "all %d members healthy", len(members))
+	}
+}
+
+// checkHeartbeats checks that all UP nodes recently sent heartbeats.
+func (d *doctor) checkHeartbeats(nodes []*apb.Node) {
+	const name = "node heartbeats"
+	var up int
+	var timeout, unknown []string
+	for _, n := range nodes {
+		if n.State != cpb.NodeState_NODE_STATE_UP {
+			continue
+		}
+		up++
+		switch n.Health {
+		case apb.Node_HEARTBEAT_TIMEOUT:
+			timeout = append(timeout, fmt.Sprintf("%s (%s ago)", n.Id, n.TimeSinceHeartbeat.AsDuration().Round(time.Second)))
+		case apb.Node_HEALTHY:
+		default:
+			unknown = append(unknown, n.Id)
+		}
+	}
+	switch {
+	case len(timeout) > 0:
+		d.report(name, checkFail, "Check that the nodes are running and can reach the cluster network.", "%d of %d nodes timing out: %s", len(timeout), up, strings.Join(timeout, ", "))
+	case len(unknown) > 0:
+		d.report(name, checkWarn, "The curator leader has likely just changed, retry in a few seconds.", "health of %d nodes unknown: %s", len(unknown), strings.Join(unknown, ", "))
+	default:
+		d.report(name, checkPass, "", "all %d nodes sending heartbeats", up)
+	}
+}
+
+// checkConsensusStorage checks the fill level of the data partitions of all
+// consensus members, which hold the etcd database. The members are verified
+// against the given cluster CA certificate. Members which cannot be reached
+// result in a warning, but do not prevent checking the others.
+func (d *doctor) checkConsensusStorage(ctx context.Context, nodes []*apb.Node, cacert *x509.Certificate) {
+	const name = "consensus storage"
+	const hint = "Free up space on the affected nodes, etcd stops accepting writes when its storage is full."
+	status := checkPass
+	var details []string
+	for _, n := range nodes {
+		if n.Roles.GetConsensusMember() == nil {
+			continue
+		}
+		if n.Status.GetExternalAddress() == "" {
+			status = worseStatus(status, checkWarn)
+			details = append(details, fmt.Sprintf("%s: no external address", n.Id))
+			continue
+		}
+		cl, err := d.dialNode(ctx, n.Id, n.Status.ExternalAddress, cacert)
+		if err != nil {
+			status = worseStatus(status, checkWarn)
+			details = append(details, fmt.Sprintf("%s: could not connect: %v", n.Id, err))
+			continue
+		}
+		rctx, rctxC := context.WithTimeout(ctx, doctorRPCTimeout)
+		res, err := apb.NewNodeManagementClient(cl).GetStorageInfo(rctx, &apb.GetStorageInfoRequest{})
+		rctxC()
+		cl.Close()
+		if err != nil {
+			status = worseStatus(status, checkWarn)
+			details = append(details, fmt.Sprintf("%s: GetStorageInfo failed: %v", n.Id, err))
+			continue
+		}
+		var percent uint64
+		if res.DataBytesTotal != 0 {
+			percent = (res.DataBytesTotal - res.DataBytesAvailable) * 100 / res.DataBytesTotal
+		}
+		switch {
+		case percent >= doctorStorageFailPercent:
+			status = worseStatus(status, checkFail)
+		case percent >= doctorStorageWarnPercent:
+			status = worseStatus(status, checkWarn)
+		}
+		details = append(details, fmt.Sprintf("%s: %d%% used", n.Id, percent))
+	}
+	if len(details) == 0 {
+		d.report(name, checkSkip, "", "no consensus members found")
+		return
+	}
+	d.report(name, status, hint, "%s", strings.Join(details, ", "))
+}
+
+// checkTimeSync checks for nodes whose clocks are ahead of the local clock,
+// by comparing the timestamps of their last status updates against the
+// current time. As status updates are only sent sporadically, this cannot
+// detect clocks which are behind.
+func (d *doctor) checkTimeSync(nodes []*apb.Node) {
+	const name = "time sync"
+	now := time.Now()
+	var checked int
+	var skewed []string
+	for _, n := range nodes {
+		ts := n.Status.GetTimestamp()
+		if ts == nil {
+			continue
+		}
+		checked++
+		if ahead := ts.AsTime().Sub(now); ahead > doctorClockSkewThreshold {
+			skewed = append(skewed, fmt.Sprintf("%s (%s ahead)", n.Id, ahead.Round(time.Second)))
+		}
+	}
+	switch {
+	case checked == 0:
+		d.report(name, checkSkip, "", "no node reported its status yet")
+	case len(skewed) > 0:
+		d.report(name, checkWarn, "Check the time synchronization of the affected nodes and of this machine.", "clocks ahead of local clock: %s", strings.Join(skewed, ", "))
+	default:
+		d.report(name, checkPass, "", "no clock skew detected on %d nodes", checked)
+	}
+}
+
+// worseStatus returns the more severe of two check statuses.
+func worseStatus(a, b checkStatus) checkStatus {
+	severity := map[checkStatus]int{
+		checkPass: 0,
+		checkSkip: 1,
+		checkWarn: 2,
+		checkFail: 3,
+	}
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
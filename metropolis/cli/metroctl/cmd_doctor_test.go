@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// TestDoctorDialFailure ensures that failing to connect to the cluster, eg.
+// because of missing owner credentials, results in failed checks instead of
+// aborting the doctor.
+func TestDoctorDialFailure(t *testing.T) {
+	configPath := flags.configPath
+	defer func() { flags.configPath = configPath }()
+	// An empty configuration directory without any owner credentials.
+	flags.configPath = t.TempDir()
+
+	d := &doctor{
+		dial: func(ctx context.Context) (*grpc.ClientConn, error) {
+			return nil, errors.New("you have to take ownership of the cluster first")
+		},
+		dialNode: func(ctx context.Context, id, address string, cacert *x509.Certificate) (*grpc.ClientConn, error) {
+			t.Errorf("dialNode called for %s", id)
+			return nil, errors.New("unexpected dial")
+		},
+	}
+	d.run(context.Background())
+
+	want := []struct {
+		name   string
+		status checkStatus
+	}{
+		{"owner certificate", checkFail},
+		{"control plane", checkFail},
+		{"cluster CA certificate", checkSkip},
+		{"consensus", checkSkip},
+		{"node heartbeats", checkSkip},
+		{"consensus storage", checkSkip},
+		{"time sync", checkSkip},
+	}
+	if len(d.results) != len(want) {
+		t.Fatalf("Wanted %d results, got %d: %v", len(want), len(d.results), d.results)
+	}
+	for i, w := range want {
+		r := d.results[i]
+		if r.name != w.name || r.status != w.status {
+			t.Errorf("Result %d: wanted %s %s, got %s %s (%s)", i, w.status, w.name, r.status, r.name, r.detail)
+		}
+	}
+	if !strings.Contains(d.results[1].detail, "take ownership") {
+		t.Errorf("Control plane result does not contain dial error: %q", d.results[1].detail)
+	}
+}
+
+// TestDoctorConsensusStorageDialFailure ensures that consensus members which
+// cannot be dialed result in a warning, without preventing the remaining
+// members from being checked.
+func TestDoctorConsensusStorageDialFailure(t *testing.T) {
+	member := func(id, address string) *apb.Node {
+		return &apb.Node{
+			Id: id,
+			Roles: &cpb.NodeRoles{
+				ConsensusMember: &cpb.NodeRoles_ConsensusMember{},
+			},
+			Status: &cpb.NodeStatus{
+				ExternalAddress: address,
+			},
+		}
+	}
+	nodes := []*apb.Node{
+		member("metropolis-1", "10.0.0.1"),
+		member("metropolis-2", ""),
+		member("metropolis-3", "10.0.0.3"),
+		// Not a consensus member, must not be dialed.
+		{Id: "metropolis-4", Status: &cpb.NodeStatus{ExternalAddress: "10.0.0.4"}},
+	}
+
+	var dialed []string
+	d := &doctor{
+		dialNode: func(ctx context.Context, id, address string, cacert *x509.Certificate) (*grpc.ClientConn, error) {
+			dialed = append(dialed, id)
+			return nil, errors.New("could not get owner credentials")
+		},
+	}
+	d.checkConsensusStorage(context.Background(), nodes, &x509.Certificate{})
+
+	if want, got := "metropolis-1,metropolis-3", strings.Join(dialed, ","); want != got {
+		t.Errorf("Wanted nodes %s to be dialed, got %s", want, got)
+	}
+	if len(d.results) != 1 {
+		t.Fatalf("Wanted one result, got %d: %v", len(d.results), d.results)
+	}
+	r := d.results[0]
+	if r.status != checkWarn {
+		t.Errorf("Wanted status %s, got %s", checkWarn, r.status)
+	}
+	for _, want := range []string{
+		"metropolis-1: could not connect: could not get owner credentials",
+		"metropolis-2: no external address",
+		"metropolis-3: could not connect: could not get owner credentials",
+	} {
+		if !strings.Contains(r.detail, want) {
+			t.Errorf("Result detail %q does not contain %q", r.detail, want)
+		}
+	}
+}
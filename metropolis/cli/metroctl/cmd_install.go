@@ -33,6 +33,7 @@ var installCmd = &cobra.Command{
 var bootstrap = installCmd.PersistentFlags().Bool("bootstrap", false, "Create a bootstrap installer image.")
 var bootstrapTPMMode = installCmd.PersistentFlags().String("bootstrap-tpm-mode", "required", "TPM mode to set on cluster (required, best-effort, disabled)")
 var bootstrapStorageSecurityPolicy = installCmd.PersistentFlags().String("bootstrap-storage-security", "needs-encryption-and-authentication", "Storage security policy to set on cluster (permissive, needs-encryption, needs-encryption-and-authentication, needs-insecure)")
+var storageCipher = installCmd.PersistentFlags().String("storage-cipher", "aes-gcm", "Cipher used for the data partition of the node if its storage is encrypted and authenticated (aes-gcm, chacha20-poly1305)")
 var bundlePath = installCmd.PersistentFlags().StringP("bundle", "b", "", "Path to the Metropolis bundle to be installed")
 
 func makeNodeParams() *api.NodeParameters {
@@ -63,6 +64,16 @@ func makeNodeParams() *api.NodeParameters {
 		log.Fatalf("Invalid --bootstrap-storage-security (must be one of: permissive, needs-encryption, needs-encryption-and-authentication, needs-insecure)")
 	}
 
+	var nodeStorageCipher cpb.NodeStorageCipher
+	switch strings.ToLower(*storageCipher) {
+	case "aes-gcm":
+		nodeStorageCipher = cpb.NodeStorageCipher_NODE_STORAGE_CIPHER_AES_GCM
+	case "chacha20-poly1305":
+		nodeStorageCipher = cpb.NodeStorageCipher_NODE_STORAGE_CIPHER_CHACHA20_POLY1305
+	default:
+		log.Fatalf("Invalid --storage-cipher (must be one of: aes-gcm, chacha20-poly1305)")
+	}
+
 	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
 
 	if err := os.MkdirAll(flags.configPath, 0700); err != nil && !os.IsExist(err) {
@@ -89,7 +100,10 @@ func makeNodeParams() *api.NodeParameters {
 			},
 		}
 	} else {
-		cc := dialAuthenticated(ctx)
+		cc, err := dialAuthenticated(ctx)
+		if err != nil {
+			log.Fatalf("Could not connect to cluster: %v", err)
+		}
 		mgmt := api.NewManagementClient(cc)
 		resT, err := mgmt.GetRegisterTicket(ctx, &api.GetRegisterTicketRequest{})
 		if err != nil {
@@ -110,6 +124,7 @@ func makeNodeParams() *api.NodeParameters {
 			},
 		}
 	}
+	params.StorageCipher = nodeStorageCipher
 	return params
 }
 
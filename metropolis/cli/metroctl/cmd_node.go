@@ -2,18 +2,21 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/semaphore"
+	"sigs.k8s.io/yaml"
 
 	"source.monogon.dev/go/clitable"
 	"source.monogon.dev/metropolis/cli/metroctl/core"
@@ -34,7 +37,10 @@ var nodeDescribeCmd = &cobra.Command{
 	Example: "metroctl node describe metropolis-c556e31c3fa2bf0a36e9ccb9fd5d6056",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
-		cc := dialAuthenticated(ctx)
+		cc, err := dialAuthenticated(ctx)
+		if err != nil {
+			log.Fatalf("Could not connect to cluster: %v", err)
+		}
 		mgmt := apb.NewManagementClient(cc)
 
 		nodes, err := core.GetNodes(ctx, mgmt, flags.filter)
@@ -57,20 +63,71 @@ var nodeDescribeCmd = &cobra.Command{
 }
 
 var nodeListCmd = &cobra.Command{
-	Short:   "Lists cluster nodes.",
+	Short: "Lists cluster nodes.",
+	Long: `Lists cluster nodes.
+
+For every node, its ID, state, health, roles and external address are shown.
+The output format can be selected with --format, which can be one of:
+
+  - plaintext (or table): a column-aligned table, the default
+  - json: a JSON array of nodes
+  - yaml: a YAML list of nodes
+`,
 	Use:     "list [node-id] [--filter] [--output] [--format]",
 	Example: "metroctl node list --filter node.status.external_address==\"10.8.0.2\"",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
-		cc := dialAuthenticated(ctx)
+		cc, err := dialAuthenticated(ctx)
+		if err != nil {
+			log.Fatalf("Could not connect to cluster: %v", err)
+		}
 		mgmt := apb.NewManagementClient(cc)
 
 		nodes, err := core.GetNodes(ctx, mgmt, flags.filter)
 		if err != nil {
 			log.Fatalf("While calling Management.GetNodes: %v", err)
 		}
-
-		printNodes(nodes, args, map[string]bool{"node id": true})
+		sort.Slice(nodes, func(i, j int) bool {
+			return identity.NodeID(nodes[i].Pubkey) < identity.NodeID(nodes[j].Pubkey)
+		})
+
+		switch flags.format {
+		case "plaintext", "table":
+			printNodes(nodes, args, map[string]bool{
+				"node id": true,
+				"state":   true,
+				"address": true,
+				"health":  true,
+				"roles":   true,
+			})
+		case "json", "yaml":
+			var items []nodeListItem
+			for _, n := range filterNodes(nodes, args) {
+				items = append(items, newNodeListItem(n))
+			}
+			if items == nil {
+				items = []nodeListItem{}
+			}
+			out, err := json.MarshalIndent(items, "", "  ")
+			if err != nil {
+				log.Fatalf("While marshaling nodes: %v", err)
+			}
+			if flags.format == "yaml" {
+				out, err = yaml.JSONToYAML(out)
+				if err != nil {
+					log.Fatalf("While converting nodes to YAML: %v", err)
+				}
+			} else {
+				out = append(out, '\n')
+			}
+			o := outputWriter()
+			defer o.Close()
+			if _, err := o.Write(out); err != nil {
+				log.Fatalf("While writing output: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown output format %q, must be one of: plaintext, table, json, yaml", flags.format)
+		}
 	},
 	Args: cobra.ArbitraryArgs,
 }
@@ -122,7 +179,11 @@ var nodeUpdateCmd = &cobra.Command{
 			return fmt.Errorf("could not get CA certificate: %w", err)
 		}
 
-		mgmt := apb.NewManagementClient(dialAuthenticated(ctx))
+		cc, err := dialAuthenticated(ctx)
+		if err != nil {
+			return fmt.Errorf("could not connect to cluster: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
 
 		nodes, err := core.GetNodes(ctx, mgmt, "")
 		if err != nil {
@@ -171,11 +232,16 @@ var nodeUpdateCmd = &cobra.Command{
 
 			go func(n *apb.Node) {
 				defer wg.Done()
-				cc := dialAuthenticatedNode(ctx, n.Id, n.Status.ExternalAddress, cacert)
+				cc, err := dialAuthenticatedNode(ctx, n.Id, n.Status.ExternalAddress, cacert)
+				if err != nil {
+					log.Printf("could not connect to node %s: %v", n.Id, err)
+					unavailableSemaphore.Release(1)
+					return
+				}
 				nodeMgmt := apb.NewNodeManagementClient(cc)
 				log.Printf("sending update request to: %s (%s)", n.Id, n.Status.ExternalAddress)
 				start := time.Now()
-				_, err := nodeMgmt.UpdateNode(ctx, updateReq)
+				_, err = nodeMgmt.UpdateNode(ctx, updateReq)
 				if err != nil {
 					log.Printf("update request to node %s failed: %v", n.Id, err)
 					// A failed UpdateNode does not mean that the node is now unavailable as it
@@ -244,7 +310,11 @@ var nodeDeleteCmd = &cobra.Command{
 		}
 
 		ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
-		mgmt := apb.NewManagementClient(dialAuthenticated(ctx))
+		cc, err := dialAuthenticated(ctx)
+		if err != nil {
+			return fmt.Errorf("could not connect to cluster: %w", err)
+		}
+		mgmt := apb.NewManagementClient(cc)
 
 		nodes, err := core.GetNodes(ctx, mgmt, fmt.Sprintf("node.id==%q", args[0]))
 		if err != nil {
@@ -298,33 +368,46 @@ func init() {
 	rootCmd.AddCommand(nodeCmd)
 }
 
-func printNodes(nodes []*apb.Node, args []string, onlyColumns map[string]bool) {
-	o := io.WriteCloser(os.Stdout)
-	if flags.output != "" {
-		of, err := os.Create(flags.output)
-		if err != nil {
-			log.Fatalf("Couldn't create the output file at %s: %v", flags.output, err)
-		}
-		o = of
+// outputWriter returns the file given by the --output flag, or stdout if it
+// is not set.
+func outputWriter() io.WriteCloser {
+	if flags.output == "" {
+		return os.Stdout
+	}
+	of, err := os.Create(flags.output)
+	if err != nil {
+		log.Fatalf("Couldn't create the output file at %s: %v", flags.output, err)
 	}
+	return of
+}
 
-	// Narrow down the output set to supplied node IDs, if any.
+// filterNodes narrows down nodes to the node IDs supplied in args, if any.
+func filterNodes(nodes []*apb.Node, args []string) []*apb.Node {
 	qids := make(map[string]bool)
 	if len(args) != 0 && args[0] != "all" {
 		for _, a := range args {
 			qids[a] = true
 		}
 	}
+	if len(qids) == 0 {
+		return nodes
+	}
 
-	var t clitable.Table
+	var res []*apb.Node
 	for _, n := range nodes {
 		// Filter the information we want client-side.
-		if len(qids) != 0 {
-			nid := identity.NodeID(n.Pubkey)
-			if _, e := qids[nid]; !e {
-				continue
-			}
+		if _, e := qids[identity.NodeID(n.Pubkey)]; e {
+			res = append(res, n)
 		}
+	}
+	return res
+}
+
+func printNodes(nodes []*apb.Node, args []string, onlyColumns map[string]bool) {
+	o := outputWriter()
+
+	var t clitable.Table
+	for _, n := range filterNodes(nodes, args) {
 		t.Add(nodeEntry(n))
 	}
 
@@ -37,7 +37,10 @@ func nodeById(nodes []*api.Node, id string) *api.Node {
 
 func doApprove(cmd *cobra.Command, args []string) {
 	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
-	cc := dialAuthenticated(ctx)
+	cc, err := dialAuthenticated(ctx)
+	if err != nil {
+		log.Fatalf("Could not connect to cluster: %v", err)
+	}
 	mgmt := api.NewManagementClient(cc)
 
 	// Get a list of all nodes pending approval by calling Management.GetNodes.
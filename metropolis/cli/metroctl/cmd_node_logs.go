@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -25,6 +26,11 @@ type metroctlLogFlags struct {
 	concise bool
 	// backlog: >0 for a concrete limit, -1 for all, 0 for none
 	backlog int
+	// tail is an alternative way to set backlog, as a concrete limit.
+	tail int
+	// severity is the minimum severity of leveled log entries to return, or empty
+	// for all.
+	severity string
 }
 
 var logFlags metroctlLogFlags
@@ -51,16 +57,41 @@ To stream logs, use --follow.
 By default, all available logs are returned. To limit the number of historical
 log lines (a.k.a. 'backlog') to return, set --backlog. This similar to requesting
 all lines and then piping the result through 'tail' - but more efficient, as no
-unnecessary lines are fetched.
+unnecessary lines are fetched. --tail N is a shorthand for --backlog N.
+
+To only return leveled log entries at or above a given severity, set --severity
+to one of INFO, WARNING, ERROR or FATAL. As raw log entries carry no severity,
+they are not returned when --severity is set.
 `,
 	Use:  "logs [node-id]",
 	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 
+		if cmd.Flags().Changed("tail") {
+			if cmd.Flags().Changed("backlog") {
+				return fmt.Errorf("--tail and --backlog are mutually exclusive")
+			}
+			if logFlags.tail < 1 {
+				return fmt.Errorf("--tail must be at least 1")
+			}
+			logFlags.backlog = logFlags.tail
+		}
+		var severity logtree.Severity
+		if logFlags.severity != "" {
+			var err error
+			severity, err = parseSeverity(logFlags.severity)
+			if err != nil {
+				return err
+			}
+		}
+
 		// First connect to the main management service and figure out the node's IP
 		// address.
-		cc := dialAuthenticated(ctx)
+		cc, err := dialAuthenticated(ctx)
+		if err != nil {
+			return fmt.Errorf("could not connect to cluster: %w", err)
+		}
 		mgmt := api.NewManagementClient(cc)
 		nodes, err := core.GetNodes(ctx, mgmt, fmt.Sprintf("node.id == %q", args[0]))
 		if err != nil {
@@ -85,7 +116,10 @@ unnecessary lines are fetched.
 
 		fmt.Printf("=== Logs from %s (%s):\n", n.Id, n.Status.ExternalAddress)
 		// Dial the actual node at its management port.
-		cl := dialAuthenticatedNode(ctx, n.Id, n.Status.ExternalAddress, cacert)
+		cl, err := dialAuthenticatedNode(ctx, n.Id, n.Status.ExternalAddress, cacert)
+		if err != nil {
+			return fmt.Errorf("could not connect to node: %w", err)
+		}
 		nmgmt := api.NewNodeManagementClient(cl)
 
 		streamMode := api.GetLogsRequest_STREAM_DISABLE
@@ -100,6 +134,15 @@ unnecessary lines are fetched.
 				},
 			})
 		}
+		if severity != "" {
+			filters = append(filters, &cpb.LogFilter{
+				Filter: &cpb.LogFilter_LeveledWithMinimumSeverity_{
+					LeveledWithMinimumSeverity: &cpb.LogFilter_LeveledWithMinimumSeverity{
+						Minimum: severity.ToProto(),
+					},
+				},
+			})
+		}
 		backlogMode := api.GetLogsRequest_BACKLOG_ALL
 		var backlogCount int64
 		switch {
@@ -141,6 +184,22 @@ unnecessary lines are fetched.
 	},
 }
 
+// parseSeverity parses a severity as given to --severity, either by its full
+// name or its single-letter logtree representation.
+func parseSeverity(s string) (logtree.Severity, error) {
+	switch strings.ToUpper(s) {
+	case "I", "INFO":
+		return logtree.INFO, nil
+	case "W", "WARNING":
+		return logtree.WARNING, nil
+	case "E", "ERROR":
+		return logtree.ERROR, nil
+	case "F", "FATAL":
+		return logtree.FATAL, nil
+	}
+	return "", fmt.Errorf("invalid severity %q, must be one of INFO, WARNING, ERROR, FATAL", s)
+}
+
 func printEntry(e *lpb.LogEntry) {
 	entry, err := logtree.LogEntryFromProto(e)
 	if err != nil {
@@ -160,5 +219,7 @@ func init() {
 	nodeLogsCmd.Flags().BoolVarP(&logFlags.exact, "exact", "e", false, "Only show logs for exactly the DN, do not recurse down the tree.")
 	nodeLogsCmd.Flags().BoolVarP(&logFlags.concise, "concise", "c", false, "Output concise logs.")
 	nodeLogsCmd.Flags().IntVar(&logFlags.backlog, "backlog", -1, "How many lines of historical log data to return. The default (-1) returns all available lines. Zero value means no backlog is returned (useful when using --follow).")
+	nodeLogsCmd.Flags().IntVarP(&logFlags.tail, "tail", "n", 0, "Return only the last N lines of historical log data. Shorthand for --backlog N.")
+	nodeLogsCmd.Flags().StringVar(&logFlags.severity, "severity", "", "Minimum severity of leveled log entries to return (INFO, WARNING, ERROR, FATAL). If not set, all entries are returned.")
 	nodeCmd.AddCommand(nodeLogsCmd)
 }
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
-	"source.monogon.dev/metropolis/cli/metroctl/core"
 	common "source.monogon.dev/metropolis/node"
 	"source.monogon.dev/metropolis/proto/api"
 )
@@ -26,7 +29,8 @@ credentials as used to manage the cluster, and is designed to be used as a
 troubleshooting tool when a proper metrics collection system has not been set up
 for the cluster.
 
-A node ID and exporter must be provided. Currently available exporters are:
+A node ID and at least one exporter must be provided. Currently available
+exporters are:
 
   - node: node_exporter metrics for the node
   - etcd: etcd metrics, if the node is running the cluster control plane
@@ -35,45 +39,111 @@ A node ID and exporter must be provided. Currently available exporters are:
     Kubernetes control plane
   - containerd: containerd metrics, if the node is a Kubernetes worker
 
+If a single exporter is given and --output-dir is not set, its metrics are
+written to stdout, or to the file given by --output. Otherwise, --output-dir
+must be set, and the metrics of every given exporter will be written to a file
+named after the exporter (eg. node.prom, etcd.prom) within that directory.
 `,
-	Use:  "metrics [node-id] [exporter]",
+	Use:  "metrics [node-id] [exporter...] [--output] [--output-dir]",
 	Args: cobra.MinimumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
+		exporters := args[1:]
+		outputDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return err
+		}
+		if outputDir == "" && len(exporters) > 1 {
+			return fmt.Errorf("--output-dir must be set when requesting multiple exporters")
+		}
+		if outputDir != "" && flags.output != "" {
+			return fmt.Errorf("--output and --output-dir are mutually exclusive")
+		}
 
 		// First connect to the main management service and figure out the node's IP
 		// address.
-		cc := dialAuthenticated(ctx)
-		mgmt := api.NewManagementClient(cc)
-		nodes, err := core.GetNodes(ctx, mgmt, fmt.Sprintf("node.id == %q", args[0]))
+		cc, err := dialAuthenticated(ctx)
 		if err != nil {
-			return fmt.Errorf("when getting node info: %w", err)
+			return fmt.Errorf("could not connect to cluster: %w", err)
 		}
-
-		if len(nodes) == 0 {
+		mgmt := api.NewManagementClient(cc)
+		n, err := mgmt.GetNode(ctx, &api.GetNodeRequest{
+			Node: &api.GetNodeRequest_Id{Id: args[0]},
+		})
+		if status.Code(err) == codes.NotFound {
 			return fmt.Errorf("no such node")
 		}
-		if len(nodes) > 1 {
-			return fmt.Errorf("expression matched more than one node")
+		if err != nil {
+			return fmt.Errorf("when getting node info: %w", err)
 		}
-		n := nodes[0]
 		if n.Status == nil || n.Status.ExternalAddress == "" {
 			return fmt.Errorf("node has no external address")
 		}
 
-		client := http.Client{
+		client := &http.Client{
 			Transport: newAuthenticatedNodeHTTPTransport(ctx, n.Id),
 		}
-		res, err := client.Get(fmt.Sprintf("https://%s/metrics/%s", net.JoinHostPort(n.Status.ExternalAddress, common.MetricsPort.PortString()), args[1]))
-		if err != nil {
-			return fmt.Errorf("metrics HTTP request failed: %v", err)
+		if outputDir == "" {
+			o := outputWriter()
+			defer o.Close()
+			return fetchMetrics(ctx, client, n.Status.ExternalAddress, exporters[0], o)
 		}
-		defer res.Body.Close()
-		_, err = io.Copy(os.Stdout, res.Body)
-		return err
+
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return fmt.Errorf("could not create output directory: %w", err)
+		}
+		var failed int
+		for _, exporter := range exporters {
+			path := filepath.Join(outputDir, exporter+".prom")
+			if err := fetchMetricsToFile(ctx, client, n.Status.ExternalAddress, exporter, path); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: failed: %v\n", exporter, err)
+				failed += 1
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%s: written to %s\n", exporter, path)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d out of %d exporters failed", failed, len(exporters))
+		}
+		return nil
 	},
 }
 
+// fetchMetrics retrieves the metrics of a given exporter from the node at
+// address and writes them to w.
+func fetchMetrics(ctx context.Context, client *http.Client, address, exporter string, w io.Writer) error {
+	url := fmt.Sprintf("https://%s/metrics/%s", net.JoinHostPort(address, common.MetricsPort.PortString()), exporter)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("metrics HTTP request failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("metrics HTTP request failed: %s", res.Status)
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// fetchMetricsToFile works like fetchMetrics, but writes the metrics to a file
+// at path.
+func fetchMetricsToFile(ctx context.Context, client *http.Client, address, exporter, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := fetchMetrics(ctx, client, address, exporter, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func init() {
+	nodeMetricsCmd.Flags().String("output-dir", "", "Directory to which the metrics of every exporter are written")
 	nodeCmd.AddCommand(nodeMetricsCmd)
 }
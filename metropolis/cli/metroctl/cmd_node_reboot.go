@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/proto/api"
+)
+
+var nodeRebootCmd = &cobra.Command{
+	Short:   "Reboots a node.",
+	Use:     "reboot [node-id]",
+	Example: "metroctl node reboot metropolis-25fa5f5e9349381d4a5e9e59de0215e3",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rebootNode(cmd, args[0], api.RebootRequest_TYPE_REBOOT)
+	},
+}
+
+var nodeShutdownCmd = &cobra.Command{
+	Short: "Powers off a node.",
+	Long: `Powers off a node.
+
+The node needs to be powered on manually afterwards. Powering off a consensus
+member reduces the fault tolerance of the cluster and can cause it to lose
+quorum, so unless --yes is given, confirmation is requested interactively.
+`,
+	Use:     "shutdown [node-id] [--yes]",
+	Example: "metroctl node shutdown metropolis-25fa5f5e9349381d4a5e9e59de0215e3",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rebootNode(cmd, args[0], api.RebootRequest_TYPE_POWER_OFF)
+	},
+}
+
+// rebootNode requests the given node to reboot or power off via its
+// NodeManagement service. Powering off requires confirmation, either
+// interactively or via the --yes flag.
+func rebootNode(cmd *cobra.Command, id string, typ api.RebootRequest_Type) error {
+	ctx := cmd.Context()
+
+	// First connect to the main management service and figure out the node's IP
+	// address.
+	cc, err := dialAuthenticated(ctx)
+	if err != nil {
+		return fmt.Errorf("could not connect to cluster: %w", err)
+	}
+	mgmt := api.NewManagementClient(cc)
+	n, err := mgmt.GetNode(ctx, &api.GetNodeRequest{
+		Node: &api.GetNodeRequest_Id{Id: id},
+	})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("no such node")
+	}
+	if err != nil {
+		return fmt.Errorf("when getting node info: %w", err)
+	}
+	if n.Status == nil || n.Status.ExternalAddress == "" {
+		return fmt.Errorf("node has no external address")
+	}
+
+	if typ == api.RebootRequest_TYPE_POWER_OFF {
+		yes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			return err
+		}
+		if !yes {
+			if n.Roles.GetConsensusMember() != nil {
+				fmt.Printf("Node %s is a consensus member, powering it off reduces the fault tolerance of the cluster.\n", n.Id)
+			}
+			fmt.Printf("Are you sure you want to power off node %s (yes/no)? ", n.Id)
+			res, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("while reading confirmation: %w", err)
+			}
+			if strings.TrimSpace(res) != "yes" {
+				return fmt.Errorf("aborted")
+			}
+		}
+	}
+
+	cacert, err := core.GetClusterCAWithTOFU(ctx, connectOptions())
+	if err != nil {
+		return fmt.Errorf("could not get CA certificate: %w", err)
+	}
+
+	// Dial the actual node at its management port.
+	cl, err := dialAuthenticatedNode(ctx, n.Id, n.Status.ExternalAddress, cacert)
+	if err != nil {
+		return fmt.Errorf("could not connect to node: %w", err)
+	}
+	nmgmt := api.NewNodeManagementClient(cl)
+	if _, err := nmgmt.Reboot(ctx, &api.RebootRequest{Type: typ}); err != nil {
+		return fmt.Errorf("Reboot: %w", err)
+	}
+
+	if typ == api.RebootRequest_TYPE_POWER_OFF {
+		fmt.Printf("Node %s is powering off.\n", n.Id)
+	} else {
+		fmt.Printf("Node %s is rebooting.\n", n.Id)
+	}
+	return nil
+}
+
+func init() {
+	nodeShutdownCmd.Flags().Bool("yes", false, "Do not ask for confirmation")
+
+	nodeCmd.AddCommand(nodeRebootCmd)
+	nodeCmd.AddCommand(nodeShutdownCmd)
+}
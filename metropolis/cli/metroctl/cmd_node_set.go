@@ -39,6 +39,7 @@ var removeRoleCmd = &cobra.Command{
 }
 
 func init() {
+	addRoleCmd.Flags().Uint32("max-volumes", 0, "Maximum number of persistent volumes which can be attached to pods on the nodes, or 0 for no limit. Only valid for the KubernetesWorker role.")
 	addCmd.AddCommand(addRoleCmd)
 	nodeCmd.AddCommand(addCmd)
 
@@ -48,7 +49,10 @@ func init() {
 
 func doAdd(cmd *cobra.Command, args []string) {
 	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
-	cc := dialAuthenticated(ctx)
+	cc, err := dialAuthenticated(ctx)
+	if err != nil {
+		log.Fatalf("Could not connect to cluster: %v", err)
+	}
 	mgmt := api.NewManagementClient(cc)
 
 	if len(args) < 2 {
@@ -58,6 +62,18 @@ func doAdd(cmd *cobra.Command, args []string) {
 	role := strings.ToLower(args[0])
 	nodes := args[1:]
 
+	var maxVolumes *uint32
+	if cmd.Flags().Changed("max-volumes") {
+		v, err := cmd.Flags().GetUint32("max-volumes")
+		if err != nil {
+			log.Fatalf("Invalid --max-volumes: %v", err)
+		}
+		maxVolumes = &v
+		if role != "kubernetesworker" && role != "kw" {
+			log.Fatalf("--max-volumes can only be set for the KubernetesWorker role")
+		}
+	}
+
 	opt := func(v bool) *bool { return &v }
 	for _, node := range nodes {
 		req := &api.UpdateNodeRolesRequest{
@@ -70,6 +86,7 @@ func doAdd(cmd *cobra.Command, args []string) {
 			req.KubernetesController = opt(true)
 		case "kubernetesworker", "kw":
 			req.KubernetesWorker = opt(true)
+			req.KubernetesWorkerMaxVolumes = maxVolumes
 		case "consensusmember", "cm":
 			req.ConsensusMember = opt(true)
 		default:
@@ -86,7 +103,10 @@ func doAdd(cmd *cobra.Command, args []string) {
 
 func doRemove(cmd *cobra.Command, args []string) {
 	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
-	cc := dialAuthenticated(ctx)
+	cc, err := dialAuthenticated(ctx)
+	if err != nil {
+		log.Fatalf("Could not connect to cluster: %v", err)
+	}
 	mgmt := api.NewManagementClient(cc)
 
 	if len(args) < 2 {
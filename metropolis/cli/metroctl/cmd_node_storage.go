@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"source.monogon.dev/metropolis/cli/metroctl/core"
+	"source.monogon.dev/metropolis/proto/api"
+)
+
+var nodeStorageCmd = &cobra.Command{
+	Short: "Inspect node storage",
+	Use:   "storage",
+}
+
+var nodeStorageInfoCmd = &cobra.Command{
+	Short: "Show storage information of a node",
+	Long: `Show storage information of a node.
+
+This displays the storage security mode of the node's data partition, the number
+of integrity errors detected on it since it has been mounted, its fill level and
+the quota utilization of all filesystem volumes provisioned on the node.
+`,
+	Use:  "info [node-id]",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		// First connect to the main management service and figure out the node's IP
+		// address.
+		cc, err := dialAuthenticated(ctx)
+		if err != nil {
+			return fmt.Errorf("could not connect to cluster: %w", err)
+		}
+		mgmt := api.NewManagementClient(cc)
+		n, err := mgmt.GetNode(ctx, &api.GetNodeRequest{
+			Node: &api.GetNodeRequest_Id{Id: args[0]},
+		})
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("no such node")
+		}
+		if err != nil {
+			return fmt.Errorf("when getting node info: %w", err)
+		}
+		if n.Status == nil || n.Status.ExternalAddress == "" {
+			return fmt.Errorf("node has no external address")
+		}
+
+		cacert, err := core.GetClusterCAWithTOFU(ctx, connectOptions())
+		if err != nil {
+			return fmt.Errorf("could not get CA certificate: %w", err)
+		}
+
+		// Dial the actual node at its management port.
+		cl, err := dialAuthenticatedNode(ctx, n.Id, n.Status.ExternalAddress, cacert)
+		if err != nil {
+			return fmt.Errorf("could not connect to node: %w", err)
+		}
+		nmgmt := api.NewNodeManagementClient(cl)
+
+		res, err := nmgmt.GetStorageInfo(ctx, &api.GetStorageInfoRequest{})
+		if err != nil {
+			return fmt.Errorf("GetStorageInfo: %w", err)
+		}
+		printStorageInfo(os.Stdout, n.Id, res)
+		return nil
+	},
+}
+
+// printStorageInfo writes the storage information of the node with the given
+// ID to w in a human-readable form.
+func printStorageInfo(w io.Writer, nodeID string, res *api.GetStorageInfoResponse) {
+	security := strings.TrimPrefix(res.StorageSecurity.String(), "NODE_STORAGE_SECURITY_")
+	fmt.Fprintf(w, "Node:                 %s\n", nodeID)
+	fmt.Fprintf(w, "Storage security:     %s\n", strings.ToLower(security))
+	if strings.Contains(security, "AUTHENTICATED") {
+		fmt.Fprintf(w, "Integrity mismatches: %d\n", res.IntegrityMismatches)
+	}
+	var used uint64
+	if res.DataBytesAvailable < res.DataBytesTotal {
+		used = res.DataBytesTotal - res.DataBytesAvailable
+	}
+	var percent float64
+	if res.DataBytesTotal != 0 {
+		percent = float64(used) / float64(res.DataBytesTotal) * 100
+	}
+	fmt.Fprintf(w, "Data partition:       %s / %s used (%.1f%%)\n", formatBytes(used), formatBytes(res.DataBytesTotal), percent)
+
+	if len(res.Volumes) == 0 {
+		fmt.Fprintf(w, "Volumes:              none\n")
+		return
+	}
+	fmt.Fprintf(w, "Volumes:\n")
+	for _, v := range res.Volumes {
+		bytesQuota, inodesQuota := "unlimited", "unlimited"
+		if v.BytesQuota != 0 {
+			bytesQuota = formatBytes(v.BytesQuota)
+		}
+		if v.InodesQuota != 0 {
+			inodesQuota = fmt.Sprintf("%d", v.InodesQuota)
+		}
+		fmt.Fprintf(w, "  %s: %s / %s, %d / %s inodes\n", v.Id, formatBytes(v.BytesUsed), bytesQuota, v.InodesUsed, inodesQuota)
+	}
+}
+
+// formatBytes returns a human-readable representation of a byte count using
+// binary prefixes.
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	nodeStorageCmd.AddCommand(nodeStorageInfoCmd)
+	nodeCmd.AddCommand(nodeStorageCmd)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"source.monogon.dev/metropolis/proto/api"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for _, te := range []struct {
+		bytes uint64
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{10 << 20, "10.0 MiB"},
+		{3 << 40, "3.0 TiB"},
+		{1 << 60, "1.0 EiB"},
+	} {
+		if got := formatBytes(te.bytes); got != te.want {
+			t.Errorf("formatBytes(%d): wanted %q, got %q", te.bytes, te.want, got)
+		}
+	}
+}
+
+func TestPrintStorageInfo(t *testing.T) {
+	for _, te := range []struct {
+		name string
+		res  *api.GetStorageInfoResponse
+		want string
+	}{
+		{
+			name: "Authenticated",
+			res: &api.GetStorageInfoResponse{
+				StorageSecurity:     cpb.NodeStorageSecurity_NODE_STORAGE_SECURITY_AUTHENTICATED_ENCRYPTED,
+				IntegrityMismatches: 3,
+				DataBytesTotal:      4 << 30,
+				DataBytesAvailable:  3 << 30,
+				Volumes: []*api.GetStorageInfoResponse_Volume{
+					{Id: "a", BytesQuota: 1 << 30, InodesQuota: 1000, BytesUsed: 512 << 20, InodesUsed: 10},
+					{Id: "b", BytesUsed: 100, InodesUsed: 1},
+				},
+			},
+			want: "Node:                 metropolis-1234\n" +
+				"Storage security:     authenticated_encrypted\n" +
+				"Integrity mismatches: 3\n" +
+				"Data partition:       1.0 GiB / 4.0 GiB used (25.0%)\n" +
+				"Volumes:\n" +
+				"  a: 512.0 MiB / 1.0 GiB, 10 / 1000 inodes\n" +
+				"  b: 100 B / unlimited, 1 / unlimited inodes\n",
+		},
+		{
+			// Integrity mismatches are not available without
+			// authentication.
+			name: "Encrypted",
+			res: &api.GetStorageInfoResponse{
+				StorageSecurity:    cpb.NodeStorageSecurity_NODE_STORAGE_SECURITY_ENCRYPTED,
+				DataBytesTotal:     2048,
+				DataBytesAvailable: 2048,
+			},
+			want: "Node:                 metropolis-1234\n" +
+				"Storage security:     encrypted\n" +
+				"Data partition:       0 B / 2.0 KiB used (0.0%)\n" +
+				"Volumes:              none\n",
+		},
+		{
+			name: "NoData",
+			res:  &api.GetStorageInfoResponse{},
+			want: "Node:                 metropolis-1234\n" +
+				"Storage security:     invalid\n" +
+				"Data partition:       0 B / 0 B used (0.0%)\n" +
+				"Volumes:              none\n",
+		},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printStorageInfo(&buf, "metropolis-1234", te.res)
+			if diff := cmp.Diff(te.want, buf.String()); diff != "" {
+				t.Errorf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
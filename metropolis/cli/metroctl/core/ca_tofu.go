@@ -41,6 +41,13 @@ type TerminalTOFU struct {
 	In io.Reader
 }
 
+// CertificateFingerprint returns the SHA256 fingerprint of a certificate in the
+// form shown to users when asking them to confirm a cluster CA certificate.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "SHA256:" + hex.EncodeToString(sum[:])
+}
+
 func (i *TerminalTOFU) Ask(ctx context.Context, connection *ConnectOptions, cert *x509.Certificate) (bool, error) {
 	out := i.Out
 	if out == nil {
@@ -57,10 +64,7 @@ func (i *TerminalTOFU) Ask(ctx context.Context, connection *ConnectOptions, cert
 	}
 	fmt.Fprintf(out, "The authenticity of the cluster %s can't be established.\n", clusterIdentity)
 
-	sum := sha256.New()
-	sum.Write(cert.Raw)
-	fingerprint := "SHA256:" + hex.EncodeToString(sum.Sum(nil))
-	fmt.Fprintf(out, "ED25519 key fingerprint of the cluster CA is %s.\n", fingerprint)
+	fmt.Fprintf(out, "ED25519 key fingerprint of the cluster CA is %s.\n", CertificateFingerprint(cert))
 
 	fmt.Fprintf(out, "Are you sure you want to continue connecting (yes/no)? ")
 
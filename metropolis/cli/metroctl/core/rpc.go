@@ -79,26 +79,42 @@ func DialNode(ctx context.Context, opkey ed25519.PrivateKey, ocert, ca *x509.Cer
 	return grpc.Dial(endpoint, dialOpts...)
 }
 
+// getNodesPageSize is the number of nodes retrieved per GetNodes call.
+const getNodesPageSize = 100
+
 // GetNodes retrieves node records, filtered by the supplied node filter
-// expression fexp.
+// expression fexp. Nodes are retrieved in pages, following the page tokens
+// returned by the cluster until all nodes have been retrieved.
 func GetNodes(ctx context.Context, mgmt api.ManagementClient, fexp string) ([]*api.Node, error) {
-	resN, err := mgmt.GetNodes(ctx, &api.GetNodesRequest{
-		Filter: fexp,
-	})
-	if err != nil {
-		return nil, err
-	}
-
 	var nodes []*api.Node
+	var token string
 	for {
-		node, err := resN.Recv()
-		if err == io.EOF {
-			break
-		}
+		resN, err := mgmt.GetNodes(ctx, &api.GetNodesRequest{
+			Filter:    fexp,
+			PageSize:  getNodesPageSize,
+			PageToken: token,
+		})
 		if err != nil {
 			return nil, err
 		}
-		nodes = append(nodes, node)
+
+		token = ""
+		for {
+			node, err := resN.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if node.NextPageToken != "" {
+				token = node.NextPageToken
+				continue
+			}
+			nodes = append(nodes, node)
+		}
+		if token == "" {
+			return nodes, nil
+		}
 	}
-	return nodes, nil
 }
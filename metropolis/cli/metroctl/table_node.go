@@ -26,18 +26,7 @@ func nodeEntry(n *apb.Node) clitable.Entry {
 	res.Add("address", address)
 	res.Add("health", n.Health.String())
 
-	var roles []string
-	if n.Roles.ConsensusMember != nil {
-		roles = append(roles, "ConsensusMember")
-	}
-	if n.Roles.KubernetesController != nil {
-		roles = append(roles, "KubernetesController")
-	}
-	if n.Roles.KubernetesWorker != nil {
-		roles = append(roles, "KubernetesWorker")
-	}
-	sort.Strings(roles)
-	res.Add("roles", strings.Join(roles, ","))
+	res.Add("roles", strings.Join(nodeRoles(n), ","))
 
 	tpm := "unk"
 	switch n.TpmUsage {
@@ -67,3 +56,45 @@ func nodeEntry(n *apb.Node) clitable.Entry {
 
 	return res
 }
+
+// nodeRoles returns the sorted names of all roles assigned to a node.
+func nodeRoles(n *apb.Node) []string {
+	var roles []string
+	if n.Roles.ConsensusMember != nil {
+		roles = append(roles, "ConsensusMember")
+	}
+	if n.Roles.KubernetesController != nil {
+		roles = append(roles, "KubernetesController")
+	}
+	if n.Roles.KubernetesWorker != nil {
+		roles = append(roles, "KubernetesWorker")
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// nodeListItem is the representation of a node in the structured (JSON, YAML)
+// output of 'node list'.
+type nodeListItem struct {
+	ID              string   `json:"id"`
+	State           string   `json:"state"`
+	Health          string   `json:"health"`
+	Roles           []string `json:"roles"`
+	ExternalAddress string   `json:"external_address,omitempty"`
+}
+
+func newNodeListItem(n *apb.Node) nodeListItem {
+	res := nodeListItem{
+		ID:     identity.NodeID(n.Pubkey),
+		State:  strings.ReplaceAll(n.State.String(), "NODE_STATE_", ""),
+		Health: n.Health.String(),
+		Roles:  nodeRoles(n),
+	}
+	if res.Roles == nil {
+		res.Roles = []string{}
+	}
+	if n.Status != nil {
+		res.ExternalAddress = n.Status.ExternalAddress
+	}
+	return res
+}
@@ -213,6 +213,16 @@ func TestMetroctl(t *testing.T) {
 			return mctlFailIfFound(t, ctx, args, cl.NodeIDs[0])
 		})
 	})
+	t.Run("list --format json", func(t *testing.T) {
+		util.TestEventual(t, "metroctl list --format json", ctx, 10*time.Second, func(ctx context.Context) error {
+			var args []string
+			args = append(args, commonOpts...)
+			args = append(args, endpointOpts...)
+			args = append(args, "node", "list", "--format", "json", cl.NodeIDs[0])
+			// Expect the node's ID to show up as a JSON field.
+			return mctlFailIfMissing(t, ctx, args, fmt.Sprintf("\"id\": %q", cl.NodeIDs[0]))
+		})
+	})
 	t.Run("describe --filter", func(t *testing.T) {
 		util.TestEventual(t, "metroctl list --filter", ctx, 10*time.Second, func(ctx context.Context) error {
 			nid := cl.NodeIDs[0]
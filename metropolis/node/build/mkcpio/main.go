@@ -202,6 +202,8 @@ func main() {
 				mode |= cpio.TypeBlock
 			case fsspec.SpecialFile_FIFO:
 				mode |= cpio.TypeFifo
+			case fsspec.SpecialFile_SOCKET:
+				mode |= cpio.TypeSocket
 			}
 
 			if err := cpioWriter.WriteHeader(&cpio.Header{
@@ -26,6 +26,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"runtime"
 	"sort"
 	"strings"
 
@@ -33,7 +34,94 @@ import (
 	"source.monogon.dev/osbase/erofs"
 )
 
-func (spec *entrySpec) writeRecursive(w *erofs.Writer, pathname string) {
+// hardlinkKey identifies files which can share a single inode. These need to
+// have the same contents and metadata.
+type hardlinkKey struct {
+	sourcePath     string
+	mode, uid, gid uint32
+	xattrs         string
+}
+
+// xattrKey identifies a single extended attribute.
+type xattrKey struct {
+	name, value string
+}
+
+// xattrsOf returns the extended attributes of the given inode.
+func xattrsOf(inode *fsspec.Inode) map[string][]byte {
+	switch inode := inode.Type.(type) {
+	case *fsspec.Inode_Directory:
+		return inode.Directory.Xattrs
+	case *fsspec.Inode_File:
+		return inode.File.Xattrs
+	case *fsspec.Inode_SymbolicLink:
+		return inode.SymbolicLink.Xattrs
+	case *fsspec.Inode_SpecialFile:
+		return inode.SpecialFile.Xattrs
+	}
+	return nil
+}
+
+// erofsXattrs converts extended attributes from the spec to the format used
+// by the EROFS writer.
+func erofsXattrs(xattrs map[string][]byte) map[string]string {
+	if len(xattrs) == 0 {
+		return nil
+	}
+	res := make(map[string]string)
+	for name, value := range xattrs {
+		res[name] = string(value)
+	}
+	return res
+}
+
+// countXattrs counts how many inodes in the tree use each extended
+// attribute.
+func (spec *entrySpec) countXattrs(counts map[xattrKey]int) {
+	for name, value := range xattrsOf(&spec.data) {
+		counts[xattrKey{name, string(value)}]++
+	}
+	for _, child := range spec.children {
+		child.countXattrs(counts)
+	}
+}
+
+// findHardlinks walks the tree in the same order as writeRecursive and
+// records into links the path of the first file in this order for all files
+// which can share an inode with it. The source paths of all other files, whose
+// contents need to be written, are appended to sources in write order.
+func (spec *entrySpec) findHardlinks(pathname string, first map[hardlinkKey]string, links map[string]string, sources *[]string) {
+	switch inode := spec.data.Type.(type) {
+	case *fsspec.Inode_Directory:
+		var sortedChildren []string
+		for name := range spec.children {
+			sortedChildren = append(sortedChildren, name)
+		}
+		sort.Strings(sortedChildren)
+		for _, name := range sortedChildren {
+			spec.children[name].findHardlinks(path.Join(pathname, name), first, links, sources)
+		}
+	case *fsspec.Inode_File:
+		key := hardlinkKey{
+			sourcePath: path.Clean(inode.File.SourcePath),
+			mode:       inode.File.Mode,
+			uid:        inode.File.Uid,
+			gid:        inode.File.Gid,
+			xattrs:     fmt.Sprintf("%q", erofsXattrs(inode.File.Xattrs)),
+		}
+		if target, ok := first[key]; ok {
+			links[pathname] = target
+		} else {
+			first[key] = pathname
+			*sources = append(*sources, inode.File.SourcePath)
+		}
+	}
+}
+
+// writeRecursive writes the tree into w. Files contained in links are
+// written as hard links to the given path. If contents is set, file contents
+// are taken from it, otherwise they are read directly from the source files.
+func (spec *entrySpec) writeRecursive(w *erofs.Writer, pathname string, links map[string]string, contents *prefetcher) {
 	switch inode := spec.data.Type.(type) {
 	case *fsspec.Inode_Directory:
 		// Sort children for reproducibility
@@ -48,6 +136,7 @@ func (spec *entrySpec) writeRecursive(w *erofs.Writer, pathname string) {
 				Permissions: uint16(inode.Directory.Mode),
 				UID:         uint16(inode.Directory.Uid),
 				GID:         uint16(inode.Directory.Gid),
+				Xattrs:      erofsXattrs(inode.Directory.Xattrs),
 			},
 			Children: sortedChildren,
 		})
@@ -55,27 +144,44 @@ func (spec *entrySpec) writeRecursive(w *erofs.Writer, pathname string) {
 			log.Fatalf("failed to write directory: %s", err)
 		}
 		for _, name := range sortedChildren {
-			spec.children[name].writeRecursive(w, path.Join(pathname, name))
+			spec.children[name].writeRecursive(w, path.Join(pathname, name), links, contents)
 		}
 	case *fsspec.Inode_File:
+		if target, ok := links[pathname]; ok {
+			if err := w.Link(pathname, target); err != nil {
+				log.Fatalf("failed to create hard link: %s", err)
+			}
+			return
+		}
 		iw := w.CreateFile(pathname, &erofs.FileMeta{
 			Base: erofs.Base{
 				Permissions: uint16(inode.File.Mode),
 				UID:         uint16(inode.File.Uid),
 				GID:         uint16(inode.File.Gid),
+				Xattrs:      erofsXattrs(inode.File.Xattrs),
 			},
 		})
 
-		sourceFile, err := os.Open(inode.File.SourcePath)
-		if err != nil {
-			log.Fatalf("failed to open source file %s: %s", inode.File.SourcePath, err)
-		}
+		if contents != nil {
+			data, err := contents.next(inode.File.SourcePath)
+			if err != nil {
+				log.Fatalf("failed to read source file %s: %s", inode.File.SourcePath, err)
+			}
+			if _, err := iw.Write(data); err != nil {
+				log.Fatalf("failed to copy file into filesystem: %s", err)
+			}
+		} else {
+			sourceFile, err := os.Open(inode.File.SourcePath)
+			if err != nil {
+				log.Fatalf("failed to open source file %s: %s", inode.File.SourcePath, err)
+			}
 
-		_, err = io.Copy(iw, sourceFile)
-		if err != nil {
-			log.Fatalf("failed to copy file into filesystem: %s", err)
+			_, err = io.Copy(iw, sourceFile)
+			if err != nil {
+				log.Fatalf("failed to copy file into filesystem: %s", err)
+			}
+			sourceFile.Close()
 		}
-		sourceFile.Close()
 		if err := iw.Close(); err != nil {
 			log.Fatalf("failed to close target file: %s", err)
 		}
@@ -83,6 +189,7 @@ func (spec *entrySpec) writeRecursive(w *erofs.Writer, pathname string) {
 		err := w.Create(pathname, &erofs.SymbolicLink{
 			Base: erofs.Base{
 				Permissions: 0777, // Nominal, Linux forces that mode anyways, see symlink(7)
+				Xattrs:      erofsXattrs(inode.SymbolicLink.Xattrs),
 			},
 			Target: inode.SymbolicLink.TargetPath,
 		})
@@ -95,12 +202,17 @@ func (spec *entrySpec) writeRecursive(w *erofs.Writer, pathname string) {
 			Permissions: uint16(inode.SpecialFile.Mode),
 			UID:         uint16(inode.SpecialFile.Uid),
 			GID:         uint16(inode.SpecialFile.Gid),
+			Xattrs:      erofsXattrs(inode.SpecialFile.Xattrs),
 		}
 		switch inode.SpecialFile.Type {
 		case fsspec.SpecialFile_FIFO:
 			err = w.Create(pathname, &erofs.FIFO{
 				Base: base,
 			})
+		case fsspec.SpecialFile_SOCKET:
+			err = w.Create(pathname, &erofs.Socket{
+				Base: base,
+			})
 		case fsspec.SpecialFile_CHARACTER_DEV:
 			err = w.Create(pathname, &erofs.CharacterDevice{
 				Base:  base,
@@ -151,18 +263,8 @@ func (spec *entrySpec) pathRef(p string) *entrySpec {
 	return entryRef
 }
 
-var (
-	outPath = flag.String("out", "", "Output file path")
-)
-
-func main() {
-	flag.Parse()
-
-	spec, err := fsspec.ReadMergeSpecs(flag.Args())
-	if err != nil {
-		log.Fatalf("failed to load specs: %v", err)
-	}
-
+// newTree builds the filesystem tree described by spec.
+func newTree(spec *fsspec.FSSpec) *entrySpec {
 	var fsRoot = &entrySpec{
 		data:     fsspec.Inode{Type: &fsspec.Inode_Directory{Directory: &fsspec.Directory{Mode: 0555}}},
 		children: make(map[string]*entrySpec),
@@ -187,20 +289,83 @@ func main() {
 		entryRef := fsRoot.pathRef(specialFile.Path)
 		entryRef.data.Type = &fsspec.Inode_SpecialFile{SpecialFile: specialFile}
 	}
+	return fsRoot
+}
 
-	fs, err := os.Create(*outPath)
+// writeImage writes the filesystem tree to out. If parallelism is bigger than
+// one, file contents are read concurrently by that many workers. The output
+// does not depend on parallelism.
+func writeImage(fsRoot *entrySpec, out io.WriteSeeker, parallelism int) error {
+	writer, err := erofs.NewWriter(out)
 	if err != nil {
-		log.Fatalf("failed to open output file: %v", err)
+		return fmt.Errorf("failed to initialize EROFS writer: %w", err)
 	}
-	writer, err := erofs.NewWriter(fs)
-	if err != nil {
-		log.Fatalf("failed to initialize EROFS writer: %v", err)
+
+	// Extended attributes used by multiple inodes are only stored once.
+	counts := make(map[xattrKey]int)
+	fsRoot.countXattrs(counts)
+	var shared []xattrKey
+	for key, count := range counts {
+		if count > 1 {
+			shared = append(shared, key)
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].name != shared[j].name {
+			return shared[i].name < shared[j].name
+		}
+		return shared[i].value < shared[j].value
+	})
+	for _, key := range shared {
+		if err := writer.ShareXattr(key.name, key.value); err != nil {
+			return fmt.Errorf("failed to add shared extended attribute: %w", err)
+		}
 	}
 
-	fsRoot.writeRecursive(writer, ".")
+	// Files with identical contents and metadata share a single inode.
+	links := make(map[string]string)
+	var sources []string
+	fsRoot.findHardlinks(".", make(map[hardlinkKey]string), links, &sources)
 
-	if err := writer.Close(); err != nil {
-		panic(err)
+	var contents *prefetcher
+	if parallelism > 1 {
+		contents = newPrefetcher(sources, parallelism)
+		defer contents.close()
+	}
+	fsRoot.writeRecursive(writer, ".", links, contents)
+
+	return writer.Close()
+}
+
+var (
+	outPath     = flag.String("out", "", "Output file path")
+	verify      = flag.Bool("verify", false, "Verify the consistency of the written filesystem")
+	parallelism = flag.Int("parallelism", runtime.GOMAXPROCS(0), "Number of files to read concurrently")
+)
+
+func main() {
+	flag.Parse()
+
+	spec, err := fsspec.ReadMergeSpecs(flag.Args())
+	if err != nil {
+		log.Fatalf("failed to load specs: %v", err)
+	}
+	if err := validateSpec(spec); err != nil {
+		log.Fatal(err)
+	}
+	fsRoot := newTree(spec)
+
+	fs, err := os.Create(*outPath)
+	if err != nil {
+		log.Fatalf("failed to open output file: %v", err)
+	}
+	if err := writeImage(fsRoot, fs, *parallelism); err != nil {
+		log.Fatalf("failed to write filesystem: %v", err)
+	}
+	if *verify {
+		if err := erofs.Verify(fs); err != nil {
+			log.Fatalf("written filesystem is inconsistent: %v", err)
+		}
 	}
 	if err := fs.Close(); err != nil {
 		panic(err)
@@ -0,0 +1,223 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"source.monogon.dev/metropolis/node/build/fsspec"
+	"source.monogon.dev/osbase/erofs"
+)
+
+// TestParallelReproducible ensures that reading file contents concurrently
+// results in the same image as reading them serially.
+func TestParallelReproducible(t *testing.T) {
+	src := t.TempDir()
+	spec := &fsspec.FSSpec{
+		Directory: []*fsspec.Directory{
+			{Path: "/etc", Mode: 0755},
+		},
+		SymbolicLink: []*fsspec.SymbolicLink{
+			{Path: "/bin/sh", TargetPath: "/bin/file0"},
+		},
+		SpecialFile: []*fsspec.SpecialFile{
+			{Path: "/dev/null", Type: fsspec.SpecialFile_CHARACTER_DEV, Major: 1, Minor: 3, Mode: 0666},
+		},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		data := make([]byte, rng.Intn(3*erofs.BlockSize))
+		rng.Read(data)
+		sourcePath := filepath.Join(src, fmt.Sprintf("file%d", i))
+		if err := os.WriteFile(sourcePath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		spec.File = append(spec.File, &fsspec.File{
+			Path:       fmt.Sprintf("/bin/file%d", i),
+			SourcePath: sourcePath,
+			Mode:       0755,
+		})
+		// Add a hard link to every fifth file.
+		if i%5 == 0 {
+			spec.File = append(spec.File, &fsspec.File{
+				Path:       fmt.Sprintf("/lib/file%d", i),
+				SourcePath: sourcePath,
+				Mode:       0755,
+			})
+		}
+	}
+
+	var images [][]byte
+	for _, parallelism := range []int{1, 4} {
+		out, err := os.Create(filepath.Join(t.TempDir(), "out.img"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+		if err := writeImage(newTree(spec), out, parallelism); err != nil {
+			t.Fatalf("parallelism %d: writeImage: %v", parallelism, err)
+		}
+		if err := erofs.Verify(out); err != nil {
+			t.Fatalf("parallelism %d: Verify: %v", parallelism, err)
+		}
+		image, err := os.ReadFile(out.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, image)
+	}
+	if !bytes.Equal(images[0], images[1]) {
+		t.Errorf("images written serially and concurrently differ")
+	}
+}
+
+// TestRoundtrip ensures that the written image contains the tree described by
+// the spec.
+func TestRoundtrip(t *testing.T) {
+	src := t.TempDir()
+	sourcePath := filepath.Join(src, "hostname")
+	if err := os.WriteFile(sourcePath, []byte("metropolis\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec := &fsspec.FSSpec{
+		Directory: []*fsspec.Directory{
+			{Path: "/etc", Mode: 0750, Uid: 1, Gid: 2},
+		},
+		File: []*fsspec.File{
+			{Path: "/etc/hostname", SourcePath: sourcePath, Mode: 0644},
+			{Path: "/etc/hostname.bak", SourcePath: sourcePath, Mode: 0644},
+		},
+		SymbolicLink: []*fsspec.SymbolicLink{
+			{Path: "/etc/mtab", TargetPath: "/proc/mounts"},
+		},
+		SpecialFile: []*fsspec.SpecialFile{
+			{Path: "/dev/null", Type: fsspec.SpecialFile_CHARACTER_DEV, Major: 1, Minor: 3, Mode: 0666},
+		},
+	}
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := writeImage(newTree(spec), out, 1); err != nil {
+		t.Fatalf("writeImage: %v", err)
+	}
+
+	r, err := erofs.NewReader(out)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	entries, err := r.Readdir("/")
+	if err != nil {
+		t.Fatalf("Readdir: %v", err)
+	}
+	if got, want := fmt.Sprint(entries), fmt.Sprint([]erofs.DirEntry{{Name: "dev", Type: fs.ModeDir}, {Name: "etc", Type: fs.ModeDir}}); got != want {
+		t.Errorf("root directory: wanted %s, got %s", want, got)
+	}
+	etc, err := r.Open("/etc")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if etc.Mode() != fs.ModeDir|0750 || etc.UID() != 1 || etc.GID() != 2 {
+		t.Errorf("/etc: wrong metadata: %v %d:%d", etc.Mode(), etc.UID(), etc.GID())
+	}
+	for _, p := range []string{"/etc/hostname", "/etc/hostname.bak"} {
+		f, err := r.Open(p)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		content, err := io.ReadAll(io.NewSectionReader(f, 0, f.Size()))
+		if err != nil {
+			t.Fatalf("%s: read failed: %v", p, err)
+		}
+		if string(content) != "metropolis\n" {
+			t.Errorf("%s: wrong content %q", p, content)
+		}
+	}
+	if target, err := r.Readlink("/etc/mtab"); err != nil || target != "/proc/mounts" {
+		t.Errorf("/etc/mtab: wanted link to /proc/mounts, got %q (%v)", target, err)
+	}
+	null, err := r.Open("/dev/null")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if null.Mode() != fs.ModeDevice|fs.ModeCharDevice|0666 {
+		t.Errorf("/dev/null: wrong mode %v", null.Mode())
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	src := t.TempDir()
+	sourcePath := filepath.Join(src, "ping")
+	if err := os.WriteFile(sourcePath, []byte("ping"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	label := []byte("system_u:object_r:bin_t:s0")
+	capability := []byte("\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	spec := &fsspec.FSSpec{
+		Directory: []*fsspec.Directory{
+			{Path: "/bin", Mode: 0755, Xattrs: map[string][]byte{"security.selinux": label}},
+		},
+		File: []*fsspec.File{
+			{Path: "/bin/ping", SourcePath: sourcePath, Mode: 0755, Xattrs: map[string][]byte{
+				"security.selinux":    label,
+				"security.capability": capability,
+			}},
+			{Path: "/bin/ping.plain", SourcePath: sourcePath, Mode: 0755},
+		},
+	}
+	out, err := os.Create(filepath.Join(t.TempDir(), "out.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := writeImage(newTree(spec), out, 1); err != nil {
+		t.Fatalf("writeImage: %v", err)
+	}
+	if err := erofs.Verify(out); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	r, err := erofs.NewReader(out)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	for p, want := range map[string]map[string]string{
+		"/bin":            {"security.selinux": string(label)},
+		"/bin/ping":       {"security.selinux": string(label), "security.capability": string(capability)},
+		"/bin/ping.plain": {},
+	} {
+		f, err := r.Open(p)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		got, err := f.Xattrs()
+		if err != nil {
+			t.Fatalf("%s: Xattrs: %v", p, err)
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("%s: wanted xattrs %q, got %q", p, want, got)
+		}
+	}
+}
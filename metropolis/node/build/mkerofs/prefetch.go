@@ -0,0 +1,97 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// prefetcher reads the contents of a list of files concurrently, while
+// returning them in order. At most as many files as there are workers are
+// kept in memory at the same time.
+type prefetcher struct {
+	paths   []string
+	results []chan prefetchResult
+	// window limits the number of files which have been read or are being read
+	// but which have not been consumed yet.
+	window chan struct{}
+	// done is closed to stop prefetching before all files have been consumed.
+	done chan struct{}
+	// idx is the index of the next file to be returned by next.
+	idx int
+}
+
+type prefetchResult struct {
+	data []byte
+	err  error
+}
+
+// newPrefetcher starts reading the given files using the given number of
+// workers. The contents need to be consumed by calling next once for every
+// path, in order. close needs to be called when the prefetcher is no longer
+// needed.
+func newPrefetcher(paths []string, workers int) *prefetcher {
+	p := &prefetcher{
+		paths:   paths,
+		results: make([]chan prefetchResult, len(paths)),
+		window:  make(chan struct{}, workers),
+		done:    make(chan struct{}),
+	}
+	for i := range p.results {
+		p.results[i] = make(chan prefetchResult, 1)
+	}
+
+	queue := make(chan int)
+	go func() {
+		defer close(queue)
+		for i := range paths {
+			select {
+			case p.window <- struct{}{}:
+			case <-p.done:
+				return
+			}
+			queue <- i
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range queue {
+				data, err := os.ReadFile(paths[i])
+				p.results[i] <- prefetchResult{data: data, err: err}
+			}
+		}()
+	}
+	return p
+}
+
+// next returns the contents of the next file, which is expected to be at the
+// given path.
+func (p *prefetcher) next(path string) ([]byte, error) {
+	if p.idx >= len(p.paths) || p.paths[p.idx] != path {
+		return nil, fmt.Errorf("prefetcher out of sync: expected %q", path)
+	}
+	res := <-p.results[p.idx]
+	p.idx++
+	<-p.window
+	return res.data, res.err
+}
+
+// close stops prefetching.
+func (p *prefetcher) close() {
+	close(p.done)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"source.monogon.dev/metropolis/node/build/fsspec"
+)
+
+// specEntry is a single entry of an FSSpec, used for validation.
+type specEntry struct {
+	kind  string
+	inode proto.Message
+}
+
+// validateSpec checks that spec does not contain multiple different entries
+// for the same path and that no entry other than a directory has children.
+// Identical entries for the same path are allowed as they can result from
+// merging multiple specs. All problems found are returned in a single error.
+func validateSpec(spec *fsspec.FSSpec) error {
+	entries := make(map[string][]specEntry)
+	add := func(p, kind string, inode proto.Message) {
+		p = path.Clean("/" + p)
+		// Clear the path of the inode, as different spellings of the same
+		// path are equivalent.
+		inode = proto.Clone(inode)
+		m := inode.ProtoReflect()
+		m.Clear(m.Descriptor().Fields().ByName("path"))
+		entries[p] = append(entries[p], specEntry{kind: kind, inode: inode})
+	}
+	for _, d := range spec.Directory {
+		add(d.Path, "directory", d)
+	}
+	for _, f := range spec.File {
+		add(f.Path, "file", f)
+	}
+	for _, s := range spec.SymbolicLink {
+		add(s.Path, "symbolic link", s)
+	}
+	for _, s := range spec.SpecialFile {
+		add(s.Path, "special file", s)
+	}
+
+	var problems []string
+	for p, es := range entries {
+		for _, e := range es[1:] {
+			if !proto.Equal(e.inode, es[0].inode) {
+				var kinds []string
+				for _, e := range es {
+					kinds = append(kinds, e.kind)
+				}
+				problems = append(problems, fmt.Sprintf("%s: conflicting entries (%s)", p, strings.Join(kinds, ", ")))
+				break
+			}
+		}
+		if p == "/" && es[0].kind != "directory" {
+			problems = append(problems, fmt.Sprintf("%s: root must be a directory, not a %s", p, es[0].kind))
+		}
+		// Check that all parents of this entry are directories, if they are
+		// specified.
+		for parent := path.Dir(p); parent != "/"; parent = path.Dir(parent) {
+			pes, ok := entries[parent]
+			if !ok || pes[0].kind == "directory" {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("%s: parent %s is a %s", p, parent, pes[0].kind))
+			break
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid spec:\n  %s", strings.Join(problems, "\n  "))
+}
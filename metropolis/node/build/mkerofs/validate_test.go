@@ -0,0 +1,87 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"source.monogon.dev/metropolis/node/build/fsspec"
+)
+
+func TestValidateSpec(t *testing.T) {
+	for _, te := range []struct {
+		name string
+		spec *fsspec.FSSpec
+		// wantErr contains substrings of the expected error, or nothing if the
+		// spec is valid.
+		wantErr []string
+	}{
+		{
+			name: "Valid",
+			spec: &fsspec.FSSpec{
+				Directory:    []*fsspec.Directory{{Path: "/etc", Mode: 0755}, {Path: "etc/", Mode: 0755}},
+				File:         []*fsspec.File{{Path: "/etc/hostname", SourcePath: "a"}},
+				SymbolicLink: []*fsspec.SymbolicLink{{Path: "/etc/os-release", TargetPath: "/usr/lib/os-release"}},
+			},
+		},
+		{
+			name: "Duplicate",
+			spec: &fsspec.FSSpec{
+				File:         []*fsspec.File{{Path: "/etc/hostname", SourcePath: "a"}, {Path: "/etc/passwd", SourcePath: "a"}, {Path: "/etc/passwd", SourcePath: "b"}},
+				SymbolicLink: []*fsspec.SymbolicLink{{Path: "/etc//hostname", TargetPath: "/tmp/hostname"}},
+			},
+			wantErr: []string{
+				"/etc/hostname: conflicting entries (file, symbolic link)",
+				"/etc/passwd: conflicting entries (file, file)",
+			},
+		},
+		{
+			name: "Parent",
+			spec: &fsspec.FSSpec{
+				File:        []*fsspec.File{{Path: "/etc", SourcePath: "a"}},
+				SpecialFile: []*fsspec.SpecialFile{{Path: "/etc/foo/null"}},
+			},
+			wantErr: []string{"/etc/foo/null: parent /etc is a file"},
+		},
+		{
+			name: "Root",
+			spec: &fsspec.FSSpec{
+				SymbolicLink: []*fsspec.SymbolicLink{{Path: "/", TargetPath: "/foo"}},
+			},
+			wantErr: []string{"/: root must be a directory, not a symbolic link"},
+		},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			err := validateSpec(te.spec)
+			if len(te.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("expected spec to be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected spec to be invalid")
+			}
+			for _, want := range te.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %q in error, got %q", want, err.Error())
+				}
+			}
+		})
+	}
+}
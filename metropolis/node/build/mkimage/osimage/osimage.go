@@ -51,13 +51,16 @@ const (
 )
 
 // PartitionSizeInfo contains parameters used during partition table
-// initialization and, in case of image files, space allocation.
+// initialization and, in case of image files, space allocation. All sizes are
+// rounded up to the partition alignment of the output device, which is 1MiB or
+// the device's optimal block size, whichever is bigger.
 type PartitionSizeInfo struct {
 	// Size of the EFI System Partition (ESP), in mebibytes. The size must
 	// not be zero.
 	ESP int64
-	// Size of the Metropolis system partition, in mebibytes. The partition
-	// won't be created if the size is zero.
+	// Size of each of the two Metropolis system partitions (slots A and B),
+	// in mebibytes. Both slots are always allocated with identical sizes. The
+	// partitions won't be created if the size is zero.
 	System int64
 	// Size of the Metropolis data partition, in mebibytes. The partition
 	// won't be created if the size is zero. If the image is output to a
@@ -96,8 +99,46 @@ type Params struct {
 
 const Mi = 1024 * 1024
 
+// partitionAlignment returns the alignment in bytes of partition boundaries on
+// b. This is 1MiB or the optimal block size of b, whichever is bigger, rounded
+// up to a multiple of the block size of b.
+func partitionAlignment(b blockdev.BlockDev) int64 {
+	var alignment int64 = Mi
+	if optimal := b.OptimalBlockSize(); optimal > alignment {
+		alignment = optimal
+	}
+	return alignUp(alignment, b.BlockSize())
+}
+
+// alignUp rounds v up to the next multiple of alignment.
+func alignUp(v, alignment int64) int64 {
+	return (v + alignment - 1) / alignment * alignment
+}
+
 // Create writes a Metropolis OS image to a block device.
 func Create(params *Params) (*efivarfs.LoadOption, error) {
+	size := params.PartitionSize
+	if size.ESP <= 0 {
+		return nil, fmt.Errorf("invalid ESP size %d MiB, must be positive", size.ESP)
+	}
+	if size.System < 0 || size.Data < 0 {
+		return nil, fmt.Errorf("invalid partition sizes, system (%d MiB) and data (%d MiB) must not be negative", size.System, size.Data)
+	}
+	if size.System == 0 && params.SystemImage != nil {
+		// Safeguard against contradicting parameters.
+		return nil, fmt.Errorf("the system image parameter was passed while the associated partition size is zero")
+	}
+	createSystem := size.System != 0 && params.SystemImage != nil
+
+	alignment := partitionAlignment(params.Output)
+	espBytes := alignUp(size.ESP*Mi, alignment)
+	systemBytes := alignUp(size.System*Mi, alignment)
+	dataBytes := alignUp(size.Data*Mi, alignment)
+	requiredBytes := espBytes + dataBytes
+	if createSystem {
+		requiredBytes += 2 * systemBytes
+	}
+
 	// Discard the entire device, we're going to write new data over it.
 	// Ignore errors, this is only advisory.
 	params.Output.Discard(0, params.Output.BlockCount()*params.Output.BlockSize())
@@ -107,11 +148,28 @@ func Create(params *Params) (*efivarfs.LoadOption, error) {
 		return nil, fmt.Errorf("invalid block device: %w", err)
 	}
 	tbl.ID = params.DiskGUID
+
+	freeSpaces, _, err := tbl.GetFreeSpaces()
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine free space: %w", err)
+	}
+	var availableBytes int64
+	blockSize := params.Output.BlockSize()
+	for _, fs := range freeSpaces {
+		start := alignUp(fs[0]*blockSize, alignment)
+		if avail := fs[1]*blockSize - start; avail > availableBytes {
+			availableBytes = avail
+		}
+	}
+	if requiredBytes > availableBytes {
+		return nil, fmt.Errorf("requested partitions (ESP %d MiB, 2x system %d MiB, data %d MiB, aligned to %d bytes) need %d bytes, but only %d bytes are available on the device", size.ESP, size.System, size.Data, alignment, requiredBytes, availableBytes)
+	}
+
 	esp := gpt.Partition{
 		Type: gpt.PartitionTypeEFISystem,
 		Name: ESPLabel,
 	}
-	if err := tbl.AddPartition(&esp, params.PartitionSize.ESP*Mi); err != nil {
+	if err := tbl.AddPartition(&esp, espBytes, gpt.WithAlignment(alignment)); err != nil {
 		return nil, fmt.Errorf("failed to allocate ESP: %w", err)
 	}
 
@@ -138,13 +196,13 @@ func Create(params *Params) (*efivarfs.LoadOption, error) {
 		return nil, fmt.Errorf("failed to write FAT32: %w", err)
 	}
 
-	// Create the system partition only if its size is specified.
-	if params.PartitionSize.System != 0 && params.SystemImage != nil {
+	// Create the system partitions only if their size is specified.
+	if createSystem {
 		systemPartitionA := gpt.Partition{
 			Type: SystemAType,
 			Name: SystemALabel,
 		}
-		if err := tbl.AddPartition(&systemPartitionA, params.PartitionSize.System*Mi); err != nil {
+		if err := tbl.AddPartition(&systemPartitionA, systemBytes, gpt.WithAlignment(alignment)); err != nil {
 			return nil, fmt.Errorf("failed to allocate system partition A: %w", err)
 		}
 		if _, err := io.Copy(blockdev.NewRWS(systemPartitionA), params.SystemImage); err != nil {
@@ -154,20 +212,20 @@ func Create(params *Params) (*efivarfs.LoadOption, error) {
 			Type: SystemBType,
 			Name: SystemBLabel,
 		}
-		if err := tbl.AddPartition(&systemPartitionB, params.PartitionSize.System*Mi); err != nil {
+		if err := tbl.AddPartition(&systemPartitionB, systemBytes, gpt.WithAlignment(alignment)); err != nil {
 			return nil, fmt.Errorf("failed to allocate system partition B: %w", err)
 		}
-	} else if params.PartitionSize.System == 0 && params.SystemImage != nil {
-		// Safeguard against contradicting parameters.
-		return nil, fmt.Errorf("the system image parameter was passed while the associated partition size is zero")
+		if systemPartitionA.SizeBlocks() != systemPartitionB.SizeBlocks() {
+			return nil, fmt.Errorf("system partitions differ in size (A: %d blocks, B: %d blocks)", systemPartitionA.SizeBlocks(), systemPartitionB.SizeBlocks())
+		}
 	}
 	// Create the data partition only if its size is specified.
-	if params.PartitionSize.Data != 0 {
+	if size.Data != 0 {
 		dataPartition := gpt.Partition{
 			Type: DataType,
 			Name: DataLabel,
 		}
-		if err := tbl.AddPartition(&dataPartition, -1); err != nil {
+		if err := tbl.AddPartition(&dataPartition, -1, gpt.WithAlignment(alignment)); err != nil {
 			return nil, fmt.Errorf("failed to allocate data partition: %w", err)
 		}
 	}
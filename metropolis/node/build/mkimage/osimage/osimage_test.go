@@ -0,0 +1,76 @@
+package osimage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"source.monogon.dev/osbase/blockdev"
+	"source.monogon.dev/osbase/gpt"
+)
+
+// optimalMemory is a memory-backed block device with a configurable optimal
+// block size.
+type optimalMemory struct {
+	*blockdev.Memory
+	optimal int64
+}
+
+func (m *optimalMemory) OptimalBlockSize() int64 {
+	return m.optimal
+}
+
+func newTestDevice(t *testing.T, size, optimal int64) *optimalMemory {
+	t.Helper()
+	mem, err := blockdev.NewMemory(512, size/512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &optimalMemory{Memory: mem, optimal: optimal}
+}
+
+func testParams(out blockdev.BlockDev, size PartitionSizeInfo) *Params {
+	return &Params{
+		Output:        out,
+		ABLoader:      bytes.NewReader([]byte("abloader")),
+		EFIPayload:    bytes.NewReader([]byte("payload")),
+		SystemImage:   bytes.NewReader([]byte("system")),
+		PartitionSize: size,
+	}
+}
+
+func TestCreateAlignment(t *testing.T) {
+	const optimal = 4 * Mi
+	dev := newTestDevice(t, 128*Mi, optimal)
+	if _, err := Create(testParams(dev, PartitionSizeInfo{ESP: 40, System: 10, Data: 1})); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	tbl, err := gpt.Read(dev)
+	if err != nil {
+		t.Fatalf("gpt.Read: %v", err)
+	}
+	sizes := make(map[string]int64)
+	for _, p := range tbl.Partitions {
+		if p.IsUnused() {
+			continue
+		}
+		if start := int64(p.FirstBlock) * 512; start%optimal != 0 {
+			t.Errorf("partition %s starts at %d, not aligned to %d", p.Name, start, optimal)
+		}
+		sizes[p.Name] = int64(p.SizeBlocks()) * 512
+	}
+	if want, got := int64(12*Mi), sizes[SystemALabel]; want != got {
+		t.Errorf("wanted system A size %d, got %d", want, got)
+	}
+	if sizes[SystemALabel] != sizes[SystemBLabel] {
+		t.Errorf("system partitions differ in size: A %d, B %d", sizes[SystemALabel], sizes[SystemBLabel])
+	}
+}
+
+func TestCreateTooSmall(t *testing.T) {
+	dev := newTestDevice(t, 60*Mi, 512)
+	_, err := Create(testParams(dev, PartitionSizeInfo{ESP: 40, System: 10, Data: 1}))
+	if err == nil || !strings.Contains(err.Error(), "available on the device") {
+		t.Fatalf("wanted error about available space, got %v", err)
+	}
+}
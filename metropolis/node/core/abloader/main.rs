@@ -22,6 +22,10 @@ const B_LOADER_PATH: &CStr16 = cstr16!("\\EFI\\metropolis\\boot-b.efi");
 
 const LOADER_STATE_PATH: &CStr16 = cstr16!("\\EFI\\metropolis\\loader_state.pb");
 
+// Number of times next_slot is booted without the OS marking it as successful
+// before the loader gives up and rolls back to active_slot.
+const MAX_BOOT_ATTEMPTS: u32 = 3;
+
 enum ValidSlot {
     A,
     B,
@@ -120,21 +124,35 @@ fn main(_handle: Handle, mut system_table: SystemTable<Boot>) -> Status {
                 abloader::AbLoaderData {
                     active_slot: abloader::Slot::A.into(),
                     next_slot: abloader::Slot::None.into(),
+                    boot_attempts: 0,
                 }
             }
         };
 
-        // If next_slot is set, use it as slot to boot but clear it in the
-        // state file as the next boot should not use it again. If it should
-        // be permanently activated, it is the OS's job to put it into 
+        // If next_slot is set, use it as slot to boot and count the attempt in
+        // the state file. The OS is responsible for permanently activating it
+        // (and thereby resetting the counter) once it has booted successfully.
+        // If it hasn't done so after MAX_BOOT_ATTEMPTS, roll back to the
+        // active slot.
         if loader_data.next_slot != abloader::Slot::None.into() {
             let next_slot = loader_data.next_slot;
-            loader_data.next_slot = abloader::Slot::None.into();
+            let boot_slot = if loader_data.boot_attempts >= MAX_BOOT_ATTEMPTS {
+                println!(
+                    "Slot {} not marked successful after {} boot attempts, rolling back",
+                    next_slot, loader_data.boot_attempts
+                );
+                loader_data.next_slot = abloader::Slot::None.into();
+                loader_data.boot_attempts = 0;
+                loader_data.active_slot
+            } else {
+                loader_data.boot_attempts += 1;
+                next_slot
+            };
             let new_loader_data = loader_data.encode_to_vec();
             esp_fs
                 .write(&LOADER_STATE_PATH, new_loader_data)
                 .expect("failed to write back abdata");
-            next_slot
+            boot_slot
         } else {
             loader_data.active_slot
         }
@@ -82,7 +82,7 @@ func (m *Manager) bootstrap(ctx context.Context, bootstrap *apb.NodeParameters_C
 			supervisor.Logger(ctx).Infof("Bootstrapping: still waiting for storage....")
 		}
 	}()
-	cuk, err := m.storageRoot.Data.MountNew(&configuration, storageSecurity)
+	cuk, err := m.storageRoot.Data.MountNew(&configuration, storageSecurity, m.nodeParams.StorageCipher)
 	close(storageDone)
 	if err != nil {
 		return fmt.Errorf("could not make and mount data partition: %w", err)
@@ -128,6 +128,7 @@ func (m *Manager) bootstrap(ctx context.Context, bootstrap *apb.NodeParameters_C
 		// bootstrap.
 		ClusterCa:       nil,
 		StorageSecurity: storageSecurity,
+		StorageCipher:   configuration.StorageCipher,
 	}
 	if err = m.storageRoot.ESP.Metropolis.SealedConfiguration.SealSecureBoot(&sc, tpmUsage); err != nil {
 		return fmt.Errorf("writing sealed configuration failed: %w", err)
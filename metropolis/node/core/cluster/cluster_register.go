@@ -133,7 +133,7 @@ func (m *Manager) register(ctx context.Context, register *apb.NodeParameters_Clu
 	// saved into the ESP after successful registration.
 	var sc ppb.SealedConfiguration
 	supervisor.Logger(ctx).Infof("Registering: mounting new storage...")
-	cuk, err := m.storageRoot.Data.MountNew(&sc, storageSecurity)
+	cuk, err := m.storageRoot.Data.MountNew(&sc, storageSecurity, m.nodeParams.StorageCipher)
 	if err != nil {
 		return fmt.Errorf("could not make and mount data partition: %w", err)
 	}
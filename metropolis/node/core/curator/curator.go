@@ -52,8 +52,9 @@ type Service struct {
 	// config is the configuration with which the service was started.
 	config *Config
 
-	// ttl is the effective TTL value from Config.LeaderTTL (if given as <= 0,
-	// this is the value that has been fixed up to some default).
+	// ttl is the effective default TTL value from Config.LeaderTTL (if given as
+	// <= 0, this is the value that has been fixed up to some default). It is
+	// used unless the cluster configuration overrides it.
 	ttl int
 
 	// status is a memory Event Value for keeping the electionStatus of this
@@ -138,14 +139,28 @@ var (
 	electionPrefix = "/leader"
 )
 
+// errLeaderTTLChanged is returned by elect when the cluster-configured leader
+// TTL changed, requiring a new lease to be established.
+var errLeaderTTLChanged = errors.New("leader TTL changed in cluster configuration")
+
+// clusterTTL returns the leader election lease TTL in seconds as configured in
+// the cluster configuration, falling back to the node-local default if not
+// set or if the cluster configuration cannot be parsed. A nil value means the
+// cluster configuration is not present.
+func (s *Service) clusterTTL(value []byte) int {
+	if value == nil {
+		return s.ttl
+	}
+	cc, err := clusterUnmarshal(value)
+	if err != nil || cc.LeaderTTL == 0 {
+		return s.ttl
+	}
+	return int(cc.LeaderTTL.Seconds())
+}
+
 // elect runs a single leader election attempt. The status of the service will
 // be updated with electionStatus values as the election makes progress.
 func (s *Service) elect(ctx context.Context) error {
-	lv, err := s.config.buildLockValue(s.ttl)
-	if err != nil {
-		return fmt.Errorf("building lock value failed: %w", err)
-	}
-
 	w := s.config.Consensus.Watch()
 	defer w.Close()
 	st, err := w.Get(ctx)
@@ -157,6 +172,25 @@ func (s *Service) elect(ctx context.Context) error {
 		return fmt.Errorf("getting consensus client failed: %w", err)
 	}
 
+	// Retrieve the leader TTL from the cluster configuration.
+	ccRes, err := cl.Get(ctx, clusterConfigurationKey)
+	if err != nil {
+		return fmt.Errorf("getting cluster configuration failed: %w", err)
+	}
+	var ccValue []byte
+	if len(ccRes.Kvs) > 0 {
+		ccValue = ccRes.Kvs[0].Value
+	}
+	ttl := s.clusterTTL(ccValue)
+	if ttl != s.ttl {
+		supervisor.Logger(ctx).Infof("Using lease TTL of %d seconds from cluster configuration", ttl)
+	}
+
+	lv, err := s.config.buildLockValue(ttl)
+	if err != nil {
+		return fmt.Errorf("building lock value failed: %w", err)
+	}
+
 	if err := s.cleanupPreviousLifetime(ctx, cl); err != nil {
 		supervisor.Logger(ctx).Warningf("Failed to cleanup previous lifetime: %v", err)
 	}
@@ -164,7 +198,7 @@ func (s *Service) elect(ctx context.Context) error {
 	// Establish a lease/session with etcd.
 	session, err := concurrency.NewSession(cl.ThinClient(ctx),
 		concurrency.WithContext(ctx),
-		concurrency.WithTTL(s.ttl))
+		concurrency.WithTTL(ttl))
 	if err != nil {
 		return fmt.Errorf("creating session failed: %w", err)
 	}
@@ -187,6 +221,23 @@ func (s *Service) elect(ctx context.Context) error {
 
 	// Channel that gets updates about the current leader in the cluster.
 	observerC := election.Observe(octx)
+	// Channel that gets updates about the cluster configuration, starting
+	// right after the revision at which we retrieved the leader TTL.
+	configC := cl.Watch(octx, clusterConfigurationKey, clientv3.WithRev(ccRes.Header.Revision+1))
+	// ttlChanged checks a cluster configuration watch response for changes of
+	// the leader TTL.
+	ttlChanged := func(wr clientv3.WatchResponse) bool {
+		for _, ev := range wr.Events {
+			var value []byte
+			if ev.Type == clientv3.EventTypePut {
+				value = ev.Kv.Value
+			}
+			if s.clusterTTL(value) != ttl {
+				return true
+			}
+		}
+		return false
+	}
 	// Channel that gets updates about this instance becoming a leader.
 	campaignerC := make(chan error)
 
@@ -217,6 +268,13 @@ func (s *Service) elect(ctx context.Context) error {
 					lock: &lock,
 				},
 			})
+		case wr, ok := <-configC:
+			if !ok || wr.Canceled {
+				return errors.New("cluster configuration watch failed")
+			}
+			if ttlChanged(wr) {
+				return errLeaderTTLChanged
+			}
 		case err = <-campaignerC:
 			if err == nil {
 				goto campaigned
@@ -236,16 +294,28 @@ campaigned:
 		},
 	})
 
-	// Wait until either we loose the lease/session or our context expires.
-	select {
-	case <-ctx.Done():
-		supervisor.Logger(ctx).Warningf("Context canceled, quitting.")
-		return fmt.Errorf("curator session canceled: %w", ctx.Err())
-	case <-session.Done():
-		supervisor.Logger(ctx).Warningf("Session done, quitting.")
-		return fmt.Errorf("curator session done")
+	// Wait until either we loose the lease/session, our context expires or the
+	// leader TTL gets reconfigured. In the last case, we give up leadership by
+	// closing the session, and re-campaign with a new lease.
+	for {
+		select {
+		case <-ctx.Done():
+			supervisor.Logger(ctx).Warningf("Context canceled, quitting.")
+			return fmt.Errorf("curator session canceled: %w", ctx.Err())
+		case <-session.Done():
+			supervisor.Logger(ctx).Warningf("Session done, quitting.")
+			return fmt.Errorf("curator session done")
+		case wr, ok := <-configC:
+			if !ok || wr.Canceled {
+				supervisor.Logger(ctx).Warningf("Cluster configuration watch failed, quitting.")
+				return errors.New("cluster configuration watch failed")
+			}
+			if ttlChanged(wr) {
+				supervisor.Logger(ctx).Infof("Leader TTL changed, resigning to establish new lease.")
+				return errLeaderTTLChanged
+			}
+		}
 	}
-
 }
 
 // cleanupPreviousLifetime checks if we just started up after ungracefully losing
@@ -364,7 +434,7 @@ func (s *Service) Run(ctx context.Context) error {
 	if s.ttl <= 0 {
 		s.ttl = 10
 	}
-	supervisor.Logger(ctx).Infof("Curator starting on prefix %q with lease TTL of %d seconds...", electionPrefix, s.ttl)
+	supervisor.Logger(ctx).Infof("Curator starting on prefix %q with default lease TTL of %d seconds...", electionPrefix, s.ttl)
 
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
 	for {
@@ -25,6 +25,10 @@ type leaderState struct {
 	// the last corresponding node heartbeats received by the current Curator
 	// leader.
 	heartbeatTimestamps sync.Map
+	// heartbeatUtilization maps node IDs to the last resource utilization
+	// samples (as *ipb.HeartbeatUpdateRequest) received in node heartbeats by
+	// the current Curator leader.
+	heartbeatUtilization sync.Map
 
 	// startTs is a local monotonic clock timestamp associated with this node's
 	// assumption of Curator leadership.
@@ -34,6 +38,14 @@ type leaderState struct {
 	// used to detect possibly re-used WireGuard public keys without having to get
 	// all nodes from etcd.
 	clusternetCache map[string]string
+
+	// webhooks is the queue of cluster lifecycle events to be delivered to
+	// webhooks by this leader.
+	webhooks *webhookQueue
+
+	// registerLimiter throttles RegisterNode calls with invalid register
+	// tickets.
+	registerLimiter *registerLimiter
 }
 
 // leadership represents the curator leader's ability to perform actions as a
@@ -68,6 +80,10 @@ type leadership struct {
 	// are the same as for muNodes, as described above.
 	muRegisterTicket sync.Mutex
 
+	// muCluster guards changes to the cluster configuration. Its usage semantics
+	// are the same as for muNodes, as described above.
+	muCluster sync.Mutex
+
 	// ls contains the current leader's non-persistent local state.
 	ls leaderState
 }
@@ -155,6 +171,8 @@ type curatorLeader struct {
 func newCuratorLeader(l *leadership, node *identity.Node) *curatorLeader {
 	// Mark the start of this leader's tenure.
 	l.ls.startTs = time.Now()
+	l.ls.webhooks = newWebhookQueue()
+	l.ls.registerLimiter = newRegisterLimiter()
 
 	return &curatorLeader{
 		leaderCurator{leadership: l},
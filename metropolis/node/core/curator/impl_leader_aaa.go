@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"fmt"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -108,8 +110,10 @@ func (a *leaderAAA) Escrow(srv apb.AAA_EscrowServer) error {
 		return status.Error(codes.Unauthenticated, "cannot proceed without refresh certificate proof at transport layer")
 	}
 
-	// MVP: only support parameters public_key == TLS public key.
-	if subtle.ConstantTimeCompare(pk, msg.Parameters.PublicKey) != 1 {
+	// MVP: only support parameters public_key == TLS public key, unless the
+	// client requests read-only credentials, which the owner can issue to any
+	// key.
+	if !msg.Parameters.ReadOnly && subtle.ConstantTimeCompare(pk, msg.Parameters.PublicKey) != 1 {
 		return status.Errorf(codes.Unimplemented, "client parameters public_key different from transport public key unimplemented")
 	}
 
@@ -133,6 +137,14 @@ func (a *leaderAAA) Escrow(srv apb.AAA_EscrowServer) error {
 		Mode:      pki.CertificateExternal,
 		PublicKey: pk,
 	}
+	if msg.Parameters.ReadOnly {
+		// Read-only certificates are issued for arbitrary keys, so name them by
+		// their key to not return a certificate previously emitted for another
+		// key.
+		oc.Template = identity.ReadOnlyUserCertificate("owner")
+		oc.Name = fmt.Sprintf("owner-read-only-%s", hex.EncodeToString(msg.Parameters.PublicKey))
+		oc.PublicKey = msg.Parameters.PublicKey
+	}
 	ocBytes, err := oc.Ensure(ctx, a.etcd)
 	if err != nil {
 		return status.Errorf(codes.Unavailable, "ensuring new certificate failed: %v", err)
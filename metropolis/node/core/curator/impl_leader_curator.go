@@ -96,29 +96,54 @@ func (l *leaderCurator) watchNodeInCluster(nic *ipb.WatchRequest_NodeInCluster,
 // watchNodesInCluster implements the Watch API when dealing with a
 // all-nodes-in-cluster request. Effectively, it pipes a ranged etcd value
 // watcher into the Watch API.
-func (l *leaderCurator) watchNodesInCluster(_ *ipb.WatchRequest_NodesInCluster, srv ipb.Curator_WatchServer) error {
+func (l *leaderCurator) watchNodesInCluster(nic *ipb.WatchRequest_NodesInCluster, srv ipb.Curator_WatchServer) error {
 	ctx := srv.Context()
 
+	var window time.Duration
+	if nic.CoalesceWindow != nil {
+		if err := nic.CoalesceWindow.CheckValid(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid coalesce_window: %v", err)
+		}
+		window = nic.CoalesceWindow.AsDuration()
+		if window < 0 {
+			return status.Error(codes.InvalidArgument, "coalesce_window must not be negative")
+		}
+		if window > maxCoalesceWindow {
+			return status.Errorf(codes.InvalidArgument, "coalesce_window must not be longer than %s", maxCoalesceWindow)
+		}
+	}
+	if nic.ResumeRevision < 0 {
+		return status.Error(codes.InvalidArgument, "resume_revision must not be negative")
+	}
+
 	start, end := NodeEtcdPrefix.KeyRange()
-	value := etcd.NewValue[*nodeAtID](l.etcd, start, nodeValueConverter, etcd.Range(end))
+	opts := []*etcd.Option{etcd.Range(end)}
+	if nic.ResumeRevision != 0 {
+		opts = append(opts, etcd.StartRevision(nic.ResumeRevision))
+	}
+	value := etcd.NewValue[*nodeAtID](l.etcd, start, nodeValueConverter, opts...)
 
-	w := value.Watch()
+	w := value.RevisionWatch()
 	defer w.Close()
 
-	// Perform initial fetch from etcd.
-	nodes := make(map[string]*Node)
+	// Perform initial fetch from etcd. When resuming, this only contains nodes
+	// changed or removed since the given revision.
+	nodes := make(map[string]*nodeAtID)
 	for {
 		nodeKV, err := w.Get(ctx, event.BacklogOnly[*nodeAtID]())
 		if errors.Is(err, event.ErrBacklogDone) {
 			break
 		}
-		if err != nil {
+		switch {
+		case errors.Is(err, etcd.ErrCompacted):
+			return status.Error(codes.OutOfRange, "resume_revision is too old, full resync required")
+		case errors.Is(err, etcd.ErrFutureRevision):
+			return status.Error(codes.InvalidArgument, "resume_revision is newer than the current revision")
+		case err != nil:
 			rpc.Trace(ctx).Printf("etcd watch failed (initial fetch): %v", err)
 			return status.Error(codes.Unavailable, "internal error during initial fetch")
 		}
-		if nodeKV.value != nil {
-			nodes[nodeKV.id] = nodeKV.value
-		}
+		nodes[nodeKV.id] = nodeKV
 	}
 
 	// Initial send, chunked to not go over 2MiB (half of the default gRPC message
@@ -136,8 +161,12 @@ func (l *leaderCurator) watchNodesInCluster(_ *ipb.WatchRequest_NodesInCluster,
 		}
 	}
 	// Send last update message. This might be empty, but we need to send the
-	// LAST_BACKLOGGED marker.
+	// LAST_BACKLOGGED marker. Only this message carries a revision, as the
+	// client's view is incomplete until it is received.
 	we.Progress = ipb.WatchEvent_PROGRESS_LAST_BACKLOGGED
+	if rev, ok := w.Revision(); ok {
+		we.Revision = rev
+	}
 	if err := srv.Send(we); err != nil {
 		return err
 	}
@@ -150,13 +179,63 @@ func (l *leaderCurator) watchNodesInCluster(_ *ipb.WatchRequest_NodesInCluster,
 			return status.Errorf(codes.Unavailable, "internal error during update")
 		}
 		we := &ipb.WatchEvent{}
-		nodeKV.appendToEvent(we)
+		if window == 0 {
+			nodeKV.appendToEvent(we)
+		} else {
+			if err := coalesceNodeUpdates(ctx, w, nodeKV, window, we); err != nil {
+				rpc.Trace(ctx).Printf("etcd watch failed (coalesced update): %v", err)
+				return status.Errorf(codes.Unavailable, "internal error during update")
+			}
+		}
+		if rev, ok := w.Revision(); ok {
+			we.Revision = rev
+		}
 		if err := srv.Send(we); err != nil {
 			return err
 		}
 	}
 }
 
+// maxCoalesceWindow is the longest coalesce_window accepted in a
+// NodesInCluster watch request. Longer windows would effectively stall the
+// watch for consumers.
+const maxCoalesceWindow = 10 * time.Second
+
+// coalesceNodeUpdates keeps receiving node updates from the given watcher for
+// the duration of window, starting with first. All updates are then recorded
+// into ev, keeping only the newest update for every node. Coalescing also
+// stops early when ev would grow beyond the message size limit used for the
+// initial backlog.
+func coalesceNodeUpdates(ctx context.Context, w event.Watcher[*nodeAtID], first *nodeAtID, window time.Duration, ev *ipb.WatchEvent) error {
+	ctxT, ctxC := context.WithTimeout(ctx, window)
+	defer ctxC()
+
+	// Keep order of first appearance to make the resulting events stable.
+	updates := map[string]*nodeAtID{first.id: first}
+	order := []string{first.id}
+	size := 0
+	for size < (2 << 20) {
+		nodeKV, err := w.Get(ctxT)
+		if err != nil {
+			if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return err
+		}
+		if _, ok := updates[nodeKV.id]; !ok {
+			order = append(order, nodeKV.id)
+		}
+		updates[nodeKV.id] = nodeKV
+		if nodeKV.value != nil {
+			size += proto.Size(nodeKV.value.proto())
+		}
+	}
+	for _, id := range order {
+		updates[id].appendToEvent(ev)
+	}
+	return nil
+}
+
 // nodeAtID is a key/pair container for a node update received from an etcd
 // watcher. The value will be nil if this update represents a node being
 // deleted.
@@ -275,7 +354,7 @@ func (l *leaderCurator) Heartbeat(stream ipb.Curator_HeartbeatServer) error {
 	id := identity.NodeID(pi.Node.PublicKey)
 
 	for {
-		_, err := stream.Recv()
+		req, err := stream.Recv()
 		if err == io.EOF {
 			return nil
 		}
@@ -286,6 +365,14 @@ func (l *leaderCurator) Heartbeat(stream ipb.Curator_HeartbeatServer) error {
 		// Update the node's timestamp within the local Curator state.
 		l.ls.heartbeatTimestamps.Store(id, time.Now())
 
+		// Keep the latest utilization sample. Invalid samples are dropped, but
+		// must not make the node seem unhealthy.
+		if err := validateUtilization(req); err != nil {
+			rpc.Trace(ctx).Printf("Dropping invalid utilization sample: %v", err)
+		} else {
+			l.ls.heartbeatUtilization.Store(id, req)
+		}
+
 		rsp := &ipb.HeartbeatUpdateResponse{}
 		if err := stream.Send(rsp); err != nil {
 			return err
@@ -293,6 +380,28 @@ func (l *leaderCurator) Heartbeat(stream ipb.Curator_HeartbeatServer) error {
 	}
 }
 
+// validateUtilization ensures that all utilization values in a heartbeat are
+// within [0.0, 1.0].
+func validateUtilization(req *ipb.HeartbeatUpdateRequest) error {
+	for _, v := range []struct {
+		name  string
+		value *float64
+	}{
+		{"cpu_usage", req.CpuUsage},
+		{"memory_usage", req.MemoryUsage},
+		{"disk_usage", req.DiskUsage},
+	} {
+		if v.value == nil {
+			continue
+		}
+		// Written to also catch NaNs.
+		if !(*v.value >= 0 && *v.value <= 1) {
+			return fmt.Errorf("%s must be between 0 and 1, got %v", v.name, *v.value)
+		}
+	}
+	return nil
+}
+
 func (l *leaderCurator) RegisterNode(ctx context.Context, req *ipb.RegisterNodeRequest) (*ipb.RegisterNodeResponse, error) {
 	// Call is unauthenticated - verify the other side has connected with an
 	// ephemeral certificate. That certificate's pubkey will become the node's
@@ -303,6 +412,12 @@ func (l *leaderCurator) RegisterNode(ctx context.Context, req *ipb.RegisterNodeR
 	}
 	pubkey := pi.Unauthenticated.SelfSignedPublicKey
 
+	// Reject callers which presented too many invalid register tickets
+	// recently, before even looking at the request.
+	if left := l.ls.registerLimiter.lockedOut(pubkey); left > 0 {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many invalid register tickets, retry in %s", left.Round(time.Second))
+	}
+
 	// Check the Join Key size.
 	if want, got := ed25519.PublicKeySize, len(req.JoinKey); want != got {
 		return nil, status.Errorf(codes.InvalidArgument, "join_key must be set and be %d bytes long", want)
@@ -317,8 +432,10 @@ func (l *leaderCurator) RegisterNode(ctx context.Context, req *ipb.RegisterNodeR
 	}
 	gotTicket := req.RegisterTicket
 	if subtle.ConstantTimeCompare(wantTicket, gotTicket) != 1 {
+		l.ls.registerLimiter.fail(pubkey)
 		return nil, status.Error(codes.PermissionDenied, "registerticket invalid")
 	}
+	l.ls.registerLimiter.succeed(pubkey)
 
 	// Doing a read-then-write operation below, take lock.
 	//
@@ -394,18 +511,20 @@ func (l *leaderCurator) RegisterNode(ctx context.Context, req *ipb.RegisterNodeR
 
 	// No node exists, create one.
 	node = &Node{
-		pubkey:   pubkey,
-		jkey:     req.JoinKey,
-		state:    cpb.NodeState_NODE_STATE_NEW,
-		tpmUsage: tpmUsage,
-		labels:   labels,
+		pubkey:       pubkey,
+		jkey:         req.JoinKey,
+		state:        cpb.NodeState_NODE_STATE_NEW,
+		tpmUsage:     tpmUsage,
+		labels:       labels,
+		registeredAt: time.Now(),
 	}
 	if err := nodeSave(ctx, l.leadership, node); err != nil {
 		return nil, err
 	}
+	l.ls.webhooks.emit(webhookNodeRegistered, node.ID())
 
 	// Eat error, as we just deserialized this from a proto.
-	clusterConfig, _ := cl.proto()
+	clusterConfig, _ := cl.publicProto()
 	return &ipb.RegisterNodeResponse{
 		ClusterConfiguration:           clusterConfig,
 		TpmUsage:                       tpmUsage,
@@ -504,8 +623,11 @@ func (l *leaderCurator) CommitNode(ctx context.Context, req *ipb.CommitNodeReque
 		return nil, status.Errorf(codes.Unavailable, "could not emit node credentials: %v", err)
 	}
 
+	now := time.Now()
 	node.state = cpb.NodeState_NODE_STATE_UP
 	node.clusterUnlockKey = req.ClusterUnlockKey
+	node.committedAt = now
+	node.credentialsIssuedAt = now
 	if err := nodeSave(ctx, l.leadership, node); err != nil {
 		return nil, err
 	}
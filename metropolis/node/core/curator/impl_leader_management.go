@@ -4,16 +4,19 @@ import (
 	"bytes"
 	"context"
 	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
 	"sort"
 	"time"
 
+	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	dpb "google.golang.org/protobuf/types/known/durationpb"
 
 	common "source.monogon.dev/metropolis/node"
 	"source.monogon.dev/metropolis/node/core/consensus"
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
 	"source.monogon.dev/metropolis/node/core/identity"
 	"source.monogon.dev/metropolis/node/core/rpc"
 	apb "source.monogon.dev/metropolis/proto/api"
@@ -47,8 +50,10 @@ const (
 	// messages sent by the node.
 	HeartbeatInterval = time.Second * 5
 
-	// HeartbeatTimeout is the duration after which a node is considered to be
-	// timing out, given no recent heartbeat updates were received by the leader.
+	// HeartbeatTimeout is the default duration after which a node is
+	// considered to be timing out, given no recent heartbeat updates were
+	// received by the leader. It can be overridden in the cluster
+	// configuration, see Cluster.HeartbeatTimeout.
 	HeartbeatTimeout = HeartbeatInterval * 2
 )
 
@@ -125,7 +130,7 @@ func (l *leaderManagement) GetClusterInfo(ctx context.Context, req *apb.GetClust
 
 	cl, err := clusterLoad(ctx, l.leadership)
 	if err == nil {
-		resp.ClusterConfiguration, _ = cl.proto()
+		resp.ClusterConfiguration, _ = cl.publicProto()
 	}
 
 	return resp, nil
@@ -142,9 +147,20 @@ func (l *leaderManagement) nodeHeartbeatTimestamp(nid string) time.Time {
 	return time.Time{}
 }
 
+// heartbeatTimeout returns the heartbeat timeout configured for the cluster.
+// If the cluster configuration cannot be loaded, the default is returned.
+func (l *leaderManagement) heartbeatTimeout(ctx context.Context) time.Duration {
+	cl, err := clusterLoad(ctx, l.leadership)
+	if err != nil {
+		rpc.Trace(ctx).Printf("Could not load cluster configuration, using default heartbeat timeout: %v", err)
+		return HeartbeatTimeout
+	}
+	return cl.EffectiveHeartbeatTimeout()
+}
+
 // nodeHealth returns the node's health, along with the duration since last
-// heartbeat was received, given a current timestamp.
-func (l *leaderManagement) nodeHealth(node *Node, now time.Time) (apb.Node_Health, time.Duration) {
+// heartbeat was received, given a current timestamp and the heartbeat timeout.
+func (l *leaderManagement) nodeHealth(node *Node, now time.Time, timeout time.Duration) (apb.Node_Health, time.Duration) {
 	// Get the last received node heartbeat's timestamp.
 	nid := identity.NodeID(node.pubkey)
 	nts := l.nodeHeartbeatTimestamp(nid)
@@ -157,11 +173,11 @@ func (l *leaderManagement) nodeHealth(node *Node, now time.Time) (apb.Node_Healt
 		switch {
 		// If no heartbeats were received, but the leadership has only just
 		// started, the node's health is unknown.
-		case nts.IsZero() && (now.Sub(l.ls.startTs) < HeartbeatTimeout):
+		case nts.IsZero() && (now.Sub(l.ls.startTs) < timeout):
 			nh = apb.Node_UNKNOWN
 		// If the leader had received heartbeats from the node, but the last
 		// heartbeat is stale, the node is timing out.
-		case lhb > HeartbeatTimeout:
+		case lhb > timeout:
 			nh = apb.Node_HEARTBEAT_TIMEOUT
 		// Otherwise, the node can be declared healthy.
 		default:
@@ -175,6 +191,76 @@ func (l *leaderManagement) nodeHealth(node *Node, now time.Time) (apb.Node_Healt
 	return nh, lhb
 }
 
+// nodeProto converts a node into its public representation as returned by
+// GetNodes and GetNode, given a current timestamp and the heartbeat timeout to
+// assess its health.
+func (l *leaderManagement) nodeProto(node *Node, now time.Time, timeout time.Duration) *apb.Node {
+	// Convert node roles.
+	roles := &cpb.NodeRoles{}
+	if node.kubernetesController != nil {
+		roles.KubernetesController = &cpb.NodeRoles_KubernetesController{}
+	}
+	if node.kubernetesWorker != nil {
+		roles.KubernetesWorker = &cpb.NodeRoles_KubernetesWorker{
+			MaxVolumes: node.kubernetesWorker.MaxVolumes,
+		}
+	}
+	if node.consensusMember != nil {
+		roles.ConsensusMember = &cpb.NodeRoles_ConsensusMember{}
+	}
+
+	// Assess the node's health.
+	health, lhb := l.nodeHealth(node, now, timeout)
+
+	entry := &apb.Node{
+		Pubkey:             node.pubkey,
+		Id:                 identity.NodeID(node.pubkey),
+		State:              node.state,
+		Status:             node.status,
+		Roles:              roles,
+		TimeSinceHeartbeat: dpb.New(lhb),
+		Health:             health,
+		TpmUsage:           node.tpmUsage,
+		Labels:             &cpb.NodeLabels{},
+		StateTransitions: &apb.Node_StateTransitions{
+			RegisteredAt: timestampProto(node.registeredAt),
+			ApprovedAt:   timestampProto(node.approvedAt),
+			CommittedAt:  timestampProto(node.committedAt),
+		},
+	}
+	for k, v := range node.labels {
+		entry.Labels.Pairs = append(entry.Labels.Pairs, &cpb.NodeLabels_Pair{
+			Key:   k,
+			Value: v,
+		})
+	}
+	sort.Slice(entry.Labels.Pairs, func(i, j int) bool {
+		return entry.Labels.Pairs[i].Key < entry.Labels.Pairs[j].Key
+	})
+	if len(node.annotations) > 0 {
+		entry.Annotations = make(map[string]string)
+		for k, v := range node.annotations {
+			entry.Annotations[k] = v
+		}
+	}
+	if smv, ok := l.ls.heartbeatUtilization.Load(entry.Id); ok {
+		u := smv.(*ipb.HeartbeatUpdateRequest)
+		entry.CpuUsage = copyFloat(u.CpuUsage)
+		entry.MemoryUsage = copyFloat(u.MemoryUsage)
+		entry.DiskUsage = copyFloat(u.DiskUsage)
+	}
+	return entry
+}
+
+// copyFloat returns a copy of the value pointed to by f, or nil if f is nil.
+func copyFloat(f *float64) *float64 {
+	if f == nil {
+		return nil
+	}
+	v := *f
+	return &v
+}
+
 // GetNodes implements Management.GetNodes, which returns a list of nodes from
 // the point of view of the cluster.
 func (l *leaderManagement) GetNodes(req *apb.GetNodesRequest, srv apb.Management_GetNodesServer) error {
@@ -183,11 +269,27 @@ func (l *leaderManagement) GetNodes(req *apb.GetNodesRequest, srv apb.Management
 	l.muNodes.Lock()
 	defer l.muNodes.Unlock()
 
-	// Retrieve all nodes from etcd in a single Get call.
-	res, err := l.txnAsLeader(ctx, NodeEtcdPrefix.Range())
+	// Retrieve all nodes (or a single page of them) from etcd in a single Get
+	// call. Nodes are retrieved in key order, and a page token is the key of
+	// the last node of the previous page.
+	start, end := NodeEtcdPrefix.KeyRange()
+	if req.PageToken != "" {
+		last, err := base64.RawURLEncoding.DecodeString(req.PageToken)
+		if err != nil || NodeEtcdPrefix.ExtractID(string(last)) == "" {
+			return status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+		// Start right after the last key of the previous page.
+		start = string(last) + "\x00"
+	}
+	opts := []clientv3.OpOption{clientv3.WithRange(end)}
+	if req.PageSize != 0 {
+		opts = append(opts, clientv3.WithLimit(int64(req.PageSize)))
+	}
+	res, err := l.txnAsLeader(ctx, clientv3.OpGet(start, opts...))
 	if err != nil {
 		return status.Errorf(codes.Unavailable, "could not retrieve list of nodes: %v", err)
 	}
+	rr := res.Responses[0].GetResponseRange()
 
 	// Create a CEL filter program, to be used in the reply loop below.
 	filter, err := buildNodeFilter(ctx, req.Filter)
@@ -198,69 +300,123 @@ func (l *leaderManagement) GetNodes(req *apb.GetNodesRequest, srv apb.Management
 	// Get a singular monotonic timestamp to reference node heartbeat timestamps
 	// against.
 	now := time.Now()
+	timeout := l.heartbeatTimeout(ctx)
 
 	// Convert etcd data into proto nodes, send one streaming response for each
 	// node.
-	kvs := res.Responses[0].GetResponseRange().Kvs
-	for _, kv := range kvs {
+	for _, kv := range rr.Kvs {
 		node, err := nodeUnmarshal(kv.Value)
 		if err != nil {
 			rpc.Trace(ctx).Printf("Unmarshalling node %q failed: %v", kv.Value, err)
 			continue
 		}
 
-		// Convert node roles.
-		roles := &cpb.NodeRoles{}
-		if node.kubernetesController != nil {
-			roles.KubernetesController = &cpb.NodeRoles_KubernetesController{}
-		}
-		if node.kubernetesWorker != nil {
-			roles.KubernetesWorker = &cpb.NodeRoles_KubernetesWorker{}
-		}
-		if node.consensusMember != nil {
-			roles.ConsensusMember = &cpb.NodeRoles_ConsensusMember{}
-		}
-
-		// Assess the node's health.
-		health, lhb := l.nodeHealth(node, now)
-
-		entry := apb.Node{
-			Pubkey:             node.pubkey,
-			Id:                 identity.NodeID(node.pubkey),
-			State:              node.state,
-			Status:             node.status,
-			Roles:              roles,
-			TimeSinceHeartbeat: dpb.New(lhb),
-			Health:             health,
-			TpmUsage:           node.tpmUsage,
-			Labels:             &cpb.NodeLabels{},
-		}
-		for k, v := range node.labels {
-			entry.Labels.Pairs = append(entry.Labels.Pairs, &cpb.NodeLabels_Pair{
-				Key:   k,
-				Value: v,
-			})
-		}
-		sort.Slice(entry.Labels.Pairs, func(i, j int) bool {
-			return entry.Labels.Pairs[i].Key < entry.Labels.Pairs[j].Key
-		})
+		entry := l.nodeProto(node, now, timeout)
 
 		// Evaluate the filter expression for this node. Send the node, if it's
 		// kept by the filter.
-		keep, err := filter(ctx, &entry)
+		keep, err := filter(ctx, entry)
 		if err != nil {
 			return err
 		}
 		if !keep {
 			continue
 		}
-		if err := srv.Send(&entry); err != nil {
+		if err := srv.Send(entry); err != nil {
+			return err
+		}
+	}
+
+	// If there are further nodes, end the page with the token to retrieve
+	// them.
+	if rr.More && len(rr.Kvs) > 0 {
+		token := base64.RawURLEncoding.EncodeToString(rr.Kvs[len(rr.Kvs)-1].Key)
+		if err := srv.Send(&apb.Node{NextPageToken: token}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// GetNode implements Management.GetNode, which returns a single node from the
+// point of view of the cluster.
+func (l *leaderManagement) GetNode(ctx context.Context, req *apb.GetNodeRequest) (*apb.Node, error) {
+	var id string
+	switch rid := req.Node.(type) {
+	case *apb.GetNodeRequest_Pubkey:
+		if len(rid.Pubkey) != ed25519.PublicKeySize {
+			return nil, status.Errorf(codes.InvalidArgument, "pubkey must be %d bytes long", ed25519.PublicKeySize)
+		}
+		id = identity.NodeID(rid.Pubkey)
+	case *apb.GetNodeRequest_Id:
+		id = rid.Id
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "exactly one of pubkey or id must be set")
+	}
+
+	node, err := nodeLoad(ctx, l.leadership, id)
+	if errors.Is(err, errNodeNotFound) {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l.nodeProto(node, time.Now(), l.heartbeatTimeout(ctx)), nil
+}
+
+func (l *leaderManagement) GetNodeRegistrationStatus(ctx context.Context, req *apb.GetNodeRegistrationStatusRequest) (*apb.GetNodeRegistrationStatusResponse, error) {
+	var id string
+	switch rid := req.Node.(type) {
+	case *apb.GetNodeRegistrationStatusRequest_Pubkey:
+		if len(rid.Pubkey) != ed25519.PublicKeySize {
+			return nil, status.Errorf(codes.InvalidArgument, "pubkey must be %d bytes long", ed25519.PublicKeySize)
+		}
+		id = identity.NodeID(rid.Pubkey)
+	case *apb.GetNodeRegistrationStatusRequest_Id:
+		id = rid.Id
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "exactly one of pubkey or id must be set")
+	}
+
+	node, err := nodeLoad(ctx, l.leadership, id)
+	if errors.Is(err, errNodeNotFound) {
+		// The node hasn't registered (yet). This is not an error, as answering
+		// exactly this question is the point of this call.
+		return &apb.GetNodeRegistrationStatusResponse{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The recorded times might be missing for nodes that went through the
+	// registration flow before they were recorded, so also infer progress from
+	// the node's current state.
+	var approved, committed bool
+	switch node.state {
+	case cpb.NodeState_NODE_STATE_STANDBY:
+		approved = true
+	case cpb.NodeState_NODE_STATE_UP:
+		approved = true
+		committed = true
+	}
+	approved = approved || !node.approvedAt.IsZero()
+	committed = committed || !node.committedAt.IsZero()
+	// Credentials are issued as part of committing the node.
+	credentialsIssued := committed || !node.credentialsIssuedAt.IsZero()
+
+	return &apb.GetNodeRegistrationStatusResponse{
+		Registered:          true,
+		RegisteredAt:        timestampProto(node.registeredAt),
+		Approved:            approved,
+		ApprovedAt:          timestampProto(node.approvedAt),
+		Committed:           committed,
+		CommittedAt:         timestampProto(node.committedAt),
+		CredentialsIssued:   credentialsIssued,
+		CredentialsIssuedAt: timestampProto(node.credentialsIssuedAt),
+		State:               node.state,
+	}, nil
+}
+
 func (l *leaderManagement) ApproveNode(ctx context.Context, req *apb.ApproveNodeRequest) (*apb.ApproveNodeResponse, error) {
 	// MVP: check if policy allows for this node to be approved for this cluster.
 	// This should happen automatically, if possible, via hardware attestation
@@ -297,9 +453,15 @@ func (l *leaderManagement) ApproveNode(ctx context.Context, req *apb.ApproveNode
 
 	// Set node to be STANDBY.
 	node.state = cpb.NodeState_NODE_STATE_STANDBY
-	if err := nodeSave(ctx, l.leadership, node); err != nil {
+	node.approvedAt = time.Now()
+	audit, err := auditLogOps(ctx, "ApproveNode", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := nodeSave(ctx, l.leadership, node, audit...); err != nil {
 		return nil, err
 	}
+	l.ls.webhooks.emit(webhookNodeApproved, id)
 
 	return &apb.ApproveNodeResponse{}, nil
 }
@@ -363,6 +525,11 @@ func (l *leaderManagement) UpdateNodeRoles(ctx context.Context, req *apb.UpdateN
 			if node.kubernetesController != nil {
 				return nil, status.Errorf(codes.FailedPrecondition, "could not remove consensus member role while node is a kubernetes controller")
 			}
+			if node.consensusMember != nil {
+				if err := l.checkConsensusMemberRemovable(ctx, id); err != nil {
+					return nil, err
+				}
+			}
 			node.DisableConsensusMember()
 		}
 	}
@@ -386,16 +553,125 @@ func (l *leaderManagement) UpdateNodeRoles(ctx context.Context, req *apb.UpdateN
 		}
 	}
 
-	if err := nodeSave(ctx, l.leadership, node); err != nil {
+	if req.KubernetesWorkerMaxVolumes != nil {
+		if node.kubernetesWorker == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "could not set maximum number of volumes: node is not a Kubernetes worker")
+		}
+		node.kubernetesWorker.MaxVolumes = *req.KubernetesWorkerMaxVolumes
+	}
+
+	audit, err := auditLogOps(ctx, "UpdateNodeRoles", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := nodeSave(ctx, l.leadership, node, audit...); err != nil {
 		return nil, err
 	}
 	return &apb.UpdateNodeRolesResponse{}, nil
 }
 
+// checkConsensusMemberRemovable returns a FailedPrecondition error if removing
+// the ConsensusMember role from the node with the given ID would leave the
+// cluster without a healthy consensus member, and thus without a working
+// control plane.
+//
+// Other members are considered healthy if they are UP and not timing out on
+// heartbeats. Members whose health is unknown because the leadership has only
+// just started are given the benefit of the doubt.
+func (l *leaderManagement) checkConsensusMemberRemovable(ctx context.Context, id string) error {
+	members, err := consensusMembers(ctx, l.leadership)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	timeout := l.heartbeatTimeout(ctx)
+	healthy := 0
+	for _, m := range members {
+		if m.ID() == id || m.state != cpb.NodeState_NODE_STATE_UP {
+			continue
+		}
+		if health, _ := l.nodeHealth(m, now, timeout); health != apb.Node_HEARTBEAT_TIMEOUT {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return status.Errorf(codes.FailedPrecondition, "could not remove consensus member role: no other healthy ConsensusMember would remain in the cluster")
+	}
+	return nil
+}
+
 func (l *leaderManagement) DecommissionNode(ctx context.Context, req *apb.DecommissionNodeRequest) (*apb.DecommissionNodeResponse, error) {
-	// Decommissioning is currently unimplemented. We'll get to that soon. For now,
-	// use SafetyBypassNotDecommissioned.
-	return nil, status.Error(codes.Unimplemented, "unimplemented")
+	var id string
+	switch rid := req.Node.(type) {
+	case *apb.DecommissionNodeRequest_Pubkey:
+		if len(rid.Pubkey) != ed25519.PublicKeySize {
+			return nil, status.Errorf(codes.InvalidArgument, "pubkey must be %d bytes long", ed25519.PublicKeySize)
+		}
+		// Convert the pubkey into node ID.
+		id = identity.NodeID(rid.Pubkey)
+	case *apb.DecommissionNodeRequest_Id:
+		id = rid.Id
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "exactly one of pubkey or id must be set")
+	}
+
+	// Take l.muNodes before modifying the node.
+	l.muNodes.Lock()
+	defer l.muNodes.Unlock()
+
+	node, err := nodeLoad(ctx, l.leadership, id)
+	if errors.Is(err, errNodeNotFound) {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "while loading node %s: %v", id, err)
+	}
+
+	// Control plane roles must be removed via UpdateNodeRoles first, which
+	// makes sure the cluster keeps a working control plane.
+	if node.consensusMember != nil {
+		// If the node is the last healthy ConsensusMember, say so, as removing
+		// its role first would fail for that reason.
+		if err := l.checkConsensusMemberRemovable(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, status.Error(codes.FailedPrecondition, "node still has ConsensusMember role")
+	}
+	if node.kubernetesController != nil {
+		return nil, status.Error(codes.FailedPrecondition, "node still has KubernetesController role")
+	}
+
+	switch node.state {
+	case cpb.NodeState_NODE_STATE_NEW, cpb.NodeState_NODE_STATE_STANDBY, cpb.NodeState_NODE_STATE_UP:
+	case cpb.NodeState_NODE_STATE_DECOMMISSIONED:
+		// Already decommissioned, possibly by a previous call which failed
+		// before the node was removed. Just finish the removal.
+	default:
+		return nil, status.Error(codes.Internal, "node has an invalid internal state")
+	}
+
+	// Mark the node as decommissioned first, so that watchers observe the
+	// transition before the node disappears. If removal fails below, the node
+	// stays DECOMMISSIONED and can be cleaned up by a subsequent
+	// DecommissionNode or DeleteNode call.
+	if node.state != cpb.NodeState_NODE_STATE_DECOMMISSIONED {
+		node.state = cpb.NodeState_NODE_STATE_DECOMMISSIONED
+		if err := nodeSave(ctx, l.leadership, node); err != nil {
+			return nil, err
+		}
+	}
+
+	audit, err := auditLogOps(ctx, "DecommissionNode", id)
+	if err != nil {
+		return nil, err
+	}
+	// Remove the node from etcd. This emits a tombstone for the node to all
+	// curator Watch calls.
+	if err := nodeDestroy(ctx, l.leadership, node, audit...); err != nil {
+		return nil, err
+	}
+	l.ls.webhooks.emit(webhookNodeDeleted, id)
+	return &apb.DecommissionNodeResponse{}, nil
 }
 
 func (l *leaderManagement) DeleteNode(ctx context.Context, req *apb.DeleteNodeRequest) (*apb.DeleteNodeResponse, error) {
@@ -468,8 +744,15 @@ func (l *leaderManagement) DeleteNode(ctx context.Context, req *apb.DeleteNodeRe
 	//     verification (which is okay to do on the leader, as the leader always has
 	//     access to cluster data).
 
-	err = nodeDestroy(ctx, l.leadership, node)
-	return &apb.DeleteNodeResponse{}, err
+	audit, err := auditLogOps(ctx, "DeleteNode", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := nodeDestroy(ctx, l.leadership, node, audit...); err != nil {
+		return nil, err
+	}
+	l.ls.webhooks.emit(webhookNodeDeleted, id)
+	return &apb.DeleteNodeResponse{}, nil
 }
 
 func (l *leaderManagement) UpdateNodeLabels(ctx context.Context, req *apb.UpdateNodeLabelsRequest) (*apb.UpdateNodeLabelsResponse, error) {
@@ -545,9 +828,168 @@ func (l *leaderManagement) UpdateNodeLabels(ctx context.Context, req *apb.Update
 	}
 
 	// Save changes.
-	if err := nodeSave(ctx, l.leadership, node); err != nil {
+	audit, err := auditLogOps(ctx, "UpdateNodeLabels", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := nodeSave(ctx, l.leadership, node, audit...); err != nil {
 		return nil, err
 	}
 
 	return &apb.UpdateNodeLabelsResponse{}, nil
 }
+
+func (l *leaderManagement) UpdateNodeAnnotations(ctx context.Context, req *apb.UpdateNodeAnnotationsRequest) (*apb.UpdateNodeAnnotationsResponse, error) {
+	// Get node ID from request.
+	var id string
+	switch rid := req.Node.(type) {
+	case *apb.UpdateNodeAnnotationsRequest_Pubkey:
+		if len(rid.Pubkey) != ed25519.PublicKeySize {
+			return nil, status.Errorf(codes.InvalidArgument, "pubkey must be %d bytes long", ed25519.PublicKeySize)
+		}
+		// Convert the pubkey into node ID.
+		id = identity.NodeID(rid.Pubkey)
+	case *apb.UpdateNodeAnnotationsRequest_Id:
+		id = rid.Id
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "exactly one of pubkey or id must be set")
+	}
+
+	for k, v := range req.Upsert {
+		if err := common.ValidateLabel(k); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid upsert key %q: %v", k, err)
+		}
+		if len(v) > common.MaxAnnotationValueLength {
+			return nil, status.Errorf(codes.InvalidArgument, "upsert value for key %q too long (%d bytes, limit %d)", k, len(v), common.MaxAnnotationValueLength)
+		}
+	}
+	keysToDelete := make(map[string]bool)
+	for _, k := range req.Delete {
+		if err := common.ValidateLabel(k); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid delete key %q: %v", k, err)
+		}
+		if _, ok := req.Upsert[k]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "delete key %q conflicts with upsert key", k)
+		}
+		if keysToDelete[k] {
+			return nil, status.Errorf(codes.InvalidArgument, "repeated delete key %q", k)
+		}
+		keysToDelete[k] = true
+	}
+
+	// Take l.muNodes before modifying the node.
+	l.muNodes.Lock()
+	defer l.muNodes.Unlock()
+
+	// Load the node matching the request.
+	node, err := nodeLoad(ctx, l.leadership, id)
+	if errors.Is(err, errNodeNotFound) {
+		return nil, status.Errorf(codes.NotFound, "node %s not found", id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "while loading node %s: %v", id, err)
+	}
+
+	// Apply changes.
+	for k, v := range req.Upsert {
+		node.annotations[k] = v
+	}
+	for k := range keysToDelete {
+		delete(node.annotations, k)
+	}
+
+	// Check we don't end up with too many annotations.
+	if n := len(node.annotations); n > common.MaxAnnotationsPerNode {
+		return nil, status.Errorf(codes.OutOfRange, "change would result in too many annotations on node (%d, limit %d)", n, common.MaxAnnotationsPerNode)
+	}
+
+	// Save changes.
+	audit, err := auditLogOps(ctx, "UpdateNodeAnnotations", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := nodeSave(ctx, l.leadership, node, audit...); err != nil {
+		return nil, err
+	}
+
+	return &apb.UpdateNodeAnnotationsResponse{}, nil
+}
+
+func (l *leaderManagement) ConfigureCluster(ctx context.Context, req *apb.ConfigureClusterRequest) (*apb.ConfigureClusterResponse, error) {
+	if req.NewConfig == nil {
+		return nil, status.Error(codes.InvalidArgument, "new_config must be set")
+	}
+	if len(req.UpdateMask.GetPaths()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "update_mask must contain at least one path")
+	}
+
+	// Take l.muCluster before modifying the cluster configuration.
+	l.muCluster.Lock()
+	defer l.muCluster.Unlock()
+
+	cl, err := clusterLoad(ctx, l.leadership)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range req.UpdateMask.Paths {
+		switch path {
+		case "leader_election":
+			var ttl time.Duration
+			if le := req.NewConfig.LeaderElection; le != nil {
+				ttl = time.Duration(le.TtlSeconds) * time.Second
+			}
+			if err := validateLeaderTTL(ttl); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid leader_election: %v", err)
+			}
+			cl.LeaderTTL = ttl
+		case "webhooks":
+			var whs []Webhook
+			for _, wh := range req.NewConfig.Webhooks {
+				whs = append(whs, Webhook{
+					URL:    wh.Url,
+					Secret: wh.Secret,
+				})
+			}
+			if err := validateWebhooks(whs); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid webhooks: %v", err)
+			}
+			cl.Webhooks = whs
+		case "node_health":
+			var timeout time.Duration
+			if nh := req.NewConfig.NodeHealth; nh != nil {
+				timeout = time.Duration(nh.HeartbeatTimeoutSeconds) * time.Second
+			}
+			if err := validateHeartbeatTimeout(timeout); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid node_health: %v", err)
+			}
+			cl.HeartbeatTimeout = timeout
+		case "node_directory":
+			var period time.Duration
+			if nd := req.NewConfig.NodeDirectory; nd != nil {
+				period = time.Duration(nd.StaleNodeGracePeriodSeconds) * time.Second
+			}
+			if err := validateStaleNodeGracePeriod(period); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid node_directory: %v", err)
+			}
+			cl.StaleNodeGracePeriod = period
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported update_mask path %q", path)
+		}
+	}
+
+	audit, err := auditLogOps(ctx, "ConfigureCluster", "")
+	if err != nil {
+		return nil, err
+	}
+	if err := clusterSave(ctx, l.leadership, cl, audit...); err != nil {
+		return nil, err
+	}
+	resulting, err := cl.publicProto()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not convert resulting cluster configuration: %v", err)
+	}
+	return &apb.ConfigureClusterResponse{
+		ResultingConfig: resulting,
+	}, nil
+}
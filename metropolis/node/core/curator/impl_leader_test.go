@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -19,9 +20,13 @@ import (
 	"go.etcd.io/etcd/tests/v3/integration"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	common "source.monogon.dev/metropolis/node"
@@ -661,6 +666,256 @@ func TestWatchNodesInCluster(t *testing.T) {
 	}
 }
 
+// TestWatchNodesInClusterCoalesced exercises a NodesInCluster Watch with a
+// coalesce window, expecting multiple quick updates to a node to be delivered
+// as a single WatchEvent.
+func TestWatchNodesInClusterCoalesced(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cur := ipb.NewCuratorClient(cl.localNodeConn)
+
+	// Negative windows must be rejected.
+	w, err := cur.Watch(ctx, &ipb.WatchRequest{
+		Kind: &ipb.WatchRequest_NodesInCluster_{
+			NodesInCluster: &ipb.WatchRequest_NodesInCluster{
+				CoalesceWindow: durationpb.New(-time.Second),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if _, err := w.Recv(); err == nil {
+		t.Fatalf("Recv with negative coalesce window should have failed")
+	}
+
+	w, err = cur.Watch(ctx, &ipb.WatchRequest{
+		Kind: &ipb.WatchRequest_NodesInCluster_{
+			NodesInCluster: &ipb.WatchRequest_NodesInCluster{
+				CoalesceWindow: durationpb.New(2 * time.Second),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	for {
+		ev, err := w.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if ev.Progress == ipb.WatchEvent_PROGRESS_LAST_BACKLOGGED {
+			break
+		}
+	}
+
+	// Update the node status twice in quick succession.
+	for _, addr := range []string{"203.0.113.43", "203.0.113.44"} {
+		_, err = cur.UpdateNodeStatus(ctx, &ipb.UpdateNodeStatusRequest{
+			NodeId: cl.localNodeID,
+			Status: &cpb.NodeStatus{
+				ExternalAddress: addr,
+			},
+		})
+		if err != nil {
+			t.Fatalf("UpdateNodeStatus: %v", err)
+		}
+	}
+
+	// Expect a single event carrying only the newest state.
+	ev, err := w.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if want, got := 1, len(ev.Nodes); want != got {
+		t.Fatalf("Wanted %d node in event, got %d", want, got)
+	}
+	n := ev.Nodes[0]
+	if n.Id != cl.localNodeID {
+		t.Errorf("Wanted node %q, got %q", cl.localNodeID, n.Id)
+	}
+	if n.Status == nil || n.Status.ExternalAddress != "203.0.113.44" {
+		t.Errorf("Wanted newest external address, got %v", n.Status)
+	}
+}
+
+// TestWatchNodesInClusterResume exercises resuming a NodesInCluster watch from
+// a revision received from a previous watch.
+func TestWatchNodesInClusterResume(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cur := ipb.NewCuratorClient(cl.localNodeConn)
+
+	// watch starts a NodesInCluster watch from the given revision and returns
+	// the backlog, keyed by node ID (nil for tombstones), and the revision of
+	// the last backlogged event.
+	watch := func(rev int64) (map[string]*ipb.Node, int64, error) {
+		wctx, wctxC := context.WithCancel(ctx)
+		defer wctxC()
+		w, err := cur.Watch(wctx, &ipb.WatchRequest{
+			Kind: &ipb.WatchRequest_NodesInCluster_{
+				NodesInCluster: &ipb.WatchRequest_NodesInCluster{
+					ResumeRevision: rev,
+				},
+			},
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		nodes := make(map[string]*ipb.Node)
+		for {
+			ev, err := w.Recv()
+			if err != nil {
+				return nil, 0, err
+			}
+			for _, n := range ev.Nodes {
+				nodes[n.Id] = n
+			}
+			for _, nt := range ev.NodeTombstones {
+				nodes[nt.NodeId] = nil
+			}
+			if ev.Progress == ipb.WatchEvent_PROGRESS_LAST_BACKLOGGED {
+				return nodes, ev.Revision, nil
+			}
+			if ev.Revision != 0 {
+				return nil, 0, fmt.Errorf("unexpected revision in backlog")
+			}
+		}
+	}
+
+	gone := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP })
+
+	// Full resync.
+	nodes, rev, err := watch(0)
+	if err != nil {
+		t.Fatalf("Initial watch: %v", err)
+	}
+	if len(nodes) != 2 || nodes[cl.localNodeID] == nil || nodes[gone.ID()] == nil {
+		t.Fatalf("Initial watch returned unexpected nodes: %v", nodes)
+	}
+	if rev == 0 {
+		t.Fatalf("Initial watch returned no revision")
+	}
+
+	// Resuming without changes should return an empty backlog.
+	nodes, rev2, err := watch(rev)
+	if err != nil {
+		t.Fatalf("Resumed watch: %v", err)
+	}
+	if len(nodes) != 0 {
+		t.Errorf("Resumed watch without changes returned nodes: %v", nodes)
+	}
+	if rev2 != rev {
+		t.Errorf("Resumed watch without changes returned revision %d, wanted %d", rev2, rev)
+	}
+
+	// Remove a node and add another.
+	k, _ := NodeEtcdPrefix.Key(gone.ID())
+	if _, err := cl.etcd.Delete(ctx, k); err != nil {
+		t.Fatalf("could not delete node from etcd: %v", err)
+	}
+	added := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_NEW })
+
+	// Only the changes should be returned when resuming.
+	nodes, rev2, err = watch(rev)
+	if err != nil {
+		t.Fatalf("Resumed watch: %v", err)
+	}
+	if n, ok := nodes[gone.ID()]; !ok || n != nil {
+		t.Errorf("Expected tombstone for node %s, got %v (present: %v)", gone.ID(), n, ok)
+	}
+	if n := nodes[added.ID()]; n == nil || n.State != cpb.NodeState_NODE_STATE_NEW {
+		t.Errorf("Expected new node %s, got %v", added.ID(), n)
+	}
+	if _, ok := nodes[cl.localNodeID]; ok {
+		t.Errorf("Unchanged node %s returned in resumed watch", cl.localNodeID)
+	}
+	if len(nodes) != 2 {
+		t.Errorf("Expected 2 changes, got %v", nodes)
+	}
+	if rev2 <= rev {
+		t.Errorf("Resumed watch returned revision %d, wanted newer than %d", rev2, rev)
+	}
+
+	// Invalid revisions must be rejected.
+	if _, _, err := watch(-1); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Watch with negative revision: wanted InvalidArgument, got %v", err)
+	}
+	if _, _, err := watch(rev2 + 1000); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Watch with future revision: wanted InvalidArgument, got %v", err)
+	}
+}
+
+// TestRegisterNodeRateLimit ensures that callers presenting invalid register
+// tickets are locked out for an increasing duration, and that valid tickets are
+// honored again after the lockout.
+func TestRegisterNodeRateLimit(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	// Replace the limiter's clock with a fake one.
+	now := time.Now()
+	cl.l.ls.registerLimiter.now = func() time.Time { return now }
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	ticket, err := mgmt.GetRegisterTicket(ctx, &apb.GetRegisterTicketRequest{})
+	if err != nil {
+		t.Fatalf("GetRegisterTicket: %v", err)
+	}
+	joinPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate join keypair: %v", err)
+	}
+
+	cur := ipb.NewCuratorClient(cl.otherNodeConn)
+	register := func(ticket []byte) codes.Code {
+		_, err := cur.RegisterNode(ctx, &ipb.RegisterNodeRequest{
+			RegisterTicket: ticket,
+			JoinKey:        joinPub,
+			HaveLocalTpm:   true,
+		})
+		return status.Code(err)
+	}
+
+	// The first invalid tickets are rejected as such.
+	for i := 0; i < registerFreeAttempts+1; i++ {
+		if want, got := codes.PermissionDenied, register([]byte("invalid")); want != got {
+			t.Fatalf("Attempt %d: wanted %s, got %s", i, want, got)
+		}
+	}
+	// Afterwards, the caller is locked out, even with a valid ticket.
+	if want, got := codes.ResourceExhausted, register(ticket.Ticket); want != got {
+		t.Fatalf("Locked out with valid ticket: wanted %s, got %s", want, got)
+	}
+	pub := cl.otherNodePriv.Public().(ed25519.PublicKey)
+	if want, got := registerInitialLockout, cl.l.ls.registerLimiter.lockedOut(pub); want != got {
+		t.Errorf("Wanted lockout of %s, got %s", want, got)
+	}
+
+	// Further invalid tickets after the lockout double the lockout duration.
+	now = now.Add(registerInitialLockout)
+	if want, got := codes.PermissionDenied, register([]byte("invalid")); want != got {
+		t.Fatalf("Invalid ticket after lockout: wanted %s, got %s", want, got)
+	}
+	if want, got := 2*registerInitialLockout, cl.l.ls.registerLimiter.lockedOut(pub); want != got {
+		t.Errorf("Wanted lockout of %s, got %s", want, got)
+	}
+
+	// After the lockout, a valid ticket is honored.
+	now = now.Add(2 * registerInitialLockout)
+	if want, got := codes.OK, register(ticket.Ticket); want != got {
+		t.Fatalf("Valid ticket after lockout: wanted %s, got %s", want, got)
+	}
+	if got := cl.l.ls.registerLimiter.lockedOut(pub); got != 0 {
+		t.Errorf("Wanted no lockout after successful registration, got %s", got)
+	}
+}
+
 // TestRegistration exercises the node 'Register' (a.k.a. Registration) flow,
 // which is described in the Cluster Lifecycle design document.
 //
@@ -701,6 +956,38 @@ func TestRegistration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("could not generate node join keypair: %v", err)
 	}
+	expectRegistrationStatus := func(registered, approved, committed bool) {
+		t.Helper()
+		res, err := mgmt.GetNodeRegistrationStatus(ctx, &apb.GetNodeRegistrationStatusRequest{
+			Node: &apb.GetNodeRegistrationStatusRequest_Id{
+				Id: cl.otherNodeID,
+			},
+		})
+		if err != nil {
+			t.Fatalf("GetNodeRegistrationStatus failed: %v", err)
+		}
+		for _, step := range []struct {
+			name      string
+			want, got bool
+			at        *timestamppb.Timestamp
+		}{
+			{"registered", registered, res.Registered, res.RegisteredAt},
+			{"approved", approved, res.Approved, res.ApprovedAt},
+			{"committed", committed, res.Committed, res.CommittedAt},
+			{"credentials issued", committed, res.CredentialsIssued, res.CredentialsIssuedAt},
+		} {
+			if step.want != step.got {
+				t.Errorf("Expected %s to be %v, got %v", step.name, step.want, step.got)
+			}
+			if step.want != (step.at != nil) {
+				t.Errorf("Expected %s timestamp to be set: %v, got %v", step.name, step.want, step.at)
+			}
+		}
+	}
+
+	// Node should not be known to the cluster yet.
+	expectRegistrationStatus(false, false, false)
+
 	// Register 'other node' into cluster.
 	cur := ipb.NewCuratorClient(cl.otherNodeConn)
 	_, err = cur.RegisterNode(ctx, &ipb.RegisterNodeRequest{
@@ -711,6 +998,7 @@ func TestRegistration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("RegisterNode failed: %v", err)
 	}
+	expectRegistrationStatus(true, false, false)
 
 	expectOtherNode := func(state cpb.NodeState) {
 		t.Helper()
@@ -746,6 +1034,7 @@ func TestRegistration(t *testing.T) {
 
 	// Expect node to be 'STANDBY'.
 	expectOtherNode(cpb.NodeState_NODE_STATE_STANDBY)
+	expectRegistrationStatus(true, true, false)
 
 	// Approve call should be idempotent and not fail when called a second time.
 	_, err = mgmt.ApproveNode(ctx, &apb.ApproveNodeRequest{Pubkey: otherNodePub})
@@ -767,6 +1056,7 @@ func TestRegistration(t *testing.T) {
 
 	// Expect node to be 'UP'.
 	expectOtherNode(cpb.NodeState_NODE_STATE_UP)
+	expectRegistrationStatus(true, true, true)
 }
 
 // TestJoin exercises Join Flow, as described in "Cluster Lifecycle" design
@@ -962,6 +1252,28 @@ func TestClusterHeartbeat(t *testing.T) {
 		expectNode(cl.localNodeID, apb.Node_HEALTHY)
 	}
 
+	// Utilization sent in heartbeats should be exposed by GetNodes and usable
+	// in filters. Invalid samples should be dropped.
+	cpu, mem := 0.75, 1.5
+	for _, req := range []*ipb.HeartbeatUpdateRequest{
+		{CpuUsage: &cpu},
+		{CpuUsage: &mem, MemoryUsage: &mem},
+	} {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("While sending a heartbeat: %v", err)
+		}
+		if _, err := stream.Recv(); err != nil {
+			t.Fatalf("While receiving a heartbeat reply: %v", err)
+		}
+	}
+	nodes := getNodes(t, ctx, mgmt, "has(node.cpu_usage) && node.cpu_usage > 0.5")
+	if len(nodes) != 1 {
+		t.Fatalf("Expected one busy node, got %d", len(nodes))
+	}
+	if n := nodes[0]; n.CpuUsage == nil || *n.CpuUsage != cpu || n.MemoryUsage != nil || n.DiskUsage != nil {
+		t.Errorf("Unexpected utilization: cpu %v, memory %v, disk %v", n.CpuUsage, n.MemoryUsage, n.DiskUsage)
+	}
+
 	// This case tests timing out from a healthy state. The passage of time is
 	// simulated by an adjustment of curator leader's timestamp entry
 	// corresponding to the tested node's ID.
@@ -971,6 +1283,23 @@ func TestClusterHeartbeat(t *testing.T) {
 	cl.l.ls.heartbeatTimestamps.Store(cl.localNodeID, lts)
 	expectNode(cl.localNodeID, apb.Node_HEARTBEAT_TIMEOUT)
 
+	// With a longer heartbeat timeout configured for the cluster, the same node
+	// is considered HEALTHY again.
+	_, err = mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+		NewConfig: &cpb.ClusterConfiguration{
+			NodeHealth: &cpb.ClusterConfiguration_NodeHealth{
+				HeartbeatTimeoutSeconds: 60,
+			},
+		},
+		UpdateMask: &fieldmaskpb.FieldMask{
+			Paths: []string{"node_health"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConfigureCluster: %v", err)
+	}
+	expectNode(cl.localNodeID, apb.Node_HEALTHY)
+
 	// This case verifies that health of non-UP nodes is assessed to be UNKNOWN,
 	// regardless of leadership tenure, since only UP nodes are capable of
 	// sending heartbeats.
@@ -1112,6 +1441,41 @@ func TestGetNodes(t *testing.T) {
 		t.Fatalf("management.GetNodes returned a node which isn't a Kubernetes worker.")
 	}
 
+	// The same applies to all other roles.
+	cmn := putNode(t, ctx, cl.l, func(n *Node) {
+		n.consensusMember = &NodeRoleConsensusMember{}
+	})
+	kcn := putNode(t, ctx, cl.l, func(n *Node) {
+		n.kubernetesController = &NodeRoleKubernetesController{}
+	})
+	cmr := getNodes(t, ctx, mgmt, "has(node.roles.consensus_member)")
+	if !exists(cmn, cmr) {
+		t.Fatalf("management.GetNodes didn't return the consensus member node.")
+	}
+	if exists(kcn, cmr) || exists(in, cmr) {
+		t.Fatalf("management.GetNodes returned a node which isn't a consensus member.")
+	}
+	kcr := getNodes(t, ctx, mgmt, "has(node.roles.kubernetes_controller)")
+	if !exists(kcn, kcr) {
+		t.Fatalf("management.GetNodes didn't return the Kubernetes controller node.")
+	}
+	if exists(cmn, kcr) || exists(in, kcr) {
+		t.Fatalf("management.GetNodes returned a node which isn't a Kubernetes controller.")
+	}
+
+	// Exercise filtering on state transition times.
+	apn := putNode(t, ctx, cl.l, func(n *Node) {
+		n.state = cpb.NodeState_NODE_STATE_STANDBY
+		n.approvedAt = time.Now().Add(-time.Hour)
+	})
+	apr := getNodes(t, ctx, mgmt, "has(node.state_transitions.approved_at) && node.state_transitions.approved_at < timestamp('"+time.Now().Add(-30*time.Minute).Format(time.RFC3339)+"')")
+	if !exists(apn, apr) {
+		t.Fatalf("management.GetNodes didn't return the node approved an hour ago.")
+	}
+	if exists(in, apr) {
+		t.Fatalf("management.GetNodes returned a node which was never approved.")
+	}
+
 	// Exercise duration-based filtering. Start with setting up node and
 	// leadership timestamps much like in TestClusterHeartbeat.
 	tsn := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP })
@@ -1135,6 +1499,172 @@ func TestGetNodes(t *testing.T) {
 	}
 }
 
+// TestGetNodesPagination exercises pagination of management.GetNodes.
+func TestGetNodesPagination(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	for i := 0; i < 10; i++ {
+		state := cpb.NodeState_NODE_STATE_NEW
+		if i%2 == 0 {
+			state = cpb.NodeState_NODE_STATE_UP
+		}
+		putNode(t, ctx, cl.l, func(n *Node) { n.state = state })
+	}
+	all := getNodes(t, ctx, mgmt, "")
+	up := getNodes(t, ctx, mgmt, "node.state == NODE_STATE_UP")
+
+	// getPages retrieves all pages with the given size and filter, returning
+	// the IDs of all nodes and the number of pages.
+	getPages := func(pageSize uint32, filter string) ([]string, int) {
+		t.Helper()
+		var ids []string
+		var token string
+		for pages := 1; ; pages++ {
+			srv, err := mgmt.GetNodes(ctx, &apb.GetNodesRequest{
+				Filter:    filter,
+				PageSize:  pageSize,
+				PageToken: token,
+			})
+			if err != nil {
+				t.Fatalf("GetNodes failed: %v", err)
+			}
+			var n int
+			token = ""
+			for {
+				node, err := srv.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Recv failed: %v", err)
+				}
+				if token != "" {
+					t.Fatalf("node %q received after next page token", node.Id)
+				}
+				if node.NextPageToken != "" {
+					if node.Id != "" {
+						t.Fatalf("next page token sent alongside node %q", node.Id)
+					}
+					token = node.NextPageToken
+					continue
+				}
+				ids = append(ids, node.Id)
+				n++
+			}
+			if n > int(pageSize) {
+				t.Fatalf("page contained %d nodes, wanted at most %d", n, pageSize)
+			}
+			if token == "" {
+				return ids, pages
+			}
+		}
+	}
+	ids := func(nodes []*apb.Node) []string {
+		var res []string
+		for _, n := range nodes {
+			res = append(res, n.Id)
+		}
+		return res
+	}
+
+	// Paginating through all nodes should return them in the same order as
+	// without pagination.
+	got, pages := getPages(3, "")
+	if diff := cmp.Diff(ids(all), got); diff != "" {
+		t.Errorf("paginated nodes differ (-want +got):\n%s", diff)
+	}
+	if want := (len(all) + 2) / 3; pages != want {
+		t.Errorf("got %d pages, wanted %d", pages, want)
+	}
+	// The filter applies within each page.
+	got, _ = getPages(3, "node.state == NODE_STATE_UP")
+	if diff := cmp.Diff(ids(up), got); diff != "" {
+		t.Errorf("paginated filtered nodes differ (-want +got):\n%s", diff)
+	}
+
+	// Invalid page tokens should be rejected.
+	for _, token := range []string{"invalid!", base64.RawURLEncoding.EncodeToString([]byte("/other/key"))} {
+		srv, err := mgmt.GetNodes(ctx, &apb.GetNodesRequest{PageSize: 3, PageToken: token})
+		if err == nil {
+			_, err = srv.Recv()
+		}
+		if want, got := codes.InvalidArgument, status.Code(err); want != got {
+			t.Errorf("GetNodes with page token %q returned %s, wanted %s", token, got, want)
+		}
+	}
+}
+
+// TestGetNode exercises management.GetNode.
+func TestGetNode(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	node := putNode(t, ctx, cl.l, func(n *Node) {
+		n.state = cpb.NodeState_NODE_STATE_UP
+		n.kubernetesWorker = &NodeRoleKubernetesWorker{}
+		n.labels = map[string]string{"foo": "bar"}
+	})
+	id := identity.NodeID(node.pubkey)
+
+	for _, req := range []*apb.GetNodeRequest{
+		{Node: &apb.GetNodeRequest_Id{Id: id}},
+		{Node: &apb.GetNodeRequest_Pubkey{Pubkey: node.pubkey}},
+	} {
+		res, err := mgmt.GetNode(ctx, req)
+		if err != nil {
+			t.Fatalf("GetNode(%v): %v", req, err)
+		}
+		if res.Id != id || !bytes.Equal(res.Pubkey, node.pubkey) {
+			t.Errorf("GetNode(%v) returned wrong node %s", req, res.Id)
+		}
+		if res.State != cpb.NodeState_NODE_STATE_UP {
+			t.Errorf("GetNode(%v) returned wrong state %s", req, res.State)
+		}
+		if res.Roles.KubernetesWorker == nil {
+			t.Errorf("GetNode(%v) is missing KubernetesWorker role", req)
+		}
+		if len(res.Labels.Pairs) != 1 || res.Labels.Pairs[0].Key != "foo" || res.Labels.Pairs[0].Value != "bar" {
+			t.Errorf("GetNode(%v) returned wrong labels %v", req, res.Labels)
+		}
+	}
+
+	// The result should match what GetNodes returns, apart from the time since
+	// the last heartbeat.
+	nodes := getNodes(t, ctx, mgmt, fmt.Sprintf("node.id == %q", id))
+	if len(nodes) != 1 {
+		t.Fatalf("GetNodes returned %d nodes, wanted 1", len(nodes))
+	}
+	res, err := mgmt.GetNode(ctx, &apb.GetNodeRequest{Node: &apb.GetNodeRequest_Id{Id: id}})
+	if err != nil {
+		t.Fatalf("GetNode: %v", err)
+	}
+	res.TimeSinceHeartbeat, nodes[0].TimeSinceHeartbeat = nil, nil
+	if !proto.Equal(res, nodes[0]) {
+		t.Errorf("GetNode returned %v, GetNodes returned %v", res, nodes[0])
+	}
+
+	// Missing nodes and invalid requests should fail.
+	_, err = mgmt.GetNode(ctx, &apb.GetNodeRequest{Node: &apb.GetNodeRequest_Id{Id: "metropolis-fake"}})
+	if want, got := codes.NotFound, status.Code(err); want != got {
+		t.Errorf("GetNode of missing node returned %s, wanted %s", got, want)
+	}
+	_, err = mgmt.GetNode(ctx, &apb.GetNodeRequest{Node: &apb.GetNodeRequest_Pubkey{Pubkey: []byte("short")}})
+	if want, got := codes.InvalidArgument, status.Code(err); want != got {
+		t.Errorf("GetNode with invalid pubkey returned %s, wanted %s", got, want)
+	}
+	_, err = mgmt.GetNode(ctx, &apb.GetNodeRequest{})
+	if want, got := codes.InvalidArgument, status.Code(err); want != got {
+		t.Errorf("GetNode without node returned %s, wanted %s", got, want)
+	}
+}
+
 // TestUpdateNodeRoles exercises management.UpdateNodeRoles by running it
 // against some newly created nodes, and verifying the effect by examining
 // results delivered by a subsequent call to management.GetNodes.
@@ -1236,12 +1766,192 @@ func TestUpdateNodeRoles(t *testing.T) {
 			KubernetesController: opt(true),
 			ConsensusMember:      nil,
 		},
-	}
-	for _, e := range uf {
-		_, err := mgmt.UpdateNodeRoles(ctx, e)
-		if err == nil {
-			t.Fatalf("expected an error from management.UpdateNodeRoles, got nil.")
-		}
+	}
+	for _, e := range uf {
+		_, err := mgmt.UpdateNodeRoles(ctx, e)
+		if err == nil {
+			t.Fatalf("expected an error from management.UpdateNodeRoles, got nil.")
+		}
+	}
+}
+
+// TestUpdateNodeRolesMaxVolumes exercises setting the volume limit of a
+// KubernetesWorker through UpdateNodeRoles.
+func TestUpdateNodeRolesMaxVolumes(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	node := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP })
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	opt := func(v bool) *bool { return &v }
+	optU := func(v uint32) *uint32 { return &v }
+	update := func(worker *bool, maxVolumes *uint32) error {
+		_, err := mgmt.UpdateNodeRoles(ctx, &apb.UpdateNodeRolesRequest{
+			Node: &apb.UpdateNodeRolesRequest_Id{
+				Id: node.ID(),
+			},
+			KubernetesWorker:           worker,
+			KubernetesWorkerMaxVolumes: maxVolumes,
+		})
+		return err
+	}
+	expectMaxVolumes := func(want uint32) {
+		t.Helper()
+		nodes := getNodes(t, ctx, mgmt, "")
+		for _, n := range nodes {
+			if n.Id != node.ID() {
+				continue
+			}
+			if n.Roles.KubernetesWorker == nil {
+				t.Fatalf("node is not a KubernetesWorker")
+			}
+			if got := n.Roles.KubernetesWorker.MaxVolumes; got != want {
+				t.Fatalf("wanted max volumes %d, got %d", want, got)
+			}
+			return
+		}
+		t.Fatalf("node not found")
+	}
+
+	// The limit can only be set on workers.
+	if err := update(nil, optU(16)); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("wanted FailedPrecondition when setting limit on non-worker, got %v", err)
+	}
+
+	if err := update(opt(true), optU(16)); err != nil {
+		t.Fatalf("UpdateNodeRoles: %v", err)
+	}
+	expectMaxVolumes(16)
+
+	if err := update(nil, optU(8)); err != nil {
+		t.Fatalf("UpdateNodeRoles: %v", err)
+	}
+	expectMaxVolumes(8)
+
+	// Enabling the role again must keep the limit.
+	if err := update(opt(true), nil); err != nil {
+		t.Fatalf("UpdateNodeRoles: %v", err)
+	}
+	expectMaxVolumes(8)
+
+	if err := update(opt(false), nil); err != nil {
+		t.Fatalf("UpdateNodeRoles: %v", err)
+	}
+	if err := update(nil, optU(0)); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("wanted FailedPrecondition after removing worker role, got %v", err)
+	}
+}
+
+// TestUpdateNodeRolesLastConsensusMember ensures that UpdateNodeRoles refuses
+// to remove the ConsensusMember role from the last healthy consensus member.
+func TestUpdateNodeRolesLastConsensusMember(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	var tn []*Node
+	tn = append(tn, putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP }))
+	tn = append(tn, putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP }))
+
+	opt := func(v bool) *bool { return &v }
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	setConsensusMember := func(n *Node, v bool) error {
+		_, err := mgmt.UpdateNodeRoles(ctx, &apb.UpdateNodeRolesRequest{
+			Node: &apb.UpdateNodeRolesRequest_Id{
+				Id: n.ID(),
+			},
+			ConsensusMember: opt(v),
+		})
+		return err
+	}
+
+	for i, n := range tn {
+		if err := setConsensusMember(n, true); err != nil {
+			t.Fatalf("Making node %d a ConsensusMember failed: %v", i, err)
+		}
+	}
+
+	// With two members, one of them can be demoted.
+	if err := setConsensusMember(tn[0], false); err != nil {
+		t.Fatalf("Demoting one of two ConsensusMembers failed: %v", err)
+	}
+	// Demoting a node which isn't a ConsensusMember is a no-op.
+	if err := setConsensusMember(tn[0], false); err != nil {
+		t.Fatalf("Demoting a non-ConsensusMember failed: %v", err)
+	}
+	// The sole remaining member must not be demoted.
+	err := setConsensusMember(tn[1], false)
+	if want, got := codes.FailedPrecondition, status.Code(err); want != got {
+		t.Fatalf("Demoting the last ConsensusMember: wanted %s, got %v", want, err)
+	}
+
+	// Verify that the last member still has its role.
+	for _, n := range getNodes(t, ctx, mgmt, "") {
+		if bytes.Equal(n.Pubkey, tn[1].pubkey) && n.Roles.ConsensusMember == nil {
+			t.Fatalf("Last ConsensusMember lost its role")
+		}
+	}
+}
+
+// TestLastHealthyConsensusMember ensures that a ConsensusMember can neither be
+// demoted nor decommissioned if the only other ConsensusMember is unhealthy.
+func TestLastHealthyConsensusMember(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	healthy := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP })
+	unhealthy := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP })
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	yes, no := true, false
+	for i, n := range []*Node{healthy, unhealthy} {
+		_, err := mgmt.UpdateNodeRoles(ctx, &apb.UpdateNodeRolesRequest{
+			Node: &apb.UpdateNodeRolesRequest_Id{
+				Id: n.ID(),
+			},
+			ConsensusMember: &yes,
+		})
+		if err != nil {
+			t.Fatalf("Making node %d a ConsensusMember failed: %v", i, err)
+		}
+	}
+
+	// Simulate the second member timing out on heartbeats.
+	cl.l.ls.heartbeatTimestamps.Store(unhealthy.ID(), time.Now().Add(-2*HeartbeatTimeout))
+
+	_, err := mgmt.UpdateNodeRoles(ctx, &apb.UpdateNodeRolesRequest{
+		Node: &apb.UpdateNodeRolesRequest_Id{
+			Id: healthy.ID(),
+		},
+		ConsensusMember: &no,
+	})
+	if want, got := codes.FailedPrecondition, status.Code(err); want != got {
+		t.Fatalf("Demoting the last healthy ConsensusMember: wanted %s, got %v", want, err)
+	}
+	_, err = mgmt.DecommissionNode(ctx, &apb.DecommissionNodeRequest{
+		Node: &apb.DecommissionNodeRequest_Id{
+			Id: healthy.ID(),
+		},
+	})
+	if want, got := codes.FailedPrecondition, status.Code(err); want != got {
+		t.Fatalf("Decommissioning the last healthy ConsensusMember: wanted %s, got %v", want, err)
+	}
+	if want, got := "no other healthy ConsensusMember", status.Convert(err).Message(); !strings.Contains(got, want) {
+		t.Errorf("Decommissioning the last healthy ConsensusMember: wanted error containing %q, got %q", want, got)
+	}
+
+	// Once the second member is healthy again, the first one can be demoted.
+	cl.l.ls.heartbeatTimestamps.Store(unhealthy.ID(), time.Now())
+	_, err = mgmt.UpdateNodeRoles(ctx, &apb.UpdateNodeRolesRequest{
+		Node: &apb.UpdateNodeRolesRequest_Id{
+			Id: healthy.ID(),
+		},
+		ConsensusMember: &no,
+	})
+	if err != nil {
+		t.Fatalf("Demoting a ConsensusMember with a healthy peer failed: %v", err)
 	}
 }
 
@@ -1344,6 +2054,130 @@ func TestDeleteNode(t *testing.T) {
 	}
 }
 
+// TestDecommissionNode exercises DecommissionNode, ensuring that decommissioned
+// nodes are removed from the cluster and that nodes with control plane roles
+// cannot be decommissioned.
+func TestDecommissionNode(t *testing.T) {
+	cl := fakeLeader(t)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+	cur := ipb.NewCuratorClient(cl.localNodeConn)
+
+	// Create the test nodes: two ConsensusMembers, one of which is also a
+	// KubernetesController, and a worker.
+	member := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP })
+	controller := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP })
+	worker := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_UP })
+	yes := true
+	for _, n := range []*Node{member, controller} {
+		_, err := mgmt.UpdateNodeRoles(ctx, &apb.UpdateNodeRolesRequest{
+			Node: &apb.UpdateNodeRolesRequest_Id{
+				Id: n.ID(),
+			},
+			ConsensusMember: &yes,
+		})
+		if err != nil {
+			t.Fatalf("Making node a ConsensusMember failed: %v", err)
+		}
+	}
+	_, err := mgmt.UpdateNodeRoles(ctx, &apb.UpdateNodeRolesRequest{
+		Node: &apb.UpdateNodeRolesRequest_Id{
+			Id: controller.ID(),
+		},
+		KubernetesController: &yes,
+	})
+	if err != nil {
+		t.Fatalf("Making node a KubernetesController failed: %v", err)
+	}
+
+	w, err := cur.Watch(ctx, &ipb.WatchRequest{
+		Kind: &ipb.WatchRequest_NodesInCluster_{
+			NodesInCluster: &ipb.WatchRequest_NodesInCluster{},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	for {
+		ev, err := w.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if ev.Progress == ipb.WatchEvent_PROGRESS_LAST_BACKLOGGED {
+			break
+		}
+	}
+
+	// Decommissioning nodes with control plane roles must fail, even if other
+	// ConsensusMembers remain.
+	for _, n := range []*Node{member, controller} {
+		_, err = mgmt.DecommissionNode(ctx, &apb.DecommissionNodeRequest{
+			Node: &apb.DecommissionNodeRequest_Id{
+				Id: n.ID(),
+			},
+		})
+		if want, got := codes.FailedPrecondition, status.Code(err); want != got {
+			t.Fatalf("Decommissioning node with control plane roles: wanted %s, got %v", want, err)
+		}
+	}
+
+	// Decommissioning the worker must succeed.
+	_, err = mgmt.DecommissionNode(ctx, &apb.DecommissionNodeRequest{
+		Node: &apb.DecommissionNodeRequest_Pubkey{
+			Pubkey: worker.pubkey,
+		},
+	})
+	if err != nil {
+		t.Fatalf("DecommissionNode: %v", err)
+	}
+
+	// The watcher should first see the node transition into DECOMMISSIONED
+	// (unless coalesced), then a tombstone for it.
+	for {
+		ev, err := w.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		for _, n := range ev.Nodes {
+			if n.Id != worker.ID() {
+				continue
+			}
+			if want, got := cpb.NodeState_NODE_STATE_DECOMMISSIONED, n.State; want != got {
+				t.Errorf("Wanted node in state %s, got %s", want, got)
+			}
+		}
+		done := false
+		for _, nt := range ev.NodeTombstones {
+			if nt.NodeId == worker.ID() {
+				done = true
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	// The node should be gone.
+	_, err = mgmt.GetNode(ctx, &apb.GetNodeRequest{
+		Node: &apb.GetNodeRequest_Id{
+			Id: worker.ID(),
+		},
+	})
+	if want, got := codes.NotFound, status.Code(err); want != got {
+		t.Errorf("GetNode after decommissioning: wanted %s, got %v", want, err)
+	}
+	_, err = mgmt.DecommissionNode(ctx, &apb.DecommissionNodeRequest{
+		Node: &apb.DecommissionNodeRequest_Id{
+			Id: worker.ID(),
+		},
+	})
+	if want, got := codes.NotFound, status.Code(err); want != got {
+		t.Errorf("Decommissioning twice: wanted %s, got %v", want, err)
+	}
+}
+
 // TestGetCurrentLeader ensures that a leader responds with its own information
 // when asked for information about the current leader.
 func TestGetCurrentLeader(t *testing.T) {
@@ -1880,3 +2714,397 @@ func TestNodeLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeAnnotations(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cl := fakeLeader(t)
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	update := func(upsert map[string]string, del ...string) error {
+		_, err := mgmt.UpdateNodeAnnotations(ctx, &apb.UpdateNodeAnnotationsRequest{
+			Node: &apb.UpdateNodeAnnotationsRequest_Id{
+				Id: cl.localNodeID,
+			},
+			Upsert: upsert,
+			Delete: del,
+		})
+		return err
+	}
+	checkAnnotations := func(want map[string]string) {
+		t.Helper()
+		nodes := getNodes(t, ctx, mgmt, "")
+		if len(nodes) != 1 {
+			t.Fatalf("Expected 1 node, got %d", len(nodes))
+		}
+		if len(want) == 0 && len(nodes[0].Annotations) == 0 {
+			return
+		}
+		if diff := cmp.Diff(want, nodes[0].Annotations); diff != "" {
+			t.Fatalf("Annotations differ (-want +got):\n%s", diff)
+		}
+	}
+
+	// A fresh node has no annotations.
+	checkAnnotations(nil)
+
+	// Expect annotation mutation to work.
+	if err := update(map[string]string{"rack": "a1", "notes": "Replaced PSU.\nAwaiting burn-in."}); err != nil {
+		t.Fatalf("UpdateNodeAnnotations: %v", err)
+	}
+	if err := update(map[string]string{"rack": "b2"}, "notes"); err != nil {
+		t.Fatalf("UpdateNodeAnnotations: %v", err)
+	}
+	checkAnnotations(map[string]string{"rack": "b2"})
+
+	// Annotations can be used in GetNodes filters.
+	if nodes := getNodes(t, ctx, mgmt, "node.annotations['rack'] == 'b2'"); len(nodes) != 1 {
+		t.Errorf("Expected 1 node in rack b2, got %d", len(nodes))
+	}
+	if nodes := getNodes(t, ctx, mgmt, "'rack' in node.annotations && node.annotations['rack'] == 'a1'"); len(nodes) != 0 {
+		t.Errorf("Expected no nodes in rack a1, got %d", len(nodes))
+	}
+
+	// Test some invalid mutations, make sure they error out and don't change the
+	// annotation state.
+	for i, te := range []struct {
+		upsert map[string]string
+		del    []string
+	}{
+		// Invalid key.
+		{upsert: map[string]string{"-rack": "a"}},
+		// Value too long.
+		{upsert: map[string]string{"notes": strings.Repeat("a", common.MaxAnnotationValueLength+1)}},
+		// Repeat delete key.
+		{del: []string{"rack", "rack"}},
+		// Key contained both in upsert and delete.
+		{upsert: map[string]string{"rack": "c3"}, del: []string{"rack"}},
+	} {
+		if err := update(te.upsert, te.del...); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Case %d: wanted InvalidArgument, got %v", i, err)
+		}
+		checkAnnotations(map[string]string{"rack": "b2"})
+	}
+}
+
+// TestConfigureCluster exercises the Management.ConfigureCluster RPC by
+// changing the leader election parameters.
+func TestConfigureCluster(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cl := fakeLeader(t)
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	configure := func(ttl uint32, paths ...string) (*apb.ConfigureClusterResponse, error) {
+		return mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+			NewConfig: &cpb.ClusterConfiguration{
+				LeaderElection: &cpb.ClusterConfiguration_LeaderElection{
+					TtlSeconds: ttl,
+				},
+			},
+			UpdateMask: &fieldmaskpb.FieldMask{
+				Paths: paths,
+			},
+		})
+	}
+
+	res, err := configure(30, "leader_election")
+	if err != nil {
+		t.Fatalf("ConfigureCluster: %v", err)
+	}
+	if got := res.ResultingConfig.GetLeaderElection().GetTtlSeconds(); got != 30 {
+		t.Errorf("Wanted resulting leader TTL of 30, got %d", got)
+	}
+
+	// Out of bounds values and unknown paths must be rejected.
+	for _, te := range []struct {
+		ttl   uint32
+		paths []string
+	}{
+		{1, []string{"leader_election"}},
+		{3600, []string{"leader_election"}},
+		{30, []string{"tpm_mode"}},
+		{30, nil},
+	} {
+		_, err := configure(te.ttl, te.paths...)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ConfigureCluster(%d, %v): wanted InvalidArgument, got %v", te.ttl, te.paths, err)
+		}
+	}
+
+	// The change must be persisted and visible in GetClusterInfo.
+	info, err := mgmt.GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetClusterInfo: %v", err)
+	}
+	if got := info.ClusterConfiguration.GetLeaderElection().GetTtlSeconds(); got != 30 {
+		t.Errorf("Wanted persisted leader TTL of 30, got %d", got)
+	}
+
+	// Resetting to zero restores the node-local default.
+	res, err = configure(0, "leader_election")
+	if err != nil {
+		t.Fatalf("ConfigureCluster: %v", err)
+	}
+	if res.ResultingConfig.LeaderElection != nil {
+		t.Errorf("Wanted no leader election configuration, got %v", res.ResultingConfig.LeaderElection)
+	}
+
+	// Configure webhooks. Their secrets must never be returned.
+	res, err = mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+		NewConfig: &cpb.ClusterConfiguration{
+			Webhooks: []*cpb.ClusterConfiguration_Webhook{
+				{Url: "https://example.com/hook", Secret: "hunter2"},
+			},
+		},
+		UpdateMask: &fieldmaskpb.FieldMask{
+			Paths: []string{"webhooks"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ConfigureCluster: %v", err)
+	}
+	info, err = mgmt.GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetClusterInfo: %v", err)
+	}
+	for _, whs := range [][]*cpb.ClusterConfiguration_Webhook{res.ResultingConfig.Webhooks, info.ClusterConfiguration.Webhooks} {
+		if len(whs) != 1 || whs[0].Url != "https://example.com/hook" {
+			t.Errorf("Wanted one webhook, got %v", whs)
+		} else if whs[0].Secret != "" {
+			t.Errorf("Webhook secret returned by API")
+		}
+	}
+	cl2, err := clusterLoad(ctx, cl.l)
+	if err != nil {
+		t.Fatalf("clusterLoad: %v", err)
+	}
+	if len(cl2.Webhooks) != 1 || cl2.Webhooks[0].Secret != "hunter2" {
+		t.Errorf("Wanted webhook secret to be persisted, got %+v", cl2.Webhooks)
+	}
+
+	// Webhooks with invalid URLs must be rejected.
+	_, err = mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+		NewConfig: &cpb.ClusterConfiguration{
+			Webhooks: []*cpb.ClusterConfiguration_Webhook{
+				{Url: "ftp://example.com/hook"},
+			},
+		},
+		UpdateMask: &fieldmaskpb.FieldMask{
+			Paths: []string{"webhooks"},
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ConfigureCluster with invalid webhook: wanted InvalidArgument, got %v", err)
+	}
+
+	// Configure the heartbeat timeout.
+	configureHealth := func(timeout uint32) (*apb.ConfigureClusterResponse, error) {
+		return mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+			NewConfig: &cpb.ClusterConfiguration{
+				NodeHealth: &cpb.ClusterConfiguration_NodeHealth{
+					HeartbeatTimeoutSeconds: timeout,
+				},
+			},
+			UpdateMask: &fieldmaskpb.FieldMask{
+				Paths: []string{"node_health"},
+			},
+		})
+	}
+	res, err = configureHealth(30)
+	if err != nil {
+		t.Fatalf("ConfigureCluster: %v", err)
+	}
+	if got := res.ResultingConfig.GetNodeHealth().GetHeartbeatTimeoutSeconds(); got != 30 {
+		t.Errorf("Wanted resulting heartbeat timeout of 30, got %d", got)
+	}
+	cl2, err = clusterLoad(ctx, cl.l)
+	if err != nil {
+		t.Fatalf("clusterLoad: %v", err)
+	}
+	if got, want := cl2.EffectiveHeartbeatTimeout(), 30*time.Second; got != want {
+		t.Errorf("Wanted persisted heartbeat timeout of %s, got %s", want, got)
+	}
+	for _, timeout := range []uint32{1, 3600} {
+		if _, err := configureHealth(timeout); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ConfigureCluster(heartbeat timeout %d): wanted InvalidArgument, got %v", timeout, err)
+		}
+	}
+
+	// Resetting to zero restores the default.
+	res, err = configureHealth(0)
+	if err != nil {
+		t.Fatalf("ConfigureCluster: %v", err)
+	}
+	if res.ResultingConfig.NodeHealth != nil {
+		t.Errorf("Wanted no node health configuration, got %v", res.ResultingConfig.NodeHealth)
+	}
+
+	// Configure the stale node grace period.
+	configureDirectory := func(period uint32) (*apb.ConfigureClusterResponse, error) {
+		return mgmt.ConfigureCluster(ctx, &apb.ConfigureClusterRequest{
+			NewConfig: &cpb.ClusterConfiguration{
+				NodeDirectory: &cpb.ClusterConfiguration_NodeDirectory{
+					StaleNodeGracePeriodSeconds: period,
+				},
+			},
+			UpdateMask: &fieldmaskpb.FieldMask{
+				Paths: []string{"node_directory"},
+			},
+		})
+	}
+	res, err = configureDirectory(86400)
+	if err != nil {
+		t.Fatalf("ConfigureCluster: %v", err)
+	}
+	if got := res.ResultingConfig.GetNodeDirectory().GetStaleNodeGracePeriodSeconds(); got != 86400 {
+		t.Errorf("Wanted resulting stale node grace period of 86400, got %d", got)
+	}
+	cl2, err = clusterLoad(ctx, cl.l)
+	if err != nil {
+		t.Fatalf("clusterLoad: %v", err)
+	}
+	if got, want := cl2.StaleNodeGracePeriod, 24*time.Hour; got != want {
+		t.Errorf("Wanted persisted stale node grace period of %s, got %s", want, got)
+	}
+	for _, period := range []uint32{60, 400 * 86400} {
+		if _, err := configureDirectory(period); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ConfigureCluster(stale node grace period %d): wanted InvalidArgument, got %v", period, err)
+		}
+	}
+	res, err = configureDirectory(0)
+	if err != nil {
+		t.Fatalf("ConfigureCluster: %v", err)
+	}
+	if res.ResultingConfig.NodeDirectory != nil {
+		t.Errorf("Wanted no node directory configuration, got %v", res.ResultingConfig.NodeDirectory)
+	}
+}
+
+// getAuditLog retrieves all entries of the audit log.
+func getAuditLog(t *testing.T, ctx context.Context, mgmt apb.ManagementClient) []*apb.AuditLogEntry {
+	t.Helper()
+	srv, err := mgmt.GetAuditLog(ctx, &apb.GetAuditLogRequest{})
+	if err != nil {
+		t.Fatalf("GetAuditLog: %v", err)
+	}
+	var entries []*apb.AuditLogEntry
+	for {
+		entry, err := srv.Recv()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("GetAuditLog.Recv: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// TestAuditLog exercises the audit log by approving a node and reading back
+// the resulting audit log entry.
+func TestAuditLog(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cl := fakeLeader(t)
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	node := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_NEW })
+	id := identity.NodeID(node.pubkey)
+	before := time.Now()
+	if _, err := mgmt.ApproveNode(ctx, &apb.ApproveNodeRequest{Pubkey: node.pubkey}); err != nil {
+		t.Fatalf("ApproveNode: %v", err)
+	}
+	// Approving again is a no-op and must not be recorded.
+	if _, err := mgmt.ApproveNode(ctx, &apb.ApproveNodeRequest{Pubkey: node.pubkey}); err != nil {
+		t.Fatalf("ApproveNode: %v", err)
+	}
+
+	entries := getAuditLog(t, ctx, mgmt)
+	if want, got := 1, len(entries); want != got {
+		t.Fatalf("Wanted %d audit log entries, got %d: %v", want, got, entries)
+	}
+	entry := entries[0]
+	if want, got := "owner", entry.Actor; want != got {
+		t.Errorf("Wanted actor %q, got %q", want, got)
+	}
+	if want, got := "ApproveNode", entry.Operation; want != got {
+		t.Errorf("Wanted operation %q, got %q", want, got)
+	}
+	if want, got := id, entry.NodeId; want != got {
+		t.Errorf("Wanted node ID %q, got %q", want, got)
+	}
+	if ts := entry.Time.AsTime(); ts.Before(before.Add(-time.Second)) || ts.After(time.Now()) {
+		t.Errorf("Audit log entry time %v out of range", ts)
+	}
+}
+
+// TestAuditLogRetention ensures that expired audit log entries are removed when
+// a new entry is recorded, and that audit logs spanning multiple pages are
+// returned in full and in order.
+func TestAuditLogRetention(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	cl := fakeLeader(t)
+	mgmt := apb.NewManagementClient(cl.mgmtConn)
+
+	putEntry := func(at time.Time, operation string) string {
+		t.Helper()
+		key, err := auditLogPrefix.Key(auditLogID(at, "00000000"))
+		if err != nil {
+			t.Fatalf("Key: %v", err)
+		}
+		entry, err := proto.Marshal(&ppb.AuditLogEntry{
+			Actor:     "owner",
+			Operation: operation,
+			Time:      timestamppb.New(at),
+		})
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if _, err := cl.etcd.Put(ctx, key, string(entry)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		return key
+	}
+
+	now := time.Now()
+	expired := putEntry(now.Add(-auditLogRetention-time.Hour), "Expired")
+	// Enough entries to span more than one page.
+	for i := 0; i < auditLogPageSize+1; i++ {
+		putEntry(now.Add(-auditLogRetention+time.Hour+time.Duration(i)*time.Second), "Retained")
+	}
+
+	node := putNode(t, ctx, cl.l, func(n *Node) { n.state = cpb.NodeState_NODE_STATE_NEW })
+	if _, err := mgmt.ApproveNode(ctx, &apb.ApproveNodeRequest{Pubkey: node.pubkey}); err != nil {
+		t.Fatalf("ApproveNode: %v", err)
+	}
+
+	res, err := cl.etcd.Get(ctx, expired)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(res.Kvs) != 0 {
+		t.Errorf("Expired audit log entry has not been removed")
+	}
+
+	entries := getAuditLog(t, ctx, mgmt)
+	if want, got := auditLogPageSize+2, len(entries); want != got {
+		t.Fatalf("Wanted %d audit log entries, got %d", want, got)
+	}
+	for i, entry := range entries[:auditLogPageSize+1] {
+		if want, got := "Retained", entry.Operation; want != got {
+			t.Fatalf("Entry %d: wanted operation %q, got %q", i, want, got)
+		}
+		if i > 0 && entry.Time.AsTime().Before(entries[i-1].Time.AsTime()) {
+			t.Fatalf("Entry %d is older than its predecessor", i)
+		}
+	}
+	if want, got := "ApproveNode", entries[auditLogPageSize+1].Operation; want != got {
+		t.Errorf("Wanted last operation %q, got %q", want, got)
+	}
+}
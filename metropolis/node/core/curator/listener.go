@@ -113,6 +113,10 @@ func (l *listener) run(ctx context.Context) error {
 		cpb.RegisterCuratorLocalServer(srv, leader)
 		apb.RegisterAAAServer(srv, leader)
 		apb.RegisterManagementServer(srv, leader)
+
+		if err := supervisor.Run(ctx, "webhooks", leader.runWebhooks); err != nil {
+			return fmt.Errorf("could not run webhooks: %w", err)
+		}
 	case st.follower != nil:
 		supervisor.Logger(ctx).Infof("This curator is a follower (leader is %q), starting minimal implementation.", st.follower.lock.NodeId)
 
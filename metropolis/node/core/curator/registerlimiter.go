@@ -0,0 +1,126 @@
+package curator
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// registerFreeAttempts is the number of invalid register tickets a given
+	// caller can present before it gets locked out.
+	registerFreeAttempts = 3
+	// registerInitialLockout is the duration of the first lockout of a caller.
+	// Every further invalid ticket doubles the lockout duration.
+	registerInitialLockout = time.Second
+	// registerMaxLockout is the longest lockout of a caller. Callers which did
+	// not present an invalid ticket for this long are forgotten.
+	registerMaxLockout = 5 * time.Minute
+	// registerMaxTracked is the maximum number of callers tracked by the
+	// limiter, bounding its memory usage.
+	registerMaxTracked = 10000
+)
+
+// registerLimiter throttles RegisterNode calls presenting invalid register
+// tickets, preventing callers from brute-forcing the register ticket. Callers
+// are identified by the public key of their ephemeral certificate.
+//
+// After registerFreeAttempts invalid tickets, a caller is locked out for an
+// exponentially growing duration after every further invalid ticket, during
+// which all its calls are rejected.
+type registerLimiter struct {
+	mu      sync.Mutex
+	callers map[string]*registerCaller
+
+	// initial and max are the initial and maximum lockout durations. They are
+	// configurable for tests.
+	initial, max time.Duration
+	// now returns the current time, configurable for tests.
+	now func() time.Time
+}
+
+// registerCaller is the state of a single caller within the registerLimiter.
+type registerCaller struct {
+	// failures is the number of invalid tickets presented by the caller.
+	failures int
+	// last is the time at which the last invalid ticket was presented.
+	last time.Time
+	// lockedUntil is the time until which the caller is locked out.
+	lockedUntil time.Time
+}
+
+func newRegisterLimiter() *registerLimiter {
+	return &registerLimiter{
+		callers: make(map[string]*registerCaller),
+		initial: registerInitialLockout,
+		max:     registerMaxLockout,
+		now:     time.Now,
+	}
+}
+
+// lockedOut returns the remaining duration of the lockout of the given caller,
+// or zero if it is not locked out.
+func (r *registerLimiter) lockedOut(pubkey []byte) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.callers[string(pubkey)]
+	if !ok {
+		return 0
+	}
+	if left := c.lockedUntil.Sub(r.now()); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// fail records an invalid ticket presented by the given caller, locking it out
+// if it has presented too many invalid tickets.
+func (r *registerLimiter) fail(pubkey []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	c, ok := r.callers[string(pubkey)]
+	if !ok {
+		if len(r.callers) >= registerMaxTracked {
+			r.pruneLocked(now)
+		}
+		c = &registerCaller{}
+		r.callers[string(pubkey)] = c
+	}
+	c.failures++
+	c.last = now
+	if c.failures <= registerFreeAttempts {
+		return
+	}
+	lockout := r.initial
+	for i := registerFreeAttempts + 1; i < c.failures && lockout < r.max; i++ {
+		lockout *= 2
+	}
+	if lockout > r.max {
+		lockout = r.max
+	}
+	c.lockedUntil = now.Add(lockout)
+}
+
+// succeed forgets about previous invalid tickets of the given caller.
+func (r *registerLimiter) succeed(pubkey []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.callers, string(pubkey))
+}
+
+// pruneLocked removes callers which haven't presented an invalid ticket for a
+// while and are not locked out. If that doesn't free up any space, all callers
+// are forgotten, as the limiter is then likely being flooded with
+// throwaway keys anyway. r.mu must be held.
+func (r *registerLimiter) pruneLocked(now time.Time) {
+	for k, c := range r.callers {
+		if now.Sub(c.last) > r.max && now.After(c.lockedUntil) {
+			delete(r.callers, k)
+		}
+	}
+	if len(r.callers) >= registerMaxTracked {
+		r.callers = make(map[string]*registerCaller)
+	}
+}
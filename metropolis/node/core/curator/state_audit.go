@@ -0,0 +1,144 @@
+package curator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+
+	ppb "source.monogon.dev/metropolis/node/core/curator/proto/private"
+	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/node/core/rpc"
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+// auditLogPrefix is the etcd prefix under which audit log entries are stored.
+// Entry IDs start with the zero-padded time of the mutation, so that a range
+// read over the prefix returns them in chronological order.
+var auditLogPrefix = mustNewEtcdPrefix("/audit/")
+
+// auditActor returns the identity of the caller in ctx, as recorded in audit
+// log entries.
+func auditActor(ctx context.Context) string {
+	pi := rpc.GetPeerInfo(ctx)
+	switch {
+	case pi == nil:
+		return "unknown"
+	case pi.User != nil:
+		return pi.User.Identity
+	case pi.Node != nil:
+		return identity.NodeID(pi.Node.PublicKey)
+	default:
+		return "unauthenticated"
+	}
+}
+
+// auditLogRetention is the age after which audit log entries are removed.
+const auditLogRetention = 90 * 24 * time.Hour
+
+// auditLogPageSize is the maximum number of audit log entries retrieved from
+// etcd at once by GetAuditLog.
+const auditLogPageSize = 500
+
+// auditLogID returns the ID of an audit log entry recorded at the given time.
+// The given suffix is used to disambiguate entries recorded at the same time,
+// and can be empty to get the lowest possible ID at this time.
+func auditLogID(t time.Time, suffix string) string {
+	return fmt.Sprintf("%020d-%s", t.UnixNano(), suffix)
+}
+
+// auditLogOps returns etcd operations which append an entry to the cluster's
+// audit log, recording that the caller in ctx performed operation on the node
+// with the given ID (or on no particular node, if nodeID is empty). They also
+// remove any entries older than auditLogRetention.
+//
+// The operations must be executed in the same transaction as the mutation
+// they record, so that the audit log contains an entry if and only if the
+// mutation has been committed.
+func auditLogOps(ctx context.Context, operation, nodeID string) ([]clientv3.Op, error) {
+	now := time.Now()
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not generate audit log entry ID: %v", err)
+	}
+	key, err := auditLogPrefix.Key(auditLogID(now, hex.EncodeToString(suffix[:])))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not build audit log entry key: %v", err)
+	}
+	entry, err := proto.Marshal(&ppb.AuditLogEntry{
+		Actor:     auditActor(ctx),
+		Operation: operation,
+		NodeId:    nodeID,
+		Time:      tpb.New(now),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not marshal audit log entry: %v", err)
+	}
+	start, _ := auditLogPrefix.KeyRange()
+	expired, err := auditLogPrefix.Key(auditLogID(now.Add(-auditLogRetention), ""))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not build audit log retention key: %v", err)
+	}
+	return []clientv3.Op{
+		clientv3.OpPut(key, string(entry)),
+		clientv3.OpDelete(start, clientv3.WithRange(expired)),
+	}, nil
+}
+
+// GetAuditLog implements Management.GetAuditLog, which returns all entries of
+// the cluster's audit log, oldest first.
+//
+// Entries are retrieved from etcd in pages of auditLogPageSize, all at the
+// revision of the first page, so that a consistent view of the audit log is
+// returned.
+func (l *leaderManagement) GetAuditLog(_ *apb.GetAuditLogRequest, srv apb.Management_GetAuditLogServer) error {
+	ctx := srv.Context()
+
+	start, end := auditLogPrefix.KeyRange()
+	var rev int64
+	for {
+		opts := []clientv3.OpOption{
+			clientv3.WithRange(end),
+			clientv3.WithLimit(auditLogPageSize),
+		}
+		if rev != 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+		res, err := l.txnAsLeader(ctx, clientv3.OpGet(start, opts...))
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "could not retrieve audit log: %v", err)
+		}
+		if rev == 0 {
+			rev = res.Header.Revision
+		}
+		rr := res.Responses[0].GetResponseRange()
+		for _, kv := range rr.Kvs {
+			var entry ppb.AuditLogEntry
+			if err := proto.Unmarshal(kv.Value, &entry); err != nil {
+				rpc.Trace(ctx).Printf("Unmarshalling audit log entry %q failed: %v", kv.Key, err)
+				continue
+			}
+			err := srv.Send(&apb.AuditLogEntry{
+				Actor:     entry.Actor,
+				Operation: entry.Operation,
+				NodeId:    entry.NodeId,
+				Time:      entry.Time,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if !rr.More || len(rr.Kvs) == 0 {
+			return nil
+		}
+		// Continue right after the last retrieved key.
+		start = string(rr.Kvs[len(rr.Kvs)-1].Key) + "\x00"
+	}
+}
@@ -3,6 +3,8 @@ package curator
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc/codes"
@@ -22,6 +24,130 @@ var (
 type Cluster struct {
 	TPMMode               cpb.ClusterConfiguration_TPMMode
 	StorageSecurityPolicy cpb.ClusterConfiguration_StorageSecurityPolicy
+	// LeaderTTL is the TTL of the curator leader election lease. If zero, the
+	// node-local default (Config.LeaderTTL) is used.
+	LeaderTTL time.Duration
+	// Webhooks are the endpoints notified about cluster lifecycle events. See
+	// webhooks.go for more information.
+	Webhooks []Webhook
+	// HeartbeatTimeout is the duration after which an UP node without
+	// heartbeats is considered to be timing out. If zero, the default
+	// (HeartbeatTimeout) is used.
+	HeartbeatTimeout time.Duration
+	// StaleNodeGracePeriod is the time after which nodes deleted from the
+	// cluster get removed from the node directories of the remaining nodes.
+	// If zero, deleted nodes are never removed.
+	StaleNodeGracePeriod time.Duration
+}
+
+// EffectiveHeartbeatTimeout returns the heartbeat timeout configured for the
+// cluster, or the default if none is configured.
+func (c *Cluster) EffectiveHeartbeatTimeout() time.Duration {
+	if c.HeartbeatTimeout == 0 {
+		return HeartbeatTimeout
+	}
+	return c.HeartbeatTimeout
+}
+
+// Webhook is an endpoint notified about cluster lifecycle events, as
+// (un)marshaled to/from common.ClusterConfiguration.Webhook.
+type Webhook struct {
+	// URL is the http(s) URL to which events get POSTed.
+	URL string
+	// Secret is the HMAC-SHA256 key used to sign events, or empty if events
+	// should not be signed.
+	Secret string
+}
+
+// maxWebhooks is the maximum number of webhooks configurable in a cluster.
+const maxWebhooks = 16
+
+// validateWebhooks checks that cluster-configured webhooks have well-formed
+// URLs and that there are not too many of them.
+func validateWebhooks(whs []Webhook) error {
+	if len(whs) > maxWebhooks {
+		return fmt.Errorf("at most %d webhooks can be configured, got %d", maxWebhooks, len(whs))
+	}
+	for i, wh := range whs {
+		u, err := url.Parse(wh.URL)
+		if err != nil {
+			return fmt.Errorf("webhook %d: invalid URL: %w", i, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("webhook %d: URL scheme must be http or https, got %q", i, u.Scheme)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("webhook %d: URL must contain a host", i)
+		}
+	}
+	return nil
+}
+
+const (
+	// minLeaderTTL and maxLeaderTTL are the bounds of a non-zero
+	// Cluster.LeaderTTL.
+	minLeaderTTL = 2 * time.Second
+	maxLeaderTTL = 120 * time.Second
+)
+
+// validateLeaderTTL checks that a cluster-configured leader TTL is either
+// unset or within sane bounds.
+func validateLeaderTTL(ttl time.Duration) error {
+	if ttl == 0 {
+		return nil
+	}
+	if ttl < minLeaderTTL || ttl > maxLeaderTTL {
+		return fmt.Errorf("leader TTL must be between %s and %s, got %s", minLeaderTTL, maxLeaderTTL, ttl)
+	}
+	if ttl%time.Second != 0 {
+		return fmt.Errorf("leader TTL must be a whole number of seconds, got %s", ttl)
+	}
+	return nil
+}
+
+const (
+	// minHeartbeatTimeout and maxHeartbeatTimeout are the bounds of a non-zero
+	// Cluster.HeartbeatTimeout. The lower bound leaves some leeway over the
+	// interval in which nodes send heartbeats.
+	minHeartbeatTimeout = HeartbeatInterval + time.Second
+	maxHeartbeatTimeout = 600 * time.Second
+)
+
+// validateHeartbeatTimeout checks that a cluster-configured heartbeat timeout
+// is either unset or within sane bounds.
+func validateHeartbeatTimeout(timeout time.Duration) error {
+	if timeout == 0 {
+		return nil
+	}
+	if timeout < minHeartbeatTimeout || timeout > maxHeartbeatTimeout {
+		return fmt.Errorf("heartbeat timeout must be between %s and %s, got %s", minHeartbeatTimeout, maxHeartbeatTimeout, timeout)
+	}
+	if timeout%time.Second != 0 {
+		return fmt.Errorf("heartbeat timeout must be a whole number of seconds, got %s", timeout)
+	}
+	return nil
+}
+
+const (
+	// minStaleNodeGracePeriod and maxStaleNodeGracePeriod are the bounds of a
+	// non-zero Cluster.StaleNodeGracePeriod.
+	minStaleNodeGracePeriod = time.Hour
+	maxStaleNodeGracePeriod = 365 * 24 * time.Hour
+)
+
+// validateStaleNodeGracePeriod checks that a cluster-configured stale node
+// grace period is either unset or within sane bounds.
+func validateStaleNodeGracePeriod(period time.Duration) error {
+	if period == 0 {
+		return nil
+	}
+	if period < minStaleNodeGracePeriod || period > maxStaleNodeGracePeriod {
+		return fmt.Errorf("stale node grace period must be between %s and %s, got %s", minStaleNodeGracePeriod, maxStaleNodeGracePeriod, period)
+	}
+	if period%time.Second != 0 {
+		return fmt.Errorf("stale node grace period must be a whole number of seconds, got %s", period)
+	}
+	return nil
 }
 
 // DefaultClusterConfiguration is the default cluster configuration for a newly
@@ -162,6 +288,33 @@ func clusterFromProto(cc *cpb.ClusterConfiguration) (*Cluster, error) {
 		TPMMode:               cc.TpmMode,
 		StorageSecurityPolicy: cc.StorageSecurityPolicy,
 	}
+	if le := cc.LeaderElection; le != nil {
+		c.LeaderTTL = time.Duration(le.TtlSeconds) * time.Second
+	}
+	if err := validateLeaderTTL(c.LeaderTTL); err != nil {
+		return nil, err
+	}
+	for _, wh := range cc.Webhooks {
+		c.Webhooks = append(c.Webhooks, Webhook{
+			URL:    wh.Url,
+			Secret: wh.Secret,
+		})
+	}
+	if err := validateWebhooks(c.Webhooks); err != nil {
+		return nil, err
+	}
+	if nh := cc.NodeHealth; nh != nil {
+		c.HeartbeatTimeout = time.Duration(nh.HeartbeatTimeoutSeconds) * time.Second
+	}
+	if err := validateHeartbeatTimeout(c.HeartbeatTimeout); err != nil {
+		return nil, err
+	}
+	if nd := cc.NodeDirectory; nd != nil {
+		c.StaleNodeGracePeriod = time.Duration(nd.StaleNodeGracePeriodSeconds) * time.Second
+	}
+	if err := validateStaleNodeGracePeriod(c.StaleNodeGracePeriod); err != nil {
+		return nil, err
+	}
 
 	return c, nil
 }
@@ -184,10 +337,58 @@ func (c *Cluster) proto() (*cpb.ClusterConfiguration, error) {
 		return nil, fmt.Errorf("invalid StorageSecurityPolicy %d", c.StorageSecurityPolicy)
 	}
 
-	return &cpb.ClusterConfiguration{
+	if err := validateLeaderTTL(c.LeaderTTL); err != nil {
+		return nil, err
+	}
+	if err := validateWebhooks(c.Webhooks); err != nil {
+		return nil, err
+	}
+	if err := validateHeartbeatTimeout(c.HeartbeatTimeout); err != nil {
+		return nil, err
+	}
+	if err := validateStaleNodeGracePeriod(c.StaleNodeGracePeriod); err != nil {
+		return nil, err
+	}
+
+	res := &cpb.ClusterConfiguration{
 		TpmMode:               c.TPMMode,
 		StorageSecurityPolicy: c.StorageSecurityPolicy,
-	}, nil
+	}
+	if c.LeaderTTL != 0 {
+		res.LeaderElection = &cpb.ClusterConfiguration_LeaderElection{
+			TtlSeconds: uint32(c.LeaderTTL / time.Second),
+		}
+	}
+	for _, wh := range c.Webhooks {
+		res.Webhooks = append(res.Webhooks, &cpb.ClusterConfiguration_Webhook{
+			Url:    wh.URL,
+			Secret: wh.Secret,
+		})
+	}
+	if c.HeartbeatTimeout != 0 {
+		res.NodeHealth = &cpb.ClusterConfiguration_NodeHealth{
+			HeartbeatTimeoutSeconds: uint32(c.HeartbeatTimeout / time.Second),
+		}
+	}
+	if c.StaleNodeGracePeriod != 0 {
+		res.NodeDirectory = &cpb.ClusterConfiguration_NodeDirectory{
+			StaleNodeGracePeriodSeconds: uint32(c.StaleNodeGracePeriod / time.Second),
+		}
+	}
+	return res, nil
+}
+
+// publicProto returns the cluster configuration as a proto suitable for
+// returning to API clients, ie. with all webhook secrets removed.
+func (c *Cluster) publicProto() (*cpb.ClusterConfiguration, error) {
+	res, err := c.proto()
+	if err != nil {
+		return nil, err
+	}
+	for _, wh := range res.Webhooks {
+		wh.Secret = ""
+	}
+	return res, nil
 }
 
 func clusterLoad(ctx context.Context, l *leadership) (*Cluster, error) {
@@ -214,7 +415,10 @@ func clusterLoad(ctx context.Context, l *leadership) (*Cluster, error) {
 	return node, nil
 }
 
-func clusterSave(ctx context.Context, l *leadership, c *Cluster) error {
+// clusterSave saves the cluster configuration into etcd, within a given active
+// leadership. Any additional etcd operations given are executed in the same
+// transaction.
+func clusterSave(ctx context.Context, l *leadership, c *Cluster, ops ...clientv3.Op) error {
 	rpc.Trace(ctx).Printf("clusterSave...")
 	clusterProto, err := c.proto()
 	if err != nil {
@@ -228,7 +432,7 @@ func clusterSave(ctx context.Context, l *leadership, c *Cluster) error {
 	}
 
 	ocs := clientv3.OpPut(clusterConfigurationKey, string(clusterBytes))
-	_, err = l.txnAsLeader(ctx, ocs)
+	_, err = l.txnAsLeader(ctx, append([]clientv3.Op{ocs}, ops...)...)
 	if err != nil {
 		if rpcErr, ok := rpcError(err); ok {
 			return rpcErr
@@ -23,11 +23,13 @@ import (
 	"fmt"
 	"net/netip"
 	"sort"
+	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
 
 	common "source.monogon.dev/metropolis/node"
 	"source.monogon.dev/metropolis/node/core/consensus"
@@ -104,6 +106,18 @@ type Node struct {
 	networkPrefixes []netip.Prefix
 
 	labels map[string]string
+	// annotations are operator-defined, free-form metadata attached to the
+	// node.
+	annotations map[string]string
+
+	// registeredAt, approvedAt, committedAt and credentialsIssuedAt are the
+	// times at which the node progressed through the registration flow. Each is
+	// zero if the node hasn't (yet) reached that step, or if it did so before
+	// these times were recorded.
+	registeredAt        time.Time
+	approvedAt          time.Time
+	committedAt         time.Time
+	credentialsIssuedAt time.Time
 }
 
 type NewNodeData struct {
@@ -119,13 +133,20 @@ type NewNodeData struct {
 //
 // This can only be used by the cluster bootstrap logic.
 func NewNodeForBootstrap(n *NewNodeData) Node {
+	// The bootstrap node skips the registration flow, consider it to have gone
+	// through all of it at once.
+	now := time.Now()
 	return Node{
-		clusterUnlockKey: n.CUK,
-		pubkey:           n.Pubkey,
-		jkey:             n.JPub,
-		state:            cpb.NodeState_NODE_STATE_UP,
-		tpmUsage:         n.TPMUsage,
-		labels:           n.Labels,
+		clusterUnlockKey:    n.CUK,
+		pubkey:              n.Pubkey,
+		jkey:                n.JPub,
+		state:               cpb.NodeState_NODE_STATE_UP,
+		tpmUsage:            n.TPMUsage,
+		labels:              n.Labels,
+		registeredAt:        now,
+		approvedAt:          now,
+		committedAt:         now,
+		credentialsIssuedAt: now,
 	}
 }
 
@@ -137,6 +158,9 @@ type NodeRoleKubernetesController struct {
 // NodeRoleKubernetesWorker defines that the Node should be running the
 // Kubernetes data plane.
 type NodeRoleKubernetesWorker struct {
+	// MaxVolumes is the maximum number of persistent volumes which can be
+	// attached to pods on the node, or zero if not limited.
+	MaxVolumes uint32
 }
 
 // NodeRoleConsensusMember defines that the Node should be running a
@@ -189,8 +213,12 @@ func (n *Node) KubernetesWorker() *NodeRoleKubernetesWorker {
 	return &kw
 }
 
+// EnableKubernetesWorker makes the node a Kubernetes worker. The settings of
+// an existing worker role are kept.
 func (n *Node) EnableKubernetesWorker() {
-	n.kubernetesWorker = &NodeRoleKubernetesWorker{}
+	if n.kubernetesWorker == nil {
+		n.kubernetesWorker = &NodeRoleKubernetesWorker{}
+	}
 }
 
 func (n *Node) DisableKubernetesWorker() {
@@ -262,9 +290,16 @@ func (n *Node) proto() *ppb.Node {
 		Status:           n.status,
 		TpmUsage:         n.tpmUsage,
 		Labels:           &cpb.NodeLabels{},
+
+		RegisteredAt:        timestampProto(n.registeredAt),
+		ApprovedAt:          timestampProto(n.approvedAt),
+		CommittedAt:         timestampProto(n.committedAt),
+		CredentialsIssuedAt: timestampProto(n.credentialsIssuedAt),
 	}
 	if n.kubernetesWorker != nil {
-		msg.Roles.KubernetesWorker = &cpb.NodeRoles_KubernetesWorker{}
+		msg.Roles.KubernetesWorker = &cpb.NodeRoles_KubernetesWorker{
+			MaxVolumes: n.kubernetesWorker.MaxVolumes,
+		}
 	}
 	if n.kubernetesController != nil {
 		msg.Roles.KubernetesController = &cpb.NodeRoles_KubernetesController{}
@@ -305,9 +340,33 @@ func (n *Node) proto() *ppb.Node {
 	sort.Slice(msg.Labels.Pairs, func(i, j int) bool {
 		return msg.Labels.Pairs[i].Key < msg.Labels.Pairs[j].Key
 	})
+	if len(n.annotations) > 0 {
+		msg.Annotations = make(map[string]string)
+		for k, v := range n.annotations {
+			msg.Annotations[k] = v
+		}
+	}
 	return msg
 }
 
+// timestampProto converts a time into a protobuf timestamp, returning nil for
+// the zero time.
+func timestampProto(t time.Time) *tpb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return tpb.New(t)
+}
+
+// timestampFromProto converts a protobuf timestamp into a time, returning the
+// zero time if the timestamp is not set.
+func timestampFromProto(ts *tpb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
 func nodeUnmarshal(data []byte) (*Node, error) {
 	var msg ppb.Node
 	if err := proto.Unmarshal(data, &msg); err != nil {
@@ -321,9 +380,17 @@ func nodeUnmarshal(data []byte) (*Node, error) {
 		status:           msg.Status,
 		tpmUsage:         msg.TpmUsage,
 		labels:           make(map[string]string),
+		annotations:      make(map[string]string),
+
+		registeredAt:        timestampFromProto(msg.RegisteredAt),
+		approvedAt:          timestampFromProto(msg.ApprovedAt),
+		committedAt:         timestampFromProto(msg.CommittedAt),
+		credentialsIssuedAt: timestampFromProto(msg.CredentialsIssuedAt),
 	}
-	if msg.Roles.KubernetesWorker != nil {
-		n.kubernetesWorker = &NodeRoleKubernetesWorker{}
+	if kw := msg.Roles.KubernetesWorker; kw != nil {
+		n.kubernetesWorker = &NodeRoleKubernetesWorker{
+			MaxVolumes: kw.MaxVolumes,
+		}
 	}
 	if msg.Roles.KubernetesController != nil {
 		n.kubernetesController = &NodeRoleKubernetesController{}
@@ -386,6 +453,16 @@ func nodeUnmarshal(data []byte) (*Node, error) {
 			n.labels[pair.Key] = pair.Value
 		}
 	}
+	for k, v := range msg.Annotations {
+		// As with labels, skip invalid annotations.
+		if err := common.ValidateLabel(k); err != nil {
+			continue
+		}
+		if len(v) > common.MaxAnnotationValueLength {
+			continue
+		}
+		n.annotations[k] = v
+	}
 	return n, nil
 }
 
@@ -426,9 +503,36 @@ func nodeLoad(ctx context.Context, l *leadership, id string) (*Node, error) {
 	return node, nil
 }
 
+// consensusMembers returns all nodes which have the ConsensusMember role
+// assigned, within a given active leadership. All returned errors are gRPC
+// statuses that are safe to return to untrusted callers.
+func consensusMembers(ctx context.Context, l *leadership) ([]*Node, error) {
+	res, err := l.txnAsLeader(ctx, NodeEtcdPrefix.Range())
+	if err != nil {
+		if rpcErr, ok := rpcError(err); ok {
+			return nil, rpcErr
+		}
+		rpc.Trace(ctx).Printf("could not retrieve nodes: %v", err)
+		return nil, status.Errorf(codes.Unavailable, "could not retrieve nodes: %v", err)
+	}
+	var nodes []*Node
+	for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+		node, err := nodeUnmarshal(kv.Value)
+		if err != nil {
+			rpc.Trace(ctx).Printf("could not unmarshal node %q: %v", kv.Key, err)
+			continue
+		}
+		if node.consensusMember != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
 // nodeSave attempts to save a node into etcd, within a given active leadership.
 // All returned errors are gRPC statuses that safe to return to untrusted callers.
-func nodeSave(ctx context.Context, l *leadership, n *Node) error {
+// Any additional etcd operations given are executed in the same transaction.
+func nodeSave(ctx context.Context, l *leadership, n *Node, ops ...clientv3.Op) error {
 	// Build an etcd operation to save the node with a key based on its ID.
 	id := n.ID()
 	rpc.Trace(ctx).Printf("nodeSave(%s)...", id)
@@ -458,7 +562,7 @@ func nodeSave(ctx context.Context, l *leadership, n *Node) error {
 	oks := clientv3.OpPut(jkey, id)
 
 	// Execute both operations atomically.
-	_, err = l.txnAsLeader(ctx, ons, oks)
+	_, err = l.txnAsLeader(ctx, append([]clientv3.Op{ons, oks}, ops...)...)
 	if err != nil {
 		if rpcErr, ok := rpcError(err); ok {
 			return rpcErr
@@ -471,8 +575,9 @@ func nodeSave(ctx context.Context, l *leadership, n *Node) error {
 }
 
 // nodeDestroy removes all traces of a node from etcd. It does not first check
-// whether the node is safe to be removed.
-func nodeDestroy(ctx context.Context, l *leadership, n *Node) error {
+// whether the node is safe to be removed. Any additional etcd operations given
+// are executed in the same transaction.
+func nodeDestroy(ctx context.Context, l *leadership, n *Node, ops ...clientv3.Op) error {
 	// Build an etcd operation to save the node with a key based on its ID.
 	id := n.ID()
 	rpc.Trace(ctx).Printf("nodeDestroy(%s)...", id)
@@ -490,10 +595,10 @@ func nodeDestroy(ctx context.Context, l *leadership, n *Node) error {
 		return status.Errorf(codes.InvalidArgument, "invalid join key representation")
 	}
 	// Delete both.
-	_, err = l.txnAsLeader(ctx,
+	_, err = l.txnAsLeader(ctx, append([]clientv3.Op{
 		clientv3.OpDelete(nkey),
 		clientv3.OpDelete(jkey),
-	)
+	}, ops...)...)
 	if err != nil {
 		if rpcErr, ok := rpcError(err); ok {
 			return rpcErr
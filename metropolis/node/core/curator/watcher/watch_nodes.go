@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"slices"
 	"sort"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
 )
@@ -267,6 +270,19 @@ func (f SimpleFollower) BatchDone() error {
 	return f.OnBatchDone()
 }
 
+// WatchNodesOption configures a WatchNodes call.
+type WatchNodesOption func(req *ipb.WatchRequest_NodesInCluster)
+
+// WithCoalesceWindow requests the Curator to batch all node changes occurring
+// within the given window into a single update, after the initial backlog has
+// been received. This reduces the amount of BatchDone calls on the Follower
+// under heavy churn, at the cost of delaying updates by up to the window.
+func WithCoalesceWindow(window time.Duration) WatchNodesOption {
+	return func(req *ipb.WatchRequest_NodesInCluster) {
+		req.CoalesceWindow = durationpb.New(window)
+	}
+}
+
 // WatchNodes runs a WatchRequest for NodesInCluster with the given Curator
 // channel. Any updates to the state of the nodes is processed through the given
 // Follower.
@@ -279,10 +295,14 @@ func (f SimpleFollower) BatchDone() error {
 // This function will exit with a context error whenever the given context is
 // canceled, or return with whatever error is returned by the Follower
 // implementation.
-func WatchNodes(ctx context.Context, cur ipb.CuratorClient, f Follower) error {
+func WatchNodes(ctx context.Context, cur ipb.CuratorClient, f Follower, opts ...WatchNodesOption) error {
+	nic := &ipb.WatchRequest_NodesInCluster{}
+	for _, opt := range opts {
+		opt(nic)
+	}
 	wa, err := cur.Watch(ctx, &ipb.WatchRequest{
 		Kind: &ipb.WatchRequest_NodesInCluster_{
-			NodesInCluster: &ipb.WatchRequest_NodesInCluster{},
+			NodesInCluster: nic,
 		},
 	})
 	if err != nil {
@@ -0,0 +1,334 @@
+package curator
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"source.monogon.dev/metropolis/node/core/identity"
+	apb "source.monogon.dev/metropolis/proto/api"
+	"source.monogon.dev/osbase/supervisor"
+)
+
+// Webhooks notify external systems (eg. alerting) about cluster lifecycle
+// events. They are configured in the cluster configuration and delivered by
+// the current curator leader only. Events are kept in a bounded in-memory
+// queue and are lost on leadership changes, so delivery is best-effort.
+// Every webhook is served by its own worker with its own bounded queue, so
+// that a slow or unreachable webhook does not delay delivery to the others.
+// Events which do not fit into a queue are dropped and logged.
+//
+// Each event is POSTed as a JSON-encoded webhookEvent to every configured
+// webhook. If a webhook has a secret configured, the request carries an
+// X-Metropolis-Signature header containing sha256=<hex HMAC-SHA256 of the body>.
+// Failed deliveries are retried with exponential backoff for up to
+// webhookRetryTimeout.
+
+// webhookEventType is the type of a cluster lifecycle event, as sent to
+// webhooks.
+type webhookEventType string
+
+const (
+	webhookNodeRegistered webhookEventType = "node_registered"
+	webhookNodeApproved   webhookEventType = "node_approved"
+	webhookNodeDeleted    webhookEventType = "node_deleted"
+	// webhookNodeUnhealthy is emitted when an UP node stops sending heartbeats
+	// to the curator leader.
+	webhookNodeUnhealthy webhookEventType = "node_unhealthy"
+	// webhookLeaderChanged is emitted by a curator when it becomes the leader.
+	webhookLeaderChanged webhookEventType = "leader_changed"
+)
+
+// webhookEvent is the JSON payload sent to webhooks.
+type webhookEvent struct {
+	Type webhookEventType `json:"type"`
+	Time time.Time        `json:"time"`
+	// NodeID is the ID of the node affected by the event. For leader changes,
+	// this is the ID of the new leader.
+	NodeID string `json:"node_id"`
+}
+
+const (
+	// webhookQueueSize is the maximum number of events pending dispatch, and
+	// the maximum number of events pending delivery to a single webhook.
+	// Further events are dropped.
+	webhookQueueSize = 128
+	// webhookRequestTimeout is the timeout of a single webhook request.
+	webhookRequestTimeout = 10 * time.Second
+	// webhookRetryTimeout is the time after which delivery of an event to a
+	// webhook is given up.
+	webhookRetryTimeout = time.Minute
+)
+
+// webhookQueue is the queue of events pending delivery by the current leader.
+type webhookQueue struct {
+	events chan *webhookEvent
+	// dropped is the number of events dropped because the queue was full,
+	// and not yet reported by the delivery runnable.
+	dropped atomic.Uint64
+}
+
+func newWebhookQueue() *webhookQueue {
+	return &webhookQueue{
+		events: make(chan *webhookEvent, webhookQueueSize),
+	}
+}
+
+// emit enqueues an event of the given type for the given node. It never
+// blocks, events are dropped and counted if the queue is full.
+func (q *webhookQueue) emit(typ webhookEventType, nodeID string) {
+	if q == nil {
+		return
+	}
+	select {
+	case q.events <- &webhookEvent{Type: typ, Time: time.Now(), NodeID: nodeID}:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+// webhookSignature returns the value of the X-Metropolis-Signature header for
+// the given body signed with the given secret.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook POSTs the given event to a webhook, retrying according to bo
+// until it succeeds, bo gives up, or the context is canceled.
+func deliverWebhook(ctx context.Context, client *http.Client, wh Webhook, ev *webhookEvent, bo backoff.BackOff) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+	send := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Metropolis-Event", string(ev.Type))
+		if wh.Secret != "" {
+			req.Header.Set("X-Metropolis-Signature", webhookSignature(wh.Secret, body))
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		switch {
+		case res.StatusCode >= 200 && res.StatusCode < 300:
+			return nil
+		case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+			return fmt.Errorf("server returned %s", res.Status)
+		default:
+			// Other client errors will not go away by retrying.
+			return backoff.Permanent(fmt.Errorf("server returned %s", res.Status))
+		}
+	}
+	return backoff.Retry(send, backoff.WithContext(bo, ctx))
+}
+
+// runWebhooks is the leader runnable responsible for emitting leadership and
+// node health events, and delivering all emitted events to the webhooks
+// configured in the cluster configuration.
+func (l *leaderManagement) runWebhooks(ctx context.Context) error {
+	l.ls.webhooks.emit(webhookLeaderChanged, l.leaderID)
+
+	if err := supervisor.Run(ctx, "health", l.runWebhookHealth); err != nil {
+		return err
+	}
+	if err := supervisor.Run(ctx, "delivery", l.runWebhookDelivery); err != nil {
+		return err
+	}
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// runWebhookHealth periodically checks the health of all nodes and emits an
+// event whenever a node starts timing out.
+func (l *leaderManagement) runWebhookHealth(ctx context.Context) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	// healthy contains the IDs of all nodes which were seen healthy during
+	// the last check.
+	healthy := make(map[string]bool)
+	t := time.NewTicker(HeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		res, err := l.txnAsLeader(ctx, NodeEtcdPrefix.Range())
+		if err != nil {
+			return fmt.Errorf("could not retrieve list of nodes: %w", err)
+		}
+		now := time.Now()
+		timeout := l.heartbeatTimeout(ctx)
+		nowHealthy := make(map[string]bool)
+		for _, kv := range res.Responses[0].GetResponseRange().Kvs {
+			node, err := nodeUnmarshal(kv.Value)
+			if err != nil {
+				continue
+			}
+			id := identity.NodeID(node.pubkey)
+			switch health, _ := l.nodeHealth(node, now, timeout); health {
+			case apb.Node_HEALTHY:
+				nowHealthy[id] = true
+			case apb.Node_HEARTBEAT_TIMEOUT:
+				if healthy[id] {
+					supervisor.Logger(ctx).Warningf("Node %s timed out on heartbeats", id)
+					l.ls.webhooks.emit(webhookNodeUnhealthy, id)
+				}
+			}
+		}
+		healthy = nowHealthy
+	}
+}
+
+// webhookDelivery is a pending delivery of an event to a webhook.
+type webhookDelivery struct {
+	wh Webhook
+	ev *webhookEvent
+}
+
+// webhookWorker delivers events to a single webhook.
+type webhookWorker struct {
+	queue chan *webhookDelivery
+	stop  context.CancelFunc
+}
+
+// webhookDispatcher distributes events to per-webhook workers, which are
+// started and stopped as webhooks get added to and removed from the cluster
+// configuration.
+type webhookDispatcher struct {
+	client *http.Client
+	// newBackOff returns the backoff used for the delivery of a single event.
+	newBackOff func() backoff.BackOff
+	// logf is called for failed deliveries.
+	logf func(format string, args ...any)
+
+	// workers are the running workers keyed by webhook URL.
+	workers map[string]*webhookWorker
+	wg      sync.WaitGroup
+}
+
+// dispatch enqueues the given event for delivery to all given webhooks, and
+// stops the workers of webhooks which are not present anymore. The URLs of the
+// webhooks whose queues were full, and for which the event has thus been
+// dropped, are returned.
+func (d *webhookDispatcher) dispatch(ctx context.Context, whs []Webhook, ev *webhookEvent) (dropped []string) {
+	if d.workers == nil {
+		d.workers = make(map[string]*webhookWorker)
+	}
+	present := make(map[string]bool)
+	for _, wh := range whs {
+		present[wh.URL] = true
+		w, ok := d.workers[wh.URL]
+		if !ok {
+			wctx, wctxC := context.WithCancel(ctx)
+			w = &webhookWorker{
+				queue: make(chan *webhookDelivery, webhookQueueSize),
+				stop:  wctxC,
+			}
+			d.workers[wh.URL] = w
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				d.work(wctx, w)
+			}()
+		}
+		select {
+		case w.queue <- &webhookDelivery{wh: wh, ev: ev}:
+		default:
+			dropped = append(dropped, wh.URL)
+		}
+	}
+	for url, w := range d.workers {
+		if !present[url] {
+			w.stop()
+			delete(d.workers, url)
+		}
+	}
+	return dropped
+}
+
+// work delivers the events enqueued for a single webhook until the context is
+// canceled.
+func (d *webhookDispatcher) work(ctx context.Context, w *webhookWorker) {
+	for {
+		var del *webhookDelivery
+		select {
+		case <-ctx.Done():
+			return
+		case del = <-w.queue:
+		}
+		if err := deliverWebhook(ctx, d.client, del.wh, del.ev, d.newBackOff()); err != nil && ctx.Err() == nil {
+			d.logf("Delivering %s event to %s failed: %v", del.ev.Type, del.wh.URL, err)
+		}
+	}
+}
+
+// stop stops all workers and waits for them to exit.
+func (d *webhookDispatcher) stop() {
+	for url, w := range d.workers {
+		w.stop()
+		delete(d.workers, url)
+	}
+	d.wg.Wait()
+}
+
+// runWebhookDelivery delivers all emitted events to the configured webhooks.
+func (l *leaderManagement) runWebhookDelivery(ctx context.Context) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	logger := supervisor.Logger(ctx)
+	d := &webhookDispatcher{
+		client: &http.Client{
+			Timeout: webhookRequestTimeout,
+		},
+		newBackOff: func() backoff.BackOff {
+			bo := backoff.NewExponentialBackOff()
+			bo.MaxElapsedTime = webhookRetryTimeout
+			return bo
+		},
+		logf: logger.Warningf,
+	}
+	defer d.stop()
+	for {
+		var ev *webhookEvent
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev = <-l.ls.webhooks.events:
+		}
+		if n := l.ls.webhooks.dropped.Swap(0); n != 0 {
+			logger.Warningf("Dropped %d events as the webhook queue was full", n)
+		}
+
+		// Retrieve the webhooks for every event, so that configuration changes
+		// take effect immediately.
+		cl, err := clusterLoad(ctx, l.leadership)
+		if err != nil {
+			return fmt.Errorf("could not load cluster configuration: %w", err)
+		}
+		for _, url := range d.dispatch(ctx, cl.Webhooks, ev) {
+			logger.Warningf("Dropped %s event for %s as its queue was full", ev.Type, url)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package curator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// TestWebhookDelivery exercises webhook delivery against a local HTTP server,
+// including signing and retries.
+func TestWebhookDelivery(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	var mu sync.Mutex
+	var attempts int
+	var received []*webhookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		// Fail the first attempt to exercise retries.
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body failed: %v", err)
+			return
+		}
+		if got, want := r.Header.Get("X-Metropolis-Signature"), webhookSignature("secret", body); got != want {
+			t.Errorf("wanted signature %q, got %q", want, got)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var ev webhookEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Errorf("unmarshaling event failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if got, want := r.Header.Get("X-Metropolis-Event"), string(ev.Type); got != want {
+			t.Errorf("wanted event header %q, got %q", want, got)
+		}
+		received = append(received, &ev)
+	}))
+	defer srv.Close()
+
+	ev := &webhookEvent{
+		Type:   webhookNodeApproved,
+		Time:   time.Now(),
+		NodeID: "metropolis-1234",
+	}
+	bo := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 3)
+	wh := Webhook{URL: srv.URL, Secret: "secret"}
+	if err := deliverWebhook(ctx, srv.Client(), wh, ev, bo); err != nil {
+		t.Fatalf("deliverWebhook: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("wanted 2 delivery attempts, got %d", attempts)
+	}
+	if len(received) != 1 {
+		t.Fatalf("wanted 1 received event, got %d", len(received))
+	}
+	if got := received[0]; got.Type != ev.Type || got.NodeID != ev.NodeID {
+		t.Errorf("wanted event %+v, got %+v", ev, got)
+	}
+}
+
+// TestWebhookDeliveryPermanentFailure ensures that client errors returned by
+// webhooks are not retried.
+func TestWebhookDeliveryPermanentFailure(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	var mu sync.Mutex
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	ev := &webhookEvent{Type: webhookLeaderChanged, Time: time.Now()}
+	bo := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 3)
+	if err := deliverWebhook(ctx, srv.Client(), Webhook{URL: srv.URL}, ev, bo); err == nil {
+		t.Fatalf("deliverWebhook succeeded, wanted error")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 1 {
+		t.Errorf("wanted 1 delivery attempt, got %d", attempts)
+	}
+}
+
+// TestWebhookQueue ensures emitting events never blocks, and that dropped
+// events are counted.
+func TestWebhookQueue(t *testing.T) {
+	var nilQueue *webhookQueue
+	nilQueue.emit(webhookNodeRegistered, "foo")
+
+	q := &webhookQueue{events: make(chan *webhookEvent, 1)}
+	q.emit(webhookNodeRegistered, "foo")
+	q.emit(webhookNodeRegistered, "bar")
+	if ev := <-q.events; ev.NodeID != "foo" {
+		t.Errorf("wanted first event to be kept, got %+v", ev)
+	}
+	if got := q.dropped.Load(); got != 1 {
+		t.Errorf("wanted 1 dropped event, got %d", got)
+	}
+}
+
+// TestWebhookDispatcher ensures that a webhook which does not respond neither
+// delays delivery to other webhooks nor blocks dispatching, and that events
+// which do not fit into its queue are reported as dropped.
+func TestWebhookDispatcher(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	// The dead webhook accepts connections, but never responds until the test
+	// is done.
+	done := make(chan struct{})
+	blocked := make(chan struct{}, 1)
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case blocked <- struct{}{}:
+		default:
+		}
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer dead.Close()
+	defer close(done)
+
+	received := make(chan *webhookEvent)
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("decoding event failed: %v", err)
+			return
+		}
+		select {
+		case received <- &ev:
+		case <-r.Context().Done():
+		}
+	}))
+	defer alive.Close()
+
+	d := &webhookDispatcher{
+		client: &http.Client{},
+		newBackOff: func() backoff.BackOff {
+			return backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 3)
+		},
+		logf: t.Logf,
+	}
+	defer d.stop()
+
+	whs := []Webhook{{URL: dead.URL}, {URL: alive.URL}}
+	// The dead webhook's worker blocks on the first event, so the following
+	// webhookQueueSize events fill its queue, and the last one is dropped.
+	var dropped []string
+	for i := 0; i < webhookQueueSize+2; i++ {
+		dropped = append(dropped, d.dispatch(ctx, whs, &webhookEvent{Type: webhookNodeApproved, NodeID: fmt.Sprintf("%d", i)})...)
+		// Receive the event from the alive webhook before dispatching the next
+		// one, so that its queue never fills up.
+		select {
+		case ev := <-received:
+			if want := fmt.Sprintf("%d", i); ev.NodeID != want {
+				t.Fatalf("wanted event for %s, got %+v", want, ev)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("event %d not delivered to alive webhook", i)
+		}
+		if i == 0 {
+			<-blocked
+		}
+	}
+	if len(dropped) != 1 || dropped[0] != dead.URL {
+		t.Errorf("wanted one dropped event for %s, got %v", dead.URL, dropped)
+	}
+
+	// Removing a webhook from the configuration stops its worker.
+	d.dispatch(ctx, whs[1:], &webhookEvent{Type: webhookNodeApproved, NodeID: "last"})
+	<-received
+	if _, ok := d.workers[dead.URL]; ok || len(d.workers) != 1 {
+		t.Errorf("wanted only worker for %s to be running, got %v", alive.URL, d.workers)
+	}
+}
@@ -8,6 +8,10 @@ import (
 	"source.monogon.dev/osbase/logtree"
 )
 
+// allowUnsignedBundles makes the update service accept unsigned bundles if
+// no bundle key is available. Production builds never do so.
+const allowUnsignedBundles = false
+
 // runDebugService runs the debug service if this is a debug build. Otherwise
 // it does nothing.
 func runDebugService(_ context.Context, _ *roleserve.Service, _ *logtree.LogTree, _ *localstorage.Root) error {
@@ -45,6 +45,10 @@ const (
 	logFilterMax = 1000
 )
 
+// allowUnsignedBundles makes the update service accept unsigned bundles if
+// no bundle key is available, which is the case for development builds.
+const allowUnsignedBundles = true
+
 // runDebugService runs the debug service if this is a debug build. Otherwise
 // it does nothing.
 func runDebugService(ctx context.Context, rs *roleserve.Service, lt *logtree.LogTree, root *localstorage.Root) error {
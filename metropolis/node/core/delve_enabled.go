@@ -36,9 +36,14 @@ func initializeDebugger(networkSvc *network.Service) {
 		// network interface is available to do that through. Also external
 		// access isn't possible early on anyways.
 		watcher := networkSvc.Status.Watch()
-		_, err := watcher.Get(context.Background())
-		if err != nil {
-			panic(err)
+		for {
+			st, err := watcher.Get(context.Background())
+			if err != nil {
+				panic(err)
+			}
+			if st.ExternalAddress != nil {
+				break
+			}
 		}
 		dlvCmd := exec.Command("/dlv", "--headless=true", fmt.Sprintf("--listen=:%v", node.DebuggerPort),
 			"--accept-multiclient", "--only-same-user=false", "attach", "--continue", "1", "/init")
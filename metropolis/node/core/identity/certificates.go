@@ -21,6 +21,34 @@ func UserCertificate(identity string) x509.Certificate {
 	}
 }
 
+// ReadOnlyUserCertificate makes a Metropolis-compatible user certificate
+// template for a user which is only permitted to perform read-only operations
+// on the cluster, eg. for use by monitoring systems. See UserIsReadOnly.
+func ReadOnlyUserCertificate(identity string) x509.Certificate {
+	c := UserCertificate(identity)
+	c.Subject.OrganizationalUnit = []string{userScopeReadOnly}
+	return c
+}
+
+// userScopeReadOnly is the Subject OrganizationalUnit marking a user
+// certificate as read-only.
+const userScopeReadOnly = "read-only"
+
+// UserIsReadOnly returns whether the given user certificate has been emitted
+// from a ReadOnlyUserCertificate template, ie. whether the user must only be
+// permitted to perform read-only operations.
+//
+// This does not verify the certificate in any way, use VerifyUserInCluster
+// for that.
+func UserIsReadOnly(user *x509.Certificate) bool {
+	for _, ou := range user.Subject.OrganizationalUnit {
+		if ou == userScopeReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
 // NodeCertificate makes a Metropolis-compatible node certificate template.
 func NodeCertificate(pubkey ed25519.PublicKey) x509.Certificate {
 	return x509.Certificate{
@@ -39,6 +39,7 @@ import (
 	"fmt"
 
 	"source.monogon.dev/osbase/blockdev"
+	"source.monogon.dev/osbase/devicemapper"
 )
 
 // Mode of block device encryption and/or authentication, if any. See the
@@ -69,6 +70,23 @@ const ModeAuthenticated Mode = "authenticated"
 // A key must not be provided, or must be exactly zero bytes long.
 const ModeInsecure Mode = "insecure"
 
+// Cipher is the AEAD cipher used for authenticated encryption, ie. in
+// ModeEncryptedAuthenticated. The empty Cipher means CipherAESGCM. A device
+// must always be mapped with the cipher it was initialized with.
+type Cipher string
+
+const (
+	// CipherAESGCM is AES-256 in GCM mode.
+	CipherAESGCM Cipher = "aes-gcm"
+	// CipherChaCha20Poly1305 is ChaCha20-Poly1305 as per RFC 7539. It is faster
+	// than AES-GCM on hardware without AES acceleration.
+	CipherChaCha20Poly1305 Cipher = "chacha20-poly1305"
+)
+
+// DefaultJournalSectors is the default size of the dm-integrity journal in
+// 512-byte sectors, used when zero journal sectors are given to Map or Init.
+const DefaultJournalSectors = 1024
+
 func (m Mode) encrypted() bool {
 	switch m {
 	case ModeEncryptedAuthenticated, ModeEncrypted:
@@ -104,19 +122,31 @@ func (m Mode) authenticated() bool {
 // authentication and/or encryption is enabled, and nil / 0 bytes long when
 // insecure mode is used.
 //
+// cipher is the AEAD cipher used in ModeEncryptedAuthenticated, and must be
+// the same as was passed to Init.
+//
+// journalSectors is the size of the dm-integrity journal in 512-byte sectors,
+// or zero to use DefaultJournalSectors. It is only used in authenticated modes.
+// As the journal size is recorded on the device when it is initialized, this
+// should be the same value as was passed to Init.
+//
 // Note: a successful Map does not necessarily mean the underlying device is
 // ready to access. Integrity errors or data corruption might mean accesses to
 // the newly mapped device will fail. The caller is responsible for catching
 // these conditions.
-func Map(name string, underlying string, encryptionKey []byte, mode Mode) (string, error) {
-	return map_(name, underlying, encryptionKey, mode, true)
+func Map(name string, underlying string, encryptionKey []byte, mode Mode, cipher Cipher, journalSectors uint64) (string, error) {
+	spec, err := cipher.spec()
+	if err != nil {
+		return "", err
+	}
+	return map_(name, underlying, encryptionKey, mode, spec, journalSectors, true)
 }
 
 // map_ is the internal implementation of Map, which also allows
 // enabling/disabling the integrity journal.
 //
 // This would be called map, but map is a reserved keyword in Go.
-func map_(name string, underlying string, encryptionKey []byte, mode Mode, enableJournal bool) (string, error) {
+func map_(name string, underlying string, encryptionKey []byte, mode Mode, spec *cipherSpec, journalSectors uint64, enableJournal bool) (string, error) {
 	// Verify key length.
 	switch mode {
 	case ModeInsecure:
@@ -132,7 +162,7 @@ func map_(name string, underlying string, encryptionKey []byte, mode Mode, enabl
 	device := underlying
 	if mode.authenticated() {
 		var err error
-		device, err = mapIntegrity(name, device, enableJournal)
+		device, err = mapIntegrity(name, device, spec.tagSize(), journalSectors, enableJournal)
 		if err != nil {
 			return "", err
 		}
@@ -140,7 +170,7 @@ func map_(name string, underlying string, encryptionKey []byte, mode Mode, enabl
 
 	if mode.encrypted() {
 		var err error
-		device, err = mapEncryption(name, device, encryptionKey, mode.authenticated())
+		device, err = mapEncryption(name, device, encryptionKey, mode.authenticated(), spec)
 		if err != nil {
 			unmapIntegrity(name)
 			return "", err
@@ -167,6 +197,68 @@ func Unmap(name string, mode Mode) error {
 	return nil
 }
 
+// Resize grows a block device mapped by Map or Init after its underlying block
+// device has been enlarged, without affecting the data stored on it. The
+// size of the top-level block device in bytes is returned.
+//
+// The given name, underlying path, encryption key, mode, cipher and journal
+// sectors must match the ones used when mapping the device. The top-level block device
+// is suspended while the device-mapper targets are reloaded, and any
+// filesystem on it must be grown separately afterwards.
+func Resize(name, underlying string, encryptionKey []byte, mode Mode, cipher Cipher, journalSectors uint64) (uint64, error) {
+	spec, err := cipher.spec()
+	if err != nil {
+		return 0, err
+	}
+	if mode == ModeInsecure {
+		blkdev, err := blockdev.Open(underlying)
+		if err != nil {
+			return 0, fmt.Errorf("opening underlying block device failed: %w", err)
+		}
+		defer blkdev.Close()
+		return uint64(blkdev.BlockCount() * blkdev.BlockSize()), nil
+	}
+
+	// Suspend the top-level device first to quiesce all I/O while the stack is
+	// being reloaded.
+	if mode.encrypted() {
+		if err := devicemapper.Suspend(encryptionDMName(name)); err != nil {
+			return 0, fmt.Errorf("failed to suspend crypt device: %w", err)
+		}
+		// Best-effort resume on failure, the new table is only active if it has
+		// been loaded successfully.
+		defer devicemapper.Resume(encryptionDMName(name))
+	}
+
+	device := underlying
+	var sectors uint64
+	if mode.authenticated() {
+		var err error
+		sectors, err = resizeIntegrity(name, underlying, spec.tagSize(), journalSectors)
+		if err != nil {
+			return 0, err
+		}
+		device = integrityDevPath(name)
+	} else {
+		blkdev, err := blockdev.Open(underlying)
+		if err != nil {
+			return 0, fmt.Errorf("opening underlying block device failed: %w", err)
+		}
+		sectors = uint64(blkdev.BlockCount())
+		blkdev.Close()
+	}
+
+	if mode.encrypted() {
+		if err := resizeEncryption(name, device, sectors, encryptionKey, mode.authenticated(), spec); err != nil {
+			return 0, err
+		}
+		if err := devicemapper.Resume(encryptionDMName(name)); err != nil {
+			return 0, fmt.Errorf("failed to resume resized crypt device: %w", err)
+		}
+	}
+	return sectors * 512, nil
+}
+
 // Init sets up encryption/authentication as defined by mode on an underlying
 // block device path. After initialization, the setup/mapping is preserved and
 // the path of the resulting top-level block device is returned.
@@ -182,19 +274,31 @@ func Unmap(name string, mode Mode) error {
 // The encryption key must be exactly 32 bytes / 256 bits long when
 // authentication and/or encryption is enabled, and nil / 0 bytes long when
 // insecure mode is used.
-func Init(name, underlying string, encryptionKey []byte, mode Mode) (string, error) {
+//
+// cipher is the AEAD cipher used in ModeEncryptedAuthenticated, or empty to use
+// CipherAESGCM. Unknown ciphers are rejected.
+//
+// journalSectors is the size of the dm-integrity journal in 512-byte sectors,
+// or zero to use DefaultJournalSectors. It is only used in authenticated modes,
+// and must be smaller than half the size of the underlying device.
+func Init(name, underlying string, encryptionKey []byte, mode Mode, cipher Cipher, journalSectors uint64) (string, error) {
+	spec, err := cipher.spec()
+	if err != nil {
+		return "", err
+	}
+
 	// If using an authenticated mode, we'll do an initial map with journaling
 	// enabled to speed up the initial zeroing, then remap it with journaling.
 	// Otherwise, we immediately map with journaling enabled and don't remap.
 	initWithJournal := true
 	if mode.authenticated() {
-		if err := initializeIntegrity(name, underlying); err != nil {
+		if err := initializeIntegrity(name, underlying, spec.tagSize(), journalSectors); err != nil {
 			return "", err
 		}
 		initWithJournal = false
 	}
 
-	device, err := map_(name, underlying, encryptionKey, mode, initWithJournal)
+	device, err := map_(name, underlying, encryptionKey, mode, spec, journalSectors, initWithJournal)
 	if err != nil {
 		return "", fmt.Errorf("initial mount failed: %w", err)
 	}
@@ -218,7 +322,7 @@ func Init(name, underlying string, encryptionKey []byte, mode Mode) (string, err
 			return "", fmt.Errorf("failed to unmap temporary encrypted block device: %w", err)
 		}
 
-		device, err = map_(name, underlying, encryptionKey, mode, true)
+		device, err = map_(name, underlying, encryptionKey, mode, spec, journalSectors, true)
 		if err != nil {
 			return "", fmt.Errorf("failed to map initialized encrypted device: %w", err)
 		}
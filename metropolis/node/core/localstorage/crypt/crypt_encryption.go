@@ -19,20 +19,66 @@ func encryptionDMName(name string) string {
 	return fmt.Sprintf("%s-crypt", name)
 }
 
-func mapEncryption(name, underlying string, encryptionKey []byte, authenticated bool) (string, error) {
-	blkdev, err := blockdev.Open(underlying)
-	if err != nil {
-		return "", fmt.Errorf("opening underlying block device failed: %w", err)
+// cipherSpec describes how a Cipher is configured in dm-crypt and dm-integrity.
+type cipherSpec struct {
+	// crypt is the cipher specification passed to dm-crypt.
+	crypt string
+	// ivSize is the size of the random IV stored per sector, in bytes.
+	ivSize int
+	// authSize is the size of the authentication tag stored per sector, in bytes.
+	authSize int
+}
+
+// tagSize is the size of the per-sector tag stored by dm-integrity, in bytes.
+// dm-crypt stores both the IV and the authentication tag in it, and must be
+// configured with the same size (via integrity:N:aead) as the underlying
+// dm-integrity device.
+func (c *cipherSpec) tagSize() int {
+	return c.ivSize + c.authSize
+}
+
+var cipherSpecs = map[Cipher]*cipherSpec{
+	CipherAESGCM: {
+		crypt:    "capi:gcm(aes)-random",
+		ivSize:   12,
+		authSize: 16,
+	},
+	CipherChaCha20Poly1305: {
+		crypt:    "capi:rfc7539(chacha20,poly1305)-random",
+		ivSize:   12,
+		authSize: 16,
+	},
+}
+
+// spec returns the cipherSpec of this cipher, with the empty cipher being
+// CipherAESGCM. An error is returned if the cipher is unknown.
+func (c Cipher) spec() (*cipherSpec, error) {
+	if c == "" {
+		c = CipherAESGCM
 	}
-	defer blkdev.Close()
+	spec, ok := cipherSpecs[c]
+	if !ok {
+		return nil, fmt.Errorf("unknown cipher %q", c)
+	}
+	// dm-integrity supports tags of at most 255 bytes. The IV needs to be stored
+	// alongside the authentication tag, as random IVs are used.
+	if spec.tagSize() <= spec.authSize || spec.tagSize() > 255 {
+		return nil, fmt.Errorf("cipher %q has invalid integrity tag size %d", c, spec.tagSize())
+	}
+	return spec, nil
+}
 
+// encryptionTarget returns the device-mapper target for a dm-crypt device of
+// the given length in 512-byte sectors on top of underlying. The given cipher
+// is only used if authenticated is set.
+func encryptionTarget(underlying string, length uint64, encryptionKey []byte, authenticated bool, spec *cipherSpec) devicemapper.Target {
 	optParams := []string{
 		"no_read_workqueue", "no_write_workqueue",
 	}
 	cipher := "capi:xts(aes)-essiv:sha256"
 	if authenticated {
-		optParams = append(optParams, "integrity:28:aead")
-		cipher = "capi:gcm(aes)-random"
+		optParams = append(optParams, fmt.Sprintf("integrity:%d:aead", spec.tagSize()))
+		cipher = spec.crypt
 	} else {
 		// discard (TRIM/UNMAP) only works without integrity enabled.
 		optParams = append(optParams, "allow_discards")
@@ -45,12 +91,22 @@ func mapEncryption(name, underlying string, encryptionKey []byte, authenticated
 	}
 	params = append(params, optParams...)
 
+	return devicemapper.Target{
+		Length:     length,
+		Type:       "crypt",
+		Parameters: params,
+	}
+}
+
+func mapEncryption(name, underlying string, encryptionKey []byte, authenticated bool, spec *cipherSpec) (string, error) {
+	blkdev, err := blockdev.Open(underlying)
+	if err != nil {
+		return "", fmt.Errorf("opening underlying block device failed: %w", err)
+	}
+	defer blkdev.Close()
+
 	cryptDev, err := devicemapper.CreateActiveDevice(encryptionDMName(name), false, []devicemapper.Target{
-		{
-			Length:     uint64(blkdev.BlockCount()),
-			Type:       "crypt",
-			Parameters: params,
-		},
+		encryptionTarget(underlying, uint64(blkdev.BlockCount()), encryptionKey, authenticated, spec),
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create crypt device: %w", err)
@@ -63,6 +119,18 @@ func mapEncryption(name, underlying string, encryptionKey []byte, authenticated
 	return encryptionDevPath(name), nil
 }
 
+// resizeEncryption loads a new table with the given length in 512-byte
+// sectors into a mapped dm-crypt device. The new table becomes active once the
+// device gets resumed.
+func resizeEncryption(name, underlying string, length uint64, encryptionKey []byte, authenticated bool, spec *cipherSpec) error {
+	if err := devicemapper.LoadTable(encryptionDMName(name), false, []devicemapper.Target{
+		encryptionTarget(underlying, length, encryptionKey, authenticated, spec),
+	}); err != nil {
+		return fmt.Errorf("failed to load resized crypt device: %w", err)
+	}
+	return nil
+}
+
 func unmapEncryption(name string) error {
 	// Remove /dev node if present.
 	if _, err := os.Stat(encryptionDevPath(name)); err == nil {
@@ -21,11 +21,12 @@ func integrityDMName(name string) string {
 
 // readIntegrityDataSectors parses the number of available integrity data sectors
 // from a raw dm-integrity formatted device. This is needed to then map the
-// device.
+// device. The tag size the device was formatted with is also verified to match
+// the given one.
 //
 // This is described in further detail in
 // https://docs.kernel.org/admin-guide/device-mapper/dm-integrity.html.
-func readIntegrityDataSectors(path string) (uint64, error) {
+func readIntegrityDataSectors(path string, tagSize int) (uint64, error) {
 	integrityPartition, err := blockdev.Open(path)
 	if err != nil {
 		return 0, err
@@ -38,11 +39,16 @@ func readIntegrityDataSectors(path string) (uint64, error) {
 	}
 	// Based on structure defined in
 	//   https://git.kernel.org/pub/scm/linux/kernel/git/torvalds/linux.git/tree/drivers/md/dm-integrity.c#n59
+	integrityTagSize := binary.LittleEndian.Uint16(firstBlock[10:12])
 	providedDataSectors := binary.LittleEndian.Uint64(firstBlock[16:24])
 
 	// Let's perform some simple checks on the read value to make sure the returned
 	// data isn't corrupted or has been tampered with.
 
+	if int(integrityTagSize) != tagSize {
+		return 0, fmt.Errorf("device has integrity tag size %d, but %d is required by the cipher", integrityTagSize, tagSize)
+	}
+
 	if providedDataSectors == 0 {
 		return 0, fmt.Errorf("invalid data sector count of zero")
 	}
@@ -53,9 +59,34 @@ func readIntegrityDataSectors(path string) (uint64, error) {
 	return providedDataSectors, nil
 }
 
+// checkJournalSectors validates the given dm-integrity journal size in 512-byte
+// sectors against the size of the device at path, returning the journal size
+// to use. Zero means DefaultJournalSectors.
+func checkJournalSectors(path string, journalSectors uint64) (uint64, error) {
+	if journalSectors == 0 {
+		journalSectors = DefaultJournalSectors
+	}
+	dev, err := blockdev.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer dev.Close()
+
+	deviceSectors := uint64(dev.BlockCount()*dev.BlockSize()) / 512
+	if journalSectors >= deviceSectors/2 {
+		return 0, fmt.Errorf("journal of %d sectors too large for device with %d sectors", journalSectors, deviceSectors)
+	}
+	return journalSectors, nil
+}
+
 // initializeIntegrity performs the initialization steps outlined in
 // https://docs.kernel.org/admin-guide/device-mapper/dm-integrity.html.
-func initializeIntegrity(name, baseName string) error {
+func initializeIntegrity(name, baseName string, tagSize int, journalSectors uint64) error {
+	journalSectors, err := checkJournalSectors(baseName, journalSectors)
+	if err != nil {
+		return err
+	}
+
 	// Zero out superblock.
 	integrityPartition, err := os.OpenFile(baseName, os.O_WRONLY, 0)
 	if err != nil {
@@ -73,7 +104,7 @@ func initializeIntegrity(name, baseName string) error {
 		{
 			Length:     1,
 			Type:       "integrity",
-			Parameters: []string{baseName, "0", "28", "J", "1", "journal_sectors:1024"},
+			Parameters: []string{baseName, "0", fmt.Sprintf("%d", tagSize), "J", "1", fmt.Sprintf("journal_sectors:%d", journalSectors)},
 		},
 	})
 	if err != nil {
@@ -87,22 +118,34 @@ func initializeIntegrity(name, baseName string) error {
 	return nil
 }
 
-func mapIntegrity(name, baseName string, enableJournal bool) (string, error) {
-	integritySectors, err := readIntegrityDataSectors(baseName)
-	if err != nil {
-		return "", fmt.Errorf("failed to read the number of usable sectors on the integrity device: %w", err)
-	}
-
+// integrityTarget returns the device-mapper target for a dm-integrity device
+// of the given length in 512-byte sectors on top of baseName. tagSize is the
+// size of the per-sector tag in bytes, which must match the dm-crypt cipher
+// used on top of it.
+func integrityTarget(baseName string, length uint64, tagSize int, journalSectors uint64, enableJournal bool) devicemapper.Target {
 	mode := "D"
 	if enableJournal {
 		mode = "J"
 	}
+	return devicemapper.Target{
+		Length:     length,
+		Type:       "integrity",
+		Parameters: []string{baseName, "0", fmt.Sprintf("%d", tagSize), mode, "1", fmt.Sprintf("journal_sectors:%d", journalSectors)},
+	}
+}
+
+func mapIntegrity(name, baseName string, tagSize int, journalSectors uint64, enableJournal bool) (string, error) {
+	journalSectors, err := checkJournalSectors(baseName, journalSectors)
+	if err != nil {
+		return "", err
+	}
+	integritySectors, err := readIntegrityDataSectors(baseName, tagSize)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the number of usable sectors on the integrity device: %w", err)
+	}
+
 	integrityDev, err := devicemapper.CreateActiveDevice(integrityDMName(name), false, []devicemapper.Target{
-		{
-			Length:     integritySectors,
-			Type:       "integrity",
-			Parameters: []string{baseName, "0", "28", mode, "1", "journal_sectors:1024"},
-		},
+		integrityTarget(baseName, integritySectors, tagSize, journalSectors, enableJournal),
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create Integrity device: %w", err)
@@ -115,6 +158,50 @@ func mapIntegrity(name, baseName string, enableJournal bool) (string, error) {
 	return integrityDevPath(name), nil
 }
 
+// resizeIntegrity grows a mapped dm-integrity device to cover its whole
+// underlying device, returning the new number of provided data sectors.
+func resizeIntegrity(name, baseName string, tagSize int, journalSectors uint64) (uint64, error) {
+	journalSectors, err := checkJournalSectors(baseName, journalSectors)
+	if err != nil {
+		return 0, err
+	}
+	st, err := GetStatus(name, ModeAuthenticated)
+	if err != nil {
+		return 0, err
+	}
+
+	// Reload the table with the current length first. The kernel recalculates
+	// the number of provided data sectors from the size of the underlying device
+	// when the target gets constructed, and persists it to the superblock on
+	// resume. We then retrieve it from the target status instead of reading the
+	// superblock from the underlying device, as the latter might be served from
+	// a stale page cache.
+	dmName := integrityDMName(name)
+	if err := devicemapper.LoadTable(dmName, false, []devicemapper.Target{
+		integrityTarget(baseName, st.IntegrityDataSectors, tagSize, journalSectors, true),
+	}); err != nil {
+		return 0, fmt.Errorf("failed to reload integrity device: %w", err)
+	}
+	if err := devicemapper.Resume(dmName); err != nil {
+		return 0, fmt.Errorf("failed to resume integrity device: %w", err)
+	}
+	st, err = GetStatus(name, ModeAuthenticated)
+	if err != nil {
+		return 0, err
+	}
+
+	// Then, grow the mapping to the new number of provided data sectors.
+	if err := devicemapper.LoadTable(dmName, false, []devicemapper.Target{
+		integrityTarget(baseName, st.IntegrityDataSectors, tagSize, journalSectors, true),
+	}); err != nil {
+		return 0, fmt.Errorf("failed to load resized integrity device: %w", err)
+	}
+	if err := devicemapper.Resume(dmName); err != nil {
+		return 0, fmt.Errorf("failed to resume resized integrity device: %w", err)
+	}
+	return st.IntegrityDataSectors, nil
+}
+
 func unmapIntegrity(name string) error {
 	// Remove /dev node if present.
 	if _, err := os.Stat(integrityDevPath(name)); err == nil {
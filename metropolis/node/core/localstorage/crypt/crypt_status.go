@@ -0,0 +1,64 @@
+package crypt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"source.monogon.dev/osbase/devicemapper"
+)
+
+// Status describes the runtime state of a mapped block device.
+type Status struct {
+	// Mode with which the device is mapped.
+	Mode Mode
+	// IntegrityMismatches is the number of integrity mismatches detected by
+	// dm-integrity since the device got mapped. Only valid for authenticated
+	// modes.
+	IntegrityMismatches uint64
+	// IntegrityDataSectors is the number of 512-byte data sectors provided by
+	// the dm-integrity device. Only valid for authenticated modes.
+	IntegrityDataSectors uint64
+}
+
+// GetStatus returns the Status of a block device mapped by Map or Init. The
+// given name and mode must match the name and mode used when mapping and/or
+// initializing the disk.
+func GetStatus(name string, mode Mode) (*Status, error) {
+	res := Status{
+		Mode: mode,
+	}
+	if mode.authenticated() {
+		targets, err := devicemapper.TableStatus(integrityDMName(name))
+		if err != nil {
+			return nil, fmt.Errorf("getting integrity device status failed: %w", err)
+		}
+		if len(targets) != 1 || targets[0].Type != "integrity" {
+			return nil, fmt.Errorf("integrity device has unexpected table")
+		}
+		res.IntegrityMismatches, res.IntegrityDataSectors, err = parseIntegrityStatus(targets[0].Status)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &res, nil
+}
+
+// parseIntegrityStatus parses the status line of a dm-integrity target, which
+// starts with the number of integrity mismatches and the number of provided
+// data sectors.
+func parseIntegrityStatus(status string) (mismatches, dataSectors uint64, err error) {
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("invalid integrity status %q", status)
+	}
+	mismatches, err = strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid integrity mismatch count: %w", err)
+	}
+	dataSectors, err = strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid integrity data sector count: %w", err)
+	}
+	return mismatches, dataSectors, nil
+}
@@ -0,0 +1,33 @@
+package crypt
+
+import "testing"
+
+func TestParseIntegrityStatus(t *testing.T) {
+	for _, te := range []struct {
+		status          string
+		wantMismatches  uint64
+		wantDataSectors uint64
+		wantErr         bool
+	}{
+		// Status as reported by dm-integrity: mismatches, provided data
+		// sectors and recalculation progress.
+		{status: "0 2093056 -", wantMismatches: 0, wantDataSectors: 2093056},
+		{status: "17 2093056 1024", wantMismatches: 17, wantDataSectors: 2093056},
+		{status: "  5   100  ", wantMismatches: 5, wantDataSectors: 100},
+		{status: "18446744073709551615 1", wantMismatches: 18446744073709551615, wantDataSectors: 1},
+		{status: "", wantErr: true},
+		{status: "0", wantErr: true},
+		{status: "x 100 -", wantErr: true},
+		{status: "0 -1 -", wantErr: true},
+		{status: "18446744073709551616 1", wantErr: true},
+	} {
+		mismatches, dataSectors, err := parseIntegrityStatus(te.status)
+		if (err != nil) != te.wantErr {
+			t.Errorf("parseIntegrityStatus(%q): wanted error %v, got %v", te.status, te.wantErr, err)
+			continue
+		}
+		if mismatches != te.wantMismatches || dataSectors != te.wantDataSectors {
+			t.Errorf("parseIntegrityStatus(%q): wanted %d/%d, got %d/%d", te.status, te.wantMismatches, te.wantDataSectors, mismatches, dataSectors)
+		}
+	}
+}
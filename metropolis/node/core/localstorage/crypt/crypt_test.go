@@ -15,10 +15,10 @@ func TestMapUnmap(t *testing.T) {
 		t.Skip("Not in ktest")
 	}
 
-	init := func(name string, key []byte, mode Mode) string {
+	init := func(name string, key []byte, mode Mode, cipher Cipher) string {
 		t.Helper()
 
-		target, err := Init(name, "/dev/ram0", key, mode)
+		target, err := Init(name, "/dev/ram0", key, mode, cipher, DefaultJournalSectors)
 		if err != nil {
 			t.Fatalf("Init failed: %v", err)
 		}
@@ -33,9 +33,9 @@ func TestMapUnmap(t *testing.T) {
 
 	}
 
-	map_ := func(name string, key []byte, mode Mode) string {
+	map_ := func(name string, key []byte, mode Mode, cipher Cipher) string {
 		t.Helper()
-		target, err := Map(name, "/dev/ram0", key, mode)
+		target, err := Map(name, "/dev/ram0", key, mode, cipher, DefaultJournalSectors)
 		if err != nil {
 			t.Fatalf("Map fialed: %v", err)
 		}
@@ -81,20 +81,29 @@ func TestMapUnmap(t *testing.T) {
 		file.Close()
 	}
 
-	for i, mode := range []Mode{
-		ModeInsecure,
-		ModeEncrypted,
-		ModeAuthenticated,
-		ModeEncryptedAuthenticated,
+	for i, te := range []struct {
+		mode   Mode
+		cipher Cipher
+	}{
+		{ModeInsecure, ""},
+		{ModeEncrypted, ""},
+		{ModeAuthenticated, ""},
+		{ModeEncryptedAuthenticated, CipherAESGCM},
+		{ModeEncryptedAuthenticated, CipherChaCha20Poly1305},
 	} {
-		t.Run(string(mode), func(t *testing.T) {
+		mode, cipher := te.mode, te.cipher
+		testName := string(mode)
+		if cipher != "" {
+			testName += "," + string(cipher)
+		}
+		t.Run(testName, func(t *testing.T) {
 			name := fmt.Sprintf("test-%d", i)
 			key := bytes.Repeat([]byte("a"), 32)
 			if mode == ModeInsecure {
 				key = nil
 			}
 
-			target := init(name, key, mode)
+			target := init(name, key, mode, cipher)
 			witness := writeWitness(target, i)
 			unmap(name, mode)
 
@@ -104,7 +113,7 @@ func TestMapUnmap(t *testing.T) {
 				}
 			}
 
-			target2 := map_(name, key, mode)
+			target2 := map_(name, key, mode, cipher)
 			if target != target2 {
 				t.Fatalf("Init mounted at %s, first Map mounted at %s", target, target2)
 			}
@@ -112,7 +121,7 @@ func TestMapUnmap(t *testing.T) {
 			checkWitness(target, witness)
 			unmap(name, mode)
 
-			target3 := map_(name, key, mode)
+			target3 := map_(name, key, mode, cipher)
 			if target != target3 {
 				t.Fatalf("Init mounted at %s, second Map mounted at %s", target, target2)
 			}
@@ -121,3 +130,39 @@ func TestMapUnmap(t *testing.T) {
 		})
 	}
 }
+
+// TestJournalSectorsTooLarge makes sure that Init refuses to set up an
+// integrity journal which doesn't fit the underlying device.
+func TestJournalSectorsTooLarge(t *testing.T) {
+	if os.Getenv("IN_KTEST") != "true" {
+		t.Skip("Not in ktest")
+	}
+
+	key := bytes.Repeat([]byte("a"), 32)
+	if _, err := Init("test-journal", "/dev/ram0", key, ModeEncryptedAuthenticated, CipherAESGCM, 1<<40); err == nil {
+		t.Fatalf("Init with oversized journal succeeded")
+	}
+}
+
+// TestCipherSpec makes sure all known ciphers have a consistent spec, and
+// unknown ciphers are rejected.
+func TestCipherSpec(t *testing.T) {
+	for c := range cipherSpecs {
+		if _, err := c.spec(); err != nil {
+			t.Errorf("Cipher %q: %v", c, err)
+		}
+	}
+	spec, err := Cipher("").spec()
+	if err != nil {
+		t.Fatalf("Default cipher: %v", err)
+	}
+	if spec != cipherSpecs[CipherAESGCM] {
+		t.Errorf("Default cipher is not AES-GCM")
+	}
+	if spec.tagSize() != 28 {
+		t.Errorf("AES-GCM tag size is %d, wanted 28", spec.tagSize())
+	}
+	if _, err := Cipher("rot13").spec(); err == nil {
+		t.Errorf("Unknown cipher accepted")
+	}
+}
@@ -17,9 +17,14 @@
 package localstorage
 
 import (
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
 
 	"golang.org/x/sys/unix"
 
@@ -27,11 +32,27 @@ import (
 	"source.monogon.dev/metropolis/node/core/localstorage/declarative"
 	cpb "source.monogon.dev/metropolis/proto/common"
 	ppb "source.monogon.dev/metropolis/proto/private"
+	"source.monogon.dev/osbase/event/memory"
+	"source.monogon.dev/osbase/fsquota"
+	"source.monogon.dev/osbase/supervisor"
 	"source.monogon.dev/osbase/tpm"
 )
 
 var keySize uint16 = 256 / 8
 
+// cryptCipher returns the crypt.Cipher corresponding to a node storage
+// cipher.
+func cryptCipher(c cpb.NodeStorageCipher) (crypt.Cipher, error) {
+	switch c {
+	case cpb.NodeStorageCipher_NODE_STORAGE_CIPHER_AES_GCM:
+		return crypt.CipherAESGCM, nil
+	case cpb.NodeStorageCipher_NODE_STORAGE_CIPHER_CHACHA20_POLY1305:
+		return crypt.CipherChaCha20Poly1305, nil
+	default:
+		return "", fmt.Errorf("invalid node storage cipher: %d", c)
+	}
+}
+
 // MountExisting mounts the node data partition with the given cluster unlock key.
 // It automatically unseals the node unlock key from the TPM.
 func (d *DataDirectory) MountExisting(config *ppb.SealedConfiguration, clusterUnlockKey []byte) error {
@@ -55,6 +76,10 @@ func (d *DataDirectory) MountExisting(config *ppb.SealedConfiguration, clusterUn
 	default:
 		return fmt.Errorf("invalid storage security in sealed configuration: %d", config.StorageSecurity)
 	}
+	cipher, err := cryptCipher(config.StorageCipher)
+	if err != nil {
+		return fmt.Errorf("in sealed configuration: %w", err)
+	}
 
 	d.flagLock.Lock()
 	defer d.flagLock.Unlock()
@@ -75,20 +100,32 @@ func (d *DataDirectory) MountExisting(config *ppb.SealedConfiguration, clusterUn
 		}
 	}
 
-	target, err := crypt.Map("data", crypt.NodeDataRawPath, key, mode)
+	target, err := crypt.Map("data", crypt.NodeDataRawPath, key, mode, cipher, d.JournalSectors)
+	if err != nil {
+		return err
+	}
+	size, err := d.growMapping(target, key, mode, cipher)
 	if err != nil {
 		return err
 	}
 	if err := d.mount(target); err != nil {
 		return err
 	}
+	if err := growXFS(d.FullPath(), size); err != nil {
+		return fmt.Errorf("growing data filesystem: %w", err)
+	}
+	d.security = config.StorageSecurity
+	d.mode = mode
+	d.Mounted.Set(true)
 	return nil
 }
 
 // MountNew initializes the node data partition and returns the cluster unlock
 // key. It seals the local portion into the TPM. This is a potentially slow
-// operation since it touches the whole partition.
-func (d *DataDirectory) MountNew(config *ppb.SealedConfiguration, security cpb.NodeStorageSecurity) ([]byte, error) {
+// operation since it touches the whole partition. The given cipher is used if
+// the storage security is authenticated and encrypted, and is saved into the
+// sealed configuration alongside the storage security.
+func (d *DataDirectory) MountNew(config *ppb.SealedConfiguration, security cpb.NodeStorageSecurity, cipher cpb.NodeStorageCipher) ([]byte, error) {
 	d.flagLock.Lock()
 	defer d.flagLock.Unlock()
 
@@ -111,13 +148,17 @@ func (d *DataDirectory) MountNew(config *ppb.SealedConfiguration, security cpb.N
 	default:
 		return nil, fmt.Errorf("invalid node storage security: %d", security)
 	}
+	dmCipher, err := cryptCipher(cipher)
+	if err != nil {
+		return nil, err
+	}
 	config.StorageSecurity = security
+	config.StorageCipher = cipher
 
 	var nodeUnlockKey, clusterUnlockKey, key []byte
 
 	// Generate keys unless we're in insecure mode.
 	if mode != crypt.ModeInsecure {
-		var err error
 		if tpm.IsInitialized() {
 			nodeUnlockKey, err = tpm.GenerateSafeKey(keySize)
 		} else {
@@ -146,7 +187,7 @@ func (d *DataDirectory) MountNew(config *ppb.SealedConfiguration, security cpb.N
 		}
 	}
 
-	target, err := crypt.Init("data", crypt.NodeDataRawPath, key, mode)
+	target, err := crypt.Init("data", crypt.NodeDataRawPath, key, mode, dmCipher, d.JournalSectors)
 	if err != nil {
 		return nil, fmt.Errorf("initializing encrypted block device: %w", err)
 	}
@@ -158,6 +199,8 @@ func (d *DataDirectory) MountNew(config *ppb.SealedConfiguration, security cpb.N
 	if err := d.mount(target); err != nil {
 		return nil, fmt.Errorf("mounting: %w", err)
 	}
+	d.security = security
+	d.mode = mode
 
 	// TODO(q3k): do this automatically?
 	for _, d := range []declarative.DirectoryPlacement{
@@ -177,10 +220,164 @@ func (d *DataDirectory) MountNew(config *ppb.SealedConfiguration, security cpb.N
 	}
 
 	config.NodeUnlockKey = nodeUnlockKey
+	d.Mounted.Set(true)
 
 	return clusterUnlockKey, nil
 }
 
+// DataStatus describes the state of the mounted data partition.
+type DataStatus struct {
+	// Security is the storage security mode of the data partition.
+	Security cpb.NodeStorageSecurity
+	// IntegrityMismatches is the number of integrity errors detected since the
+	// data partition has been mounted. Only valid if Security is
+	// NODE_STORAGE_SECURITY_AUTHENTICATED_ENCRYPTED.
+	IntegrityMismatches uint64
+	// BytesTotal is the size of the data filesystem.
+	BytesTotal uint64
+	// BytesAvailable is the free space of the data filesystem available to
+	// unprivileged users.
+	BytesAvailable uint64
+	// BytesUsed is the space used on the data filesystem.
+	BytesUsed uint64
+	// InodesTotal is the number of inodes of the data filesystem.
+	InodesTotal uint64
+	// InodesUsed is the number of inodes in use on the data filesystem.
+	InodesUsed uint64
+}
+
+// dataPressurePercent is the percentage of free space or inodes of the data
+// partition below which it is considered to be under pressure.
+const dataPressurePercent = 10
+
+// Pressure returns whether the data partition is running low on free space or
+// inodes.
+func (s *DataStatus) Pressure() bool {
+	if s.BytesAvailable*100 < s.BytesTotal*dataPressurePercent {
+		return true
+	}
+	if (s.InodesTotal-s.InodesUsed)*100 < s.InodesTotal*dataPressurePercent {
+		return true
+	}
+	return false
+}
+
+// errDataNotMounted is returned by DataDirectory.Status if the data partition
+// is not mounted.
+var errDataNotMounted = errors.New("not mounted")
+
+// Status returns the DataStatus of the data partition. An error is returned if
+// the data partition is not mounted.
+func (d *DataDirectory) Status() (*DataStatus, error) {
+	d.flagLock.Lock()
+	defer d.flagLock.Unlock()
+
+	if d.mode == "" {
+		return nil, errDataNotMounted
+	}
+	cs, err := crypt.GetStatus("data", d.mode)
+	if err != nil {
+		return nil, err
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(d.FullPath(), &st); err != nil {
+		return nil, fmt.Errorf("statfs failed: %w", err)
+	}
+	return &DataStatus{
+		Security:            d.security,
+		IntegrityMismatches: cs.IntegrityMismatches,
+		BytesTotal:          st.Blocks * uint64(st.Bsize),
+		BytesAvailable:      st.Bavail * uint64(st.Bsize),
+		BytesUsed:           (st.Blocks - st.Bfree) * uint64(st.Bsize),
+		InodesTotal:         st.Files,
+		InodesUsed:          st.Files - st.Ffree,
+	}, nil
+}
+
+// usagePollInterval is the interval in which RunUsageMonitor samples the
+// status of the data partition.
+const usagePollInterval = 30 * time.Second
+
+// RunUsageMonitor is a runnable which periodically publishes the DataStatus of
+// the data partition to Usage. Nothing is published until the data partition
+// is mounted.
+func (d *DataDirectory) RunUsageMonitor(ctx context.Context) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	t := time.NewTicker(usagePollInterval)
+	defer t.Stop()
+	var loggedErr bool
+	for {
+		st, err := d.Status()
+		switch {
+		case err == nil:
+			d.Usage.Set(st)
+			loggedErr = false
+		case errors.Is(err, errDataNotMounted):
+		case !loggedErr:
+			// Only log errors once, as they are likely to persist.
+			supervisor.Logger(ctx).Warningf("Could not get data partition status: %v", err)
+			loggedErr = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Quotas returns the quota and its utilization of every volume within the
+// volumes directory, keyed by volume ID. Volumes without a quota (eg. block
+// volumes backed by image files) are omitted.
+func (d *DataVolumesDirectory) Quotas() (map[string]*fsquota.Quota, error) {
+	entries, err := os.ReadDir(d.FullPath())
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]*fsquota.Quota)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		quota, err := fsquota.GetQuota(filepath.Join(d.FullPath(), e.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("volume %s: %w", e.Name(), err)
+		}
+		res[e.Name()] = quota
+	}
+	return res, nil
+}
+
+// growMapping grows the mapped data partition at target if the underlying
+// partition has been enlarged since the mapping was created, and returns the
+// resulting size of target in bytes. In authenticated modes the underlying
+// partition is always larger than the mapped device due to the integrity
+// metadata, so the mapping is always reloaded, which is a no-op if the
+// partition did not change.
+func (d *DataDirectory) growMapping(target string, key []byte, mode crypt.Mode, cipher crypt.Cipher) (uint64, error) {
+	mapped, err := blockDeviceSize(target)
+	if err != nil {
+		return 0, fmt.Errorf("getting size of mapped data partition: %w", err)
+	}
+	underlying, err := blockDeviceSize(crypt.NodeDataRawPath)
+	if err != nil {
+		return 0, fmt.Errorf("getting size of data partition: %w", err)
+	}
+	if underlying <= mapped {
+		return mapped, nil
+	}
+	size, err := crypt.Resize("data", crypt.NodeDataRawPath, key, mode, cipher, d.JournalSectors)
+	if err != nil {
+		return 0, fmt.Errorf("resizing data partition: %w", err)
+	}
+	return size, nil
+}
+
 func (d *DataDirectory) mount(path string) error {
 	// TODO(T965): MS_NODEV should definitely be set on the data partition, but as long as the kubelet root
 	// is on there, we can't do it.
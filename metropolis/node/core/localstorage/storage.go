@@ -38,7 +38,10 @@ package localstorage
 import (
 	"sync"
 
+	"source.monogon.dev/metropolis/node/core/localstorage/crypt"
 	"source.monogon.dev/metropolis/node/core/localstorage/declarative"
+	cpb "source.monogon.dev/metropolis/proto/common"
+	"source.monogon.dev/osbase/event/memory"
 )
 
 type Root struct {
@@ -79,6 +82,23 @@ type DataDirectory struct {
 	// mounted is set by DataDirectory when it is mounted. It ensures it's only
 	// mounted once.
 	mounted bool
+	// security is the storage security of the data partition, set once it has
+	// been successfully mounted.
+	security cpb.NodeStorageSecurity
+	// mode is the crypt mode corresponding to security.
+	mode crypt.Mode
+
+	// Mounted is set to true once the data partition has been mounted.
+	Mounted memory.Value[bool]
+	// Usage is the status of the data partition, periodically updated by
+	// RunUsageMonitor once it is mounted.
+	Usage memory.Value[*DataStatus]
+
+	// JournalSectors is the size of the dm-integrity journal of the data
+	// partition in 512-byte sectors, used when the data partition is
+	// authenticated. Zero means crypt.DefaultJournalSectors. It must be set
+	// before mounting.
+	JournalSectors uint64
 
 	Containerd declarative.Directory   `dir:"containerd"`
 	Etcd       DataEtcdDirectory       `dir:"etcd"`
@@ -91,6 +111,8 @@ type DataNodeDirectory struct {
 	declarative.Directory
 	Credentials    PKIDirectory     `dir:"credentials"`
 	PersistedRoles declarative.File `file:"roles.pb"`
+	// Persisted node log journal, see logtree.LogTree.Persist.
+	LogJournal declarative.File `file:"logs.pb"`
 }
 
 type DataEtcdDirectory struct {
@@ -182,7 +182,7 @@ func (e *ESPSealedConfiguration) SealSecureBoot(c *ppb.SealedConfiguration, tpmU
 		//      table 3.3.4.1
 		// See: https://trustedcomputinggroup.org/wp-content/uploads/
 		//      TCG_PCClient_PFP_r1p05_v22_02dec2020.pdf
-		bytes, err = tpm.Seal(bytes, tpm.SecureBootPCRs)
+		bytes, err = tpm.SealToPCRs(bytes, tpm.SecureBootPCRs)
 		if err != nil {
 			return fmt.Errorf("while using tpm: %w", err)
 		}
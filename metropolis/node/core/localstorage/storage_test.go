@@ -19,7 +19,9 @@ package localstorage
 import (
 	"testing"
 
+	"source.monogon.dev/metropolis/node/core/localstorage/crypt"
 	"source.monogon.dev/metropolis/node/core/localstorage/declarative"
+	cpb "source.monogon.dev/metropolis/proto/common"
 )
 
 func TestValidateAll(t *testing.T) {
@@ -56,3 +58,43 @@ func TestPlaceFS(t *testing.T) {
 		}
 	}
 }
+
+func TestCryptCipher(t *testing.T) {
+	for _, te := range []struct {
+		in      cpb.NodeStorageCipher
+		want    crypt.Cipher
+		wantErr bool
+	}{
+		// Sealed configurations written before the cipher was configurable
+		// don't contain it, and must keep mapping with AES-GCM.
+		{0, crypt.CipherAESGCM, false},
+		{cpb.NodeStorageCipher_NODE_STORAGE_CIPHER_CHACHA20_POLY1305, crypt.CipherChaCha20Poly1305, false},
+		{cpb.NodeStorageCipher(42), "", true},
+	} {
+		got, err := cryptCipher(te.in)
+		if (err != nil) != te.wantErr {
+			t.Errorf("%v: wanted error %v, got %v", te.in, te.wantErr, err)
+			continue
+		}
+		if got != te.want {
+			t.Errorf("%v: wanted %q, got %q", te.in, te.want, got)
+		}
+	}
+}
+
+func TestDataStatusPressure(t *testing.T) {
+	for i, te := range []struct {
+		st   DataStatus
+		want bool
+	}{
+		{DataStatus{}, false},
+		{DataStatus{BytesTotal: 1000, BytesAvailable: 500, InodesTotal: 100, InodesUsed: 50}, false},
+		{DataStatus{BytesTotal: 1000, BytesAvailable: 100, InodesTotal: 100, InodesUsed: 90}, false},
+		{DataStatus{BytesTotal: 1000, BytesAvailable: 99, InodesTotal: 100, InodesUsed: 50}, true},
+		{DataStatus{BytesTotal: 1000, BytesAvailable: 500, InodesTotal: 100, InodesUsed: 91}, true},
+	} {
+		if got := te.st.Pressure(); got != te.want {
+			t.Errorf("case %d: wanted %v, got %v", i, te.want, got)
+		}
+	}
+}
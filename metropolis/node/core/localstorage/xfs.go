@@ -0,0 +1,91 @@
+package localstorage
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"source.monogon.dev/osbase/blockdev"
+)
+
+// xfsGeometry is struct xfs_fsop_geom_v1 from the XFS UAPI, which is enough to
+// retrieve the block size and the number of data blocks of a filesystem.
+type xfsGeometry struct {
+	BlockSize    uint32
+	RTExtSize    uint32
+	AGBlocks     uint32
+	AGCount      uint32
+	LogBlocks    uint32
+	SectSize     uint32
+	InodeSize    uint32
+	IMaxPct      uint32
+	DataBlocks   uint64
+	RTBlocks     uint64
+	RTExtents    uint64
+	LogStart     uint64
+	UUID         [16]byte
+	SUnit        uint32
+	SWidth       uint32
+	Version      int32
+	Flags        uint32
+	LogSectSize  uint32
+	RTSectSize   uint32
+	DirBlockSize uint32
+}
+
+// xfsGrowfsData is struct xfs_growfs_data from the XFS UAPI.
+type xfsGrowfsData struct {
+	NewBlocks uint64
+	IMaxPct   uint32
+}
+
+const (
+	// xfsIocFsGeometryV1 is _IOR('X', 100, struct xfs_fsop_geom_v1).
+	xfsIocFsGeometryV1 = 2<<30 | uintptr(unsafe.Sizeof(xfsGeometry{}))<<16 | 'X'<<8 | 100
+	// xfsIocFsGrowfsData is _IOW('X', 110, struct xfs_growfs_data).
+	xfsIocFsGrowfsData = 1<<30 | uintptr(unsafe.Sizeof(xfsGrowfsData{}))<<16 | 'X'<<8 | 110
+)
+
+// blockDeviceSize returns the size of the block device at path in bytes.
+func blockDeviceSize(path string) (uint64, error) {
+	blkdev, err := blockdev.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer blkdev.Close()
+	return uint64(blkdev.BlockCount() * blkdev.BlockSize()), nil
+}
+
+// growXFS grows the data section of the XFS filesystem mounted at path to
+// fill a block device of the given size in bytes. Nothing is done if the
+// filesystem already covers the whole block device. This is the equivalent
+// of running xfs_growfs -d on the mountpoint.
+func growXFS(path string, size uint64) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	var geom xfsGeometry
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, dir.Fd(), xfsIocFsGeometryV1, uintptr(unsafe.Pointer(&geom))); errno != 0 {
+		return fmt.Errorf("failed to get filesystem geometry: %w", errno)
+	}
+	if geom.BlockSize == 0 {
+		return fmt.Errorf("filesystem reported block size of zero")
+	}
+	newBlocks := size / uint64(geom.BlockSize)
+	if newBlocks <= geom.DataBlocks {
+		return nil
+	}
+	req := xfsGrowfsData{
+		NewBlocks: newBlocks,
+		IMaxPct:   geom.IMaxPct,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, dir.Fd(), xfsIocFsGrowfsData, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("failed to grow filesystem from %d to %d blocks: %w", geom.DataBlocks, newBlocks, errno)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"source.monogon.dev/metropolis/node/core/localstorage"
+	"source.monogon.dev/osbase/logtree"
+	"source.monogon.dev/osbase/supervisor"
+)
+
+const (
+	// logRateLimit and logRateBurst limit the rate at which every DN can log,
+	// so that a single runnable flooding the log cannot evict the logs of all
+	// other runnables from the journal.
+	logRateLimit = 100
+	logRateBurst = 1000
+	// kernelLogDeduplicationWindow is the window within which repeated kernel
+	// messages are collapsed.
+	kernelLogDeduplicationWindow = 10 * time.Second
+)
+
+// configureLogTree sets up rate limiting and deduplication of the node's
+// LogTree.
+func configureLogTree(lt *logtree.LogTree) error {
+	if err := lt.SetRateLimit(logRateLimit, logRateBurst); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+	// The kernel is prone to logging the same message over and over again (eg.
+	// on hardware errors).
+	if err := lt.SetDeduplication("root.kernel", kernelLogDeduplicationWindow); err != nil {
+		return fmt.Errorf("kernel log deduplication: %w", err)
+	}
+	return nil
+}
+
+// logJournalMaxSize is the maximum size of the log journal persisted to the
+// data partition.
+const logJournalMaxSize = 64 << 20
+
+// persistLogs is a runnable which persists the node's log journal to the data
+// partition once it is mounted, so that logs from previous boots are available
+// to log readers.
+func persistLogs(ctx context.Context, lt *logtree.LogTree, root *localstorage.Root) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	w := root.Data.Mounted.Watch()
+	defer w.Close()
+	for {
+		mounted, err := w.Get(ctx)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			break
+		}
+	}
+
+	// Logs are best effort, so don't fail the node if they cannot be
+	// persisted.
+	path := root.Data.Node.LogJournal.FullPath()
+	if err := lt.Persist(path, logJournalMaxSize); err != nil {
+		supervisor.Logger(ctx).Errorf("Could not persist logs to %s: %v", path, err)
+	} else {
+		supervisor.Logger(ctx).Infof("Persisting logs to %s", path)
+	}
+	supervisor.Signal(ctx, supervisor.SignalDone)
+	return nil
+}
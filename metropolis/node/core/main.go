@@ -51,6 +51,9 @@ func main() {
 
 	// Root system logtree.
 	lt := logtree.New()
+	if err := configureLogTree(lt); err != nil {
+		panic(fmt.Errorf("could not configure logtree: %w", err))
+	}
 
 	// Set up logger for Metropolis. Currently logs everything to /dev/tty0 and
 	// /dev/ttyS{0,1}.
@@ -136,7 +139,8 @@ func main() {
 	}
 
 	updateSvc := &update.Service{
-		Logger: lt.MustLeveledFor("update"),
+		Logger:               lt.MustLeveledFor("update"),
+		AllowUnsignedBundles: allowUnsignedBundles,
 	}
 
 	// Make context for supervisor. We cancel it when we reach the trapdoor.
@@ -155,6 +159,14 @@ func main() {
 		if err := root.Start(ctx, updateSvc); err != nil {
 			return fmt.Errorf("cannot start root FS: %w", err)
 		}
+		if err := supervisor.Run(ctx, "data-usage", root.Data.RunUsageMonitor); err != nil {
+			return fmt.Errorf("when starting data usage monitor: %w", err)
+		}
+		if err := supervisor.Run(ctx, "log-persistence", func(ctx context.Context) error {
+			return persistLogs(ctx, lt, root)
+		}); err != nil {
+			return fmt.Errorf("when starting log persistence: %w", err)
+		}
 		nodeParams, err := getNodeParams(ctx, root)
 		if err != nil {
 			return fmt.Errorf("cannot get node parameters: %w", err)
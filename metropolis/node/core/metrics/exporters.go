@@ -69,6 +69,10 @@ var DefaultExporters = []*Exporter{
 		Port: node.MetricsContainerdListenerPort,
 		Path: "/v1/metrics",
 	},
+	{
+		Name: "csi",
+		Port: node.MetricsCSIListenerPort,
+	},
 }
 
 func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
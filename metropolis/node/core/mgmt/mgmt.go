@@ -12,6 +12,7 @@ import (
 
 	"source.monogon.dev/metropolis/node"
 	"source.monogon.dev/metropolis/node/core/identity"
+	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/rpc"
 	"source.monogon.dev/metropolis/node/core/update"
 	"source.monogon.dev/osbase/logtree"
@@ -28,6 +29,8 @@ type Service struct {
 	LogTree *logtree.LogTree
 	// Update service handle for performing updates via the API.
 	UpdateService *update.Service
+	// Data partition from which NodeManagement.GetStorageInfo will be served.
+	Data *localstorage.DataDirectory
 	// Serialized UpdateNode RPCs
 	updateMutex sync.Mutex
 
@@ -0,0 +1,52 @@
+package mgmt
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+// rebootDelay is the time between acknowledging a reboot request and actually
+// rebooting, giving the RPC response time to reach the caller.
+const rebootDelay = 5 * time.Second
+
+func (s *Service) Reboot(ctx context.Context, req *apb.RebootRequest) (*apb.RebootResponse, error) {
+	var methodString string
+	var method int
+	switch req.Type {
+	case apb.RebootRequest_TYPE_REBOOT:
+		methodString, method = "reboot", unix.LINUX_REBOOT_CMD_RESTART
+	case apb.RebootRequest_TYPE_POWER_OFF:
+		methodString, method = "power off", unix.LINUX_REBOOT_CMD_POWER_OFF
+	default:
+		return nil, status.Error(codes.InvalidArgument, "type needs to be explicitly specified")
+	}
+
+	// Don't interrupt an update which is being installed. The lock is held
+	// until the node reboots, so that no update can start in the meantime.
+	if !s.updateMutex.TryLock() {
+		return nil, status.Error(codes.Aborted, "an UpdateNode RPC is in progress on this node")
+	}
+
+	logger := s.LogTree.MustLeveledFor("reboot")
+	logger.Infof("%s requested, performing in %s", methodString, rebootDelay)
+	go func() {
+		// TODO(#253): Tell Supervisor to shut down gracefully and reboot
+		time.Sleep(rebootDelay)
+		logger.Infof("performing %s now...", methodString)
+		// Write out all logs if they are persisted.
+		s.LogTree.Sync()
+		unix.Unmount(s.UpdateService.ESPPath, 0)
+		unix.Sync()
+		if err := unix.Reboot(method); err != nil {
+			logger.Errorf("%s failed: %v", methodString, err)
+			s.updateMutex.Unlock()
+		}
+	}()
+	return &apb.RebootResponse{}, nil
+}
@@ -0,0 +1,44 @@
+package mgmt
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apb "source.monogon.dev/metropolis/proto/api"
+)
+
+func (s *Service) GetStorageInfo(ctx context.Context, req *apb.GetStorageInfoRequest) (*apb.GetStorageInfoResponse, error) {
+	if s.Data == nil {
+		return nil, status.Error(codes.Unimplemented, "storage information not available on this node")
+	}
+	st, err := s.Data.Status()
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not get data partition status: %v", err)
+	}
+	quotas, err := s.Data.Volumes.Quotas()
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not get volume quotas: %v", err)
+	}
+	res := &apb.GetStorageInfoResponse{
+		StorageSecurity:     st.Security,
+		IntegrityMismatches: st.IntegrityMismatches,
+		DataBytesTotal:      st.BytesTotal,
+		DataBytesAvailable:  st.BytesAvailable,
+	}
+	for id, q := range quotas {
+		res.Volumes = append(res.Volumes, &apb.GetStorageInfoResponse_Volume{
+			Id:          id,
+			BytesQuota:  q.Bytes,
+			InodesQuota: q.Inodes,
+			BytesUsed:   q.BytesUsed,
+			InodesUsed:  q.InodesUsed,
+		})
+	}
+	sort.Slice(res.Volumes, func(i, j int) bool {
+		return res.Volumes[i].Id < res.Volumes[j].Id
+	})
+	return res, nil
+}
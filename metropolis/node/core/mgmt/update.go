@@ -17,7 +17,7 @@ func (s *Service) UpdateNode(ctx context.Context, req *apb.UpdateNodeRequest) (*
 	if ok {
 		defer s.updateMutex.Unlock()
 	} else {
-		return nil, status.Error(codes.Aborted, "another UpdateNode RPC is in progress on this node")
+		return nil, status.Error(codes.Aborted, "another UpdateNode RPC or a reboot is in progress on this node")
 	}
 	if req.ActivationMode == apb.ActivationMode_ACTIVATION_INVALID {
 		return nil, status.Errorf(codes.InvalidArgument, "activation_mode needs to be explicitly specified")
@@ -49,6 +49,27 @@ func (s *Service) UpdateNode(ctx context.Context, req *apb.UpdateNodeRequest) (*
 	return &apb.UpdateNodeResponse{}, nil
 }
 
+func (s *Service) CheckUpdate(ctx context.Context, req *apb.CheckUpdateRequest) (*apb.CheckUpdateResponse, error) {
+	if req.BundleUrl == "" {
+		return nil, status.Error(codes.InvalidArgument, "bundle_url must be set")
+	}
+	report, err := s.UpdateService.CheckBundle(ctx, req.BundleUrl)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "error checking update: %v", err)
+	}
+	res := &apb.CheckUpdateResponse{
+		Ok: report.OK(),
+	}
+	for _, c := range report.Checks {
+		res.Checks = append(res.Checks, &apb.CheckUpdateResponse_Check{
+			Name:   c.Name,
+			Passed: c.Passed,
+			Detail: c.Detail,
+		})
+	}
+	return res, nil
+}
+
 // For kexec it's recommended to disable all physical network interfaces
 // before doing it. This function doesn't return any errors as it's best-
 // effort anyways as we cannot reliably log the error anymore.
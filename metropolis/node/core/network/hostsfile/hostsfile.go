@@ -21,7 +21,10 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/netip"
+	"slices"
 	"sort"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
@@ -52,6 +55,11 @@ type Config struct {
 	// ClusterDirectorySaved will be written with a boolean indicating whether the
 	// ClusterDirectory has been successfully persisted to the ESP.
 	ClusterDirectorySaved event.Value[bool]
+	// StaleNodeGracePeriod is the time after which a node which has been deleted
+	// from the cluster gets removed from /etc/hosts and the ClusterDirectory. If
+	// zero, nodes are never removed, and stale data is kept forever. This is set
+	// from the cluster configuration.
+	StaleNodeGracePeriod time.Duration
 }
 
 // Service is the hostsfile service instance. See package-level documentation
@@ -70,9 +78,11 @@ type ClusterDialer func(ctx context.Context) (*grpc.ClientConn, error)
 // nodeInfo contains all of a single node's data needed to build its entry in
 // either hostsfile or ClusterDirectory.
 type nodeInfo struct {
-	// address is the node's IP address.
-	address string
-	// local is true if address belongs to the local node.
+	// addresses are the node's IP addresses, each either IPv4 or IPv6. IPv4-mapped
+	// IPv6 addresses are always stored unmapped, see parseAddress. The addresses
+	// are kept sorted and deduplicated, see sortAddresses.
+	addresses []netip.Addr
+	// local is true if addresses belong to the local node.
 	local bool
 	// controlPlane is true if this node can be expected to run the control plane
 	// (for example, it was running it at time of retrieval from the cluster). This
@@ -81,7 +91,7 @@ type nodeInfo struct {
 }
 
 func (n *nodeInfo) equals(o *nodeInfo) bool {
-	if n.address != o.address {
+	if !slices.Equal(n.addresses, o.addresses) {
 		return false
 	}
 	if n.controlPlane != o.controlPlane {
@@ -90,9 +100,98 @@ func (n *nodeInfo) equals(o *nodeInfo) bool {
 	return true
 }
 
-// nodeMap is a map from node ID (effectively DNS name) to node IP address.
+// parseAddress parses a node address as retrieved from the cluster or the
+// network service. IPv4-mapped IPv6 addresses are converted to plain IPv4
+// addresses, so that a given address always has a single canonical string
+// representation in both /etc/hosts and the ClusterDirectory.
+func parseAddress(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("address %q has a zone", s)
+	}
+	return addr.Unmap(), nil
+}
+
+// sortAddresses sorts and deduplicates the given addresses in place, returning
+// the resulting slice. IPv4 addresses sort before IPv6 addresses.
+func sortAddresses(addrs []netip.Addr) []netip.Addr {
+	slices.SortFunc(addrs, func(a, b netip.Addr) int {
+		return a.Compare(b)
+	})
+	return slices.Compact(addrs)
+}
+
+// primary returns the address used for this node in /etc/hosts, or an invalid
+// address if the node has no addresses. This is the lowest address of the
+// node, which makes it deterministic regardless of the order in which
+// addresses were retrieved, and prefers IPv4 over IPv6 addresses.
+func (n *nodeInfo) primary() netip.Addr {
+	if len(n.addresses) == 0 {
+		return netip.Addr{}
+	}
+	return n.addresses[0]
+}
+
+// nodeMap is a map from node ID (effectively DNS name) to node IP addresses.
 type nodeMap map[string]nodeInfo
 
+// markDeleted updates tombstones, which contains the time at which each
+// non-local node of m was first seen to be absent from latest, the up-to-date
+// view of the cluster nodes. Nodes present in latest have their tombstone
+// removed.
+//
+// As tombstones are derived from the difference between the local and the
+// cluster view of nodes, they do not need to be persisted: nodes deleted while
+// the service was not running (eg. nodes only known from the saved
+// ClusterDirectory) are tombstoned once the service sees the cluster again,
+// which at worst delays their pruning by the service's downtime.
+func (m nodeMap) markDeleted(latest nodeMap, tombstones map[string]time.Time, now time.Time) {
+	for id := range tombstones {
+		if _, ok := latest[id]; ok {
+			delete(tombstones, id)
+		}
+	}
+	for id, ni := range m {
+		if ni.local {
+			continue
+		}
+		if _, ok := latest[id]; ok {
+			continue
+		}
+		if _, ok := tombstones[id]; !ok {
+			tombstones[id] = now
+		}
+	}
+}
+
+// prune removes all non-local nodes which are absent from latest and have been
+// deleted from the cluster for at least grace, as per tombstones. The IDs of
+// the removed nodes are returned in sorted order, and their tombstones are
+// removed.
+func (m nodeMap) prune(latest nodeMap, tombstones map[string]time.Time, now time.Time, grace time.Duration) []string {
+	var evicted []string
+	for id, ni := range m {
+		if ni.local {
+			continue
+		}
+		if _, ok := latest[id]; ok {
+			continue
+		}
+		deleted, ok := tombstones[id]
+		if !ok || now.Sub(deleted) < grace {
+			continue
+		}
+		delete(m, id)
+		delete(tombstones, id)
+		evicted = append(evicted, id)
+	}
+	sort.Strings(evicted)
+	return evicted
+}
+
 // hosts generates a complete /etc/hosts file based on the contents of the
 // nodeMap. Apart from the addresses in the nodeMap, entries for localhost
 // pointing to 127.0.0.1 and ::1 will also be generated.
@@ -110,8 +209,13 @@ func (m nodeMap) hosts(ctx context.Context) []byte {
 		[]byte("::1 localhost"),
 	}
 	for _, nid := range nodeIdsSorted {
-		addr := m[nid].address
-		line := fmt.Sprintf("%s %s", addr, nid)
+		// Unlike in URLs, IPv6 addresses are not bracketed in /etc/hosts.
+		ni := m[nid]
+		addr := ni.primary()
+		if !addr.IsValid() {
+			continue
+		}
+		line := fmt.Sprintf("%s %s", addr.String(), nid)
 		lines = append(lines, []byte(line))
 	}
 	lines = append(lines, []byte(""))
@@ -124,12 +228,16 @@ func (m nodeMap) hosts(ctx context.Context) []byte {
 func (m nodeMap) clusterDirectory(ctx context.Context) *cpb.ClusterDirectory {
 	var directory cpb.ClusterDirectory
 	for nid, ni := range m {
-		if !ni.controlPlane {
+		if !ni.controlPlane || len(ni.addresses) == 0 {
 			continue
 		}
-		supervisor.Logger(ctx).Infof("ClusterDirectory entry: %s", ni.address)
-		addresses := []*cpb.ClusterDirectory_Node_Address{
-			{Host: ni.address},
+		supervisor.Logger(ctx).Infof("ClusterDirectory entry: %s %v", nid, ni.addresses)
+		// Host is always a bare IP address, IPv6 addresses are not bracketed.
+		var addresses []*cpb.ClusterDirectory_Node_Address
+		for _, addr := range ni.addresses {
+			addresses = append(addresses, &cpb.ClusterDirectory_Node_Address{
+				Host: addr.String(),
+			})
 		}
 		node := &cpb.ClusterDirectory_Node{
 			Id:        nid,
@@ -162,8 +270,21 @@ func (s *Service) Run(ctx context.Context) error {
 					supervisor.Logger(ctx).Warningf("Node %d (%s) in cluster directory has no addresses, skipping...", i, node.Id)
 					continue
 				}
+				var addrs []netip.Addr
+				for j, a := range node.Addresses {
+					addr, err := parseAddress(a.Host)
+					if err != nil {
+						supervisor.Logger(ctx).Warningf("Node %d (%s) in cluster directory has invalid address %d, skipping address: %v", i, node.Id, j, err)
+						continue
+					}
+					addrs = append(addrs, addr)
+				}
+				if len(addrs) == 0 {
+					supervisor.Logger(ctx).Warningf("Node %d (%s) in cluster directory has no valid addresses, skipping...", i, node.Id)
+					continue
+				}
 				nodes[node.Id] = nodeInfo{
-					address:      node.Addresses[0].Host,
+					addresses:    sortAddresses(addrs),
 					local:        false,
 					controlPlane: true,
 				}
@@ -205,6 +326,27 @@ func (s *Service) Run(ctx context.Context) error {
 	// cluster directory with an empty one or one just containing this node.
 	haveRemoteData := false
 
+	// Latest cluster data and tombstones of nodes absent from it, used to prune
+	// stale nodes if enabled.
+	var latest nodeMap
+	tombstones := make(map[string]time.Time)
+	var pruneC <-chan time.Time
+	if s.StaleNodeGracePeriod > 0 {
+		t := time.NewTicker(min(s.StaleNodeGracePeriod, pruneInterval))
+		defer t.Stop()
+		pruneC = t.C
+	}
+	prune := func() bool {
+		if s.StaleNodeGracePeriod <= 0 || latest == nil {
+			return false
+		}
+		evicted := nodes.prune(latest, tombstones, time.Now(), s.StaleNodeGracePeriod)
+		for _, id := range evicted {
+			supervisor.Logger(ctx).Infof("Evicting node %s: deleted from cluster more than %s ago", id, s.StaleNodeGracePeriod)
+		}
+		return len(evicted) > 0
+	}
+
 	// Update nodeMap in a loop, issuing writes/updates when any change occurred.
 	for {
 		changed := false
@@ -216,25 +358,32 @@ func (s *Service) Run(ctx context.Context) error {
 			if st.ExternalAddress == nil {
 				continue
 			}
-			u := st.ExternalAddress.String()
-			if nodes[s.NodeID].address == u {
+			u, ok := netip.AddrFromSlice(st.ExternalAddress)
+			if !ok {
+				supervisor.Logger(ctx).Warningf("Got invalid local address: %v", st.ExternalAddress)
+				continue
+			}
+			u = u.Unmap()
+			if slices.Equal(nodes[s.NodeID].addresses, []netip.Addr{u}) {
 				continue
 			}
 			supervisor.Logger(ctx).Infof("Got new local address: %s", u)
 			nodes[s.NodeID] = nodeInfo{
-				address: u,
-				local:   true,
+				addresses: []netip.Addr{u},
+				local:     true,
 			}
 			changed = true
+		case <-pruneC:
+			changed = prune()
 		case u := <-s.clusterC:
 			// Loop through the nodeMap from the cluster subrunnable, making note of what
-			// changed. By design we don't care about any nodes disappearing from the
-			// nodeMap: we'd rather keep stale data about nodes that don't exist any more,
-			// as these might either be spurious or have a long tail of effectively still
-			// being used by the local node for communications while the node gets fully
-			// drained/disowned.
-			//
-			// MVP: we should at least log removed nodes.
+			// changed. By design we don't immediately care about any nodes disappearing
+			// from the nodeMap: we'd rather keep stale data about nodes that don't exist
+			// any more, as these might either be spurious or have a long tail of
+			// effectively still being used by the local node for communications while
+			// the node gets fully drained/disowned. Only once StaleNodeGracePeriod has
+			// passed since a node's deletion was noticed, it gets pruned.
+			latest = u
 			for id, info := range u {
 				// We're not interested in what the cluster thinks about our local node, as that
 				// might be outdated (eg. when we haven't yet reported a new local address to
@@ -255,10 +404,14 @@ func (s *Service) Run(ctx context.Context) error {
 				if existing.equals(&info) {
 					continue
 				}
-				supervisor.Logger(ctx).Infof("Update for node %s: address %s, control plane %v", id, info.address, info.controlPlane)
+				supervisor.Logger(ctx).Infof("Update for node %s: addresses %v, control plane %v", id, info.addresses, info.controlPlane)
 				nodes[id] = info
 				changed = true
 			}
+			nodes.markDeleted(latest, tombstones, time.Now())
+			if prune() {
+				changed = true
+			}
 			haveRemoteData = true
 		}
 
@@ -304,6 +457,10 @@ func (s *Service) runCluster(ctx context.Context) error {
 			if a.Status == nil || a.Status.ExternalAddress == "" {
 				return false
 			}
+			if _, err := parseAddress(a.Status.ExternalAddress); err != nil {
+				supervisor.Logger(ctx).Warningf("Node %s has invalid external address %q, ignoring: %v", a.Id, a.Status.ExternalAddress, err)
+				return false
+			}
 			return true
 		},
 		EqualsFn: func(a *ipb.Node, b *ipb.Node) bool {
@@ -317,7 +474,7 @@ func (s *Service) runCluster(ctx context.Context) error {
 		},
 		OnNewUpdated: func(new *ipb.Node) error {
 			nodes[new.Id] = nodeInfo{
-				address:      new.Status.ExternalAddress,
+				addresses:    nodeAddresses(new),
 				local:        false,
 				controlPlane: new.Roles.ConsensusMember != nil,
 			}
@@ -337,5 +494,31 @@ func (s *Service) runCluster(ctx context.Context) error {
 			s.clusterC <- nodesCopy
 			return nil
 		},
-	})
+	}, watcher.WithCoalesceWindow(clusterCoalesceWindow))
+}
+
+// nodeAddresses returns the sorted and deduplicated addresses of a node as
+// retrieved from the Curator. Currently this is only the node's external
+// address. Invalid addresses are skipped, as they are already rejected by the
+// FilterFn in runCluster.
+func nodeAddresses(n *ipb.Node) []netip.Addr {
+	var addrs []netip.Addr
+	for _, a := range []string{n.Status.ExternalAddress} {
+		addr, err := parseAddress(a)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return sortAddresses(addrs)
 }
+
+// clusterCoalesceWindow is the window within which node changes are batched
+// by the Curator before being sent to runCluster. Every batch causes the hosts
+// file and the ClusterDirectory to be rewritten, so there's little use in
+// getting updates at a finer granularity.
+const clusterCoalesceWindow = 500 * time.Millisecond
+
+// pruneInterval is the maximum interval at which the hosts service checks for
+// stale nodes to prune, if StaleNodeGracePeriod is set.
+const pruneInterval = time.Minute
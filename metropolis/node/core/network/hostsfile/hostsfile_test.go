@@ -0,0 +1,265 @@
+package hostsfile
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"source.monogon.dev/osbase/supervisor"
+
+	cpb "source.monogon.dev/metropolis/proto/common"
+)
+
+// TestMixedAddressFamilies exercises /etc/hosts and ClusterDirectory generation
+// from a nodeMap containing both IPv4 and IPv6 node addresses.
+func TestMixedAddressFamilies(t *testing.T) {
+	m := make(nodeMap)
+	for id, addr := range map[string]string{
+		"metropolis-a": "10.0.0.1",
+		"metropolis-b": "2001:DB8:0::2",
+		"metropolis-c": "::ffff:10.0.0.3",
+	} {
+		a, err := parseAddress(addr)
+		if err != nil {
+			t.Fatalf("parseAddress(%q): %v", addr, err)
+		}
+		m[id] = nodeInfo{
+			addresses:    []netip.Addr{a},
+			controlPlane: true,
+		}
+	}
+	m["metropolis-d"] = nodeInfo{
+		addresses: []netip.Addr{netip.MustParseAddr("fd00::4")},
+	}
+
+	got := string(m.hosts(context.Background()))
+	want := "127.0.0.1 localhost\n" +
+		"::1 localhost\n" +
+		"10.0.0.1 metropolis-a\n" +
+		"2001:db8::2 metropolis-b\n" +
+		"10.0.0.3 metropolis-c\n" +
+		"fd00::4 metropolis-d\n"
+	if got != want {
+		t.Errorf("Wrong hosts file, got:\n%s\nwant:\n%s", got, want)
+	}
+
+	cdC := make(chan *cpb.ClusterDirectory)
+	supervisor.TestHarness(t, func(ctx context.Context) error {
+		cdC <- m.clusterDirectory(ctx)
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	})
+	cd := <-cdC
+	hosts := make(map[string]string)
+	for _, n := range cd.Nodes {
+		if len(n.Addresses) != 1 {
+			t.Fatalf("Node %s: wanted exactly one address, got %d", n.Id, len(n.Addresses))
+		}
+		hosts[n.Id] = n.Addresses[0].Host
+	}
+	wantHosts := map[string]string{
+		"metropolis-a": "10.0.0.1",
+		"metropolis-b": "2001:db8::2",
+		"metropolis-c": "10.0.0.3",
+	}
+	if len(hosts) != len(wantHosts) {
+		t.Errorf("Wrong ClusterDirectory nodes, got %v, want %v", hosts, wantHosts)
+	}
+	for id, want := range wantHosts {
+		if got := hosts[id]; got != want {
+			t.Errorf("Node %s: wrong host, got %q, want %q", id, got, want)
+		}
+	}
+}
+
+// TestMultipleAddresses exercises /etc/hosts and ClusterDirectory generation
+// for nodes with multiple addresses.
+func TestMultipleAddresses(t *testing.T) {
+	m := nodeMap{
+		"metropolis-a": {
+			addresses: sortAddresses([]netip.Addr{
+				netip.MustParseAddr("2001:db8::1"),
+				netip.MustParseAddr("10.0.1.1"),
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("10.0.1.1"),
+			}),
+			controlPlane: true,
+		},
+		"metropolis-b": {
+			addresses: sortAddresses([]netip.Addr{
+				netip.MustParseAddr("2001:db8::3"),
+				netip.MustParseAddr("2001:db8::2"),
+			}),
+			controlPlane: true,
+		},
+	}
+
+	got := string(m.hosts(context.Background()))
+	want := "127.0.0.1 localhost\n" +
+		"::1 localhost\n" +
+		"10.0.0.1 metropolis-a\n" +
+		"2001:db8::2 metropolis-b\n"
+	if got != want {
+		t.Errorf("Wrong hosts file, got:\n%s\nwant:\n%s", got, want)
+	}
+
+	cdC := make(chan *cpb.ClusterDirectory)
+	supervisor.TestHarness(t, func(ctx context.Context) error {
+		cdC <- m.clusterDirectory(ctx)
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	})
+	cd := <-cdC
+	hosts := make(map[string][]string)
+	for _, n := range cd.Nodes {
+		for _, a := range n.Addresses {
+			hosts[n.Id] = append(hosts[n.Id], a.Host)
+		}
+	}
+	wantHosts := map[string][]string{
+		"metropolis-a": {"10.0.0.1", "10.0.1.1", "2001:db8::1"},
+		"metropolis-b": {"2001:db8::2", "2001:db8::3"},
+	}
+	if len(hosts) != len(wantHosts) {
+		t.Errorf("Wrong ClusterDirectory nodes, got %v, want %v", hosts, wantHosts)
+	}
+	for id, want := range wantHosts {
+		if got := hosts[id]; !slices.Equal(got, want) {
+			t.Errorf("Node %s: wrong hosts, got %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	for _, te := range []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "192.0.2.1", want: "192.0.2.1"},
+		{in: "::ffff:192.0.2.1", want: "192.0.2.1"},
+		{in: "2001:db8::1", want: "2001:db8::1"},
+		{in: "[2001:db8::1]", wantErr: true},
+		{in: "fe80::1%eth0", wantErr: true},
+		{in: "metropolis-a", wantErr: true},
+		{in: "", wantErr: true},
+	} {
+		got, err := parseAddress(te.in)
+		if te.wantErr {
+			if err == nil {
+				t.Errorf("parseAddress(%q): wanted error, got %s", te.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAddress(%q): %v", te.in, err)
+			continue
+		}
+		if got.String() != te.want {
+			t.Errorf("parseAddress(%q): got %s, want %s", te.in, got, te.want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	grace := time.Hour
+	addr := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+	m := nodeMap{
+		"local":   {addresses: addr, local: true},
+		"current": {addresses: addr},
+		"fresh":   {addresses: addr},
+		"stale":   {addresses: addr},
+		"unknown": {addresses: addr},
+	}
+	latest := nodeMap{
+		"current": {addresses: addr},
+	}
+	tombstones := map[string]time.Time{
+		"local":   now.Add(-2 * grace),
+		"current": now.Add(-2 * grace),
+		"fresh":   now.Add(-grace / 2),
+		"stale":   now.Add(-2 * grace),
+	}
+
+	evicted := m.prune(latest, tombstones, now, grace)
+	if want := []string{"stale"}; !slices.Equal(evicted, want) {
+		t.Errorf("Wrong evicted nodes, got %v, want %v", evicted, want)
+	}
+	for _, id := range []string{"local", "current", "fresh", "unknown"} {
+		if _, ok := m[id]; !ok {
+			t.Errorf("Node %s should not have been evicted", id)
+		}
+	}
+	if _, ok := m["stale"]; ok {
+		t.Errorf("Node stale should have been evicted")
+	}
+}
+
+// TestPruneAfterGracePeriod exercises the tombstoning of nodes absent from the
+// cluster and their pruning once the grace period has passed since they were
+// first seen absent.
+func TestPruneAfterGracePeriod(t *testing.T) {
+	start := time.Now()
+	grace := time.Hour
+	addr := []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+	// Nodes as restored from a saved ClusterDirectory, plus the local node.
+	m := nodeMap{
+		"local":    {addresses: addr, local: true},
+		"current":  {addresses: addr},
+		"deleted":  {addresses: addr},
+		"returned": {addresses: addr},
+	}
+	tombstones := make(map[string]time.Time)
+
+	// The cluster does not contain the deleted and returned nodes.
+	latest := nodeMap{
+		"current": {addresses: addr},
+	}
+	m.markDeleted(latest, tombstones, start)
+	for _, id := range []string{"deleted", "returned"} {
+		if got, ok := tombstones[id]; !ok || !got.Equal(start) {
+			t.Errorf("Node %s: wanted tombstone at %v, got %v", id, start, got)
+		}
+	}
+	if len(tombstones) != 2 {
+		t.Errorf("Wanted two tombstones, got %v", tombstones)
+	}
+
+	// Nothing is pruned within the grace period.
+	if evicted := m.prune(latest, tombstones, start.Add(grace/2), grace); len(evicted) != 0 {
+		t.Errorf("Wanted no evicted nodes within grace period, got %v", evicted)
+	}
+
+	// The returned node comes back, which removes its tombstone. Marking
+	// again does not move the deleted node's tombstone.
+	latest["returned"] = nodeInfo{addresses: addr}
+	m.markDeleted(latest, tombstones, start.Add(grace/2))
+	if _, ok := tombstones["returned"]; ok {
+		t.Errorf("Node returned should not have a tombstone")
+	}
+	if got := tombstones["deleted"]; !got.Equal(start) {
+		t.Errorf("Node deleted: wanted tombstone at %v, got %v", start, got)
+	}
+
+	// Once the grace period has passed, only the deleted node is pruned.
+	evicted := m.prune(latest, tombstones, start.Add(grace), grace)
+	if want := []string{"deleted"}; !slices.Equal(evicted, want) {
+		t.Errorf("Wrong evicted nodes, got %v, want %v", evicted, want)
+	}
+	var remaining []string
+	for id := range m {
+		remaining = append(remaining, id)
+	}
+	slices.Sort(remaining)
+	if want := []string{"current", "local", "returned"}; !slices.Equal(remaining, want) {
+		t.Errorf("Wrong remaining nodes, got %v, want %v", remaining, want)
+	}
+	if len(tombstones) != 0 {
+		t.Errorf("Wanted no tombstones left, got %v", tombstones)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
 
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
@@ -75,6 +76,10 @@ type Service struct {
 
 	// Status is the current status of the network as seen by the service.
 	Status memory.Value[*Status]
+	// statusMu guards status.
+	statusMu sync.Mutex
+	// status is the last value published to Status.
+	status Status
 }
 
 // New instantiates a new network service. If autoconfiguration is desired,
@@ -95,7 +100,15 @@ func New(staticConfig *netpb.Net) *Service {
 // changes might occur, consumers should ensure that the change that occured is
 // meaningful to them.
 type Status struct {
+	// ExternalAddress is the node's IPv4 address, either obtained via DHCP or
+	// statically configured, or nil if the node has none (yet).
 	ExternalAddress net.IP
+	// Links is the state of all network interfaces of the host, keyed by
+	// interface name.
+	Links map[string]LinkStatus
+	// DefaultRoute is the IPv4 default route with the lowest metric, or nil if
+	// there is none.
+	DefaultRoute *Route
 }
 
 // ConfigureDNS sets a DNS ExtraDirective on the built-in DNS server of the
@@ -157,8 +170,8 @@ func (s *Service) statusCallback(ctx context.Context) dhcp4c.LeaseCallback {
 		if !newAddress.Equal(s.dhcpAddress) {
 			s.dhcpAddress = newAddress
 			// Notify status waiters.
-			s.Status.Set(&Status{
-				ExternalAddress: newAddress,
+			s.updateStatus(func(st *Status) {
+				st.ExternalAddress = newAddress
 			})
 			if newAddress != nil {
 				supervisor.Logger(ctx).Infof("New DHCP address: %s", newAddress)
@@ -220,6 +233,7 @@ func (s *Service) Run(ctx context.Context) error {
 	}
 
 	supervisor.Run(ctx, "announce", s.runNeighborAnnounce)
+	supervisor.Run(ctx, "status", s.runStatusMonitor)
 
 	// Choose between autoconfig and static config runnables
 	if s.StaticConfig == nil {
@@ -34,6 +34,13 @@ var vlanProtoMap = map[netpb.VLAN_Protocol]netlink.VlanProtocol{
 func (s *Service) runStaticConfig(ctx context.Context) error {
 	l := supervisor.Logger(ctx)
 	var success bool
+	warnings, err := validateStaticConfig(s.StaticConfig)
+	if err != nil {
+		return fmt.Errorf("invalid static network configuration: %w", err)
+	}
+	for _, w := range warnings {
+		l.Warning(w)
+	}
 	sortedInterfaces, err := getSortedIfaces(s)
 	if err != nil {
 		return err
@@ -162,6 +169,8 @@ func (s *Service) runStaticConfig(ctx context.Context) error {
 	}
 	if len(nsIPList) > 0 {
 		s.ConfigureDNS(dns.NewUpstreamDirective(nsIPList))
+	} else if !hasIPv4Autoconfig {
+		l.Warning("No nameservers configured and DHCPv4 not in use, name resolution will not work")
 	}
 
 	if !hasIPv4Autoconfig {
@@ -183,8 +192,9 @@ func (s *Service) runStaticConfig(ctx context.Context) error {
 				}
 			}
 		}
-		s.Status.Set(&Status{
-			ExternalAddress: selectedAddr,
+		l.Infof("Using static address %s", selectedAddr)
+		s.updateStatus(func(st *Status) {
+			st.ExternalAddress = selectedAddr
 		})
 	}
 
@@ -194,6 +204,43 @@ func (s *Service) runStaticConfig(ctx context.Context) error {
 	return nil
 }
 
+// validateStaticConfig checks that a static network configuration provides a
+// source for the node's external IPv4 address, ie. that at least one
+// interface gets an IPv4 address either via DHCPv4 or statically. Interfaces
+// which combine both are valid (eg. a permanent secondary address next to a
+// DHCPv4 lease), but the external address is then always taken from DHCPv4.
+// These are returned as warnings.
+func validateStaticConfig(c *netpb.Net) (warnings []string, err error) {
+	var hasIPv4 bool
+	for _, i := range c.Interface {
+		var hasStaticIPv4 bool
+		for _, a := range i.Address {
+			ipNet, err := addressOrPrefix(a)
+			if err != nil {
+				return nil, fmt.Errorf("interface %q: invalid address %q: %w", i.Name, a, err)
+			}
+			if ipNet.IP.To4() != nil {
+				hasStaticIPv4 = true
+			}
+		}
+		if hasStaticIPv4 && i.Ipv4Autoconfig != nil {
+			warnings = append(warnings, fmt.Sprintf("interface %q: both DHCPv4 and static IPv4 addresses configured, the external address will be taken from DHCPv4", i.Name))
+		}
+		if hasStaticIPv4 || i.Ipv4Autoconfig != nil {
+			hasIPv4 = true
+		}
+	}
+	if !hasIPv4 {
+		return nil, errors.New("no interface has either DHCPv4 or a static IPv4 address configured")
+	}
+	for _, ns := range c.Nameserver {
+		if net.ParseIP(ns.Ip) == nil {
+			return nil, fmt.Errorf("invalid nameserver IP %q", ns.Ip)
+		}
+	}
+	return warnings, nil
+}
+
 func (s *Service) runDHCPv4(ctx context.Context, lnk netlink.Link) error {
 	c, err := dhcp4c.NewClient(netlinkLinkToNetInterface(lnk))
 	if err != nil {
@@ -0,0 +1,80 @@
+package network
+
+import (
+	"testing"
+
+	netpb "source.monogon.dev/net/proto"
+)
+
+func TestValidateStaticConfig(t *testing.T) {
+	for _, te := range []struct {
+		name         string
+		config       *netpb.Net
+		wantErr      bool
+		wantWarnings int
+	}{
+		{
+			name: "DHCPv4",
+			config: &netpb.Net{Interface: []*netpb.Interface{
+				{Name: "eth0", Ipv4Autoconfig: &netpb.IPv4Autoconfig{}},
+			}},
+		},
+		{
+			name: "Static",
+			config: &netpb.Net{
+				Interface: []*netpb.Interface{
+					{Name: "eth0", Address: []string{"10.0.0.2/24", "fd00::2/64"}},
+				},
+				Nameserver: []*netpb.Nameserver{{Ip: "10.0.0.1"}},
+			},
+		},
+		{
+			// As dumped by the installer for a NIC with a permanent
+			// secondary address next to a DHCPv4 lease.
+			name: "DHCPv4AndStatic",
+			config: &netpb.Net{Interface: []*netpb.Interface{
+				{Name: "eth0", Address: []string{"10.0.0.2/24"}, Ipv4Autoconfig: &netpb.IPv4Autoconfig{}},
+			}},
+			wantWarnings: 1,
+		},
+		{
+			name: "OnlyIPv6",
+			config: &netpb.Net{Interface: []*netpb.Interface{
+				{Name: "eth0", Address: []string{"fd00::2/64"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "NoInterfaces",
+			config:  &netpb.Net{},
+			wantErr: true,
+		},
+		{
+			name: "InvalidAddress",
+			config: &netpb.Net{Interface: []*netpb.Interface{
+				{Name: "eth0", Address: []string{"10.0.0.256/24"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "InvalidNameserver",
+			config: &netpb.Net{
+				Interface: []*netpb.Interface{
+					{Name: "eth0", Ipv4Autoconfig: &netpb.IPv4Autoconfig{}},
+				},
+				Nameserver: []*netpb.Nameserver{{Ip: "invalid"}},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			warnings, err := validateStaticConfig(te.config)
+			if (err != nil) != te.wantErr {
+				t.Fatalf("wanted error %v, got %v", te.wantErr, err)
+			}
+			if len(warnings) != te.wantWarnings {
+				t.Errorf("wanted %d warnings, got %q", te.wantWarnings, warnings)
+			}
+		})
+	}
+}
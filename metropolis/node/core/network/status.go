@@ -0,0 +1,158 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"source.monogon.dev/osbase/supervisor"
+)
+
+// LinkStatus is the state of a network interface as seen by the kernel.
+type LinkStatus struct {
+	// Up is true if the interface is administratively up.
+	Up bool
+	// Running is true if the interface is operational, ie. has a carrier.
+	Running bool
+}
+
+// Route is a route as seen by the kernel.
+type Route struct {
+	// Gateway is the IP address of the next hop, or nil if the route is an
+	// interface route.
+	Gateway net.IP
+	// Interface is the name of the interface the route goes out of.
+	Interface string
+}
+
+// updateStatus applies fn to a copy of the current status and publishes the
+// result to Status.
+func (s *Service) updateStatus(fn func(st *Status)) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	st := s.status
+	st.Links = maps.Clone(s.status.Links)
+	fn(&st)
+	s.status = st
+	s.Status.Set(&st)
+}
+
+// runStatusMonitor keeps the interface states and the default route in Status
+// up to date by subscribing to netlink link and route updates.
+func (s *Service) runStatusMonitor(ctx context.Context) error {
+	l := supervisor.Logger(ctx)
+
+	linkUpdates := make(chan netlink.LinkUpdate, 10)
+	if err := netlink.LinkSubscribeWithOptions(linkUpdates, ctx.Done(), netlink.LinkSubscribeOptions{
+		ListExisting: true,
+	}); err != nil {
+		return fmt.Errorf("while subscribing to netlink link updates: %w", err)
+	}
+	routeUpdates := make(chan netlink.RouteUpdate, 10)
+	if err := netlink.RouteSubscribeWithOptions(routeUpdates, ctx.Done(), netlink.RouteSubscribeOptions{
+		ListExisting: true,
+	}); err != nil {
+		return fmt.Errorf("while subscribing to netlink route updates: %w", err)
+	}
+
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	links := make(map[string]LinkStatus)
+	var defaultRoute *Route
+	for {
+		select {
+		case u, ok := <-linkUpdates:
+			if !ok {
+				return fmt.Errorf("netlink link subscription closed")
+			}
+			attrs := u.Link.Attrs()
+			before, existed := links[attrs.Name]
+			if u.Header.Type == unix.RTM_DELLINK {
+				if !existed {
+					continue
+				}
+				delete(links, attrs.Name)
+				l.Infof("Interface %q removed", attrs.Name)
+			} else {
+				now := LinkStatus{
+					Up:      attrs.Flags&net.FlagUp != 0,
+					Running: attrs.RawFlags&unix.IFF_RUNNING != 0,
+				}
+				if existed && before == now {
+					continue
+				}
+				links[attrs.Name] = now
+			}
+			s.updateStatus(func(st *Status) {
+				st.Links = maps.Clone(links)
+			})
+		case _, ok := <-routeUpdates:
+			if !ok {
+				return fmt.Errorf("netlink route subscription closed")
+			}
+			route, err := getDefaultRoute()
+			if err != nil {
+				l.Warningf("Failed to get default route: %v", err)
+				continue
+			}
+			if route.equal(defaultRoute) {
+				continue
+			}
+			defaultRoute = route
+			if route != nil {
+				l.Infof("Default route is now via %s on %q", route.Gateway, route.Interface)
+			} else {
+				l.Warning("Lost default route")
+			}
+			s.updateStatus(func(st *Status) {
+				st.DefaultRoute = route
+			})
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// getDefaultRoute returns the IPv4 default route of the main routing table
+// with the lowest metric, or nil if there is none.
+func getDefaultRoute() (*Route, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("while listing routes: %w", err)
+	}
+	var best *netlink.Route
+	for i, r := range routes {
+		if r.Dst != nil {
+			if ones, _ := r.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		if best == nil || r.Priority < best.Priority {
+			best = &routes[i]
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	link, err := netlink.LinkByIndex(best.LinkIndex)
+	if err != nil {
+		return nil, fmt.Errorf("while getting interface of default route: %w", err)
+	}
+	return &Route{
+		Gateway:   best.Gw,
+		Interface: link.Attrs().Name,
+	}, nil
+}
+
+// equal returns true if both routes are nil or equal.
+func (r *Route) equal(o *Route) bool {
+	if r == nil || o == nil {
+		return r == o
+	}
+	return r.Gateway.Equal(o.Gateway) && r.Interface == o.Interface
+}
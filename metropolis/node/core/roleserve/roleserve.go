@@ -121,10 +121,12 @@ func New(c Config) *Service {
 		curatorConnection:     &s.CuratorConnection,
 		localControlPlane:     &s.localControlPlane,
 		clusterDirectorySaved: &s.clusterDirectorySaved,
+		dataUsage:             &s.StorageRoot.Data.Usage,
 	}
 
 	s.heartbeat = &workerHeartbeat{
-		network: s.Network,
+		network:     s.Network,
+		storageRoot: s.StorageRoot,
 
 		curatorConnection: &s.CuratorConnection,
 	}
@@ -152,6 +154,7 @@ func New(c Config) *Service {
 		curatorConnection: &s.CuratorConnection,
 		logTree:           s.LogTree,
 		updateService:     s.Update,
+		storageRoot:       s.StorageRoot,
 	}
 
 	s.clusternet = &workerClusternet{
@@ -0,0 +1,169 @@
+package roleserve
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	"source.monogon.dev/metropolis/node/core/localstorage"
+)
+
+// utilizationSampler samples the node's resource utilization for inclusion in
+// heartbeats. CPU utilization is computed between consecutive samples, so the
+// first sample does not contain it.
+type utilizationSampler struct {
+	storageRoot *localstorage.Root
+
+	// prev is the CPU time counters of the previous sample, or nil if there was
+	// none.
+	prev *cpuTimes
+}
+
+// sample fills the utilization fields of req. Resources which cannot be
+// sampled are left unset, and the resulting errors are returned.
+func (u *utilizationSampler) sample(req *ipb.HeartbeatUpdateRequest) []error {
+	var errs []error
+
+	if cur, err := readCPUTimes(); err != nil {
+		errs = append(errs, fmt.Errorf("cpu: %w", err))
+	} else {
+		if u.prev != nil {
+			if v, ok := cur.usageSince(u.prev); ok {
+				req.CpuUsage = &v
+			}
+		}
+		u.prev = cur
+	}
+
+	if v, err := readMemoryUsage(); err != nil {
+		errs = append(errs, fmt.Errorf("memory: %w", err))
+	} else {
+		req.MemoryUsage = &v
+	}
+
+	if u.storageRoot != nil {
+		st, err := u.storageRoot.Data.Status()
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("disk: %w", err))
+		case st.BytesTotal > 0:
+			v := 1 - float64(st.BytesAvailable)/float64(st.BytesTotal)
+			req.DiskUsage = &v
+		}
+	}
+	return errs
+}
+
+// cpuTimes are the aggregate CPU time counters of all CPUs, in USER_HZ.
+type cpuTimes struct {
+	// busy is the time spent not idling or waiting for I/O.
+	busy uint64
+	// total is the time spent in any state.
+	total uint64
+}
+
+// usageSince returns the fraction of time the CPUs were busy between prev and
+// c. False is returned if no time passed between them.
+func (c *cpuTimes) usageSince(prev *cpuTimes) (float64, bool) {
+	if c.total <= prev.total || c.busy < prev.busy {
+		return 0, false
+	}
+	v := float64(c.busy-prev.busy) / float64(c.total-prev.total)
+	if v > 1 {
+		v = 1
+	}
+	return v, true
+}
+
+func readCPUTimes() (*cpuTimes, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCPUTimes(f)
+}
+
+// parseCPUTimes parses the aggregate cpu line of /proc/stat.
+func parseCPUTimes(r io.Reader) (*cpuTimes, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+		// user nice system idle iowait irq softirq steal [guest guest_nice].
+		// Guest time is already accounted in user and nice.
+		if len(fields) < 9 {
+			return nil, fmt.Errorf("cpu line has %d fields, expected at least 9", len(fields))
+		}
+		var res cpuTimes
+		for i, f := range fields[1:9] {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid counter %q: %w", f, err)
+			}
+			res.total += v
+			// Skip idle and iowait.
+			if i != 3 && i != 4 {
+				res.busy += v
+			}
+		}
+		return &res, nil
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no cpu line found")
+}
+
+func readMemoryUsage() (float64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseMemoryUsage(f)
+}
+
+// parseMemoryUsage returns the fraction of memory not available for new
+// allocations, based on the MemTotal and MemAvailable fields of /proc/meminfo.
+func parseMemoryUsage(r io.Reader) (float64, error) {
+	var total, available uint64
+	var haveTotal, haveAvailable bool
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		var dst *uint64
+		switch fields[0] {
+		case "MemTotal:":
+			dst, haveTotal = &total, true
+		case "MemAvailable:":
+			dst, haveAvailable = &available, true
+		default:
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s value %q: %w", fields[0], fields[1], err)
+		}
+		*dst = v
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	if !haveTotal || !haveAvailable {
+		return 0, fmt.Errorf("MemTotal or MemAvailable missing")
+	}
+	if total == 0 || available > total {
+		return 0, fmt.Errorf("invalid MemTotal %d / MemAvailable %d", total, available)
+	}
+	return 1 - float64(available)/float64(total), nil
+}
@@ -0,0 +1,54 @@
+package roleserve
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCPUTimes(t *testing.T) {
+	stat := `cpu  100 10 50 800 40 0 0 0 20 0
+cpu0 50 5 25 400 20 0 0 0 10 0
+intr 12345
+`
+	ct, err := parseCPUTimes(strings.NewReader(stat))
+	if err != nil {
+		t.Fatalf("parseCPUTimes: %v", err)
+	}
+	if want, got := uint64(1000), ct.total; want != got {
+		t.Errorf("total: wanted %d, got %d", want, got)
+	}
+	if want, got := uint64(160), ct.busy; want != got {
+		t.Errorf("busy: wanted %d, got %d", want, got)
+	}
+
+	next := &cpuTimes{busy: ct.busy + 50, total: ct.total + 200}
+	if v, ok := next.usageSince(ct); !ok || v != 0.25 {
+		t.Errorf("usageSince: wanted 0.25, got %v (%v)", v, ok)
+	}
+	if _, ok := ct.usageSince(ct); ok {
+		t.Errorf("usageSince with no time passed should fail")
+	}
+
+	if _, err := parseCPUTimes(strings.NewReader("intr 12345\n")); err == nil {
+		t.Errorf("parseCPUTimes without cpu line should fail")
+	}
+}
+
+func TestParseMemoryUsage(t *testing.T) {
+	meminfo := `MemTotal:        1000 kB
+MemFree:          100 kB
+MemAvailable:     250 kB
+Buffers:           10 kB
+`
+	v, err := parseMemoryUsage(strings.NewReader(meminfo))
+	if err != nil {
+		t.Fatalf("parseMemoryUsage: %v", err)
+	}
+	if v != 0.75 {
+		t.Errorf("Wanted 0.75, got %v", v)
+	}
+
+	if _, err := parseMemoryUsage(strings.NewReader("MemTotal: 1000 kB\n")); err == nil {
+		t.Errorf("parseMemoryUsage without MemAvailable should fail")
+	}
+}
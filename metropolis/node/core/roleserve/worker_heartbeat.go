@@ -8,15 +8,17 @@ import (
 
 	"source.monogon.dev/metropolis/node/core/curator"
 	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/network"
 	"source.monogon.dev/osbase/event/memory"
 	"source.monogon.dev/osbase/supervisor"
 )
 
 // workerHeartbeat is a service that periodically updates node's heartbeat
-// timestamps within the cluster.
+// timestamps within the cluster, along with the node's resource utilization.
 type workerHeartbeat struct {
-	network *network.Service
+	network     *network.Service
+	storageRoot *localstorage.Root
 
 	// curatorConnection will be read.
 	curatorConnection *memory.Value[*curatorConnection]
@@ -41,8 +43,16 @@ func (s *workerHeartbeat) run(ctx context.Context) error {
 		return err
 	}
 
+	sampler := utilizationSampler{storageRoot: s.storageRoot}
+	loggedErrs := false
 	for {
-		if err := stream.Send(&ipb.HeartbeatUpdateRequest{}); err != nil {
+		req := &ipb.HeartbeatUpdateRequest{}
+		// Sampling errors are only logged once, as they are likely to persist.
+		if errs := sampler.sample(req); len(errs) > 0 && !loggedErrs {
+			supervisor.Logger(ctx).Warningf("Could not sample utilization: %v", errs)
+			loggedErrs = true
+		}
+		if err := stream.Send(req); err != nil {
 			return fmt.Errorf("while sending a heartbeat: %v", err)
 		}
 		next := time.Now().Add(curator.HeartbeatTimeout)
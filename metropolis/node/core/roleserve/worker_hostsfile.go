@@ -2,6 +2,7 @@ package roleserve
 
 import (
 	"context"
+	"time"
 
 	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/network"
@@ -10,6 +11,7 @@ import (
 	"source.monogon.dev/osbase/supervisor"
 
 	ipb "source.monogon.dev/metropolis/node/core/curator/proto/api"
+	apb "source.monogon.dev/metropolis/proto/api"
 )
 
 // workerHostsfile run the //metropolis/node/core/network/hostsfile service,
@@ -39,6 +41,20 @@ func (s *workerHostsfile) run(ctx context.Context) error {
 
 	cur := ipb.NewCuratorClient(cc.conn)
 
+	// Retrieve the stale node grace period from the cluster configuration. This
+	// is not fatal, as the service also has to run (eg. to set the hostname)
+	// while the cluster is unreachable. Stale nodes are then just not pruned.
+	var grace time.Duration
+	ictx, ictxC := context.WithTimeout(ctx, 10*time.Second)
+	info, err := apb.NewManagementClient(cc.conn).GetClusterInfo(ictx, &apb.GetClusterInfoRequest{})
+	ictxC()
+	if err != nil {
+		supervisor.Logger(ctx).Warningf("Could not retrieve cluster configuration, not pruning stale nodes: %v", err)
+	} else {
+		nd := info.ClusterConfiguration.GetNodeDirectory()
+		grace = time.Duration(nd.GetStaleNodeGracePeriodSeconds()) * time.Second
+	}
+
 	svc := hostsfile.Service{
 		Config: hostsfile.Config{
 			Network:               &s.network.Status,
@@ -47,6 +63,7 @@ func (s *workerHostsfile) run(ctx context.Context) error {
 			NodeID:                cc.nodeID(),
 			Curator:               cur,
 			ClusterDirectorySaved: s.clusterDirectorySaved,
+			StaleNodeGracePeriod:  grace,
 		},
 	}
 
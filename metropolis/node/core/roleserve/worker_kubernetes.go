@@ -53,8 +53,12 @@ type kubernetesStartup struct {
 func (k *kubernetesStartup) workerChanged(o *kubernetesStartup) bool {
 	hasKubernetesA := k.roles.KubernetesWorker != nil
 	hasKubernetesB := o.roles.KubernetesWorker != nil
-
-	return hasKubernetesA != hasKubernetesB
+	if hasKubernetesA != hasKubernetesB {
+		return true
+	}
+	// The volume limit is only reported to Kubernetes when the CSI plugin
+	// registers, so changing it requires a restart.
+	return k.roles.KubernetesWorker.GetMaxVolumes() != o.roles.KubernetesWorker.GetMaxVolumes()
 }
 
 func (k *kubernetesStartup) controllerChanged(o *kubernetesStartup) bool {
@@ -229,6 +233,8 @@ func (s *workerKubernetes) run(ctx context.Context) error {
 			NodeID:        d.node.ID(),
 			CuratorClient: d.curator,
 			PodNetwork:    s.podNetwork,
+
+			MaxVolumesPerNode: int64(d.roles.KubernetesWorker.MaxVolumes),
 		})
 		// Start Kubernetes.
 		if err := supervisor.Run(ctx, "run", worker.Run); err != nil {
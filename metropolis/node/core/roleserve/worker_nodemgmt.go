@@ -3,6 +3,7 @@ package roleserve
 import (
 	"context"
 
+	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/mgmt"
 	"source.monogon.dev/metropolis/node/core/update"
 	"source.monogon.dev/osbase/event/memory"
@@ -14,6 +15,7 @@ type workerNodeMgmt struct {
 	curatorConnection *memory.Value[*curatorConnection]
 	logTree           *logtree.LogTree
 	updateService     *update.Service
+	storageRoot       *localstorage.Root
 }
 
 func (s *workerNodeMgmt) run(ctx context.Context) error {
@@ -30,6 +32,7 @@ func (s *workerNodeMgmt) run(ctx context.Context) error {
 		NodeCredentials: cc.credentials,
 		LogTree:         s.logTree,
 		UpdateService:   s.updateService,
+		Data:            &s.storageRoot.Data,
 	}
 	return srv.Run(ctx)
 }
@@ -3,10 +3,17 @@ package roleserve
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 
 	common "source.monogon.dev/metropolis/node"
+	"source.monogon.dev/metropolis/node/core/localstorage"
 	"source.monogon.dev/metropolis/node/core/network"
 	"source.monogon.dev/metropolis/version"
 	"source.monogon.dev/osbase/event"
@@ -28,6 +35,8 @@ type workerStatusPush struct {
 	curatorConnection *memory.Value[*curatorConnection]
 	// clusterDirectorySaved will be read.
 	clusterDirectorySaved *memory.Value[bool]
+	// dataUsage will be read.
+	dataUsage *memory.Value[*localstorage.DataStatus]
 }
 
 // workerStatusPushChannels contain all the channels between the status pusher's
@@ -37,28 +46,47 @@ type workerStatusPushChannels struct {
 	address           chan string
 	localControlPlane chan *localControlPlane
 	curatorConnection chan *curatorConnection
+	// uname of the running kernel. Retrieved once at startup.
+	uname chan *cpb.NodeStatus_Uname
+	// dataPressure of the data partition. Retrieved from its usage.
+	dataPressure chan bool
 }
 
+// statusPushMaxRetryTime is the maximum time for which a failing
+// UpdateNodeStatus call is retried before workerStatusPushLoop gives up.
+const statusPushMaxRetryTime = time.Minute
+
 // workerStatusPushLoop runs the main loop acting on data received from
 // workerStatusPushChannels.
 func workerStatusPushLoop(ctx context.Context, chans *workerStatusPushChannels) error {
-	status := cpb.NodeStatus{
+	nodeStatus := cpb.NodeStatus{
 		Version: version.Version,
 	}
 	var cur ipb.CuratorClient
 	var nodeID string
 
-	for {
-		changed := false
+	// changed is set when the status has changed since it was last successfully
+	// submitted. It is kept across failed submissions, so that any changes are
+	// submitted once the retry succeeds.
+	changed := false
+	// retryC is non-nil when a failed submission is waiting to be retried. While
+	// it is, any further changes are accumulated and submitted on retry.
+	var retryC <-chan time.Time
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxElapsedTime = statusPushMaxRetryTime
 
+	for {
 		select {
 		case <-ctx.Done():
 			return fmt.Errorf("while waiting for map updates: %w", ctx.Err())
 
+		case <-retryC:
+			retryC = nil
+
 		case address := <-chans.address:
-			if address != status.ExternalAddress {
+			if address != nodeStatus.ExternalAddress {
 				supervisor.Logger(ctx).Infof("Got external address: %s", address)
-				status.ExternalAddress = address
+				nodeStatus.ExternalAddress = address
 				changed = true
 			}
 
@@ -75,40 +103,91 @@ func workerStatusPushLoop(ctx context.Context, chans *workerStatusPushChannels)
 				changed = true
 			}
 
+		case uname := <-chans.uname:
+			if !proto.Equal(uname, nodeStatus.Uname) {
+				supervisor.Logger(ctx).Infof("Got uname: %s %s", uname.Sysname, uname.Release)
+				nodeStatus.Uname = uname
+				changed = true
+			}
+
+		case pressure := <-chans.dataPressure:
+			if pressure != nodeStatus.DataPressure {
+				supervisor.Logger(ctx).Infof("Got data partition pressure: %v", pressure)
+				nodeStatus.DataPressure = pressure
+				changed = true
+			}
+
 		case lcp := <-chans.localControlPlane:
-			if status.RunningCurator == nil && lcp.exists() {
+			if nodeStatus.RunningCurator == nil && lcp.exists() {
 				supervisor.Logger(ctx).Infof("Got new local curator state: running")
-				status.RunningCurator = &cpb.NodeStatus_RunningCurator{
+				nodeStatus.RunningCurator = &cpb.NodeStatus_RunningCurator{
 					Port: int32(common.CuratorServicePort),
 				}
 				changed = true
 			}
-			if status.RunningCurator != nil && !lcp.exists() {
+			if nodeStatus.RunningCurator != nil && !lcp.exists() {
 				supervisor.Logger(ctx).Infof("Got new local curator state: not running")
-				status.RunningCurator = nil
+				nodeStatus.RunningCurator = nil
 				changed = true
 			}
 		}
 
-		if cur != nil && nodeID != "" && changed && status.ExternalAddress != "" {
-			txt, _ := prototext.Marshal(&status)
+		if cur != nil && nodeID != "" && changed && nodeStatus.ExternalAddress != "" && retryC == nil {
+			txt, _ := prototext.Marshal(&nodeStatus)
 			supervisor.Logger(ctx).Infof("Submitting status: %q", txt)
 			_, err := cur.UpdateNodeStatus(ctx, &ipb.UpdateNodeStatusRequest{
 				NodeId: nodeID,
-				Status: &status,
+				Status: &nodeStatus,
 			})
-			if err != nil {
+			switch {
+			case err == nil:
+				changed = false
+				bo.Reset()
+			case !statusPushRetriable(err):
 				return fmt.Errorf("UpdateNodeStatus failed: %w", err)
+			default:
+				next := bo.NextBackOff()
+				if next == backoff.Stop {
+					return fmt.Errorf("UpdateNodeStatus failed, giving up after %s: %w", statusPushMaxRetryTime, err)
+				}
+				supervisor.Logger(ctx).Warningf("UpdateNodeStatus failed, retrying in %s: %v", next, err)
+				retryC = time.After(next)
 			}
 		}
 	}
 }
 
+// statusPushRetriable returns whether an UpdateNodeStatus error is transient,
+// ie. whether the call should be retried.
+func statusPushRetriable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// getUname returns information about the running kernel.
+func getUname() (*cpb.NodeStatus_Uname, error) {
+	var u unix.Utsname
+	if err := unix.Uname(&u); err != nil {
+		return nil, err
+	}
+	return &cpb.NodeStatus_Uname{
+		Sysname: unix.ByteSliceToString(u.Sysname[:]),
+		Release: unix.ByteSliceToString(u.Release[:]),
+		Version: unix.ByteSliceToString(u.Version[:]),
+		Machine: unix.ByteSliceToString(u.Machine[:]),
+	}, nil
+}
+
 func (s *workerStatusPush) run(ctx context.Context) error {
 	chans := workerStatusPushChannels{
 		address:           make(chan string),
 		curatorConnection: make(chan *curatorConnection),
 		localControlPlane: make(chan *localControlPlane),
+		uname:             make(chan *cpb.NodeStatus_Uname),
+		dataPressure:      make(chan bool),
 	}
 
 	// All the channel sends in the map runnables are preemptible by a context
@@ -136,6 +215,37 @@ func (s *workerStatusPush) run(ctx context.Context) error {
 			}
 		}
 	})
+	supervisor.Run(ctx, "map-uname", func(ctx context.Context) error {
+		uname, err := getUname()
+		if err != nil {
+			return fmt.Errorf("uname failed: %w", err)
+		}
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		select {
+		case chans.uname <- uname:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	})
+	supervisor.Run(ctx, "map-data-usage", func(ctx context.Context) error {
+		w := s.dataUsage.Watch()
+		defer w.Close()
+
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		for {
+			st, err := w.Get(ctx)
+			if err != nil {
+				return fmt.Errorf("getting data partition usage failed: %w", err)
+			}
+			select {
+			case chans.dataPressure <- st.Pressure():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
 	supervisor.Run(ctx, "pipe-local-control-plane", event.Pipe[*localControlPlane](s.localControlPlane, chans.localControlPlane))
 	supervisor.Run(ctx, "pipe-curator-connection", event.Pipe[*curatorConnection](s.curatorConnection, chans.curatorConnection))
 
@@ -2,6 +2,7 @@ package roleserve
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -11,8 +12,11 @@ import (
 	"github.com/cenkalti/backoff/v4"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 
 	common "source.monogon.dev/metropolis/node"
@@ -33,11 +37,19 @@ type statusRecordingCurator struct {
 
 	mu            sync.Mutex
 	statusReports []*ipb.UpdateNodeStatusRequest
+	// failures is the number of upcoming UpdateNodeStatus calls which will fail
+	// with failureCode without being recorded.
+	failures    int
+	failureCode codes.Code
 }
 
 func (f *statusRecordingCurator) UpdateNodeStatus(ctx context.Context, req *ipb.UpdateNodeStatusRequest) (*ipb.UpdateNodeStatusResponse, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures -= 1
+		return nil, status.Error(f.failureCode, "injected failure")
+	}
 	f.statusReports = append(f.statusReports, req)
 	return &ipb.UpdateNodeStatusResponse{}, nil
 }
@@ -70,6 +82,8 @@ func TestWorkerStatusPush(t *testing.T) {
 		address:           make(chan string),
 		localControlPlane: make(chan *localControlPlane),
 		curatorConnection: make(chan *curatorConnection),
+		uname:             make(chan *cpb.NodeStatus_Uname),
+		dataPressure:      make(chan bool),
 	}
 
 	go supervisor.TestHarness(t, func(ctx context.Context) error {
@@ -163,4 +177,160 @@ func TestWorkerStatusPush(t *testing.T) {
 			Version:         mversion.Version,
 		}},
 	})
+
+	// Uname should be submitted, but only once if unchanged.
+	uname := &cpb.NodeStatus_Uname{
+		Sysname: "Linux",
+		Release: "6.6.36-metropolis",
+		Version: "#1 SMP",
+		Machine: "x86_64",
+	}
+	chans.uname <- uname
+	chans.uname <- proto.Clone(uname).(*cpb.NodeStatus_Uname)
+	cur.expectReports(t, []*ipb.UpdateNodeStatusRequest{
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.10",
+			Version:         mversion.Version,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			Version:         mversion.Version,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			RunningCurator: &cpb.NodeStatus_RunningCurator{
+				Port: int32(common.CuratorServicePort),
+			},
+			Version: mversion.Version,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			Version:         mversion.Version,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			Version:         mversion.Version,
+			Uname:           uname,
+		}},
+	})
+
+	// Data partition pressure should only be submitted when it changes.
+	chans.dataPressure <- false
+	chans.dataPressure <- true
+	chans.dataPressure <- true
+	cur.expectReports(t, []*ipb.UpdateNodeStatusRequest{
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.10",
+			Version:         mversion.Version,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			Version:         mversion.Version,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			RunningCurator: &cpb.NodeStatus_RunningCurator{
+				Port: int32(common.CuratorServicePort),
+			},
+			Version: mversion.Version,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			Version:         mversion.Version,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			Version:         mversion.Version,
+			Uname:           uname,
+		}},
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.11",
+			Version:         mversion.Version,
+			Uname:           uname,
+			DataPressure:    true,
+		}},
+	})
+}
+
+// TestWorkerStatusPushRetry ensures that the status push worker main loop
+// retries transient UpdateNodeStatus failures without losing status changes,
+// and gives up on non-transient failures.
+func TestWorkerStatusPushRetry(t *testing.T) {
+	chans := workerStatusPushChannels{
+		address:           make(chan string),
+		localControlPlane: make(chan *localControlPlane),
+		curatorConnection: make(chan *curatorConnection),
+	}
+
+	loopErr := make(chan error, 1)
+	go supervisor.TestHarness(t, func(ctx context.Context) error {
+		supervisor.Signal(ctx, supervisor.SignalHealthy)
+		err := workerStatusPushLoop(ctx, &chans)
+		loopErr <- err
+		supervisor.Signal(ctx, supervisor.SignalDone)
+		return nil
+	})
+
+	cur := &statusRecordingCurator{
+		failures:    2,
+		failureCode: codes.Unavailable,
+	}
+	srv := grpc.NewServer()
+	defer srv.Stop()
+	ipb.RegisterCuratorServer(srv, cur)
+	lis := bufconn.Listen(1024 * 1024)
+	defer lis.Close()
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			t.Errorf("GRPC serve failed: %v", err)
+			return
+		}
+	}()
+	withLocalDialer := grpc.WithContextDialer(func(_ context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	})
+	cl, err := grpc.Dial("local", withLocalDialer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer cl.Close()
+
+	eph := util.NewEphemeralClusterCredentials(t, 1)
+	nodeID := eph.Nodes[0].ID()
+
+	chans.curatorConnection <- &curatorConnection{
+		credentials: eph.Nodes[0],
+		conn:        cl,
+	}
+	// The first submission fails, and a change made while waiting for the retry
+	// must be included in the eventually successful submission.
+	chans.address <- "192.0.2.10"
+	chans.localControlPlane <- &localControlPlane{
+		curator:   &curator.Service{},
+		consensus: &consensus.Service{},
+	}
+	cur.expectReports(t, []*ipb.UpdateNodeStatusRequest{
+		{NodeId: nodeID, Status: &cpb.NodeStatus{
+			ExternalAddress: "192.0.2.10",
+			RunningCurator: &cpb.NodeStatus_RunningCurator{
+				Port: int32(common.CuratorServicePort),
+			},
+			Version: mversion.Version,
+		}},
+	})
+
+	// Non-transient failures should end the loop.
+	cur.mu.Lock()
+	cur.failures = 1
+	cur.failureCode = codes.PermissionDenied
+	cur.mu.Unlock()
+	chans.address <- "192.0.2.11"
+	select {
+	case err := <-loopErr:
+		if status.Code(errors.Unwrap(err)) != codes.PermissionDenied {
+			t.Errorf("wanted PermissionDenied error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("loop did not fail on non-transient error")
+	}
 }
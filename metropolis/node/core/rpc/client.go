@@ -211,6 +211,37 @@ func NewAuthenticatedCredentials(cert tls.Certificate, opts ...CredentialsOpt) c
 //
 // The retrieved certificate can be used to dial further cluster RPCs.
 func RetrieveOwnerCertificate(ctx context.Context, aaa apb.AAAClient, private ed25519.PrivateKey) (*tls.Certificate, error) {
+	cert, err := escrowOwner(ctx, aaa, &apb.EscrowFromClient_Parameters{
+		RequestedIdentityName: "owner",
+		PublicKey:             private.Public().(ed25519.PublicKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{cert},
+		PrivateKey:  private,
+	}, nil
+}
+
+// RetrieveReadOnlyCertificate uses AAA.Escrow to retrieve a read-only cluster
+// manager certificate for the given public key. The connection used by aaa must
+// be authenticated in the same way as for RetrieveOwnerCertificate.
+//
+// The retrieved certificate, together with the private key corresponding to
+// the given public key, can be used to dial further cluster RPCs which do not
+// mutate the cluster.
+func RetrieveReadOnlyCertificate(ctx context.Context, aaa apb.AAAClient, public ed25519.PublicKey) ([]byte, error) {
+	return escrowOwner(ctx, aaa, &apb.EscrowFromClient_Parameters{
+		RequestedIdentityName: "owner",
+		PublicKey:             public,
+		ReadOnly:              true,
+	})
+}
+
+// escrowOwner runs AAA.Escrow with the given parameters, expecting the
+// cluster to issue a certificate without requesting further proofs.
+func escrowOwner(ctx context.Context, aaa apb.AAAClient, params *apb.EscrowFromClient_Parameters) ([]byte, error) {
 	srv, err := aaa.Escrow(ctx)
 	if err != nil {
 		if st, ok := status.FromError(err); ok {
@@ -219,10 +250,7 @@ func RetrieveOwnerCertificate(ctx context.Context, aaa apb.AAAClient, private ed
 		return nil, err
 	}
 	if err := srv.Send(&apb.EscrowFromClient{
-		Parameters: &apb.EscrowFromClient_Parameters{
-			RequestedIdentityName: "owner",
-			PublicKey:             private.Public().(ed25519.PublicKey),
-		},
+		Parameters: params,
 	}); err != nil {
 		return nil, fmt.Errorf("when sending client parameters: %w", err)
 	}
@@ -233,9 +261,5 @@ func RetrieveOwnerCertificate(ctx context.Context, aaa apb.AAAClient, private ed
 	if len(resp.EmittedCertificate) == 0 {
 		return nil, fmt.Errorf("expected certificate, instead got needed proofs: %+v", resp.Needed)
 	}
-
-	return &tls.Certificate{
-		Certificate: [][]byte{resp.EmittedCertificate},
-		PrivateKey:  private,
-	}, nil
+	return resp.EmittedCertificate, nil
 }
@@ -64,6 +64,9 @@ type PeerInfoUser struct {
 	// Identity is an opaque identifier for the user. MVP: Currently this is always
 	// "manager".
 	Identity string
+	// ReadOnly is set if the user presented read-only credentials, in which case
+	// it is only permitted to call RPCs which do not mutate the cluster.
+	ReadOnly bool
 }
 
 type PeerInfoUnauthenticated struct {
@@ -100,7 +103,15 @@ func (p *PeerInfo) CheckPermissions(need Permissions) error {
 		}
 		return nil
 	} else if p.User != nil {
-		// MVP: all permissions are granted to all users.
+		if p.User.ReadOnly {
+			for n, v := range need {
+				if v && !readOnlyUserPermissions[n] {
+					return status.Errorf(codes.PermissionDenied, "read-only user missing %s permission", n.String())
+				}
+			}
+			return nil
+		}
+		// MVP: all permissions are granted to all other users.
 		// TODO(q3k): check authz.Need once we have a user/identity system implemented.
 		return nil
 	} else if p.Node != nil {
@@ -123,6 +134,9 @@ func (p *PeerInfo) String() string {
 	case p.Node != nil:
 		return fmt.Sprintf("node: %s, %s", identity.NodeID(p.Node.PublicKey), p.Node.Permissions)
 	case p.User != nil:
+		if p.User.ReadOnly {
+			return fmt.Sprintf("user: %s (read-only)", p.User.Identity)
+		}
 		return fmt.Sprintf("user: %s", p.User.Identity)
 	case p.Unauthenticated != nil:
 		return fmt.Sprintf("unauthenticated: pubkey %s", hex.EncodeToString(p.Unauthenticated.SelfSignedPublicKey))
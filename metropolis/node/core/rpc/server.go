@@ -12,4 +12,14 @@ var (
 		epb.Permission_PERMISSION_READ_CLUSTER_STATUS: true,
 		epb.Permission_PERMISSION_UPDATE_NODE_SELF:    true,
 	}
+
+	// readOnlyUserPermissions are the set of
+	// metropolis.common.ext.authorization permissions given to users with
+	// read-only credentials (see identity.ReadOnlyUserCertificate). None of
+	// these permissions allow mutating the cluster.
+	readOnlyUserPermissions = Permissions{
+		epb.Permission_PERMISSION_READ_CLUSTER_STATUS: true,
+		epb.Permission_PERMISSION_READ_NODE_LOGS:      true,
+		epb.Permission_PERMISSION_READ_NODE_STORAGE:   true,
+	}
 )
@@ -182,6 +182,7 @@ func (s *ServerSecurity) getPeerInfo(ctx context.Context) (*PeerInfo, error) {
 		return &PeerInfo{
 			User: &PeerInfoUser{
 				Identity: userid,
+				ReadOnly: identity.UserIsReadOnly(cert),
 			},
 		}, nil
 	}
@@ -76,6 +76,29 @@ func TestExternalServerSecurity(t *testing.T) {
 		t.Errorf("GetRegisterTicket returned %v, wanted codes.Unimplemented", err)
 	}
 
+	// Authenticate as read-only manager externally, ensure that GetClusterInfo
+	// runs, but GetRegisterTicket and ApproveNode are refused.
+	cl, err = grpc.Dial("local",
+		grpc.WithTransportCredentials(NewAuthenticatedCredentials(eph.ReadOnlyManager, WantRemoteCluster(eph.CA))),
+		withLocalDialer)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer cl.Close()
+	mgmt = apb.NewManagementClient(cl)
+	_, err = mgmt.GetClusterInfo(ctx, &apb.GetClusterInfoRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.Unimplemented {
+		t.Errorf("GetClusterInfo (by read-only manager) returned %v, wanted codes.Unimplemented", err)
+	}
+	_, err = mgmt.GetRegisterTicket(ctx, &apb.GetRegisterTicketRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.PermissionDenied {
+		t.Errorf("GetRegisterTicket (by read-only manager) returned %v, wanted codes.PermissionDenied", err)
+	}
+	_, err = mgmt.ApproveNode(ctx, &apb.ApproveNodeRequest{})
+	if s, ok := status.FromError(err); !ok || s.Code() != codes.PermissionDenied {
+		t.Errorf("ApproveNode (by read-only manager) returned %v, wanted codes.PermissionDenied", err)
+	}
+
 	// Authenticate as node externally, ensure that GetRegisterTicket is refused
 	// (this is because nodes miss the GET_REGISTER_TICKET permissions).
 	cl, err = grpc.Dial("local",
@@ -0,0 +1,158 @@
+package time
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// This file implements a minimal client for chrony's command protocol (as used
+// by chronyc), which is only able to retrieve the tracking report.
+
+const (
+	// chronyCommandAddress is the address on which chrony serves monitoring
+	// commands. The Unix command socket is disabled via bindcmdaddress, but
+	// chrony still listens on its default command port on localhost, where
+	// monitoring commands like tracking are permitted.
+	chronyCommandAddress = "127.0.0.1:323"
+	// chronyQueryTimeout is the maximum time to wait for a reply from chrony.
+	chronyQueryTimeout = 2 * time.Second
+
+	chronyProtocolVersion    = 6
+	chronyPacketTypeRequest  = 1
+	chronyPacketTypeReply    = 2
+	chronyRequestTracking    = 33
+	chronyReplyTracking      = 5
+	chronyStatusSuccess      = 0
+	chronyLeapUnsynchronised = 3
+
+	// chronyRequestLength is the length of a tracking request. chrony drops
+	// requests which are shorter than their reply to prevent traffic
+	// amplification, so requests are padded to the maximum request length.
+	chronyRequestLength = 416
+	// chronyReplyHeaderLength is the length of the header of every reply.
+	chronyReplyHeaderLength = 28
+	// chronyTrackingLength is the length of the payload of a tracking reply.
+	chronyTrackingLength = 76
+)
+
+// chronyTracking is the subset of chrony's tracking report used by the time
+// service.
+type chronyTracking struct {
+	// stratum is the NTP stratum of the local clock, or zero if it is not
+	// synchronized.
+	stratum uint16
+	// leapStatus is the leap status of the local clock, which is
+	// chronyLeapUnsynchronised if it is not synchronized.
+	leapStatus uint16
+	// refTime is the time at which the clock was last updated from a
+	// reference, or zero if it never was.
+	refTime time.Time
+	// correction is the estimated correction chrony is applying to the system
+	// clock, ie. NTP time minus system time.
+	correction time.Duration
+}
+
+// queryChronyTracking retrieves the tracking report from the locally running
+// chrony.
+func queryChronyTracking(ctx context.Context) (*chronyTracking, error) {
+	ctx, ctxC := context.WithTimeout(ctx, chronyQueryTimeout)
+	defer ctxC()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", chronyCommandAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial chrony: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	seq := rand.Uint32()
+	if _, err := conn.Write(chronyTrackingRequest(seq)); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive reply: %w", err)
+	}
+	return parseChronyTracking(buf[:n], seq)
+}
+
+// chronyTrackingRequest returns a tracking request with the given sequence
+// number.
+func chronyTrackingRequest(seq uint32) []byte {
+	req := make([]byte, chronyRequestLength)
+	req[0] = chronyProtocolVersion
+	req[1] = chronyPacketTypeRequest
+	binary.BigEndian.PutUint16(req[4:], chronyRequestTracking)
+	binary.BigEndian.PutUint32(req[8:], seq)
+	return req
+}
+
+// parseChronyTracking parses a reply to the tracking request with the given
+// sequence number.
+func parseChronyTracking(b []byte, seq uint32) (*chronyTracking, error) {
+	if len(b) < chronyReplyHeaderLength {
+		return nil, fmt.Errorf("reply too short (%d bytes)", len(b))
+	}
+	if b[0] != chronyProtocolVersion || b[1] != chronyPacketTypeReply {
+		return nil, fmt.Errorf("unexpected reply version %d, type %d", b[0], b[1])
+	}
+	if st := binary.BigEndian.Uint16(b[8:]); st != chronyStatusSuccess {
+		return nil, fmt.Errorf("chrony returned status %d", st)
+	}
+	if rpy := binary.BigEndian.Uint16(b[6:]); rpy != chronyReplyTracking {
+		return nil, fmt.Errorf("unexpected reply %d", rpy)
+	}
+	if got := binary.BigEndian.Uint32(b[16:]); got != seq {
+		return nil, fmt.Errorf("unexpected sequence number %d, wanted %d", got, seq)
+	}
+	b = b[chronyReplyHeaderLength:]
+	if len(b) < chronyTrackingLength {
+		return nil, fmt.Errorf("tracking reply too short (%d bytes)", len(b))
+	}
+
+	// Layout: ref_id (4), ip_addr (20), stratum (2), leap_status (2), ref_time
+	// (12), followed by the current correction and further floats.
+	t := &chronyTracking{
+		stratum:    binary.BigEndian.Uint16(b[24:]),
+		leapStatus: binary.BigEndian.Uint16(b[26:]),
+	}
+	secHigh := uint64(binary.BigEndian.Uint32(b[28:]))
+	secLow := uint64(binary.BigEndian.Uint32(b[32:]))
+	nsec := int64(binary.BigEndian.Uint32(b[36:]))
+	// 0x7fffffff marks the high bits as unused.
+	if secHigh == 0x7fffffff {
+		secHigh = 0
+	}
+	if sec := secHigh<<32 | secLow; sec != 0 || nsec != 0 {
+		t.refTime = time.Unix(int64(sec), nsec)
+	}
+	correction := chronyFloat(binary.BigEndian.Uint32(b[40:]))
+	t.correction = time.Duration(correction * float64(time.Second))
+	return t, nil
+}
+
+// chronyFloat decodes chrony's network representation of floating point
+// numbers: a 7-bit signed exponent followed by a 25-bit signed coefficient.
+func chronyFloat(x uint32) float64 {
+	const expBits, coefBits = 7, 25
+	exp := int32(x >> coefBits)
+	if exp >= 1<<(expBits-1) {
+		exp -= 1 << expBits
+	}
+	exp -= coefBits
+	coef := int32(x % (1 << coefBits))
+	if coef >= 1<<(coefBits-1) {
+		coef -= 1 << coefBits
+	}
+	return float64(coef) * math.Pow(2, float64(exp))
+}
@@ -0,0 +1,108 @@
+package time
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestChronyFloat(t *testing.T) {
+	for _, te := range []struct {
+		x    uint32
+		want float64
+	}{
+		{0, 0},
+		// 3 * 2^-1
+		{24<<25 | 3, 1.5},
+		// -1 * 2^-2
+		{23<<25 | 0x1ffffff, -0.25},
+		// 1 * 2^-40, with a negative exponent field.
+		{113<<25 | 1, 1.0 / (1 << 40)},
+	} {
+		if got := chronyFloat(te.x); got != te.want {
+			t.Errorf("chronyFloat(%#x): wanted %v, got %v", te.x, te.want, got)
+		}
+	}
+}
+
+// makeTrackingReply returns a tracking reply as sent by chrony.
+func makeTrackingReply(seq uint32, stratum, leap uint16, refTime time.Time, correction uint32) []byte {
+	b := make([]byte, chronyReplyHeaderLength+chronyTrackingLength)
+	b[0] = chronyProtocolVersion
+	b[1] = chronyPacketTypeReply
+	binary.BigEndian.PutUint16(b[4:], chronyRequestTracking)
+	binary.BigEndian.PutUint16(b[6:], chronyReplyTracking)
+	binary.BigEndian.PutUint32(b[16:], seq)
+	p := b[chronyReplyHeaderLength:]
+	binary.BigEndian.PutUint16(p[24:], stratum)
+	binary.BigEndian.PutUint16(p[26:], leap)
+	if !refTime.IsZero() {
+		binary.BigEndian.PutUint32(p[28:], uint32(refTime.Unix()>>32))
+		binary.BigEndian.PutUint32(p[32:], uint32(refTime.Unix()))
+		binary.BigEndian.PutUint32(p[36:], uint32(refTime.Nanosecond()))
+	}
+	binary.BigEndian.PutUint32(p[40:], correction)
+	return b
+}
+
+func TestParseChronyTracking(t *testing.T) {
+	refTime := time.Unix(1700000000, 500)
+	// -0.25s correction, ie. the clock is 250ms ahead.
+	b := makeTrackingReply(42, 3, 0, refTime, 23<<25|0x1ffffff)
+	tr, err := parseChronyTracking(b, 42)
+	if err != nil {
+		t.Fatalf("parseChronyTracking: %v", err)
+	}
+	if tr.stratum != 3 {
+		t.Errorf("wanted stratum 3, got %d", tr.stratum)
+	}
+	if !tr.refTime.Equal(refTime) {
+		t.Errorf("wanted reference time %s, got %s", refTime, tr.refTime)
+	}
+	st := statusFromTracking(tr)
+	if !st.Synchronized {
+		t.Errorf("wanted clock to be synchronized")
+	}
+	if want := 250 * time.Millisecond; st.Offset != want {
+		t.Errorf("wanted offset %s, got %s", want, st.Offset)
+	}
+
+	// Unsynchronized clock.
+	tr, err = parseChronyTracking(makeTrackingReply(1, 0, chronyLeapUnsynchronised, time.Time{}, 0), 1)
+	if err != nil {
+		t.Fatalf("parseChronyTracking: %v", err)
+	}
+	st = statusFromTracking(tr)
+	if st.Synchronized || st.Stratum != 0 || !st.LastSync.IsZero() {
+		t.Errorf("wanted unsynchronized status, got %+v", st)
+	}
+
+	// Invalid replies.
+	if _, err := parseChronyTracking(b, 43); err == nil {
+		t.Errorf("wanted error on sequence number mismatch")
+	}
+	if _, err := parseChronyTracking(b[:chronyReplyHeaderLength+10], 42); err == nil {
+		t.Errorf("wanted error on truncated reply")
+	}
+	failed := makeTrackingReply(42, 3, 0, refTime, 0)
+	binary.BigEndian.PutUint16(failed[8:], 3)
+	if _, err := parseChronyTracking(failed, 42); err == nil {
+		t.Errorf("wanted error on unsuccessful status")
+	}
+}
+
+func TestChronyTrackingRequest(t *testing.T) {
+	req := chronyTrackingRequest(42)
+	if len(req) != chronyRequestLength {
+		t.Errorf("wanted request of %d bytes, got %d", chronyRequestLength, len(req))
+	}
+	if req[0] != chronyProtocolVersion || req[1] != chronyPacketTypeRequest {
+		t.Errorf("unexpected version/type %d/%d", req[0], req[1])
+	}
+	if got := binary.BigEndian.Uint16(req[4:]); got != chronyRequestTracking {
+		t.Errorf("wanted command %d, got %d", chronyRequestTracking, got)
+	}
+	if got := binary.BigEndian.Uint32(req[8:]); got != 42 {
+		t.Errorf("wanted sequence 42, got %d", got)
+	}
+}
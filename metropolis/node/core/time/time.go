@@ -4,7 +4,9 @@
 // timestamping, validating certain certificates, ...) as well as workloads
 // running on top of it expecting accurate time.
 // This initial implementation is very minimalistic, running just a stateless
-// NTP client per node for the whole lifecycle of it.
+// NTP client per node for the whole lifecycle of it. The resulting clock
+// synchronization state is queried from the NTP client and published as
+// Status.
 // This implementation is simple, but is fairly unsafe as NTP by itself does
 // not offer any cryptography, so it's easy to tamper with the responses.
 // See #73 for further work in that direction.
@@ -12,28 +14,114 @@ package time
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"source.monogon.dev/metropolis/node"
+	"source.monogon.dev/osbase/event"
+	"source.monogon.dev/osbase/event/memory"
 	"source.monogon.dev/osbase/fileargs"
 	"source.monogon.dev/osbase/supervisor"
 )
 
+const (
+	// defaultInitialSyncTimeout is the time after which the service becomes
+	// healthy even if the clock has not yet been synchronized.
+	defaultInitialSyncTimeout = 2 * time.Minute
+	// pollInterval is the interval in which the synchronization state is
+	// retrieved from chrony.
+	pollInterval = 10 * time.Second
+)
+
 // Service implements the time service. See package documentation for further
 // information.
-type Service struct{}
+type Service struct {
+	// Servers are the NTP servers to synchronize against. If empty, the
+	// Monogon NTP pool is used.
+	Servers []string
+	// InitialSyncTimeout is the maximum time the service waits for the clock
+	// to get synchronized before signaling healthy regardless. Defaults to two
+	// minutes if zero.
+	InitialSyncTimeout time.Duration
+
+	// Status is the current clock synchronization state of the node, updated
+	// periodically while the service is running.
+	Status memory.Value[*Status]
+}
+
+// Status is the clock synchronization state of the node as reported by the
+// NTP client.
+type Status struct {
+	// Synchronized is true if the clock is currently synchronized.
+	Synchronized bool
+	// Offset is the estimated offset of the system clock from NTP time,
+	// positive if the system clock is ahead. The NTP client is slewing the
+	// clock to correct it.
+	Offset time.Duration
+	// Stratum is the NTP stratum of the node's clock, ie. the distance to the
+	// reference clock of its NTP servers plus one, or zero if it is not
+	// synchronized.
+	Stratum int
+	// LastSync is the time at which the clock was last updated from an NTP
+	// server, or zero if it has not been synchronized yet.
+	LastSync time.Time
+}
 
 func New() *Service {
 	return &Service{}
 }
 
+// Run starts the NTP client and waits for the initial clock synchronization
+// (or InitialSyncTimeout) before signaling healthy.
 func (s *Service) Run(ctx context.Context) error {
+	if err := supervisor.Run(ctx, "chrony", s.runChrony); err != nil {
+		return fmt.Errorf("when starting chrony: %w", err)
+	}
+	if err := supervisor.Run(ctx, "monitor", s.runMonitor); err != nil {
+		return fmt.Errorf("when starting monitor: %w", err)
+	}
+
+	timeout := s.InitialSyncTimeout
+	if timeout == 0 {
+		timeout = defaultInitialSyncTimeout
+	}
+	w := s.Status.Watch()
+	defer w.Close()
+	ctxT, ctxC := context.WithTimeout(ctx, timeout)
+	defer ctxC()
+	_, err := w.Get(ctxT, event.Filter(func(st *Status) bool {
+		return st.Synchronized
+	}))
+	switch {
+	case err == nil:
+		supervisor.Logger(ctx).Info("Clock synchronized")
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, context.DeadlineExceeded):
+		supervisor.Logger(ctx).Warningf("Clock not synchronized after %s, continuing anyway", timeout)
+	default:
+		return fmt.Errorf("when waiting for clock synchronization: %w", err)
+	}
+
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+	supervisor.Signal(ctx, supervisor.SignalDone)
+	return nil
+}
+
+func (s *Service) runChrony(ctx context.Context) error {
 	// TODO(#72): Apply for a NTP pool vendor zone
-	config := strings.Join([]string{
-		"pool ntp.monogon.dev iburst",
+	sources := []string{"pool ntp.monogon.dev iburst"}
+	if len(s.Servers) > 0 {
+		sources = nil
+		for _, server := range s.Servers {
+			sources = append(sources, fmt.Sprintf("server %s iburst", server))
+		}
+	}
+	config := strings.Join(append(sources,
 		"bindcmdaddress /",
 		"stratumweight 0.01",
 		"leapsecmode slew",
@@ -41,7 +129,7 @@ func (s *Service) Run(ctx context.Context) error {
 		"makestep 2.0 3",
 		"rtconutc",
 		"rtcsync",
-	}, "\n")
+	), "\n")
 	args, err := fileargs.New()
 	if err != nil {
 		return fmt.Errorf("cannot create fileargs: %w", err)
@@ -58,3 +146,49 @@ func (s *Service) Run(ctx context.Context) error {
 	cmd.Stderr = supervisor.RawLogger(ctx)
 	return supervisor.RunCommand(ctx, cmd)
 }
+
+// runMonitor periodically publishes the clock synchronization state as
+// reported by chrony to Status.
+func (s *Service) runMonitor(ctx context.Context) error {
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	var wasSynchronized bool
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		tr, err := queryChronyTracking(ctx)
+		if err != nil {
+			// chrony might not be up yet or be restarting, try again later.
+			supervisor.Logger(ctx).Warningf("Failed to query chrony: %v", err)
+		} else {
+			st := statusFromTracking(tr)
+			if st.Synchronized != wasSynchronized {
+				if st.Synchronized {
+					supervisor.Logger(ctx).Infof("Clock synchronized, stratum %d, offset %s", st.Stratum, st.Offset)
+				} else {
+					supervisor.Logger(ctx).Warningf("Clock lost synchronization")
+				}
+				wasSynchronized = st.Synchronized
+			}
+			s.Status.Set(st)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// statusFromTracking converts a tracking report of chrony to Status.
+func statusFromTracking(tr *chronyTracking) *Status {
+	return &Status{
+		Synchronized: tr.leapStatus != chronyLeapUnsynchronised,
+		// chrony reports the correction it is applying, which is the inverse
+		// of the offset.
+		Offset:   -tr.correction,
+		Stratum:  int(tr.stratum),
+		LastSync: tr.refTime,
+	}
+}
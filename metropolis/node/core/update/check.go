@@ -0,0 +1,306 @@
+package update
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	mversion "source.monogon.dev/metropolis/version"
+	"source.monogon.dev/version"
+	"source.monogon.dev/version/spec"
+)
+
+// CheckResult is the outcome of a single pre-flight check performed against
+// an update bundle.
+type CheckResult struct {
+	// Name is a short, stable identifier of the check, eg. "architecture".
+	Name string
+	// Passed is true if the bundle passed this check.
+	Passed bool
+	// Detail is a human-readable explanation of the outcome.
+	Detail string
+}
+
+// CheckReport is the result of CheckBundle.
+type CheckReport struct {
+	// TargetSlot is the slot into which the bundle would be installed, or
+	// SlotInvalid if it could not be determined.
+	TargetSlot Slot
+	// Checks performed, in order of execution.
+	Checks []CheckResult
+}
+
+// OK returns true if all checks in the report passed.
+func (r *CheckReport) OK() bool {
+	for _, c := range r.Checks {
+		if !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *CheckReport) add(name string, err error, passDetail string) bool {
+	if err != nil {
+		r.Checks = append(r.Checks, CheckResult{Name: name, Detail: err.Error()})
+		return false
+	}
+	r.Checks = append(r.Checks, CheckResult{Name: name, Passed: true, Detail: passDetail})
+	return true
+}
+
+// peMachine maps GOARCH values to the PE machine type of EFI payloads able to
+// run on them.
+var peMachine = map[string]uint16{
+	"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+	"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+}
+
+// checkEnv describes the node a bundle is checked against.
+type checkEnv struct {
+	// arch is the GOARCH of the node.
+	arch string
+	// release is the release of the running node.
+	release *spec.Version_Release
+	// systemSpace is the size of the system partition of the target slot in
+	// bytes, or systemSpaceErr is set if it could not be determined.
+	systemSpace    uint64
+	systemSpaceErr error
+	// espSpace is the space available on the ESP for the EFI payload of the
+	// target slot in bytes, or espSpaceErr is set if it could not be
+	// determined.
+	espSpace    uint64
+	espSpaceErr error
+}
+
+// CheckBundle downloads the bundle at the given HTTP(S) URL and validates that
+// it could be installed by InstallBundle on this node, without writing to
+// any slot or to the ESP.
+//
+// An error is only returned if the check itself could not be performed. A
+// bundle failing validation is reported through the returned CheckReport.
+func (s *Service) CheckBundle(ctx context.Context, bundleURL string) (*CheckReport, error) {
+	if s.ESPPath == "" {
+		return nil, errors.New("no ESP information provided to update service, cannot continue")
+	}
+	var report CheckReport
+
+	env := checkEnv{
+		arch:    runtime.GOARCH,
+		release: mversion.Version.Release,
+	}
+	activeSlot := s.CurrentlyRunningSlot()
+	if activeSlot == SlotInvalid {
+		report.add("slot", errors.New("unable to determine active slot"), "")
+		env.systemSpaceErr = errors.New("target slot unknown")
+		env.espSpaceErr = env.systemSpaceErr
+	} else {
+		report.TargetSlot = activeSlot.Other()
+		report.add("slot", nil, fmt.Sprintf("running from slot %s, would install into slot %s", activeSlot, report.TargetSlot))
+		env.systemSpace, env.systemSpaceErr = systemSlotSize(report.TargetSlot)
+		env.espSpace, env.espSpaceErr = s.espSpace(report.TargetSlot)
+	}
+
+	s.checkBundle(ctx, &report, bundleURL, &env)
+	return &report, nil
+}
+
+// checkBundle performs all checks of the bundle at the given URL against the
+// given node and adds their results to the report.
+func (s *Service) checkBundle(ctx context.Context, report *CheckReport, bundleURL string, env *checkEnv) {
+	bundleRaw, err := s.fetchBundle(ctx, bundleURL)
+	if !report.add("download", err, "bundle downloaded") {
+		return
+	}
+	if !report.add("signature", s.verifyBundle(ctx, bundleURL, bundleRaw), s.signatureDetail()) {
+		return
+	}
+	bundle, err := openBundle(bundleRaw)
+	if !report.add("open", err, "bundle opened") {
+		return
+	}
+
+	efiPayload, efiErr := bundleFile(bundle, "kernel_efi.efi")
+	systemImage, systemErr := bundleFile(bundle, "verity_rootfs.img")
+	contentsErr := errors.Join(efiErr, systemErr)
+	if !report.add("contents", contentsErr, "bundle contains EFI payload and system image") {
+		return
+	}
+
+	payload, err := readEFIPayload(efiPayload)
+	if !report.add("efi-payload", err, "EFI payload is a valid unified kernel image") {
+		return
+	}
+	report.add("architecture", checkArchitecture(payload, env.arch), fmt.Sprintf("EFI payload is bootable on %s", env.arch))
+	report.add("version", checkVersion(payload, env.release), fmt.Sprintf("bundle is not older than running version %s", version.Release(env.release)))
+
+	sizeErr := env.systemSpaceErr
+	if sizeErr == nil {
+		sizeErr = checkSize("system image", systemImage, env.systemSpace)
+	}
+	report.add("system-size", sizeErr, "system image fits into target slot")
+	espErr := env.espSpaceErr
+	if espErr == nil {
+		espErr = checkSize("EFI payload", efiPayload, env.espSpace)
+	}
+	report.add("esp-space", espErr, "EFI payload fits onto the ESP")
+}
+
+// signatureDetail describes how a bundle passing signature verification was
+// verified.
+func (s *Service) signatureDetail() string {
+	if key, err := s.bundleKey(); err == nil && key == nil {
+		return "no bundle key configured, unsigned bundles allowed"
+	}
+	return "bundle signature is valid"
+}
+
+// bundleFile returns the file with the given name from the bundle.
+func bundleFile(bundle *zip.Reader, name string) (*zip.File, error) {
+	for _, f := range bundle.File {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("bundle does not contain %s", name)
+}
+
+// readEFIPayload parses the given EFI payload as a PE file and ensures that it
+// contains the sections required by the A/B loader and by kexec staging.
+func readEFIPayload(f *zip.File) (*pe.File, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("while opening EFI payload: %w", err)
+	}
+	defer rc.Close()
+	// debug/pe requires an io.ReaderAt, which zip entries do not provide.
+	raw, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("while reading EFI payload: %w", err)
+	}
+	payload, err := pe.NewFile(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("EFI payload is not a valid PE file: %w", err)
+	}
+	for _, section := range []string{".linux", ".cmdline", ".osrel"} {
+		if payload.Section(section) == nil {
+			return nil, fmt.Errorf("EFI payload has no %s section", section)
+		}
+	}
+	return payload, nil
+}
+
+// checkArchitecture ensures that the given EFI payload is built for the given
+// GOARCH.
+func checkArchitecture(payload *pe.File, arch string) error {
+	want, ok := peMachine[arch]
+	if !ok {
+		return fmt.Errorf("unsupported node architecture %s", arch)
+	}
+	if payload.Machine != want {
+		return fmt.Errorf("EFI payload machine type is 0x%x, node requires 0x%x", payload.Machine, want)
+	}
+	return nil
+}
+
+// releaseRegexp matches the release part of a version as stamped into the
+// os-release file, eg. v0.1.0 in v0.1.0-dev12.g01234567.
+var releaseRegexp = regexp.MustCompile(`^v([0-9]+)\.([0-9]+)\.([0-9]+)`)
+
+// checkVersion ensures that the release of the given EFI payload, as contained
+// in its os-release section, is not older than the running release.
+// Prereleases of the same release are not ordered and thus always pass.
+func checkVersion(payload *pe.File, running *spec.Version_Release) error {
+	osrel, err := payload.Section(".osrel").Data()
+	if err != nil {
+		return fmt.Errorf("while reading os-release: %w", err)
+	}
+	versionID := parseOSRelease(osrel)["VERSION_ID"]
+	m := releaseRegexp.FindStringSubmatch(versionID)
+	if m == nil {
+		return fmt.Errorf("bundle has invalid version %q", versionID)
+	}
+	var release spec.Version_Release
+	for i, p := range []*int64{&release.Major, &release.Minor, &release.Patch} {
+		*p, err = strconv.ParseInt(m[i+1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bundle has invalid version %q: %w", versionID, err)
+		}
+	}
+	if running == nil {
+		return errors.New("running version unknown")
+	}
+	if version.ReleaseLessThan(&release, running) {
+		return fmt.Errorf("bundle version %s is older than running version %s", versionID, version.Release(running))
+	}
+	return nil
+}
+
+// parseOSRelease parses the contents of an os-release file into a map of its
+// variables.
+func parseOSRelease(raw []byte) map[string]string {
+	res := make(map[string]string)
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, "'")
+		}
+		res[key] = value
+	}
+	return res
+}
+
+// checkSize ensures that the given bundle file fits into the given number of
+// bytes.
+func checkSize(what string, f *zip.File, available uint64) error {
+	if f.UncompressedSize64 > available {
+		return fmt.Errorf("%s is %d bytes, but only %d bytes are available", what, f.UncompressedSize64, available)
+	}
+	return nil
+}
+
+// systemSlotSize returns the size of the system partition of the given slot.
+func systemSlotSize(target Slot) (uint64, error) {
+	systemPart, err := openSystemSlot(target)
+	if err != nil {
+		return 0, fmt.Errorf("inactive system slot unavailable: %w", err)
+	}
+	defer systemPart.Close()
+	return uint64(systemPart.BlockCount() * systemPart.BlockSize()), nil
+}
+
+// espSpace returns the space available on the ESP for the EFI payload of
+// the target slot, taking into account that its current boot file gets
+// replaced.
+func (s *Service) espSpace(target Slot) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(s.ESPPath, &st); err != nil {
+		return 0, fmt.Errorf("while getting ESP filesystem statistics: %w", err)
+	}
+	available := st.Bavail * uint64(st.Bsize)
+	if fi, err := os.Stat(filepath.Join(s.ESPPath, target.EFIBootPath())); err == nil {
+		available += uint64(fi.Size())
+	}
+	return available, nil
+}
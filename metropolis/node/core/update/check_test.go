@@ -0,0 +1,157 @@
+package update
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"debug/pe"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"source.monogon.dev/osbase/logtree"
+	"source.monogon.dev/version/spec"
+)
+
+// makeEFIPayload returns a minimal PE file for the given machine type with the
+// sections of a unified kernel image, containing an os-release with the given
+// version.
+func makeEFIPayload(t *testing.T, machine uint16, version string) []byte {
+	t.Helper()
+	sections := []struct {
+		name string
+		data []byte
+	}{
+		{".osrel", []byte("NAME=\"Metropolis Node\"\nVERSION_ID=\"" + version + "\"\n")},
+		{".cmdline", []byte("console=ttyS0")},
+		{".linux", []byte("kernel")},
+	}
+	const dosHeaderSize = 64
+	var buf bytes.Buffer
+	dosHeader := make([]byte, dosHeaderSize)
+	copy(dosHeader, "MZ")
+	binary.LittleEndian.PutUint32(dosHeader[0x3c:], dosHeaderSize)
+	buf.Write(dosHeader)
+	buf.WriteString("PE\x00\x00")
+	binary.Write(&buf, binary.LittleEndian, pe.FileHeader{
+		Machine:          machine,
+		NumberOfSections: uint16(len(sections)),
+	})
+	offset := uint32(buf.Len() + len(sections)*binary.Size(pe.SectionHeader32{}))
+	for _, s := range sections {
+		sh := pe.SectionHeader32{
+			VirtualSize:      uint32(len(s.data)),
+			SizeOfRawData:    uint32(len(s.data)),
+			PointerToRawData: offset,
+		}
+		copy(sh.Name[:], s.name)
+		binary.Write(&buf, binary.LittleEndian, sh)
+		offset += uint32(len(s.data))
+	}
+	for _, s := range sections {
+		buf.Write(s.data)
+	}
+	return buf.Bytes()
+}
+
+// makeFullBundle returns a bundle containing the given EFI payload and a
+// system image of the given size.
+func makeFullBundle(t *testing.T, efiPayload []byte, systemSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{
+		"kernel_efi.efi":    efiPayload,
+		"verity_rootfs.img": make([]byte, systemSize),
+	} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckBundle(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amd64 := makeEFIPayload(t, pe.IMAGE_FILE_MACHINE_AMD64, "v0.2.0-dev3.g01234567")
+	current := makeFullBundle(t, amd64, 1024)
+	older := makeFullBundle(t, makeEFIPayload(t, pe.IMAGE_FILE_MACHINE_AMD64, "v0.1.5"), 1024)
+
+	mux := http.NewServeMux()
+	serve := func(path string, data []byte) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		})
+	}
+	serve("/current.zip", current)
+	serve("/current.zip.sig", ed25519.Sign(priv, current))
+	serve("/older.zip", older)
+	serve("/older.zip.sig", ed25519.Sign(priv, older))
+	serve("/badsig.zip", current)
+	serve("/badsig.zip.sig", ed25519.Sign(otherPriv, current))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	s := &Service{
+		Logger:    logtree.New().MustLeveledFor("update"),
+		BundleKey: pub,
+	}
+	env := checkEnv{
+		arch:        "amd64",
+		release:     &spec.Version_Release{Major: 0, Minor: 2, Patch: 0},
+		systemSpace: 4096,
+		espSpace:    4096,
+	}
+
+	for _, te := range []struct {
+		name       string
+		bundle     string
+		env        func(e *checkEnv)
+		wantFailed []string
+	}{
+		{"Valid", "/current.zip", nil, nil},
+		{"ArchitectureMismatch", "/current.zip", func(e *checkEnv) { e.arch = "arm64" }, []string{"architecture"}},
+		{"Downgrade", "/older.zip", nil, []string{"version"}},
+		{"BadSignature", "/badsig.zip", nil, []string{"signature"}},
+		{"TooLargeForSlot", "/current.zip", func(e *checkEnv) { e.systemSpace = 512 }, []string{"system-size"}},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			e := env
+			if te.env != nil {
+				te.env(&e)
+			}
+			var report CheckReport
+			s.checkBundle(context.Background(), &report, srv.URL+te.bundle, &e)
+			var failed []string
+			for _, c := range report.Checks {
+				if !c.Passed {
+					failed = append(failed, c.Name)
+				}
+			}
+			if !slices.Equal(failed, te.wantFailed) {
+				t.Errorf("wanted failed checks %v, got %v (report: %+v)", te.wantFailed, failed, report.Checks)
+			}
+			if report.OK() != (len(te.wantFailed) == 0) {
+				t.Errorf("wanted OK() to be %v", len(te.wantFailed) == 0)
+			}
+		})
+	}
+}
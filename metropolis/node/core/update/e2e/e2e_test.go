@@ -25,6 +25,9 @@ import (
 
 const Mi = 1024 * 1024
 
+// maxBootAttempts mirrors MAX_BOOT_ATTEMPTS in the A/B loader.
+const maxBootAttempts = 3
+
 var variantRegexp = regexp.MustCompile(`TESTOS_VARIANT=([A-Z])`)
 
 func stdoutHandler(t *testing.T, cmd *exec.Cmd, cancel context.CancelFunc, testosStarted chan string) {
@@ -147,6 +150,11 @@ func setup(t *testing.T) (*bundleServing, []string) {
 		t.Fatal(err)
 	}
 	b.bundlePaths["Z"] = bundleZPath
+	bundleFPath, err := runfiles.Rlocation("_main/metropolis/node/core/update/e2e/testos/testos_bundle_f.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.bundlePaths["F"] = bundleFPath
 	m.HandleFunc("/bundle.bin", func(w http.ResponseWriter, req *http.Request) {
 		b.m.Lock()
 		bundleFilePath := b.bundleFilePath
@@ -272,6 +280,26 @@ func TestABUpdateSequenceReboot(t *testing.T) {
 	runAndCheckVariant(t, "Z", qemuArgs)
 }
 
+func TestABUpdateRollback(t *testing.T) {
+	bsrv, qemuArgs := setup(t)
+
+	t.Log("Launching X image to install broken F")
+	bsrv.setNextBundle("F")
+	runAndCheckVariant(t, "X", qemuArgs)
+
+	for i := 0; i < maxBootAttempts; i++ {
+		t.Logf("Launching F on slot B without marking it successful (attempt %d)", i+1)
+		runAndCheckVariant(t, "F", qemuArgs)
+	}
+
+	t.Log("Launching X on slot A after rollback to install Z on slot B")
+	bsrv.setNextBundle("Z")
+	runAndCheckVariant(t, "X", qemuArgs)
+
+	t.Log("Launching Z on slot B")
+	runAndCheckVariant(t, "Z", qemuArgs)
+}
+
 func TestABUpdateSequenceKexec(t *testing.T) {
 	bsrv, qemuArgs := setup(t)
 	qemuArgs = append(qemuArgs, "-fw_cfg", "name=use_kexec,string=1")
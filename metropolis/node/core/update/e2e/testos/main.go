@@ -102,6 +102,8 @@ func testosRunnable(ctx context.Context) error {
 
 	updateSvc := update.Service{
 		Logger: supervisor.MustSubLogger(ctx, "update"),
+		// Test bundles are not signed.
+		AllowUnsignedBundles: true,
 	}
 	for pn, p := range vdaParts.Partitions {
 		if p.IsUnused() {
@@ -123,6 +125,14 @@ func testosRunnable(ctx context.Context) error {
 			}
 		}
 	}
+	if Variant == "F" {
+		// Simulate an update which boots, but never becomes healthy.
+		supervisor.Logger(ctx).Info("Not marking boot as successful, powering off")
+		unix.Sync()
+		time.Sleep(1 * time.Second)
+		unix.Reboot(unix.LINUX_REBOOT_CMD_POWER_OFF)
+		return nil
+	}
 	if err := updateSvc.MarkBootSuccessful(); err != nil {
 		supervisor.Logger(ctx).Errorf("error marking boot successful: %w", err)
 	}
@@ -4,13 +4,16 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"debug/pe"
 	_ "embed"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -44,6 +47,46 @@ type Service struct {
 
 	// Logger service for the update service.
 	Logger logtree.LeveledLogger
+
+	// BundleKey is the Ed25519 public key which bundles need to be signed
+	// with. If nil, the key baked into the binary at build time is used. If
+	// neither is available, all bundles are rejected unless
+	// AllowUnsignedBundles is set.
+	BundleKey ed25519.PublicKey
+	// AllowUnsignedBundles makes the service install bundles without
+	// verifying their signature if no bundle key is available. This must only
+	// be set for development builds.
+	AllowUnsignedBundles bool
+}
+
+// bundlePublicKey is the hex-encoded Ed25519 public key which bundles are
+// verified against. It is set at link time (via x_defs) for release builds.
+var bundlePublicKey string
+
+// ErrBadSignature is returned by InstallBundle if the detached signature of
+// a bundle is missing or does not match the bundle contents.
+var ErrBadSignature = errors.New("bundle signature verification failed")
+
+// bundleKey returns the public key bundles need to be signed with, or nil if
+// no key is configured.
+func (s *Service) bundleKey() (ed25519.PublicKey, error) {
+	if s.BundleKey != nil {
+		if len(s.BundleKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid bundle key length %d", len(s.BundleKey))
+		}
+		return s.BundleKey, nil
+	}
+	if bundlePublicKey == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(bundlePublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid baked-in bundle key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid baked-in bundle key length %d", len(key))
+	}
+	return key, nil
 }
 
 type Slot int
@@ -124,8 +167,10 @@ var bootVarRegexp = regexp.MustCompile(`^Boot([0-9A-Fa-f]{4})$`)
 // MarkBootSuccessful must be called after each boot if some implementation-
 // defined criteria for a successful boot are met. If an update has been
 // installed and booted and this function is called, the updated version is
-// marked as default. If an issue occurs during boot and so this function is
-// not called the old version will be started again on next boot.
+// marked as default and the loader's boot attempt counter is reset. If an
+// issue occurs during boot and so this function is not called, the loader
+// retries the updated version a limited number of times before starting the
+// old version again.
 func (s *Service) MarkBootSuccessful() error {
 	if s.ESPPath == "" {
 		return errors.New("no ESP information provided to update service, cannot continue")
@@ -156,6 +201,17 @@ func (s *Service) MarkBootSuccessful() error {
 			return fmt.Errorf("while setting next A/B slot: %w", err)
 		}
 		s.Logger.Infof("Permanently activated slot %v", activeSlot)
+	} else if abState.NextSlot != abloaderpb.Slot_NONE {
+		// The loader was unable to load the next slot and fell back to the
+		// active one. Discard the pending update instead of retrying it on
+		// every boot.
+		err := s.setABState(&abloaderpb.ABLoaderData{
+			ActiveSlot: abloaderpb.Slot(activeSlot),
+		})
+		if err != nil {
+			return fmt.Errorf("while resetting next A/B slot: %w", err)
+		}
+		s.Logger.Warningf("Booted from slot %v instead of pending slot %v after %d attempts, discarded pending update", activeSlot, Slot(abState.NextSlot), abState.BootAttempts)
 	} else {
 		s.Logger.Infof("Normal boot from slot %v", activeSlot)
 	}
@@ -204,23 +260,9 @@ func (s *Service) InstallBundle(ctx context.Context, bundleURL string, withKexec
 	if s.ESPPath == "" {
 		return errors.New("no ESP information provided to update service, cannot continue")
 	}
-	// Download into a buffer as ZIP files cannot efficiently be read from
-	// HTTP in Go as the ReaderAt has no way of indicating continuous sections,
-	// thus a ton of small range requests would need to be used, causing
-	// a huge latency penalty as well as costing a lot of money on typical
-	// object storages. This should go away when we switch to a better bundle
-	// format which can be streamed.
-	var bundleRaw bytes.Buffer
-	b := backoff.NewExponentialBackOff()
-	err := backoff.Retry(func() error {
-		return s.tryDownloadBundle(ctx, bundleURL, &bundleRaw)
-	}, backoff.WithContext(b, ctx))
+	bundle, err := s.downloadBundle(ctx, bundleURL)
 	if err != nil {
-		return fmt.Errorf("error downloading Metropolis bundle: %v", err)
-	}
-	bundle, err := zip.NewReader(bytes.NewReader(bundleRaw.Bytes()), int64(bundleRaw.Len()))
-	if err != nil {
-		return fmt.Errorf("failed to open node bundle: %w", err)
+		return err
 	}
 	efiPayload, err := bundle.Open("kernel_efi.efi")
 	if err != nil {
@@ -246,6 +288,9 @@ func (s *Service) InstallBundle(ctx context.Context, bundleURL string, withKexec
 	if _, err := io.Copy(blockdev.NewRWS(systemPart), systemImage); err != nil {
 		return status.Errorf(codes.Unavailable, "Failed to copy system image: %v", err)
 	}
+	if err := systemPart.Sync(); err != nil {
+		return status.Errorf(codes.Unavailable, "Failed to sync system image: %v", err)
+	}
 
 	bootFile, err := os.Create(filepath.Join(s.ESPPath, targetSlot.EFIBootPath()))
 	if err != nil {
@@ -273,6 +318,94 @@ func (s *Service) InstallBundle(ctx context.Context, bundleURL string, withKexec
 	return nil
 }
 
+// signatureURL returns the URL of the detached signature belonging to the
+// bundle at bundleURL, which is the bundle path with a .sig suffix.
+func signatureURL(bundleURL string) (string, error) {
+	u, err := url.Parse(bundleURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid bundle URL: %w", err)
+	}
+	u.Path += ".sig"
+	if u.RawPath != "" {
+		u.RawPath += ".sig"
+	}
+	return u.String(), nil
+}
+
+// downloadBundle downloads the bundle at the given HTTP(S) URL into memory,
+// verifies its detached signature and opens it as a ZIP archive.
+func (s *Service) downloadBundle(ctx context.Context, bundleURL string) (*zip.Reader, error) {
+	bundleRaw, err := s.fetchBundle(ctx, bundleURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifyBundle(ctx, bundleURL, bundleRaw); err != nil {
+		return nil, err
+	}
+	return openBundle(bundleRaw)
+}
+
+// fetchBundle downloads the file at the given HTTP(S) URL into memory.
+func (s *Service) fetchBundle(ctx context.Context, bundleURL string) ([]byte, error) {
+	// Download into a buffer as ZIP files cannot efficiently be read from
+	// HTTP in Go as the ReaderAt has no way of indicating continuous sections,
+	// thus a ton of small range requests would need to be used, causing
+	// a huge latency penalty as well as costing a lot of money on typical
+	// object storages. This should go away when we switch to a better bundle
+	// format which can be streamed.
+	var bundleRaw bytes.Buffer
+	b := backoff.NewExponentialBackOff()
+	err := backoff.Retry(func() error {
+		return s.tryDownloadBundle(ctx, bundleURL, &bundleRaw)
+	}, backoff.WithContext(b, ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading Metropolis bundle: %v", err)
+	}
+	return bundleRaw.Bytes(), nil
+}
+
+// verifyBundle verifies the contents of the bundle downloaded from bundleURL
+// against its detached signature. Without a bundle key, the signature is only
+// skipped if unsigned bundles are allowed.
+func (s *Service) verifyBundle(ctx context.Context, bundleURL string, bundleRaw []byte) error {
+	key, err := s.bundleKey()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		if !s.AllowUnsignedBundles {
+			return fmt.Errorf("%w: no bundle key configured", ErrBadSignature)
+		}
+		s.Logger.Warningf("No bundle key configured, not verifying bundle signature")
+		return nil
+	}
+	sigURL, err := signatureURL(bundleURL)
+	if err != nil {
+		return err
+	}
+	var sigRaw bytes.Buffer
+	b := backoff.NewExponentialBackOff()
+	err = backoff.Retry(func() error {
+		return s.tryDownloadBundle(ctx, sigURL, &sigRaw)
+	}, backoff.WithContext(b, ctx))
+	if err != nil {
+		return fmt.Errorf("%w: error downloading signature: %v", ErrBadSignature, err)
+	}
+	if !ed25519.Verify(key, bundleRaw, sigRaw.Bytes()) {
+		return fmt.Errorf("%w: signature does not match bundle", ErrBadSignature)
+	}
+	return nil
+}
+
+// openBundle opens a downloaded bundle as a ZIP archive.
+func openBundle(bundleRaw []byte) (*zip.Reader, error) {
+	bundle, err := zip.NewReader(bytes.NewReader(bundleRaw), int64(len(bundleRaw)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open node bundle: %w", err)
+	}
+	return bundle, nil
+}
+
 func (*Service) tryDownloadBundle(ctx context.Context, bundleURL string, bundleRaw *bytes.Buffer) error {
 	bundleReq, err := http.NewRequestWithContext(ctx, "GET", bundleURL, nil)
 	if err != nil {
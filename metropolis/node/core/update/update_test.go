@@ -0,0 +1,101 @@
+package update
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"source.monogon.dev/osbase/logtree"
+)
+
+// makeBundle returns a minimal ZIP-formatted bundle.
+func makeBundle(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.Create("verity_rootfs.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("rootfs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadBundleSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := makeBundle(t)
+	sig := ed25519.Sign(priv, bundle)
+	tampered := bytes.Clone(bundle)
+	tampered[len(tampered)-30] ^= 0xff
+
+	mux := http.NewServeMux()
+	serve := func(path string, data []byte) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		})
+	}
+	serve("/good.zip", bundle)
+	serve("/good.zip.sig", sig)
+	serve("/tampered.zip", tampered)
+	serve("/tampered.zip.sig", sig)
+	serve("/unsigned.zip", bundle)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	s := &Service{
+		Logger:    logtree.New().MustLeveledFor("update"),
+		BundleKey: pub,
+	}
+	ctx := context.Background()
+
+	if _, err := s.downloadBundle(ctx, srv.URL+"/good.zip"); err != nil {
+		t.Errorf("valid bundle rejected: %v", err)
+	}
+	if _, err := s.downloadBundle(ctx, srv.URL+"/tampered.zip"); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("tampered bundle: wanted ErrBadSignature, got %v", err)
+	}
+	if _, err := s.downloadBundle(ctx, srv.URL+"/unsigned.zip"); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("unsigned bundle: wanted ErrBadSignature, got %v", err)
+	}
+
+	// Without a key, bundles must only be accepted if explicitly allowed.
+	s.BundleKey = nil
+	if _, err := s.downloadBundle(ctx, srv.URL+"/good.zip"); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("no key: wanted ErrBadSignature, got %v", err)
+	}
+	s.AllowUnsignedBundles = true
+	if _, err := s.downloadBundle(ctx, srv.URL+"/unsigned.zip"); err != nil {
+		t.Errorf("no key, unsigned bundles allowed: unsigned bundle rejected: %v", err)
+	}
+}
+
+func TestSignatureURL(t *testing.T) {
+	for _, te := range []struct {
+		in, want string
+	}{
+		{"https://example.com/bundle.zip", "https://example.com/bundle.zip.sig"},
+		{"https://example.com/bundle.zip?X-Token=abc", "https://example.com/bundle.zip.sig?X-Token=abc"},
+	} {
+		got, err := signatureURL(te.in)
+		if err != nil {
+			t.Errorf("%q: %v", te.in, err)
+			continue
+		}
+		if got != te.want {
+			t.Errorf("%q: wanted %q, got %q", te.in, te.want, got)
+		}
+	}
+}
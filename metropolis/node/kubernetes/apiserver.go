@@ -79,6 +79,10 @@ func mustMarshalJSON(o any) []byte {
 }
 
 var (
+	// podsecurityadmission configures Pod Security Admission, which enforces
+	// pod security cluster-wide. It superseded the PodSecurityPolicy builtins
+	// which used to be created by the reconciler. Namespaces can deviate from
+	// these defaults using the standard pod-security.kubernetes.io/* labels.
 	podsecurityadmission = &podsecurityadmissionv1.PodSecurityConfiguration{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: podsecurityadmissionv1.SchemeGroupVersion.String(),
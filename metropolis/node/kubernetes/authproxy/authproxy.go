@@ -51,6 +51,86 @@ func respondWithK8sStatus(w http.ResponseWriter, status *metav1.Status) error {
 	return json.NewEncoder(w).Encode(status)
 }
 
+// readOnlyGroup is the Kubernetes group to which users presenting read-only
+// Metropolis credentials are mapped. The reconciler binds it to the built-in
+// view ClusterRole.
+const readOnlyGroup = "metropolis:read-only"
+
+// readOnlyUser returns the Kubernetes user name for a user presenting
+// read-only Metropolis credentials. It must differ from the name used for full
+// credentials of the same identity, as RBAC bindings apply to the latter.
+func readOnlyUser(identity string) string {
+	return readOnlyGroup + ":" + identity
+}
+
+// isReadRequest returns whether the given HTTP method cannot be used to
+// perform write operations on the Kubernetes API.
+func isReadRequest(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// newHandler returns the HTTP handler which authenticates Metropolis users by
+// their client certificate and forwards their requests to the apiserver via
+// proxy, or spdyProxy for SPDY upgrade requests.
+//
+// Users presenting read-only credentials are mapped to a separate user in
+// readOnlyGroup, and any request which could perform a write is rejected
+// before it reaches the apiserver.
+func newHandler(ca *x509.Certificate, proxy, spdyProxy http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Guaranteed to exist because of RequireAndVerifyClientCert
+		clientCert := req.TLS.VerifiedChains[0][0]
+		clientIdentity, err := identity.VerifyUserInCluster(clientCert, ca)
+		if err != nil {
+			respondWithK8sStatus(rw, &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusUnauthorized,
+				Reason:  metav1.StatusReasonUnauthorized,
+				Message: fmt.Sprintf("Metropolis authentication failed: %v", err),
+			})
+			return
+		}
+		user := clientIdentity
+		group := ""
+		if identity.UserIsReadOnly(clientCert) {
+			if !isReadRequest(req.Method) {
+				respondWithK8sStatus(rw, &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    http.StatusForbidden,
+					Reason:  metav1.StatusReasonForbidden,
+					Message: fmt.Sprintf("read-only Metropolis credentials cannot perform %s requests", req.Method),
+				})
+				return
+			}
+			user = readOnlyUser(clientIdentity)
+			group = readOnlyGroup
+		}
+		proxyToUse := proxy
+		// Kubernetes wants to use SPDY but using SPDY with HTTP/2 is unsupported.
+		// SPDY should be removed from K8s, this is tracked in
+		// https://github.com/kubernetes/kubernetes/issues/7452
+		if strings.HasPrefix(strings.ToLower(req.Header.Get("Upgrade")), "spdy/") {
+			proxyToUse = spdyProxy
+		}
+		// Clone the request as otherwise modifying it is not allowed
+		newReq := req.Clone(req.Context())
+		// Drop any X-Remote headers to prevent injection
+		for k := range newReq.Header {
+			if strings.HasPrefix(http.CanonicalHeaderKey(k), http.CanonicalHeaderKey("X-Remote-")) {
+				newReq.Header.Del(k)
+			}
+		}
+		newReq.Header.Set("X-Remote-User", user)
+		newReq.Header.Set("X-Remote-Group", group)
+
+		proxyToUse.ServeHTTP(rw, newReq)
+	})
+}
+
 func (s *Service) Run(ctx context.Context) error {
 	logger := supervisor.Logger(ctx)
 
@@ -129,39 +209,7 @@ func (s *Service) Run(ctx context.Context) error {
 		IdleTimeout:       90 * time.Second,
 		ReadHeaderTimeout: 32 * time.Second,
 
-		Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-			// Guaranteed to exist because of RequireAndVerifyClientCert
-			clientCert := req.TLS.VerifiedChains[0][0]
-			clientIdentity, err := identity.VerifyUserInCluster(clientCert, s.Node.ClusterCA())
-			if err != nil {
-				respondWithK8sStatus(rw, &metav1.Status{
-					Status:  metav1.StatusFailure,
-					Code:    http.StatusUnauthorized,
-					Reason:  metav1.StatusReasonUnauthorized,
-					Message: fmt.Sprintf("Metropolis authentication failed: %v", err),
-				})
-				return
-			}
-			proxyToUse := standardProxy
-			// Kubernetes wants to use SPDY but using SPDY with HTTP/2 is unsupported.
-			// SPDY should be removed from K8s, this is tracked in
-			// https://github.com/kubernetes/kubernetes/issues/7452
-			if strings.HasPrefix(strings.ToLower(req.Header.Get("Upgrade")), "spdy/") {
-				proxyToUse = noHTTP2Proxy
-			}
-			// Clone the request as otherwise modifying it is not allowed
-			newReq := req.Clone(req.Context())
-			// Drop any X-Remote headers to prevent injection
-			for k := range newReq.Header {
-				if strings.HasPrefix(http.CanonicalHeaderKey(k), http.CanonicalHeaderKey("X-Remote-")) {
-					newReq.Header.Del(k)
-				}
-			}
-			newReq.Header.Set("X-Remote-User", clientIdentity)
-			newReq.Header.Set("X-Remote-Group", "")
-
-			proxyToUse.ServeHTTP(rw, newReq)
-		}),
+		Handler: newHandler(s.Node.ClusterCA(), standardProxy, noHTTP2Proxy),
 	}
 	go server.ListenAndServeTLS("", "")
 	logger.Info("K8s AuthProxy running")
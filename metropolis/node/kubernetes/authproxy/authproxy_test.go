@@ -0,0 +1,123 @@
+package authproxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"source.monogon.dev/metropolis/node/core/identity"
+)
+
+// issue signs the given certificate template with the CA and returns the
+// resulting certificate.
+func issue(t *testing.T, template x509.Certificate, ca *x509.Certificate, caKey ed25519.PrivateKey) *x509.Certificate {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template.SerialNumber = big.NewInt(1)
+	template.NotBefore = time.Now()
+	template.NotAfter = time.Unix(253402300799, 0)
+	template.BasicConstraintsValid = true
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca, pub, caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return cert
+}
+
+// TestHandler exercises the mapping of Metropolis user certificates into
+// Kubernetes users, and ensures that read-only certificates cannot be used to
+// perform writes.
+func TestHandler(t *testing.T) {
+	caPub, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	caTemplate := identity.CACertificate("test metropolis CA")
+	caTemplate.SerialNumber = big.NewInt(1)
+	caTemplate.NotBefore = time.Now()
+	caTemplate.NotAfter = time.Unix(253402300799, 0)
+	caTemplate.BasicConstraintsValid = true
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate, caPub, caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+
+	owner := issue(t, identity.UserCertificate("owner"), ca, caKey)
+	readOnly := issue(t, identity.ReadOnlyUserCertificate("owner"), ca, caKey)
+
+	for _, te := range []struct {
+		name   string
+		cert   *x509.Certificate
+		method string
+		// wantCode is the expected response code. If it is http.StatusOK, the
+		// request is expected to reach the apiserver.
+		wantCode  int
+		wantUser  string
+		wantGroup string
+	}{
+		{"OwnerGet", owner, http.MethodGet, http.StatusOK, "owner", ""},
+		{"OwnerPost", owner, http.MethodPost, http.StatusOK, "owner", ""},
+		{"ReadOnlyGet", readOnly, http.MethodGet, http.StatusOK, "metropolis:read-only:owner", "metropolis:read-only"},
+		{"ReadOnlyPost", readOnly, http.MethodPost, http.StatusForbidden, "", ""},
+		{"ReadOnlyPut", readOnly, http.MethodPut, http.StatusForbidden, "", ""},
+		{"ReadOnlyPatch", readOnly, http.MethodPatch, http.StatusForbidden, "", ""},
+		{"ReadOnlyDelete", readOnly, http.MethodDelete, http.StatusForbidden, "", ""},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			var forwarded *http.Request
+			apiserver := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r
+			})
+			h := newHandler(ca, apiserver, apiserver)
+
+			req := httptest.NewRequest(te.method, "/api/v1/namespaces/default/pods", nil)
+			req.TLS = &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{te.cert}},
+			}
+			// Attempt to inject an identity, which must be dropped.
+			req.Header.Set("X-Remote-User", "owner")
+			req.Header.Set("X-Remote-Extra-Foo", "bar")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != te.wantCode {
+				t.Fatalf("got response code %d, wanted %d", rec.Code, te.wantCode)
+			}
+			if te.wantCode != http.StatusOK {
+				if forwarded != nil {
+					t.Fatalf("request was forwarded to apiserver")
+				}
+				return
+			}
+			if forwarded == nil {
+				t.Fatalf("request was not forwarded to apiserver")
+			}
+			if got := forwarded.Header.Get("X-Remote-User"); got != te.wantUser {
+				t.Errorf("X-Remote-User is %q, wanted %q", got, te.wantUser)
+			}
+			if got := forwarded.Header.Get("X-Remote-Group"); got != te.wantGroup {
+				t.Errorf("X-Remote-Group is %q, wanted %q", got, te.wantGroup)
+			}
+			if got := forwarded.Header.Get("X-Remote-Extra-Foo"); got != "" {
+				t.Errorf("injected X-Remote-Extra-Foo header was forwarded")
+			}
+		})
+	}
+}
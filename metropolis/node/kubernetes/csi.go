@@ -20,10 +20,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/sys/unix"
@@ -46,12 +48,25 @@ import (
 // 130 characters.
 var acceptableNames = regexp.MustCompile("^[a-z][a-z0-9-.]{0,128}[a-z0-9]$")
 
+// csiTopologyKey is the topology segment key under which the CSI plugin
+// reports the ID of the node it is running on. As volumes are local to a node,
+// they are only accessible from nodes within the same segment.
+const csiTopologyKey = "topology.metropolis.monogon.dev/node"
+
 type csiPluginServer struct {
 	*csi.UnimplementedNodeServer
 	KubeletDirectory *localstorage.DataKubernetesKubeletDirectory
 	VolumesDirectory *localstorage.DataVolumesDirectory
+	// NodeID is the ID of the local node, used for topology.
+	NodeID string
+	// MaxVolumesPerNode is the maximum number of volumes which can be
+	// published on this node, or zero if unlimited.
+	MaxVolumesPerNode int64
 
 	logger logtree.LeveledLogger
+	// metrics are the per-operation metrics of this plugin, created on first
+	// run and kept across restarts.
+	metrics *csiMetrics
 }
 
 func (s *csiPluginServer) Run(ctx context.Context) error {
@@ -65,7 +80,14 @@ func (s *csiPluginServer) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to listen on CSI socket: %w", err)
 	}
 
-	pluginServer := grpc.NewServer()
+	if s.metrics == nil {
+		s.metrics = newCSIMetrics()
+	}
+	if err := supervisor.Run(ctx, "metrics", s.metrics.serve); err != nil {
+		return err
+	}
+
+	pluginServer := grpc.NewServer(grpc.UnaryInterceptor(s.metrics.interceptor))
 	csi.RegisterIdentityServer(pluginServer, s)
 	csi.RegisterNodeServer(pluginServer, s)
 	// Enable graceful shutdown since we don't have long-running RPCs and most
@@ -87,50 +109,180 @@ func (s *csiPluginServer) Run(ctx context.Context) error {
 	return nil
 }
 
-func (s *csiPluginServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	if !acceptableNames.MatchString(req.VolumeId) {
-		return nil, status.Error(codes.InvalidArgument, "invalid characters in volume id")
+// volumePath returns the path of the backing directory or image file of a
+// volume.
+func (s *csiPluginServer) volumePath(volumeID string) (string, error) {
+	if !acceptableNames.MatchString(volumeID) {
+		return "", status.Error(codes.InvalidArgument, "invalid characters in volume id")
 	}
-
 	// TODO(q3k): move this logic to localstorage?
-	volumePath := filepath.Join(s.VolumesDirectory.FullPath(), req.VolumeId)
+	return filepath.Join(s.VolumesDirectory.FullPath(), volumeID), nil
+}
 
-	switch req.VolumeCapability.AccessMode.Mode {
+// checkAccessMode returns whether the access mode of the given capability only
+// permits reading, or an error if it is not supported. As volumes are local to
+// a node, multi-node access modes are never supported.
+func checkAccessMode(cap *csi.VolumeCapability) (readOnly bool, err error) {
+	switch cap.GetAccessMode().GetMode() {
 	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:
+		return false, nil
 	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:
+		return true, nil
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return false, status.Error(codes.InvalidArgument, "multi-node access modes are not supported")
 	default:
-		return nil, status.Error(codes.InvalidArgument, "unsupported access mode")
+		return false, status.Error(codes.InvalidArgument, "unsupported access mode")
 	}
-	if err := os.MkdirAll(req.TargetPath, 0700); err != nil {
-		return nil, status.Errorf(codes.Internal, "unable to create requested target path: %v", err)
+}
+
+// publishReadOnly returns whether a volume must be published read-only, either
+// because the request asks for it or because of the volume's access mode.
+func publishReadOnly(req *csi.NodePublishVolumeRequest) (bool, error) {
+	readOnly, err := checkAccessMode(req.VolumeCapability)
+	if err != nil {
+		return false, err
+	}
+	switch req.VolumeCapability.GetAccessType().(type) {
+	case *csi.VolumeCapability_Mount, *csi.VolumeCapability_Block:
+	default:
+		return false, status.Error(codes.InvalidArgument, "unsupported access type")
+	}
+	return readOnly || req.Readonly, nil
+}
+
+// isMountOf returns true if path is the root of a (bind) mount of src, ie.
+// if both refer to the same inode.
+func isMountOf(path, src string) (bool, error) {
+	var pathStat, srcStat unix.Stat_t
+	if err := unix.Stat(path, &pathStat); err != nil {
+		return false, err
+	}
+	if err := unix.Stat(src, &srcStat); err != nil {
+		return false, err
+	}
+	return pathStat.Dev == srcStat.Dev && pathStat.Ino == srcStat.Ino, nil
+}
+
+// NodeStageVolume makes a volume available at its staging path, from which it
+// is then bind-mounted into pods by NodePublishVolume. For filesystem volumes,
+// the volume directory is bind-mounted to the staging path. Block volumes get
+// a separate loop device per publication, so only their existence is checked
+// here.
+func (s *csiPluginServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	volumePath, err := s.volumePath(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	if req.StagingTargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "staging target path missing")
+	}
+	if _, err := checkAccessMode(req.VolumeCapability); err != nil {
+		return nil, err
 	}
 	switch req.VolumeCapability.AccessType.(type) {
 	case *csi.VolumeCapability_Mount:
-		err := unix.Mount(volumePath, req.TargetPath, "", unix.MS_BIND, "")
+		if err := os.MkdirAll(req.StagingTargetPath, 0700); err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to create requested staging path: %v", err)
+		}
+		staged, err := isMountOf(req.StagingTargetPath, volumePath)
 		switch {
 		case errors.Is(err, unix.ENOENT):
 			return nil, status.Error(codes.NotFound, "volume not found")
 		case err != nil:
+			return nil, status.Errorf(codes.Unavailable, "failed to check staging path: %v", err)
+		case staged:
+			return &csi.NodeStageVolumeResponse{}, nil
+		}
+		if err := unix.Mount(volumePath, req.StagingTargetPath, "", unix.MS_BIND, ""); err != nil {
 			return nil, status.Errorf(codes.Unavailable, "failed to bind-mount volume: %v", err)
 		}
+	case *csi.VolumeCapability_Block:
+		_, err := os.Stat(volumePath)
+		switch {
+		case os.IsNotExist(err):
+			return nil, status.Error(codes.NotFound, "volume not found")
+		case err != nil:
+			return nil, status.Errorf(codes.Unavailable, "failed to stat block volume: %v", err)
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unsupported access type")
+	}
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// NodeUnstageVolume undoes NodeStageVolume.
+func (s *csiPluginServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	if req.StagingTargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "staging target path missing")
+	}
+	err := unix.Unmount(req.StagingTargetPath, 0)
+	// EINVAL is returned if the staging path is not a mount point, ie. the
+	// volume is a block volume or has already been unstaged.
+	if err != nil && !errors.Is(err, unix.EINVAL) && !errors.Is(err, unix.ENOENT) {
+		return nil, status.Errorf(codes.Unavailable, "failed to unmount volume: %v", err)
+	}
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
 
-		if req.Readonly {
-			err := unix.Mount(volumePath, req.TargetPath, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, "")
+func (s *csiPluginServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	volumePath, err := s.volumePath(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	readOnly, err := publishReadOnly(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(req.TargetPath, 0700); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to create requested target path: %v", err)
+	}
+	switch at := req.VolumeCapability.AccessType.(type) {
+	case *csi.VolumeCapability_Mount:
+		// gid is the group to apply to the volume, or -1 if none.
+		gid := -1
+		if group := at.Mount.GetVolumeMountGroup(); group != "" && !readOnly {
+			gid, err = strconv.Atoi(group)
+			if err != nil || gid < 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid volume mount group %q", group)
+			}
+		}
+		if req.StagingTargetPath == "" {
+			return nil, status.Error(codes.FailedPrecondition, "volume not staged")
+		}
+		err := unix.Mount(req.StagingTargetPath, req.TargetPath, "", unix.MS_BIND, "")
+		switch {
+		case errors.Is(err, unix.ENOENT):
+			return nil, status.Error(codes.NotFound, "staging path not found")
+		case err != nil:
+			return nil, status.Errorf(codes.Unavailable, "failed to bind-mount volume: %v", err)
+		}
+
+		if readOnly {
+			err := unix.Mount(req.StagingTargetPath, req.TargetPath, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, "")
 			if err != nil {
 				_ = unix.Unmount(req.TargetPath, 0) // Best-effort
 				return nil, status.Errorf(codes.Unavailable, "failed to remount volume: %v", err)
 			}
+		} else if gid >= 0 {
+			if err := applyGroupOwnership(req.TargetPath, gid); err != nil {
+				_ = unix.Unmount(req.TargetPath, 0) // Best-effort
+				return nil, status.Errorf(codes.Unavailable, "failed to apply volume mount group: %v", err)
+			}
 		}
 	case *csi.VolumeCapability_Block:
-		f, err := os.OpenFile(volumePath, os.O_RDWR, 0)
+		openFlags := os.O_RDWR
+		var flags uint32 = loop.FlagDirectIO
+		if readOnly {
+			openFlags = os.O_RDONLY
+			flags |= loop.FlagReadOnly
+		}
+		f, err := os.OpenFile(volumePath, openFlags, 0)
 		if err != nil {
 			return nil, status.Errorf(codes.Unavailable, "failed to open block volume: %v", err)
 		}
 		defer f.Close()
-		var flags uint32 = loop.FlagDirectIO
-		if req.Readonly {
-			flags |= loop.FlagReadOnly
-		}
 		loopdev, err := loop.Create(f, loop.Config{Flags: flags})
 		if err != nil {
 			return nil, status.Errorf(codes.Unavailable, "failed to create loop device: %v", err)
@@ -152,6 +304,36 @@ func (s *csiPluginServer) NodePublishVolume(ctx context.Context, req *csi.NodePu
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// applyGroupOwnership recursively makes all files and directories below path
+// owned and read/writable by the given group, the same way Kubernetes applies
+// a pod's fsGroup to volumes. Directories additionally get the setgid bit, so
+// that newly created files inherit the group.
+func applyGroupOwnership(path string, gid int) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(p, -1, gid); err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mode := info.Mode() | 0660
+		if d.IsDir() {
+			mode |= os.ModeSetgid | 0110
+		}
+		if mode == info.Mode() {
+			return nil
+		}
+		return os.Chmod(p, mode)
+	})
+}
+
 func (s *csiPluginServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	loopdev, err := loop.Open(req.TargetPath)
 	if err == nil {
@@ -236,19 +418,27 @@ func rpcCapability(cap csi.NodeServiceCapability_RPC_Type) *csi.NodeServiceCapab
 func (*csiPluginServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
 	return &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
+			rpcCapability(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME),
 			rpcCapability(csi.NodeServiceCapability_RPC_EXPAND_VOLUME),
 			rpcCapability(csi.NodeServiceCapability_RPC_GET_VOLUME_STATS),
+			rpcCapability(csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP),
 		},
 	}, nil
 }
 
-func (*csiPluginServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+func (s *csiPluginServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, status.Errorf(codes.Unavailable, "failed to get node identity: %v", err)
 	}
 	return &csi.NodeGetInfoResponse{
-		NodeId: hostname,
+		NodeId:            hostname,
+		MaxVolumesPerNode: s.MaxVolumesPerNode,
+		AccessibleTopology: &csi.Topology{
+			Segments: map[string]string{
+				csiTopologyKey: s.NodeID,
+			},
+		},
 	}, nil
 }
 
@@ -263,6 +453,13 @@ func (*csiPluginServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInf
 func (*csiPluginServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
 	return &csi.GetPluginCapabilitiesResponse{
 		Capabilities: []*csi.PluginCapability{
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			},
 			{
 				Type: &csi.PluginCapability_VolumeExpansion_{
 					VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
@@ -0,0 +1,116 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"source.monogon.dev/metropolis/node"
+	"source.monogon.dev/osbase/supervisor"
+)
+
+// csiMetrics are the Prometheus metrics of the CSI plugin. All of them are
+// labeled with the CSI operation, ie. the name of the gRPC method (eg.
+// NodePublishVolume).
+type csiMetrics struct {
+	registry *prometheus.Registry
+
+	// operations counts all handled operations.
+	operations *prometheus.CounterVec
+	// errors counts failed operations, additionally labeled with the gRPC
+	// status code.
+	errors *prometheus.CounterVec
+	// duration is the latency of all handled operations.
+	duration *prometheus.HistogramVec
+}
+
+func newCSIMetrics() *csiMetrics {
+	m := &csiMetrics{
+		registry: prometheus.NewRegistry(),
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csi_operations_total",
+			Help: "Number of CSI operations handled by the node plugin.",
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csi_operation_errors_total",
+			Help: "Number of CSI operations handled by the node plugin which failed.",
+		}, []string{"operation", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "csi_operation_duration_seconds",
+			Help:    "Latency of CSI operations handled by the node plugin.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"operation"}),
+	}
+	m.registry.MustRegister(m.operations, m.errors, m.duration)
+	return m
+}
+
+// interceptor is a gRPC unary server interceptor recording metrics for every
+// handled operation.
+func (m *csiMetrics) interceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	operation := path.Base(info.FullMethod)
+	start := time.Now()
+	res, err := handler(ctx, req)
+	m.operations.WithLabelValues(operation).Inc()
+	m.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(operation, status.Code(err).String()).Inc()
+	}
+	return res, err
+}
+
+// serve serves the metrics on 127.0.0.1:MetricsCSIListenerPort, from where they
+// are exported by the metrics service.
+func (m *csiMetrics) serve(ctx context.Context) error {
+	lis, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", node.MetricsCSIListenerPort.PortString()))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	defer lis.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	srv := http.Server{
+		Handler: mux,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	supervisor.Signal(ctx, supervisor.SignalHealthy)
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	err = srv.Serve(lis)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("Serve(): %w", err)
+}
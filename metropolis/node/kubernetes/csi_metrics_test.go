@@ -0,0 +1,38 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+// TestCSIMetricsErrors ensures that failed operations are counted by the
+// metrics interceptor, labeled with their operation and status code.
+func TestCSIMetricsErrors(t *testing.T) {
+	s := &csiPluginServer{
+		metrics: newCSIMetrics(),
+	}
+	info := &grpc.UnaryServerInfo{
+		FullMethod: "/csi.v1.Node/NodePublishVolume",
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return s.NodePublishVolume(ctx, req.(*csi.NodePublishVolumeRequest))
+	}
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "../invalid",
+		TargetPath: t.TempDir(),
+	}
+	if _, err := s.metrics.interceptor(context.Background(), req, info, handler); err == nil {
+		t.Fatalf("publishing volume with invalid ID succeeded")
+	}
+
+	if want, got := 1.0, testutil.ToFloat64(s.metrics.operations.WithLabelValues("NodePublishVolume")); want != got {
+		t.Errorf("wanted %v operations, got %v", want, got)
+	}
+	if want, got := 1.0, testutil.ToFloat64(s.metrics.errors.WithLabelValues("NodePublishVolume", "InvalidArgument")); want != got {
+		t.Errorf("wanted %v errors, got %v", want, got)
+	}
+}
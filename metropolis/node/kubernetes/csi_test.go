@@ -0,0 +1,74 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestPublishReadOnly exercises the access mode checks of NodePublishVolume
+// for all combinations of access modes and access types.
+func TestPublishReadOnly(t *testing.T) {
+	accessTypes := map[string]func() *csi.VolumeCapability{
+		"mount": func() *csi.VolumeCapability {
+			return &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			}
+		},
+		"block": func() *csi.VolumeCapability {
+			return &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			}
+		},
+	}
+	for _, te := range []struct {
+		mode csi.VolumeCapability_AccessMode_Mode
+		// requestReadOnly is the Readonly field of the request.
+		requestReadOnly bool
+		wantReadOnly    bool
+		wantErr         bool
+	}{
+		{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, false, false, false},
+		{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, true, true, false},
+		{csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, false, true, false},
+		{csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, true, true, false},
+		{csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, false, false, true},
+		{csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER, false, false, true},
+		{csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, false, false, true},
+		{csi.VolumeCapability_AccessMode_UNKNOWN, false, false, true},
+	} {
+		for name, accessType := range accessTypes {
+			cap := accessType()
+			cap.AccessMode = &csi.VolumeCapability_AccessMode{Mode: te.mode}
+			readOnly, err := publishReadOnly(&csi.NodePublishVolumeRequest{
+				VolumeCapability: cap,
+				Readonly:         te.requestReadOnly,
+			})
+			if te.wantErr {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("%s/%s: wanted InvalidArgument, got %v", te.mode, name, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s/%s: unexpected error: %v", te.mode, name, err)
+				continue
+			}
+			if readOnly != te.wantReadOnly {
+				t.Errorf("%s/%s (readonly request: %v): wanted read-only %v, got %v", te.mode, name, te.requestReadOnly, te.wantReadOnly, readOnly)
+			}
+		}
+	}
+
+	// Requests without an access type are rejected.
+	_, err := publishReadOnly(&csi.NodePublishVolumeRequest{
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("missing access type: wanted InvalidArgument, got %v", err)
+	}
+}
@@ -26,7 +26,10 @@ package pki
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -34,8 +37,10 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
 	"k8s.io/client-go/tools/clientcmd"
 	configapi "k8s.io/client-go/tools/clientcmd/api"
 
@@ -85,14 +90,79 @@ const (
 )
 
 const (
+	// certificateLifetime is the lifetime of static certificates issued by the
+	// Kubernetes CAs. The CAs themselves never expire, as renewing them would
+	// require distributing the new CAs to all consumers first.
+	certificateLifetime = 365 * 24 * time.Hour
+	// certificateRenewalWindow is the duration before expiry at which static
+	// certificates get renewed.
+	certificateRenewalWindow = 30 * 24 * time.Hour
+
 	// etcdPrefix is where all the PKI data is stored in etcd.
 	etcdPrefix = "/kube-pki/"
 	// serviceAccountKeyName is the etcd path part that is used to store the
-	// ServiceAccount authentication secret. This is not a certificate, just an
-	// RSA key.
+	// ServiceAccount authentication secret. This is not a certificate, just a
+	// private key. Its algorithm is stored alongside it, see
+	// ServiceAccountKeyAlgorithm.
 	serviceAccountKeyName = "service-account-privkey"
 )
 
+// ServiceAccountKeyAlgorithm is the algorithm of the Kubernetes service account
+// key. Its value is stored in etcd alongside the key.
+type ServiceAccountKeyAlgorithm string
+
+const (
+	// ServiceAccountKeyRSA2048 is a 2048-bit RSA key. This is the default, and
+	// also the algorithm of keys stored without an algorithm marker, as all such
+	// keys were generated before the algorithm became configurable.
+	ServiceAccountKeyRSA2048 ServiceAccountKeyAlgorithm = "rsa-2048"
+	// ServiceAccountKeyRSA3072 is a 3072-bit RSA key.
+	ServiceAccountKeyRSA3072 ServiceAccountKeyAlgorithm = "rsa-3072"
+	// ServiceAccountKeyECDSAP256 is an ECDSA key on the NIST P-256 curve.
+	ServiceAccountKeyECDSAP256 ServiceAccountKeyAlgorithm = "ecdsa-p256"
+)
+
+// generate generates a new private key of this algorithm, returned in PKCS#8
+// form.
+func (a ServiceAccountKeyAlgorithm) generate() ([]byte, error) {
+	var key crypto.Signer
+	var err error
+	switch a {
+	case ServiceAccountKeyRSA2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ServiceAccountKeyRSA3072:
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case ServiceAccountKeyECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown service account key algorithm %q", a)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// check returns an error if the given PKCS#8 private key is not of this
+// algorithm.
+func (a ServiceAccountKeyAlgorithm) check(der []byte) error {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return fmt.Errorf("could not parse key: %w", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if (a == ServiceAccountKeyRSA2048 && k.N.BitLen() == 2048) || (a == ServiceAccountKeyRSA3072 && k.N.BitLen() == 3072) {
+			return nil
+		}
+	case *ecdsa.PrivateKey:
+		if a == ServiceAccountKeyECDSAP256 && k.Curve == elliptic.P256() {
+			return nil
+		}
+	}
+	return fmt.Errorf("key is not of algorithm %q", a)
+}
+
 // PKI manages all PKI resources required to run Kubernetes on Metropolis. It
 // contains all static certificates, which can be retrieved, or be used to
 // generate Kubeconfigs from.
@@ -100,22 +170,29 @@ type PKI struct {
 	namespace    opki.Namespace
 	KV           clientv3.KV
 	Certificates map[KubeCertificateName]*opki.Certificate
+	// ServiceAccountKeyAlgorithm is the algorithm used when generating the
+	// service account key. It has no effect on an already existing key.
+	// Defaults to ServiceAccountKeyRSA2048.
+	ServiceAccountKeyAlgorithm ServiceAccountKeyAlgorithm
 }
 
 func New(kv clientv3.KV, clusterDomain string) *PKI {
 	pki := PKI{
-		namespace:    opki.Namespaced(etcdPrefix),
-		KV:           kv,
-		Certificates: make(map[KubeCertificateName]*opki.Certificate),
+		namespace:                  opki.Namespaced(etcdPrefix),
+		KV:                         kv,
+		Certificates:               make(map[KubeCertificateName]*opki.Certificate),
+		ServiceAccountKeyAlgorithm: ServiceAccountKeyRSA2048,
 	}
 
 	makeCert := func(i, name KubeCertificateName, template x509.Certificate) {
 		pki.Certificates[name] = &opki.Certificate{
-			Namespace: &pki.namespace,
-			Issuer:    pki.Certificates[i],
-			Name:      string(name),
-			Template:  template,
-			Mode:      opki.CertificateManaged,
+			Namespace:     &pki.namespace,
+			Issuer:        pki.Certificates[i],
+			Name:          string(name),
+			Template:      template,
+			Mode:          opki.CertificateManaged,
+			Lifetime:      certificateLifetime,
+			RenewalWindow: certificateRenewalWindow,
 		}
 	}
 
@@ -202,6 +279,22 @@ func (k *PKI) EnsureAll(ctx context.Context) error {
 	return nil
 }
 
+// Snapshot ensures all static certificates like EnsureAll, thereby renewing
+// any which are about to expire, and returns their current DER-encoded x509
+// certificates. Comparing two snapshots reveals whether any certificate has
+// been renewed in the meantime, either locally or by another node.
+func (k *PKI) Snapshot(ctx context.Context) (map[KubeCertificateName][]byte, error) {
+	res := make(map[KubeCertificateName][]byte)
+	for n, v := range k.Certificates {
+		cert, err := v.Ensure(ctx, k.KV)
+		if err != nil {
+			return nil, fmt.Errorf("could not ensure certificate %q exists: %w", n, err)
+		}
+		res[n] = cert
+	}
+	return res, nil
+}
+
 // Kubeconfig generates a kubeconfig blob for a given certificate name. The
 // same lifetime semantics as in .Certificate apply.
 func (k *PKI) Kubeconfig(ctx context.Context, name KubeCertificateName, endpoint KubernetesAPIEndpoint) ([]byte, error) {
@@ -264,6 +357,16 @@ func KubeconfigRaw(cacert, cert []byte, priv ed25519.PrivateKey, endpoint Kubern
 		return nil, fmt.Errorf("could not marshal private key: %w", err)
 	}
 
+	authInfo := configapi.NewAuthInfo()
+	authInfo.ClientCertificateData = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	authInfo.ClientKeyData = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	return kubeconfig(cacert, authInfo, endpoint)
+}
+
+// kubeconfig emits a Kubeconfig which trusts the given CA certificate when
+// connecting to the given KubernetesAPIEndpoint, and authenticates using the
+// given AuthInfo.
+func kubeconfig(cacert []byte, authInfo *configapi.AuthInfo, endpoint KubernetesAPIEndpoint) ([]byte, error) {
 	kubeconfig := configapi.NewConfig()
 
 	cluster := configapi.NewCluster()
@@ -273,9 +376,6 @@ func KubeconfigRaw(cacert, cert []byte, priv ed25519.PrivateKey, endpoint Kubern
 	cluster.CertificateAuthorityData = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cacert})
 	kubeconfig.Clusters["default"] = cluster
 
-	authInfo := configapi.NewAuthInfo()
-	authInfo.ClientCertificateData = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
-	authInfo.ClientKeyData = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
 	kubeconfig.AuthInfos["default"] = authInfo
 
 	ct := configapi.NewContext()
@@ -305,40 +405,93 @@ func Kubeconfig(ctx context.Context, kv clientv3.KV, c *opki.Certificate, endpoi
 	return KubeconfigRaw(ca, cert, c.PrivateKey, endpoint)
 }
 
+// KubeconfigWithExec generates a kubeconfig blob which, instead of embedding a
+// client certificate, authenticates by running execCommand with the given args
+// as a client-go credential plugin. The CA certificate with the given name is
+// embedded to verify the apiserver, and will be created on etcd if not yet
+// present.
+func (k *PKI) KubeconfigWithExec(ctx context.Context, name KubeCertificateName, endpoint KubernetesAPIEndpoint, execCommand string, args []string) ([]byte, error) {
+	c, ok := k.Certificates[name]
+	if !ok {
+		return nil, fmt.Errorf("no certificate %q", name)
+	}
+	ca, err := c.Ensure(ctx, k.KV)
+	if err != nil {
+		return nil, fmt.Errorf("could not ensure CA certificate exists: %w", err)
+	}
+
+	authInfo := configapi.NewAuthInfo()
+	authInfo.Exec = &configapi.ExecConfig{
+		APIVersion:      clientauthentication.SchemeGroupVersion.String(),
+		Command:         execCommand,
+		Args:            args,
+		InteractiveMode: configapi.NeverExecInteractiveMode,
+	}
+	return kubeconfig(ca, authInfo, endpoint)
+}
+
 // ServiceAccountKey retrieves (and possibly generates and stores on etcd) the
 // Kubernetes service account key. The returned data is ready to be used by
-// Kubernetes components (in PKIX form).
+// Kubernetes components (in PKCS#8 form).
+//
+// A newly generated key uses the algorithm configured in
+// ServiceAccountKeyAlgorithm. An existing key is always kept, regardless of
+// the configured algorithm, as replacing it would invalidate all issued
+// service account tokens.
 func (k *PKI) ServiceAccountKey(ctx context.Context) ([]byte, error) {
 	// TODO(q3k): this should be abstracted away once we abstract away etcd
 	// access into a library with try-or-create semantics.
 	path := fmt.Sprintf("%s%s.der", etcdPrefix, serviceAccountKeyName)
+	algPath := fmt.Sprintf("%s%s.alg", etcdPrefix, serviceAccountKeyName)
 
-	// Try loading  key from etcd.
-	keyRes, err := k.KV.Get(ctx, path)
+	// Try loading key from etcd.
+	res, err := k.KV.Txn(ctx).Then(
+		clientv3.OpGet(path),
+		clientv3.OpGet(algPath),
+	).Commit()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get key from etcd: %w", err)
 	}
-
-	if len(keyRes.Kvs) == 1 {
-		// Certificate and key exists in etcd, return that.
-		return keyRes.Kvs[0].Value, nil
+	keyKvs := res.Responses[0].GetResponseRange().Kvs
+	algKvs := res.Responses[1].GetResponseRange().Kvs
+
+	if len(keyKvs) == 1 {
+		// Key exists in etcd, return that. Keys stored without an algorithm
+		// marker predate it and are always RSA-2048.
+		alg := ServiceAccountKeyRSA2048
+		if len(algKvs) == 1 {
+			alg = ServiceAccountKeyAlgorithm(algKvs[0].Value)
+		}
+		key := keyKvs[0].Value
+		if err := alg.check(key); err != nil {
+			return nil, fmt.Errorf("stored key invalid: %w", err)
+		}
+		return key, nil
 	}
 
 	// No key found - generate one.
-	keyRaw, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		panic(err)
+	alg := k.ServiceAccountKeyAlgorithm
+	if alg == "" {
+		alg = ServiceAccountKeyRSA2048
 	}
-	key, err := x509.MarshalPKCS8PrivateKey(keyRaw)
+	key, err := alg.generate()
 	if err != nil {
-		panic(err) // Always a programmer error
+		return nil, fmt.Errorf("failed to generate key: %w", err)
 	}
 
 	// Save to etcd.
-	_, err = k.KV.Put(ctx, path, string(key))
+	res, err = k.KV.Txn(ctx).If(
+		clientv3.Compare(clientv3.CreateRevision(path), "=", 0),
+	).Then(
+		clientv3.OpPut(path, string(key)),
+		clientv3.OpPut(algPath, string(alg)),
+	).Commit()
 	if err != nil {
 		return nil, fmt.Errorf("failed to write newly generated key: %w", err)
 	}
+	if !res.Succeeded {
+		return nil, fmt.Errorf("key generation transaction failed: concurrent write")
+	}
 	return key, nil
 }
 
@@ -19,11 +19,14 @@
 // functionality. All resources containing the
 // metropolis.monogon.dev/builtin=true label are assumed to be managed by the
 // reconciler.
-// It currently does not revert modifications made by admins, it is  planned to
-// create an admission plugin prohibiting such modifications to resources with
-// the metropolis.monogon.dev/builtin label to deal with that problem. This
-// would also solve a potential issue where you could delete resources just by
-// adding the metropolis.monogon.dev/builtin=true label.
+// Modifications made by admins to built-in resources are reverted on the next
+// reconciliation: every present object is compared against its expected
+// state, ignoring fields managed by the server, and updated (or recreated, if
+// immutable fields differ) if it drifted. It is planned to create an admission
+// plugin prohibiting such modifications to resources with the
+// metropolis.monogon.dev/builtin label in the first place. This would also
+// solve a potential issue where you could delete resources just by adding the
+// metropolis.monogon.dev/builtin=true label.
 package reconciler
 
 import (
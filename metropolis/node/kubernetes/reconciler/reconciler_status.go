@@ -128,6 +128,10 @@ type Service struct {
 	ClientSet kubernetes.Interface
 	// NodeID is the ID of the local node.
 	NodeID string
+	// ResyncInterval is the interval at which all resources are reconciled,
+	// in addition to reconciliations triggered by changes of built-in
+	// objects. Defaults to 30 seconds if zero.
+	ResyncInterval time.Duration
 	// releases is set by watchNodes and watched by other parts of the service.
 	releases memory.Value[*nodeReleases]
 }
@@ -511,18 +515,10 @@ func (s *Service) lead(ctx context.Context, isLeaderCmp clientv3.Cmp) error {
 		}
 	}
 
-	// Reconcile at a regular interval.
-	t := time.NewTicker(30 * time.Second)
-	defer t.Stop()
-	for {
-		select {
-		case <-t.C:
-			err := reconcileAll(ctx, s.ClientSet)
-			if err != nil {
-				log.Warning(err)
-			}
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+	// Reconcile on changes and at a regular interval.
+	interval := s.ResyncInterval
+	if interval == 0 {
+		interval = defaultResyncInterval
 	}
+	return reconcileContinuously(ctx, s.ClientSet, interval)
 }
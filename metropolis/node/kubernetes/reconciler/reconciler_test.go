@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -28,6 +29,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
 	installnode "k8s.io/kubernetes/pkg/apis/node/install"
 	installpolicy "k8s.io/kubernetes/pkg/apis/policy/install"
 	installrbac "k8s.io/kubernetes/pkg/apis/rbac/install"
@@ -171,9 +173,13 @@ func newTestResource(want ...*testObject) *testResource {
 func (r *testResource) currentDiff(want ...*testObject) string {
 	expected := make(map[string]*testObject)
 	for _, w := range want {
-		if _, ok := r.current[w.GetName()]; !ok {
+		cur, ok := r.current[w.GetName()]
+		if !ok {
 			return fmt.Sprintf("%q missing in current", w.GetName())
 		}
+		if cur.Val != w.Val {
+			return fmt.Sprintf("%q has value %d in current, wanted %d", w.GetName(), cur.Val, w.Val)
+		}
 		expected[w.GetName()] = w
 	}
 	for _, g := range r.current {
@@ -220,13 +226,103 @@ func TestBasicReconciliation(t *testing.T) {
 		}
 		// bar should be updated
 		if diff := r.currentDiff(makeTestObject("bar", 1), makeTestObject("baz", 0)); diff != "" {
-			return fmt.Errorf("wrong state after deleting foo: %s", diff)
+			return fmt.Errorf("wrong state after updating bar: %s", diff)
+		}
+
+		// Simulate an admin modifying baz.
+		r.current["baz"] = makeTestObject("baz", 2)
+		if err := reconcile(ctx, r, rname); err != nil {
+			return fmt.Errorf("reconcile: %v", err)
+		}
+		// baz should be reverted
+		if diff := r.currentDiff(makeTestObject("bar", 1), makeTestObject("baz", 0)); diff != "" {
+			return fmt.Errorf("wrong state after modifying baz: %s", diff)
+		}
+
+		return nil
+	})
+}
+
+// TestDriftReverted ensures that modifications of built-in objects on a
+// (fake) apiserver get reverted by the next reconciliation.
+func TestDriftReverted(t *testing.T) {
+	supervisor.TestHarness(t, func(ctx context.Context) error {
+		clientSet := fake.NewSimpleClientset()
+		if err := reconcileAll(ctx, clientSet); err != nil {
+			return fmt.Errorf("initial reconcile: %w", err)
+		}
+
+		// Modify the local storage class, as an admin might do.
+		scs := clientSet.StorageV1().StorageClasses()
+		sc, err := scs.Get(ctx, "local", meta.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("get storage class: %w", err)
+		}
+		sc.AllowVolumeExpansion = False()
+		delete(sc.Annotations, "storageclass.kubernetes.io/is-default-class")
+		if _, err := scs.Update(ctx, sc, meta.UpdateOptions{}); err != nil {
+			return fmt.Errorf("update storage class: %w", err)
 		}
 
+		if err := reconcileAll(ctx, clientSet); err != nil {
+			return fmt.Errorf("second reconcile: %w", err)
+		}
+		sc, err = scs.Get(ctx, "local", meta.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("get storage class: %w", err)
+		}
+		if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+			t.Errorf("AllowVolumeExpansion not reverted")
+		}
+		if sc.Annotations["storageclass.kubernetes.io/is-default-class"] != "true" {
+			t.Errorf("Default class annotation not reverted")
+		}
 		return nil
 	})
 }
 
+// TestReconcileOnChange ensures that changes of built-in objects trigger a
+// reconciliation without waiting for the periodic resync.
+func TestReconcileOnChange(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	supervisor.TestHarness(t, func(ctx context.Context) error {
+		if err := reconcileAll(ctx, clientSet); err != nil {
+			return fmt.Errorf("initial reconcile: %w", err)
+		}
+		return reconcileContinuously(ctx, clientSet, time.Hour)
+	})
+
+	ctx, ctxC := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ctxC()
+	scs := clientSet.StorageV1().StorageClasses()
+	for {
+		err := scs.Delete(ctx, "local", meta.DeleteOptions{})
+		if err == nil {
+			break
+		}
+		if !apierrors.IsNotFound(err) {
+			t.Fatalf("Delete: %v", err)
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Storage class never created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for {
+		_, err := scs.Get(ctx, "local", meta.GetOptions{})
+		if err == nil {
+			break
+		}
+		if !apierrors.IsNotFound(err) {
+			t.Fatalf("Get: %v", err)
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Storage class not recreated after deletion")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestIsImmutableError(t *testing.T) {
 	gk := schema.GroupKind{Group: "someGroup", Kind: "someKind"}
 	cases := []struct {
@@ -0,0 +1,91 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"source.monogon.dev/osbase/supervisor"
+)
+
+// defaultResyncInterval is the interval at which all resources are
+// reconciled if Service.ResyncInterval is not set.
+const defaultResyncInterval = 30 * time.Second
+
+// reconcileContinuously keeps reconciling all resources until the context is
+// canceled. Built-in objects are watched, and any change to them (eg. an admin
+// modifying or deleting them) triggers an immediate reconciliation. In
+// addition, all resources are reconciled at the given interval, as a safety
+// net against missed events.
+func reconcileContinuously(ctx context.Context, clientSet kubernetes.Interface, interval time.Duration) error {
+	log := supervisor.Logger(ctx)
+
+	// trigger is buffered, so that a burst of events only results in a single
+	// reconciliation.
+	trigger := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { notify() },
+		UpdateFunc: func(oldObj, newObj any) { notify() },
+		DeleteFunc: func(obj any) { notify() },
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientSet, 0,
+		informers.WithTweakListOptions(func(options *meta.ListOptions) {
+			options.LabelSelector = listBuiltins.LabelSelector
+		}))
+	defer factory.Shutdown()
+	for name, informer := range map[string]cache.SharedIndexInformer{
+		"clusterroles":        factory.Rbac().V1().ClusterRoles().Informer(),
+		"clusterrolebindings": factory.Rbac().V1().ClusterRoleBindings().Informer(),
+		"storageclasses":      factory.Storage().V1().StorageClasses().Informer(),
+		"csidrivers":          factory.Storage().V1().CSIDrivers().Informer(),
+		"runtimeclasses":      factory.Node().V1().RuntimeClasses().Informer(),
+	} {
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("could not add %s event handler: %w", name, err)
+		}
+		err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			log.Warningf("%s watch error: %v", name, err)
+		})
+		if err != nil {
+			return fmt.Errorf("could not set %s watch error handler: %w", name, err)
+		}
+	}
+	factory.Start(ctx.Done())
+	for typ, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("could not sync %v informer", typ)
+		}
+	}
+	// Reconcile once the watches are established, in case anything changed
+	// between the previous reconciliation and now.
+	notify()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-trigger:
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := reconcileAll(ctx, clientSet); err != nil {
+			log.Warning(err)
+		}
+	}
+}
@@ -27,6 +27,7 @@ import (
 var (
 	clusterRoleBindingAPIServerKubeletClient = builtinRBACName("apiserver-kubelet-client")
 	clusterRoleBindingOwnerAdmin             = builtinRBACName("owner-admin")
+	clusterRoleBindingReadOnlyView           = builtinRBACName("read-only-view")
 	clusterRoleCSIProvisioner                = builtinRBACName("csi-provisioner")
 	clusterRoleBindingCSIProvisioners        = builtinRBACName("csi-provisioner")
 	clusterRoleNetServices                   = builtinRBACName("netservices")
@@ -192,6 +193,29 @@ func (r resourceClusterRoleBindings) Expected() []meta.Object {
 				},
 			},
 		},
+		&rbac.ClusterRoleBinding{
+			ObjectMeta: meta.ObjectMeta{
+				Name:   clusterRoleBindingReadOnlyView,
+				Labels: builtinLabels(nil),
+				Annotations: map[string]string{
+					"kubernetes.io/description": "This binding grants users presenting read-only Metropolis " +
+						"credentials access to the view role on Kubernetes.",
+				},
+			},
+			RoleRef: rbac.RoleRef{
+				APIGroup: rbac.GroupName,
+				Kind:     "ClusterRole",
+				Name:     "view",
+			},
+			Subjects: []rbac.Subject{
+				{
+					APIGroup: rbac.GroupName,
+					Kind:     "Group",
+					// Contract with the authproxy, which maps read-only users into this group.
+					Name: "metropolis:read-only",
+				},
+			},
+		},
 		&rbac.ClusterRoleBinding{
 			ObjectMeta: meta.ObjectMeta{
 				Name:   clusterRoleBindingCSIProvisioners,
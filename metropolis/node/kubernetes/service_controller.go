@@ -17,9 +17,11 @@
 package kubernetes
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -63,6 +65,13 @@ func NewController(c ConfigController) *Controller {
 }
 
 func (s *Controller) Run(ctx context.Context) error {
+	// Snapshot the static certificates before handing them to any component,
+	// so that renewals happening from now on get noticed.
+	certificates, err := s.c.KPKI.Snapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("could not ensure certificates: %w", err)
+	}
+
 	controllerManagerConfig, err := getPKIControllerManagerConfig(ctx, s.c.KPKI)
 	if err != nil {
 		return fmt.Errorf("could not generate controller manager pki config: %w", err)
@@ -199,9 +208,40 @@ func (s *Controller) Run(ctx context.Context) error {
 	s.c.Network.ConfigureDNS(clusterDNSDirective)
 
 	supervisor.Signal(ctx, supervisor.SignalHealthy)
-	<-ctx.Done()
+	certErr := s.watchCertificates(ctx, certificates)
 	s.c.Network.ConfigureDNS(dns.CancelDirective(clusterDNSDirective))
-	return nil
+	return certErr
+}
+
+// certificateCheckInterval is the interval at which the controller checks the
+// static Kubernetes certificates for renewal.
+const certificateCheckInterval = time.Hour
+
+// watchCertificates periodically renews static certificates which are about
+// to expire. Once any of them differs from the given snapshot, either because
+// it has been renewed here or by another node, an error is returned. This
+// restarts the controller and all Kubernetes components, which then pick up
+// the renewed certificates. nil is returned once ctx is canceled.
+func (s *Controller) watchCertificates(ctx context.Context, snapshot map[pki.KubeCertificateName][]byte) error {
+	t := time.NewTicker(certificateCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		current, err := s.c.KPKI.Snapshot(ctx)
+		if err != nil {
+			supervisor.Logger(ctx).Warningf("Could not check certificates for renewal: %v", err)
+			continue
+		}
+		for name, cert := range current {
+			if !bytes.Equal(cert, snapshot[name]) {
+				return fmt.Errorf("certificate %q has been renewed, restarting", name)
+			}
+		}
+	}
 }
 
 // GetDebugKubeconfig issues a kubeconfig for an arbitrary given identity.
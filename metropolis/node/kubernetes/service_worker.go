@@ -38,6 +38,11 @@ type ConfigWorker struct {
 	NodeID        string
 	CuratorClient ipb.CuratorClient
 	PodNetwork    event.Value[*oclusternet.Prefixes]
+
+	// MaxVolumesPerNode is the maximum number of volumes which can be
+	// published on this node, or zero if unlimited. It is set from the node's
+	// KubernetesWorker role.
+	MaxVolumesPerNode int64
 }
 
 type Worker struct {
@@ -179,8 +184,10 @@ func (s *Worker) Run(ctx context.Context) error {
 	}
 
 	csiPlugin := csiPluginServer{
-		KubeletDirectory: &s.c.Root.Data.Kubernetes.Kubelet,
-		VolumesDirectory: &s.c.Root.Data.Volumes,
+		KubeletDirectory:  &s.c.Root.Data.Kubernetes.Kubelet,
+		VolumesDirectory:  &s.c.Root.Data.Volumes,
+		NodeID:            s.c.NodeID,
+		MaxVolumesPerNode: s.c.MaxVolumesPerNode,
 	}
 
 	csiProvisioner := csiProvisionerServer{
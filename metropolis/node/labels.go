@@ -32,6 +32,12 @@ const (
 	// MaxLabelsPerNode is the absolute maximum of labels that can be attached to a
 	// node.
 	MaxLabelsPerNode = 128
+	// MaxAnnotationsPerNode is the absolute maximum of annotations that can be
+	// attached to a node.
+	MaxAnnotationsPerNode = 128
+	// MaxAnnotationValueLength is the maximum length in bytes of a node
+	// annotation value. Annotation keys follow the same rules as label keys.
+	MaxAnnotationValueLength = 1024
 )
 
 // ValidateLabel ensures that a given node label key/value component is valid:
@@ -66,6 +66,9 @@ const (
 	// MetricsContainerdListenerPort is the TCP port on which the
 	// containerd metrics endpoint, bound to 127.0.0.1, is exposed.
 	MetricsContainerdListenerPort Port = 7846
+	// MetricsCSIListenerPort is the TCP port on which the Kubernetes CSI
+	// plugin metrics endpoint, bound to 127.0.0.1, is exposed.
+	MetricsCSIListenerPort Port = 7847
 	// KubernetesAPIPort is the TCP port on which the Kubernetes API is
 	// exposed.
 	KubernetesAPIPort Port = 6443
@@ -94,6 +97,7 @@ var SystemPorts = []Port{
 	MetricsKubeControllerManagerListenerPort,
 	MetricsKubeAPIServerListenerPort,
 	MetricsContainerdListenerPort,
+	MetricsCSIListenerPort,
 	KubernetesAPIPort,
 	KubernetesAPIWrappedPort,
 	KubernetesWorkerLocalAPIPort,
@@ -126,6 +130,8 @@ func (p Port) String() string {
 		return "metrics-kubernetes-api-server"
 	case MetricsContainerdListenerPort:
 		return "metrics-containerd"
+	case MetricsCSIListenerPort:
+		return "metrics-csi"
 	case KubernetesAPIPort:
 		return "kubernetes-api"
 	case KubernetesAPIWrappedPort:
@@ -13,8 +13,9 @@ import (
 )
 
 // NewEphemeralClusterCredentials creates a set of TLS certificates for use in a
-// test Metropolis cluster. These are a CA certificate, a Manager certificate
-// and an arbitrary amount of Node certificates (per the nodes argument).
+// test Metropolis cluster. These are a CA certificate, a Manager certificate, a
+// read-only Manager certificate and an arbitrary amount of Node certificates
+// (per the nodes argument).
 //
 // All of these are ephemeral, ie. not stored anywhere - including the CA
 // certificate. This function is for use by tests which want to bring up a
@@ -49,12 +50,26 @@ func NewEphemeralClusterCredentials(t *testing.T, nodes int) *EphemeralClusterCr
 	if err != nil {
 		t.Fatalf("Could not ensure manager certificate: %v", err)
 	}
+	readOnlyManagerCert := pki.Certificate{
+		Namespace: &ns,
+		Issuer:    &caCert,
+		Template:  identity.ReadOnlyUserCertificate("owner"),
+		Mode:      pki.CertificateEphemeral,
+	}
+	readOnlyManagerBytes, err := readOnlyManagerCert.Ensure(ctx, nil)
+	if err != nil {
+		t.Fatalf("Could not ensure read-only manager certificate: %v", err)
+	}
 	res := &EphemeralClusterCredentials{
 		Nodes: make([]*identity.NodeCredentials, nodes),
 		Manager: tls.Certificate{
 			Certificate: [][]byte{managerBytes},
 			PrivateKey:  managerCert.PrivateKey,
 		},
+		ReadOnlyManager: tls.Certificate{
+			Certificate: [][]byte{readOnlyManagerBytes},
+			PrivateKey:  readOnlyManagerCert.PrivateKey,
+		},
 		CA: ca,
 	}
 
@@ -94,6 +109,9 @@ type EphemeralClusterCredentials struct {
 	// Manager TLS certificate for the cluster. Contains a private key and x509
 	// certificate authenticating the bearer as a Metropolis manager.
 	Manager tls.Certificate
+	// ReadOnlyManager TLS certificate for the cluster. Like Manager, but only
+	// permitted to perform read-only operations.
+	ReadOnlyManager tls.Certificate
 	// CA is the x509 certificate of the CA certificate for the cluster. Manager and
 	// Node certificates are signed by this CA.
 	CA *x509.Certificate
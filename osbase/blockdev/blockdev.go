@@ -8,6 +8,34 @@ import (
 
 var ErrNotBlockDevice = errors.New("not a block device")
 
+// ErrBusy is returned by Open and FromFileHandle if exclusive access was
+// requested, but the block device is already in use.
+var ErrBusy = errors.New("block device is busy")
+
+// OpenOption describes options for the Open and FromFileHandle calls.
+type OpenOption struct {
+	direct    bool
+	exclusive bool
+}
+
+// WithDirect makes Open and FromFileHandle return a Device which bypasses the
+// page cache (O_DIRECT on Linux). Reads and writes to such a Device need to
+// be aligned to its BlockSize, both in offset and in memory.
+func WithDirect() OpenOption { return OpenOption{direct: true} }
+
+// WithExclusive makes Open and FromFileHandle fail with ErrBusy if the block
+// device is already in use, eg. mounted or opened exclusively elsewhere.
+func WithExclusive() OpenOption { return OpenOption{exclusive: true} }
+
+// mergeOpenOptions combines multiple OpenOptions into one.
+func mergeOpenOptions(opts []OpenOption) (res OpenOption) {
+	for _, opt := range opts {
+		res.direct = res.direct || opt.direct
+		res.exclusive = res.exclusive || opt.exclusive
+	}
+	return
+}
+
 // BlockDev represents a generic block device made up of equally-sized blocks.
 // All offsets and intervals are expressed in bytes and must be aligned to
 // BlockSize and are recommended to be aligned to OptimalBlockSize if feasible.
@@ -41,6 +69,10 @@ type BlockDev interface {
 	// Zero zeroes a continouous set of blocks. On certain implementations this
 	// can be significantly faster than just calling Write with zeroes.
 	Zero(startByte, endByte int64) error
+
+	// Sync flushes all data written to the block device to stable storage,
+	// including volatile caches of the device itself if applicable.
+	Sync() error
 }
 
 func NewRWS(b BlockDev) *ReadWriteSeeker {
@@ -128,6 +160,10 @@ func (s *Section) WriteAt(p []byte, off int64) (n int, err error) {
 	return s.b.WriteAt(p, off+(s.startBlock*s.b.BlockSize()))
 }
 
+func (s *Section) Sync() error {
+	return s.b.Sync()
+}
+
 func (s *Section) BlockCount() int64 {
 	return s.endBlock - s.startBlock
 }
@@ -5,6 +5,7 @@ package blockdev
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math/bits"
 	"os"
 	"syscall"
@@ -55,30 +56,76 @@ func (d *Device) OptimalBlockSize() int64 {
 	return d.blockSize
 }
 
+func (d *Device) Sync() error {
+	var err error
+	if ctrlErr := d.rawConn.Control(func(fd uintptr) {
+		// Unlike fsync, F_FULLFSYNC also flushes the device's write cache.
+		_, err = unix.FcntlInt(fd, unix.F_FULLFSYNC, 0)
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	return nil
+}
+
 func (d *Device) Zero(startByte int64, endByte int64) error {
 	// It doesn't look like MacOS even has any zeroing acceleration, so just
 	// use the generic one.
 	return GenericZero(d, startByte, endByte)
 }
 
-// Open opens a block device given a path to its inode.
-func Open(path string) (*Device, error) {
+// RefreshPartitionTable is not implemented on Darwin.
+func (d *Device) RefreshPartitionTable() error {
+	return errors.ErrUnsupported
+}
+
+// Open opens a block device given a path to its inode. See OpenOption for
+// available options.
+func Open(path string, opts ...OpenOption) (*Device, error) {
 	outFile, err := os.OpenFile(path, os.O_RDWR, 0640)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open block device: %w", err)
 	}
-	return FromFileHandle(outFile)
+	dev, err := FromFileHandle(outFile, opts...)
+	if err != nil {
+		outFile.Close()
+		return nil, err
+	}
+	return dev, nil
 }
 
 // FromFileHandle creates a blockdev from a device handle. The device handle is
 // not duplicated, closing the returned Device will close it. If the handle is
 // not a block device, i.e does not implement block device ioctls, an error is
-// returned.
-func FromFileHandle(handle *os.File) (*Device, error) {
+// returned. See OpenOption for available options, which are applied to the
+// handle.
+func FromFileHandle(handle *os.File, opts ...OpenOption) (*Device, error) {
 	outFileC, err := handle.SyscallConn()
 	if err != nil {
 		return nil, fmt.Errorf("error getting SyscallConn: %w", err)
 	}
+	o := mergeOpenOptions(opts)
+	if o.direct {
+		// Darwin has no O_DIRECT, F_NOCACHE is the closest equivalent.
+		outFileC.Control(func(fd uintptr) {
+			_, err = unix.FcntlInt(fd, unix.F_NOCACHE, 1)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("when enabling direct I/O: %w", err)
+		}
+	}
+	if o.exclusive {
+		outFileC.Control(func(fd uintptr) {
+			err = unix.Flock(int(fd), unix.LOCK_EX|unix.LOCK_NB)
+		})
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, ErrBusy
+		} else if err != nil {
+			return nil, fmt.Errorf("when locking block device: %w", err)
+		}
+	}
 	var blockSize int
 	outFileC.Control(func(fd uintptr) {
 		blockSize, err = unix.IoctlGetInt(int(fd), DKIOCGETBLOCKSIZE)
@@ -137,10 +184,28 @@ func CreateFile(name string, blockSize int64, blockCount int64) (*File, error) {
 }
 
 func (d *File) ReadAt(p []byte, off int64) (n int, err error) {
+	bytesToEnd := d.blockCount*d.blockSize - off
+	if bytesToEnd <= 0 {
+		return 0, io.EOF
+	}
+	if bytesToEnd < int64(len(p)) {
+		return d.backend.ReadAt(p[:bytesToEnd], off)
+	}
 	return d.backend.ReadAt(p, off)
 }
 
 func (d *File) WriteAt(p []byte, off int64) (n int, err error) {
+	bytesToEnd := d.blockCount*d.blockSize - off
+	if bytesToEnd <= 0 {
+		return 0, ErrOutOfBounds
+	}
+	if bytesToEnd < int64(len(p)) {
+		n, err := d.backend.WriteAt(p[:bytesToEnd], off)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrOutOfBounds
+	}
 	return d.backend.WriteAt(p, off)
 }
 
@@ -156,6 +221,20 @@ func (d *File) BlockSize() int64 {
 	return d.blockSize
 }
 
+// Resize changes the size of the device to newBlockCount blocks by truncating
+// or extending the backing file. Unlike on Linux, shrinking the device is not
+// checked for data contained in the removed blocks.
+func (d *File) Resize(newBlockCount int64) error {
+	if newBlockCount < 0 {
+		return errors.New("block count cannot be negative")
+	}
+	if err := d.backend.Truncate(newBlockCount * d.blockSize); err != nil {
+		return fmt.Errorf("when resizing backing file: %w", err)
+	}
+	d.blockCount = newBlockCount
+	return nil
+}
+
 func (d *File) Discard(startByte int64, endByte int64) error {
 	// Can be supported in the future via fnctl.
 	return errors.ErrUnsupported
@@ -165,7 +244,26 @@ func (d *File) OptimalBlockSize() int64 {
 	return d.blockSize
 }
 
+func (d *File) Sync() error {
+	var err error
+	if ctrlErr := d.rawConn.Control(func(fd uintptr) {
+		// Unlike fsync, F_FULLFSYNC also flushes the device's write cache.
+		_, err = unix.FcntlInt(fd, unix.F_FULLFSYNC, 0)
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	return nil
+}
+
 func (d *File) Zero(startByte int64, endByte int64) error {
 	// Can possibly be accelerated in the future via fnctl.
 	return GenericZero(d, startByte, endByte)
 }
+
+// ReadSMART is not implemented on Darwin.
+func ReadSMART(d *Device) (*SMARTData, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -5,6 +5,7 @@ package blockdev
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math/bits"
 	"os"
 	"syscall"
@@ -63,6 +64,20 @@ func (d *Device) OptimalBlockSize() int64 {
 	return d.blockSize
 }
 
+func (d *Device) Sync() error {
+	var err error
+	if ctrlErr := d.rawConn.Control(func(fd uintptr) {
+		// On block devices, this also flushes the device's write cache.
+		err = unix.Fdatasync(int(fd))
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	return nil
+}
+
 func (d *Device) Zero(startByte int64, endByte int64) error {
 	var args [2]uint64
 	var err error
@@ -107,25 +122,69 @@ func (d *Device) RefreshPartitionTable() error {
 	return nil
 }
 
-// Open opens a block device given a path to its inode.
-// TODO: exclusive, O_DIRECT
-func Open(path string) (*Device, error) {
-	outFile, err := os.OpenFile(path, os.O_RDWR, 0640)
-	if err != nil {
+// Open opens a block device given a path to its inode. See OpenOption for
+// available options.
+func Open(path string, opts ...OpenOption) (*Device, error) {
+	o := mergeOpenOptions(opts)
+	flags := os.O_RDWR
+	if o.direct {
+		flags |= unix.O_DIRECT
+	}
+	if o.exclusive {
+		// For block devices, O_EXCL fails with EBUSY if the device is mounted
+		// or otherwise claimed by the kernel.
+		flags |= os.O_EXCL
+	}
+	outFile, err := os.OpenFile(path, flags, 0640)
+	if errors.Is(err, unix.EBUSY) {
+		return nil, ErrBusy
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to open block device: %w", err)
 	}
-	return FromFileHandle(outFile)
+	dev, err := FromFileHandle(outFile, OpenOption{exclusive: o.exclusive})
+	if err != nil {
+		outFile.Close()
+		return nil, err
+	}
+	return dev, nil
 }
 
 // FromFileHandle creates a blockdev from a device handle. The device handle is
 // not duplicated, closing the returned Device will close it. If the handle is
 // not a block device, i.e does not implement block device ioctls, an error is
-// returned.
-func FromFileHandle(handle *os.File) (*Device, error) {
+// returned. See OpenOption for available options, which are applied to the
+// handle.
+func FromFileHandle(handle *os.File, opts ...OpenOption) (*Device, error) {
 	outFileC, err := handle.SyscallConn()
 	if err != nil {
 		return nil, fmt.Errorf("error getting SyscallConn: %w", err)
 	}
+	o := mergeOpenOptions(opts)
+	if o.direct {
+		outFileC.Control(func(fd uintptr) {
+			var flags int
+			flags, err = unix.FcntlInt(fd, unix.F_GETFL, 0)
+			if err != nil {
+				return
+			}
+			_, err = unix.FcntlInt(fd, unix.F_SETFL, flags|unix.O_DIRECT)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("when enabling direct I/O: %w", err)
+		}
+	}
+	if o.exclusive {
+		// Advisory lock, as used by udev and util-linux to coordinate access
+		// to block devices.
+		outFileC.Control(func(fd uintptr) {
+			err = unix.Flock(int(fd), unix.LOCK_EX|unix.LOCK_NB)
+		})
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, ErrBusy
+		} else if err != nil {
+			return nil, fmt.Errorf("when locking block device: %w", err)
+		}
+	}
 	var blockSize uint32
 	outFileC.Control(func(fd uintptr) {
 		blockSize, err = unix.IoctlGetUint32(int(fd), unix.BLKSSZGET)
@@ -187,10 +246,28 @@ func CreateFile(name string, blockSize int64, blockCount int64) (*File, error) {
 }
 
 func (d *File) ReadAt(p []byte, off int64) (n int, err error) {
+	bytesToEnd := d.blockCount*d.blockSize - off
+	if bytesToEnd <= 0 {
+		return 0, io.EOF
+	}
+	if bytesToEnd < int64(len(p)) {
+		return d.backend.ReadAt(p[:bytesToEnd], off)
+	}
 	return d.backend.ReadAt(p, off)
 }
 
 func (d *File) WriteAt(p []byte, off int64) (n int, err error) {
+	bytesToEnd := d.blockCount*d.blockSize - off
+	if bytesToEnd <= 0 {
+		return 0, ErrOutOfBounds
+	}
+	if bytesToEnd < int64(len(p)) {
+		n, err := d.backend.WriteAt(p[:bytesToEnd], off)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrOutOfBounds
+	}
 	return d.backend.WriteAt(p, off)
 }
 
@@ -206,6 +283,31 @@ func (d *File) BlockSize() int64 {
 	return d.blockSize
 }
 
+// Resize changes the size of the device to newBlockCount blocks by truncating
+// or extending the backing file. Shrinking the device is rejected if the
+// blocks to be removed might contain data, as reported by SEEK_DATA. If the
+// filesystem cannot report this, all blocks are assumed to contain data.
+func (d *File) Resize(newBlockCount int64) error {
+	if newBlockCount < 0 {
+		return errors.New("block count cannot be negative")
+	}
+	newSize := newBlockCount * d.blockSize
+	if newBlockCount < d.blockCount {
+		start, _, err := d.nextData(newSize)
+		if err != nil {
+			return fmt.Errorf("while looking for data after %d: %w", newSize, err)
+		}
+		if start != -1 && start < d.blockCount*d.blockSize {
+			return fmt.Errorf("cannot shrink to %d blocks, data present at byte %d", newBlockCount, start)
+		}
+	}
+	if err := d.backend.Truncate(newSize); err != nil {
+		return fmt.Errorf("when resizing backing file: %w", err)
+	}
+	d.blockCount = newBlockCount
+	return nil
+}
+
 func (d *File) Discard(startByte int64, endByte int64) error {
 	var err error
 	if ctrlErr := d.rawConn.Control(func(fd uintptr) {
@@ -224,10 +326,46 @@ func (d *File) Discard(startByte int64, endByte int64) error {
 	return nil
 }
 
+// nextData implements dataFinder using SEEK_DATA and SEEK_HOLE.
+func (d *File) nextData(off int64) (start, end int64, err error) {
+	if ctrlErr := d.rawConn.Control(func(fd uintptr) {
+		start, err = unix.Seek(int(fd), off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// No more data after off.
+			start, err = -1, nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		end, err = unix.Seek(int(fd), start, unix.SEEK_HOLE)
+	}); ctrlErr != nil {
+		return 0, 0, ctrlErr
+	}
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+		// Not supported by the filesystem, assume everything contains data.
+		return off, d.blockCount * d.blockSize, nil
+	}
+	return start, end, err
+}
+
 func (d *File) OptimalBlockSize() int64 {
 	return d.blockSize
 }
 
+func (d *File) Sync() error {
+	var err error
+	if ctrlErr := d.rawConn.Control(func(fd uintptr) {
+		err = unix.Fdatasync(int(fd))
+	}); ctrlErr != nil {
+		return ctrlErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	return nil
+}
+
 func (d *File) Zero(startByte int64, endByte int64) error {
 	var err error
 	if ctrlErr := d.rawConn.Control(func(fd uintptr) {
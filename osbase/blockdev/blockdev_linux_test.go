@@ -0,0 +1,90 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package blockdev
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileResize(t *testing.T) {
+	d, err := CreateFile(filepath.Join(t.TempDir(), "file.img"), 512, 16)
+	if err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+	defer d.Close()
+
+	block := bytes.Repeat([]byte{0x42}, 512)
+	if _, err := d.WriteAt(block, 15*512); err != nil {
+		t.Fatalf("WriteAt(15): %v", err)
+	}
+	if _, err := d.WriteAt(block, 16*512); !errors.Is(err, ErrOutOfBounds) {
+		t.Fatalf("WriteAt(16) before growing: wanted ErrOutOfBounds, got %v", err)
+	}
+
+	if err := d.Resize(32); err != nil {
+		t.Fatalf("Resize(32): %v", err)
+	}
+	if want, got := int64(32), d.BlockCount(); want != got {
+		t.Errorf("wanted %d blocks, got %d", want, got)
+	}
+	if _, err := d.WriteAt(block, 31*512); err != nil {
+		t.Fatalf("WriteAt(31): %v", err)
+	}
+	if _, err := d.WriteAt(block, 32*512); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("WriteAt(32): wanted ErrOutOfBounds, got %v", err)
+	}
+	if n, err := d.WriteAt(make([]byte, 1024), 31*512); n != 512 || !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("WriteAt(31) across end: wanted 512 bytes and ErrOutOfBounds, got %d, %v", n, err)
+	}
+	buf := make([]byte, 1024)
+	if n, err := d.ReadAt(buf, 15*512); n != 1024 || err != nil {
+		t.Errorf("ReadAt(15): wanted 1024 bytes, got %d, %v", n, err)
+	}
+	if !bytes.Equal(buf[:512], block) {
+		t.Errorf("ReadAt(15): data written before growing not preserved")
+	}
+	if _, err := d.ReadAt(buf, 32*512); err != io.EOF {
+		t.Errorf("ReadAt(32): wanted EOF, got %v", err)
+	}
+
+	// Shrinking over written data must fail, unless the data is zeroed out
+	// first.
+	if err := d.Resize(8); err == nil {
+		t.Fatalf("Resize(8) over written data succeeded")
+	}
+	if err := d.Zero(8*512, 32*512); err != nil {
+		t.Fatalf("Zero: %v", err)
+	}
+	if err := d.Resize(8); err != nil {
+		t.Fatalf("Resize(8): %v", err)
+	}
+	if want, got := int64(8), d.BlockCount(); want != got {
+		t.Errorf("wanted %d blocks, got %d", want, got)
+	}
+	if _, err := d.WriteAt(block, 8*512); !errors.Is(err, ErrOutOfBounds) {
+		t.Errorf("WriteAt(8) after shrinking: wanted ErrOutOfBounds, got %v", err)
+	}
+	if err := d.Resize(-1); err == nil {
+		t.Errorf("Resize(-1) succeeded")
+	}
+}
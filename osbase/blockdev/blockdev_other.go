@@ -0,0 +1,166 @@
+//go:build !linux && !darwin
+
+package blockdev
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// Device is not supported on this platform. All methods return
+// errors.ErrUnsupported, and neither Open nor FromFileHandle can create one.
+// It only exists so that packages depending on blockdev can be compiled, eg.
+// for editor tooling.
+type Device struct{}
+
+func (d *Device) ReadAt(p []byte, off int64) (n int, err error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (d *Device) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (d *Device) Close() error {
+	return errors.ErrUnsupported
+}
+
+func (d *Device) BlockCount() int64 {
+	return 0
+}
+
+func (d *Device) BlockSize() int64 {
+	return 512
+}
+
+func (d *Device) Discard(startByte int64, endByte int64) error {
+	return errors.ErrUnsupported
+}
+
+func (d *Device) OptimalBlockSize() int64 {
+	return 512
+}
+
+func (d *Device) Sync() error {
+	return errors.ErrUnsupported
+}
+
+func (d *Device) Zero(startByte int64, endByte int64) error {
+	return errors.ErrUnsupported
+}
+
+func (d *Device) RefreshPartitionTable() error {
+	return errors.ErrUnsupported
+}
+
+// Open is not supported on this platform.
+func Open(path string, opts ...OpenOption) (*Device, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// FromFileHandle is not supported on this platform.
+func FromFileHandle(handle *os.File, opts ...OpenOption) (*Device, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// File is a portable implementation of a file-backed block device, without any
+// of the acceleration available on Linux and Darwin.
+type File struct {
+	backend    *os.File
+	blockSize  int64
+	blockCount int64
+}
+
+func CreateFile(name string, blockSize int64, blockCount int64) (*File, error) {
+	if blockSize < 512 {
+		return nil, fmt.Errorf("blockSize must be bigger than 512 bytes")
+	}
+	if bits.OnesCount64(uint64(blockSize)) != 1 {
+		return nil, fmt.Errorf("blockSize must be a power of two")
+	}
+	out, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("when creating backing file: %w", err)
+	}
+	return &File{
+		backend:    out,
+		blockSize:  blockSize,
+		blockCount: blockCount,
+	}, nil
+}
+
+func (d *File) ReadAt(p []byte, off int64) (n int, err error) {
+	bytesToEnd := d.blockCount*d.blockSize - off
+	if bytesToEnd <= 0 {
+		return 0, io.EOF
+	}
+	if bytesToEnd < int64(len(p)) {
+		return d.backend.ReadAt(p[:bytesToEnd], off)
+	}
+	return d.backend.ReadAt(p, off)
+}
+
+func (d *File) WriteAt(p []byte, off int64) (n int, err error) {
+	bytesToEnd := d.blockCount*d.blockSize - off
+	if bytesToEnd <= 0 {
+		return 0, ErrOutOfBounds
+	}
+	if bytesToEnd < int64(len(p)) {
+		n, err := d.backend.WriteAt(p[:bytesToEnd], off)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrOutOfBounds
+	}
+	return d.backend.WriteAt(p, off)
+}
+
+func (d *File) Close() error {
+	return d.backend.Close()
+}
+
+func (d *File) BlockCount() int64 {
+	return d.blockCount
+}
+
+func (d *File) BlockSize() int64 {
+	return d.blockSize
+}
+
+// Resize changes the size of the device to newBlockCount blocks by truncating
+// or extending the backing file. Shrinking the device is not checked for data
+// contained in the removed blocks.
+func (d *File) Resize(newBlockCount int64) error {
+	if newBlockCount < 0 {
+		return errors.New("block count cannot be negative")
+	}
+	if err := d.backend.Truncate(newBlockCount * d.blockSize); err != nil {
+		return fmt.Errorf("when resizing backing file: %w", err)
+	}
+	d.blockCount = newBlockCount
+	return nil
+}
+
+func (d *File) Discard(startByte int64, endByte int64) error {
+	return errors.ErrUnsupported
+}
+
+func (d *File) OptimalBlockSize() int64 {
+	return d.blockSize
+}
+
+func (d *File) Sync() error {
+	return d.backend.Sync()
+}
+
+func (d *File) Zero(startByte int64, endByte int64) error {
+	return GenericZero(d, startByte, endByte)
+}
+
+// ReadSMART is not implemented on this platform.
+func ReadSMART(d *Device) (*SMARTData, error) {
+	return nil, errors.ErrUnsupported
+}
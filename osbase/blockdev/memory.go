@@ -79,6 +79,11 @@ func (m *Memory) BlockCount() int64 {
 	return m.blockCount
 }
 
+// Sync is a no-op, as Memory has no stable storage to flush to.
+func (m *Memory) Sync() error {
+	return nil
+}
+
 func (m *Memory) OptimalBlockSize() int64 {
 	return m.blockSize
 }
@@ -0,0 +1,167 @@
+package blockdev
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SMARTProtocol identifies the protocol over which health data of a disk has
+// been read.
+type SMARTProtocol int
+
+const (
+	SMARTProtocolNVMe SMARTProtocol = iota
+	// SMARTProtocolATA is used for ATA disks reached via a SCSI/ATA
+	// translation layer (SAT), which is how Linux exposes SATA disks.
+	SMARTProtocolATA
+	SMARTProtocolSCSI
+)
+
+func (p SMARTProtocol) String() string {
+	switch p {
+	case SMARTProtocolNVMe:
+		return "NVMe"
+	case SMARTProtocolATA:
+		return "ATA"
+	case SMARTProtocolSCSI:
+		return "SCSI"
+	default:
+		return fmt.Sprintf("<invalid protocol %d>", int(p))
+	}
+}
+
+// SMARTData contains protocol-independent health information about a physical
+// disk. Fields which are not reported by a given disk or protocol are nil.
+type SMARTData struct {
+	// Protocol over which this data has been read.
+	Protocol SMARTProtocol
+	// TemperatureCelsius is the current temperature of the disk.
+	TemperatureCelsius *int
+	// ReallocatedSectors is the number of sectors which have been remapped to
+	// spare area due to errors. For SCSI disks this is the length of the grown
+	// defect list, for NVMe disks this is not available.
+	ReallocatedSectors *uint64
+	// PendingSectors is the number of sectors which are waiting to be
+	// remapped. Only available on ATA disks.
+	PendingSectors *uint64
+	// MediaErrors is the number of unrecovered media errors reported by the
+	// disk.
+	MediaErrors *uint64
+	// LifeUsed is a vendor-specific estimate of the wear of the disk, with 1.0
+	// signifying end of life. It can exceed 1.0.
+	LifeUsed *float32
+	// FailurePredicted is set if the disk itself reports that it is failing
+	// or about to fail.
+	FailurePredicted bool
+
+	// Degraded is true if any of the values above indicate that the disk
+	// should be replaced or inspected. See DegradedReasons for details.
+	Degraded bool
+	// DegradedReasons contains human-readable reasons for why the disk is
+	// considered degraded.
+	DegradedReasons []string
+}
+
+// evaluate sets Degraded and DegradedReasons based on the other fields of
+// SMARTData.
+func (s *SMARTData) evaluate() {
+	var reasons []string
+	if s.FailurePredicted {
+		reasons = append(reasons, "disk predicts failure")
+	}
+	if s.ReallocatedSectors != nil && *s.ReallocatedSectors > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d reallocated sectors", *s.ReallocatedSectors))
+	}
+	if s.PendingSectors != nil && *s.PendingSectors > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d sectors pending reallocation", *s.PendingSectors))
+	}
+	if s.MediaErrors != nil && *s.MediaErrors > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d media errors", *s.MediaErrors))
+	}
+	if s.LifeUsed != nil && *s.LifeUsed >= 1.0 {
+		reasons = append(reasons, fmt.Sprintf("%.0f%% of rated life used", *s.LifeUsed*100))
+	}
+	if s.TemperatureCelsius != nil && *s.TemperatureCelsius >= smartMaxTemperatureCelsius {
+		reasons = append(reasons, fmt.Sprintf("temperature of %d°C", *s.TemperatureCelsius))
+	}
+	s.DegradedReasons = reasons
+	s.Degraded = len(reasons) > 0
+}
+
+// smartMaxTemperatureCelsius is the temperature at which disks are considered
+// degraded. Disks are commonly specified for operation up to 60°C (spinning
+// disks) or 70°C (solid state disks).
+const smartMaxTemperatureCelsius = 70
+
+// ATA SMART attribute IDs. These are not formally standardized, but are used
+// consistently across vendors.
+const (
+	ataAttrReallocatedSectors    = 5
+	ataAttrAirflowTemperature    = 190
+	ataAttrTemperature           = 194
+	ataAttrPendingSectors        = 197
+	ataAttrOfflineUncorrectable  = 198
+	ataSMARTAttributeCount       = 30
+	ataSMARTAttributeSize        = 12
+	ataSMARTAttributeTableOffset = 2
+)
+
+// parseATASMART parses an ATA SMART READ DATA page and, if available, a SMART
+// READ THRESHOLDS page into SMARTData. thresholds can be nil if the disk does
+// not support reading thresholds.
+func parseATASMART(data, thresholds []byte) (*SMARTData, error) {
+	tableEnd := ataSMARTAttributeTableOffset + ataSMARTAttributeCount*ataSMARTAttributeSize
+	if len(data) < tableEnd {
+		return nil, errors.New("SMART data page too short")
+	}
+	if thresholds != nil && len(thresholds) < tableEnd {
+		return nil, errors.New("SMART thresholds page too short")
+	}
+	thresholdByID := make(map[uint8]uint8)
+	for i := 0; thresholds != nil && i < ataSMARTAttributeCount; i++ {
+		e := thresholds[ataSMARTAttributeTableOffset+i*ataSMARTAttributeSize:]
+		if e[0] != 0 {
+			thresholdByID[e[0]] = e[1]
+		}
+	}
+
+	res := SMARTData{Protocol: SMARTProtocolATA}
+	for i := 0; i < ataSMARTAttributeCount; i++ {
+		e := data[ataSMARTAttributeTableOffset+i*ataSMARTAttributeSize:]
+		id := e[0]
+		if id == 0 {
+			continue
+		}
+		value := e[3]
+		// The raw value is 48 bits, little-endian.
+		var rawBuf [8]byte
+		copy(rawBuf[:6], e[5:11])
+		raw := binary.LittleEndian.Uint64(rawBuf[:])
+
+		// A normalized value at or below a non-zero threshold means the
+		// attribute is failing.
+		if t, ok := thresholdByID[id]; ok && t != 0 && value <= t {
+			res.FailurePredicted = true
+		}
+
+		switch id {
+		case ataAttrReallocatedSectors:
+			res.ReallocatedSectors = &raw
+		case ataAttrPendingSectors:
+			res.PendingSectors = &raw
+		case ataAttrOfflineUncorrectable:
+			res.MediaErrors = &raw
+		case ataAttrTemperature, ataAttrAirflowTemperature:
+			// Only the lowest byte contains the current temperature, the
+			// others are vendor-specific (often min/max values).
+			if id == ataAttrAirflowTemperature && res.TemperatureCelsius != nil {
+				continue
+			}
+			temp := int(raw & 0xff)
+			res.TemperatureCelsius = &temp
+		}
+	}
+	res.evaluate()
+	return &res, nil
+}
@@ -0,0 +1,136 @@
+//go:build linux
+
+package blockdev
+
+import (
+	"fmt"
+
+	"source.monogon.dev/osbase/nvme"
+	"source.monogon.dev/osbase/scsi"
+)
+
+// ReadSMART reads health information from the physical disk backing the given
+// Device. NVMe disks are queried via their health log page, SATA disks via
+// ATA SMART commands tunneled through SCSI/ATA translation and all other SCSI
+// disks via their informational exceptions log page and grown defect list.
+//
+// The device must be a whole disk, not a partition or a virtual device.
+func ReadSMART(d *Device) (*SMARTData, error) {
+	nvmeDev, err := nvme.NewFromFd(d.backend)
+	if err != nil {
+		return nil, err
+	}
+	health, nvmeErr := nvmeDev.GetHealthInfo()
+	if nvmeErr == nil {
+		return nvmeSMART(health), nil
+	}
+
+	scsiDev, err := scsi.NewFromFd(d.backend)
+	if err != nil {
+		return nil, err
+	}
+	inquiry, err := scsiDev.Inquiry()
+	if err != nil {
+		return nil, fmt.Errorf("device speaks neither NVMe (%v) nor SCSI (%w)", nvmeErr, err)
+	}
+	// SAT-5 R8 Table 14
+	if inquiry.Vendor == "ATA" {
+		return ataSMART(scsiDev)
+	}
+	return scsiSMART(scsiDev)
+}
+
+func nvmeSMART(h *nvme.HealthInfo) *SMARTData {
+	res := SMARTData{
+		Protocol:         SMARTProtocolNVMe,
+		FailurePredicted: h.HasCriticalWarning(),
+	}
+	mediaErrors := h.MediaAndDataIntegrityErrors
+	res.MediaErrors = &mediaErrors
+	lifeUsed := h.LifeUsed
+	res.LifeUsed = &lifeUsed
+	if h.CompositeTemperatureKelvin != 0 {
+		temp := int(h.CompositeTemperatureKelvin) - 273
+		res.TemperatureCelsius = &temp
+	}
+	res.evaluate()
+	return &res
+}
+
+const (
+	ataCmdSMART           = 0xb0
+	ataSMARTReadData      = 0xd0
+	ataSMARTReadThreshold = 0xd1
+)
+
+// ataSMARTCommand issues an ATA SMART command with a single sector PIO data-in
+// transfer via ATA PASS-THROUGH (16).
+func ataSMARTCommand(d *scsi.Device, feature uint8) ([]byte, error) {
+	data := make([]byte, 512)
+	// SAT-5 Table 151, byte 0 (operation code) and 15 (control) are omitted.
+	var req [14]byte
+	// PROTOCOL: PIO Data-In
+	req[0] = 4 << 1
+	// T_DIR: from device, BYTE_BLOCK: transfer length in blocks,
+	// T_LENGTH: length in SECTOR COUNT field.
+	req[1] = 1<<3 | 1<<2 | 0b10
+	req[3] = feature
+	// SECTOR COUNT
+	req[5] = 1
+	// LBA MID/HIGH contain the SMART signature.
+	req[9] = 0x4f
+	req[11] = 0xc2
+	req[13] = ataCmdSMART
+	if err := d.RawCommand(&scsi.CommandDataBuffer{
+		OperationCode:         scsi.ATAPassThrough16Op,
+		Request:               req[:],
+		Data:                  data,
+		DataTransferDirection: scsi.DataTransferFromDevice,
+	}); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func ataSMART(d *scsi.Device) (*SMARTData, error) {
+	data, err := ataSMARTCommand(d, ataSMARTReadData)
+	if err != nil {
+		return nil, fmt.Errorf("while reading ATA SMART data: %w", err)
+	}
+	// Thresholds are obsolete since ATA-8, but still commonly available.
+	// Without them failure prediction is limited to the raw attributes.
+	thresholds, err := ataSMARTCommand(d, ataSMARTReadThreshold)
+	if err != nil {
+		thresholds = nil
+	}
+	return parseATASMART(data, thresholds)
+}
+
+func scsiSMART(d *scsi.Device) (*SMARTData, error) {
+	res := SMARTData{Protocol: SMARTProtocolSCSI}
+	ie, err := d.GetInformationalExceptions()
+	if err != nil {
+		return nil, fmt.Errorf("while reading informational exceptions: %w", err)
+	}
+	// Only FailurePredictionThresholdExceeded-class codes indicate an actual
+	// impending failure, Warning-class codes are commonly reported without
+	// any permanent issue.
+	res.FailurePredicted = ie.InformationalSenseCode.IsKey(scsi.FailurePredictionThresholdExceeded)
+	if ie.Temperature != 0 && ie.Temperature != 0xff {
+		temp := int(ie.Temperature)
+		res.TemperatureCelsius = &temp
+	}
+	if defects, err := d.ReadDefectDataLBA(false, true); err == nil {
+		n := uint64(len(defects))
+		res.ReallocatedSectors = &n
+	} else if defects, err := d.ReadDefectDataPhysical(false, true); err == nil {
+		n := uint64(len(defects))
+		res.ReallocatedSectors = &n
+	}
+	if mh, err := d.SolidStateMediaHealth(); err == nil {
+		used := float32(mh.PercentageUsedEnduranceIndicator) / 100.
+		res.LifeUsed = &used
+	}
+	res.evaluate()
+	return &res, nil
+}
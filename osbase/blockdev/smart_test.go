@@ -0,0 +1,178 @@
+package blockdev
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// ataSMARTFixture is the attribute table of a SMART READ DATA page, as read
+// from a spinning SATA disk with some values adjusted to exercise all parsed
+// attributes. Each row is an attribute entry: ID, flags (2 bytes), normalized
+// value, worst value, 48-bit little-endian raw value and a reserved byte.
+var ataSMARTFixture = [][ataSMARTAttributeSize]byte{
+	// Raw Read Error Rate
+	{0x01, 0x0f, 0x00, 0x75, 0x63, 0x10, 0x27, 0x4f, 0x0a, 0x00, 0x00, 0x00},
+	// Reallocated Sector Count: 8, reserved byte set.
+	{0x05, 0x33, 0x00, 0x64, 0x64, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff},
+	// Power-On Hours: 12345
+	{0x09, 0x32, 0x00, 0x5a, 0x5a, 0x39, 0x30, 0x00, 0x00, 0x00, 0x00, 0x00},
+	// Airflow Temperature: 40°C, min 20°C, max 45°C in the vendor bytes.
+	{0xbe, 0x22, 0x00, 0x3c, 0x32, 0x28, 0x14, 0x2d, 0x28, 0x00, 0x00, 0x00},
+	// Temperature: 36°C, vendor-specific data in the upper bytes.
+	{0xc2, 0x22, 0x00, 0x24, 0x32, 0x24, 0x00, 0x00, 0x00, 0x12, 0x00, 0x00},
+	// Current Pending Sector Count: 513
+	{0xc5, 0x12, 0x00, 0x64, 0x64, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00},
+	// Offline Uncorrectable: 1<<32, only the fifth raw byte set.
+	{0xc6, 0x10, 0x00, 0x64, 0x64, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00},
+}
+
+// ataSMARTThresholdsFixture is the threshold table of a SMART READ THRESHOLDS
+// page matching ataSMARTFixture. Each row is an ID followed by its threshold.
+var ataSMARTThresholdsFixture = [][2]byte{
+	{0x01, 0x06},
+	{0x05, 0x24},
+	{0x09, 0x00},
+	{0xbe, 0x00},
+	{0xc2, 0x00},
+	{0xc5, 0x00},
+	{0xc6, 0x00},
+}
+
+// makeATASMARTPages builds SMART READ DATA and READ THRESHOLDS pages from the
+// given attribute and threshold rows.
+func makeATASMARTPages(attrs [][ataSMARTAttributeSize]byte, thresholds [][2]byte) (data, thres []byte) {
+	data = make([]byte, 512)
+	thres = make([]byte, 512)
+	// Revision number
+	data[0], thres[0] = 0x10, 0x10
+	for i, a := range attrs {
+		copy(data[ataSMARTAttributeTableOffset+i*ataSMARTAttributeSize:], a[:])
+	}
+	for i, t := range thresholds {
+		copy(thres[ataSMARTAttributeTableOffset+i*ataSMARTAttributeSize:], t[:])
+	}
+	return
+}
+
+func TestParseATASMART(t *testing.T) {
+	ptrInt := func(v int) *int { return &v }
+	ptrUint64 := func(v uint64) *uint64 { return &v }
+
+	data, thresholds := makeATASMARTPages(ataSMARTFixture, ataSMARTThresholdsFixture)
+	// Without the Temperature attribute, Airflow Temperature is used.
+	var withoutTemp [][ataSMARTAttributeSize]byte
+	for _, a := range ataSMARTFixture {
+		if a[0] != ataAttrTemperature {
+			withoutTemp = append(withoutTemp, a)
+		}
+	}
+	airflowData, _ := makeATASMARTPages(withoutTemp, nil)
+	// Raw Read Error Rate at its threshold.
+	failing := append([][ataSMARTAttributeSize]byte{}, ataSMARTFixture...)
+	failing[0][3] = 0x06
+	failingData, _ := makeATASMARTPages(failing, nil)
+
+	for _, te := range []struct {
+		name       string
+		data       []byte
+		thresholds []byte
+		want       *SMARTData
+	}{
+		{
+			name:       "Fixture",
+			data:       data,
+			thresholds: thresholds,
+			want: &SMARTData{
+				Protocol:           SMARTProtocolATA,
+				TemperatureCelsius: ptrInt(36),
+				ReallocatedSectors: ptrUint64(8),
+				PendingSectors:     ptrUint64(513),
+				MediaErrors:        ptrUint64(1 << 32),
+				Degraded:           true,
+				DegradedReasons: []string{
+					"8 reallocated sectors",
+					"513 sectors pending reallocation",
+					"4294967296 media errors",
+				},
+			},
+		},
+		{
+			name: "AirflowTemperature",
+			data: airflowData,
+			want: &SMARTData{
+				Protocol:           SMARTProtocolATA,
+				TemperatureCelsius: ptrInt(40),
+				ReallocatedSectors: ptrUint64(8),
+				PendingSectors:     ptrUint64(513),
+				MediaErrors:        ptrUint64(1 << 32),
+				Degraded:           true,
+				DegradedReasons: []string{
+					"8 reallocated sectors",
+					"513 sectors pending reallocation",
+					"4294967296 media errors",
+				},
+			},
+		},
+		{
+			name:       "ThresholdReached",
+			data:       failingData,
+			thresholds: thresholds,
+			want: &SMARTData{
+				Protocol:           SMARTProtocolATA,
+				TemperatureCelsius: ptrInt(36),
+				ReallocatedSectors: ptrUint64(8),
+				PendingSectors:     ptrUint64(513),
+				MediaErrors:        ptrUint64(1 << 32),
+				FailurePredicted:   true,
+				Degraded:           true,
+				DegradedReasons: []string{
+					"disk predicts failure",
+					"8 reallocated sectors",
+					"513 sectors pending reallocation",
+					"4294967296 media errors",
+				},
+			},
+		},
+		{
+			// Without thresholds, failing normalized values go unnoticed.
+			name: "ThresholdReachedWithoutThresholds",
+			data: failingData,
+			want: &SMARTData{
+				Protocol:           SMARTProtocolATA,
+				TemperatureCelsius: ptrInt(36),
+				ReallocatedSectors: ptrUint64(8),
+				PendingSectors:     ptrUint64(513),
+				MediaErrors:        ptrUint64(1 << 32),
+				Degraded:           true,
+				DegradedReasons: []string{
+					"8 reallocated sectors",
+					"513 sectors pending reallocation",
+					"4294967296 media errors",
+				},
+			},
+		},
+		{
+			name: "Empty",
+			data: make([]byte, 512),
+			want: &SMARTData{Protocol: SMARTProtocolATA},
+		},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			got, err := parseATASMART(te.data, te.thresholds)
+			if err != nil {
+				t.Fatalf("parseATASMART: %v", err)
+			}
+			if diff := cmp.Diff(te.want, got); diff != "" {
+				t.Errorf("unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := parseATASMART(data[:100], nil); err == nil {
+		t.Errorf("wanted error on short data page")
+	}
+	if _, err := parseATASMART(data, thresholds[:100]); err == nil {
+		t.Errorf("wanted error on short thresholds page")
+	}
+}
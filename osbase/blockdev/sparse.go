@@ -0,0 +1,225 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockdev
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The sparse image format written by ExportSparse and read by ImportSparse
+// consists of a sparseHeader followed by any number of sparseRecords, each
+// followed by Length bytes of data to be placed at Offset. Records are ordered
+// by offset, do not overlap and are aligned to the block size. All regions of
+// the device not covered by records are zero. The image is terminated by a
+// record with a Length of zero. The entire image can optionally be gzip
+// compressed.
+
+// sparseMagic identifies a sparse image.
+var sparseMagic = [8]byte{'B', 'D', 'S', 'P', 'A', 'R', 'S', 'E'}
+
+type sparseHeader struct {
+	Magic      [8]byte
+	BlockSize  int64
+	BlockCount int64
+}
+
+type sparseRecord struct {
+	Offset int64
+	Length int64
+}
+
+// sparseChunkSize is the maximum amount of bytes read from a device at once
+// when exporting it.
+const sparseChunkSize = 4 * 1024 * 1024
+
+// dataFinder is implemented by block devices which can efficiently find
+// regions containing data, eg. files on filesystems supporting SEEK_DATA and
+// SEEK_HOLE.
+type dataFinder interface {
+	// nextData returns the first region at or after off which might contain
+	// data. If there is no further data, start is returned as -1.
+	nextData(off int64) (start, end int64, err error)
+}
+
+// ExportSparse writes the contents of a block device to w in a simple sparse
+// format which omits all blocks that are zero. For block devices backed by
+// files with holes, the holes are skipped without reading them. If compress is
+// set, the output is gzip compressed. Use ImportSparse to restore the image
+// onto a block device.
+func ExportSparse(d BlockDev, w io.Writer, compress bool) error {
+	if compress {
+		gw := gzip.NewWriter(w)
+		if err := exportSparse(d, gw); err != nil {
+			return err
+		}
+		return gw.Close()
+	}
+	bw := bufio.NewWriter(w)
+	if err := exportSparse(d, bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func exportSparse(d BlockDev, w io.Writer) error {
+	if d.BlockCount() < 0 {
+		return errors.New("cannot export block device with undefined size")
+	}
+	blockSize := d.BlockSize()
+	size := blockSize * d.BlockCount()
+	if err := binary.Write(w, binary.LittleEndian, &sparseHeader{
+		Magic:      sparseMagic,
+		BlockSize:  blockSize,
+		BlockCount: d.BlockCount(),
+	}); err != nil {
+		return fmt.Errorf("while writing header: %w", err)
+	}
+
+	bufSize := (sparseChunkSize / blockSize) * blockSize
+	if bufSize == 0 {
+		bufSize = blockSize
+	}
+	buf := make([]byte, bufSize)
+	zero := make([]byte, blockSize)
+	df, _ := d.(dataFinder)
+	for off := int64(0); off < size; {
+		// Find the next region which might contain data.
+		start, end := off, size
+		if df != nil {
+			var err error
+			start, end, err = df.nextData(off)
+			if err != nil {
+				return fmt.Errorf("while looking for data after %d: %w", off, err)
+			}
+			if start == -1 {
+				break
+			}
+			// Regions returned by the filesystem are not necessarily aligned
+			// to our block size.
+			start -= start % blockSize
+			end = min(end+(blockSize-end%blockSize)%blockSize, size)
+			if end <= off {
+				break
+			}
+		}
+
+		// Read the region chunk by chunk, writing out all non-zero blocks.
+		for pos := start; pos < end; {
+			chunk := buf[:min(bufSize, end-pos)]
+			if _, err := d.ReadAt(chunk, pos); err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("while reading at %d: %w", pos, err)
+			}
+			for i := int64(0); i < int64(len(chunk)); {
+				if bytes.Equal(chunk[i:i+blockSize], zero) {
+					i += blockSize
+					continue
+				}
+				j := i + blockSize
+				for j < int64(len(chunk)) && !bytes.Equal(chunk[j:j+blockSize], zero) {
+					j += blockSize
+				}
+				if err := binary.Write(w, binary.LittleEndian, &sparseRecord{
+					Offset: pos + i,
+					Length: j - i,
+				}); err != nil {
+					return fmt.Errorf("while writing record: %w", err)
+				}
+				if _, err := w.Write(chunk[i:j]); err != nil {
+					return fmt.Errorf("while writing data: %w", err)
+				}
+				i = j
+			}
+			pos += int64(len(chunk))
+		}
+		off = end
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &sparseRecord{Offset: size}); err != nil {
+		return fmt.Errorf("while writing final record: %w", err)
+	}
+	return nil
+}
+
+// ImportSparse reads a sparse image written by ExportSparse from r (either
+// compressed or not) and writes it to the given block device. The device must
+// have the same block size as the exported device and must be at least as
+// large. All regions of the device covered by the image but not contained in
+// it are zeroed.
+func ImportSparse(r io.Reader, d BlockDev) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("while opening compressed image: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	} else {
+		r = br
+	}
+
+	var hdr sparseHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return fmt.Errorf("while reading header: %w", err)
+	}
+	if hdr.Magic != sparseMagic {
+		return errors.New("not a sparse image (invalid magic)")
+	}
+	if hdr.BlockSize != d.BlockSize() {
+		return fmt.Errorf("image block size (%d) does not match device block size (%d)", hdr.BlockSize, d.BlockSize())
+	}
+	if hdr.BlockCount < 0 || (d.BlockCount() >= 0 && hdr.BlockCount > d.BlockCount()) {
+		return fmt.Errorf("image block count (%d) does not fit onto device (%d blocks)", hdr.BlockCount, d.BlockCount())
+	}
+	size := hdr.BlockSize * hdr.BlockCount
+
+	var pos int64
+	for {
+		var rec sparseRecord
+		if err := binary.Read(r, binary.LittleEndian, &rec); err != nil {
+			return fmt.Errorf("while reading record: %w", err)
+		}
+		if rec.Offset < pos || rec.Length < 0 || rec.Offset+rec.Length > size {
+			return fmt.Errorf("invalid record (offset %d, length %d)", rec.Offset, rec.Length)
+		}
+		if rec.Offset%hdr.BlockSize != 0 || rec.Length%hdr.BlockSize != 0 {
+			return fmt.Errorf("record (offset %d, length %d) not aligned to block size", rec.Offset, rec.Length)
+		}
+		// Zero the gap between the previous and this record.
+		if rec.Offset > pos {
+			if err := d.Zero(pos, rec.Offset); err != nil {
+				return fmt.Errorf("while zeroing %d-%d: %w", pos, rec.Offset, err)
+			}
+		}
+		if rec.Length == 0 {
+			if rec.Offset != size {
+				return fmt.Errorf("final record at %d does not match image size %d", rec.Offset, size)
+			}
+			return nil
+		}
+		if _, err := io.CopyN(io.NewOffsetWriter(d, rec.Offset), r, rec.Length); err != nil {
+			return fmt.Errorf("while writing record at %d: %w", rec.Offset, err)
+		}
+		pos = rec.Offset + rec.Length
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockdev
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// fillSparseTestDevice writes random data into a few non-contiguous regions
+// of d.
+func fillSparseTestDevice(t *testing.T, d BlockDev) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	for _, region := range []struct{ off, len int64 }{
+		{0, 512},
+		{4096, 3 * 512},
+		{1 << 20, 8192},
+		{d.BlockSize() * (d.BlockCount() - 1), 512},
+	} {
+		buf := make([]byte, region.len)
+		rng.Read(buf)
+		if _, err := d.WriteAt(buf, region.off); err != nil {
+			t.Fatalf("WriteAt(%d): %v", region.off, err)
+		}
+	}
+}
+
+func testSparseRoundtrip(t *testing.T, src BlockDev, compress bool) {
+	fillSparseTestDevice(t, src)
+
+	var img bytes.Buffer
+	if err := ExportSparse(src, &img, compress); err != nil {
+		t.Fatalf("ExportSparse: %v", err)
+	}
+	size := src.BlockSize() * src.BlockCount()
+	if int64(img.Len()) >= size/10 {
+		t.Errorf("sparse image is %d bytes, expected it to be much smaller than %d bytes", img.Len(), size)
+	}
+
+	// Fill the destination with garbage to check that gaps are zeroed.
+	dst := MustNewMemory(src.BlockSize(), src.BlockCount())
+	garbage := make([]byte, size)
+	for i := range garbage {
+		garbage[i] = 0xaa
+	}
+	if _, err := dst.WriteAt(garbage, 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if err := ImportSparse(&img, dst); err != nil {
+		t.Fatalf("ImportSparse: %v", err)
+	}
+
+	want := make([]byte, size)
+	if _, err := src.ReadAt(want, 0); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	got := make([]byte, size)
+	if _, err := dst.ReadAt(got, 0); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Error("imported device contents differ from exported device")
+	}
+}
+
+func TestSparseMemory(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		src := MustNewMemory(512, 8192)
+		testSparseRoundtrip(t, src, compress)
+	}
+}
+
+func TestSparseFile(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		src, err := CreateFile(filepath.Join(t.TempDir(), "src.img"), 512, 8192)
+		if err != nil {
+			t.Fatalf("CreateFile: %v", err)
+		}
+		testSparseRoundtrip(t, src, compress)
+		src.Close()
+	}
+}
+
+func TestSparseImportErrors(t *testing.T) {
+	src := MustNewMemory(512, 16)
+	var img bytes.Buffer
+	if err := ExportSparse(src, &img, false); err != nil {
+		t.Fatalf("ExportSparse: %v", err)
+	}
+
+	if err := ImportSparse(bytes.NewReader(img.Bytes()), MustNewMemory(4096, 16)); err == nil {
+		t.Error("import onto device with different block size succeeded")
+	}
+	if err := ImportSparse(bytes.NewReader(img.Bytes()), MustNewMemory(512, 8)); err == nil {
+		t.Error("import onto smaller device succeeded")
+	}
+	if err := ImportSparse(bytes.NewReader([]byte("garbage data which is not an image")), MustNewMemory(512, 16)); err == nil {
+		t.Error("import of garbage succeeded")
+	}
+	if err := ImportSparse(bytes.NewReader(img.Bytes()[:img.Len()-1]), MustNewMemory(512, 16)); err == nil {
+		t.Error("import of truncated image succeeded")
+	}
+}
@@ -112,6 +112,7 @@ const (
 	DM_READONLY_FLAG       = 1 << 0 /* In/Out */
 	DM_SUSPEND_FLAG        = 1 << 1 /* In/Out */
 	DM_PERSISTENT_DEV_FLAG = 1 << 3 /* In */
+	DM_BUFFER_FULL_FLAG    = 1 << 8 /* Out */
 )
 
 const baseDataSize = uint32(unsafe.Sizeof(DMIoctl{})) - 16384
@@ -188,13 +189,23 @@ func initCtrlFile() {
 	}
 }
 
-func GetVersion() (Version, error) {
-	req := newReq()
+// ioctl performs a devicemapper ioctl with the given command and request on
+// the control device. It is a variable so that tests can intercept ioctls.
+var ioctl = func(cmd uintptr, req *DMIoctl) error {
 	ctrlFileOnce.Do(initCtrlFile)
 	if ctrlFileError != nil {
-		return Version{}, ctrlFileError
+		return ctrlFileError
+	}
+	if _, _, err := unix.Syscall(unix.SYS_IOCTL, ctrlFile.Fd(), cmd, uintptr(unsafe.Pointer(req))); err != 0 {
+		return err
 	}
-	if _, _, err := unix.Syscall(unix.SYS_IOCTL, ctrlFile.Fd(), DM_VERSION_CMD, uintptr(unsafe.Pointer(&req))); err != 0 {
+	runtime.KeepAlive(req)
+	return nil
+}
+
+func GetVersion() (Version, error) {
+	req := newReq()
+	if err := ioctl(DM_VERSION_CMD, &req); err != nil {
 		return Version{}, err
 	}
 	return req.Version, nil
@@ -205,11 +216,7 @@ func CreateDevice(name string) (uint64, error) {
 	if err := stringToDelimitedBuf(req.Name[:], name); err != nil {
 		return 0, err
 	}
-	ctrlFileOnce.Do(initCtrlFile)
-	if ctrlFileError != nil {
-		return 0, ctrlFileError
-	}
-	if _, _, err := unix.Syscall(unix.SYS_IOCTL, ctrlFile.Fd(), DM_DEV_CREATE_CMD, uintptr(unsafe.Pointer(&req))); err != 0 {
+	if err := ioctl(DM_DEV_CREATE_CMD, &req); err != nil {
 		return 0, err
 	}
 	return req.Dev, nil
@@ -220,14 +227,9 @@ func RemoveDevice(name string) error {
 	if err := stringToDelimitedBuf(req.Name[:], name); err != nil {
 		return err
 	}
-	ctrlFileOnce.Do(initCtrlFile)
-	if ctrlFileError != nil {
-		return ctrlFileError
-	}
-	if _, _, err := unix.Syscall(unix.SYS_IOCTL, ctrlFile.Fd(), DM_DEV_REMOVE_CMD, uintptr(unsafe.Pointer(&req))); err != 0 {
+	if err := ioctl(DM_DEV_REMOVE_CMD, &req); err != nil {
 		return err
 	}
-	runtime.KeepAlive(req)
 	return nil
 }
 
@@ -291,14 +293,9 @@ func LoadTable(name string, readOnly bool, targets []Target) error {
 	if readOnly {
 		req.Flags = DM_READONLY_FLAG
 	}
-	ctrlFileOnce.Do(initCtrlFile)
-	if ctrlFileError != nil {
-		return ctrlFileError
-	}
-	if _, _, err := unix.Syscall(unix.SYS_IOCTL, ctrlFile.Fd(), DM_TABLE_LOAD_CMD, uintptr(unsafe.Pointer(&req))); err != 0 {
+	if err := ioctl(DM_TABLE_LOAD_CMD, &req); err != nil {
 		return err
 	}
-	runtime.KeepAlive(req)
 	return nil
 }
 
@@ -310,24 +307,77 @@ func suspendResume(name string, suspend bool) error {
 	if suspend {
 		req.Flags = DM_SUSPEND_FLAG
 	}
-	ctrlFileOnce.Do(initCtrlFile)
-	if ctrlFileError != nil {
-		return ctrlFileError
-	}
-	if _, _, err := unix.Syscall(unix.SYS_IOCTL, ctrlFile.Fd(), DM_DEV_SUSPEND_CMD, uintptr(unsafe.Pointer(&req))); err != 0 {
+	if err := ioctl(DM_DEV_SUSPEND_CMD, &req); err != nil {
 		return err
 	}
-	runtime.KeepAlive(req)
 	return nil
 }
 
+// Suspend suspends the named device. Outstanding I/O is flushed to the active
+// table, and new I/O is queued until the device is resumed.
 func Suspend(name string) error {
 	return suspendResume(name, true)
 }
+
+// Resume resumes the named device. If a table has been loaded into the
+// inactive slot of the device (ie. by LoadTable), it becomes the active table.
 func Resume(name string) error {
 	return suspendResume(name, false)
 }
 
+// ClearTable discards the inactive table of the named device, if any. The
+// active table is not affected.
+func ClearTable(name string) error {
+	req := newReq()
+	if err := stringToDelimitedBuf(req.Name[:], name); err != nil {
+		return err
+	}
+	if err := ioctl(DM_TABLE_CLEAR_CMD, &req); err != nil {
+		return err
+	}
+	return nil
+}
+
+// deviceFlags returns the flags of the named device.
+func deviceFlags(name string) (uint32, error) {
+	req := newReq()
+	if err := stringToDelimitedBuf(req.Name[:], name); err != nil {
+		return 0, err
+	}
+	if err := ioctl(DM_DEV_STATUS_CMD, &req); err != nil {
+		return 0, err
+	}
+	return req.Flags, nil
+}
+
+// ReloadTable replaces the active table of the named device with one made up of
+// the given targets, without removing the device. This can for example be used
+// to grow a device after its underlying storage has been enlarged. The new
+// table keeps the read-only flag of the device.
+//
+// The device is briefly suspended while the tables are swapped. If the new
+// table cannot be activated, the device keeps its previous table.
+func ReloadTable(name string, targets []Target) error {
+	flags, err := deviceFlags(name)
+	if err != nil {
+		return fmt.Errorf("DM_DEV_STATUS failed: %w", err)
+	}
+	if err := LoadTable(name, flags&DM_READONLY_FLAG != 0, targets); err != nil {
+		return fmt.Errorf("DM_TABLE_LOAD failed: %w", err)
+	}
+	if err := Suspend(name); err != nil {
+		_ = ClearTable(name)
+		return fmt.Errorf("DM_DEV_SUSPEND failed: %w", err)
+	}
+	if err := Resume(name); err != nil {
+		// Drop the new table and make sure the device doesn't stay suspended.
+		_ = ClearTable(name)
+		_ = Resume(name)
+		return fmt.Errorf("DM_DEV_SUSPEND (resume) failed: %w", err)
+	}
+	return nil
+}
+
 func CreateActiveDevice(name string, readOnly bool, targets []Target) (uint64, error) {
 	dev, err := CreateDevice(name)
 	if err != nil {
@@ -343,3 +393,62 @@ func CreateActiveDevice(name string, readOnly bool, targets []Target) (uint64, e
 	}
 	return dev, nil
 }
+
+// TargetStatus is the status of a single target of an active devicemapper
+// table, as returned by TableStatus.
+type TargetStatus struct {
+	// StartSector is the first sector (defined as being 512 bytes long) this
+	// target covers.
+	StartSector uint64
+	// Length is the number of sectors (defined as being 512 bytes long) this
+	// target covers, starting from StartSector.
+	Length uint64
+	// Type is the type of target handling this byte region.
+	Type string
+	// Status is the target-specific status string. Its format is documented
+	// per target type at @linux//Documentation/admin-guide/device-mapper.
+	Status string
+}
+
+// TableStatus returns the status of all targets of the active table of the
+// named device.
+func TableStatus(name string) ([]TargetStatus, error) {
+	req := newReq()
+	if err := stringToDelimitedBuf(req.Name[:], name); err != nil {
+		return nil, err
+	}
+	req.DataSize = uint32(unsafe.Sizeof(req))
+	if err := ioctl(DM_TABLE_STATUS_CMD, &req); err != nil {
+		return nil, err
+	}
+	if req.Flags&DM_BUFFER_FULL_FLAG != 0 {
+		return nil, errors.New("status too large for allocated memory")
+	}
+
+	// The kernel places the results at DataStart, with each target spec
+	// followed by its null-terminated status string. The next field of each
+	// spec contains the offset of the following spec relative to DataStart.
+	data := req.Data[req.DataStart-baseDataSize : req.DataSize-baseDataSize]
+	specSize := int(unsafe.Sizeof(DMTargetSpec{}))
+	res := make([]TargetStatus, 0, req.TargetCount)
+	var offset int
+	for i := uint32(0); i < req.TargetCount; i++ {
+		if offset+specSize > len(data) {
+			return nil, fmt.Errorf("target %d out of bounds", i)
+		}
+		var spec DMTargetSpec
+		if err := binary.Read(bytes.NewReader(data[offset:offset+specSize]), native_endian.NativeEndian(), &spec); err != nil {
+			return nil, fmt.Errorf("target %d: %w", i, err)
+		}
+		status, _, _ := bytes.Cut(data[offset+specSize:], []byte{0x00})
+		targetType, _, _ := bytes.Cut(spec.TargetType[:], []byte{0x00})
+		res = append(res, TargetStatus{
+			StartSector: spec.SectorStart,
+			Length:      spec.Length,
+			Type:        string(targetType),
+			Status:      string(status),
+		})
+		offset = int(spec.Next)
+	}
+	return res, nil
+}
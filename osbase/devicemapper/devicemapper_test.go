@@ -0,0 +1,125 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package devicemapper
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+)
+
+// fakeIoctl records all ioctls performed on a device with a given set of
+// flags, optionally failing one of them.
+type fakeIoctl struct {
+	// flags are returned by DM_DEV_STATUS.
+	flags uint32
+	// fail is the index of the ioctl which fails, or -1 if none does.
+	fail  int
+	calls []string
+}
+
+func (f *fakeIoctl) ioctl(cmd uintptr, req *DMIoctl) error {
+	name, _, _ := bytes.Cut(req.Name[:], []byte{0})
+	var call string
+	switch cmd {
+	case DM_DEV_STATUS_CMD:
+		call = "status"
+		req.Flags = f.flags
+	case DM_TABLE_LOAD_CMD:
+		call = "load"
+		if req.Flags&DM_READONLY_FLAG != 0 {
+			call += " ro"
+		}
+	case DM_TABLE_CLEAR_CMD:
+		call = "clear"
+	case DM_DEV_SUSPEND_CMD:
+		call = "resume"
+		if req.Flags&DM_SUSPEND_FLAG != 0 {
+			call = "suspend"
+		}
+	default:
+		call = "unknown"
+	}
+	f.calls = append(f.calls, call+" "+string(name))
+	if len(f.calls)-1 == f.fail {
+		return unix.EINVAL
+	}
+	return nil
+}
+
+func TestReloadTable(t *testing.T) {
+	targets := []Target{
+		{Length: 2048, Type: "linear", Parameters: []string{"/dev/vda", "0"}},
+	}
+	for _, te := range []struct {
+		name    string
+		flags   uint32
+		fail    int
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Success",
+			fail: -1,
+			want: []string{"status dev", "load dev", "suspend dev", "resume dev"},
+		},
+		{
+			name:  "ReadOnly",
+			flags: DM_READONLY_FLAG,
+			fail:  -1,
+			want:  []string{"status dev", "load ro dev", "suspend dev", "resume dev"},
+		},
+		{
+			name:    "LoadFails",
+			fail:    1,
+			want:    []string{"status dev", "load dev"},
+			wantErr: true,
+		},
+		{
+			// The new table must be dropped, so that a later resume doesn't
+			// activate it.
+			name:    "SuspendFails",
+			fail:    2,
+			want:    []string{"status dev", "load dev", "suspend dev", "clear dev"},
+			wantErr: true,
+		},
+		{
+			// The device must not be left suspended.
+			name:    "ResumeFails",
+			fail:    3,
+			want:    []string{"status dev", "load dev", "suspend dev", "resume dev", "clear dev", "resume dev"},
+			wantErr: true,
+		},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			f := &fakeIoctl{flags: te.flags, fail: te.fail}
+			oldIoctl := ioctl
+			ioctl = f.ioctl
+			defer func() { ioctl = oldIoctl }()
+
+			err := ReloadTable("dev", targets)
+			if (err != nil) != te.wantErr {
+				t.Errorf("wanted error %v, got %v", te.wantErr, err)
+			}
+			if diff := cmp.Diff(te.want, f.calls); diff != "" {
+				t.Errorf("unexpected ioctls (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -21,7 +21,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"path"
+	"sort"
 
 	"golang.org/x/sys/unix"
 )
@@ -36,6 +38,14 @@ type Writer struct {
 	// Close().
 	fixDirectoryEntry map[string][]direntFixupLocation
 	pathInodeMeta     map[string]*uncompressedInodeMeta
+	// hardlinks contains for each path created using Link() the path of the
+	// inode it links to. They are resolved on Close().
+	hardlinks map[string]string
+	// sharedXattrs maps extended attributes added using ShareXattr() (name and
+	// value separated by a NUL byte) to their id in sharedXattrArea, which is
+	// written on Close().
+	sharedXattrs    map[string]uint32
+	sharedXattrArea []byte
 	// legacyInodeIndex stores the next legacy (32-bit) inode to be allocated.
 	// 64 bit inodes are automatically calculated by EROFS on mount.
 	legacyInodeIndex    uint32
@@ -50,6 +60,8 @@ func NewWriter(w io.WriteSeeker) (*Writer, error) {
 		w:                 w,
 		fixDirectoryEntry: make(map[string][]direntFixupLocation),
 		pathInodeMeta:     make(map[string]*uncompressedInodeMeta),
+		hardlinks:         make(map[string]string),
+		sharedXattrs:      make(map[string]uint32),
 	}
 	_, err := erofsWriter.allocateMetadata(1024+binary.Size(&superblock{}), 0)
 	if err != nil {
@@ -116,6 +128,7 @@ func (w *Writer) create(pathname string, inode Inode) *uncompressedInodeWriter {
 		legacyInodeNumber: w.legacyInodeIndex,
 		pathname:          path.Clean(pathname),
 	}
+	i.xattrs, i.inode.XattrCount, i.err = w.encodeXattrs(inode.xattrs())
 	w.legacyInodeIndex++
 	return i
 }
@@ -149,10 +162,80 @@ func (w *Writer) Create(pathname string, inode Inode) error {
 	return iw.Close()
 }
 
+// Link adds a hard link at pathname to the non-directory inode created at
+// target. The target does not need to be created yet, but needs to exist when
+// calling Close(). Like other inodes, the given pathname needs to be referenced
+// by a directory, otherwise it will not be accessible.
+func (w *Writer) Link(pathname, target string) error {
+	pathname, target = path.Clean(pathname), path.Clean(target)
+	if _, ok := w.hardlinks[pathname]; ok {
+		return fmt.Errorf("link %q already exists", pathname)
+	}
+	if _, ok := w.pathInodeMeta[pathname]; ok {
+		return fmt.Errorf("inode at %q already exists", pathname)
+	}
+	w.hardlinks[pathname] = target
+	return nil
+}
+
+// resolveHardlinks points all paths created using Link() to their target
+// inodes and updates the link counts of these inodes.
+func (w *Writer) resolveHardlinks() error {
+	// Sort links for reproducibility.
+	links := make([]string, 0, len(w.hardlinks))
+	for link := range w.hardlinks {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	linkCount := make(map[*uncompressedInodeMeta]uint16)
+	var targets []*uncompressedInodeMeta
+	for _, link := range links {
+		target := w.hardlinks[link]
+		if _, ok := w.hardlinks[target]; ok {
+			return fmt.Errorf("link %q points to link %q", link, target)
+		}
+		if _, ok := w.pathInodeMeta[link]; ok {
+			return fmt.Errorf("inode at %q already exists, cannot link it to %q", link, target)
+		}
+		targetMeta, ok := w.pathInodeMeta[target]
+		if !ok {
+			return fmt.Errorf("link %q points to nonexistent inode %q", link, target)
+		}
+		if targetMeta.ftype == fileTypeDirectory {
+			return fmt.Errorf("link %q points to directory %q", link, target)
+		}
+		if _, ok := linkCount[targetMeta]; !ok {
+			linkCount[targetMeta] = 1
+			targets = append(targets, targetMeta)
+		}
+		if linkCount[targetMeta] == math.MaxUint16 {
+			return fmt.Errorf("too many links to %q", target)
+		}
+		linkCount[targetMeta]++
+		w.pathInodeMeta[link] = targetMeta
+	}
+	for _, targetMeta := range targets {
+		// HardlinkCount is at offset 6 into inodeCompact.
+		if _, err := w.w.Seek(int64(targetMeta.nid)*32+6, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to inode: %w", err)
+		}
+		if err := binary.Write(w.w, binary.LittleEndian, linkCount[targetMeta]); err != nil {
+			return fmt.Errorf("failed to write link count: %w", err)
+		}
+	}
+	return nil
+}
+
 // Close finishes writing an EROFS filesystem. Errors by this function need to
 // be handled as they indicate if the written filesystem is consistent (i.e.
 // there are no directory entries pointing to nonexistent inodes).
 func (w *Writer) Close() error {
+	if err := w.resolveHardlinks(); err != nil {
+		return err
+	}
+	if err := w.writeSharedXattrs(); err != nil {
+		return err
+	}
 	for targetPath, entries := range w.fixDirectoryEntry {
 		for _, entry := range entries {
 			targetMeta, ok := w.pathInodeMeta[targetPath]
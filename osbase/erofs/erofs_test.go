@@ -17,10 +17,12 @@
 package erofs
 
 import (
+	"encoding/binary"
 	"io"
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -243,6 +245,77 @@ func TestKernelInterop(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			name: "Hardlink",
+			setup: func(w *Writer) error {
+				if err := w.Create(".", &Directory{
+					Base:     Base{GID: 123, UID: 123, Permissions: 0755},
+					Children: []string{"a.bin", "b.bin"},
+				}); err != nil {
+					return err
+				}
+				writer := w.CreateFile("a.bin", &FileMeta{
+					Base: Base{GID: 123, UID: 124, Permissions: 0644},
+				})
+				if _, err := writer.Write([]byte("hello")); err != nil {
+					return err
+				}
+				if err := writer.Close(); err != nil {
+					return err
+				}
+				return w.Link("b.bin", "a.bin")
+			},
+			validate: func(t *testing.T) error {
+				var statA, statB unix.Stat_t
+				require.NoError(t, unix.Stat("/test/a.bin", &statA), "failed to stat file")
+				require.NoError(t, unix.Stat("/test/b.bin", &statB), "failed to stat link")
+				require.EqualValues(t, 2, statA.Nlink, "wrong link count")
+				require.Equal(t, statA.Ino, statB.Ino, "link points to different inode")
+				contents, err := os.ReadFile("/test/b.bin")
+				require.NoError(t, err, "failed to read link")
+				require.Equal(t, "hello", string(contents), "content not identical")
+				return nil
+			},
+		},
+		{
+			name: "Xattrs",
+			setup: func(w *Writer) error {
+				if err := w.ShareXattr("user.shared", "shared value"); err != nil {
+					return err
+				}
+				if err := w.Create(".", &Directory{
+					Base:     Base{GID: 123, UID: 123, Permissions: 0755},
+					Children: []string{"file"},
+				}); err != nil {
+					return err
+				}
+				writer := w.CreateFile("file", &FileMeta{
+					Base: Base{GID: 123, UID: 124, Permissions: 0644, Xattrs: map[string]string{
+						"user.shared": "shared value",
+						"user.inline": "inline value",
+					}},
+				})
+				if _, err := writer.Write([]byte("hello")); err != nil {
+					return err
+				}
+				return writer.Close()
+			},
+			validate: func(t *testing.T) error {
+				for name, want := range map[string]string{
+					"user.shared": "shared value",
+					"user.inline": "inline value",
+				} {
+					buf := make([]byte, 64)
+					n, err := unix.Getxattr("/test/file", name, buf)
+					require.NoError(t, err, "failed to get %s", name)
+					require.Equal(t, want, string(buf[:n]), "wrong value of %s", name)
+				}
+				contents, err := os.ReadFile("/test/file")
+				require.NoError(t, err, "failed to read file")
+				require.Equal(t, "hello", string(contents), "content not identical")
+				return nil
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -279,3 +352,64 @@ func TestKernelInterop(t *testing.T) {
 
 	}
 }
+
+func TestLink(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.img"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	w, err := NewWriter(f)
+	require.NoError(t, err)
+	require.NoError(t, w.Create(".", &Directory{
+		Base:     Base{Permissions: 0755},
+		Children: []string{"a.bin", "b.bin", "dir"},
+	}))
+	require.NoError(t, w.Create("dir", &Directory{
+		Base:     Base{Permissions: 0755},
+		Children: []string{"c.bin"},
+	}))
+	require.NoError(t, w.Link("dir/c.bin", "a.bin"))
+	fw := w.CreateFile("a.bin", &FileMeta{Base: Base{Permissions: 0644}})
+	require.NoError(t, fw.Close())
+	require.NoError(t, w.Link("b.bin", "a.bin"))
+	require.Error(t, w.Link("b.bin", "a.bin"), "duplicate link")
+	require.Error(t, w.Link("a.bin", "b.bin"), "link over existing inode")
+	require.NoError(t, w.Close())
+	require.NoError(t, Verify(f))
+
+	var nlink uint16
+	_, err = f.Seek(int64(w.pathInodeMeta["a.bin"].nid)*32+6, io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, binary.Read(f, binary.LittleEndian, &nlink))
+	require.EqualValues(t, 3, nlink, "wrong link count")
+}
+
+func TestLinkErrors(t *testing.T) {
+	for name, link := range map[string]func(w *Writer) error{
+		"Directory":   func(w *Writer) error { return w.Link("b.bin", ".") },
+		"Nonexistent": func(w *Writer) error { return w.Link("b.bin", "c.bin") },
+		"ToLink": func(w *Writer) error {
+			if err := w.Link("c.bin", "a.bin"); err != nil {
+				return err
+			}
+			return w.Link("b.bin", "c.bin")
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "test.img"))
+			require.NoError(t, err)
+			defer f.Close()
+
+			w, err := NewWriter(f)
+			require.NoError(t, err)
+			require.NoError(t, w.Create(".", &Directory{
+				Base:     Base{Permissions: 0755},
+				Children: []string{"a.bin", "b.bin"},
+			}))
+			fw := w.CreateFile("a.bin", &FileMeta{Base: Base{Permissions: 0644}})
+			require.NoError(t, fw.Close())
+			require.NoError(t, link(w))
+			require.Error(t, w.Close())
+		})
+	}
+}
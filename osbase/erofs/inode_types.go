@@ -32,6 +32,7 @@ import (
 // filesystem implement.
 type Inode interface {
 	inode() *inodeCompact
+	xattrs() map[string]string
 }
 
 // Base contains generic inode metadata independent from the specific inode
@@ -39,6 +40,15 @@ type Inode interface {
 type Base struct {
 	Permissions uint16
 	UID, GID    uint16
+	// Xattrs contains extended attributes of the inode, keyed by their full
+	// name (including the namespace prefix, e.g. "security.selinux"). Only
+	// the user, trusted and security namespaces as well as POSIX ACLs are
+	// supported.
+	Xattrs map[string]string
+}
+
+func (b *Base) xattrs() map[string]string {
+	return b.Xattrs
 }
 
 func (b *Base) baseInode(fileType uint16) *inodeCompact {
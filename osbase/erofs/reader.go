@@ -0,0 +1,349 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Reader provides read access to an EROFS filesystem without mounting it.
+//
+// Like Verify, Reader only supports the subset of EROFS that is produced by
+// Writer, ie. uncompressed images with compact inodes and a block size of
+// BlockSize. It does not check the filesystem for consistency, use Verify for
+// that.
+type Reader struct {
+	r  io.ReaderAt
+	sb superblock
+}
+
+// NewReader opens the EROFS filesystem in r.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	rd := &Reader{r: r}
+	raw := make([]byte, binary.Size(&superblock{}))
+	if _, err := r.ReadAt(raw, 1024); err != nil {
+		return nil, fmt.Errorf("cannot read superblock: %w", err)
+	}
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &rd.sb); err != nil {
+		return nil, fmt.Errorf("cannot parse superblock: %w", err)
+	}
+	if rd.sb.Magic != Magic {
+		return nil, fmt.Errorf("invalid superblock magic %x", rd.sb.Magic)
+	}
+	if rd.sb.BlockSizeBits != blockSizeBits {
+		return nil, fmt.Errorf("unsupported block size 2^%d", rd.sb.BlockSizeBits)
+	}
+	return rd, nil
+}
+
+// File is an inode in a filesystem opened by Reader. Its data can be read
+// using ReadAt. For symbolic links, the data is the link target.
+type File struct {
+	r     *Reader
+	nid   uint64
+	inode inodeCompact
+}
+
+// Size returns the size of the file data in bytes.
+func (f *File) Size() int64 {
+	return int64(f.inode.Size)
+}
+
+// Mode returns the type and permissions of the file.
+func (f *File) Mode() fs.FileMode {
+	mode := fs.FileMode(f.inode.Mode & 0777)
+	if f.inode.Mode&unix.S_ISUID != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if f.inode.Mode&unix.S_ISGID != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if f.inode.Mode&unix.S_ISVTX != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode | fileTypeToMode(unixModeToFT(f.inode.Mode))
+}
+
+// UID returns the owner user ID of the file.
+func (f *File) UID() uint16 {
+	return f.inode.UID
+}
+
+// GID returns the owner group ID of the file.
+func (f *File) GID() uint16 {
+	return f.inode.GID
+}
+
+// Xattrs returns the extended attributes of the file, keyed by their full
+// name.
+func (f *File) Xattrs() (map[string]string, error) {
+	size := xattrSize(f.inode.XattrCount)
+	if size == 0 {
+		return nil, nil
+	}
+	raw := make([]byte, size)
+	if _, err := f.r.r.ReadAt(raw, f.r.inodeLocation(f.nid)+int64(binary.Size(&inodeCompact{}))); err != nil {
+		return nil, fmt.Errorf("cannot read extended attributes: %w", err)
+	}
+	var hdr xattrHeaderRaw
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("cannot parse extended attribute header: %w", err)
+	}
+	raw = raw[binary.Size(&hdr):]
+	if len(raw) < int(hdr.SharedCount)*4 {
+		return nil, errors.New("shared extended attribute ids out of bounds")
+	}
+	xattrs := make(map[string]string)
+	for j := 0; j < int(hdr.SharedCount); j++ {
+		id := binary.LittleEndian.Uint32(raw[j*4:])
+		name, value, err := f.r.sharedXattr(id)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = value
+	}
+	raw = raw[int(hdr.SharedCount)*4:]
+	for len(raw) > 0 {
+		name, value, n, err := decodeXattr(raw)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = value
+		raw = raw[n:]
+	}
+	return xattrs, nil
+}
+
+// ReadAt implements io.ReaderAt for the file data.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	size := f.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if int64(want) > size-off {
+		p = p[:size-off]
+	}
+	// Data consists of full blocks starting at block Union, followed by the
+	// tail which is stored inline after the inode if the inode uses the inline
+	// layout.
+	blockBytes := size
+	if (f.inode.Format>>1)&0x7 == inodeFlatInline {
+		blockBytes = size - size%BlockSize
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		var src int64
+		var avail int64
+		if pos < blockBytes {
+			src = int64(f.inode.Union)*BlockSize + pos
+			avail = blockBytes - pos
+		} else {
+			src = f.r.inodeLocation(f.nid) + int64(binary.Size(&inodeCompact{})) + xattrSize(f.inode.XattrCount) + pos - blockBytes
+			avail = size - pos
+		}
+		chunk := p[n:min(int64(len(p)), int64(n)+avail)]
+		read, err := f.r.r.ReadAt(chunk, src)
+		n += read
+		if read < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if n < want {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// DirEntry is an entry of a directory as returned by Reader.Readdir.
+type DirEntry struct {
+	Name string
+	// Type contains the type bits of the entry's mode.
+	Type fs.FileMode
+}
+
+// Open returns the inode at the given path. Symbolic links are not followed.
+func (r *Reader) Open(p string) (*File, error) {
+	f, err := r.inode(uint64(r.sb.RootNodeNumber))
+	if err != nil {
+		return nil, err
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return f, nil
+	}
+	for _, name := range strings.Split(p[1:], "/") {
+		if !f.Mode().IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: p, Err: unix.ENOTDIR}
+		}
+		entries, err := r.readdir(f)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+		}
+		var found bool
+		for _, e := range entries {
+			if e.name == name {
+				if f, err = r.inode(e.NodeNumber); err != nil {
+					return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+		}
+	}
+	return f, nil
+}
+
+// Readdir returns the entries of the directory at the given path, excluding
+// "." and "..", sorted by name.
+func (r *Reader) Readdir(p string) ([]DirEntry, error) {
+	f, err := r.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if !f.Mode().IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: unix.ENOTDIR}
+	}
+	entries, err := r.readdir(f)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: err}
+	}
+	var out []DirEntry
+	for _, e := range entries {
+		if e.name == "." || e.name == ".." {
+			continue
+		}
+		out = append(out, DirEntry{Name: e.name, Type: fileTypeToMode(e.FileType)})
+	}
+	return out, nil
+}
+
+// Readlink returns the target of the symbolic link at the given path.
+func (r *Reader) Readlink(p string) (string, error) {
+	f, err := r.Open(p)
+	if err != nil {
+		return "", err
+	}
+	if f.Mode().Type() != fs.ModeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: unix.EINVAL}
+	}
+	target := make([]byte, f.Size())
+	if _, err := f.ReadAt(target, 0); err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: err}
+	}
+	return string(target), nil
+}
+
+// inodeLocation returns the byte offset of the inode with the given nid.
+func (r *Reader) inodeLocation(nid uint64) int64 {
+	return int64(r.sb.MetaStartAddr)*BlockSize + int64(nid)*32
+}
+
+// inode reads the inode with the given nid.
+func (r *Reader) inode(nid uint64) (*File, error) {
+	raw := make([]byte, binary.Size(&inodeCompact{}))
+	if _, err := r.r.ReadAt(raw, r.inodeLocation(nid)); err != nil {
+		return nil, fmt.Errorf("cannot read inode %d: %w", nid, err)
+	}
+	f := &File{r: r, nid: nid}
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &f.inode); err != nil {
+		return nil, fmt.Errorf("cannot parse inode %d: %w", nid, err)
+	}
+	if f.inode.Format&1 != 0 {
+		return nil, fmt.Errorf("inode %d: extended inodes are unsupported", nid)
+	}
+	switch layout := (f.inode.Format >> 1) & 0x7; layout {
+	case inodeFlatPlain, inodeFlatInline:
+	default:
+		return nil, fmt.Errorf("inode %d: unsupported data layout %d", nid, layout)
+	}
+	return f, nil
+}
+
+// readdir reads all entries of the given directory.
+func (r *Reader) readdir(dir *File) ([]directoryEntry, error) {
+	content := make([]byte, dir.Size())
+	if _, err := dir.ReadAt(content, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var entries []directoryEntry
+	for blockStart := 0; blockStart < len(content); blockStart += BlockSize {
+		block := content[blockStart:min(blockStart+BlockSize, len(content))]
+		blockEntries, err := parseDirectoryBlock(block)
+		if err != nil {
+			return nil, fmt.Errorf("directory block %d: %w", blockStart/BlockSize, err)
+		}
+		entries = append(entries, blockEntries...)
+	}
+	return entries, nil
+}
+
+// fileTypeToMode maps an EROFS file type to the type bits of a fs.FileMode.
+func fileTypeToMode(ft uint8) fs.FileMode {
+	switch ft {
+	case fileTypeDirectory:
+		return fs.ModeDir
+	case fileTypeCharacterDevice:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case fileTypeBlockDevice:
+		return fs.ModeDevice
+	case fileTypeFIFO:
+		return fs.ModeNamedPipe
+	case fileTypeSocket:
+		return fs.ModeSocket
+	case fileTypeSymbolicLink:
+		return fs.ModeSymlink
+	}
+	return 0
+}
+
+// sharedXattr reads the extended attribute with the given id from the shared
+// extended attribute area.
+func (r *Reader) sharedXattr(id uint32) (name, value string, err error) {
+	pos := int64(r.sb.SharedXattrStartAddr)*BlockSize + int64(id)*4
+	var e xattrEntryRaw
+	raw := make([]byte, binary.Size(&e))
+	if _, err := r.r.ReadAt(raw, pos); err != nil {
+		return "", "", fmt.Errorf("cannot read shared extended attribute %d: %w", id, err)
+	}
+	binary.Read(bytes.NewReader(raw), binary.LittleEndian, &e)
+	raw = make([]byte, len(raw)+int(e.NameLength)+int(e.ValueSize))
+	if _, err := r.r.ReadAt(raw, pos); err != nil {
+		return "", "", fmt.Errorf("cannot read shared extended attribute %d: %w", id, err)
+	}
+	name, value, _, err = decodeXattr(raw)
+	return name, value, err
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"io"
+	"io/fs"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	f, _ := writeVerifyTestImage(t)
+	r, err := NewReader(f)
+	require.NoError(t, err)
+
+	entries, err := r.Readdir("/")
+	require.NoError(t, err)
+	require.Equal(t, []DirEntry{
+		{Name: "dir", Type: fs.ModeDir},
+		{Name: "large.bin", Type: 0},
+		{Name: "link", Type: fs.ModeSymlink},
+		{Name: "small.bin", Type: 0},
+		{Name: "ttyS0", Type: fs.ModeDevice | fs.ModeCharDevice},
+	}, entries)
+
+	entries, err = r.Readdir("dir")
+	require.NoError(t, err)
+	require.Equal(t, []DirEntry{{Name: "fifo", Type: fs.ModeNamedPipe}}, entries)
+
+	for name, size := range map[string]int64{"large.bin": 6500, "small.bin": 128} {
+		file, err := r.Open(name)
+		require.NoError(t, err)
+		require.Equal(t, size, file.Size())
+		require.Equal(t, fs.FileMode(0644), file.Mode())
+		want, err := io.ReadAll(io.LimitReader(rand.New(rand.NewSource(size)), size))
+		require.NoError(t, err)
+		got, err := io.ReadAll(io.NewSectionReader(file, 0, file.Size()))
+		require.NoError(t, err)
+		require.Equal(t, want, got, "%s: contents differ", name)
+
+		buf := make([]byte, 100)
+		if size > BlockSize {
+			// Read across the boundary between blocks and inline data.
+			n, err := file.ReadAt(buf, BlockSize-50)
+			require.NoError(t, err)
+			require.Equal(t, 100, n)
+			require.Equal(t, want[BlockSize-50:BlockSize+50], buf)
+		}
+		// Read across the end of the file.
+		n, err := file.ReadAt(buf, size-50)
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, 50, n)
+		require.Equal(t, want[size-50:], buf[:n])
+	}
+
+	target, err := r.Readlink("link")
+	require.NoError(t, err)
+	require.Equal(t, "small.bin", target)
+
+	dev, err := r.Open("/ttyS0")
+	require.NoError(t, err)
+	require.Equal(t, fs.ModeDevice|fs.ModeCharDevice|0600, dev.Mode())
+
+	_, err = r.Open("dir/nonexistent")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = r.Open("small.bin/foo")
+	require.Error(t, err)
+	_, err = r.Readdir("small.bin")
+	require.Error(t, err)
+	_, err = r.Readlink("small.bin")
+	require.Error(t, err)
+}
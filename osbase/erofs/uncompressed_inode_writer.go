@@ -36,6 +36,11 @@ type uncompressedInodeWriter struct {
 	writtenBytes      int
 	legacyInodeNumber uint32
 	pathname          string
+	// xattrs contains the encoded inline extended attribute area which is
+	// written directly after the inode.
+	xattrs []byte
+	// err is returned on Close() if set.
+	err error
 }
 
 func (i *uncompressedInodeWriter) allocateBlock() error {
@@ -79,7 +84,10 @@ func (i *uncompressedInodeWriter) Close() error {
 	if i.buf.Len() > BlockSize {
 		panic("programming error")
 	}
-	inodeSize := binary.Size(i.inode)
+	if i.err != nil {
+		return i.err
+	}
+	inodeSize := binary.Size(i.inode) + len(i.xattrs)
 	if i.buf.Len()+inodeSize > BlockSize {
 		// Can't fit last part of data inline, write it in its own block.
 		if err := i.flush(i.buf.Len()); err != nil {
@@ -110,13 +118,16 @@ func (i *uncompressedInodeWriter) Close() error {
 		ftype:        unixModeToFT(i.inode.Mode),
 		blockStart:   int64(i.baseBlock),
 		blockLength:  (int64(i.writtenBytes) / BlockSize) * BlockSize,
-		inlineStart:  basePos + 32,
+		inlineStart:  basePos + int64(inodeSize),
 		inlineLength: int64(i.buf.Len()),
 		writer:       i.writer,
 	}
 	if err := binary.Write(i.writer.w, binary.LittleEndian, &i.inode); err != nil {
 		return err
 	}
+	if _, err := i.writer.w.Write(i.xattrs); err != nil {
+		return err
+	}
 	if i.inode.Format&(inodeFlatInline<<1) != 0 {
 		// Data colocated in inode, if any.
 		_, err := i.writer.w.Write(i.buf.Bytes())
@@ -0,0 +1,405 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Verify checks the structural consistency of the EROFS filesystem in r. It
+// checks the superblock, walks the directory tree starting at the root
+// directory and checks that all inodes, directory entries and referenced data
+// blocks are present and well-formed. All found problems are returned joined
+// into a single error, nil is returned if no problems were found.
+//
+// Verify only supports the subset of EROFS that is produced by Writer, ie.
+// uncompressed images with compact inodes and a block size of BlockSize.
+func Verify(r io.ReaderAt) error {
+	v := verifier{
+		r:          r,
+		references: make(map[uint64]int),
+		inodes:     make(map[uint64]*verifiedInode),
+	}
+	if err := v.run(); err != nil {
+		v.problems = append(v.problems, err)
+	}
+	return errors.Join(v.problems...)
+}
+
+// verifier holds the state of a single Verify call.
+type verifier struct {
+	r  io.ReaderAt
+	sb superblock
+
+	// problems are all problems found so far which did not prevent further
+	// verification.
+	problems []error
+	// references counts the number of directory entries (excluding "." and "..")
+	// which point to each nid.
+	references map[uint64]int
+	// inodes contains all inodes which have been verified so far, keyed by nid.
+	inodes map[uint64]*verifiedInode
+}
+
+// verifiedInode is an inode which has been read and verified, along with some
+// metadata required to verify references to it.
+type verifiedInode struct {
+	inodeCompact
+	// path is the first path at which this inode was found.
+	path string
+	// ok is true if the inode itself was well-formed.
+	ok bool
+}
+
+func (v *verifier) problemf(format string, args ...any) {
+	v.problems = append(v.problems, fmt.Errorf(format, args...))
+}
+
+// readAt reads exactly n bytes at offset off from the image.
+func (v *verifier) readAt(off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	read, err := v.r.ReadAt(buf, off)
+	if read == n {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// run performs the verification. Errors returned by it are problems which
+// prevent any further verification.
+func (v *verifier) run() error {
+	raw, err := v.readAt(1024, binary.Size(&superblock{}))
+	if err != nil {
+		return fmt.Errorf("cannot read superblock: %w", err)
+	}
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &v.sb); err != nil {
+		return fmt.Errorf("cannot parse superblock: %w", err)
+	}
+	if v.sb.Magic != Magic {
+		return fmt.Errorf("invalid superblock magic %x", v.sb.Magic)
+	}
+	if v.sb.BlockSizeBits != blockSizeBits {
+		return fmt.Errorf("unsupported block size 2^%d", v.sb.BlockSizeBits)
+	}
+	if v.sb.FeaturesIncompatible != 0 {
+		return fmt.Errorf("unsupported incompatible features %#x", v.sb.FeaturesIncompatible)
+	}
+
+	root := uint64(v.sb.RootNodeNumber)
+	rootInode := v.inode(root, ".")
+	if rootInode == nil {
+		return fmt.Errorf("cannot read root directory")
+	}
+	if rootInode.Mode&modeTypeMask != modeTypeDirectory {
+		return fmt.Errorf("root inode is not a directory")
+	}
+
+	// Walk all directories breadth-first, remembering the parent of each
+	// directory to verify ".." entries.
+	type queued struct {
+		nid, parent uint64
+	}
+	queue := []queued{{root, root}}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		for _, child := range v.directory(dir.nid, dir.parent) {
+			queue = append(queue, queued{child, dir.nid})
+		}
+	}
+
+	// Check that the link counts of all non-directory inodes are consistent
+	// with the directory entries pointing to them. Directory link counts are
+	// not checked, as they are not used by the kernel.
+	nids := make([]uint64, 0, len(v.inodes))
+	for nid := range v.inodes {
+		nids = append(nids, nid)
+	}
+	sort.Slice(nids, func(i, j int) bool { return nids[i] < nids[j] })
+	for _, nid := range nids {
+		i := v.inodes[nid]
+		if !i.ok || i.Mode&modeTypeMask == modeTypeDirectory {
+			continue
+		}
+		if got, want := v.references[nid], int(i.HardlinkCount); got != want {
+			v.problemf("%s: inode has link count %d, but %d directory entries point to it", i.path, want, got)
+		}
+	}
+	return nil
+}
+
+const (
+	// modeTypeMask and modeTypeDirectory are S_IFMT and S_IFDIR, duplicated
+	// here to not depend on the host's definitions.
+	modeTypeMask      = 0170000
+	modeTypeDirectory = 0040000
+)
+
+// inodeLocation returns the byte offset of the inode with the given nid.
+func (v *verifier) inodeLocation(nid uint64) int64 {
+	return int64(v.sb.MetaStartAddr)*BlockSize + int64(nid)*32
+}
+
+// inode reads and verifies the inode with the given nid, first found at path
+// p. It returns nil if the inode cannot be read at all, otherwise the inode
+// with ok set if it is well-formed. Inodes are only verified once.
+func (v *verifier) inode(nid uint64, p string) *verifiedInode {
+	if i, ok := v.inodes[nid]; ok {
+		return i
+	}
+	raw, err := v.readAt(v.inodeLocation(nid), binary.Size(&inodeCompact{}))
+	if err != nil {
+		v.problemf("%s: cannot read inode %d: %w", p, nid, err)
+		return nil
+	}
+	i := &verifiedInode{path: p}
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &i.inodeCompact); err != nil {
+		v.problemf("%s: cannot parse inode %d: %w", p, nid, err)
+		return nil
+	}
+	v.inodes[nid] = i
+
+	if i.Format&1 != 0 {
+		v.problemf("%s: extended inodes are unsupported", p)
+		return i
+	}
+	if unixModeToFT(i.Mode) == fileTypeUnknown {
+		v.problemf("%s: invalid file type in mode %#o", p, i.Mode)
+		return i
+	}
+	switch layout := (i.Format >> 1) & 0x7; layout {
+	case inodeFlatPlain, inodeFlatInline:
+	default:
+		v.problemf("%s: unsupported data layout %d", p, layout)
+		return i
+	}
+	if i.HardlinkCount == 0 {
+		v.problemf("%s: inode has a link count of zero", p)
+	}
+	switch unixModeToFT(i.Mode) {
+	case fileTypeCharacterDevice, fileTypeBlockDevice, fileTypeFIFO, fileTypeSocket:
+		if i.Size != 0 {
+			v.problemf("%s: special file has non-zero size %d", p, i.Size)
+			return i
+		}
+	case fileTypeSymbolicLink:
+		if i.Size == 0 {
+			v.problemf("%s: symbolic link has an empty target", p)
+			return i
+		}
+	}
+	if _, err := v.data(nid, i, false); err != nil {
+		v.problemf("%s: %w", p, err)
+		return i
+	}
+	i.ok = true
+	return i
+}
+
+// xattrSize returns the size of the inline extended attribute area of an
+// inode, see erofs_xattr_ibody_size.
+func xattrSize(count uint16) int64 {
+	if count == 0 {
+		return 0
+	}
+	return 12 + (int64(count)-1)*4
+}
+
+// data checks that all data of the given inode is present. If read is true,
+// it also reads and returns the data.
+func (v *verifier) data(nid uint64, i *verifiedInode, read bool) ([]byte, error) {
+	size := int64(i.Size)
+	var blocks, tail int64
+	if (i.Format>>1)&0x7 == inodeFlatInline {
+		blocks = size / BlockSize
+		tail = size % BlockSize
+	} else {
+		blocks = (size + BlockSize - 1) / BlockSize
+	}
+
+	var out []byte
+	if blocks > 0 {
+		start := int64(i.Union)
+		if start == 0 {
+			return nil, fmt.Errorf("data blocks start at the superblock")
+		}
+		if v.sb.Blocks != 0 && start+blocks > int64(v.sb.Blocks) {
+			return nil, fmt.Errorf("data blocks %d-%d beyond end of filesystem (%d blocks)", start, start+blocks-1, v.sb.Blocks)
+		}
+		if read {
+			// Only read the part of the last block which is part of the file.
+			buf, err := v.readAt(start*BlockSize, int(size-tail))
+			if err != nil {
+				return nil, fmt.Errorf("cannot read data blocks %d-%d: %w", start, start+blocks-1, err)
+			}
+			out = append(out, buf...)
+		} else if _, err := v.readAt((start+blocks)*BlockSize-1, 1); err != nil {
+			return nil, fmt.Errorf("data blocks %d-%d missing: %w", start, start+blocks-1, err)
+		}
+	}
+	if tail > 0 {
+		// Inline data directly follows the inode and its extended attributes and
+		// must not cross a block boundary.
+		start := v.inodeLocation(nid) + int64(binary.Size(&inodeCompact{})) + xattrSize(i.XattrCount)
+		if start%BlockSize+tail > BlockSize {
+			return nil, fmt.Errorf("inline data crosses block boundary")
+		}
+		buf, err := v.readAt(start, int(tail))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read inline data: %w", err)
+		}
+		out = append(out, buf...)
+	}
+	return out, nil
+}
+
+// directory verifies the directory with the given nid and its entries. It
+// returns the nids of all subdirectories which have not been visited yet.
+func (v *verifier) directory(nid, parent uint64) []uint64 {
+	dir := v.inodes[nid]
+	if dir == nil || !dir.ok {
+		return nil
+	}
+	content, err := v.data(nid, dir, true)
+	if err != nil {
+		v.problemf("%s: %w", dir.path, err)
+		return nil
+	}
+
+	var subdirs []uint64
+	var seenDot, seenDotDot bool
+	var lastName string
+	var haveLast bool
+	// Directory entries are stored per block, with each block containing an
+	// array of entries followed by their names.
+	for blockStart := 0; blockStart < len(content); blockStart += BlockSize {
+		block := content[blockStart:min(blockStart+BlockSize, len(content))]
+		entries, err := parseDirectoryBlock(block)
+		if err != nil {
+			v.problemf("%s: directory block %d: %w", dir.path, blockStart/BlockSize, err)
+			return subdirs
+		}
+		for _, e := range entries {
+			// Entries must be sorted, as the kernel looks them up by binary
+			// search.
+			if haveLast && e.name <= lastName {
+				v.problemf("%s: directory entry %q is out of order", dir.path, e.name)
+			}
+			lastName, haveLast = e.name, true
+
+			switch e.name {
+			case ".":
+				seenDot = true
+				if e.NodeNumber != nid {
+					v.problemf("%s: \".\" points to inode %d instead of itself", dir.path, e.NodeNumber)
+				}
+				continue
+			case "..":
+				seenDotDot = true
+				if e.NodeNumber != parent {
+					v.problemf("%s: \"..\" points to inode %d instead of its parent %d", dir.path, e.NodeNumber, parent)
+				}
+				continue
+			}
+			p := path.Join(dir.path, e.name)
+			if e.NodeNumber == nid || e.NodeNumber == parent {
+				v.problemf("%s: directory entry points to a parent directory", p)
+				continue
+			}
+			_, visited := v.inodes[e.NodeNumber]
+			v.references[e.NodeNumber] += 1
+			target := v.inode(e.NodeNumber, p)
+			if target == nil || !target.ok {
+				continue
+			}
+			if ft := unixModeToFT(target.Mode); ft != e.FileType {
+				v.problemf("%s: directory entry has file type %d, but inode has file type %d", p, e.FileType, ft)
+			}
+			if target.Mode&modeTypeMask == modeTypeDirectory {
+				if visited {
+					v.problemf("%s: directory is also reachable as %s", p, target.path)
+					continue
+				}
+				subdirs = append(subdirs, e.NodeNumber)
+			}
+		}
+	}
+	if !seenDot {
+		v.problemf("%s: directory is missing \".\" entry", dir.path)
+	}
+	if !seenDotDot {
+		v.problemf("%s: directory is missing \"..\" entry", dir.path)
+	}
+	return subdirs
+}
+
+// directoryEntry is a parsed directory entry.
+type directoryEntry struct {
+	directoryEntryRaw
+	name string
+}
+
+// parseDirectoryBlock parses a single block of directory entries.
+func parseDirectoryBlock(block []byte) ([]directoryEntry, error) {
+	entrySize := binary.Size(directoryEntryRaw{})
+	if len(block) < entrySize {
+		return nil, fmt.Errorf("block too small for a single entry")
+	}
+	// The name of the first entry directly follows the entry array, thus its
+	// offset determines the number of entries.
+	firstNameOffset := int(binary.LittleEndian.Uint16(block[8:10]))
+	if firstNameOffset%entrySize != 0 || firstNameOffset == 0 || firstNameOffset > len(block) {
+		return nil, fmt.Errorf("invalid name offset %d of first entry", firstNameOffset)
+	}
+	raw := make([]directoryEntryRaw, firstNameOffset/entrySize)
+	if err := binary.Read(bytes.NewReader(block), binary.LittleEndian, raw); err != nil {
+		return nil, fmt.Errorf("cannot parse entries: %w", err)
+	}
+	entries := make([]directoryEntry, len(raw))
+	for i, e := range raw {
+		start := int(e.NameStartOffset)
+		end := len(block)
+		if i+1 < len(raw) {
+			end = int(raw[i+1].NameStartOffset)
+		}
+		if start < firstNameOffset || start >= end || end > len(block) {
+			return nil, fmt.Errorf("entry %d has invalid name bounds %d-%d", i, start, end)
+		}
+		// The last name in a block can be padded with NUL bytes.
+		name := string(block[start:end])
+		if i+1 == len(raw) {
+			if n := bytes.IndexByte(block[start:end], 0); n != -1 {
+				name = name[:n]
+			}
+		}
+		if name == "" || strings.ContainsAny(name, "/\x00") {
+			return nil, fmt.Errorf("entry %d has invalid name %q", i, name)
+		}
+		entries[i] = directoryEntry{directoryEntryRaw: e, name: name}
+	}
+	return entries, nil
+}
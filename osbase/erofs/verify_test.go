@@ -0,0 +1,141 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeVerifyTestImage writes a small filesystem containing all supported
+// inode types into a new file and returns the file along with the writer used
+// to create it.
+func writeVerifyTestImage(t *testing.T) (*os.File, *Writer) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.img"))
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	w, err := NewWriter(f)
+	require.NoError(t, err)
+	require.NoError(t, w.Create(".", &Directory{
+		Base:     Base{Permissions: 0755},
+		Children: []string{"dir", "large.bin", "link", "small.bin", "ttyS0"},
+	}))
+	require.NoError(t, w.Create("dir", &Directory{
+		Base:     Base{Permissions: 0755},
+		Children: []string{"fifo"},
+	}))
+	require.NoError(t, w.Create("dir/fifo", &FIFO{Base: Base{Permissions: 0644}}))
+	for name, size := range map[string]int64{"large.bin": 6500, "small.bin": 128} {
+		fw := w.CreateFile(name, &FileMeta{Base: Base{Permissions: 0644}})
+		_, err := io.CopyN(fw, rand.New(rand.NewSource(size)), size)
+		require.NoError(t, err)
+		require.NoError(t, fw.Close())
+	}
+	require.NoError(t, w.Create("link", &SymbolicLink{
+		Base:   Base{Permissions: 0777},
+		Target: "small.bin",
+	}))
+	require.NoError(t, w.Create("ttyS0", &CharacterDevice{
+		Base:  Base{Permissions: 0600},
+		Major: 4,
+		Minor: 64,
+	}))
+	require.NoError(t, w.Close())
+	return f, w
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		// corrupt modifies the written image, or nil to keep it intact.
+		corrupt func(t *testing.T, f *os.File, w *Writer)
+		// wantErr is a substring of the expected error, or empty if the image is
+		// expected to be valid.
+		wantErr string
+	}{
+		{
+			name: "Valid",
+		},
+		{
+			name: "BadMagic",
+			corrupt: func(t *testing.T, f *os.File, w *Writer) {
+				_, err := f.WriteAt([]byte{0, 0, 0, 0}, 1024)
+				require.NoError(t, err)
+			},
+			wantErr: "invalid superblock magic",
+		},
+		{
+			name: "MissingDataBlock",
+			corrupt: func(t *testing.T, f *os.File, w *Writer) {
+				meta := w.pathInodeMeta["large.bin"]
+				require.NoError(t, f.Truncate(meta.blockStart*BlockSize))
+			},
+			wantErr: "large.bin: data blocks",
+		},
+		{
+			name: "WrongFileType",
+			corrupt: func(t *testing.T, f *os.File, w *Writer) {
+				// Entries of the root directory are sorted, the third one is
+				// "dir". Overwrite its file type.
+				meta := w.pathInodeMeta["."]
+				_, err := f.WriteAt([]byte{fileTypeRegularFile}, meta.inlineStart+2*12+10)
+				require.NoError(t, err)
+			},
+			wantErr: "dir: directory entry has file type",
+		},
+		{
+			name: "DanglingEntry",
+			corrupt: func(t *testing.T, f *os.File, w *Writer) {
+				// Point "dir/fifo" to an inode beyond the end of the image.
+				meta := w.pathInodeMeta["dir"]
+				_, err := f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, meta.inlineStart+2*12)
+				require.NoError(t, err)
+			},
+			wantErr: "dir/fifo: cannot read inode",
+		},
+		{
+			name: "WrongLinkCount",
+			corrupt: func(t *testing.T, f *os.File, w *Writer) {
+				meta := w.pathInodeMeta["small.bin"]
+				_, err := f.WriteAt([]byte{2, 0}, int64(meta.nid)*32+6)
+				require.NoError(t, err)
+			},
+			wantErr: "small.bin: inode has link count 2, but 1 directory entries point to it",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, w := writeVerifyTestImage(t)
+			if test.corrupt != nil {
+				test.corrupt(t, f, w)
+			}
+			err := Verify(f)
+			if test.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, test.wantErr)
+		})
+	}
+}
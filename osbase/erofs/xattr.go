@@ -0,0 +1,204 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Extended attributes are stored either inline directly after the inode or in
+// the shared extended attribute area, in which case the inline area only
+// contains references to them. See doc.md for details.
+
+// xattrPrefixes maps the name prefixes supported by EROFS to their name index
+// in erofs_xattr_entry, see EROFS_XATTR_INDEX_*.
+var xattrPrefixes = []struct {
+	prefix string
+	index  uint8
+}{
+	{"user.", 1},
+	{"system.posix_acl_access", 2},
+	{"system.posix_acl_default", 3},
+	{"trusted.", 4},
+	{"security.", 6},
+}
+
+// struct erofs_xattr_entry, followed by the name and value.
+type xattrEntryRaw struct {
+	NameLength uint8
+	NameIndex  uint8
+	ValueSize  uint16
+}
+
+// struct erofs_xattr_ibody_header, followed by the shared xattr ids.
+type xattrHeaderRaw struct {
+	NameFilter  uint32
+	SharedCount uint8
+	Reserved    [7]uint8
+}
+
+// encodeXattr encodes a single extended attribute as an erofs_xattr_entry
+// padded to 4 bytes.
+func encodeXattr(name, value string) ([]byte, error) {
+	var index uint8
+	var suffix string
+	for _, p := range xattrPrefixes {
+		if strings.HasPrefix(name, p.prefix) {
+			index, suffix = p.index, strings.TrimPrefix(name, p.prefix)
+			break
+		}
+	}
+	if index == 0 {
+		return nil, fmt.Errorf("extended attribute %q has unsupported prefix", name)
+	}
+	if len(suffix) > math.MaxUint8 {
+		return nil, fmt.Errorf("extended attribute name %q too long", name)
+	}
+	if len(value) > math.MaxUint16 {
+		return nil, fmt.Errorf("value of extended attribute %q too long", name)
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &xattrEntryRaw{
+		NameLength: uint8(len(suffix)),
+		NameIndex:  index,
+		ValueSize:  uint16(len(value)),
+	})
+	buf.WriteString(suffix)
+	buf.WriteString(value)
+	buf.Write(make([]byte, (4-buf.Len()%4)%4))
+	return buf.Bytes(), nil
+}
+
+// decodeXattr decodes a single extended attribute encoded by encodeXattr
+// from the start of b. It returns the attribute and the number of bytes
+// consumed.
+func decodeXattr(b []byte) (name, value string, n int, err error) {
+	var e xattrEntryRaw
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &e); err != nil {
+		return "", "", 0, fmt.Errorf("cannot parse extended attribute entry: %w", err)
+	}
+	n = binary.Size(&e)
+	end := n + int(e.NameLength) + int(e.ValueSize)
+	if end > len(b) {
+		return "", "", 0, errors.New("extended attribute entry out of bounds")
+	}
+	var prefix string
+	for _, p := range xattrPrefixes {
+		if p.index == e.NameIndex {
+			prefix = p.prefix
+		}
+	}
+	if prefix == "" {
+		return "", "", 0, fmt.Errorf("extended attribute entry has unknown name index %d", e.NameIndex)
+	}
+	name = prefix + string(b[n:n+int(e.NameLength)])
+	value = string(b[n+int(e.NameLength) : end])
+	return name, value, (end + 3) &^ 3, nil
+}
+
+// ShareXattr adds an extended attribute to the shared extended attribute area.
+// Inodes created afterwards with the same attribute reference it instead of
+// storing it inline, which saves space if it is used by many inodes.
+func (w *Writer) ShareXattr(name, value string) error {
+	key := name + "\x00" + value
+	if _, ok := w.sharedXattrs[key]; ok {
+		return nil
+	}
+	entry, err := encodeXattr(name, value)
+	if err != nil {
+		return err
+	}
+	w.sharedXattrs[key] = uint32(len(w.sharedXattrArea) / 4)
+	w.sharedXattrArea = append(w.sharedXattrArea, entry...)
+	return nil
+}
+
+// encodeXattrs encodes the inline extended attribute area of an inode and
+// returns it along with the value for the XattrCount field of the inode.
+func (w *Writer) encodeXattrs(xattrs map[string]string) ([]byte, uint16, error) {
+	if len(xattrs) == 0 {
+		return nil, 0, nil
+	}
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var shared []uint32
+	var inline []byte
+	for _, name := range names {
+		if id, ok := w.sharedXattrs[name+"\x00"+xattrs[name]]; ok {
+			shared = append(shared, id)
+			continue
+		}
+		entry, err := encodeXattr(name, xattrs[name])
+		if err != nil {
+			return nil, 0, err
+		}
+		inline = append(inline, entry...)
+	}
+	if len(shared) > math.MaxUint8 {
+		return nil, 0, errors.New("too many shared extended attributes")
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &xattrHeaderRaw{SharedCount: uint8(len(shared))})
+	binary.Write(&buf, binary.LittleEndian, shared)
+	buf.Write(inline)
+	// The maximum inline size also includes the inode itself, leave some space
+	// for that.
+	if buf.Len() > BlockSize-64 {
+		return nil, 0, errors.New("extended attributes too big")
+	}
+	// Reverse of xattrSize.
+	count := (buf.Len()-binary.Size(&xattrHeaderRaw{}))/4 + 1
+	return buf.Bytes(), uint16(count), nil
+}
+
+// writeSharedXattrs writes the shared extended attribute area, if any, and
+// points the superblock to it.
+func (w *Writer) writeSharedXattrs() error {
+	if len(w.sharedXattrArea) == 0 {
+		return nil
+	}
+	blocks := (len(w.sharedXattrArea) + BlockSize - 1) / BlockSize
+	start, err := w.allocateBlocks(uint32(blocks))
+	if err != nil {
+		return fmt.Errorf("failed to allocate shared extended attribute area: %w", err)
+	}
+	if _, err := w.w.Write(w.sharedXattrArea); err != nil {
+		return fmt.Errorf("failed to write shared extended attribute area: %w", err)
+	}
+	if _, err := w.w.Write(make([]byte, blocks*BlockSize-len(w.sharedXattrArea))); err != nil {
+		return fmt.Errorf("failed to write shared extended attribute area: %w", err)
+	}
+	// SharedXattrStartAddr is at offset 44 into the superblock.
+	if _, err := w.w.Seek(1024+44, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to superblock: %w", err)
+	}
+	if err := binary.Write(w.w, binary.LittleEndian, start); err != nil {
+		return fmt.Errorf("failed to write shared extended attribute area address: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXattrs(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.img"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	security := map[string]string{"security.selinux": "system_u:object_r:bin_t:s0"}
+	w, err := NewWriter(f)
+	require.NoError(t, err)
+	require.NoError(t, w.ShareXattr("security.selinux", security["security.selinux"]))
+	require.NoError(t, w.Create(".", &Directory{
+		Base:     Base{Permissions: 0755, Xattrs: security},
+		Children: []string{"file", "link"},
+	}))
+	fileXattrs := map[string]string{
+		"security.selinux":        security["security.selinux"],
+		"user.a":                  "",
+		"trusted.overlay.opaque":  "y",
+		"system.posix_acl_access": "\x02\x00\x00\x00",
+		"user.long":               strings.Repeat("x", 1000),
+	}
+	fw := w.CreateFile("file", &FileMeta{Base: Base{Permissions: 0644, Xattrs: fileXattrs}})
+	_, err = fw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+	require.NoError(t, w.Create("link", &SymbolicLink{
+		Base:   Base{Permissions: 0777},
+		Target: "file",
+	}))
+	require.NoError(t, w.Close())
+	require.NoError(t, Verify(f))
+
+	r, err := NewReader(f)
+	require.NoError(t, err)
+	for name, want := range map[string]map[string]string{
+		".":    security,
+		"file": fileXattrs,
+		"link": nil,
+	} {
+		file, err := r.Open(name)
+		require.NoError(t, err)
+		got, err := file.Xattrs()
+		require.NoError(t, err)
+		if len(want) == 0 {
+			require.Empty(t, got, name)
+		} else {
+			require.Equal(t, want, got, name)
+		}
+	}
+	file, err := r.Open("file")
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = file.ReadAt(buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+	target, err := r.Readlink("link")
+	require.NoError(t, err)
+	require.Equal(t, "file", target)
+}
+
+func TestXattrsErrors(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.img"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	w, err := NewWriter(f)
+	require.NoError(t, err)
+	require.Error(t, w.ShareXattr("unknown.attr", "x"))
+	require.Error(t, w.Create(".", &Directory{
+		Base: Base{Permissions: 0755, Xattrs: map[string]string{"unknown.attr": "x"}},
+	}))
+	require.Error(t, w.Create("big", &FIFO{
+		Base: Base{Permissions: 0755, Xattrs: map[string]string{"user.big": strings.Repeat("x", BlockSize)}},
+	}))
+}
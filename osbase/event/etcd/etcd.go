@@ -5,9 +5,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"sync"
 
 	"github.com/cenkalti/backoff/v4"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 
 	"source.monogon.dev/osbase/event"
@@ -19,8 +21,39 @@ var (
 	// strictly true.  However, users of this library might want to rely on the
 	// Value type instead of particular Value implementations.
 	_ event.ValueWatch[StringAt] = &Value[StringAt]{}
+
+	_ RevisionWatcher[StringAt] = &watcher[StringAt]{}
 )
 
+var (
+	// ErrCompacted is returned by Get of a watcher created with StartRevision if
+	// the given revision has already been compacted away by etcd. The caller
+	// has to fall back to a watcher without a start revision, ie. a full
+	// resynchronization.
+	ErrCompacted = errors.New("start revision has been compacted")
+	// ErrFutureRevision is returned by Get of a watcher created with
+	// StartRevision if the given revision is newer than the current etcd store
+	// revision.
+	ErrFutureRevision = errors.New("start revision is newer than current revision")
+)
+
+// RevisionWatcher is an event.Watcher which additionally exposes the etcd store
+// revision of the data returned so far.
+type RevisionWatcher[T any] interface {
+	event.Watcher[T]
+
+	// Revision returns the etcd store revision that all data returned by Get so
+	// far corresponds to. If the watcher has retrieved changes from etcd which
+	// have not yet been returned by Get, or if no data has been retrieved yet,
+	// false is returned.
+	//
+	// The returned revision can be used as a StartRevision of a subsequent
+	// watcher to continue where this watcher left off.
+	//
+	// Revision must not be called concurrently with Get.
+	Revision() (int64, bool)
+}
+
 // ThinClient is a small wrapper interface to combine
 // clientv3.KV and clientv3.Watcher.
 type ThinClient interface {
@@ -36,10 +69,14 @@ type Value[T any] struct {
 	etcd    ThinClient
 	key     string
 	keyEnd  string
+	// startRevision, if non-zero, is the revision after which changes should
+	// be returned by watchers.
+	startRevision int64
 }
 
 type Option struct {
-	rangeEnd string
+	rangeEnd      string
+	startRevision int64
 }
 
 // Range creates a Value that is backed a range of etcd key/value pairs from
@@ -70,6 +107,27 @@ func Range(end string) *Option {
 	}
 }
 
+// StartRevision configures a Value to only return changes made after the given
+// etcd store revision, instead of all data currently in etcd. This is used to
+// resume watching after the data up to a given revision has already been
+// retrieved, eg. by another watcher (see RevisionWatcher).
+//
+// The initial backlog of a watcher then only contains keys which have been
+// changed or removed since the given revision. Removed keys are passed to the
+// decoder with a nil value.
+//
+// If the given revision has been compacted, Get will return ErrCompacted. If
+// it is newer than the current revision, Get will return ErrFutureRevision.
+//
+// The start revision is only used when the watcher first retrieves data. If
+// the watcher later needs to reconnect to etcd, it will return the full state
+// of the key(s), just like a watcher created without this option.
+func StartRevision(rev int64) *Option {
+	return &Option{
+		startRevision: rev,
+	}
+}
+
 // NewValue creates a new Value for a given key(s) in an etcd client. The
 // given decoder will be used to convert bytes retrieved from etcd into the
 // interface{} value retrieved by Get by this value's watcher.
@@ -85,6 +143,9 @@ func NewValue[T any](etcd ThinClient, key string, decoder func(key, value []byte
 		if end := opt.rangeEnd; end != "" {
 			res.keyEnd = end
 		}
+		if rev := opt.startRevision; rev != 0 {
+			res.startRevision = rev
+		}
 	}
 
 	return res
@@ -107,6 +168,11 @@ type StringAt struct {
 }
 
 func (e *Value[T]) Watch() event.Watcher[T] {
+	return e.RevisionWatch()
+}
+
+// RevisionWatch is like Watch, but returns a RevisionWatcher.
+func (e *Value[T]) RevisionWatch() RevisionWatcher[T] {
 	ctx, ctxC := context.WithCancel(context.Background())
 	return &watcher[T]{
 		Value: *e,
@@ -114,7 +180,8 @@ func (e *Value[T]) Watch() event.Watcher[T] {
 		ctx:  ctx,
 		ctxC: ctxC,
 
-		current: make(map[string][]byte),
+		current:   make(map[string][]byte),
+		delivered: make(map[string]bool),
 
 		getSem: make(chan struct{}, 1),
 	}
@@ -149,6 +216,11 @@ type watcher[T any] struct {
 	// persists alongside an etcd connection, permitting deduplication of spurious
 	// etcd updates even across multiple Get calls.
 	current map[string][]byte
+	// delivered contains the keys which the user of this watcher knows to
+	// exist, ie. which have last been returned via Get with a non-nil value, or
+	// which existed at the start revision when resuming. Deletions are only
+	// returned for these keys. This map persists across etcd reconnects.
+	delivered map[string]bool
 
 	// prev is the etcd store revision of a previously completed etcd Get/Watch
 	// call, used to resume a Watch call in case of failures.
@@ -206,8 +278,36 @@ func (w *watcher[T]) setup(ctx context.Context) error {
 		w.backlogged = nil
 		w.current = make(map[string][]byte)
 		for _, kv := range get.Kvs {
-			w.backlogged = append(w.backlogged, kv.Key)
 			w.current[string(kv.Key)] = kv.Value
+			// When resuming, only backlog keys changed after the start revision.
+			if w.startRevision != 0 && kv.ModRevision <= w.startRevision {
+				continue
+			}
+			w.backlogged = append(w.backlogged, kv.Key)
+		}
+		if w.startRevision != 0 {
+			// Find keys which have been removed since the start revision, by
+			// comparing the keys present at that revision with the current ones.
+			getOpts = append(getOpts, clientv3.WithRev(w.startRevision), clientv3.WithKeysOnly())
+			old, err := w.etcd.Get(ctx, w.key, getOpts...)
+			switch {
+			case errors.Is(err, rpctypes.ErrCompacted):
+				return backoff.Permanent(ErrCompacted)
+			case errors.Is(err, rpctypes.ErrFutureRev):
+				return backoff.Permanent(ErrFutureRevision)
+			case err != nil:
+				return fmt.Errorf("when retrieving keys at start revision: %w", err)
+			}
+			for _, kv := range old.Kvs {
+				// The caller knows these keys from the watcher which returned the
+				// start revision.
+				w.delivered[string(kv.Key)] = true
+				if _, ok := w.current[string(kv.Key)]; ok {
+					continue
+				}
+				w.backlogged = append(w.backlogged, kv.Key)
+				w.current[string(kv.Key)] = nil
+			}
 		}
 		return nil
 
@@ -219,6 +319,8 @@ func (w *watcher[T]) setup(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	// Any further setup (ie. reconnect) will retrieve the full state.
+	w.startRevision = 0
 
 	watchOpts := []clientv3.OpOption{
 		clientv3.WithRev(*w.prev + 1),
@@ -303,6 +405,19 @@ func (w *watcher[T]) backfill(ctx context.Context) error {
 			}
 
 			keyS := string(ev.Kv.Key)
+			// Deletions of keys which the user does not know about are not
+			// returned. If the key was created and not yet returned, its pending
+			// update is dropped from the backlog instead.
+			if ev.Type == clientv3.EventTypeDelete && !w.delivered[keyS] {
+				if seen[keyS] {
+					w.backlogged = slices.DeleteFunc(w.backlogged, func(k []byte) bool {
+						return string(k) == keyS
+					})
+					delete(seen, keyS)
+				}
+				delete(w.current, keyS)
+				continue
+			}
 			prev := w.current[keyS]
 			// Short-circuit and skip updates with the same content as already present.
 			// These are sometimes emitted by etcd.
@@ -428,16 +543,36 @@ func (w *watcher[T]) getUnlocked(ctx context.Context, ranged, backlogOnly bool)
 		k := w.backlogged[0]
 		v := w.current[string(k)]
 		w.backlogged = nil
+		w.markDelivered(k, v)
 		return w.decoder(k, v)
 	} else {
 		// For ranged queries, pop one ranged query off the backlog.
 		k := w.backlogged[0]
 		v := w.current[string(k)]
 		w.backlogged = w.backlogged[1:]
+		w.markDelivered(k, v)
 		return w.decoder(k, v)
 	}
 }
 
+// markDelivered records that the given key has been returned via Get with the
+// given value, nil meaning that it has been returned as deleted.
+func (w *watcher[T]) markDelivered(k, v []byte) {
+	if v == nil {
+		delete(w.delivered, string(k))
+	} else {
+		w.delivered[string(k)] = true
+	}
+}
+
+// Revision implements RevisionWatcher.
+func (w *watcher[T]) Revision() (int64, bool) {
+	if w.prev == nil || len(w.backlogged) > 0 {
+		return 0, false
+	}
+	return *w.prev, true
+}
+
 func (w *watcher[T]) Close() error {
 	w.ctxC()
 	return nil
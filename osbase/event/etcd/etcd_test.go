@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	"go.etcd.io/etcd/client/pkg/v3/testutil"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -863,3 +864,186 @@ func TestBacklogOnlyRange(t *testing.T) {
 		}
 	}
 }
+
+// TestStartRevision exercises watchers resuming from a given revision, ensuring
+// that only changes after that revision are returned.
+func TestStartRevision(t *testing.T) {
+	tc := newTestClient(t)
+	defer tc.close()
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	ks := "test-start-revision/"
+	ke := "test-start-revision0"
+
+	tc.put(t, ks+"a", "one")
+	tc.put(t, ks+"b", "one")
+	tc.put(t, ks+"c", "one")
+
+	// Retrieve the full state and its revision.
+	value := NewValue(tc.client, ks, DecoderStringAt, Range(ke))
+	w := value.RevisionWatch()
+	if _, ok := w.Revision(); ok {
+		t.Errorf("Revision available before first Get")
+	}
+	for {
+		_, err := w.Get(ctx, event.BacklogOnly[StringAt]())
+		if errors.Is(err, event.ErrBacklogDone) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	rev, ok := w.Revision()
+	if !ok {
+		t.Fatalf("Revision not available after backlog was drained")
+	}
+	w.Close()
+
+	// Make some changes: update a, remove b, add d. c stays unchanged.
+	tc.put(t, ks+"a", "two")
+	tc.remove(t, ks+"b")
+	tc.put(t, ks+"d", "two")
+
+	// Resume from the revision. The backlog should only contain the changes.
+	value = NewValue(tc.client, ks, DecoderStringAt, Range(ke), StartRevision(rev))
+	w = value.RevisionWatch()
+	defer w.Close()
+	res := make(map[string]string)
+	for {
+		g, err := w.Get(ctx, event.BacklogOnly[StringAt]())
+		if errors.Is(err, event.ErrBacklogDone) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		res[g.Key] = g.Value
+	}
+	want := map[string]string{
+		ks + "a": "two",
+		ks + "b": "",
+		ks + "d": "two",
+	}
+	if len(res) != len(want) {
+		t.Errorf("Wanted backlog %v, got %v", want, res)
+	}
+	for k, v := range want {
+		if got, ok := res[k]; !ok || got != v {
+			t.Errorf("res[%q]: wanted %q, got %q (present: %v)", k, v, got, ok)
+		}
+	}
+	rev2, ok := w.Revision()
+	if !ok || rev2 <= rev {
+		t.Errorf("Wanted revision newer than %d, got %d (%v)", rev, rev2, ok)
+	}
+
+	// Further updates should be returned as usual.
+	tc.remove(t, ks+"c")
+	g, err := w.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if g.Key != ks+"c" || g.Value != "" {
+		t.Errorf("Wanted removal of %q, got %+v", ks+"c", g)
+	}
+
+	// Resuming from a revision in the future should fail.
+	wf := NewValue(tc.client, ks, DecoderStringAt, Range(ke), StartRevision(rev2+1000)).Watch()
+	defer wf.Close()
+	if _, err := wf.Get(ctx); !errors.Is(err, ErrFutureRevision) {
+		t.Errorf("Wanted ErrFutureRevision, got %v", err)
+	}
+
+	// Resuming from a compacted revision should fail.
+	if _, err := tc.client.Compact(ctx, rev2); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	wc := NewValue(tc.client, ks, DecoderStringAt, Range(ke), StartRevision(rev)).Watch()
+	defer wc.Close()
+	if _, err := wc.Get(ctx); !errors.Is(err, ErrCompacted) {
+		t.Errorf("Wanted ErrCompacted, got %v", err)
+	}
+}
+
+// TestDeleteUndelivered ensures that deletions are only returned for keys which
+// have previously been returned, by feeding a watcher crafted etcd watch
+// events.
+func TestDeleteUndelivered(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	ks := "test-delete-undelivered/"
+	value := NewValue(nil, ks, DecoderStringAt, Range("test-delete-undelivered0"))
+	w := value.Watch().(*watcher[StringAt])
+	defer w.Close()
+
+	// Pretend the watcher has already been set up, which makes it only consume
+	// events from wc.
+	wc := make(chan clientv3.WatchResponse, 2)
+	w.wc = wc
+	ev := func(typ mvccpb.Event_EventType, key, value string) *clientv3.Event {
+		kv := &mvccpb.KeyValue{Key: []byte(ks + key)}
+		if value != "" {
+			kv.Value = []byte(value)
+		}
+		return &clientv3.Event{Type: typ, Kv: kv}
+	}
+
+	wc <- clientv3.WatchResponse{Events: []*clientv3.Event{
+		ev(clientv3.EventTypePut, "a", "one"),
+	}}
+	if g, err := w.Get(ctx); err != nil || g.Key != ks+"a" || g.Value != "one" {
+		t.Fatalf("Wanted a=one, got %+v, %v", g, err)
+	}
+
+	// b is created and deleted before it is ever returned, c was never seen at
+	// all. Only the deletion of a and the creation of d must be returned.
+	wc <- clientv3.WatchResponse{Events: []*clientv3.Event{
+		ev(clientv3.EventTypePut, "b", "one"),
+		ev(clientv3.EventTypeDelete, "b", ""),
+		ev(clientv3.EventTypeDelete, "c", ""),
+		ev(clientv3.EventTypeDelete, "a", ""),
+		ev(clientv3.EventTypePut, "d", "one"),
+	}}
+	// The whole response is processed at once, so the second update is
+	// backlogged by the time the first one is returned.
+	var got []StringAt
+	g, err := w.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got = append(got, g)
+	for {
+		g, err := w.Get(ctx, event.BacklogOnly[StringAt]())
+		if errors.Is(err, event.ErrBacklogDone) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		got = append(got, g)
+	}
+	want := []StringAt{
+		{Key: ks + "a", Value: ""},
+		{Key: ks + "d", Value: "one"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Wanted %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Update %d: wanted %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	// a has been returned as deleted, so a repeated deletion is not returned.
+	wc <- clientv3.WatchResponse{Events: []*clientv3.Event{
+		ev(clientv3.EventTypeDelete, "a", ""),
+		ev(clientv3.EventTypePut, "e", "one"),
+	}}
+	if g, err := w.Get(ctx); err != nil || g.Key != ks+"e" || g.Value != "one" {
+		t.Fatalf("Wanted e=one, got %+v, %v", g, err)
+	}
+}
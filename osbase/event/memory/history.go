@@ -0,0 +1,238 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"source.monogon.dev/osbase/event"
+)
+
+var (
+	_ event.Value[int] = &HistoryValue[int]{}
+)
+
+// HistoryValue is a variant of Value which retains the last Size values Set on
+// it. New watchers first receive all retained values in the order they were
+// Set before receiving any live updates. This is useful to debug state which
+// flaps, as a late consumer can still observe the recent transitions.
+//
+// Every watcher also buffers up to Size values it has not yet retrieved,
+// dropping the oldest ones first. In other words, a watcher which keeps up
+// receives a full log of all Set calls, while a slow watcher receives at least
+// the Size newest values.
+//
+// It is safe to construct an empty object of this type, in which case it
+// behaves like a Value retaining only the newest value. However, this must not
+// be copied.
+type HistoryValue[T any] struct {
+	// Size is the number of values retained. Values smaller than 1 are
+	// treated as 1.
+	//
+	// This must not be changed after the first .Set/.Watch call.
+	Size int
+
+	// mu guards the history, next and watchers fields.
+	mu sync.Mutex
+	// history is a ring buffer of the last Size values Set, next is the index
+	// in history at which the next value will be written.
+	history []T
+	next    int
+	// watchers is the list of watchers that should be updated when new data is
+	// Set. Closed watchers are removed on every Set.
+	watchers []*historyWatcher[T]
+}
+
+func (m *HistoryValue[T]) size() int {
+	if m.Size < 1 {
+		return 1
+	}
+	return m.Size
+}
+
+// Set updates the HistoryValue to the given data, appending it to the
+// retained history. It is safe to call this from multiple goroutines,
+// including concurrently.
+//
+// For more information about guarantees, see event.Value.Set.
+func (m *HistoryValue[T]) Set(val T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.history) < m.size() {
+		m.history = append(m.history, val)
+	} else {
+		m.history[m.next] = val
+	}
+	m.next = (m.next + 1) % m.size()
+
+	newWatchers := m.watchers[:0]
+	for _, w := range m.watchers {
+		if w.closed() {
+			continue
+		}
+		w.update(val)
+		newWatchers = append(newWatchers, w)
+	}
+	m.watchers = newWatchers
+}
+
+// Watch retrieves a Watcher which first returns all retained values in the
+// order they were Set, and then any values Set afterwards.
+//
+// For more information about guarantees, see event.Value.Watch.
+func (m *HistoryValue[T]) Watch() event.Watcher[T] {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &historyWatcher[T]{
+		size:   m.size(),
+		notify: make(chan struct{}, 1),
+		getSem: make(chan struct{}, 1),
+		close:  make(chan struct{}),
+	}
+	// Copy out the ring buffer, oldest value first. Until it is full, the
+	// ring buffer starts at index 0.
+	if len(m.history) == m.size() {
+		w.queue = append(w.queue, m.history[m.next:]...)
+		w.queue = append(w.queue, m.history[:m.next]...)
+	} else {
+		w.queue = append(w.queue, m.history...)
+	}
+	if len(w.queue) > 0 {
+		w.notify <- struct{}{}
+	}
+	m.watchers = append(m.watchers, w)
+	return w
+}
+
+// historyWatcher implements the event.Watcher interface for watchers returned
+// by HistoryValue.
+type historyWatcher[T any] struct {
+	// size is the maximum length of queue.
+	size int
+
+	// mu guards queue.
+	mu sync.Mutex
+	// queue contains values not yet retrieved by Get, oldest first.
+	queue []T
+	// notify is a buffered channel of size 1 which is written to whenever
+	// queue becomes non-empty.
+	notify chan struct{}
+
+	// getSem is a channel-based semaphore ensuring only a single .Get() call
+	// is active, see watcher.getSem.
+	getSem chan struct{}
+	// close is a channel that is closed when this watcher is itself Closed.
+	close chan struct{}
+}
+
+func (m *historyWatcher[T]) closed() bool {
+	select {
+	case <-m.close:
+		return true
+	default:
+		return false
+	}
+}
+
+// update appends val to the watcher's queue, dropping the oldest value if the
+// queue is full.
+func (m *historyWatcher[T]) update(val T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.queue) >= m.size {
+		m.queue = m.queue[1:]
+	}
+	m.queue = append(m.queue, val)
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes and returns the oldest queued value, if any.
+func (m *historyWatcher[T]) pop() (T, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var empty T
+	if len(m.queue) == 0 {
+		return empty, false
+	}
+	val := m.queue[0]
+	m.queue[0] = empty
+	m.queue = m.queue[1:]
+	if len(m.queue) > 0 {
+		select {
+		case m.notify <- struct{}{}:
+		default:
+		}
+	}
+	return val, true
+}
+
+func (m *historyWatcher[T]) Close() error {
+	close(m.close)
+	return nil
+}
+
+// Get returns the oldest value not yet retrieved by this watcher, blocking
+// until one is available. If BacklogOnly is set, event.ErrBacklogDone is
+// returned instead of blocking. See event.Watcher.Get for more information.
+func (m *historyWatcher[T]) Get(ctx context.Context, opts ...event.GetOption[T]) (T, error) {
+	var empty T
+	select {
+	case m.getSem <- struct{}{}:
+	default:
+		return empty, fmt.Errorf("cannot Get() concurrently on a single waiter")
+	}
+	defer func() {
+		<-m.getSem
+	}()
+
+	var predicate func(t T) bool
+	var backlogOnly bool
+	for _, opt := range opts {
+		if opt.Predicate != nil {
+			predicate = opt.Predicate
+		}
+		if opt.BacklogOnly {
+			backlogOnly = true
+		}
+	}
+
+	for {
+		val, ok := m.pop()
+		if !ok {
+			if backlogOnly {
+				return empty, event.ErrBacklogDone
+			}
+			select {
+			case <-ctx.Done():
+				return empty, ctx.Err()
+			case <-m.notify:
+			}
+			continue
+		}
+		if predicate != nil && !predicate(val) {
+			continue
+		}
+		return val, nil
+	}
+}
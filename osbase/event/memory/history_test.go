@@ -0,0 +1,104 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"source.monogon.dev/osbase/event"
+)
+
+// TestHistoryReplay ensures that a late watcher of a HistoryValue receives the
+// last Size values in order, followed by live updates.
+func TestHistoryReplay(t *testing.T) {
+	p := HistoryValue[int]{Size: 3}
+	for i := 0; i < 5; i++ {
+		p.Set(i)
+	}
+
+	ctx := context.Background()
+	w := p.Watch()
+	defer w.Close()
+	for _, want := range []int{2, 3, 4} {
+		got, err := w.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if want != got {
+			t.Fatalf("Value: got %d, wanted %d", got, want)
+		}
+	}
+	if _, err := w.Get(ctx, event.BacklogOnly[int]()); !errors.Is(err, event.ErrBacklogDone) {
+		t.Fatalf("Get with BacklogOnly: wanted ErrBacklogDone, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Set(5)
+	}()
+	got, err := w.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if want := 5; want != got {
+		t.Fatalf("Value: got %d, wanted %d", got, want)
+	}
+}
+
+// TestHistorySlowWatcher ensures that a watcher which does not keep up only
+// receives the newest Size values.
+func TestHistorySlowWatcher(t *testing.T) {
+	p := HistoryValue[int]{Size: 2}
+	w := p.Watch()
+	defer w.Close()
+	for i := 0; i < 10; i++ {
+		p.Set(i)
+	}
+
+	ctx := context.Background()
+	for _, want := range []int{8, 9} {
+		got, err := w.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if want != got {
+			t.Fatalf("Value: got %d, wanted %d", got, want)
+		}
+	}
+
+	ctxT, ctxC := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer ctxC()
+	if _, err := w.Get(ctxT); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get: wanted DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestHistoryEmpty ensures that a HistoryValue with no values Set blocks Get.
+func TestHistoryEmpty(t *testing.T) {
+	p := HistoryValue[int]{Size: 2}
+	w := p.Watch()
+	defer w.Close()
+
+	ctx, ctxC := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer ctxC()
+	if _, err := w.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get: wanted DeadlineExceeded, got %v", err)
+	}
+}
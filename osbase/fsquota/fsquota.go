@@ -141,3 +141,50 @@ func GetQuota(path string) (*Quota, error) {
 		InodesUsed: quota.CurInodes,
 	}, nil
 }
+
+// ProjectQuota is the quota and utilization of a single project on a
+// filesystem, as returned by ListQuotas.
+type ProjectQuota struct {
+	// ProjectID is the ID of the project, as assigned to the quota directory
+	// by SetQuota.
+	ProjectID uint32
+	Quota
+}
+
+// ListQuotas returns all project quotas and their utilization on the
+// filesystem mounted at the given path, ordered by project ID. The default
+// project (ID 0), which all files outside of quota directories are part of,
+// is not included.
+func ListQuotas(mountpoint string) ([]ProjectQuota, error) {
+	dir, err := os.Open(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	var res []ProjectQuota
+	var id uint32 = 1
+	for {
+		quota, err := quotactl.GetNextQuota(dir, quotactl.QuotaTypeProject, id)
+		if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ESRCH) {
+			// We have enumerated all quotas
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to call GetNextQuota: %w", err)
+		}
+		res = append(res, ProjectQuota{
+			ProjectID: quota.ID,
+			Quota: Quota{
+				Bytes:      quota.HardLimitBytes * 1024,
+				BytesUsed:  quota.CurrentBytes,
+				Inodes:     quota.HardLimitInodes,
+				InodesUsed: quota.CurrentInodes,
+			},
+		})
+		if quota.ID == math.MaxUint32 {
+			break
+		}
+		id = quota.ID + 1
+	}
+	return res, nil
+}
@@ -27,6 +27,8 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sys/unix"
+
+	"source.monogon.dev/osbase/fsquota/fsxattrs"
 )
 
 // withinTolerance is a helper for asserting that a value is within a certain
@@ -149,4 +151,63 @@ func TestBasic(t *testing.T) {
 
 		withinTolerance(t, 51, quotaUtil.InodesUsed, 0.1, "InodesUsed")
 	})
+	t.Run("ListQuotas", func(t *testing.T) {
+		defer func() {
+			os.RemoveAll("/test/lista")
+			os.RemoveAll("/test/listb")
+		}()
+		for _, dir := range []string{"/test/lista", "/test/listb"} {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := SetQuota("/test/lista", 1024*1024, 100); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetQuota("/test/listb", 2*1024*1024, 200); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile("/test/listb/512kfile", make([]byte, 512*1024), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// Map project IDs to directories. Quotas of previous tests might
+		// still be present, so only look at the ones created here.
+		projects := make(map[uint32]string)
+		for _, dir := range []string{"/test/lista", "/test/listb"} {
+			f, err := os.Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attrs, err := fsxattrs.Get(f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			projects[attrs.ProjectID] = dir
+		}
+
+		quotas, err := ListQuotas("/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found int
+		for _, pq := range quotas {
+			if pq.ProjectID == 0 {
+				t.Error("ListQuotas returned default project")
+			}
+			switch projects[pq.ProjectID] {
+			case "/test/lista":
+				require.Equal(t, uint64(1024*1024), pq.Bytes, "lista bytes quota mismatch")
+				require.Equal(t, uint64(100), pq.Inodes, "lista inodes quota mismatch")
+				found++
+			case "/test/listb":
+				require.Equal(t, uint64(2*1024*1024), pq.Bytes, "listb bytes quota mismatch")
+				require.Equal(t, uint64(200), pq.Inodes, "listb inodes quota mismatch")
+				withinTolerance(t, 512*1024, pq.BytesUsed, 0.1, "listb BytesUsed")
+				found++
+			}
+		}
+		require.Equal(t, 2, found, "expected both quotas to be listed")
+	})
 }
@@ -102,6 +102,14 @@ type journal struct {
 	// particular DN-designated logger.
 	quota map[DN]*quota
 
+	// rateLimit is the rate limit applied to every DN, or nil if rate limiting is
+	// disabled (the default). See LogTree.SetRateLimit.
+	rateLimit *rateLimit
+
+	// disk is the on-disk store to which entries are persisted, or nil if the
+	// journal is in-memory only (the default). See LogTree.Persist.
+	disk *journalDisk
+
 	// subscribers are observer to logs. New log entries get emitted to channels
 	// present in the subscriber structure, after filtering them through subscriber-
 	// provided filters (eg. to limit events to subtrees that interest that particular
@@ -120,6 +128,82 @@ func newJournal() *journal {
 	}
 }
 
+// rateLimit is the configuration of per-DN token bucket rate limiting of log
+// entries appended to the journal.
+type rateLimit struct {
+	// rate is the number of tokens added per second to the bucket of each DN.
+	rate float64
+	// burst is the size of the bucket of each DN, ie. the maximum number of
+	// entries that can be logged at once.
+	burst float64
+}
+
+// SetRateLimit enables rate limiting of log entries for every DN (each DN
+// separately, not recursively). Each DN can log up to burst entries at once,
+// and then further entries at the given rate per second. Any entries logged in
+// excess of that are dropped and counted in Stats.RateLimited. A rate of zero
+// disables rate limiting, which is the default.
+func (l *LogTree) SetRateLimit(rate float64, burst int) error {
+	if rate < 0 {
+		return errors.New("rate must not be negative")
+	}
+	if rate > 0 && burst < 1 {
+		return errors.New("burst must be at least 1")
+	}
+	l.journal.mu.Lock()
+	defer l.journal.mu.Unlock()
+	if rate == 0 {
+		l.journal.rateLimit = nil
+		return nil
+	}
+	l.journal.rateLimit = &rateLimit{
+		rate:  rate,
+		burst: float64(burst),
+	}
+	return nil
+}
+
+// Stats contains statistics about the log entries of a given DN.
+type Stats struct {
+	// RateLimited is the number of entries which were dropped because they
+	// exceeded the rate limit, see LogTree.SetRateLimit.
+	RateLimited uint64
+	// Evicted is the number of entries which were removed from the journal to
+	// keep the DN within its retention quota.
+	Evicted uint64
+}
+
+// Stats returns the statistics of a given DN (non-recursively, ie. for that DN
+// only, not its children).
+func (l *LogTree) Stats(dn DN) Stats {
+	return l.journal.Stats(dn)
+}
+
+// Stats returns the statistics of a given DN.
+func (j *journal) Stats(dn DN) Stats {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	q, ok := j.quota[dn]
+	if !ok {
+		return Stats{}
+	}
+	return q.stats()
+}
+
+// statsMatching returns the statistics of all DNs that have logged entries and
+// match the given filter. journal.mu must be taken at R or RW level when
+// calling this function.
+func (j *journal) statsMatching(f filter) map[DN]Stats {
+	res := make(map[DN]Stats)
+	for dn, q := range j.quota {
+		if !f(&entry{origin: dn}) {
+			continue
+		}
+		res[dn] = q.stats()
+	}
+	return res
+}
+
 // filter is a predicate that returns true if a log subscriber or reader is
 // interested in a given log entry.
 type filter func(*entry) bool
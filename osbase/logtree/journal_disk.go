@@ -0,0 +1,363 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logtree
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	lpb "source.monogon.dev/osbase/logtree/proto"
+)
+
+// journalDisk is the on-disk store of a journal. It is a file containing
+// length-delimited LogEntry protos, oldest first, to which every entry
+// appended to the journal is also appended.
+//
+// Once the file would grow past maxSize, it gets compacted: it is rewritten
+// to only contain the newest entries retained by the journal (and as such
+// subject to the same quota), up to half of maxSize.
+//
+// Appending to the journal only queues entries. They are written to the file
+// by a background goroutine (see run), so that neither disk I/O nor
+// compaction is performed while holding journal.mu.
+type journalDisk struct {
+	// path is the path of the journal file.
+	path string
+	// maxSize is the maximum size of the journal file in bytes.
+	maxSize int64
+	// logger is used to report errors which disable persistence.
+	logger LeveledLogger
+
+	// mu guards pending and needCompact. It may be taken while holding
+	// journal.mu, but not the other way around.
+	mu sync.Mutex
+	// pending are the entries appended to the journal which have not yet been
+	// written to the file.
+	pending []*entry
+	// needCompact is set if pending entries had to be dropped because too many
+	// of them were queued. The next write then compacts the file, which
+	// recovers them from the journal.
+	needCompact bool
+
+	// notify is signalled whenever entries are queued.
+	notify chan struct{}
+	// syncC receives requests from Sync, which are channels closed once all
+	// entries queued before the request have been written.
+	syncC chan chan struct{}
+	// stopped is closed once persistence got disabled due to err.
+	stopped chan struct{}
+	err     error
+
+	// file is the journal file, opened for appending. Only accessed by the
+	// goroutine writing to it.
+	file *os.File
+	// size is the current size of the journal file in bytes. Only accessed by
+	// the goroutine writing to it.
+	size int64
+}
+
+// maxPendingEntries is the number of entries which can be queued for writing
+// before they are dropped in favour of compacting the file.
+const maxPendingEntries = 8192
+
+// Persist enables persisting the journal to a file at path, which will be kept
+// below maxSize bytes. Any entries already present in that file (eg. from a
+// previous boot) are first loaded into the journal, before any entries logged
+// to this LogTree so far, so that they are available to readers requesting a
+// backlog. Loaded entries are not delivered to streaming readers.
+//
+// Entries are written to the file asynchronously, see Sync.
+//
+// Persistence can only be enabled once per LogTree. If writing to the file
+// fails later on, persistence is disabled and the error is logged to the
+// "logtree" DN, but the in-memory journal keeps working as usual.
+func (l *LogTree) Persist(path string, maxSize int64) error {
+	if maxSize <= 0 {
+		return errors.New("maximum size must be positive")
+	}
+	loaded, err := loadJournalFile(path)
+	if err != nil {
+		return fmt.Errorf("could not load journal: %w", err)
+	}
+
+	j := l.journal
+	j.mu.Lock()
+	if j.disk != nil {
+		j.mu.Unlock()
+		return errors.New("journal is already persisted")
+	}
+
+	// Rebuild the journal with the loaded entries in front of the current ones.
+	current := j.scanEntries(BacklogAllAvailable, filterAll())
+	j.head = nil
+	j.tail = nil
+	j.heads = make(map[DN]*entry)
+	j.tails = make(map[DN]*entry)
+	for _, e := range loaded {
+		j.insert(e)
+	}
+	for _, e := range current {
+		j.insert(e)
+	}
+
+	d := &journalDisk{
+		path:    path,
+		maxSize: maxSize,
+		notify:  make(chan struct{}, 1),
+		syncC:   make(chan chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	// Entries appended from now on are queued, and written after the initial
+	// contents of the file.
+	entries := j.scanEntries(BacklogAllAvailable, filterAll())
+	j.disk = d
+	j.mu.Unlock()
+
+	if err := d.writeCompacted(entries); err != nil {
+		j.mu.Lock()
+		j.disk = nil
+		j.mu.Unlock()
+		return fmt.Errorf("could not write journal: %w", err)
+	}
+	d.logger = l.MustLeveledFor("logtree")
+	go d.run(j)
+	return nil
+}
+
+// Sync waits until all entries appended to the LogTree so far have been
+// written to the journal file. It returns an error if persistence is not
+// enabled or got disabled due to an error.
+func (l *LogTree) Sync() error {
+	l.journal.mu.RLock()
+	d := l.journal.disk
+	l.journal.mu.RUnlock()
+	if d == nil {
+		return errors.New("journal is not persisted")
+	}
+	done := make(chan struct{})
+	select {
+	case d.syncC <- done:
+	case <-d.stopped:
+		return d.err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-d.stopped:
+		return d.err
+	}
+}
+
+// loadJournalFile returns all entries contained in a journal file at path, or
+// no entries if the file doesn't exist. Entries which decode but are not valid
+// log entries are skipped. Reading stops at the first record which cannot be
+// decoded, as the framing of all following records is lost: this is usually a
+// truncated trailing entry (eg. due to a power loss while writing it), but
+// might also be corruption within the file. Entries read up to that point are
+// returned, and the rest of the file is discarded once it gets compacted.
+func loadJournalFile(path string) ([]*entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res []*entry
+	r := bufio.NewReader(f)
+	for {
+		var p lpb.LogEntry
+		if err := protodelim.UnmarshalFrom(r, &p); err != nil {
+			break
+		}
+		le, err := LogEntryFromProto(&p)
+		if err != nil {
+			continue
+		}
+		res = append(res, &entry{
+			origin:  le.DN,
+			leveled: le.Leveled,
+			raw:     le.Raw,
+		})
+	}
+	return res, nil
+}
+
+// marshalEntry returns the on-disk representation of an entry.
+func marshalEntry(e *entry) ([]byte, error) {
+	p := e.external().Proto()
+	if p == nil {
+		return nil, errors.New("invalid entry")
+	}
+	var buf bytes.Buffer
+	if _, err := protodelim.MarshalTo(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// append queues an entry which has just been inserted into the journal for
+// writing to the journal file. journal.mu must be taken as RW.
+func (d *journalDisk) append(e *entry) {
+	d.mu.Lock()
+	if len(d.pending) < maxPendingEntries {
+		d.pending = append(d.pending, e)
+	} else {
+		d.pending = nil
+		d.needCompact = true
+	}
+	d.mu.Unlock()
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run writes queued entries to the journal file until writing fails, in which
+// case persistence is disabled.
+func (d *journalDisk) run(j *journal) {
+	for {
+		var done chan struct{}
+		select {
+		case <-d.notify:
+		case done = <-d.syncC:
+		}
+		if err := d.write(j); err != nil {
+			j.mu.Lock()
+			j.disk = nil
+			j.mu.Unlock()
+			d.file.Close()
+			// Pending Sync calls return err once stopped is closed.
+			d.err = err
+			close(d.stopped)
+			d.logger.Errorf("Could not write journal to %s, persistence disabled: %v", d.path, err)
+			return
+		}
+		if done != nil {
+			close(done)
+		}
+	}
+}
+
+// write writes all queued entries to the journal file, compacting it if
+// needed.
+func (d *journalDisk) write(j *journal) error {
+	d.mu.Lock()
+	pending := d.pending
+	needCompact := d.needCompact
+	d.pending = nil
+	d.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, e := range pending {
+		b, err := marshalEntry(e)
+		if err != nil {
+			continue
+		}
+		buf.Write(b)
+	}
+	if needCompact || d.size+int64(buf.Len()) > d.maxSize {
+		return d.compact(j)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	n, err := d.file.Write(buf.Bytes())
+	d.size += int64(n)
+	return err
+}
+
+// compact replaces the journal file with one containing the newest entries of
+// the journal, up to half of maxSize.
+func (d *journalDisk) compact(j *journal) error {
+	// All entries queued so far are part of the journal, so they can be
+	// dropped while taking a snapshot of it.
+	j.mu.RLock()
+	entries := j.scanEntries(BacklogAllAvailable, filterAll())
+	d.mu.Lock()
+	d.pending = nil
+	d.needCompact = false
+	d.mu.Unlock()
+	j.mu.RUnlock()
+
+	return d.writeCompacted(entries)
+}
+
+// writeCompacted replaces the journal file with one containing the newest of
+// the given entries, up to half of maxSize.
+func (d *journalDisk) writeCompacted(entries []*entry) error {
+	// Collect entries newest first until the size budget is exhausted.
+	var chunks [][]byte
+	var size int64
+	for i := len(entries) - 1; i >= 0; i-- {
+		b, err := marshalEntry(entries[i])
+		if err != nil {
+			continue
+		}
+		if size+int64(len(b)) > d.maxSize/2 {
+			break
+		}
+		chunks = append(chunks, b)
+		size += int64(len(b))
+	}
+
+	tmpPath := d.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		if _, err := w.Write(chunks[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, d.path); err != nil {
+		return err
+	}
+
+	if d.file != nil {
+		d.file.Close()
+	}
+	d.file, err = os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	d.size = size
+	return nil
+}
@@ -16,7 +16,11 @@
 
 package logtree
 
-import "source.monogon.dev/osbase/logbuffer"
+import (
+	"time"
+
+	"source.monogon.dev/osbase/logbuffer"
+)
 
 // entry is a journal entry, representing a single log event (encompassed in a
 // Payload) at a given DN. See the journal struct for more information about the
@@ -110,13 +114,84 @@ type quota struct {
 	// max is the maximum count of log entries permitted for this DN - ie, the maximum
 	// size of the local linked list.
 	max uint64
+
+	// tokens is the number of tokens left in this DN's rate limiting bucket, as of
+	// refilled.
+	tokens float64
+	// refilled is the time at which tokens was last updated. It is zero if the
+	// bucket has not yet been used, in which case it is considered full.
+	refilled time.Time
+	// rateLimited is the number of entries dropped due to rate limiting.
+	rateLimited uint64
+	// evicted is the number of entries removed from the local linked list in
+	// order to stay within max.
+	evicted uint64
+}
+
+// stats returns the Stats of the DN tracked by this quota.
+func (q *quota) stats() Stats {
+	return Stats{
+		RateLimited: q.rateLimited,
+		Evicted:     q.evicted,
+	}
 }
 
-// append adds an entry at the head of the global and local linked lists.
-func (j *journal) append(e *entry) {
+// allow returns whether an entry may be appended at the given time under the
+// given rate limit, consuming a token if so, or counting a dropped entry
+// otherwise.
+func (q *quota) allow(rl *rateLimit, now time.Time) bool {
+	if q.refilled.IsZero() {
+		q.tokens = rl.burst
+	} else {
+		q.tokens += now.Sub(q.refilled).Seconds() * rl.rate
+		if q.tokens > rl.burst {
+			q.tokens = rl.burst
+		}
+	}
+	q.refilled = now
+	if q.tokens < 1 {
+		q.rateLimited += 1
+		return false
+	}
+	q.tokens -= 1
+	return true
+}
+
+// append adds an entry at the head of the global and local linked lists and
+// notifies all subscribers. If rate limiting is enabled and the entry's origin
+// exceeds it, the entry is dropped instead and false is returned.
+func (j *journal) append(e *entry) bool {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 
+	// Create quota if necessary.
+	if _, ok := j.quota[e.origin]; !ok {
+		j.quota[e.origin] = &quota{origin: e.origin, max: 8192}
+	}
+	if j.rateLimit != nil && !j.quota[e.origin].allow(j.rateLimit, time.Now()) {
+		return false
+	}
+
+	j.insert(e)
+	if j.disk != nil {
+		j.disk.append(e)
+	}
+	// Notify subscribers while still holding the lock, so that readers
+	// retrieving a backlog and subscribing atomically (see LogTree.Read)
+	// observe every entry either in their backlog or in their stream, but
+	// never in both.
+	j.notify(e)
+	return true
+}
+
+// insert adds an entry at the head of the global and local linked lists,
+// evicting entries in excess of the DN's quota. Unlike append, it does not
+// apply rate limiting nor persist the entry. journal.mu must be taken as RW.
+func (j *journal) insert(e *entry) {
+	if _, ok := j.quota[e.origin]; !ok {
+		j.quota[e.origin] = &quota{origin: e.origin, max: 8192}
+	}
+
 	e.journal = j
 
 	// Insert at head in global linked list, set pointers.
@@ -130,11 +205,6 @@ func (j *journal) append(e *entry) {
 		j.head = e
 	}
 
-	// Create quota if necessary.
-	if _, ok := j.quota[e.origin]; !ok {
-		j.quota[e.origin] = &quota{origin: e.origin, max: 8192}
-	}
-
 	// Insert at head in local linked list, calculate seqLocal, set pointers.
 	e.nextLocal = nil
 	e.prevLocal = j.tails[e.origin]
@@ -171,6 +241,7 @@ func (j *journal) append(e *entry) {
 			// Unlinking the entry unlinks it from both the global and local linked lists.
 			el.unlink()
 			left -= 1
+			quota.evicted += 1
 		}
 	}
 }
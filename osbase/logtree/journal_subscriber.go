@@ -43,11 +43,10 @@ func (j *journal) subscribe(sub *subscriber) {
 }
 
 // notify sends an entry to all subscribers that wish to receive it.
+// mu must be taken in W mode
 func (j *journal) notify(e *entry) {
-	j.mu.Lock()
-	defer j.mu.Unlock()
-
 	newSub := make([]*subscriber, 0, len(j.subscribers))
+subscribers:
 	for _, sub := range j.subscribers {
 		select {
 		case <-sub.doneC:
@@ -57,9 +56,10 @@ func (j *journal) notify(e *entry) {
 			newSub = append(newSub, sub)
 		}
 
+		// Skip this subscriber if any of its filters rejects the entry.
 		for _, filter := range sub.filters {
 			if !filter(e) {
-				continue
+				continue subscribers
 			}
 		}
 		select {
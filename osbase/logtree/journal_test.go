@@ -17,7 +17,10 @@
 package logtree
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -55,6 +58,9 @@ func TestJournalRetention(t *testing.T) {
 			t.Fatalf("wanted entry %q, got %q", want, got)
 		}
 	}
+	if want, got := uint64(9000-8192), j.Stats("main").Evicted; want != got {
+		t.Fatalf("wanted %d evicted entries, got %d", want, got)
+	}
 }
 
 func TestJournalQuota(t *testing.T) {
@@ -109,6 +115,278 @@ func TestJournalQuota(t *testing.T) {
 	}
 }
 
+func TestJournalRateLimit(t *testing.T) {
+	lt := New()
+	j := lt.journal
+
+	// Default: no rate limiting.
+	for i := 0; i < 100; i += 1 {
+		if !j.append(&entry{origin: "unlimited", leveled: testPayload("unlimited")}) {
+			t.Fatalf("entry %d dropped without rate limit", i)
+		}
+	}
+
+	// Rate low enough to not refill any tokens during the test.
+	if err := lt.SetRateLimit(0.0001, 10); err != nil {
+		t.Fatalf("SetRateLimit: %v", err)
+	}
+	for i := 0; i < 100; i += 1 {
+		j.append(&entry{origin: "chatty", leveled: testPayload(fmt.Sprintf("chatty %d", i))})
+		if i%20 == 0 {
+			j.append(&entry{origin: "solemn", leveled: testPayload(fmt.Sprintf("solemn %d", i))})
+		}
+	}
+
+	if want, got := 10, len(j.getEntries(BacklogAllAvailable, "chatty")); want != got {
+		t.Errorf("wanted %d chatty entries, got %d", want, got)
+	}
+	if want, got := uint64(90), lt.Stats("chatty").RateLimited; want != got {
+		t.Errorf("wanted %d rate limited chatty entries, got %d", want, got)
+	}
+	if want, got := 5, len(j.getEntries(BacklogAllAvailable, "solemn")); want != got {
+		t.Errorf("wanted %d solemn entries, got %d", want, got)
+	}
+	if want, got := uint64(0), lt.Stats("solemn").RateLimited; want != got {
+		t.Errorf("wanted %d rate limited solemn entries, got %d", want, got)
+	}
+
+	// Disabling rate limiting lets entries through again.
+	if err := lt.SetRateLimit(0, 0); err != nil {
+		t.Fatalf("SetRateLimit: %v", err)
+	}
+	if !j.append(&entry{origin: "chatty", leveled: testPayload("chatty")}) {
+		t.Errorf("entry dropped after disabling rate limit")
+	}
+
+	if err := lt.SetRateLimit(-1, 10); err == nil {
+		t.Errorf("negative rate accepted")
+	}
+	if err := lt.SetRateLimit(1, 0); err == nil {
+		t.Errorf("zero burst accepted")
+	}
+}
+
+// messagesOf returns the joined messages of the given entries.
+func messagesOf(entries []*entry) []string {
+	var res []string
+	for _, e := range entries {
+		res = append(res, strings.Join(e.leveled.messages, "\n"))
+	}
+	return res
+}
+
+func TestJournalPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	lt := New()
+	lt.journal.append(&entry{origin: "main", leveled: testPayload("before persist")})
+	if err := lt.Persist(path, 1<<20); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	lt.journal.append(&entry{origin: "main", leveled: testPayload("after persist")})
+	if err := lt.Persist(path, 1<<20); err == nil {
+		t.Errorf("second Persist succeeded")
+	}
+
+	if err := lt.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	// Simulate a restart, with some entries logged before persistence is
+	// enabled.
+	lt = New()
+	lt.journal.append(&entry{origin: "main", leveled: testPayload("early")})
+	if err := lt.Persist(path, 1<<20); err != nil {
+		t.Fatalf("Persist after restart: %v", err)
+	}
+	lt.journal.append(&entry{origin: "main", leveled: testPayload("late")})
+
+	want := []string{"before persist", "after persist", "early", "late"}
+	got := messagesOf(lt.journal.getEntries(BacklogAllAvailable, "main"))
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+	got = messagesOf(lt.journal.scanEntries(BacklogAllAvailable, filterAll()))
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		t.Errorf("wanted %v from scan, got %v", want, got)
+	}
+}
+
+func TestJournalPersistQuota(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	lt := New()
+	if err := lt.Persist(path, 16<<20); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	for i := 0; i < 9000; i += 1 {
+		lt.journal.append(&entry{origin: "chatty", leveled: testPayload(fmt.Sprintf("chatty %d", i))})
+		if i%10 == 0 {
+			lt.journal.append(&entry{origin: "solemn", leveled: testPayload(fmt.Sprintf("solemn %d", i))})
+		}
+	}
+
+	if err := lt.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	lt = New()
+	if err := lt.Persist(path, 16<<20); err != nil {
+		t.Fatalf("Persist after restart: %v", err)
+	}
+	chatty := lt.journal.getEntries(BacklogAllAvailable, "chatty")
+	if want, got := 8192, len(chatty); want != got {
+		t.Fatalf("wanted %d chatty entries, got %d", want, got)
+	}
+	if want, got := fmt.Sprintf("chatty %d", 9000-8192), messagesOf(chatty)[0]; want != got {
+		t.Errorf("wanted oldest chatty entry %q, got %q", want, got)
+	}
+	if want, got := 900, len(lt.journal.getEntries(BacklogAllAvailable, "solemn")); want != got {
+		t.Errorf("wanted %d solemn entries, got %d", want, got)
+	}
+
+	// The file must have been compacted down to the entries retained by the
+	// journal.
+	lt = New()
+	if err := lt.Persist(path, 16<<20); err != nil {
+		t.Fatalf("Persist after second restart: %v", err)
+	}
+	if want, got := 8192+900, len(lt.journal.scanEntries(BacklogAllAvailable, filterAll())); want != got {
+		t.Errorf("wanted %d total entries, got %d", want, got)
+	}
+}
+
+func TestJournalPersistSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	const maxSize = 4096
+
+	lt := New()
+	if err := lt.Persist(path, maxSize); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	for i := 0; i < 1000; i += 1 {
+		lt.journal.append(&entry{origin: "main", leveled: testPayload(fmt.Sprintf("test %d", i))})
+		if err := lt.Sync(); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if st.Size() > maxSize {
+			t.Fatalf("journal file grew to %d bytes", st.Size())
+		}
+	}
+
+	lt = New()
+	if err := lt.Persist(path, maxSize); err != nil {
+		t.Fatalf("Persist after restart: %v", err)
+	}
+	got := messagesOf(lt.journal.getEntries(BacklogAllAvailable, "main"))
+	if len(got) == 0 {
+		t.Fatalf("no entries loaded")
+	}
+	if want := "test 999"; got[len(got)-1] != want {
+		t.Errorf("wanted newest entry %q, got %q", want, got[len(got)-1])
+	}
+}
+
+func TestJournalPersistError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	const maxSize = 4096
+
+	lt := New()
+	if err := lt.Persist(path, maxSize); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	// Make compaction fail by blocking the path of its temporary file.
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	for i := 0; i < 1000 && err == nil; i += 1 {
+		lt.journal.append(&entry{origin: "main", leveled: testPayload(fmt.Sprintf("test %d", i))})
+		err = lt.Sync()
+	}
+	if err == nil {
+		t.Fatalf("wanted Sync to fail")
+	}
+
+	// Persistence must be disabled and the error logged, while the journal
+	// keeps working.
+	if lt.journal.disk != nil {
+		t.Errorf("wanted persistence to be disabled")
+	}
+	if err := lt.Sync(); err == nil {
+		t.Errorf("wanted Sync to fail after persistence got disabled")
+	}
+	logged := messagesOf(lt.journal.getEntries(BacklogAllAvailable, "logtree"))
+	if len(logged) != 1 || !strings.Contains(logged[0], "persistence disabled") {
+		t.Errorf("wanted error to be logged, got %v", logged)
+	}
+	lt.journal.append(&entry{origin: "main", leveled: testPayload("after error")})
+	got := messagesOf(lt.journal.getEntries(1, "main"))
+	if len(got) != 1 || got[0] != "after error" {
+		t.Errorf("wanted journal to keep working, got %v", got)
+	}
+}
+
+func TestJournalPersistCorrupted(t *testing.T) {
+	for _, te := range []struct {
+		name    string
+		garbage []byte
+	}{
+		// Length prefix of a record which was never fully written.
+		{"Truncated", []byte{0x10, 0x0a}},
+		// Complete record which is not a valid LogEntry proto.
+		{"Undecodable", []byte{0x02, 0xff, 0xff}},
+		// Length prefix which is not a valid varint.
+		{"InvalidLength", bytes.Repeat([]byte{0xff}, 11)},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal")
+
+			lt := New()
+			if err := lt.Persist(path, 1<<20); err != nil {
+				t.Fatalf("Persist: %v", err)
+			}
+			lt.journal.append(&entry{origin: "main", leveled: testPayload("first")})
+			lt.journal.append(&entry{origin: "main", leveled: testPayload("second")})
+			if err := lt.Sync(); err != nil {
+				t.Fatalf("Sync: %v", err)
+			}
+
+			// Corrupt the file, followed by a valid entry which cannot be
+			// found anymore.
+			b, err := marshalEntry(&entry{origin: "main", leveled: testPayload("lost")})
+			if err != nil {
+				t.Fatalf("marshalEntry: %v", err)
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(append(te.garbage, b...)); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			// Entries before the corruption must be kept, and the file must be
+			// usable again.
+			for i := 0; i < 2; i++ {
+				lt = New()
+				if err := lt.Persist(path, 1<<20); err != nil {
+					t.Fatalf("Persist after restart %d: %v", i, err)
+				}
+				want := []string{"first", "second"}
+				got := messagesOf(lt.journal.getEntries(BacklogAllAvailable, "main"))
+				if strings.Join(want, ",") != strings.Join(got, ",") {
+					t.Errorf("restart %d: wanted %v, got %v", i, want, got)
+				}
+			}
+		})
+	}
+}
+
 func TestJournalSubtree(t *testing.T) {
 	j := newJournal()
 	j.append(&entry{origin: "a", leveled: testPayload("a")})
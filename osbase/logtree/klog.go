@@ -95,7 +95,6 @@ func (k *klogParser) consumeLine(l *logbuffer.Line) {
 		leveled: p,
 	}
 	k.publisher.node.tree.journal.append(e)
-	k.publisher.node.tree.journal.notify(e)
 }
 
 var (
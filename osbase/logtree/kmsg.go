@@ -76,7 +76,6 @@ func KmsgPipe(ctx context.Context, lt LeveledLogger) error {
 			leveled: p,
 		}
 		publisher.node.tree.journal.append(e)
-		publisher.node.tree.journal.notify(e)
 	}
 }
 
@@ -39,6 +39,9 @@ type LeveledPayload struct {
 	timestamp time.Time
 	// severity is the leveled Severity at which this message was emitted.
 	severity Severity
+	// verbosity is the VerbosityLevel at which this message was emitted, or zero
+	// if it was not emitted through a V-logger.
+	verbosity VerbosityLevel
 	// file is the filename of the caller that emitted this message.
 	file string
 	// line is the line number within the file of the caller that emitted this message.
@@ -113,6 +116,10 @@ func (p *LeveledPayload) Location() string { return fmt.Sprintf("%s:%d", p.file,
 // Severity returns the Severity with which this entry was logged.
 func (p *LeveledPayload) Severity() Severity { return p.severity }
 
+// Verbosity returns the VerbosityLevel at which this entry was logged, or zero
+// if it was not logged through a V-logger.
+func (p *LeveledPayload) Verbosity() VerbosityLevel { return p.verbosity }
+
 // Proto converts a LeveledPayload to protobuf format.
 func (p *LeveledPayload) Proto() *lpb.LogEntry_Leveled {
 	return &lpb.LogEntry_Leveled{
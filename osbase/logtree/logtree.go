@@ -54,6 +54,8 @@ type node struct {
 	// LeveledLogger calls
 	verbosity     VerbosityLevel
 	rawLineBuffer *logbuffer.LineBuffer
+	// dedup is the leveled log deduplication state of this node.
+	dedup dedup
 
 	// mu guards children.
 	mu sync.Mutex
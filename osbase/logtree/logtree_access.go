@@ -18,6 +18,7 @@ package logtree
 
 import (
 	"errors"
+	"fmt"
 	"sync/atomic"
 )
 
@@ -29,6 +30,7 @@ type LogReadOption struct {
 	onlyLeveled                bool
 	onlyRaw                    bool
 	leveledWithMinimumSeverity Severity
+	format                     LogFormat
 }
 
 // WithChildren makes Read return/stream data for both a given DN and all its
@@ -51,9 +53,26 @@ func OnlyRaw() LogReadOption { return LogReadOption{onlyRaw: true} }
 
 func OnlyLeveled() LogReadOption { return LogReadOption{onlyLeveled: true} }
 
-// LeveledWithMinimumSeverity makes Read return only log entries that are at least
-// at a given Severity. If only leveled entries are needed, OnlyLeveled must be
-// used. This is a no-op when OnlyRaw is used.
+// LogFormat is the format in which LogReader.Format renders log entries.
+type LogFormat string
+
+const (
+	// LogFormatText renders log entries as per LogEntry.String. This is the
+	// default.
+	LogFormatText LogFormat = "text"
+	// LogFormatJSON renders log entries as single-line JSON objects, as per
+	// LogEntry.MarshalJSON.
+	LogFormatJSON LogFormat = "json"
+)
+
+// WithFormat makes the returned LogReader render log entries in the given
+// LogFormat when calling LogReader.Format.
+func WithFormat(f LogFormat) LogReadOption { return LogReadOption{format: f} }
+
+// LeveledWithMinimumSeverity makes Read return only leveled log entries that are
+// at least at a given Severity, as per Severity.AtLeast. Raw log entries carry no
+// severity and are not returned. This composes with WithChildren, ie. can be
+// used to retrieve entries at a given minimum severity across a whole subtree.
 func LeveledWithMinimumSeverity(s Severity) LogReadOption {
 	return LogReadOption{leveledWithMinimumSeverity: s}
 }
@@ -75,6 +94,27 @@ type LogReader struct {
 	// missed is an atomic integer pointer that tells the subscriber how many messages
 	// in Stream they missed. This pointer is nil if no streaming has been requested.
 	missed *uint64
+	// Stats are the statistics of the DN passed to Read (and all its children
+	// if WithChildren has been passed), keyed by DN, as of the time Read was
+	// called. Only DNs that have logged entries are present.
+	Stats map[DN]Stats
+	// format is the LogFormat used by Format.
+	format LogFormat
+}
+
+// Format renders the given log entry (eg. as received from Backlog or Stream)
+// in the LogFormat requested by WithFormat, or as text if none was requested.
+// JSON-rendered entries are always a single line, while text-rendered entries
+// might contain newlines.
+func (l *LogReader) Format(e *LogEntry) string {
+	if l.format == LogFormatJSON {
+		b, err := e.MarshalJSON()
+		if err != nil {
+			return fmt.Sprintf(`{"error":%q}`, err.Error())
+		}
+		return string(b)
+	}
+	return e.String()
 }
 
 // Missed returns the amount of entries that were missed from Stream (as the
@@ -104,15 +144,17 @@ var (
 // existing entries, a stream, or both. In addition the options also dictate
 // whether only entries for that particular DN are returned, or for all sub-DNs as
 // well.
+//
+// If both a backlog and a stream are requested, the stream continues exactly
+// where the backlog ends: every matching entry is delivered once, either in the
+// backlog or in the stream, even if entries are being logged concurrently.
 func (l *LogTree) Read(dn DN, opts ...LogReadOption) (*LogReader, error) {
-	l.journal.mu.RLock()
-	defer l.journal.mu.RUnlock()
-
 	var backlog int
 	var stream bool
 	var recursive bool
 	var leveledSeverity Severity
 	var onlyRaw, onlyLeveled bool
+	format := LogFormatText
 
 	for _, opt := range opts {
 		if opt.withBacklog > 0 || opt.withBacklog == BacklogAllAvailable {
@@ -133,28 +175,50 @@ func (l *LogTree) Read(dn DN, opts ...LogReadOption) (*LogReader, error) {
 		if opt.onlyRaw {
 			onlyRaw = true
 		}
+		if opt.format != "" {
+			format = opt.format
+		}
 	}
 
 	if onlyLeveled && onlyRaw {
 		return nil, ErrRawAndLeveled
 	}
+	if format != LogFormatText && format != LogFormatJSON {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
 
 	var filters []filter
+	var dnFilter filter
+	if recursive {
+		dnFilter = filterSubtree(dn)
+	} else {
+		dnFilter = filterExact(dn)
+	}
 	if onlyLeveled {
 		filters = append(filters, filterOnlyLeveled)
 	}
 	if onlyRaw {
 		filters = append(filters, filterOnlyRaw)
 	}
-	if recursive {
-		filters = append(filters, filterSubtree(dn))
-	} else {
-		filters = append(filters, filterExact(dn))
-	}
+	filters = append(filters, dnFilter)
 	if leveledSeverity != "" {
+		if !leveledSeverity.Valid() {
+			return nil, fmt.Errorf("invalid minimum severity %q", leveledSeverity)
+		}
 		filters = append(filters, filterSeverity(leveledSeverity))
 	}
 
+	// Retrieving the backlog and subscribing happens under the same lock which
+	// is held while entries are appended and subscribers notified, so no entry
+	// can fall in between. Subscribing requires the lock in W mode.
+	if stream {
+		l.journal.mu.Lock()
+		defer l.journal.mu.Unlock()
+	} else {
+		l.journal.mu.RLock()
+		defer l.journal.mu.RUnlock()
+	}
+
 	var entries []*entry
 	if backlog > 0 || backlog == BacklogAllAvailable {
 		if recursive {
@@ -175,7 +239,10 @@ func (l *LogTree) Read(dn DN, opts ...LogReadOption) (*LogReader, error) {
 		l.journal.subscribe(sub)
 	}
 
-	lr := &LogReader{}
+	lr := &LogReader{
+		Stats:  l.journal.statsMatching(dnFilter),
+		format: format,
+	}
 	lr.Backlog = make([]*LogEntry, len(entries))
 	for i, entry := range entries {
 		lr.Backlog[i] = entry.external()
@@ -0,0 +1,165 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logtree
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// dedup is the per-node state of leveled log deduplication. When enabled,
+// consecutive leveled entries with the same severity and message are not
+// appended to the journal. Instead, their number is counted and a summary
+// entry is emitted once a different message gets logged, or once the configured
+// window has elapsed since the last entry (or summary) that made it into the
+// journal. The latter is driven by a timer, so that repeats are reported even if
+// the node doesn't log anything afterwards.
+type dedup struct {
+	// mu guards all fields below and is held while appending entries to the
+	// journal, so that summaries are always ordered right after the entries they
+	// summarize.
+	mu sync.Mutex
+	// window is the deduplication window. Zero means deduplication is disabled.
+	window time.Duration
+	// last is the last leveled payload appended to the journal for this node, or
+	// nil if none has yet been appended since deduplication got enabled.
+	last *LeveledPayload
+	// emitted is the time at which last or the last summary was appended.
+	emitted time.Time
+	// repeats is the number of times last has been logged again but not yet
+	// been reported via a summary.
+	repeats int
+	// timer, if set, flushes pending repeats once the window has elapsed. It is
+	// armed whenever the first unreported repeat gets counted.
+	timer *time.Timer
+}
+
+// SetDeduplication enables deduplication of consecutive identical leveled log
+// entries for a given DN (non-recursively, ie. for that DN only, not its
+// children). Repeated entries are collapsed into a single 'last message
+// repeated N times' entry, emitted when the message changes or once window has
+// elapsed. A window of zero disables deduplication.
+func (l *LogTree) SetDeduplication(dn DN, window time.Duration) error {
+	if window < 0 {
+		return fmt.Errorf("deduplication window must not be negative")
+	}
+	node, err := l.nodeByDN(dn)
+	if err != nil {
+		return err
+	}
+	node.dedup.mu.Lock()
+	defer node.dedup.mu.Unlock()
+	if window == 0 {
+		node.flushRepeatsLocked(time.Now())
+		node.dedup.last = nil
+	}
+	node.dedup.window = window
+	if window != 0 && node.dedup.repeats > 0 {
+		node.armFlushLocked(time.Until(node.dedup.emitted.Add(window)))
+	}
+	return nil
+}
+
+// logLeveled appends a leveled payload as an entry of this node to the
+// journal, deduplicating it if so configured.
+func (n *node) logLeveled(p *LeveledPayload) {
+	n.dedup.mu.Lock()
+	defer n.dedup.mu.Unlock()
+
+	if n.dedup.window == 0 {
+		n.append(p)
+		return
+	}
+
+	if last := n.dedup.last; last != nil && last.severity == p.severity && slices.Equal(last.messages, p.messages) {
+		n.dedup.repeats += 1
+		if p.timestamp.Sub(n.dedup.emitted) >= n.dedup.window {
+			n.flushRepeatsLocked(p.timestamp)
+		} else if n.dedup.repeats == 1 {
+			n.armFlushLocked(n.dedup.emitted.Add(n.dedup.window).Sub(p.timestamp))
+		}
+		return
+	}
+
+	n.flushRepeatsLocked(p.timestamp)
+	n.append(p)
+	n.dedup.last = p
+	n.dedup.emitted = p.timestamp
+}
+
+// armFlushLocked makes the flush timer fire after the given duration.
+// dedup.mu must be held.
+func (n *node) armFlushLocked(d time.Duration) {
+	if n.dedup.timer == nil {
+		n.dedup.timer = time.AfterFunc(d, n.flushExpired)
+		return
+	}
+	n.dedup.timer.Reset(d)
+}
+
+// flushExpired is called by the flush timer and appends a summary for pending
+// repeats if the window has elapsed since the last emitted entry. As the timer
+// can race with logLeveled, it re-checks the state and re-arms itself if it
+// fired early.
+func (n *node) flushExpired() {
+	n.dedup.mu.Lock()
+	defer n.dedup.mu.Unlock()
+
+	if n.dedup.window == 0 || n.dedup.repeats == 0 {
+		return
+	}
+	now := time.Now()
+	if remaining := n.dedup.emitted.Add(n.dedup.window).Sub(now); remaining > 0 {
+		n.dedup.timer.Reset(remaining)
+		return
+	}
+	n.flushRepeatsLocked(now)
+}
+
+// flushRepeatsLocked appends a summary entry for any repeats of the last
+// message which haven't yet been reported. dedup.mu must be held.
+func (n *node) flushRepeatsLocked(now time.Time) {
+	last := n.dedup.last
+	if last == nil || n.dedup.repeats == 0 {
+		return
+	}
+	times := "times"
+	if n.dedup.repeats == 1 {
+		times = "time"
+	}
+	n.append(&LeveledPayload{
+		timestamp: now,
+		severity:  last.severity,
+		messages:  []string{fmt.Sprintf("last message repeated %d %s", n.dedup.repeats, times)},
+		file:      last.file,
+		line:      last.line,
+	})
+	n.dedup.repeats = 0
+	n.dedup.emitted = now
+}
+
+// append creates a new entry for the given leveled payload, appends it to the
+// journal and notifies all pertinent subscribers.
+func (n *node) append(p *LeveledPayload) {
+	e := &entry{
+		origin:  n.dn,
+		leveled: p,
+	}
+	n.tree.journal.append(e)
+}
@@ -17,8 +17,10 @@
 package logtree
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/go-wordwrap"
 
@@ -59,6 +61,49 @@ func (l *LogEntry) String() string {
 	return "INVALID"
 }
 
+// jsonEntry is the JSON representation of a LogEntry, as emitted by
+// MarshalJSON. Its fields must not be renamed or removed, as consumers depend
+// on them being stable.
+type jsonEntry struct {
+	DN string `json:"dn"`
+	// Kind is either "leveled" or "raw".
+	Kind      string         `json:"kind"`
+	Timestamp string         `json:"timestamp,omitempty"`
+	Severity  string         `json:"severity,omitempty"`
+	Verbosity VerbosityLevel `json:"verbosity,omitempty"`
+	Location  string         `json:"location,omitempty"`
+	Message   string         `json:"message"`
+}
+
+// MarshalJSON returns a JSON representation of this log entry as a single line,
+// suitable for consumption by log collectors. It contains the DN, the kind of
+// entry (leveled or raw) and the message. Leveled entries also contain the
+// timestamp (in RFC3339 format with nanoseconds, in UTC), the severity, the
+// verbosity level (if logged through a V-logger) and the source location.
+//
+// Multi-line leveled entries are emitted as a single message joined by
+// newlines. Truncated raw entries are marked with an ellipsis, like in String.
+func (l *LogEntry) MarshalJSON() ([]byte, error) {
+	e := jsonEntry{
+		DN: string(l.DN),
+	}
+	switch {
+	case l.Leveled != nil:
+		e.Kind = "leveled"
+		e.Timestamp = l.Leveled.Timestamp().UTC().Format(time.RFC3339Nano)
+		e.Severity = string(l.Leveled.Severity())
+		e.Verbosity = l.Leveled.Verbosity()
+		e.Location = l.Leveled.Location()
+		e.Message = l.Leveled.MessagesJoined()
+	case l.Raw != nil:
+		e.Kind = "raw"
+		e.Message = l.Raw.String()
+	default:
+		return nil, fmt.Errorf("invalid log entry")
+	}
+	return json.Marshal(e)
+}
+
 // ConciseString returns a concise representation of this log entry for
 // constrained environments, like TTY consoles.
 //
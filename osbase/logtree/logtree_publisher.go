@@ -90,7 +90,6 @@ func (n *node) logRaw(line *logbuffer.Line) {
 		raw:    line,
 	}
 	n.tree.journal.append(e)
-	n.tree.journal.notify(e)
 }
 
 // LogExternalLeveled injects a ExternalLeveledPayload into a given
@@ -102,20 +101,14 @@ func LogExternalLeveled(l LeveledLogger, e *ExternalLeveledPayload) error {
 	if !ok {
 		return fmt.Errorf("the given LeveledLogger is not a *leveledPublisher")
 	}
-	p := e.sanitize()
-	entry := &entry{
-		origin:  publisher.node.dn,
-		leveled: p,
-	}
-	publisher.node.tree.journal.append(entry)
-	publisher.node.tree.journal.notify(entry)
+	publisher.node.logLeveled(e.sanitize())
 	return nil
 }
 
 // log builds a LeveledPayload and entry for a given message, including all related
-// metadata. It will create a new entry append it to the journal, and notify all
-// pertinent subscribers.
-func (l *leveledPublisher) logLeveled(depth int, severity Severity, msg string) {
+// metadata. It will create a new entry append it to the journal (subject to
+// deduplication, see SetDeduplication), and notify all pertinent subscribers.
+func (l *leveledPublisher) logLeveled(depth int, severity Severity, verbosity VerbosityLevel, msg string) {
 	_, file, line, ok := runtime.Caller(2 + depth)
 	if !ok {
 		file = "???"
@@ -133,56 +126,52 @@ func (l *leveledPublisher) logLeveled(depth int, severity Severity, msg string)
 	p := &LeveledPayload{
 		timestamp: time.Now(),
 		severity:  severity,
+		verbosity: verbosity,
 		messages:  messages,
 		file:      file,
 		line:      line,
 	}
-	e := &entry{
-		origin:  l.node.dn,
-		leveled: p,
-	}
-	l.node.tree.journal.append(e)
-	l.node.tree.journal.notify(e)
+	l.node.logLeveled(p)
 }
 
 // Info implements the LeveledLogger interface.
 func (l *leveledPublisher) Info(args ...interface{}) {
-	l.logLeveled(l.depth, INFO, fmt.Sprint(args...))
+	l.logLeveled(l.depth, INFO, 0, fmt.Sprint(args...))
 }
 
 // Infof implements the LeveledLogger interface.
 func (l *leveledPublisher) Infof(format string, args ...interface{}) {
-	l.logLeveled(l.depth, INFO, fmt.Sprintf(format, args...))
+	l.logLeveled(l.depth, INFO, 0, fmt.Sprintf(format, args...))
 }
 
 // Warning implements the LeveledLogger interface.
 func (l *leveledPublisher) Warning(args ...interface{}) {
-	l.logLeveled(l.depth, WARNING, fmt.Sprint(args...))
+	l.logLeveled(l.depth, WARNING, 0, fmt.Sprint(args...))
 }
 
 // Warningf implements the LeveledLogger interface.
 func (l *leveledPublisher) Warningf(format string, args ...interface{}) {
-	l.logLeveled(l.depth, WARNING, fmt.Sprintf(format, args...))
+	l.logLeveled(l.depth, WARNING, 0, fmt.Sprintf(format, args...))
 }
 
 // Error implements the LeveledLogger interface.
 func (l *leveledPublisher) Error(args ...interface{}) {
-	l.logLeveled(l.depth, ERROR, fmt.Sprint(args...))
+	l.logLeveled(l.depth, ERROR, 0, fmt.Sprint(args...))
 }
 
 // Errorf implements the LeveledLogger interface.
 func (l *leveledPublisher) Errorf(format string, args ...interface{}) {
-	l.logLeveled(l.depth, ERROR, fmt.Sprintf(format, args...))
+	l.logLeveled(l.depth, ERROR, 0, fmt.Sprintf(format, args...))
 }
 
 // Fatal implements the LeveledLogger interface.
 func (l *leveledPublisher) Fatal(args ...interface{}) {
-	l.logLeveled(l.depth, FATAL, fmt.Sprint(args...))
+	l.logLeveled(l.depth, FATAL, 0, fmt.Sprint(args...))
 }
 
 // Fatalf implements the LeveledLogger interface.
 func (l *leveledPublisher) Fatalf(format string, args ...interface{}) {
-	l.logLeveled(l.depth, FATAL, fmt.Sprintf(format, args...))
+	l.logLeveled(l.depth, FATAL, 0, fmt.Sprintf(format, args...))
 }
 
 // WithAddedStackDepth impleemnts the LeveledLogger interface.
@@ -196,6 +185,7 @@ func (l *leveledPublisher) WithAddedStackDepth(depth int) LeveledLogger {
 func (l *leveledPublisher) V(v VerbosityLevel) VerboseLeveledLogger {
 	return &verbose{
 		publisher: l,
+		level:     v,
 		enabled:   l.node.verbosity >= v,
 	}
 }
@@ -207,6 +197,7 @@ func (l *leveledPublisher) V(v VerbosityLevel) VerboseLeveledLogger {
 type verbose struct {
 	publisher *leveledPublisher
 	node      *node
+	level     VerbosityLevel
 	enabled   bool
 }
 
@@ -218,12 +209,12 @@ func (v *verbose) Info(args ...interface{}) {
 	if !v.enabled {
 		return
 	}
-	v.publisher.logLeveled(v.publisher.depth, INFO, fmt.Sprint(args...))
+	v.publisher.logLeveled(v.publisher.depth, INFO, v.level, fmt.Sprint(args...))
 }
 
 func (v *verbose) Infof(format string, args ...interface{}) {
 	if !v.enabled {
 		return
 	}
-	v.publisher.logLeveled(v.publisher.depth, INFO, fmt.Sprintf(format, args...))
+	v.publisher.logLeveled(v.publisher.depth, INFO, v.level, fmt.Sprintf(format, args...))
 }
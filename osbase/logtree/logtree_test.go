@@ -17,10 +17,13 @@
 package logtree
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func expect(tree *LogTree, t *testing.T, dn DN, entries ...string) string {
@@ -185,6 +188,91 @@ func TestStream(t *testing.T) {
 	}
 }
 
+// TestBacklogAndStream ensures that reading with both a backlog and a stream
+// delivers every entry exactly once, even if entries are logged concurrently
+// with the Read call.
+func TestBacklogAndStream(t *testing.T) {
+	// Fewer entries than fit into the stream buffer, so that none are missed
+	// regardless of how fast they are received.
+	const writers = 4
+	const perWriter = 25
+	const readers = 8
+	for attempt := 0; attempt < 100; attempt++ {
+		tree := New()
+		logger := tree.MustLeveledFor("main")
+
+		startC := make(chan struct{})
+		for w := 0; w < writers; w++ {
+			go func(w int) {
+				<-startC
+				for i := 0; i < perWriter; i++ {
+					logger.Infof("%d-%d", w, i)
+				}
+			}(w)
+		}
+		errC := make(chan error)
+		for r := 0; r < readers; r++ {
+			go func() {
+				<-startC
+				errC <- readBacklogAndStream(tree, writers, perWriter)
+			}()
+		}
+
+		close(startC)
+		for r := 0; r < readers; r++ {
+			if err := <-errC; err != nil {
+				t.Fatalf("attempt %d: %v", attempt, err)
+			}
+		}
+	}
+}
+
+// readBacklogAndStream reads all entries logged to "main" by
+// TestBacklogAndStream using both a backlog and a stream, and ensures that each
+// of them is received exactly once.
+func readBacklogAndStream(tree *LogTree, writers, perWriter int) error {
+	res, err := tree.Read("main", WithBacklog(BacklogAllAvailable), WithStream())
+	if err != nil {
+		return fmt.Errorf("Read: %w", err)
+	}
+	defer res.Close()
+
+	got := make(map[string]int)
+	received := 0
+	for _, e := range res.Backlog {
+		got[e.Leveled.MessagesJoined()]++
+		received++
+	}
+	timeout := time.After(10 * time.Second)
+	for received < writers*perWriter {
+		select {
+		case e := <-res.Stream:
+			got[e.Leveled.MessagesJoined()]++
+			received++
+		case <-timeout:
+			return fmt.Errorf("timed out after %d entries", received)
+		}
+	}
+	// Give duplicates a chance to show up.
+	select {
+	case e := <-res.Stream:
+		return fmt.Errorf("unexpected entry %q after all entries were received", e.Leveled.MessagesJoined())
+	case <-time.After(time.Millisecond):
+	}
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			if n := got[fmt.Sprintf("%d-%d", w, i)]; n != 1 {
+				return fmt.Errorf("entry %d-%d received %d times", w, i, n)
+			}
+		}
+	}
+	if missed := res.Missed(); missed != 0 {
+		return fmt.Errorf("missed %d entries", missed)
+	}
+	return nil
+}
+
 func TestVerbose(t *testing.T) {
 	tree := New()
 
@@ -270,6 +358,58 @@ func TestSeverity(t *testing.T) {
 	}
 }
 
+func TestSeveritySubtree(t *testing.T) {
+	tree := New()
+	tree.MustLeveledFor("main.foo").Info("i am informative")
+	tree.MustLeveledFor("main.foo").Error("i am an error")
+	tree.MustLeveledFor("other").Error("i am an error elsewhere")
+
+	reader, err := tree.Read("main", WithChildren(), WithBacklog(BacklogAllAvailable), LeveledWithMinimumSeverity(ERROR))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if want, got := 1, len(reader.Backlog); want != got {
+		t.Fatalf("wanted %d entries, got %d", want, got)
+	}
+	if want, got := "i am an error", reader.Backlog[0].Leveled.MessagesJoined(); want != got {
+		t.Fatalf("wanted entry %q, got %q", want, got)
+	}
+
+	if _, err := tree.Read("main", LeveledWithMinimumSeverity("X")); err == nil {
+		t.Fatalf("wanted error on invalid severity")
+	}
+}
+
+// TestStreamFilters ensures that filters are applied to streamed entries, not
+// only to the backlog.
+func TestStreamFilters(t *testing.T) {
+	tree := New()
+
+	reader, err := tree.Read("main", WithChildren(), WithStream(), LeveledWithMinimumSeverity(ERROR))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer reader.Close()
+
+	tree.MustLeveledFor("main.foo").Info("i am informative")
+	tree.MustLeveledFor("main.foo").Error("i am an error")
+	tree.MustLeveledFor("other").Error("i am an error elsewhere")
+
+	select {
+	case e := <-reader.Stream:
+		if want, got := "i am an error", e.Leveled.MessagesJoined(); want != got {
+			t.Fatalf("wanted streamed entry %q, got %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no entry streamed")
+	}
+	select {
+	case e := <-reader.Stream:
+		t.Fatalf("unexpected streamed entry %q", e.String())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 func TestAddedStackDepth(t *testing.T) {
 	tree := New()
 	helper := func(msg string) {
@@ -384,3 +524,173 @@ func TestLogEntry_ConciseString(t *testing.T) {
 		}
 	}
 }
+
+func TestDeduplication(t *testing.T) {
+	tree := New()
+	if err := tree.SetDeduplication("main", time.Hour); err != nil {
+		t.Fatalf("SetDeduplication: %v", err)
+	}
+	l := tree.MustLeveledFor("main")
+	for i := 0; i < 5; i++ {
+		l.Error("stuck")
+	}
+	// Same message at a different severity is not a repeat.
+	l.Warning("stuck")
+	l.Warning("stuck")
+	l.Info("unstuck")
+	// Other DNs are not affected.
+	for i := 0; i < 2; i++ {
+		tree.MustLeveledFor("other").Info("not deduplicated")
+	}
+
+	got := readBacklog(tree, t, "main", BacklogAllAvailable, false)
+	want := []string{
+		"stuck",
+		"last message repeated 4 times",
+		"stuck",
+		"last message repeated 1 time",
+		"unstuck",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected main backlog (-want +got):\n%s", diff)
+	}
+	if got := readBacklog(tree, t, "other", BacklogAllAvailable, false); len(got) != 2 {
+		t.Errorf("wanted 2 entries in other, got %d", len(got))
+	}
+}
+
+func TestDeduplicationWindow(t *testing.T) {
+	tree := New()
+	if err := tree.SetDeduplication("main", 10*time.Millisecond); err != nil {
+		t.Fatalf("SetDeduplication: %v", err)
+	}
+	l := tree.MustLeveledFor("main")
+	l.Info("stuck")
+	l.Info("stuck")
+	l.Info("stuck")
+
+	// Without any further logging, the repeats must be reported once the
+	// window elapses.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := readBacklog(tree, t, "main", BacklogAllAvailable, false)
+		if len(got) == 2 {
+			if diff := cmp.Diff([]string{"stuck", "last message repeated 2 times"}, got); diff != "" {
+				t.Fatalf("unexpected backlog after window (-want +got):\n%s", diff)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("repeats not flushed after window, backlog: %v", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	l.Info("stuck")
+	// Disabling deduplication flushes pending repeats.
+	if err := tree.SetDeduplication("main", 0); err != nil {
+		t.Fatalf("SetDeduplication: %v", err)
+	}
+	l.Info("stuck")
+
+	got := readBacklog(tree, t, "main", BacklogAllAvailable, false)
+	want := []string{
+		"stuck",
+		"last message repeated 2 times",
+		"last message repeated 1 time",
+		"stuck",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected backlog (-want +got):\n%s", diff)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	tree := New()
+	n, err := tree.nodeByDN("main")
+	if err != nil {
+		t.Fatalf("nodeByDN: %v", err)
+	}
+	n.verbosity = 2
+	tree.MustLeveledFor("main").Infof("hello\nworld")
+	tree.MustLeveledFor("main").V(2).Infof("verbose")
+	tree.MustRawFor("main.raw").Write([]byte("raw line\n"))
+
+	res, err := tree.Read("main", WithChildren(), WithBacklog(BacklogAllAvailable), WithFormat(LogFormatJSON))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer res.Close()
+	if want, got := 3, len(res.Backlog); want != got {
+		t.Fatalf("wanted %d backlog entries, got %d", want, got)
+	}
+
+	var got []map[string]interface{}
+	for _, e := range res.Backlog {
+		line := res.Format(e)
+		if strings.Contains(line, "\n") {
+			t.Errorf("JSON line contains newline: %q", line)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", line, err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(m["timestamp"])); m["kind"] == "leveled" && err != nil {
+			t.Errorf("Invalid timestamp in %q: %v", line, err)
+		}
+		delete(m, "timestamp")
+		delete(m, "location")
+		got = append(got, m)
+	}
+	want := []map[string]interface{}{
+		{"dn": "main", "kind": "leveled", "severity": "I", "message": "hello\nworld"},
+		{"dn": "main", "kind": "leveled", "severity": "I", "verbosity": float64(2), "message": "verbose"},
+		{"dn": "main.raw", "kind": "raw", "message": "raw line"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected JSON entries (-want +got):\n%s", diff)
+	}
+
+	text, err := tree.Read("main", WithBacklog(1))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer text.Close()
+	if want, got := text.Backlog[0].String(), text.Format(text.Backlog[0]); want != got {
+		t.Errorf("Text format differs from String: %q vs %q", got, want)
+	}
+}
+
+func TestReaderStats(t *testing.T) {
+	tree := New()
+	for i := 0; i < 9000; i += 1 {
+		tree.MustLeveledFor("main.chatty").Infof("chatty %d", i)
+	}
+	tree.MustLeveledFor("main.solemn").Info("solemn")
+	tree.MustLeveledFor("other").Info("other")
+
+	res, err := tree.Read("main", WithChildren())
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer res.Close()
+	want := map[DN]Stats{
+		"main.chatty": {Evicted: 9000 - 8192},
+		"main.solemn": {},
+	}
+	if diff := cmp.Diff(want, res.Stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+
+	res, err = tree.Read("main.solemn")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer res.Close()
+	want = map[DN]Stats{
+		"main.solemn": {},
+	}
+	if diff := cmp.Diff(want, res.Stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+}
@@ -66,7 +66,6 @@ func (z *zapSink) consumeLine(l *logbuffer.Line) {
 		},
 	}
 	z.publisher.node.tree.journal.append(e)
-	z.publisher.node.tree.journal.notify(e)
 }
 
 type zapEntry struct {
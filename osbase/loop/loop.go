@@ -93,15 +93,24 @@ type Config struct {
 }
 
 func (c *Config) validate() error {
-	// Additional validation because of inconsistent kernel-side enforcement
 	if c.BlockSize != 0 {
-		if c.BlockSize < 512 || c.BlockSize > uint32(os.Getpagesize()) || bits.OnesCount32(c.BlockSize) > 1 {
-			return errors.New("BlockSize needs to be a power of two between 512 bytes and the OS page size")
+		if err := validateBlockSize(c.BlockSize); err != nil {
+			return fmt.Errorf("BlockSize %w", err)
 		}
 	}
 	return nil
 }
 
+// validateBlockSize checks if size is a valid logical block size for a loop
+// device. This is additional validation because of inconsistent kernel-side
+// enforcement.
+func validateBlockSize(size uint32) error {
+	if size < 512 || size > uint32(os.Getpagesize()) || bits.OnesCount32(size) > 1 {
+		return errors.New("needs to be a power of two between 512 bytes and the OS page size")
+	}
+	return nil
+}
+
 // ensureFds lazily initializes control devices
 func ensureFds() (err error) {
 	mutex.Lock()
@@ -237,6 +246,33 @@ func (d *Device) RefreshSize() error {
 	return unix.IoctlSetInt(int(d.dev.Fd()), unix.LOOP_SET_CAPACITY, 0)
 }
 
+// BlockSize returns the logical block size of the loop device in bytes.
+func (d *Device) BlockSize() (uint32, error) {
+	if err := d.ensureOpen(); err != nil {
+		return 0, err
+	}
+	size, err := unix.IoctlGetInt(int(d.dev.Fd()), unix.BLKSSZGET)
+	if err != nil {
+		return 0, os.NewSyscallError("ioctl(BLKSSZGET)", err)
+	}
+	return uint32(size), nil
+}
+
+// SetBlockSize sets the logical block size of the loop device in bytes. It
+// needs to be a power of two between 512 bytes and the OS page size.
+func (d *Device) SetBlockSize(size uint32) error {
+	if err := d.ensureOpen(); err != nil {
+		return err
+	}
+	if err := validateBlockSize(size); err != nil {
+		return fmt.Errorf("block size %w", err)
+	}
+	if err := unix.IoctlSetInt(int(d.dev.Fd()), unix.LOOP_SET_BLOCK_SIZE, int(size)); err != nil {
+		return os.NewSyscallError("ioctl(LOOP_SET_BLOCK_SIZE)", err)
+	}
+	return nil
+}
+
 // Close closes all file descriptors open to the device. Does not remove the
 // device itself or alter its configuration.
 func (d *Device) Close() error {
@@ -201,6 +201,24 @@ func TestResize(t *testing.T) {
 	require.Equal(t, uint64(96*1024), getBlkdevSize(dev.dev))
 }
 
+func TestBlockSize(t *testing.T) {
+	if os.Getenv("IN_KTEST") != "true" {
+		t.Skip("Not in ktest")
+	}
+	dev := setupCreate(t, Config{BlockSize: 512})
+	size, err := dev.BlockSize()
+	assert.NoError(t, err)
+	require.Equal(t, uint32(512), size)
+
+	assert.NoError(t, dev.SetBlockSize(4096))
+	size, err = dev.BlockSize()
+	assert.NoError(t, err)
+	require.Equal(t, uint32(4096), size)
+
+	assert.Error(t, dev.SetBlockSize(256))
+	assert.Error(t, dev.SetBlockSize(1000))
+}
+
 func TestStructSize(t *testing.T) {
 	if runtime.GOOS != "linux" && runtime.GOARCH != "amd64" {
 		t.Skip("Reference value not available")
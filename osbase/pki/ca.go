@@ -61,6 +61,9 @@ func issueCertificate(req *Certificate, ca *x509.Certificate, caKey ed25519.Priv
 	req.Template.SerialNumber = serialNumber
 	req.Template.NotBefore = time.Now()
 	req.Template.NotAfter = UnknownNotAfter
+	if req.Lifetime > 0 {
+		req.Template.NotAfter = req.Template.NotBefore.Add(req.Lifetime)
+	}
 	req.Template.BasicConstraintsValid = true
 	req.Template.SubjectKeyId = skid
 
@@ -28,6 +28,7 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 
@@ -107,6 +108,17 @@ type Certificate struct {
 	// the user for External or Ephemeral certificates, and will be populated by the
 	// next Ensure call if missing.
 	PublicKey ed25519.PublicKey
+
+	// Lifetime, if set, is the duration for which certificates issued for this
+	// Certificate are valid. Otherwise, they never expire (see UnknownNotAfter).
+	Lifetime time.Duration
+
+	// RenewalWindow, if set, makes Ensure re-issue a Managed or External
+	// certificate stored in etcd if it expires within this duration. The
+	// renewed certificate keeps the key of the previous one and replaces it in
+	// etcd. Certificates which outlive Lifetime are renewed as well. This
+	// should only be set together with Lifetime.
+	RenewalWindow time.Duration
 }
 
 func (n *Namespace) etcdPath(f string, args ...interface{}) string {
@@ -207,6 +219,9 @@ func (c *Certificate) ensure(ctx context.Context, kv clientv3.KV) (cert []byte,
 			return nil, fmt.Errorf("certificate stored in etcd emitted for different public key")
 		}
 		// TODO(q3k): ensure issuer and template haven't changed
+		if c.needsRenewal(cert) {
+			return c.renew(ctx, kv, certPath, certRes.Kvs[0].ModRevision)
+		}
 		return certBytes, nil
 	}
 
@@ -232,6 +247,42 @@ func (c *Certificate) ensure(ctx context.Context, kv clientv3.KV) (cert []byte,
 	return
 }
 
+// needsRenewal returns whether the given certificate stored in etcd for c must
+// be renewed, either because it expires within RenewalWindow, or because it
+// outlives Lifetime, eg. because it has been issued before Lifetime was set.
+func (c *Certificate) needsRenewal(cert *x509.Certificate) bool {
+	if c.RenewalWindow == 0 {
+		return false
+	}
+	if time.Until(cert.NotAfter) < c.RenewalWindow {
+		return true
+	}
+	return c.Lifetime > 0 && cert.NotAfter.After(time.Now().Add(c.Lifetime))
+}
+
+// renew re-issues a certificate stored in etcd at certPath and replaces it, as
+// long as it has not been modified since the given revision.
+func (c *Certificate) renew(ctx context.Context, kv clientv3.KV, certPath string, rev int64) ([]byte, error) {
+	cert, err := c.Issuer.Issue(ctx, c, kv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew: %w", err)
+	}
+	res, err := kv.Txn(ctx).
+		If(
+			clientv3.Compare(clientv3.ModRevision(certPath), "=", rev),
+		).
+		Then(
+			clientv3.OpPut(certPath, string(cert)),
+		).Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write renewed certificate: %w", err)
+	}
+	if !res.Succeeded {
+		return nil, fmt.Errorf("certificate renewal transaction failed: concurrent write")
+	}
+	return cert, nil
+}
+
 // ensureKey retrieves or creates PublicKey as needed based on the Certificate
 // Mode. For Managed Certificates and Ephemeral Certificates with no PrivateKey
 // it will also populate PrivateKay.
@@ -6,7 +6,9 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/x509"
+	"math/big"
 	"testing"
+	"time"
 
 	"go.etcd.io/etcd/client/pkg/v3/testutil"
 	"go.etcd.io/etcd/tests/v3/integration"
@@ -190,3 +192,133 @@ func TestExternal(t *testing.T) {
 		t.Errorf("New server certificate has different x509 certificate")
 	}
 }
+
+// TestRenewal ensures that certificates with a finite lifetime get renewed by
+// Ensure once they are about to expire, keeping their key.
+func TestRenewal(t *testing.T) {
+	lt := logtree.New()
+	logtree.PipeAllToTest(t, lt)
+	tb, cancel := testutil.NewTestingTBProthesis("pki-renewal")
+	defer cancel()
+	cluster := integration.NewClusterV3(tb, &integration.ClusterConfig{
+		Size: 1,
+		LoggerBuilder: func(memberName string) *zap.Logger {
+			dn := logtree.DN("etcd." + memberName)
+			return logtree.Zapify(lt.MustLeveledFor(dn), zap.WarnLevel)
+		},
+	})
+	cl := cluster.Client(0)
+	defer cluster.Terminate(tb)
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+	ns := Namespaced("/test-renewal/")
+
+	ca := &Certificate{
+		Namespace: &ns,
+		Issuer:    SelfSigned,
+		Name:      "ca",
+		Template:  CA("Test CA"),
+	}
+	caBytes, err := ca.Ensure(ctx, cl)
+	if err != nil {
+		t.Fatalf("Failed to Ensure CA: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+	if !caCert.NotAfter.Equal(UnknownNotAfter) {
+		t.Errorf("CA certificate expires at %v, wanted %v", caCert.NotAfter, UnknownNotAfter)
+	}
+
+	lifetime := 24 * time.Hour
+	client := &Certificate{
+		Namespace:     &ns,
+		Issuer:        ca,
+		Name:          "client",
+		Template:      Client("foo", nil),
+		Lifetime:      lifetime,
+		RenewalWindow: time.Hour,
+	}
+	// checkLifetime ensures that the given certificate has been issued with
+	// the configured lifetime just now.
+	checkLifetime := func(t *testing.T, cert *x509.Certificate) {
+		t.Helper()
+		if want, got := lifetime, cert.NotAfter.Sub(cert.NotBefore); want != got {
+			t.Errorf("Certificate valid for %v, wanted %v", got, want)
+		}
+		if d := time.Since(cert.NotBefore); d < -time.Second || d > time.Minute {
+			t.Errorf("Certificate not issued just now (NotBefore %v)", cert.NotBefore)
+		}
+	}
+
+	clientBytes, err := client.Ensure(ctx, cl)
+	if err != nil {
+		t.Fatalf("Failed to Ensure client certificate: %v", err)
+	}
+	clientCert, err := x509.ParseCertificate(clientBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse client certificate: %v", err)
+	}
+	checkLifetime(t, clientCert)
+
+	// A certificate which is far from expiry should not get renewed.
+	clientBytes2, err := client.Ensure(ctx, cl)
+	if err != nil {
+		t.Fatalf("Failed to re-Ensure client certificate: %v", err)
+	}
+	if !bytes.Equal(clientBytes, clientBytes2) {
+		t.Errorf("Client certificate got renewed although it does not expire soon")
+	}
+
+	certPath := ns.etcdPath("issued/%s-cert.der", "client")
+	for _, te := range []struct {
+		name     string
+		notAfter time.Time
+	}{
+		// A certificate which expires within the renewal window.
+		{"Expiring", time.Now().Add(10 * time.Minute)},
+		// A certificate issued before the lifetime was set.
+		{"NeverExpiring", UnknownNotAfter},
+	} {
+		t.Run(te.name, func(t *testing.T) {
+			// Replace the stored certificate.
+			template := Client("foo", nil)
+			template.SerialNumber = big.NewInt(1)
+			template.NotBefore = time.Now().Add(-time.Hour)
+			template.NotAfter = te.notAfter
+			stored, err := x509.CreateCertificate(rand.Reader, &template, caCert, client.PublicKey, ca.PrivateKey)
+			if err != nil {
+				t.Fatalf("CreateCertificate: %v", err)
+			}
+			if _, err := cl.Put(ctx, certPath, string(stored)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			renewedBytes, err := client.Ensure(ctx, cl)
+			if err != nil {
+				t.Fatalf("Failed to Ensure client certificate: %v", err)
+			}
+			renewed, err := x509.ParseCertificate(renewedBytes)
+			if err != nil {
+				t.Fatalf("Failed to parse renewed certificate: %v", err)
+			}
+			checkLifetime(t, renewed)
+			if !bytes.Equal(renewed.PublicKey.(ed25519.PublicKey), client.PublicKey) {
+				t.Errorf("Renewed certificate has different public key")
+			}
+			if err := renewed.CheckSignatureFrom(caCert); err != nil {
+				t.Errorf("Renewed certificate not signed by CA: %v", err)
+			}
+
+			// The renewed certificate should have been stored in etcd.
+			res, err := cl.Get(ctx, certPath)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if len(res.Kvs) != 1 || !bytes.Equal(res.Kvs[0].Value, renewedBytes) {
+				t.Errorf("Renewed certificate not stored in etcd")
+			}
+		})
+	}
+}
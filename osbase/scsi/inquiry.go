@@ -298,3 +298,39 @@ func (d *Device) UnitSerialNumber() (string, error) {
 	}
 	return string(bytes.Trim(serial, " \x00")), nil
 }
+
+// Identity contains the basic identifying information of a device.
+type Identity struct {
+	Vendor   string
+	Product  string
+	Revision string
+	// Serial is the unit serial number of the device, or empty if the device
+	// does not provide one.
+	Serial string
+}
+
+// Identify returns the vendor, product, revision and serial number of the
+// device by issuing a standard INQUIRY and, if supported by the device, a Unit
+// Serial Number VPD INQUIRY.
+func (d *Device) Identify() (*Identity, error) {
+	inq, err := d.Inquiry()
+	if err != nil {
+		return nil, err
+	}
+	res := Identity{
+		Vendor:   inq.Vendor,
+		Product:  inq.Product,
+		Revision: inq.ProductRevisionLevel,
+	}
+	pages, err := d.SupportedVPDPages()
+	if err != nil {
+		return nil, fmt.Errorf("while getting supported VPD pages: %w", err)
+	}
+	if pages[UnitSerialNumberVPD] {
+		res.Serial, err = d.UnitSerialNumber()
+		if err != nil {
+			return nil, fmt.Errorf("while getting unit serial number: %w", err)
+		}
+	}
+	return &res, nil
+}
@@ -2,6 +2,7 @@
 package scsi
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
@@ -56,6 +57,9 @@ const (
 	InquiryOp        OperationCode = 0x12
 	ReadDefectDataOp OperationCode = 0x37
 	LogSenseOp       OperationCode = 0x4d
+	// ATAPassThrough16Op tunnels an ATA command through a SCSI/ATA
+	// translation layer (SAT).
+	ATAPassThrough16Op OperationCode = 0x85
 )
 
 // CommandDataBuffer represents a command
@@ -245,6 +249,69 @@ func (e FixedError) Error() string {
 
 }
 
+// DescriptorError is one type of error returned by a SCSI CHECK_CONDITION.
+// See also Table 28 in the standard.
+type DescriptorError struct {
+	Deferred            bool
+	SenseKey            SenseKey
+	AdditionalSenseCode AdditionalSenseCode
+	// Descriptors contains the raw sense data descriptors.
+	Descriptors []byte
+}
+
+func (e DescriptorError) Error() string {
+	if e.AdditionalSenseCode == 0 {
+		return fmt.Sprintf("%v", e.SenseKey)
+	}
+	return fmt.Sprintf("%v: %v", e.SenseKey, e.AdditionalSenseCode)
+}
+
+// parseSenseData parses the sense data returned alongside a CHECK_CONDITION
+// status into an error, either a *FixedError, a *DescriptorError or an
+// *UnknownError.
+func parseSenseData(sense []byte) error {
+	if len(sense) == 0 {
+		return &UnknownError{RawSenseData: sense}
+	}
+	switch sense[0] & 0x7f {
+	case 0x70, 0x71:
+		// Fixed format, Table 48.
+		if len(sense) < 8 {
+			break
+		}
+		err := &FixedError{
+			Deferred:    sense[0]&0x7f == 0x71,
+			SenseKey:    SenseKey(sense[2] & 0b1111),
+			Information: binary.BigEndian.Uint32(sense[3:7]),
+		}
+		length := int(sense[7])
+		if length >= 4 && len(sense) >= 12 {
+			err.CommandSpecificInformation = binary.BigEndian.Uint32(sense[8:12])
+			if length >= 6 && len(sense) >= 14 {
+				err.AdditionalSenseCode = AdditionalSenseCode(uint16(sense[12])<<8 | uint16(sense[13]))
+			}
+		}
+		return err
+	case 0x72, 0x73:
+		// Descriptor format, Table 28.
+		if len(sense) < 8 {
+			break
+		}
+		err := &DescriptorError{
+			Deferred:            sense[0]&0x7f == 0x73,
+			SenseKey:            SenseKey(sense[1] & 0b1111),
+			AdditionalSenseCode: AdditionalSenseCode(uint16(sense[2])<<8 | uint16(sense[3])),
+		}
+		end := 8 + int(sense[7])
+		if end > len(sense) {
+			end = len(sense)
+		}
+		err.Descriptors = sense[8:end]
+		return err
+	}
+	return &UnknownError{RawSenseData: sense}
+}
+
 // UnknownError is a type of error returned by SCSI which is not understood by this
 // library. This can be a vendor-specific or future error.
 type UnknownError struct {
@@ -3,11 +3,11 @@
 package scsi
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"runtime"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -19,12 +19,20 @@ func (d *Device) RawCommand(c *CommandDataBuffer) error {
 	if err != nil {
 		return fmt.Errorf("error encoding CDB: %w", err)
 	}
+	return d.SendCommand(cdb, c.DataTransferDirection, c.Data, c.Timeout)
+}
+
+// SendCommand issues an already encoded CDB against the device via the SG_IO
+// ioctl, transferring data in the given direction. A timeout of zero means no
+// timeout. If the device reports a CHECK_CONDITION, the returned error is a
+// *FixedError, *DescriptorError or *UnknownError containing the sense data.
+func (d *Device) SendCommand(cdb []byte, dir DataTransferDirection, data []byte, timeout time.Duration) error {
 	conn, err := d.fd.SyscallConn()
 	if err != nil {
 		return fmt.Errorf("unable to get RawConn: %w", err)
 	}
 	var dxferDir int32
-	switch c.DataTransferDirection {
+	switch dir {
 	case DataTransferNone:
 		dxferDir = SG_DXFER_NONE
 	case DataTransferFromDevice:
@@ -36,20 +44,29 @@ func (d *Device) RawCommand(c *CommandDataBuffer) error {
 	default:
 		return errors.New("invalid DataTransferDirection")
 	}
-	var timeout uint32
-	if c.Timeout.Milliseconds() > math.MaxUint32 {
-		timeout = math.MaxUint32
+	var timeoutMs uint32
+	if timeout.Milliseconds() > math.MaxUint32 {
+		timeoutMs = math.MaxUint32
+	} else if timeout > 0 {
+		timeoutMs = uint32(timeout.Milliseconds())
 	}
-	if len(c.Data) > math.MaxUint32 {
+	if len(data) > math.MaxUint32 {
 		return errors.New("payload larger than 2^32 bytes, unable to issue")
 	}
+	if len(cdb) == 0 {
+		return errors.New("CDB is empty")
+	}
 	if len(cdb) > math.MaxUint8 {
 		return errors.New("CDB larger than 2^8 bytes, unable to issue")
 	}
 	var senseBuf [32]byte
 
 	var ioctlPins runtime.Pinner
-	ioctlPins.Pin(&c.Data[0])
+	var dataPtr uintptr
+	if len(data) > 0 {
+		ioctlPins.Pin(&data[0])
+		dataPtr = uintptr(unsafe.Pointer(&data[0]))
+	}
 	ioctlPins.Pin(&cdb[0])
 	ioctlPins.Pin(&senseBuf[0])
 	defer ioctlPins.Unpin()
@@ -57,20 +74,20 @@ func (d *Device) RawCommand(c *CommandDataBuffer) error {
 	cmdRaw := sgIOHdr{
 		Interface_id:    'S',
 		Dxfer_direction: dxferDir,
-		Dxfer_len:       uint32(len(c.Data)),
-		Dxferp:          uintptr(unsafe.Pointer(&c.Data[0])),
+		Dxfer_len:       uint32(len(data)),
+		Dxferp:          dataPtr,
 		Cmd_len:         uint8(len(cdb)),
 		Cmdp:            uintptr(unsafe.Pointer(&cdb[0])),
 		Mx_sb_len:       uint8(len(senseBuf)),
 		Sbp:             uintptr(unsafe.Pointer(&senseBuf[0])),
-		Timeout:         timeout,
+		Timeout:         timeoutMs,
 	}
 	var errno unix.Errno
 	err = conn.Control(func(fd uintptr) {
 		_, _, errno = unix.Syscall(unix.SYS_IOCTL, fd, SG_IO, uintptr(unsafe.Pointer(&cmdRaw)))
 	})
 	runtime.KeepAlive(cmdRaw)
-	runtime.KeepAlive(c.Data)
+	runtime.KeepAlive(data)
 	runtime.KeepAlive(senseBuf)
 	runtime.KeepAlive(cdb)
 	if err != nil {
@@ -80,24 +97,14 @@ func (d *Device) RawCommand(c *CommandDataBuffer) error {
 		return errno
 	}
 	if cmdRaw.Masked_status != 0 {
-		if senseBuf[0] == 0x70 || senseBuf[0] == 0x71 {
-			err := &FixedError{
-				Deferred:    senseBuf[0] == 0x71,
-				SenseKey:    SenseKey(senseBuf[2] & 0b1111),
-				Information: binary.BigEndian.Uint32(senseBuf[3:7]),
-			}
-			length := int(senseBuf[7])
-			if length >= 4 {
-				err.CommandSpecificInformation = binary.BigEndian.Uint32(senseBuf[8:12])
-				if length >= 6 {
-					err.AdditionalSenseCode = AdditionalSenseCode(uint16(senseBuf[12])<<8 | uint16(senseBuf[13]))
-				}
-			}
-			return err
-		}
-		return &UnknownError{
-			RawSenseData: senseBuf[:],
+		senseLen := int(cmdRaw.Sb_len_wr)
+		if senseLen == 0 || senseLen > len(senseBuf) {
+			senseLen = len(senseBuf)
 		}
+		return parseSenseData(senseBuf[:senseLen])
+	}
+	if cmdRaw.Host_status != 0 {
+		return fmt.Errorf("command failed with host status %#x", cmdRaw.Host_status)
 	}
 	return nil
 }
@@ -0,0 +1,34 @@
+package scsi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSenseData(t *testing.T) {
+	// Fixed format, ILLEGAL REQUEST, INVALID FIELD IN CDB.
+	fixed := []byte{0xf0, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00}
+	var fe *FixedError
+	if err := parseSenseData(fixed); !errors.As(err, &fe) {
+		t.Errorf("fixed: wanted FixedError, got %v", err)
+	} else if fe.SenseKey != 0x5 || fe.AdditionalSenseCode != 0x2400 || fe.Deferred {
+		t.Errorf("fixed: unexpected error %+v", fe)
+	}
+
+	// Deferred descriptor format, MEDIUM ERROR, UNRECOVERED READ ERROR, with a
+	// single information descriptor.
+	descriptor := []byte{0x73, 0x03, 0x11, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x0a, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x12, 0x34}
+	var de *DescriptorError
+	if err := parseSenseData(descriptor); !errors.As(err, &de) {
+		t.Errorf("descriptor: wanted DescriptorError, got %v", err)
+	} else if de.SenseKey != 0x3 || de.AdditionalSenseCode != 0x1100 || !de.Deferred || len(de.Descriptors) != 12 {
+		t.Errorf("descriptor: unexpected error %+v", de)
+	}
+
+	for _, sense := range [][]byte{nil, {0x70, 0x00}, {0x7f, 0, 0, 0, 0, 0, 0, 0}} {
+		var ue *UnknownError
+		if err := parseSenseData(sense); !errors.As(err, &ue) {
+			t.Errorf("%x: wanted UnknownError, got %v", sense, err)
+		}
+	}
+}
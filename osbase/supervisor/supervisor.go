@@ -25,7 +25,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"runtime/debug"
 	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 
 	"source.monogon.dev/osbase/logtree"
 )
@@ -45,17 +49,74 @@ type Runnable func(ctx context.Context) error
 // canceled and restarted.
 // The context here must be an existing Runnable context, and the spawned
 // runnables will run under the node that this context represents.
-func RunGroup(ctx context.Context, runnables map[string]Runnable) error {
+// The group can be further configured by GroupOptions.
+func RunGroup(ctx context.Context, runnables map[string]Runnable, opts ...GroupOption) error {
 	node, unlock := fromContext(ctx)
 	defer unlock()
-	return node.runGroup(runnables)
+	return node.runGroup(runnables, opts)
 }
 
-// Run starts a single runnable in its own group.
-func Run(ctx context.Context, name string, runnable Runnable) error {
+// Run starts a single runnable in its own group. The group can be further
+// configured by GroupOptions, eg. WithBackoff.
+func Run(ctx context.Context, name string, runnable Runnable, opts ...GroupOption) error {
 	return RunGroup(ctx, map[string]Runnable{
 		name: runnable,
+	}, opts...)
+}
+
+// RunOneShot runs a runnable as a child of the current runnable, blocks until
+// it returns and then returns its error. This is useful for runnables which
+// need to perform some work in their own logtree DN before proceeding.
+//
+// Unlike runnables started by Run, a one-shot runnable is never restarted by
+// the supervisor: if it fails (by returning an error or panicking), the error
+// is returned to the caller instead of being handled as a failure within the
+// supervision tree, ie. neither the caller nor its other children get
+// canceled. Once finished, the runnable is kept in the DONE state and can only
+// be run again after the caller gets restarted.
+//
+// The one-shot runnable may start children of its own and may signal
+// SignalHealthy and SignalDone as usual. If it returns without doing so, the
+// missing signals are sent on its behalf.
+func RunOneShot(ctx context.Context, name string, runnable Runnable) error {
+	res := make(chan error, 1)
+	err := Run(ctx, name, func(ctx context.Context) error {
+		res <- runOneShot(ctx, runnable)
+		// Mark the runnable as done, so that the supervisor neither considers
+		// this a failure nor restarts it.
+		node, unlock := fromContext(ctx)
+		defer unlock()
+		switch node.state {
+		case nodeStateNew:
+			node.signal(SignalHealthy)
+			node.signal(SignalDone)
+		case nodeStateHealthy:
+			node.signal(SignalDone)
+		}
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runOneShot calls a one-shot runnable, converting panics into errors unless
+// the supervisor propagates panics.
+func runOneShot(ctx context.Context, runnable Runnable) (err error) {
+	if sup := ctx.Value(supervisorKey).(*supervisor); !sup.propagatePanic {
+		defer func() {
+			if rec := recover(); rec != nil {
+				err = fmt.Errorf("panic: %v, stacktrace: %s", rec, string(debug.Stack()))
+			}
+		}()
+	}
+	return runnable(ctx)
 }
 
 // Signal tells the supervisor that the calling runnable has reached a certain
@@ -102,6 +163,28 @@ type supervisor struct {
 
 	// propagate panics, ie. don't catch them.
 	propagatePanic bool
+
+	// metricsReg, if set, is the registerer into which metrics are exported.
+	metricsReg prometheus.Registerer
+	// metrics are the exported metrics, or nil if metrics are not exported.
+	metrics *metricsSet
+
+	// onDeath, if set, is called by the processor whenever a runnable dies
+	// with an error, with the runnable's DN, its error and a snapshot of the
+	// supervision tree (as returned by Tree). It is called with the
+	// supervisor lock held and must not block.
+	onDeath func(dn string, err error, tree []RunnableStatus)
+
+	// shutdownTimeout, if non-zero, is the time runnables are given to exit
+	// once the supervisor's context has been canceled, see
+	// WithShutdownTimeout.
+	shutdownTimeout time.Duration
+	// shutdown is closed once the supervisor has shut down, ie. all runnables
+	// have exited or the shutdown timeout has elapsed.
+	shutdown chan struct{}
+	// stuck are the DNs of runnables which did not exit within the shutdown
+	// timeout. It is guarded by mu and only set once shutdown is closed.
+	stuck []string
 }
 
 // SupervisorOpt are runtime configurable options for the supervisor.
@@ -114,6 +197,25 @@ func WithPropagatePanic(s *supervisor) {
 	s.propagatePanic = true
 }
 
+// withOnDeath sets a function to be called whenever a runnable dies with an
+// error. This is used by TestHarnessStrict.
+func withOnDeath(f func(dn string, err error, tree []RunnableStatus)) SupervisorOpt {
+	return func(s *supervisor) {
+		s.onDeath = f
+	}
+}
+
+// WithShutdownTimeout limits the time the supervisor waits for runnables to
+// exit once its context has been canceled. Runnables which are still running
+// after the given duration are logged as stuck, and the supervisor is
+// considered shut down regardless (see Wait). By default, the supervisor waits
+// indefinitely.
+func WithShutdownTimeout(d time.Duration) SupervisorOpt {
+	return func(s *supervisor) {
+		s.shutdownTimeout = d
+	}
+}
+
 func WithExistingLogtree(lt *logtree.LogTree) SupervisorOpt {
 	return func(s *supervisor) {
 		s.logtree = lt
@@ -128,8 +230,9 @@ func WithExistingLogtree(lt *logtree.LogTree) SupervisorOpt {
 // output.
 func New(ctx context.Context, rootRunnable Runnable, opts ...SupervisorOpt) *supervisor {
 	sup := &supervisor{
-		logtree: logtree.New(),
-		pReq:    make(chan *processorRequest),
+		logtree:  logtree.New(),
+		pReq:     make(chan *processorRequest),
+		shutdown: make(chan struct{}),
 	}
 
 	for _, o := range opts {
@@ -137,6 +240,10 @@ func New(ctx context.Context, rootRunnable Runnable, opts ...SupervisorOpt) *sup
 	}
 
 	sup.ilogger = sup.logtree.MustLeveledFor("supervisor")
+	if sup.metricsReg != nil {
+		sup.metrics = newMetricsSet(sup)
+		sup.metrics.register(sup.metricsReg)
+	}
 	sup.root = newNode("root", rootRunnable, sup, nil)
 
 	go sup.processor(ctx)
@@ -148,6 +255,17 @@ func New(ctx context.Context, rootRunnable Runnable, opts ...SupervisorOpt) *sup
 	return sup
 }
 
+// Wait blocks until the supervisor has shut down after its context has been
+// canceled, ie. until all runnables have exited or the shutdown timeout set by
+// WithShutdownTimeout has elapsed. The DNs of runnables which failed to exit
+// within the timeout are returned, sorted.
+func (s *supervisor) Wait() []string {
+	<-s.shutdown
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stuck
+}
+
 func Logger(ctx context.Context) logtree.LeveledLogger {
 	node, unlock := fromContext(ctx)
 	defer unlock()
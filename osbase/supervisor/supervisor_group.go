@@ -0,0 +1,336 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package supervisor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// GroupOption configures a group of runnables started by RunGroup.
+type GroupOption func(g *group)
+
+// WithMaxConcurrentStarts limits the number of runnables within a group that
+// are starting at the same time. A runnable is considered to be starting from
+// the moment it is scheduled until it signals SignalHealthy or SignalDone, or
+// exits. Runnables that cannot be started immediately are queued and started in
+// order of their priority (see WithStartPriorities).
+//
+// This is useful for large groups which would otherwise overwhelm the node when
+// all of their runnables get (re)started at the same time, eg. after a shared
+// dependency has recovered. As runnables hold on to their start slot until they
+// signal healthy, all runnables in such a group must signal SignalHealthy once
+// they are up, otherwise other queued runnables might never be started.
+//
+// A limit of zero (the default) disables the limit.
+func WithMaxConcurrentStarts(n int) GroupOption {
+	return func(g *group) {
+		g.maxStarting = n
+	}
+}
+
+// WithStartPriorities sets the start priority of runnables within a group, keyed
+// by runnable name. Runnables with a higher priority are started before those
+// with a lower priority when a limit on concurrent starts is configured (see
+// WithMaxConcurrentStarts). Runnables not present in the map have priority
+// zero.
+func WithStartPriorities(priorities map[string]int) GroupOption {
+	return func(g *group) {
+		g.priorities = priorities
+	}
+}
+
+// WithDependencies declares startup dependencies between runnables of a group,
+// keyed by runnable name. A runnable is only started once all of its
+// dependencies have signaled SignalHealthy (or SignalDone). Until then, it is
+// kept in the NEW state without its Runnable function being called.
+//
+// Dependencies only order startup: the group is still canceled and restarted as
+// a whole if any of its runnables dies, after which dependent runnables again
+// wait for their dependencies to become healthy.
+//
+// Dependencies must name other runnables of the same group. RunGroup refuses
+// dependency graphs containing a cycle (including a runnable depending on
+// itself), as runnables in such a cycle could never be started.
+func WithDependencies(deps map[string][]string) GroupOption {
+	return func(g *group) {
+		g.deps = deps
+	}
+}
+
+// WithBackoff overrides the exponential backoff used when restarting
+// runnables of a group after they died. The first restart is delayed by
+// roughly initial, every following restart by multiplier times the previous
+// delay, up to max. Like with the default policy, the backoff of a runnable is
+// reset once it signals SignalHealthy or SignalDone.
+//
+// This is useful for runnables which are expected to fail for a while, eg.
+// because they depend on the network, and should thus back off more
+// aggressively, or for runnables which should retry quickly.
+func WithBackoff(initial, max time.Duration, multiplier float64) GroupOption {
+	return func(g *group) {
+		g.bo = &groupBackoff{
+			initial:    initial,
+			max:        max,
+			multiplier: multiplier,
+		}
+	}
+}
+
+// groupBackoff is a restart backoff policy configured by WithBackoff.
+type groupBackoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+}
+
+// group is a supervision group, a set of children of a node which are started
+// and restarted together. See node.groups for more information.
+type group struct {
+	// members are the names of the children within this group.
+	members map[string]bool
+
+	// maxStarting is the maximum number of members which may be starting at the
+	// same time, or zero if unlimited.
+	maxStarting int
+	// priorities are the start priorities of members, keyed by name.
+	priorities map[string]int
+	// deps are the names of members which need to be healthy before a member
+	// gets started, keyed by name.
+	deps map[string][]string
+	// bo is the restart backoff policy of members, or nil if the default
+	// policy should be used.
+	bo *groupBackoff
+
+	// starting is the number of members currently starting, ie. members which
+	// have node.starting set.
+	starting int
+	// pending are members which have been scheduled but not yet started because
+	// of maxStarting, in order of scheduling.
+	pending []*node
+	// waiting are members which have been scheduled but not yet started because
+	// their dependencies are not healthy yet, in order of scheduling.
+	waiting []*node
+}
+
+func newGroup(names []string, opts []GroupOption) (*group, error) {
+	g := &group{
+		members: make(map[string]bool),
+	}
+	for _, name := range names {
+		g.members[name] = true
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.maxStarting < 0 {
+		return nil, fmt.Errorf("maximum concurrent starts must not be negative")
+	}
+	for name := range g.priorities {
+		if !g.members[name] {
+			return nil, fmt.Errorf("priority given for unknown runnable %q", name)
+		}
+	}
+	for name, deps := range g.deps {
+		if !g.members[name] {
+			return nil, fmt.Errorf("dependencies given for unknown runnable %q", name)
+		}
+		for _, dep := range deps {
+			if !g.members[dep] {
+				return nil, fmt.Errorf("runnable %q depends on unknown runnable %q", name, dep)
+			}
+		}
+	}
+	if err := g.checkCycles(); err != nil {
+		return nil, err
+	}
+	if bo := g.bo; bo != nil {
+		if bo.initial <= 0 {
+			return nil, fmt.Errorf("initial backoff must be positive")
+		}
+		if bo.max < bo.initial {
+			return nil, fmt.Errorf("maximum backoff must not be smaller than initial backoff")
+		}
+		if bo.multiplier < 1 {
+			return nil, fmt.Errorf("backoff multiplier must be at least 1")
+		}
+	}
+	return g, nil
+}
+
+// checkCycles returns an error if the dependencies of the group contain a
+// cycle.
+func (g *group) checkCycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range g.deps[name] {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+
+	names := make([]string, 0, len(g.deps))
+	for name := range g.deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// depsReady returns whether all dependencies of a member node are healthy or
+// done.
+func (g *group) depsReady(n *node) bool {
+	for _, dep := range g.deps[n.name] {
+		switch n.parent.children[dep].state {
+		case nodeStateHealthy, nodeStateDone:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// wake launches waiting nodes whose dependencies are now ready.
+func (g *group) wake() {
+	var waiting []*node
+	var ready []*node
+	for _, n := range g.waiting {
+		if g.depsReady(n) {
+			ready = append(ready, n)
+		} else {
+			waiting = append(waiting, n)
+		}
+	}
+	g.waiting = waiting
+	for _, n := range ready {
+		n.sup.launch(n)
+	}
+}
+
+// configureBackoff applies the group's restart backoff policy, if any, to the
+// backoff of a member node.
+func (g *group) configureBackoff(bo *backoff.ExponentialBackOff) {
+	if g.bo == nil {
+		return
+	}
+	bo.InitialInterval = g.bo.initial
+	bo.MaxInterval = g.bo.max
+	bo.Multiplier = g.bo.multiplier
+	bo.Reset()
+}
+
+// limited returns whether the group has a limit on concurrent starts.
+func (g *group) limited() bool {
+	return g.maxStarting > 0
+}
+
+// byPriority sorts the given names in order of descending start priority,
+// keeping the original order for names of equal priority.
+func (g *group) byPriority(names []string) {
+	sort.SliceStable(names, func(i, j int) bool {
+		return g.priorities[names[i]] > g.priorities[names[j]]
+	})
+}
+
+// enqueue adds a node to the pending queue of this group. The node will be
+// started by the next call to admit which finds a free start slot.
+func (g *group) enqueue(n *node) {
+	g.pending = append(g.pending, n)
+}
+
+// admit starts pending nodes in order of priority as long as there are free
+// start slots available.
+func (g *group) admit() {
+	for len(g.pending) > 0 && g.starting < g.maxStarting {
+		best := 0
+		for i, n := range g.pending {
+			if g.priorities[n.name] > g.priorities[g.pending[best].name] {
+				best = i
+			}
+		}
+		n := g.pending[best]
+		g.pending = append(g.pending[:best], g.pending[best+1:]...)
+		g.starting += 1
+		n.starting = true
+		n.sup.start(n)
+	}
+}
+
+// release frees the start slot held by a node, if any, and starts pending
+// nodes which can now be started.
+func (g *group) release(n *node) {
+	if !n.starting {
+		return
+	}
+	n.starting = false
+	g.starting -= 1
+	g.admit()
+}
+
+// prune removes pending and waiting nodes whose context has been canceled
+// before they could be started and marks them as canceled, so that the GC can
+// restart their parent.
+func (g *group) prune() {
+	g.pending = pruneCanceled(g.pending)
+	g.waiting = pruneCanceled(g.waiting)
+}
+
+func pruneCanceled(nodes []*node) []*node {
+	var res []*node
+	for _, n := range nodes {
+		if n.ctx.Err() != nil {
+			n.state = nodeStateCanceled
+			continue
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+// priority returns the start priority of this node within its group.
+func (n *node) priority() int {
+	if g := n.group(); g != nil {
+		return g.priorities[n.name]
+	}
+	return 0
+}
@@ -0,0 +1,225 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithMetrics makes the supervisor export Prometheus metrics about its
+// runnables into the given registerer:
+//
+//   - supervisor_runnable_restarts_total, a counter of restarts per DN,
+//   - supervisor_runnable_panics_total, a counter of panics per DN (only
+//     counted if panics are not propagated, see WithPropagatePanic),
+//   - supervisor_runnable_state, a gauge which is 1 for the current state of
+//     every runnable, labeled by DN and state (as in RunnableStatus).
+func WithMetrics(reg prometheus.Registerer) SupervisorOpt {
+	return func(s *supervisor) {
+		s.metricsReg = reg
+	}
+}
+
+// metricsSet contains all the Prometheus metrics exported by the supervisor.
+type metricsSet struct {
+	restarts *prometheus.CounterVec
+	panics   *prometheus.CounterVec
+	state    *stateCollector
+}
+
+func newMetricsSet(s *supervisor) *metricsSet {
+	return &metricsSet{
+		restarts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "supervisor_runnable_restarts_total",
+				Help: "Number of times a runnable has been restarted by the supervisor",
+			},
+			[]string{"dn"},
+		),
+		panics: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "supervisor_runnable_panics_total",
+				Help: "Number of times a runnable has panicked",
+			},
+			[]string{"dn"},
+		),
+		state: &stateCollector{
+			sup: s,
+			desc: prometheus.NewDesc(
+				"supervisor_runnable_state",
+				"Current state of a runnable, 1 for the state it is in",
+				[]string{"dn", "state"}, nil,
+			),
+		},
+	}
+}
+
+func (m *metricsSet) register(reg prometheus.Registerer) {
+	reg.MustRegister(m.restarts, m.panics, m.state)
+}
+
+// onRestart is called by the processor whenever a runnable gets rescheduled.
+func (m *metricsSet) onRestart(dn string) {
+	if m == nil {
+		return
+	}
+	m.restarts.WithLabelValues(dn).Inc()
+}
+
+// onPanic is called whenever a runnable panics.
+func (m *metricsSet) onPanic(dn string) {
+	if m == nil {
+		return
+	}
+	m.panics.WithLabelValues(dn).Inc()
+}
+
+// stateCollector exports the current state of all runnables, as retrieved from
+// Tree at collection time.
+type stateCollector struct {
+	sup  *supervisor
+	desc *prometheus.Desc
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, st := range c.sup.Tree() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, st.DN, st.State)
+	}
+}
+
+// MetricsLabeler creates Prometheus metrics on behalf of a runnable. All
+// metrics it creates carry a constant "dn" label set to the DN of the runnable
+// (or sub-labeler), so that the same metric can be used by multiple runnables
+// without clashing. Metrics are exported into the registerer passed to
+// WithMetrics, or not exported at all if the supervisor was started without
+// it.
+type MetricsLabeler struct {
+	dn  string
+	reg prometheus.Registerer
+}
+
+// Metrics returns a MetricsLabeler for the runnable in ctx, ie. one which
+// labels metrics with the DN of the runnable, eg. `root.foo`.
+func Metrics(ctx context.Context) *MetricsLabeler {
+	node, unlock := fromContext(ctx)
+	defer unlock()
+	return newMetricsLabeler(node.sup, node.dn())
+}
+
+// SubMetrics returns a MetricsLabeler for a given name, analogous to SubLogger.
+// For example, if the runnable `root.foo` requests SubMetrics for name `bar`,
+// the returned labeler will label metrics with `root.foo.bar`.
+//
+// An error is returned if the given name is invalid or conflicts with a child
+// runnable of the current runnable. In addition, the name becomes unavailable
+// for use as a child runnable, just like names used by sub-loggers.
+func SubMetrics(ctx context.Context, name string) (*MetricsLabeler, error) {
+	node, unlock := fromContext(ctx)
+	defer unlock()
+
+	if _, ok := node.children[name]; ok {
+		return nil, fmt.Errorf("name %q already in use by child runnable", name)
+	}
+	if !reNodeName.MatchString(name) {
+		return nil, fmt.Errorf("sub-metrics name %q is invalid", name)
+	}
+	node.reserved[name] = true
+
+	return newMetricsLabeler(node.sup, fmt.Sprintf("%s.%s", node.dn(), name)), nil
+}
+
+// MustSubMetrics is a wrapper around SubMetrics which panics on error, see
+// MustSubLogger.
+func MustSubMetrics(ctx context.Context, name string) *MetricsLabeler {
+	m, err := SubMetrics(ctx, name)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func newMetricsLabeler(s *supervisor, dn string) *MetricsLabeler {
+	m := &MetricsLabeler{
+		dn: dn,
+	}
+	if s.metricsReg != nil {
+		m.reg = prometheus.WrapRegistererWith(prometheus.Labels{"dn": dn}, s.metricsReg)
+	}
+	return m
+}
+
+// DN returns the DN with which this labeler labels metrics.
+func (m *MetricsLabeler) DN() string {
+	return m.dn
+}
+
+// Register registers the given collector, adding the "dn" label to all its
+// metrics. As runnables get restarted, the same metric might be registered
+// multiple times for a DN. In this case, the previously registered collector
+// is returned instead and should be used by the caller.
+func (m *MetricsLabeler) Register(c prometheus.Collector) (prometheus.Collector, error) {
+	if m.reg == nil {
+		return c, nil
+	}
+	if err := m.reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// Counter returns a counter with the given options, labeled with the DN of
+// this labeler. It panics if the counter cannot be registered, eg. because a
+// metric with the same name but different labels or help already exists.
+func (m *MetricsLabeler) Counter(opts prometheus.CounterOpts) prometheus.Counter {
+	c, err := m.Register(prometheus.NewCounter(opts))
+	if err != nil {
+		panic(fmt.Sprintf("registering counter %q for %s: %v", opts.Name, m.dn, err))
+	}
+	return c.(prometheus.Counter)
+}
+
+// Gauge returns a gauge with the given options, labeled with the DN of this
+// labeler. It panics under the same conditions as Counter.
+func (m *MetricsLabeler) Gauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	g, err := m.Register(prometheus.NewGauge(opts))
+	if err != nil {
+		panic(fmt.Sprintf("registering gauge %q for %s: %v", opts.Name, m.dn, err))
+	}
+	return g.(prometheus.Gauge)
+}
+
+// Histogram returns a histogram with the given options, labeled with the DN of
+// this labeler. It panics under the same conditions as Counter.
+func (m *MetricsLabeler) Histogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	h, err := m.Register(prometheus.NewHistogram(opts))
+	if err != nil {
+		panic(fmt.Sprintf("registering histogram %q for %s: %v", opts.Name, m.dn, err))
+	}
+	return h.(prometheus.Histogram)
+}
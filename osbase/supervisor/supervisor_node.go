@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/cenkalti/backoff/v4"
@@ -51,10 +52,22 @@ type node struct {
 	// as such groups, don't overlap between each other. A supervision group
 	// indicates that if any child within that group fails, all others should
 	// be canceled and restarted together.
-	groups []map[string]bool
+	groups []*group
 
 	// The current state of the runnable in this node.
 	state nodeState
+	// starting is set when this node holds a start slot of its group, ie. when
+	// its group has a limit on concurrent starts and its runnable has been
+	// started, but has not yet signaled healthy/done or exited.
+	starting bool
+
+	// restarts is the number of times this node's runnable has been restarted
+	// by the supervisor. As nodes are recreated whenever their parent gets
+	// restarted, this only counts restarts since then.
+	restarts int
+	// lastErr is the error with which this node's runnable last died, or nil
+	// if it has not died yet.
+	lastErr error
 
 	// Backoff used to keep runnables from being restarted too fast.
 	bo *backoff.ExponentialBackOff
@@ -150,14 +163,32 @@ func (n *node) dn() string {
 // given runnable name within this node.  All children are always in a group,
 // even if that group is unary.
 func (n *node) groupSiblings(name string) map[string]bool {
-	for _, m := range n.groups {
-		if _, ok := m[name]; ok {
-			return m
+	if g := n.groupOf(name); g != nil {
+		return g.members
+	}
+	return nil
+}
+
+// groupOf returns the group of the child with the given name within this node,
+// or nil if there is no such child.
+func (n *node) groupOf(name string) *group {
+	for _, g := range n.groups {
+		if g.members[name] {
+			return g
 		}
 	}
 	return nil
 }
 
+// group returns the group this node is part of within its parent, or nil if
+// this is the root node.
+func (n *node) group() *group {
+	if n.parent == nil {
+		return nil
+	}
+	return n.parent.groupOf(n.name)
+}
+
 // newNode creates a new node with a given parent. It does not register it with
 // the parent (as that depends on group placement).
 func newNode(name string, runnable Runnable, sup *supervisor, parent *node) *node {
@@ -202,6 +233,9 @@ func (n *node) reset() {
 
 	// Clear children and state
 	n.state = nodeStateNew
+	if g := n.group(); g != nil {
+		g.release(n)
+	}
 	n.children = make(map[string]*node)
 	n.reserved = make(map[string]bool)
 	n.groups = nil
@@ -235,7 +269,7 @@ func (s *supervisor) nodeByDN(dn string) *node {
 var reNodeName = regexp.MustCompile(`[a-z90-9_]{1,64}`)
 
 // runGroup schedules a new group of runnables to run on a node.
-func (n *node) runGroup(runnables map[string]Runnable) error {
+func (n *node) runGroup(runnables map[string]Runnable, opts []GroupOption) error {
 	// Check that the parent node is in the right state.
 	if n.state != nodeStateNew {
 		return fmt.Errorf("cannot run new runnable on non-NEW node")
@@ -254,28 +288,39 @@ func (n *node) runGroup(runnables map[string]Runnable) error {
 		}
 	}
 
-	// Create child nodes.
-	dns := make(map[string]string)
-	group := make(map[string]bool)
-	for name, runnable := range runnables {
+	names := make([]string, 0, len(runnables))
+	for name := range runnables {
 		if g := n.groupSiblings(name); g != nil {
 			return fmt.Errorf("duplicate child name %q", name)
 		}
-		node := newNode(name, runnable, n.sup, n)
-		n.children[name] = node
+		names = append(names, name)
+	}
+	group, err := newGroup(names, opts)
+	if err != nil {
+		return err
+	}
+	// Schedule members in order of priority, so that the highest priority
+	// members are the first to get a start slot.
+	sort.Strings(names)
+	group.byPriority(names)
 
-		dns[name] = node.dn()
-		group[name] = true
+	// Create child nodes.
+	dns := make([]string, 0, len(names))
+	for _, name := range names {
+		node := newNode(name, runnables[name], n.sup, n)
+		group.configureBackoff(node.bo)
+		n.children[name] = node
+		dns = append(dns, node.dn())
 	}
 	// Add group.
 	n.groups = append(n.groups, group)
 
 	// Schedule execution of group members.
 	go func() {
-		for name := range runnables {
+		for _, dn := range dns {
 			n.sup.pReq <- &processorRequest{
 				schedule: &processorRequestSchedule{
-					dn: dns[name],
+					dn: dn,
 				},
 			}
 		}
@@ -300,4 +345,8 @@ func (n *node) signal(signal SignalType) {
 		n.state = nodeStateDone
 		n.bo.Reset()
 	}
+	if g := n.group(); g != nil {
+		g.release(n)
+		g.wake()
+	}
 }
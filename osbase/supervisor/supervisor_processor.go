@@ -126,22 +126,59 @@ func (s *supervisor) processor(ctx context.Context) {
 // It exits when all runnables have exited one way or another, and the
 // supervision tree is well and truly dead. This will also be reflected by
 // liveRunnables returning an empty list.
+//
+// If a shutdown timeout is configured and runnables are still live once it
+// elapses, they are logged as stuck and the supervisor is considered shut down
+// regardless. The liquidator keeps running until they exit, if ever.
 func (s *supervisor) liquidator() {
+	var timeout <-chan time.Time
+	if s.shutdownTimeout > 0 {
+		t := time.NewTimer(s.shutdownTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var shutdown bool
+	markShutdown := func(stuck []string) {
+		if shutdown {
+			return
+		}
+		shutdown = true
+		s.mu.Lock()
+		s.stuck = stuck
+		s.mu.Unlock()
+		close(s.shutdown)
+	}
+	defer markShutdown(nil)
+
+	if len(s.liveRunnables()) == 0 {
+		s.ilogger.Infof("liquidator: complete, all runnables dead or done")
+		return
+	}
 	for {
-		r := <-s.pReq
-		switch {
-		case r.schedule != nil:
-			s.ilogger.Infof("liquidator: refusing to schedule %s", r.schedule.dn)
-			s.mu.Lock()
-			n := s.nodeByDN(r.schedule.dn)
-			n.state = nodeStateDead
-			s.mu.Unlock()
-		case r.died != nil:
-			s.ilogger.Infof("liquidator: %s exited", r.died.dn)
-			s.mu.Lock()
-			n := s.nodeByDN(r.died.dn)
-			n.state = nodeStateDead
-			s.mu.Unlock()
+		select {
+		case r := <-s.pReq:
+			switch {
+			case r.schedule != nil:
+				s.ilogger.Infof("liquidator: refusing to schedule %s", r.schedule.dn)
+				s.mu.Lock()
+				n := s.nodeByDN(r.schedule.dn)
+				n.state = nodeStateDead
+				s.mu.Unlock()
+			case r.died != nil:
+				s.ilogger.Infof("liquidator: %s exited", r.died.dn)
+				s.mu.Lock()
+				n := s.nodeByDN(r.died.dn)
+				n.state = nodeStateDead
+				s.mu.Unlock()
+			}
+		case <-timeout:
+			timeout = nil
+			live := s.liveRunnables()
+			s.ilogger.Warningf("liquidator: shutdown timeout of %s exceeded, %d runnables failed to exit:", s.shutdownTimeout, len(live))
+			for _, dn := range live {
+				s.ilogger.Warningf("liquidator: - %s", dn)
+			}
+			markShutdown(live)
 		}
 		live := s.liveRunnables()
 		if len(live) == 0 {
@@ -212,6 +249,17 @@ func (s *supervisor) processKill() {
 		queue = queue[1:]
 
 		cancels = append(cancels, cur.ctxC)
+		// Runnables waiting for a start slot or dependencies will never run.
+		for _, g := range cur.groups {
+			for _, n := range g.pending {
+				n.state = nodeStateDead
+			}
+			for _, n := range g.waiting {
+				n.state = nodeStateDead
+			}
+			g.pending = nil
+			g.waiting = nil
+		}
 		for _, c := range cur.children {
 			queue = append(queue, c)
 		}
@@ -229,14 +277,44 @@ func (s *supervisor) processSchedule(r *processorRequestSchedule) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	n := s.nodeByDN(r.dn)
+	s.launch(s.nodeByDN(r.dn))
+}
+
+// launch starts a node's runnable, unless it has to wait. If the node's
+// dependencies within its group are not healthy yet, it is kept waiting until
+// they are. If the node's group limits concurrent starts, the node is queued
+// to be started once a start slot is available. The supervisor lock must be
+// held.
+func (s *supervisor) launch(n *node) {
+	g := n.group()
+	if g == nil {
+		s.start(n)
+		return
+	}
+	if !g.depsReady(n) {
+		g.waiting = append(g.waiting, n)
+		return
+	}
+	if g.limited() {
+		g.enqueue(n)
+		g.admit()
+		return
+	}
+	s.start(n)
+}
+
+// start runs a node's runnable in a goroutine and sends its result to the
+// processor once it's done. The supervisor lock must be held.
+func (s *supervisor) start(n *node) {
+	dn := n.dn()
 	go func() {
 		if !s.propagatePanic {
 			defer func() {
 				if rec := recover(); rec != nil {
+					s.metrics.onPanic(dn)
 					s.pReq <- &processorRequest{
 						died: &processorRequestDied{
-							dn:  r.dn,
+							dn:  dn,
 							err: fmt.Errorf("panic: %v, stacktrace: %s", rec, string(debug.Stack())),
 						},
 					}
@@ -248,7 +326,7 @@ func (s *supervisor) processSchedule(r *processorRequestSchedule) {
 
 		s.pReq <- &processorRequest{
 			died: &processorRequestDied{
-				dn:  r.dn,
+				dn:  dn,
 				err: res,
 			},
 		}
@@ -266,6 +344,11 @@ func (s *supervisor) processDied(r *processorRequestDied) {
 	n := s.nodeByDN(r.dn)
 	ctx := n.ctx
 
+	// Whatever happened, it's not starting anymore.
+	if g := n.group(); g != nil {
+		g.release(n)
+	}
+
 	// Simple case: it was marked as Done and quit with no error.
 	if n.state == nodeStateDone && r.err == nil {
 		// Do nothing. This was supposed to happen. Keep the process as DONE.
@@ -291,6 +374,10 @@ func (s *supervisor) processDied(r *processorRequestDied) {
 	s.ilogger.Errorf("%s: %v", n.dn(), err)
 	// Mark as dead.
 	n.state = nodeStateDead
+	n.lastErr = err
+	if s.onDeath != nil {
+		s.onDeath(n.dn(), err, s.tree())
+	}
 
 	// Cancel that node's context, just in case something still depends on it.
 	n.ctxC()
@@ -328,7 +415,9 @@ func (s *supervisor) processGC() {
 
 	// Phase one: Find all leaves.
 	// This is a simple DFS that finds all the leaves of the tree, ie all nodes
-	// that do not have children nodes.
+	// that do not have children nodes. Runnables that were waiting for a start
+	// slot but got canceled in the meantime are marked as CANCELED here, as
+	// they will never be started and thus never report back.
 	leaves := make(map[string]bool)
 	queue := []*node{s.root}
 	for {
@@ -338,6 +427,9 @@ func (s *supervisor) processGC() {
 		cur := queue[0]
 		queue = queue[1:]
 
+		for _, g := range cur.groups {
+			g.prune()
+		}
 		for _, c := range cur.children {
 			queue = append([]*node{c}, queue...)
 		}
@@ -481,8 +573,16 @@ func (s *supervisor) processGC() {
 		}
 	}
 
-	// Reinitialize and reschedule all subtrees
+	// Reinitialize and reschedule all subtrees, in order of their start
+	// priority.
+	canDNs := make([]string, 0, len(can))
 	for dn := range can {
+		canDNs = append(canDNs, dn)
+	}
+	sort.SliceStable(canDNs, func(i, j int) bool {
+		return s.nodeByDN(canDNs[i]).priority() > s.nodeByDN(canDNs[j]).priority()
+	})
+	for _, dn := range canDNs {
 		n := s.nodeByDN(dn)
 
 		// Only back off when the node unexpectedly died - not when it got
@@ -495,8 +595,18 @@ func (s *supervisor) processGC() {
 		// Prepare node for rescheduling - remove its children, reset its state
 		// to new.
 		n.reset()
+		n.restarts++
+		s.metrics.onRestart(dn)
 		s.ilogger.Infof("rescheduling supervised node %s with backoff %s", dn, bo.String())
 
+		// Without backoff, queue nodes in start-limited groups immediately, so
+		// that they get started in order of priority instead of in the order
+		// in which their scheduling requests happen to arrive.
+		if g := n.group(); bo == 0 && g != nil && g.limited() {
+			s.launch(n)
+			continue
+		}
+
 		// Reschedule node runnable to run after backoff.
 		go func(n *node, bo time.Duration) {
 			time.Sleep(bo)
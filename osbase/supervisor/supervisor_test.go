@@ -19,9 +19,14 @@ package supervisor
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"source.monogon.dev/osbase/logtree"
 )
 
@@ -426,6 +431,245 @@ func TestBackoff(t *testing.T) {
 	}
 }
 
+// TestBackoffOverride ensures that a backoff policy configured via WithBackoff
+// is used instead of the default one, and that it also gets reset once the
+// runnable signals healthy.
+func TestBackoffOverride(t *testing.T) {
+	fast := newRC()
+	slow := newRC()
+
+	ctx, ctxC := context.WithTimeout(context.Background(), 20*time.Second)
+	defer ctxC()
+
+	s := New(ctx, func(ctx context.Context) error {
+		if err := Run(ctx, "fast", fast.runnable(), WithBackoff(10*time.Millisecond, 20*time.Millisecond, 2)); err != nil {
+			return err
+		}
+		if err := Run(ctx, "slow", slow.runnable(), WithBackoff(time.Second, 10*time.Second, 4)); err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		Signal(ctx, SignalDone)
+		return nil
+	}, WithPropagatePanic)
+
+	// With the default policy, this many deaths in a row bring the backoff to
+	// over a second (see TestBackoff). The fast policy should still restart
+	// the runnable quickly.
+	fast.becomeHealthy()
+	for i := 0; i < 4; i += 1 {
+		fast.die()
+		fast.waitState(rcRunnableStateNew)
+	}
+	start := time.Now()
+	fast.die()
+	fast.becomeHealthy()
+	fast.waitState(rcRunnableStateHealthy)
+	if taken := time.Since(start); taken > 500*time.Millisecond {
+		t.Errorf("Fast runnable took %v to restart, wanted at most 500ms", taken)
+	}
+
+	// The slow policy backs off for at least half of its initial interval
+	// (accounting for randomization) on the first death, and grows quickly
+	// from there.
+	slow.becomeHealthy()
+	start = time.Now()
+	slow.die()
+	slow.becomeHealthy()
+	slow.waitState(rcRunnableStateHealthy)
+	if taken := time.Since(start); taken < 500*time.Millisecond {
+		t.Errorf("Slow runnable took %v to restart, wanted at least 500ms", taken)
+	}
+
+	// Becoming healthy resets the backoff, so the next restart again takes
+	// about the initial interval instead of growing.
+	s.waitSettleError(ctx, t)
+	start = time.Now()
+	slow.die()
+	slow.becomeHealthy()
+	slow.waitState(rcRunnableStateHealthy)
+	if taken := time.Since(start); taken < 500*time.Millisecond || taken > 2*time.Second {
+		t.Errorf("Slow runnable took %v to restart, wanted between 500ms and 2s after backoff reset", taken)
+	}
+}
+
+// TestBackoffInvalid ensures invalid backoff policies are rejected.
+func TestBackoffInvalid(t *testing.T) {
+	for i, opt := range []GroupOption{
+		WithBackoff(0, time.Second, 2),
+		WithBackoff(time.Second, time.Millisecond, 2),
+		WithBackoff(time.Second, time.Minute, 0.5),
+	} {
+		if _, err := newGroup([]string{"one"}, []GroupOption{opt}); err == nil {
+			t.Errorf("%d: newGroup succeeded, wanted error", i)
+		}
+	}
+}
+
+// TestTree exercises the Tree snapshot of the supervision tree, ensuring it
+// reflects runnable states, restarts and errors.
+func TestTree(t *testing.T) {
+	one := newRC()
+
+	ctx, ctxC := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ctxC()
+
+	s := New(ctx, func(ctx context.Context) error {
+		if err := Run(ctx, "one", one.runnable()); err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		Signal(ctx, SignalDone)
+		return nil
+	}, WithPropagatePanic)
+
+	one.becomeHealthy()
+	s.waitSettleError(ctx, t)
+	want := []RunnableStatus{
+		{DN: "root", State: "done"},
+		{DN: "root.one", State: "healthy"},
+	}
+	if got := s.Tree(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tree after start: wanted %+v, got %+v", want, got)
+	}
+
+	one.die()
+	one.waitState(rcRunnableStateNew)
+	s.waitSettleError(ctx, t)
+	want = []RunnableStatus{
+		{DN: "root", State: "done"},
+		{DN: "root.one", State: "new", Restarts: 1, LastError: "died on request"},
+	}
+	if got := s.Tree(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tree after death: wanted %+v, got %+v", want, got)
+	}
+}
+
+// TestMetrics ensures that restarts, panics and states of runnables are
+// exported as metrics.
+func TestMetrics(t *testing.T) {
+	one := newRC()
+	reg := prometheus.NewRegistry()
+
+	ctx, ctxC := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ctxC()
+
+	s := New(ctx, func(ctx context.Context) error {
+		if err := Run(ctx, "one", one.runnable()); err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		Signal(ctx, SignalDone)
+		return nil
+	}, WithMetrics(reg))
+
+	// gather returns all metric values, keyed by metric name and labels.
+	gather := func() map[string]float64 {
+		t.Helper()
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("Gather: %v", err)
+		}
+		res := make(map[string]float64)
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				var lvs []string
+				for _, lp := range m.Label {
+					lvs = append(lvs, fmt.Sprintf("%s=%s", lp.GetName(), lp.GetValue()))
+				}
+				name := fmt.Sprintf("%s[%s]", mf.GetName(), strings.Join(lvs, ","))
+				switch {
+				case m.Counter != nil:
+					res[name] = m.Counter.GetValue()
+				case m.Gauge != nil:
+					res[name] = m.Gauge.GetValue()
+				}
+			}
+		}
+		return res
+	}
+
+	one.becomeHealthy()
+	s.waitSettleError(ctx, t)
+	want := map[string]float64{
+		"supervisor_runnable_state[dn=root,state=done]":        1,
+		"supervisor_runnable_state[dn=root.one,state=healthy]": 1,
+	}
+	if got := gather(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Metrics after start: wanted %v, got %v", want, got)
+	}
+
+	one.die()
+	one.waitState(rcRunnableStateNew)
+	one.panic()
+	one.waitState(rcRunnableStateNew)
+	s.waitSettleError(ctx, t)
+	want = map[string]float64{
+		"supervisor_runnable_restarts_total[dn=root.one]":  2,
+		"supervisor_runnable_panics_total[dn=root.one]":    1,
+		"supervisor_runnable_state[dn=root,state=done]":    1,
+		"supervisor_runnable_state[dn=root.one,state=new]": 1,
+	}
+	if got := gather(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Metrics after restarts: wanted %v, got %v", want, got)
+	}
+}
+
+// TestRunOneShot exercises one-shot runnables, ensuring their result is
+// returned to the caller and that they do not get restarted on failure.
+func TestRunOneShot(t *testing.T) {
+	ctx, ctxC := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ctxC()
+
+	results := make(chan []error, 1)
+	s := New(ctx, func(ctx context.Context) error {
+		var errs []error
+		errs = append(errs, RunOneShot(ctx, "ok", func(ctx context.Context) error {
+			Signal(ctx, SignalHealthy)
+			return nil
+		}))
+		errs = append(errs, RunOneShot(ctx, "fail", func(ctx context.Context) error {
+			return fmt.Errorf("failed")
+		}))
+		errs = append(errs, RunOneShot(ctx, "panic", func(ctx context.Context) error {
+			panic("at the disco")
+		}))
+		results <- errs
+		Signal(ctx, SignalHealthy)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	var errs []error
+	select {
+	case errs = <-results:
+	case <-ctx.Done():
+		t.Fatalf("one-shot runnables did not finish")
+	}
+	if errs[0] != nil {
+		t.Errorf("ok: wanted no error, got %v", errs[0])
+	}
+	if errs[1] == nil || errs[1].Error() != "failed" {
+		t.Errorf("fail: wanted error %q, got %v", "failed", errs[1])
+	}
+	if errs[2] == nil || !strings.HasPrefix(errs[2].Error(), "panic: at the disco") {
+		t.Errorf("panic: wanted panic error, got %v", errs[2])
+	}
+
+	// None of the runnables should have been restarted, and the caller should
+	// not have been disturbed.
+	s.waitSettleError(ctx, t)
+	want := []RunnableStatus{
+		{DN: "root", State: "healthy"},
+		{DN: "root.fail", State: "done"},
+		{DN: "root.ok", State: "done"},
+		{DN: "root.panic", State: "done"},
+	}
+	if got := s.Tree(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tree: wanted %+v, got %+v", want, got)
+	}
+}
+
 // TestResilience throws some curveballs at the supervisor - either programming
 // errors or high load. It then ensures that another runnable is running, and
 // that it restarts on its sibling failure.
@@ -535,6 +779,268 @@ func TestResilience(t *testing.T) {
 	oneTest()
 }
 
+// TestStartLimit exercises limiting concurrent starts within a group. It starts
+// and repeatedly restarts a large group and ensures that the limit is never
+// exceeded and that runnables are started in order of priority.
+func TestStartLimit(t *testing.T) {
+	const (
+		count = 20
+		limit = 3
+	)
+
+	var mu sync.Mutex
+	// Number of runnables currently starting, and the maximum ever observed.
+	starting, maxStarting := 0, 0
+	// Start order of runnables, by index.
+	var order []int
+
+	worker := func(i int) Runnable {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			starting += 1
+			if starting > maxStarting {
+				maxStarting = starting
+			}
+			order = append(order, i)
+			mu.Unlock()
+
+			// Simulate some expensive startup work.
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			starting -= 1
+			mu.Unlock()
+			Signal(ctx, SignalHealthy)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	}
+	kill := make(chan struct{})
+	runnables := map[string]Runnable{
+		"trigger": func(ctx context.Context) error {
+			Signal(ctx, SignalHealthy)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-kill:
+				return fmt.Errorf("killed")
+			}
+		},
+	}
+	priorities := map[string]int{
+		"trigger": -1,
+	}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("w%d", i)
+		runnables[name] = worker(i)
+		priorities[name] = i
+	}
+
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+	s := New(ctx, func(ctx context.Context) error {
+		err := RunGroup(ctx, runnables, WithMaxConcurrentStarts(limit), WithStartPriorities(priorities))
+		if err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		Signal(ctx, SignalDone)
+		return nil
+	}, WithPropagatePanic)
+
+	// waitStarted waits until all workers have been started and returns their
+	// start order.
+	waitStarted := func() []int {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			mu.Lock()
+			if len(order) >= count {
+				res := order
+				order = nil
+				mu.Unlock()
+				return res
+			}
+			mu.Unlock()
+			if time.Now().After(deadline) {
+				t.Fatalf("workers did not start in time")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Workers are scheduled in order of priority initially, so they must also
+	// start in order of priority. As up to limit workers are started at the
+	// same time, the order within such a batch is not defined. A worker can
+	// however be started arbitrarily late relative to its batch if its
+	// goroutine does not get scheduled, so only check that no worker starts
+	// before workers of higher priority which were not part of its batch.
+	got := waitStarted()
+	for i, w := range got {
+		want := count - 1 - i
+		if w < want-(limit-1) {
+			t.Fatalf("initial start order: got %v, wanted descending priorities", got)
+		}
+	}
+
+	// Now restart the whole group a couple of times.
+	for i := 0; i < 3; i++ {
+		s.waitSettleError(ctx, t)
+		kill <- struct{}{}
+		if got := waitStarted(); len(got) != count {
+			t.Fatalf("round %d: %d workers restarted, wanted %d", i, len(got), count)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxStarting > limit {
+		t.Errorf("%d runnables were starting concurrently, wanted at most %d", maxStarting, limit)
+	}
+}
+
+// TestDependencies exercises startup dependencies within a group, ensuring
+// that dependent runnables only get started once their dependencies are
+// healthy, including after a restart of the group.
+func TestDependencies(t *testing.T) {
+	a := newRC()
+	started := make(chan struct{}, 10)
+
+	ctx, ctxC := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ctxC()
+	s := New(ctx, func(ctx context.Context) error {
+		err := RunGroup(ctx, map[string]Runnable{
+			"a": a.runnable(),
+			"b": func(ctx context.Context) error {
+				started <- struct{}{}
+				Signal(ctx, SignalHealthy)
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}, WithDependencies(map[string][]string{
+			"b": {"a"},
+		}))
+		if err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		Signal(ctx, SignalDone)
+		return nil
+	}, WithPropagatePanic)
+
+	for i := 0; i < 2; i++ {
+		s.waitSettleError(ctx, t)
+		select {
+		case <-started:
+			t.Fatalf("round %d: b started before a became healthy", i)
+		default:
+		}
+		a.becomeHealthy()
+		select {
+		case <-started:
+		case <-ctx.Done():
+			t.Fatalf("round %d: b did not start after a became healthy", i)
+		}
+		// Killing a restarts the whole group.
+		a.die()
+		a.waitState(rcRunnableStateNew)
+	}
+}
+
+// TestDependenciesInvalid ensures invalid dependencies are rejected.
+func TestDependenciesInvalid(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	for i, deps := range []map[string][]string{
+		{"d": {"a"}},
+		{"a": {"d"}},
+		{"a": {"a"}},
+		{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+	} {
+		if _, err := newGroup(names, []GroupOption{WithDependencies(deps)}); err == nil {
+			t.Errorf("%d: newGroup succeeded, wanted error", i)
+		}
+	}
+	deps := map[string][]string{"a": {"b", "c"}, "b": {"c"}}
+	if _, err := newGroup(names, []GroupOption{WithDependencies(deps)}); err != nil {
+		t.Errorf("newGroup with valid dependencies: %v", err)
+	}
+}
+
+// strictTB wraps a testing.TB to capture errors and logs reported by
+// TestHarnessStrict.
+type strictTB struct {
+	testing.TB
+
+	mu     sync.Mutex
+	errors []string
+	logs   []string
+	failed chan struct{}
+}
+
+func (s *strictTB) Errorf(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, fmt.Sprintf(format, args...))
+	close(s.failed)
+}
+
+func (s *strictTB) Logf(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs = append(s.logs, fmt.Sprintf(format, args...))
+}
+
+func TestHarnessStrictFailure(t *testing.T) {
+	tb := &strictTB{TB: t, failed: make(chan struct{})}
+	canceled := make(chan struct{})
+	TestHarnessStrict(tb, func(ctx context.Context) error {
+		err := RunGroup(ctx, map[string]Runnable{
+			"one": func(ctx context.Context) error {
+				Signal(ctx, SignalHealthy)
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			"two": func(ctx context.Context) error {
+				return fmt.Errorf("broken")
+			},
+		})
+		if err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	})
+
+	select {
+	case <-tb.failed:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("harness did not report failure")
+	}
+	// The tree must be canceled instead of restarting the failed runnable.
+	select {
+	case <-canceled:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("supervision tree not canceled")
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if len(tb.errors) != 1 {
+		t.Fatalf("expected one error, got %v", tb.errors)
+	}
+	if want := "Supervised runnable root.two returned error: broken"; tb.errors[0] != want {
+		t.Errorf("expected error %q, got %q", want, tb.errors[0])
+	}
+	logs := strings.Join(tb.logs, "\n")
+	for _, want := range []string{"- root.one (", "- root.two (dead, restarts: 0): broken"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("expected %q in tree snapshot, got %q", want, logs)
+		}
+	}
+}
+
 // TestSubLoggers exercises the reserved/sub-logger functionality of runnable
 // nodes. It ensures a sub-logger and runnable cannot have colliding names, and
 // that logging actually works.
@@ -616,6 +1122,144 @@ func TestSubLoggers(t *testing.T) {
 	}
 }
 
+// TestMetricsLabeler ensures that metrics created through Metrics and
+// SubMetrics are labeled with the DN of the runnable, and that sub-metrics
+// names cannot collide with runnables.
+func TestMetricsLabeler(t *testing.T) {
+	ctx, ctxC := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ctxC()
+
+	reg := prometheus.NewRegistry()
+	errC := make(chan error)
+	New(ctx, func(ctx context.Context) error {
+		err := Run(ctx, "foo", func(ctx context.Context) error {
+			Metrics(ctx).Counter(prometheus.CounterOpts{
+				Name: "test_events_total",
+			}).Add(2)
+			sm, err := SubMetrics(ctx, "dut")
+			if err != nil {
+				errC <- fmt.Errorf("creating sub-metrics: %w", err)
+				return nil
+			}
+			sm.Counter(prometheus.CounterOpts{
+				Name: "test_events_total",
+			}).Inc()
+			if err := Run(ctx, "dut", runnableBecomesHealthy(nil, nil)); err == nil {
+				errC <- fmt.Errorf("creating colliding runnable should have failed")
+				return nil
+			}
+			Signal(ctx, SignalHealthy)
+			Signal(ctx, SignalDone)
+			errC <- nil
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		Signal(ctx, SignalDone)
+		return nil
+	}, WithPropagatePanic, WithMetrics(reg))
+
+	if err := <-errC; err != nil {
+		t.Fatalf("from root.foo: %v", err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	got := make(map[string]float64)
+	for _, mf := range mfs {
+		if mf.GetName() != "test_events_total" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			for _, lp := range m.Label {
+				if lp.GetName() == "dn" {
+					got[lp.GetValue()] = m.Counter.GetValue()
+				}
+			}
+		}
+	}
+	want := map[string]float64{
+		"root.foo":     2,
+		"root.foo.dut": 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s := New(ctx, func(ctx context.Context) error {
+		err := RunGroup(ctx, map[string]Runnable{
+			// stuck ignores its context and only exits when released.
+			"stuck": func(ctx context.Context) error {
+				Signal(ctx, SignalHealthy)
+				close(started)
+				<-release
+				return nil
+			},
+			"drains": func(ctx context.Context) error {
+				Signal(ctx, SignalHealthy)
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+		if err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithPropagatePanic, WithShutdownTimeout(100*time.Millisecond))
+
+	<-started
+	ctxC()
+
+	stuckC := make(chan []string)
+	go func() {
+		stuckC <- s.Wait()
+	}()
+	select {
+	case stuck := <-stuckC:
+		if want := []string{"root.stuck"}; !reflect.DeepEqual(stuck, want) {
+			t.Errorf("wanted stuck runnables %v, got %v", want, stuck)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Wait did not return within shutdown timeout")
+	}
+}
+
+func TestShutdownClean(t *testing.T) {
+	ctx, ctxC := context.WithCancel(context.Background())
+	defer ctxC()
+
+	started := make(chan struct{})
+	s := New(ctx, func(ctx context.Context) error {
+		if err := Run(ctx, "drains", runnableBecomesHealthy(nil, nil)); err != nil {
+			return err
+		}
+		Signal(ctx, SignalHealthy)
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithPropagatePanic, WithShutdownTimeout(10*time.Second))
+
+	<-started
+	ctxC()
+	if stuck := s.Wait(); len(stuck) != 0 {
+		t.Errorf("wanted no stuck runnables, got %v", stuck)
+	}
+}
+
 func ExampleNew() {
 	// Minimal runnable that is immediately done.
 	childC := make(chan struct{})
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"log"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -45,7 +46,26 @@ import (
 // log-related functionality.
 func TestHarness(t testing.TB, r func(ctx context.Context) error) (context.CancelFunc, *logtree.LogTree) {
 	t.Helper()
+	return testHarness(t, r, false)
+}
+
+// TestHarnessStrict is like TestHarness, but aborts on the first error
+// returned by any runnable in the supervision tree (not just the given root
+// runnable) instead of letting the supervisor restart it.
+//
+// When that happens, a snapshot of the supervision tree is logged, showing the
+// state of the failing runnable and all other runnables at the moment of
+// failure. Then, the test is marked as failed and the entire supervision tree
+// is canceled, so that restarts do not obscure the original failure. As
+// t.FailNow may only be called from the goroutine running the test, the
+// supervision tree is canceled instead of calling it: tests should not block
+// on runnables beyond their context being canceled.
+func TestHarnessStrict(t testing.TB, r func(ctx context.Context) error) (context.CancelFunc, *logtree.LogTree) {
+	t.Helper()
+	return testHarness(t, r, true)
+}
 
+func testHarness(t testing.TB, r func(ctx context.Context) error, strict bool) (context.CancelFunc, *logtree.LogTree) {
 	ctx, ctxC := context.WithCancel(context.Background())
 
 	lt := logtree.New()
@@ -60,14 +80,36 @@ func TestHarness(t testing.TB, r func(ctx context.Context) error) (context.Cance
 		logtree.PipeAllToTest(t, lt)
 	}
 
+	opts := []SupervisorOpt{WithExistingLogtree(lt), WithPropagatePanic}
+	if strict {
+		var once sync.Once
+		opts = append(opts, withOnDeath(func(dn string, err error, tree []RunnableStatus) {
+			once.Do(func() {
+				t.Logf("supervisor.TestHarnessStrict: supervision tree at time of failure:")
+				for _, n := range tree {
+					if n.LastError != "" {
+						t.Logf("supervisor.TestHarnessStrict: - %s (%s, restarts: %d): %s", n.DN, n.State, n.Restarts, n.LastError)
+					} else {
+						t.Logf("supervisor.TestHarnessStrict: - %s (%s, restarts: %d)", n.DN, n.State, n.Restarts)
+					}
+				}
+				t.Errorf("Supervised runnable %s returned error: %v", dn, err)
+				ctxC()
+			})
+		}))
+	}
+
 	sup := New(ctx, func(ctx context.Context) error {
 		Logger(ctx).Infof("Starting test %s...", t.Name())
 		if err := r(ctx); err != nil && !errors.Is(err, ctx.Err()) {
-			t.Errorf("Supervised runnable in harness returned error: %v", err)
+			// In strict mode, this is reported by the death hook.
+			if !strict {
+				t.Errorf("Supervised runnable in harness returned error: %v", err)
+			}
 			return err
 		}
 		return nil
-	}, WithExistingLogtree(lt), WithPropagatePanic)
+	}, opts...)
 
 	t.Cleanup(func() {
 		ctxC()
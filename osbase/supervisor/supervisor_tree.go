@@ -0,0 +1,92 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package supervisor
+
+import (
+	"sort"
+)
+
+// RunnableStatus is a snapshot of the state of a single runnable within the
+// supervision tree, as returned by Tree. It is suitable for serialization to
+// JSON, eg. for debug endpoints.
+type RunnableStatus struct {
+	// DN is the distinguished name of the runnable, eg. root.foo.bar.
+	DN string `json:"dn"`
+	// State is the lifecycle state of the runnable: one of new, healthy, done,
+	// dead or canceled.
+	State string `json:"state"`
+	// Restarts is the number of times the runnable has been restarted by the
+	// supervisor since its parent was last (re)started.
+	Restarts int `json:"restarts"`
+	// LastError is the error with which the runnable last died, if any.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Tree returns a snapshot of all runnables in the supervision tree, sorted by
+// DN. The snapshot is taken atomically with respect to the supervisor's
+// processor, ie. it always represents a consistent state of the tree.
+func (s *supervisor) Tree() []RunnableStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree()
+}
+
+// tree implements Tree. The supervisor lock must be held.
+func (s *supervisor) tree() []RunnableStatus {
+	var res []RunnableStatus
+	q := []*node{s.root}
+	for len(q) > 0 {
+		el := q[0]
+		q = q[1:]
+
+		st := RunnableStatus{
+			DN:       el.dn(),
+			State:    el.state.short(),
+			Restarts: el.restarts,
+		}
+		if el.lastErr != nil {
+			st.LastError = el.lastErr.Error()
+		}
+		res = append(res, st)
+
+		for _, child := range el.children {
+			q = append(q, child)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].DN < res[j].DN
+	})
+	return res
+}
+
+// short returns a short, lowercase name of the state, as used in
+// RunnableStatus.
+func (s nodeState) short() string {
+	switch s {
+	case nodeStateNew:
+		return "new"
+	case nodeStateHealthy:
+		return "healthy"
+	case nodeStateDead:
+		return "dead"
+	case nodeStateDone:
+		return "done"
+	case nodeStateCanceled:
+		return "canceled"
+	}
+	return "unknown"
+}
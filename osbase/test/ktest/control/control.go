@@ -0,0 +1,75 @@
+// Copyright 2020 The Monogon Project Authors.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package control implements the protocol used by ktestinit to pass results
+// out of the VM to ktest over the control port.
+//
+// The first byte sent is always the exit code of the tester or ExitCodeTimeout.
+// It is followed by
+// zero or more frames, each consisting of a type byte, a little-endian uint32
+// payload length and the payload. The last frame is always of type FrameEnd.
+package control
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ExitCodeTimeout is sent instead of the exit code of the tester if it did not
+// exit before the watchdog deadline and was killed.
+const ExitCodeTimeout uint8 = 254
+
+// FrameType identifies the contents of a frame.
+type FrameType uint8
+
+const (
+	// FrameEnd marks the end of the stream. It has no payload.
+	FrameEnd FrameType = 0
+	// FrameCoverage contains a tar archive of the Go coverage data directory
+	// (GOCOVERDIR) of the tester.
+	FrameCoverage FrameType = 1
+)
+
+// WriteFrame writes a frame of the given type containing payload to w.
+func WriteFrame(w io.Writer, t FrameType, payload []byte) error {
+	if int64(len(payload)) > math.MaxUint32 {
+		return errors.New("payload too big")
+	}
+	hdr := make([]byte, 5)
+	hdr[0] = uint8(t)
+	binary.LittleEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadFrame reads a single frame from r.
+func ReadFrame(r io.Reader) (FrameType, []byte, error) {
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, nil, fmt.Errorf("failed to read frame header: %w", err)
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(hdr[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("failed to read frame payload: %w", err)
+	}
+	return FrameType(hdr[0]), payload, nil
+}
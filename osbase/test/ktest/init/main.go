@@ -17,17 +17,24 @@
 // ktestinit is an init designed to run inside a lightweight VM for running
 // tests in there.  It performs basic platform initialization like mounting
 // kernel filesystems and launches the test executable at /tester, passes the
-// exit code back out over the control socket to ktest and then terminates the
-// default VM kernel.
+// exit code and coverage data back out over the control socket to ktest (see
+// the control package) and then terminates the default VM kernel.
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"golang.org/x/sys/unix"
+
+	"source.monogon.dev/osbase/test/ktest/control"
 )
 
 func mountInit() error {
@@ -53,6 +60,160 @@ func mountInit() error {
 	return nil
 }
 
+// testFlags are the flags of the Go testing package which can be passed to the
+// tester on the kernel command line as ktest.<flag>=<value>, for example
+// ktest.run=TestFoo/bar.
+var testFlags = map[string]bool{
+	"bench":     true,
+	"benchtime": true,
+	"count":     true,
+	"cpu":       true,
+	"failfast":  true,
+	"list":      true,
+	"parallel":  true,
+	"run":       true,
+	"short":     true,
+	"shuffle":   true,
+	"skip":      true,
+	"timeout":   true,
+}
+
+// splitCmdline splits a kernel command line into parameters. Like the kernel,
+// it allows double quotes around values containing spaces.
+func splitCmdline(cmdline string) []string {
+	var params []string
+	var cur strings.Builder
+	inQuote := false
+	for _, c := range strings.TrimSpace(cmdline) {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+		case !inQuote && (c == ' ' || c == '\t' || c == '\n'):
+			if cur.Len() > 0 {
+				params = append(params, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	if cur.Len() > 0 {
+		params = append(params, cur.String())
+	}
+	return params
+}
+
+// testerArgs returns the arguments for the tester based on the kernel command
+// line. Parameters which are not test flags are ignored.
+func testerArgs(cmdline string) []string {
+	args := []string{"-test.v"}
+	for _, param := range splitCmdline(cmdline) {
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(param, "ktest."), "=")
+		if !strings.HasPrefix(param, "ktest.") || !testFlags[name] {
+			continue
+		}
+		if hasValue {
+			args = append(args, fmt.Sprintf("-test.%s=%s", name, value))
+		} else {
+			args = append(args, "-test."+name)
+		}
+	}
+	return args
+}
+
+// watchdogDeadline returns the duration after which the tester is considered
+// hung, as configured by the ktest.watchdog kernel parameter, or zero if the
+// watchdog is disabled.
+func watchdogDeadline(cmdline string) time.Duration {
+	for _, param := range splitCmdline(cmdline) {
+		value, ok := strings.CutPrefix(param, "ktest.watchdog=")
+		if !ok {
+			continue
+		}
+		deadline, err := time.ParseDuration(value)
+		if err != nil {
+			fmt.Printf("Ignoring invalid watchdog deadline: %v\n", err)
+			return 0
+		}
+		return deadline
+	}
+	return 0
+}
+
+// runTester runs the tester and returns its exit code. If deadline is not
+// zero and the tester does not exit within it, a goroutine dump of the tester
+// is triggered using SIGQUIT and control.ExitCodeTimeout is returned.
+func runTester(cmd *exec.Cmd, deadline time.Duration) (uint8, error) {
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var timeout <-chan time.Time
+	if deadline != 0 {
+		timeout = time.After(deadline)
+	}
+	select {
+	case err := <-done:
+		var exerr *exec.ExitError
+		if errors.As(err, &exerr) {
+			return uint8(exerr.ExitCode()), nil
+		}
+		return 0, err
+	case <-timeout:
+	}
+	fmt.Printf("Tester did not exit within %v, dumping goroutines\n", deadline)
+	if err := cmd.Process.Signal(unix.SIGQUIT); err != nil {
+		fmt.Printf("Failed to send SIGQUIT to tester: %v\n", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+	}
+	return control.ExitCodeTimeout, nil
+}
+
+// coverDir is the directory in which the tester writes coverage data, if it
+// has been built with coverage instrumentation.
+const coverDir = "/tmp/cover"
+
+// tarDirectory returns a tar archive of all regular files in dir or nil if
+// there are none.
+func tarDirectory(dir string) ([]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name: e.Name(),
+			Mode: 0644,
+			Size: int64(len(data)),
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func main() {
 	if err := mountInit(); err != nil {
 		panic(err)
@@ -64,20 +225,37 @@ func main() {
 		fmt.Printf("Failed to open communication device: %v\n", err)
 		return
 	}
-	cmd := exec.Command("/tester", "-test.v")
+	cmdline, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		fmt.Printf("Failed to read kernel command line: %v\n", err)
+	}
+	if err := os.Mkdir(coverDir, 0755); err != nil {
+		fmt.Printf("Failed to create coverage directory: %v\n", err)
+	}
+	cmd := exec.Command("/tester", testerArgs(string(cmdline))...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
-	cmd.Env = append(cmd.Env, "IN_KTEST=true")
-	if err := cmd.Run(); err != nil {
-		var exerr *exec.ExitError
-		if errors.As(err, &exerr) {
-			if _, err := ioConn.Write([]byte{uint8(exerr.ExitCode())}); err != nil {
-				panic(err)
-			}
-		}
+	cmd.Env = append(cmd.Env, "IN_KTEST=true", "GOCOVERDIR="+coverDir)
+	exitCode, err := runTester(cmd, watchdogDeadline(string(cmdline)))
+	if err != nil {
 		fmt.Printf("Failed to execute tests (tests didn't run): %v", err)
-	} else {
-		ioConn.Write([]byte{0})
+		ioConn.Close()
+		unix.Reboot(unix.LINUX_REBOOT_CMD_RESTART)
+		return
+	}
+	if _, err := ioConn.Write([]byte{exitCode}); err != nil {
+		panic(err)
+	}
+	coverage, err := tarDirectory(coverDir)
+	if err != nil {
+		fmt.Printf("Failed to collect coverage data: %v\n", err)
+	} else if coverage != nil {
+		if err := control.WriteFrame(ioConn, control.FrameCoverage, coverage); err != nil {
+			fmt.Printf("Failed to send coverage data: %v\n", err)
+		}
+	}
+	if err := control.WriteFrame(ioConn, control.FrameEnd, nil); err != nil {
+		fmt.Printf("Failed to send end of stream: %v\n", err)
 	}
 	ioConn.Close()
 
@@ -19,13 +19,19 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"source.monogon.dev/osbase/test/ktest/control"
 	"source.monogon.dev/osbase/test/launch"
 )
 
@@ -33,9 +39,85 @@ var (
 	kernelPath = flag.String("kernel-path", "", "Path of the Kernel ELF file")
 	initrdPath = flag.String("initrd-path", "", "Path of the initrd image")
 	cmdline    = flag.String("cmdline", "", "Additional kernel command line options")
+	coverDir   = flag.String("cover-dir", os.Getenv("GOCOVERDIR"), "Directory to write coverage data of the tester to, if any")
 )
 
+// testCmdline converts Go test flags (e.g. -test.run=TestFoo) into kernel
+// command line parameters which are picked up by ktestinit and passed on to
+// the tester.
+func testCmdline(args []string) string {
+	var params []string
+	for _, arg := range args {
+		name, ok := strings.CutPrefix(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "test.")
+		if !ok {
+			log.Fatalf("Unsupported argument %q, only test flags (-test.*) can be passed to the tester", arg)
+		}
+		if key, value, hasValue := strings.Cut(name, "="); hasValue && strings.ContainsAny(value, " \t") {
+			name = key + "=\"" + value + "\""
+		}
+		params = append(params, "ktest."+name)
+	}
+	return strings.Join(params, " ")
+}
+
+// readFrames processes the frames sent by ktestinit after the exit code until
+// the end of the stream.
+func readFrames(r io.Reader) error {
+	for {
+		t, payload, err := control.ReadFrame(r)
+		if err != nil {
+			return err
+		}
+		switch t {
+		case control.FrameEnd:
+			return nil
+		case control.FrameCoverage:
+			if *coverDir == "" {
+				continue
+			}
+			if err := extractTar(payload, *coverDir); err != nil {
+				return fmt.Errorf("failed to extract coverage data: %w", err)
+			}
+		default:
+			log.Printf("Ignoring unknown frame type %d", t)
+		}
+	}
+}
+
+// extractTar writes all regular files in the given tar archive to dir.
+func extractTar(archive []byte, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || filepath.Base(hdr.Name) != hdr.Name {
+			return fmt.Errorf("unexpected entry %q", hdr.Name)
+		}
+		f, err := os.Create(filepath.Join(dir, hdr.Name))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [test flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	hostFeedbackConn, vmFeedbackConn, err := launch.NewSocketPair()
@@ -52,6 +134,9 @@ func main() {
 		if _, err := io.ReadFull(hostFeedbackConn, returnCode); err != nil {
 			log.Fatalf("Failed to read socket: %v", err)
 		}
+		if err := readFrames(hostFeedbackConn); err != nil {
+			log.Printf("Failed to read results: %v", err)
+		}
 		exitCodeChan <- returnCode[0]
 	}()
 
@@ -59,7 +144,7 @@ func main() {
 		Name:                        "ktest",
 		KernelPath:                  *kernelPath,
 		InitramfsPath:               *initrdPath,
-		Cmdline:                     *cmdline,
+		Cmdline:                     *cmdline + " " + testCmdline(flag.Args()),
 		SerialPort:                  os.Stdout,
 		ExtraChardevs:               []*os.File{vmFeedbackConn},
 		DisableHostNetworkInterface: true,
@@ -69,6 +154,9 @@ func main() {
 
 	select {
 	case exitCode := <-exitCodeChan:
+		if exitCode == control.ExitCodeTimeout {
+			log.Fatal("Test timed out (tester did not exit before the watchdog deadline)")
+		}
 		os.Exit(int(exitCode))
 	case <-time.After(1 * time.Second):
 		log.Fatal("Failed to get an error code back (test runtime probably crashed)")
@@ -1,2 +1,3 @@
 #!/bin/bash
-exec "$1" -initrd-path "$2" -kernel-path "$3" -cmdline "$4"
\ No newline at end of file
+# Additional arguments (e.g. from --test_arg) are test flags for the tester.
+exec "$1" -initrd-path "$2" -kernel-path "$3" -cmdline "$4" "${@:5}"
\ No newline at end of file
@@ -114,6 +114,11 @@ var (
 	// ErrNotInitialized is returned when this package was not initialized
 	// successfully
 	ErrNotInitialized = errors.New("no TPM was initialized")
+	// ErrPCRMismatch is returned by Unseal when the current PCR values do not
+	// match the ones the data was sealed against, for example because the
+	// measured boot chain has changed. Callers can use it to fall back to
+	// other means of unlocking.
+	ErrPCRMismatch = errors.New("current PCR values do not match sealing policy")
 )
 
 // Singleton since the TPM is too
@@ -228,9 +233,18 @@ func GenerateSafeKey(size uint16) ([]byte, error) {
 	return encryptionKey, nil
 }
 
-// Seal seals sensitive data and only allows access if the current platform
-// configuration in matches the one the data was sealed on.
-func Seal(data []byte, pcrs []int) ([]byte, error) {
+// SealToPCRs seals sensitive data and binds it to the current values of the
+// given PCRs. Unseal only releases the data if these PCRs still have the same
+// values.
+func SealToPCRs(data []byte, pcrs []int) ([]byte, error) {
+	if len(pcrs) == 0 {
+		return nil, errors.New("at least one PCR must be given")
+	}
+	for _, pcr := range pcrs {
+		if pcr < 0 || pcr >= numSRTMPCRs {
+			return nil, fmt.Errorf("invalid PCR %d, must be in [0, %d)", pcr, numSRTMPCRs)
+		}
+	}
 	// Generate a key and use secretbox to encrypt and authenticate the actual
 	// payload as go-tpm2 uses a raw seal operation limiting payload size to
 	// 128 bytes which is insufficient.
@@ -267,7 +281,8 @@ func Seal(data []byte, pcrs []int) ([]byte, error) {
 }
 
 // Unseal unseals sensitive data if the current platform configuration allows
-// and sealing constraints allow it.
+// and sealing constraints allow it. If the PCR values the data was sealed
+// against do not match the current ones, ErrPCRMismatch is returned.
 func Unseal(data []byte) ([]byte, error) {
 	lock.Lock()
 	defer lock.Unlock()
@@ -295,6 +310,12 @@ func Unseal(data []byte) ([]byte, error) {
 	tpm.logger.Infof("Attempting to unseal key protected with PCRs %s", strings.Join(pcrList, ","))
 	unsealedKey, err := srk.Unseal(sealedBytes.SealedKey, tpm2tools.UnsealOpts{})
 	if err != nil {
+		// The TPM rejects the policy session if the PCR values differ from
+		// the ones in the sealing policy.
+		var sessErr tpm2.SessionError
+		if errors.As(err, &sessErr) && sessErr.Code == tpm2.RCPolicyFail {
+			return nil, fmt.Errorf("%w: PCRs %s", ErrPCRMismatch, strings.Join(pcrList, ","))
+		}
 		return nil, fmt.Errorf("failed to unseal key: %w", err)
 	}
 	var key [32]byte